@@ -6,4 +6,67 @@ type AgentMetadata struct {
 	// ClusterID is the name of the cluster or host where the agent is running.
 	// It may send data for other clusters in its datareadings.
 	ClusterID string `json:"cluster_id"`
+	// PreviousCrash is set if the previous run of the agent did not
+	// terminate cleanly, until data has been sent successfully.
+	PreviousCrash *CrashReport `json:"previous_crash,omitempty"`
+	// ResourceUsage is the usage of resources by the agent, sampled at the
+	// start of the current cycle.
+	ResourceUsage *ResourceUsage `json:"resource_usage,omitempty"`
+	// Cluster is the metadata of the cluster where the agent is running, if
+	// the agent is configured to send it.
+	Cluster *ClusterMetadata `json:"cluster,omitempty"`
+}
+
+// ClusterMetadata describes the cluster where the agent is running.
+type ClusterMetadata struct {
+	// UID is the UID of the kube-system namespace, which identifies the
+	// cluster independently of the name it is given in the agent config.
+	UID string `json:"uid"`
+	// KubernetesVersion is the version of the API server, e.g. v1.29.2.
+	KubernetesVersion string `json:"kubernetes_version"`
+	// CloudProvider is the provider of the nodes, e.g. aws, gce or azure,
+	// detected from their provider IDs. It is empty if the nodes have no
+	// provider ID, and mixed if they have several providers.
+	CloudProvider string `json:"cloud_provider,omitempty"`
+	// NodeCount is the number of nodes.
+	NodeCount int `json:"node_count"`
+	// Labels are the identity labels of the agent.
+	Labels map[string]string `json:"labels,omitempty"`
+	// GatheredAt is when the metadata was gathered.
+	GatheredAt Time `json:"gathered_at"`
+}
+
+// CrashReport describes a previous run of the agent that did not terminate
+// cleanly.
+type CrashReport struct {
+	// PID is the process ID of the previous run.
+	PID int `json:"pid"`
+	// StartedAt is when the previous run started.
+	StartedAt Time `json:"started_at"`
+	// Cycle is the number of data gathering cycles the previous run started.
+	Cycle int `json:"cycle"`
+	// Phase is what the previous run was doing when it was last recorded,
+	// one of "starting", "gathering" or "waiting".
+	Phase string `json:"phase"`
+	// LastUpdate is when the state of the previous run was last recorded.
+	LastUpdate Time `json:"last_update"`
+	// Crashes is the number of unclean terminations since the state file
+	// was created, including this one.
+	Crashes int `json:"crashes"`
+}
+
+// ResourceUsage is the usage of resources by the agent process.
+type ResourceUsage struct {
+	// SampledAt is when the usage was sampled.
+	SampledAt Time `json:"sampled_at"`
+	// CPUSeconds is the CPU time used since the agent started.
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// CycleCPUSeconds is the CPU time used since the previous sample.
+	CycleCPUSeconds float64 `json:"cycle_cpu_seconds"`
+	// MemoryBytes is the resident memory of the agent.
+	MemoryBytes uint64 `json:"memory_bytes"`
+	// Goroutines is the number of goroutines.
+	Goroutines int `json:"goroutines"`
+	// ExceededLimits are the configured resource limits the usage exceeds.
+	ExceededLimits []string `json:"exceeded_limits,omitempty"`
 }
@@ -5,8 +5,23 @@ import (
 	"time"
 )
 
+const (
+	// SchemaVersion is the latest version of the schema of the payloads and
+	// the readings, see the SchemaVersions of the client package.
+	SchemaVersion = "v2.1.0"
+	// DefaultDataVersion is the version of the format of the data of the
+	// readings, unless their data gatherer has a version of its own.
+	DefaultDataVersion = "v1"
+	// SummaryDataVersion is the version of the format of the summaries
+	// uploaded instead of the data in the findings report mode.
+	SummaryDataVersion = "summary/v1"
+)
+
 // DataReadingsPost is the payload in the upload request.
 type DataReadingsPost struct {
+	// SchemaVersion is the version of the schema of the payload, empty for
+	// the payloads of v2.0.0, which weren't versioned.
+	SchemaVersion string         `json:"schema_version,omitempty"`
 	AgentMetadata *AgentMetadata `json:"agent_metadata"`
 	// DataGatherTime represents the time that the data readings were gathered
 	DataGatherTime time.Time      `json:"data_gather_time"`
@@ -22,6 +37,19 @@ type DataReading struct {
 	Timestamp     Time        `json:"timestamp"`
 	Data          interface{} `json:"data"`
 	SchemaVersion string      `json:"schema_version"`
+	// DataVersion is the version of the format of the data, so that the
+	// format of the data of a data gatherer can change without breaking the
+	// ingestion of the readings of older agents.
+	DataVersion string `json:"data_version,omitempty"`
+	// Findings are the problems detected by the data gatherer, if it
+	// analyses the data it gathers.
+	Findings []Finding `json:"findings,omitempty"`
+	// PolicyResults are the results of the policy rules evaluated by the
+	// agent on the resources of the reading.
+	PolicyResults []PolicyResult `json:"policy_results,omitempty"`
+	// Labels are the identity labels of the agent, e.g. the team or
+	// environment the cluster belongs to.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // GatheredResource wraps the raw k8s resource that is sent to the jetstack secure backend
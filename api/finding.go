@@ -0,0 +1,56 @@
+package api
+
+// Severity is how urgently a finding should be addressed.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// Severities are the severities, from the least to the most urgent.
+var Severities = []Severity{SeverityInfo, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical}
+
+// Rank returns the position of the severity in Severities, -1 if it isn't a
+// known severity.
+func (s Severity) Rank() int {
+	for i, severity := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Finding is a problem detected by the analysis of the gathered data. All
+// the data gatherers that analyse data report their findings in this shape,
+// and the agent sends them in the Findings section of their reading.
+type Finding struct {
+	// RuleID identifies the check that reported the finding, e.g. weak-key.
+	RuleID   string      `json:"rule_id"`
+	Severity Severity    `json:"severity"`
+	Resource ResourceRef `json:"resource"`
+	Message  string      `json:"message"`
+	// Remediation is a hint of how to address the finding.
+	Remediation string `json:"remediation,omitempty"`
+}
+
+// ResourceRef identifies the Kubernetes object a finding is about.
+type ResourceRef struct {
+	Kind string `json:"kind"`
+	// Namespace is empty for cluster scoped objects.
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+// String returns the kind, namespace and name of the object, e.g.
+// Secret/default/my-tls.
+func (r ResourceRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + "/" + r.Name
+	}
+	return r.Kind + "/" + r.Namespace + "/" + r.Name
+}
@@ -0,0 +1,33 @@
+package api
+
+import "encoding/json"
+
+// PluginProtocolVersion is the version of the protocol between the agent and
+// the plugin data gatherers. It changes only if the request or the response
+// change incompatibly.
+const PluginProtocolVersion = "v1"
+
+// PluginRequest is written by the agent to the stdin of a plugin on every
+// gathering.
+type PluginRequest struct {
+	// ProtocolVersion is the version of the protocol the agent speaks.
+	ProtocolVersion string `json:"protocolVersion"`
+	// Config is the config of the plugin in the agent config, as is.
+	Config json.RawMessage `json:"config,omitempty"`
+}
+
+// PluginResponse is written by a plugin to its stdout in response to a
+// PluginRequest.
+type PluginResponse struct {
+	// ProtocolVersion is the version of the protocol the plugin speaks,
+	// which must be that of the agent.
+	ProtocolVersion string `json:"protocolVersion"`
+	// Data is the gathered data.
+	Data json.RawMessage `json:"data,omitempty"`
+	// Count is the number of items gathered, if the plugin counts them.
+	Count *int `json:"count,omitempty"`
+	// Findings are the problems detected by the plugin.
+	Findings []Finding `json:"findings,omitempty"`
+	// Error fails the gathering, if set.
+	Error string `json:"error,omitempty"`
+}
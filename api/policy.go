@@ -0,0 +1,13 @@
+package api
+
+// PolicyResult is the result of a policy rule evaluated by the agent on a
+// gathered resource.
+type PolicyResult struct {
+	// RuleID is the id of the rule in the agent config.
+	RuleID   string      `json:"rule_id"`
+	Resource ResourceRef `json:"resource"`
+	Passed   bool        `json:"passed"`
+	// Error is set if the rule could not be evaluated, e.g. because a field
+	// it reads is missing. Passed is false then, but no finding is reported.
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,547 @@
+// The gRPC protocol of the uploads of the agent, used with `backend: grpc`.
+// The data of the readings is arbitrary, so it is sent JSON encoded, as in
+// the JSON payloads, while the rest of the readings is encoded in protobuf.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0
+// 	protoc        v3.5.1-go
+// source: api/upload/v1/upload.proto
+
+package uploadv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type UploadDataReadingsRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Payload:
+	//	*UploadDataReadingsRequest_Header
+	//	*UploadDataReadingsRequest_Reading
+	Payload isUploadDataReadingsRequest_Payload `protobuf_oneof:"payload"`
+}
+
+func (x *UploadDataReadingsRequest) Reset() {
+	*x = UploadDataReadingsRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_upload_v1_upload_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadDataReadingsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadDataReadingsRequest) ProtoMessage() {}
+
+func (x *UploadDataReadingsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_api_upload_v1_upload_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadDataReadingsRequest.ProtoReflect.Descriptor instead.
+func (*UploadDataReadingsRequest) Descriptor() ([]byte, []int) {
+	return file_api_upload_v1_upload_proto_rawDescGZIP(), []int{0}
+}
+
+func (m *UploadDataReadingsRequest) GetPayload() isUploadDataReadingsRequest_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *UploadDataReadingsRequest) GetHeader() *UploadHeader {
+	if x, ok := x.GetPayload().(*UploadDataReadingsRequest_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *UploadDataReadingsRequest) GetReading() *DataReading {
+	if x, ok := x.GetPayload().(*UploadDataReadingsRequest_Reading); ok {
+		return x.Reading
+	}
+	return nil
+}
+
+type isUploadDataReadingsRequest_Payload interface {
+	isUploadDataReadingsRequest_Payload()
+}
+
+type UploadDataReadingsRequest_Header struct {
+	// Header is the first message of the stream.
+	Header *UploadHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type UploadDataReadingsRequest_Reading struct {
+	Reading *DataReading `protobuf:"bytes,2,opt,name=reading,proto3,oneof"`
+}
+
+func (*UploadDataReadingsRequest_Header) isUploadDataReadingsRequest_Payload() {}
+
+func (*UploadDataReadingsRequest_Reading) isUploadDataReadingsRequest_Payload() {}
+
+type UploadHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	OrganizationId string `protobuf:"bytes,1,opt,name=organization_id,json=organizationId,proto3" json:"organization_id,omitempty"`
+	ClusterId      string `protobuf:"bytes,2,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
+	// agent_metadata is the JSON encoded metadata of the agent.
+	AgentMetadata  []byte                 `protobuf:"bytes,3,opt,name=agent_metadata,json=agentMetadata,proto3" json:"agent_metadata,omitempty"`
+	DataGatherTime *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=data_gather_time,json=dataGatherTime,proto3" json:"data_gather_time,omitempty"`
+	// schema_version is the version of the schema of the upload, empty for
+	// v2.0.0.
+	SchemaVersion string `protobuf:"bytes,5,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+}
+
+func (x *UploadHeader) Reset() {
+	*x = UploadHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_upload_v1_upload_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadHeader) ProtoMessage() {}
+
+func (x *UploadHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_api_upload_v1_upload_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadHeader.ProtoReflect.Descriptor instead.
+func (*UploadHeader) Descriptor() ([]byte, []int) {
+	return file_api_upload_v1_upload_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *UploadHeader) GetOrganizationId() string {
+	if x != nil {
+		return x.OrganizationId
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *UploadHeader) GetAgentMetadata() []byte {
+	if x != nil {
+		return x.AgentMetadata
+	}
+	return nil
+}
+
+func (x *UploadHeader) GetDataGatherTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DataGatherTime
+	}
+	return nil
+}
+
+func (x *UploadHeader) GetSchemaVersion() string {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return ""
+}
+
+type DataReading struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ClusterId    string                 `protobuf:"bytes,1,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
+	DataGatherer string                 `protobuf:"bytes,2,opt,name=data_gatherer,json=dataGatherer,proto3" json:"data_gatherer,omitempty"`
+	Timestamp    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	// data is the JSON encoded data of the reading.
+	Data          []byte `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	SchemaVersion string `protobuf:"bytes,5,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// findings is the JSON encoded array of the findings of the reading.
+	Findings []byte `protobuf:"bytes,6,opt,name=findings,proto3" json:"findings,omitempty"`
+	// policy_results is the JSON encoded array of the policy results of the
+	// reading.
+	PolicyResults []byte            `protobuf:"bytes,7,opt,name=policy_results,json=policyResults,proto3" json:"policy_results,omitempty"`
+	Labels        map[string]string `protobuf:"bytes,8,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// data_version is the version of the format of the data.
+	DataVersion string `protobuf:"bytes,9,opt,name=data_version,json=dataVersion,proto3" json:"data_version,omitempty"`
+}
+
+func (x *DataReading) Reset() {
+	*x = DataReading{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_upload_v1_upload_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DataReading) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DataReading) ProtoMessage() {}
+
+func (x *DataReading) ProtoReflect() protoreflect.Message {
+	mi := &file_api_upload_v1_upload_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DataReading.ProtoReflect.Descriptor instead.
+func (*DataReading) Descriptor() ([]byte, []int) {
+	return file_api_upload_v1_upload_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DataReading) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *DataReading) GetDataGatherer() string {
+	if x != nil {
+		return x.DataGatherer
+	}
+	return ""
+}
+
+func (x *DataReading) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *DataReading) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *DataReading) GetSchemaVersion() string {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return ""
+}
+
+func (x *DataReading) GetFindings() []byte {
+	if x != nil {
+		return x.Findings
+	}
+	return nil
+}
+
+func (x *DataReading) GetPolicyResults() []byte {
+	if x != nil {
+		return x.PolicyResults
+	}
+	return nil
+}
+
+func (x *DataReading) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *DataReading) GetDataVersion() string {
+	if x != nil {
+		return x.DataVersion
+	}
+	return ""
+}
+
+type UploadDataReadingsResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// readings is the number of readings received.
+	Readings int64 `protobuf:"varint,1,opt,name=readings,proto3" json:"readings,omitempty"`
+}
+
+func (x *UploadDataReadingsResponse) Reset() {
+	*x = UploadDataReadingsResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_api_upload_v1_upload_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *UploadDataReadingsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadDataReadingsResponse) ProtoMessage() {}
+
+func (x *UploadDataReadingsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_api_upload_v1_upload_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadDataReadingsResponse.ProtoReflect.Descriptor instead.
+func (*UploadDataReadingsResponse) Descriptor() ([]byte, []int) {
+	return file_api_upload_v1_upload_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *UploadDataReadingsResponse) GetReadings() int64 {
+	if x != nil {
+		return x.Readings
+	}
+	return 0
+}
+
+var File_api_upload_v1_upload_proto protoreflect.FileDescriptor
+
+var file_api_upload_v1_upload_proto_rawDesc = []byte{
+	0x0a, 0x1a, 0x61, 0x70, 0x69, 0x2f, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2f, 0x76, 0x31, 0x2f,
+	0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x13, 0x70, 0x72,
+	0x65, 0x66, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x76,
+	0x31, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0xa1, 0x01, 0x0a, 0x19, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x44, 0x61, 0x74,
+	0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x3b, 0x0a, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x21, 0x2e, 0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x2e, 0x75, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x48, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x48, 0x00, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x3c, 0x0a,
+	0x07, 0x72, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x20,
+	0x2e, 0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67,
+	0x48, 0x00, 0x52, 0x07, 0x72, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x42, 0x09, 0x0a, 0x07, 0x70,
+	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x22, 0xea, 0x01, 0x0a, 0x0c, 0x55, 0x70, 0x6c, 0x6f, 0x61,
+	0x64, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x27, 0x0a, 0x0f, 0x6f, 0x72, 0x67, 0x61, 0x6e,
+	0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0e, 0x6f, 0x72, 0x67, 0x61, 0x6e, 0x69, 0x7a, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64,
+	0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x49, 0x64, 0x12,
+	0x25, 0x0a, 0x0e, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x5f, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74,
+	0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0d, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x4d, 0x65,
+	0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x12, 0x44, 0x0a, 0x10, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x67,
+	0x61, 0x74, 0x68, 0x65, 0x72, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0e, 0x64, 0x61,
+	0x74, 0x61, 0x47, 0x61, 0x74, 0x68, 0x65, 0x72, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x25, 0x0a, 0x0e,
+	0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x22, 0xad, 0x03, 0x0a, 0x0b, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61, 0x64,
+	0x69, 0x6e, 0x67, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x5f, 0x69,
+	0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63, 0x6c, 0x75, 0x73, 0x74, 0x65, 0x72,
+	0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x67, 0x61, 0x74, 0x68, 0x65,
+	0x72, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x64, 0x61, 0x74, 0x61, 0x47,
+	0x61, 0x74, 0x68, 0x65, 0x72, 0x65, 0x72, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73,
+	0x74, 0x61, 0x6d, 0x70, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0c, 0x52,
+	0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x5f,
+	0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x73,
+	0x63, 0x68, 0x65, 0x6d, 0x61, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x1a, 0x0a, 0x08,
+	0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08,
+	0x66, 0x69, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x5f, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x0d, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x12,
+	0x44, 0x0a, 0x06, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x2c, 0x2e, 0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x2e, 0x75, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e,
+	0x67, 0x2e, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x06, 0x6c,
+	0x61, 0x62, 0x65, 0x6c, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x76, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x61, 0x74,
+	0x61, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x1a, 0x39, 0x0a, 0x0b, 0x4c, 0x61, 0x62, 0x65,
+	0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a,
+	0x02, 0x38, 0x01, 0x22, 0x38, 0x0a, 0x1a, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x44, 0x61, 0x74,
+	0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x08, 0x72, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x32, 0x88, 0x01,
+	0x0a, 0x0d, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12,
+	0x77, 0x0a, 0x12, 0x55, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61,
+	0x64, 0x69, 0x6e, 0x67, 0x73, 0x12, 0x2e, 0x2e, 0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68,
+	0x74, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x55, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x2f, 0x2e, 0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68,
+	0x74, 0x2e, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x2e, 0x76, 0x31, 0x2e, 0x55, 0x70, 0x6c, 0x6f,
+	0x61, 0x64, 0x44, 0x61, 0x74, 0x61, 0x52, 0x65, 0x61, 0x64, 0x69, 0x6e, 0x67, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x28, 0x01, 0x42, 0x36, 0x5a, 0x34, 0x67, 0x69, 0x74, 0x68,
+	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6a, 0x65, 0x74, 0x73, 0x74, 0x61, 0x63, 0x6b, 0x2f,
+	0x70, 0x72, 0x65, 0x66, 0x6c, 0x69, 0x67, 0x68, 0x74, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x75, 0x70,
+	0x6c, 0x6f, 0x61, 0x64, 0x2f, 0x76, 0x31, 0x3b, 0x75, 0x70, 0x6c, 0x6f, 0x61, 0x64, 0x76, 0x31,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_api_upload_v1_upload_proto_rawDescOnce sync.Once
+	file_api_upload_v1_upload_proto_rawDescData = file_api_upload_v1_upload_proto_rawDesc
+)
+
+func file_api_upload_v1_upload_proto_rawDescGZIP() []byte {
+	file_api_upload_v1_upload_proto_rawDescOnce.Do(func() {
+		file_api_upload_v1_upload_proto_rawDescData = protoimpl.X.CompressGZIP(file_api_upload_v1_upload_proto_rawDescData)
+	})
+	return file_api_upload_v1_upload_proto_rawDescData
+}
+
+var file_api_upload_v1_upload_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_api_upload_v1_upload_proto_goTypes = []interface{}{
+	(*UploadDataReadingsRequest)(nil),  // 0: preflight.upload.v1.UploadDataReadingsRequest
+	(*UploadHeader)(nil),               // 1: preflight.upload.v1.UploadHeader
+	(*DataReading)(nil),                // 2: preflight.upload.v1.DataReading
+	(*UploadDataReadingsResponse)(nil), // 3: preflight.upload.v1.UploadDataReadingsResponse
+	nil,                                // 4: preflight.upload.v1.DataReading.LabelsEntry
+	(*timestamppb.Timestamp)(nil),      // 5: google.protobuf.Timestamp
+}
+var file_api_upload_v1_upload_proto_depIdxs = []int32{
+	1, // 0: preflight.upload.v1.UploadDataReadingsRequest.header:type_name -> preflight.upload.v1.UploadHeader
+	2, // 1: preflight.upload.v1.UploadDataReadingsRequest.reading:type_name -> preflight.upload.v1.DataReading
+	5, // 2: preflight.upload.v1.UploadHeader.data_gather_time:type_name -> google.protobuf.Timestamp
+	5, // 3: preflight.upload.v1.DataReading.timestamp:type_name -> google.protobuf.Timestamp
+	4, // 4: preflight.upload.v1.DataReading.labels:type_name -> preflight.upload.v1.DataReading.LabelsEntry
+	0, // 5: preflight.upload.v1.UploadService.UploadDataReadings:input_type -> preflight.upload.v1.UploadDataReadingsRequest
+	3, // 6: preflight.upload.v1.UploadService.UploadDataReadings:output_type -> preflight.upload.v1.UploadDataReadingsResponse
+	6, // [6:7] is the sub-list for method output_type
+	5, // [5:6] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
+}
+
+func init() { file_api_upload_v1_upload_proto_init() }
+func file_api_upload_v1_upload_proto_init() {
+	if File_api_upload_v1_upload_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_api_upload_v1_upload_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UploadDataReadingsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_upload_v1_upload_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UploadHeader); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_upload_v1_upload_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DataReading); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_api_upload_v1_upload_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UploadDataReadingsResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_api_upload_v1_upload_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*UploadDataReadingsRequest_Header)(nil),
+		(*UploadDataReadingsRequest_Reading)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_api_upload_v1_upload_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_api_upload_v1_upload_proto_goTypes,
+		DependencyIndexes: file_api_upload_v1_upload_proto_depIdxs,
+		MessageInfos:      file_api_upload_v1_upload_proto_msgTypes,
+	}.Build()
+	File_api_upload_v1_upload_proto = out.File
+	file_api_upload_v1_upload_proto_rawDesc = nil
+	file_api_upload_v1_upload_proto_goTypes = nil
+	file_api_upload_v1_upload_proto_depIdxs = nil
+}
@@ -0,0 +1,153 @@
+// The gRPC protocol of the uploads of the agent, used with `backend: grpc`.
+// The data of the readings is arbitrary, so it is sent JSON encoded, as in
+// the JSON payloads, while the rest of the readings is encoded in protobuf.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v3.5.1-go
+// source: api/upload/v1/upload.proto
+
+package uploadv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	UploadService_UploadDataReadings_FullMethodName = "/preflight.upload.v1.UploadService/UploadDataReadings"
+)
+
+// UploadServiceClient is the client API for UploadService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type UploadServiceClient interface {
+	// UploadDataReadings streams the readings of a gathering: a header
+	// followed by one message per reading. The server answers once all the
+	// readings are received.
+	UploadDataReadings(ctx context.Context, opts ...grpc.CallOption) (UploadService_UploadDataReadingsClient, error)
+}
+
+type uploadServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewUploadServiceClient(cc grpc.ClientConnInterface) UploadServiceClient {
+	return &uploadServiceClient{cc}
+}
+
+func (c *uploadServiceClient) UploadDataReadings(ctx context.Context, opts ...grpc.CallOption) (UploadService_UploadDataReadingsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UploadService_ServiceDesc.Streams[0], UploadService_UploadDataReadings_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &uploadServiceUploadDataReadingsClient{stream}
+	return x, nil
+}
+
+type UploadService_UploadDataReadingsClient interface {
+	Send(*UploadDataReadingsRequest) error
+	CloseAndRecv() (*UploadDataReadingsResponse, error)
+	grpc.ClientStream
+}
+
+type uploadServiceUploadDataReadingsClient struct {
+	grpc.ClientStream
+}
+
+func (x *uploadServiceUploadDataReadingsClient) Send(m *UploadDataReadingsRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *uploadServiceUploadDataReadingsClient) CloseAndRecv() (*UploadDataReadingsResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(UploadDataReadingsResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UploadServiceServer is the server API for UploadService service.
+// All implementations must embed UnimplementedUploadServiceServer
+// for forward compatibility
+type UploadServiceServer interface {
+	// UploadDataReadings streams the readings of a gathering: a header
+	// followed by one message per reading. The server answers once all the
+	// readings are received.
+	UploadDataReadings(UploadService_UploadDataReadingsServer) error
+	mustEmbedUnimplementedUploadServiceServer()
+}
+
+// UnimplementedUploadServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedUploadServiceServer struct {
+}
+
+func (UnimplementedUploadServiceServer) UploadDataReadings(UploadService_UploadDataReadingsServer) error {
+	return status.Errorf(codes.Unimplemented, "method UploadDataReadings not implemented")
+}
+func (UnimplementedUploadServiceServer) mustEmbedUnimplementedUploadServiceServer() {}
+
+// UnsafeUploadServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to UploadServiceServer will
+// result in compilation errors.
+type UnsafeUploadServiceServer interface {
+	mustEmbedUnimplementedUploadServiceServer()
+}
+
+func RegisterUploadServiceServer(s grpc.ServiceRegistrar, srv UploadServiceServer) {
+	s.RegisterService(&UploadService_ServiceDesc, srv)
+}
+
+func _UploadService_UploadDataReadings_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(UploadServiceServer).UploadDataReadings(&uploadServiceUploadDataReadingsServer{stream})
+}
+
+type UploadService_UploadDataReadingsServer interface {
+	SendAndClose(*UploadDataReadingsResponse) error
+	Recv() (*UploadDataReadingsRequest, error)
+	grpc.ServerStream
+}
+
+type uploadServiceUploadDataReadingsServer struct {
+	grpc.ServerStream
+}
+
+func (x *uploadServiceUploadDataReadingsServer) SendAndClose(m *UploadDataReadingsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *uploadServiceUploadDataReadingsServer) Recv() (*UploadDataReadingsRequest, error) {
+	m := new(UploadDataReadingsRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// UploadService_ServiceDesc is the grpc.ServiceDesc for UploadService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var UploadService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "preflight.upload.v1.UploadService",
+	HandlerType: (*UploadServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UploadDataReadings",
+			Handler:       _UploadService_UploadDataReadings_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "api/upload/v1/upload.proto",
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/jetstack/preflight/pkg/agent"
+	"github.com/jetstack/preflight/pkg/browse"
+	"github.com/jetstack/preflight/pkg/diff"
 	"github.com/jetstack/preflight/pkg/permissions"
 	"github.com/spf13/cobra"
 )
@@ -51,10 +53,227 @@ var agentRBACCmd = &cobra.Command{
 	},
 }
 
+var (
+	// diffOutput is the format the diff is printed in, text or json.
+	diffOutput string
+	// diffExpiryWindow is how soon the certificates reported as expiring
+	// expire.
+	diffExpiryWindow time.Duration
+)
+
+var agentDiffCmd = &cobra.Command{
+	Use:   "diff <before> <after>",
+	Short: "print the differences between two archived readings",
+	Long: `Print the resources and findings that were added, removed or changed
+between two files of data readings, as written with --output-path, and the
+certificates that newly expire within the expiry window.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if diffOutput != "text" && diffOutput != "json" {
+			log.Fatalf("The output must be text or json, got %q", diffOutput)
+		}
+		before, err := diff.LoadReadings(args[0])
+		if err != nil {
+			log.Fatalf("Failed to load readings: %s", err)
+		}
+		after, err := diff.LoadReadings(args[1])
+		if err != nil {
+			log.Fatalf("Failed to load readings: %s", err)
+		}
+
+		result, err := diff.Readings(before, after, diffExpiryWindow)
+		if err != nil {
+			log.Fatalf("Failed to compare readings: %s", err)
+		}
+		if diffOutput == "json" {
+			if err := result.PrintJSON(os.Stdout); err != nil {
+				log.Fatalf("Failed to print the differences: %s", err)
+			}
+			return
+		}
+		result.Print(os.Stdout)
+	},
+}
+
+var agentBrowseCmd = &cobra.Command{
+	Use:   "browse <file>",
+	Short: "interactively browse archived readings",
+	Long: `Browse a file of data readings, as written with --output-path, by data
+gatherer, namespace, kind and object.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		readings, err := diff.LoadReadings(args[0])
+		if err != nil {
+			log.Fatalf("Failed to load readings: %s", err)
+		}
+
+		if err := browse.Browse(os.Stdin, os.Stdout, readings); err != nil {
+			log.Fatalf("Failed to browse readings: %s", err)
+		}
+	},
+}
+
+var agentEstimateCmd = &cobra.Command{
+	Use:   "estimate",
+	Short: "estimate the data gathered by a data gatherer",
+	Long: `Estimate the number of objects, the payload size and the fetch time of a
+k8s-dynamic data gatherer before enabling it. Objects are counted with
+metadata-only requests and the size is extrapolated from a small sample.`,
+	Run: agent.Estimate,
+}
+
+var agentQueryCmd = &cobra.Command{
+	Use:   "query <sql>",
+	Short: "query the local SQLite database of readings",
+	Long: `Run an SQL query against the SQLite database the agent loads its readings
+into when sqlite is configured, and print the result as a table. The database
+is opened read-only.`,
+	Args: cobra.ExactArgs(1),
+	Run:  agent.Query,
+}
+
+var agentCheckPermissionsCmd = &cobra.Command{
+	Use:   "check-permissions",
+	Short: "check the agent has the permissions its data gatherers need",
+	Long: `Check with SelfSubjectAccessReviews that the agent is allowed to read
+the resources of each configured data gatherer, and print a report of the
+missing permissions. Exits with a non-zero status if any is missing.`,
+	Run: agent.CheckPermissions,
+}
+
+var agentValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check the agent config for mistakes",
+	Long: `Parse the agent config strictly, check the files it refers to, and print
+all the problems found with their line numbers, rather than failing on the
+first one when the agent starts. Exits with a non-zero status if there are
+any.`,
+	Run: agent.Validate,
+}
+
+var agentSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "print the JSON Schema of the agent config",
+	Long: `Print the JSON Schema of the agent config, including the config of every
+kind of data gatherer, so that editors and deployment pipelines can check the
+config before it is deployed.`,
+	Run: agent.PrintConfigSchema,
+}
+
+var agentSimulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "simulate a cycle of the agent against fixtures",
+	Long: `Run the data gatherers of the agent config against the Kubernetes
+manifests in a fixtures directory rather than a cluster, including redaction
+and findings, and print the readings, findings and outputs a cycle of the
+agent would produce. Nothing is uploaded or written, except the readings to
+--output if it is set.`,
+	Run: agent.Simulate,
+}
+
+var agentGatherCmd = &cobra.Command{
+	Use:   "gather",
+	Short: "run a single data gatherer and print its data",
+	Long: `Run a single data gatherer of the agent config once and print its
+reading, to debug the config of a data gatherer without running a whole cycle.
+Nothing is uploaded or written.`,
+	Run: agent.Gather,
+}
+
 func init() {
 	rootCmd.AddCommand(agentCmd)
 	agentCmd.AddCommand(agentInfoCmd)
 	agentCmd.AddCommand(agentRBACCmd)
+	agentCmd.AddCommand(agentDiffCmd)
+	agentCmd.AddCommand(agentBrowseCmd)
+	agentCmd.AddCommand(agentEstimateCmd)
+	agentCmd.AddCommand(agentQueryCmd)
+	agentCmd.AddCommand(agentCheckPermissionsCmd)
+	agentCmd.AddCommand(agentValidateCmd)
+	agentCmd.AddCommand(agentSchemaCmd)
+	agentCmd.AddCommand(agentSimulateCmd)
+	agentCmd.AddCommand(agentGatherCmd)
+	agentDiffCmd.Flags().StringVarP(
+		&diffOutput,
+		"output",
+		"o",
+		"text",
+		"Format the differences are printed in, text or json.",
+	)
+	agentDiffCmd.Flags().DurationVarP(
+		&diffExpiryWindow,
+		"expiry-window",
+		"",
+		diff.DefaultExpiryWindow,
+		"Report the certificates that newly expire within this duration of the time of the after readings, 0 to disable (given as XhYmZs).",
+	)
+	agentEstimateCmd.Flags().StringVarP(
+		&agent.EstimateGathererPath,
+		"gatherer",
+		"",
+		"",
+		"File containing the data gatherer to estimate, in the same format as an entry of data-gatherers in the agent config.",
+	)
+	agentEstimateCmd.MarkFlagRequired("gatherer")
+	agentQueryCmd.Flags().StringVarP(
+		&agent.QueryDatabasePath,
+		"database",
+		"",
+		"",
+		"Path to the SQLite database of readings, as configured with sqlite.path in the agent config.",
+	)
+	agentQueryCmd.MarkFlagRequired("database")
+	agentValidateCmd.Flags().StringVarP(
+		&agent.ValidateConfigPath,
+		"config",
+		"",
+		"",
+		"Config file to validate, defaults to the agent config file.",
+	)
+	agentSimulateCmd.Flags().StringVarP(
+		&agent.SimulateFixturesPath,
+		"fixtures",
+		"",
+		"",
+		"Directory of Kubernetes manifests, in YAML or JSON, the data gatherers read from.",
+	)
+	agentSimulateCmd.MarkFlagRequired("fixtures")
+	agentSimulateCmd.Flags().StringVarP(
+		&agent.SimulateConfigPath,
+		"config",
+		"",
+		"",
+		"Config file to simulate, defaults to the agent config file.",
+	)
+	agentSimulateCmd.Flags().StringVarP(
+		&agent.SimulateOutputPath,
+		"output",
+		"",
+		"",
+		"File to write the simulated readings to.",
+	)
+	agentGatherCmd.Flags().StringVarP(
+		&agent.GatherName,
+		"name",
+		"",
+		"",
+		"Name of the data gatherer to run, or <cluster>/<name> for the data gatherers of other clusters.",
+	)
+	agentGatherCmd.MarkFlagRequired("name")
+	agentGatherCmd.Flags().StringVarP(
+		&agent.GatherConfigPath,
+		"config",
+		"",
+		"",
+		"Config file the data gatherer is read from, defaults to the agent config file.",
+	)
+	agentGatherCmd.Flags().StringVarP(
+		&agent.GatherOutputFormat,
+		"output",
+		"o",
+		agent.GatherOutputJSON,
+		"Format the reading is printed in, json or yaml.",
+	)
 	agentCmd.PersistentFlags().StringVarP(
 		&agent.ConfigFilePath,
 		"agent-config-file",
@@ -136,7 +355,27 @@ func init() {
 		&agent.APIToken,
 		"api-token",
 		os.Getenv("API_TOKEN"),
-		"Token used for authentication when API tokens are in use on the backend",
+		"Token used for authentication when API tokens are in use on the backend. Can reference a token in a secret manager or a file, e.g. vault://secret/data/agent#token, aws-sm://<secret>#token, gcp-sm://projects/<project>/secrets/<secret> or file:///etc/agent/token",
+	)
+	agentCmd.PersistentFlags().StringVar(
+		&agent.NextAPIToken,
+		"next-api-token",
+		os.Getenv("NEXT_API_TOKEN"),
+		"Token used once the backend rejects --api-token, to rotate the API token without interrupting uploads. Can reference a token like --api-token.",
+	)
+	agentCmd.PersistentFlags().StringVarP(
+		&agent.StateFilePath,
+		"state-file",
+		"",
+		"",
+		"Path to a file where the agent records its state, to report when the previous run did not terminate cleanly.",
+	)
+	agentCmd.Flags().BoolVarP(
+		&agent.CheckPermissionsOnStartup,
+		"check-permissions",
+		"",
+		false,
+		"Checks the permissions of all data gatherers before the first run, and exits with a report of the missing ones if any is missing.",
 	)
 	agentCmd.PersistentFlags().BoolVarP(
 		&agent.Profiling,
@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	json "github.com/json-iterator/go"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/endpoint"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// AlertMetricCertExpiryDays is the number of days left before the expiry of
+// the certificates of the TLS Secrets and cert-manager Certificates.
+const AlertMetricCertExpiryDays = "cert_expiry_days"
+
+// alertConditionPattern matches the conditions of the rules, a metric
+// compared to a threshold.
+var alertConditionPattern = regexp.MustCompile(`^\s*([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+// AlertsConfig configures the rules evaluated on the results of the data
+// gatherers each cycle, so that basic alerting works without the backend.
+type AlertsConfig struct {
+	Rules []AlertRule `yaml:"rules"`
+}
+
+// AlertRule triggers for each value of a metric meeting its condition.
+type AlertRule struct {
+	// Name identifies the rule, and is the rule ID of its findings.
+	Name string `yaml:"name"`
+	// When is the condition of the rule, a metric compared to a threshold
+	// with <, <=, >, >=, == or !=, e.g. `cert_expiry_days < 14`. The
+	// metrics are cert_expiry_days, from the TLS Secrets and cert-manager
+	// Certificates gathered by the k8s-dynamic data gatherers, and the
+	// queries of the prometheus data gatherers, by name.
+	When string `yaml:"when"`
+	// Severity is the severity of the findings of the rule. Defaults to
+	// medium.
+	Severity api.Severity `yaml:"severity,omitempty"`
+	// Message, if set, is the message of the findings instead of the value
+	// and the condition.
+	Message string `yaml:"message,omitempty"`
+}
+
+func (c *AlertsConfig) validate() error {
+	_, err := compileAlertRules(*c)
+	return err
+}
+
+// alertCondition is the parsed condition of a rule.
+type alertCondition struct {
+	metric    string
+	operator  string
+	threshold float64
+}
+
+func parseAlertCondition(when string) (alertCondition, error) {
+	m := alertConditionPattern.FindStringSubmatch(when)
+	if m == nil {
+		return alertCondition{}, fmt.Errorf("must be a metric compared to a number, e.g. %s < 14", AlertMetricCertExpiryDays)
+	}
+	threshold, err := strconv.ParseFloat(m[3], 64)
+	if err != nil || math.IsNaN(threshold) {
+		return alertCondition{}, fmt.Errorf("invalid threshold %q", m[3])
+	}
+	return alertCondition{metric: m[1], operator: m[2], threshold: threshold}, nil
+}
+
+// holds returns whether the value meets the condition.
+func (c alertCondition) holds(value float64) bool {
+	switch c.operator {
+	case "<":
+		return value < c.threshold
+	case "<=":
+		return value <= c.threshold
+	case ">":
+		return value > c.threshold
+	case ">=":
+		return value >= c.threshold
+	case "==":
+		return value == c.threshold
+	default:
+		return value != c.threshold
+	}
+}
+
+func (c alertCondition) String() string {
+	return fmt.Sprintf("%s %s %s", c.metric, c.operator, formatAlertValue(c.threshold))
+}
+
+type compiledAlertRule struct {
+	AlertRule
+	condition alertCondition
+}
+
+// compileAlertRules parses the conditions of the rules, and returns all
+// their errors.
+func compileAlertRules(config AlertsConfig) ([]*compiledAlertRule, error) {
+	var result *multierror.Error
+	if len(config.Rules) == 0 {
+		result = multierror.Append(result, fmt.Errorf("alerts.rules is required"))
+	}
+	var rules []*compiledAlertRule
+	names := map[string]bool{}
+	for i, rule := range config.Rules {
+		if rule.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("alerts.rules[%d] is missing a name", i))
+			continue
+		}
+		if names[rule.Name] {
+			result = multierror.Append(result, fmt.Errorf("alerts.rules[%d]: duplicate name %q", i, rule.Name))
+			continue
+		}
+		names[rule.Name] = true
+		if rule.Severity == "" {
+			rule.Severity = api.SeverityMedium
+		} else if rule.Severity.Rank() < 0 {
+			result = multierror.Append(result, fmt.Errorf("alerts.rules[%d]: invalid severity %q", i, rule.Severity))
+			continue
+		}
+		condition, err := parseAlertCondition(rule.When)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("alerts.rules[%d].when: %s", i, err))
+			continue
+		}
+		rules = append(rules, &compiledAlertRule{AlertRule: rule, condition: condition})
+	}
+	if result != nil {
+		return nil, result
+	}
+	return rules, nil
+}
+
+// alertSample is a value of a metric.
+type alertSample struct {
+	Resource api.ResourceRef
+	Value    float64
+}
+
+// evaluateAlerts evaluates the configured alert rules on the readings, if
+// any. The triggered alerts are added to the Findings of the readings their
+// values were taken from, so that they are uploaded and notified like the
+// other findings. The number of values each rule triggered for is exported
+// as a metric, and an event is emitted for each rule that triggered.
+func evaluateAlerts(config Config, readings []*api.DataReading) {
+	if config.Alerts == nil {
+		return
+	}
+	rules, err := compileAlertRules(*config.Alerts)
+	if err != nil {
+		log.Printf("not evaluating alerts: %s", err)
+		return
+	}
+	firing := applyAlertRules(rules, readings, time.Now())
+
+	messages := map[string]string{}
+	for _, rule := range rules {
+		findings := firing[rule.Name]
+		metricAlertsFiring.With(prometheus.Labels{
+			"organization": config.OrganizationID,
+			"cluster":      config.ClusterID,
+			"rule":         rule.Name,
+		}).Set(float64(len(findings)))
+		if len(findings) == 0 {
+			continue
+		}
+		message := fmt.Sprintf("alert %q triggered for %s: %s", rule.Name, findings[0].Resource, findings[0].Message)
+		if len(findings) > 1 {
+			message += fmt.Sprintf(", and %d more", len(findings)-1)
+		}
+		log.Print(message)
+		messages[rule.Name] = message
+	}
+	agentEvents.observeAlerts(messages)
+}
+
+// applyAlertRules evaluates the rules on the metrics of the readings, adds
+// the findings of the triggered rules to the readings, and returns them by
+// rule.
+func applyAlertRules(rules []*compiledAlertRule, readings []*api.DataReading, now time.Time) map[string][]api.Finding {
+	firing := map[string][]api.Finding{}
+	for _, reading := range readings {
+		metrics := alertMetrics(reading.Data, now)
+		if len(metrics) == 0 {
+			continue
+		}
+		for _, rule := range rules {
+			for _, sample := range metrics[rule.condition.metric] {
+				if !rule.condition.holds(sample.Value) {
+					continue
+				}
+				finding := rule.finding(sample)
+				reading.Findings = append(reading.Findings, finding)
+				firing[rule.Name] = append(firing[rule.Name], finding)
+			}
+		}
+	}
+	return firing
+}
+
+func (r *compiledAlertRule) finding(sample alertSample) api.Finding {
+	message := r.Message
+	if message == "" {
+		message = fmt.Sprintf("%s is %s, alerting when %s", r.condition.metric, formatAlertValue(sample.Value), r.condition)
+	}
+	return api.Finding{
+		RuleID:   r.Name,
+		Severity: r.Severity,
+		Resource: sample.Resource,
+		Message:  message,
+	}
+}
+
+// alertMetrics returns the values of the metrics found in the data of a
+// reading. Like with the policies, the data is converted to its JSON
+// representation first, so that the gathered data and the data read from a
+// file are handled alike.
+func alertMetrics(data interface{}, now time.Time) map[string][]alertSample {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	metrics := map[string][]alertSample{}
+
+	certificate := func(expiry k8s.CertificateExpiry) {
+		if expiry.NotAfter == nil {
+			return
+		}
+		metrics[AlertMetricCertExpiryDays] = append(metrics[AlertMetricCertExpiryDays], alertSample{
+			Resource: api.ResourceRef{Kind: expiry.Kind, Namespace: expiry.Namespace, Name: expiry.Name},
+			Value:    math.Floor(expiry.NotAfter.Sub(now).Hours() / 24),
+		})
+	}
+	// the resources of the k8s-dynamic data gatherers
+	resources, _ := policyResources(data)
+	for _, resource := range resources {
+		if expiry, ok := k8s.CertificateExpiryOf(&unstructured.Unstructured{Object: resource}); ok {
+			certificate(expiry)
+		}
+	}
+	// their summaries in the findings report mode
+	var summary k8s.DynamicSummary
+	if err := json.Unmarshal(encoded, &summary); err == nil {
+		for _, expiry := range summary.Certificates {
+			certificate(expiry)
+		}
+	}
+	// the queries of the prometheus data gatherers
+	var queries struct {
+		Results []endpoint.PrometheusResult `json:"results"`
+	}
+	if err := json.Unmarshal(encoded, &queries); err == nil {
+		for _, result := range queries.Results {
+			for _, s := range result.Samples {
+				value, err := strconv.ParseFloat(s.Value, 64)
+				if err != nil || math.IsNaN(value) {
+					continue
+				}
+				metrics[result.Name] = append(metrics[result.Name], alertSample{
+					Resource: api.ResourceRef{Kind: "Metric", Namespace: s.Metric["namespace"], Name: result.Name + formatAlertLabels(s.Metric)},
+					Value:    value,
+				})
+			}
+		}
+	}
+	return metrics
+}
+
+// formatAlertLabels formats the labels of a sample like Prometheus does,
+// e.g. {container="web",namespace="default"}.
+func formatAlertLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatAlertValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/endpoint"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+func TestApplyAlertRules(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	certificate := func(name string, notAfter time.Time) *api.GatheredResource {
+		return &api.GatheredResource{Resource: &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1",
+			"kind":       "Certificate",
+			"metadata":   map[string]interface{}{"namespace": "default", "name": name},
+			"status":     map[string]interface{}{"notAfter": notAfter.Format(time.RFC3339)},
+		}}}
+	}
+	readings := []*api.DataReading{
+		{
+			DataGatherer: "k8s/certificates",
+			Data: map[string]interface{}{"items": []*api.GatheredResource{
+				certificate("web", now.Add(3*24*time.Hour+time.Hour)),
+				certificate("api", now.Add(90*24*time.Hour)),
+			}},
+		},
+		{
+			DataGatherer: "k8s/secrets",
+			Data: &k8s.DynamicSummary{Certificates: []k8s.CertificateExpiry{
+				{Kind: "Secret", Namespace: "default", Name: "expired", NotAfter: &api.Time{Time: now.Add(-time.Hour)}},
+				{Kind: "Secret", Namespace: "default", Name: "invalid", Error: "failed to parse tls.crt"},
+			}},
+		},
+		{
+			DataGatherer: "prometheus",
+			Data: map[string]interface{}{"results": []*endpoint.PrometheusResult{{
+				Name: "image_major_versions_behind",
+				Samples: []endpoint.PrometheusSample{
+					{Metric: map[string]string{"namespace": "default", "image": "nginx"}, Value: "2"},
+					{Metric: map[string]string{"namespace": "default", "image": "redis"}, Value: "0"},
+					{Metric: map[string]string{"namespace": "default", "image": "envoy"}, Value: "NaN"},
+				},
+			}}},
+		},
+	}
+	rules, err := compileAlertRules(AlertsConfig{Rules: []AlertRule{
+		{Name: "certificate-expiring", When: "cert_expiry_days < 14", Severity: api.SeverityHigh},
+		{Name: "outdated-image", When: "image_major_versions_behind >= 2", Message: "the image is outdated"},
+		{Name: "unknown", When: "unknown_metric > 0"},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	firing := applyAlertRules(rules, readings, now)
+
+	expiring := []api.Finding{
+		{RuleID: "certificate-expiring", Severity: api.SeverityHigh, Resource: api.ResourceRef{Kind: "Certificate", Namespace: "default", Name: "web"}, Message: "cert_expiry_days is 3, alerting when cert_expiry_days < 14"},
+		{RuleID: "certificate-expiring", Severity: api.SeverityHigh, Resource: api.ResourceRef{Kind: "Secret", Namespace: "default", Name: "expired"}, Message: "cert_expiry_days is -1, alerting when cert_expiry_days < 14"},
+	}
+	outdated := []api.Finding{
+		{RuleID: "outdated-image", Severity: api.SeverityMedium, Resource: api.ResourceRef{Kind: "Metric", Namespace: "default", Name: `image_major_versions_behind{image="nginx",namespace="default"}`}, Message: "the image is outdated"},
+	}
+	expected := map[string][]api.Finding{"certificate-expiring": expiring, "outdated-image": outdated}
+	if diff, equal := messagediff.PrettyDiff(expected, firing); !equal {
+		t.Errorf("unexpected alerts:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(expiring[:1], readings[0].Findings); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(outdated, readings[2].Findings); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestAlertsConfigValidate(t *testing.T) {
+	config := AlertsConfig{Rules: []AlertRule{
+		{Name: "a", When: "cert_expiry_days"},
+		{Name: "a", When: "cert_expiry_days < 14"},
+		{When: "cert_expiry_days < 14"},
+		{Name: "b", When: "cert_expiry_days < soon"},
+		{Name: "c", When: "cert_expiry_days < 14", Severity: "urgent"},
+	}}
+	err := config.validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{
+		"alerts.rules[0].when: must be a metric compared to a number, e.g. cert_expiry_days < 14",
+		`alerts.rules[1]: duplicate name "a"`,
+		"alerts.rules[2] is missing a name",
+		`alerts.rules[3].when: invalid threshold "soon"`,
+		`alerts.rules[4]: invalid severity "urgent"`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %s", expected, err)
+		}
+	}
+}
+
+func TestEventEmitterAlerts(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	e, err := newEventEmitter(EventsConfig{Deployment: "agent"}, "jetstack-secure", recorder, fake.NewSimpleClientset())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	events := func() []string {
+		var result []string
+		for {
+			select {
+			case event := <-recorder.Events:
+				result = append(result, event)
+			default:
+				return result
+			}
+		}
+	}
+
+	e.observeAlerts(map[string]string{"b": "alert b", "a": "alert a"})
+	expected := []string{"Warning AlertFiring alert a", "Warning AlertFiring alert b"}
+	if diff, equal := messagediff.PrettyDiff(expected, events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+	e.observeAlerts(map[string]string{"b": "alert b"})
+	expected = []string{`Normal AlertResolved alert "a" resolved`, "Warning AlertFiring alert b"}
+	if diff, equal := messagediff.PrettyDiff(expected, events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+	"github.com/jetstack/preflight/pkg/secrets"
+)
+
+// secretAPIToken is an API token referenced in a secret manager or a file,
+// loaded again when the backend rejects it.
+type secretAPIToken struct {
+	resolver  *secrets.Resolver
+	reference string
+}
+
+func (t secretAPIToken) Token() (string, error) {
+	return t.resolver.Resolve(context.Background(), t.reference)
+}
+
+func (t secretAPIToken) Reload() {
+	t.resolver.Invalidate(t.reference)
+}
+
+// createAPITokenClient creates a client authenticating with the API token
+// and, during a rotation, the next API token, which is used once the backend
+// rejects the current one.
+func createAPITokenClient(apiToken, nextAPIToken string, config Config, agentMetadata *api.AgentMetadata, baseURL string) (client.Client, error) {
+	tokens, err := apiTokenSources(apiToken, nextAPIToken, config)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewAPITokenSourceClient(agentMetadata, baseURL, tokens...)
+}
+
+// apiTokenSources returns the sources of the API token and of the next API
+// token, if any. Tokens referenced in a secret manager are loaded once to
+// fail early if they can't be.
+func apiTokenSources(apiToken, nextAPIToken string, config Config) ([]client.APITokenSource, error) {
+	var secretsConfig secrets.Config
+	if config.Secrets != nil {
+		secretsConfig = *config.Secrets
+	}
+	resolver := secrets.NewResolver(secretsConfig)
+
+	tokenSource := func(token string) (client.APITokenSource, error) {
+		if !secrets.IsReference(token) {
+			return client.StaticAPIToken(token), nil
+		}
+		if _, err := resolver.Resolve(context.Background(), token); err != nil {
+			return nil, err
+		}
+		return secretAPIToken{resolver: resolver, reference: token}, nil
+	}
+
+	current, err := tokenSource(apiToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API token: %w", err)
+	}
+	tokens := []client.APITokenSource{current}
+	if nextAPIToken != "" {
+		log.Println("A next API token was specified, it will be used if the API token is rejected.")
+		next, err := tokenSource(nextAPIToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load next API token: %w", err)
+		}
+		tokens = append(tokens, next)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// tokenServer accepts uploads authenticated with its current token and
+// records the tokens it was sent.
+type tokenServer struct {
+	mu       sync.Mutex
+	token    string
+	received []string
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.received = append(s.received, token)
+	if token != s.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *tokenServer) rotate(token string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	received := s.received
+	s.token = token
+	s.received = nil
+	return received
+}
+
+func TestAPITokenRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("one\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	server := &tokenServer{token: "one"}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c, err := createAPITokenClient("file://"+path, "next", Config{}, &api.AgentMetadata{}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	upload := func() {
+		t.Helper()
+		if err := c.PostDataReadings("org", "cluster", nil); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	expectTokens := func(expected ...string) {
+		t.Helper()
+		received := server.rotate(server.token)
+		if strings.Join(received, ",") != strings.Join(expected, ",") {
+			t.Errorf("unexpected tokens sent: got=%v want=%v", received, expected)
+		}
+	}
+
+	upload()
+	expectTokens("one")
+
+	// the token file is read again when the cached token is rejected
+	if err := os.WriteFile(path, []byte("two\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	server.rotate("two")
+	upload()
+	expectTokens("one", "two")
+
+	// the next token is used when the current token is rejected, and kept
+	server.rotate("next")
+	upload()
+	expectTokens("two", "next")
+	upload()
+	expectTokens("next")
+}
+
+func TestAPITokenRejected(t *testing.T) {
+	server := &tokenServer{token: "other"}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	c, err := createAPITokenClient("one", "next", Config{}, &api.AgentMetadata{}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = c.PostDataReadings("org", "cluster", nil)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the upload to be rejected, got %v", err)
+	}
+}
+
+func TestAPITokenRotationStreamsBody(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") != "Bearer next" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := createAPITokenClient("one", "next", Config{}, &api.AgentMetadata{}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// without an organization, the readings are posted with Post, and
+	// encoded again for the request with the next token
+	readings := []*api.DataReading{{DataGatherer: "k8s/pods", Data: map[string]int{"pods": 3}}}
+	if err := postData(context.Background(), Config{ClusterID: "cluster"}, false, c, readings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `[{"data-gatherer":"k8s/pods","timestamp":"0001-01-01T00:00:00Z","data":{"pods":3},"schema_version":""}]`
+	if len(bodies) != 2 || bodies[0] != expected || bodies[1] != expected {
+		t.Errorf("expected the body to be sent twice, got %q", bodies)
+	}
+}
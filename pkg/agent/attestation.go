@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/attestation"
+)
+
+// AttestationConfig enables in-toto provenance attestations for the data
+// readings.
+type AttestationConfig struct {
+	// OutputPath is the file the attestations are written to, one DSSE
+	// envelope per line. Defaults to the output path followed by
+	// `.intoto.jsonl`.
+	OutputPath string `yaml:"output-path"`
+	// SigningKeyPath is the path to a PEM encoded PKCS #8 ECDSA or Ed25519
+	// private key. If empty, the attestations are not signed.
+	SigningKeyPath string `yaml:"signing-key-path"`
+}
+
+// attestationOutputPath returns where the attestations are written, or an
+// empty string if there is nowhere to write them.
+func (a *AttestationConfig) attestationOutputPath(outputPath string) string {
+	if a.OutputPath != "" {
+		return a.OutputPath
+	}
+	if outputPath != "" {
+		return outputPath + ".intoto.jsonl"
+	}
+	return ""
+}
+
+// writeAttestations writes an attestation of the provenance of each reading
+// to path.
+func writeAttestations(config Config, readings []*api.DataReading, startedOn time.Time, path string) error {
+	var signer *attestation.Signer
+	if config.Attestation.SigningKeyPath != "" {
+		var err error
+		if signer, err = attestation.LoadSigner(config.Attestation.SigningKeyPath); err != nil {
+			return err
+		}
+	}
+
+	configDigests := map[string]string{}
+	for _, dg := range config.DataGatherers {
+		digest, err := attestation.Digest(dg)
+		if err != nil {
+			return fmt.Errorf("failed to compute the digest of the config of %q: %w", dg.Name, err)
+		}
+		configDigests[dg.Name] = digest
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		log.Printf("failed to get the hostname for attestations: %s", err)
+	}
+
+	var out bytes.Buffer
+	for _, reading := range readings {
+		statement, err := attestation.New(reading, attestation.Options{
+			Host:         host,
+			ConfigDigest: configDigests[reading.DataGatherer],
+			StartedOn:    startedOn,
+		})
+		if err != nil {
+			return err
+		}
+		envelope, err := attestation.Envelop(statement, signer)
+		if err != nil {
+			return err
+		}
+		line, err := json.Marshal(envelope)
+		if err != nil {
+			return fmt.Errorf("failed to marshal attestation: %w", err)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+
+	if err := ioutil.WriteFile(path, out.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write attestations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/attestation"
+)
+
+func TestWriteAttestations(t *testing.T) {
+	config := Config{
+		DataGatherers: []DataGatherer{{Name: "k8s/pods", Kind: "dummy", Config: &dummyConfig{}}},
+		Attestation:   &AttestationConfig{},
+	}
+	readings := []*api.DataReading{testReading("default"), testReading("other")}
+	path := filepath.Join(t.TempDir(), "readings.json.intoto.jsonl")
+
+	if err := writeAttestations(config, readings, time.Now(), path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer f.Close()
+
+	var digests []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var envelope attestation.Envelope
+		if err := json.Unmarshal(scanner.Bytes(), &envelope); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var statement attestation.Statement
+		if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, ok := statement.Predicate.BuildDefinition.ExternalParameters["configDigest"]; !ok {
+			t.Errorf("expected the statement to include the config digest")
+		}
+		digests = append(digests, statement.Subject[0].Digest["sha256"])
+	}
+
+	var expected []string
+	for _, reading := range readings {
+		digest, err := attestation.Digest(reading)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected = append(expected, digest)
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, digests); !equal {
+		t.Errorf("unexpected subject digests:\n%s", diff)
+	}
+}
+
+func TestAttestationOutputPath(t *testing.T) {
+	if got := (&AttestationConfig{}).attestationOutputPath("out.json"); got != "out.json.intoto.jsonl" {
+		t.Errorf("unexpected path: %q", got)
+	}
+	if got := (&AttestationConfig{OutputPath: "attestations.jsonl"}).attestationOutputPath("out.json"); got != "attestations.jsonl" {
+		t.Errorf("unexpected path: %q", got)
+	}
+	if got := (&AttestationConfig{}).attestationOutputPath(""); got != "" {
+		t.Errorf("unexpected path: %q", got)
+	}
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+const (
+	// BackendHTTP uploads the readings as JSON over HTTP.
+	BackendHTTP = "http"
+	// BackendGRPC streams the readings over gRPC, see api/upload/v1/upload.proto.
+	BackendGRPC = "grpc"
+	// BackendNATS publishes the readings to a NATS JetStream subject.
+	BackendNATS = "nats"
+	// BackendKafka produces the readings to Kafka topics.
+	BackendKafka = "kafka"
+)
+
+// validateBackend checks the backend, that only its own section is set, and
+// that the options only supported by the HTTP backend aren't set with the
+// others.
+func (c *Config) validateBackend(isVenafiCloudMode bool) error {
+	backend := c.Backend
+	if backend == "" {
+		backend = BackendHTTP
+	}
+	switch backend {
+	case BackendHTTP, BackendGRPC, BackendNATS, BackendKafka:
+	default:
+		return fmt.Errorf("backend must be %s, %s, %s or %s, got %q", BackendHTTP, BackendGRPC, BackendNATS, BackendKafka, c.Backend)
+	}
+	if c.GRPC != nil && backend != BackendGRPC {
+		return fmt.Errorf("grpc can only be set with backend %s", BackendGRPC)
+	}
+	if c.NATS != nil && backend != BackendNATS {
+		return fmt.Errorf("nats can only be set with backend %s", BackendNATS)
+	}
+	if c.Kafka != nil && backend != BackendKafka {
+		return fmt.Errorf("kafka can only be set with backend %s", BackendKafka)
+	}
+	if backend == BackendHTTP {
+		return nil
+	}
+
+	var unsupported []string
+	if c.VenafiCloud != nil || isVenafiCloudMode {
+		unsupported = append(unsupported, "Venafi Cloud mode")
+	}
+	if c.WorkloadIdentity != nil {
+		unsupported = append(unsupported, "workload-identity")
+	}
+	if c.ChunkedUpload {
+		unsupported = append(unsupported, "chunked-upload")
+	}
+	if c.MaxUploadBandwidth > 0 {
+		unsupported = append(unsupported, "max-upload-bandwidth")
+	}
+	if c.PayloadSigning != nil {
+		unsupported = append(unsupported, "payload-signing")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("the %s backend doesn't support %v", backend, unsupported)
+	}
+
+	switch backend {
+	case BackendGRPC:
+		if c.GRPC != nil {
+			return c.GRPC.validate()
+		}
+	case BackendNATS:
+		if c.NATS == nil {
+			return fmt.Errorf("nats is required with backend %s", BackendNATS)
+		}
+		return c.NATS.validate()
+	case BackendKafka:
+		if c.Kafka == nil {
+			return fmt.Errorf("kafka is required with backend %s", BackendKafka)
+		}
+		return c.Kafka.validate()
+	}
+	return nil
+}
+
+// validateSchemaVersion checks that the readings can be converted to the
+// schema version of the uploads.
+func (c *Config) validateSchemaVersion() error {
+	if c.SchemaVersion == "" {
+		return nil
+	}
+	for _, version := range client.SchemaVersions {
+		if version == c.SchemaVersion {
+			return nil
+		}
+	}
+	return fmt.Errorf("schema-version must be one of %s, got %q", strings.Join(client.SchemaVersions, ", "), c.SchemaVersion)
+}
+
+// uploadSchemaVersion returns the version of the schema of the uploads, the
+// latest unless set.
+func (c *Config) uploadSchemaVersion() string {
+	if c.SchemaVersion == "" {
+		return schemaVersion
+	}
+	return c.SchemaVersion
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"testing"
+)
+
+func TestValidateBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{"default", Config{}, ""},
+		{"grpc", Config{Backend: BackendGRPC, GRPC: &GRPCConfig{Address: "example.com:443"}}, ""},
+		{"nats", Config{Backend: BackendNATS, NATS: &NATSConfig{URL: "nats://example.com:4222", Subject: "preflight.readings"}}, ""},
+		{"kafka", Config{Backend: BackendKafka, Kafka: &KafkaConfig{Brokers: []string{"kafka.example.com:9092"}, Topic: "preflight"}}, ""},
+		{"unknown", Config{Backend: "amqp"}, `backend must be http, grpc, nats or kafka, got "amqp"`},
+		{"unsupported", Config{Backend: BackendGRPC, ChunkedUpload: true, GRPC: &GRPCConfig{Address: "example.com"}}, "the grpc backend doesn't support [chunked-upload]"},
+		{"venafi cloud", Config{Backend: BackendNATS, VenafiCloud: &VenafiCloudConfig{}}, "the nats backend doesn't support [Venafi Cloud mode]"},
+		{"grpc address", Config{Backend: BackendGRPC, GRPC: &GRPCConfig{Address: "example.com"}}, "grpc.address must be a host and port: address example.com: missing port in address"},
+		{"grpc without backend", Config{GRPC: &GRPCConfig{}}, "grpc can only be set with backend grpc"},
+		{"nats without backend", Config{Backend: BackendGRPC, NATS: &NATSConfig{}}, "nats can only be set with backend nats"},
+		{"nats missing", Config{Backend: BackendNATS}, "nats is required with backend nats"},
+		{"kafka missing", Config{Backend: BackendKafka}, "kafka is required with backend kafka"},
+		{"kafka brokers", Config{Backend: BackendKafka, Kafka: &KafkaConfig{Brokers: []string{"kafka.example.com"}, Topic: "preflight"}}, "kafka.brokers must be hosts and ports: address kafka.example.com: missing port in address"},
+		{"kafka sasl", Config{Backend: BackendKafka, Kafka: &KafkaConfig{Brokers: []string{"kafka.example.com:9092"}, Topic: "preflight", SASL: &KafkaSASLConfig{Mechanism: "GSSAPI"}}}, "kafka.sasl.mechanism must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512"},
+		{"nats url", Config{Backend: BackendNATS, NATS: &NATSConfig{Subject: "preflight.readings"}}, "nats.url is required"},
+		{"nats mode", Config{Backend: BackendNATS, NATS: &NATSConfig{URL: "nats://example.com:4222", Subject: "preflight.readings", Mode: "batch"}}, "nats.mode must be snapshot or per-gatherer"},
+		{"nats credentials", Config{Backend: BackendNATS, NATS: &NATSConfig{URL: "nats://example.com:4222", Subject: "preflight.readings", TokenPath: "/token", Username: "agent"}}, "only one of nats.credentials-path, nats.nkey-seed-path, nats.token-path and nats.username can be set"},
+	}
+	for _, tc := range tests {
+		err := tc.config.validateBackend(false)
+		if tc.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if tc.expected != "" && (err == nil || err.Error() != tc.expected) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
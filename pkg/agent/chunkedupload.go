@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// uploadParts splits the readings into the parts of an upload session: one
+// reading per part, or, with max-payload-bytes, as many readings as fit in
+// it.
+func uploadParts(config Config, readings []*api.DataReading) ([][]*api.DataReading, error) {
+	if config.MaxPayloadBytes > 0 {
+		return limitPayload(readings, config.MaxPayloadBytes, config.OversizedPayload)
+	}
+	parts := make([][]*api.DataReading, 0, len(readings))
+	for _, reading := range readings {
+		parts = append(parts, []*api.DataReading{reading})
+	}
+	return parts, nil
+}
+
+// uploadChunked uploads the readings in an upload session. Starting the
+// session, each part and completing the session are retried on their own,
+// so that a transient failure doesn't upload all the readings again.
+func uploadChunked(ctx context.Context, config Config, preflightClient client.Client, agentMetadata *api.AgentMetadata, readings []*api.DataReading, retryMessage string) error {
+	parts, err := uploadParts(config, readings)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Posting data to:", config.Server)
+
+	var session *client.UploadSession
+	err = retryUpload(ctx, func() error {
+		session, err = client.StartUploadSession(preflightClient, config.OrganizationID, config.ClusterID, agentMetadata, time.Now())
+		return err
+	}, retryMessage)
+	if err != nil {
+		return err
+	}
+	log.Printf("Started upload session %s for %d parts", session.ID, len(parts))
+
+	for i, part := range parts {
+		number := i + 1
+		err := retryUpload(ctx, func() error {
+			return session.UploadPart(number, part)
+		}, retryMessage)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = retryUpload(ctx, func() error {
+		return session.Complete(len(parts))
+	}, retryMessage)
+	if err != nil {
+		return err
+	}
+	log.Println("Data sent successfully.")
+
+	return nil
+}
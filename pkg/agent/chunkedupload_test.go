@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestUploadChunked(t *testing.T) {
+	var requests []string
+	var completed int
+	failures := map[string]int{"/api/v1/org/example/datareadings/example-cluster/uploads/abc/parts/2": 1}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests = append(requests, r.URL.Path)
+		if failures[r.URL.Path] > 0 {
+			failures[r.URL.Path]--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/uploads"):
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"upload_id":"abc"}`))
+		case strings.HasSuffix(r.URL.Path, "/complete"):
+			var payload struct {
+				Parts int `json:"parts"`
+			}
+			_ = json.Unmarshal(body, &payload)
+			completed = payload.Parts
+		}
+	}))
+	defer server.Close()
+
+	defer func(d time.Duration) { BackoffMaxTime = d }(BackoffMaxTime)
+	BackoffMaxTime = time.Minute
+	defer func(d time.Duration) { uploadRetryInterval = d }(uploadRetryInterval)
+	uploadRetryInterval = time.Millisecond
+
+	config := Config{Server: server.URL, OrganizationID: "example", ClusterID: "example-cluster", ChunkedUpload: true}
+	c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	readings := []*api.DataReading{{DataGatherer: "a"}, {DataGatherer: "b"}, {DataGatherer: "c"}}
+	if err := uploadReadings(context.Background(), config, false, c, &api.AgentMetadata{}, readings, "retrying"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// only the failed part is uploaded again
+	expected := []string{
+		"/api/v1/org/example/datareadings/example-cluster/uploads",
+		"/api/v1/org/example/datareadings/example-cluster/uploads/abc/parts/1",
+		"/api/v1/org/example/datareadings/example-cluster/uploads/abc/parts/2",
+		"/api/v1/org/example/datareadings/example-cluster/uploads/abc/parts/2",
+		"/api/v1/org/example/datareadings/example-cluster/uploads/abc/parts/3",
+		"/api/v1/org/example/datareadings/example-cluster/uploads/abc/complete",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, requests); !equal {
+		t.Errorf("unexpected requests:\n%s", diff)
+	}
+	if completed != 3 {
+		t.Errorf("expected the session to be completed with 3 parts, got %d", completed)
+	}
+}
+
+func TestUploadParts(t *testing.T) {
+	a := &api.DataReading{DataGatherer: "a", Data: strings.Repeat("x", 400)}
+	b := &api.DataReading{DataGatherer: "b", Data: strings.Repeat("x", 400)}
+	c := &api.DataReading{DataGatherer: "c", Data: strings.Repeat("x", 100)}
+
+	parts, err := uploadParts(Config{}, []*api.DataReading{a, b, c})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff([][]*api.DataReading{{a}, {b}, {c}}, parts); !equal {
+		t.Errorf("unexpected parts:\n%s", diff)
+	}
+
+	parts, err = uploadParts(Config{MaxPayloadBytes: 1000}, []*api.DataReading{a, b, c})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff([][]*api.DataReading{{a, b}, {c}}, parts); !equal {
+		t.Errorf("unexpected parts:\n%s", diff)
+	}
+}
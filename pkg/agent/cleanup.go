@@ -0,0 +1,146 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultCleanupMaxAge   = time.Hour
+	defaultCleanupInterval = time.Hour
+)
+
+// defaultCleanupPatterns match the temporary files the agent writes before
+// renaming them over their destination, which are left behind if it crashes.
+var defaultCleanupPatterns = []string{"*.tmp"}
+
+// CleanupConfig configures the removal of the files left behind by previous
+// runs of the agent, e.g. while it is crash looping, so that they don't fill
+// the ephemeral storage of the pod.
+type CleanupConfig struct {
+	// Directories are cleaned up, along with the directory of the state
+	// file, including their subdirectories.
+	Directories []string `yaml:"directories,omitempty"`
+	// Patterns are the shell patterns of the names of the files to remove,
+	// e.g. kubeconfig-*. Defaults to *.tmp.
+	Patterns []string `yaml:"patterns,omitempty"`
+	// MaxAge is how long after their last modification files are removed,
+	// so that those in use are kept. Defaults to 1h.
+	MaxAge time.Duration `yaml:"max-age,omitempty"`
+	// Interval is the time between cleanups after the one at startup.
+	// Defaults to 1h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+func (c *CleanupConfig) validate() error {
+	for _, pattern := range c.Patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("cleanup.patterns: invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cleanup.max-age must not be negative")
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("cleanup.interval must not be negative")
+	}
+	return nil
+}
+
+// cleaner removes stale files from directories.
+type cleaner struct {
+	directories []string
+	patterns    []string
+	maxAge      time.Duration
+	now         func() time.Time
+}
+
+// newCleaner creates a cleaner for config, also cleaning up the directory of
+// the state file if there is one.
+func newCleaner(config CleanupConfig, stateFilePath string) *cleaner {
+	c := &cleaner{
+		directories: config.Directories,
+		patterns:    config.Patterns,
+		maxAge:      config.MaxAge,
+		now:         time.Now,
+	}
+	if stateFilePath != "" {
+		c.directories = append([]string{filepath.Dir(stateFilePath)}, c.directories...)
+	}
+	if len(c.patterns) == 0 {
+		c.patterns = defaultCleanupPatterns
+	}
+	if c.maxAge == 0 {
+		c.maxAge = defaultCleanupMaxAge
+	}
+	return c
+}
+
+// run cleans up every interval until ctx is cancelled.
+func (c *cleaner) run(ctx context.Context, interval time.Duration) {
+	if interval == 0 {
+		interval = defaultCleanupInterval
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			c.clean()
+		}
+	}
+}
+
+// clean removes the files matching the patterns that were last modified more
+// than maxAge ago. Directories that don't exist are skipped.
+func (c *cleaner) clean() {
+	removed, size := 0, int64(0)
+	for _, dir := range c.directories {
+		err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if !entry.Type().IsRegular() || !c.matches(entry.Name()) {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return nil
+			}
+			if c.now().Sub(info.ModTime()) < c.maxAge {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				log.Printf("failed to remove stale file %s: %s", path, err)
+				return nil
+			}
+			removed++
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			log.Printf("failed to clean up %s: %s", dir, err)
+		}
+	}
+	if removed > 0 {
+		log.Printf("removed %d stale files (%d bytes) left behind by previous runs", removed, size)
+	}
+}
+
+func (c *cleaner) matches(name string) bool {
+	for _, pattern := range c.patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestCleaner(t *testing.T) {
+	stateDir := t.TempDir()
+	spoolDir := t.TempDir()
+	now := time.Now()
+
+	files := map[string]time.Duration{
+		filepath.Join(stateDir, "state.json"):            2 * time.Hour,
+		filepath.Join(stateDir, "state.json.tmp"):        2 * time.Hour,
+		filepath.Join(stateDir, "recent.tmp"):            time.Minute,
+		filepath.Join(spoolDir, "kubeconfig-123"):        2 * time.Hour,
+		filepath.Join(spoolDir, "nested", "old.tmp"):     3 * time.Hour,
+		filepath.Join(spoolDir, "nested", "readings.db"): 3 * time.Hour,
+	}
+	for path, age := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	c := newCleaner(CleanupConfig{
+		Directories: []string{spoolDir, filepath.Join(spoolDir, "missing")},
+		Patterns:    []string{"*.tmp", "kubeconfig-*"},
+	}, filepath.Join(stateDir, "state.json"))
+	c.clean()
+
+	var remaining []string
+	for path := range files {
+		if _, err := os.Stat(path); err == nil {
+			remaining = append(remaining, path)
+		}
+	}
+	sort.Strings(remaining)
+	expected := []string{
+		filepath.Join(spoolDir, "nested", "readings.db"),
+		filepath.Join(stateDir, "recent.tmp"),
+		filepath.Join(stateDir, "state.json"),
+	}
+	sort.Strings(expected)
+	if diff, equal := messagediff.PrettyDiff(expected, remaining); !equal {
+		t.Errorf("unexpected remaining files:\n%s", diff)
+	}
+}
+
+func TestCleanupConfigValidate(t *testing.T) {
+	if err := (&CleanupConfig{Patterns: []string{"[a-"}}).validate(); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+	if err := (&CleanupConfig{Patterns: []string{"*.tmp"}, MaxAge: time.Hour}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// cloudProviderMixed is the cloud provider of clusters whose nodes have
+// several providers.
+const cloudProviderMixed = "mixed"
+
+// enrichAgentMetadata attaches the metadata of the cluster the agent runs in
+// to the agent metadata sent with the readings. The metadata of the previous
+// cycle is kept if it can't be gathered.
+func enrichAgentMetadata(ctx context.Context, config Config, agentMetadata *api.AgentMetadata) {
+	clientset, err := k8s.NewClientSet(ctx, "")
+	if err != nil {
+		log.Printf("failed to gather the cluster metadata: %s", err)
+		return
+	}
+	metadata, err := gatherClusterMetadata(ctx, clientset, config.Labels)
+	if err != nil {
+		log.Printf("failed to gather the cluster metadata: %s", err)
+		return
+	}
+	agentMetadata.Cluster = metadata
+}
+
+// gatherClusterMetadata returns the fingerprint, version, cloud provider and
+// size of the cluster, with the labels of the agent.
+func gatherClusterMetadata(ctx context.Context, clientset kubernetes.Interface, labels map[string]string) (*api.ClusterMetadata, error) {
+	namespace, err := clientset.CoreV1().Namespaces().Get(ctx, "kube-system", metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the kube-system namespace: %w", err)
+	}
+	version, err := clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the Kubernetes version: %w", err)
+	}
+	// served from the cache of the API server, the count doesn't need to
+	// be exact
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var providerIDs []string
+	for _, node := range nodes.Items {
+		providerIDs = append(providerIDs, node.Spec.ProviderID)
+	}
+	return &api.ClusterMetadata{
+		UID:               string(namespace.UID),
+		KubernetesVersion: version.GitVersion,
+		CloudProvider:     cloudProvider(providerIDs),
+		NodeCount:         len(nodes.Items),
+		Labels:            labels,
+		GatheredAt:        api.Time{Time: time.Now()},
+	}, nil
+}
+
+// cloudProvider returns the provider of the provider IDs of the nodes, the
+// scheme of the IDs, e.g. aws for aws:///eu-west-1a/i-0123. Nodes without a
+// provider ID are ignored.
+func cloudProvider(providerIDs []string) string {
+	provider := ""
+	for _, id := range providerIDs {
+		scheme, _, ok := strings.Cut(id, "://")
+		if !ok || scheme == "" {
+			continue
+		}
+		if provider != "" && provider != scheme {
+			return cloudProviderMixed
+		}
+		provider = scheme
+	}
+	return provider
+}
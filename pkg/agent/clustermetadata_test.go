@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestGatherClusterMetadata(t *testing.T) {
+	node := func(name, providerID string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: corev1.NodeSpec{ProviderID: providerID}}
+	}
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system", UID: "6c4a1f0e-0bd2-4d1c-9a36-4b1b7a1e2f3c"}},
+		node("node-1", "aws:///eu-west-1a/i-0123"),
+		node("node-2", "aws:///eu-west-1b/i-4567"),
+	)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: "v1.29.2"}
+
+	metadata, err := gatherClusterMetadata(context.Background(), clientset, map[string]string{"team": "platform"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &api.ClusterMetadata{
+		UID:               "6c4a1f0e-0bd2-4d1c-9a36-4b1b7a1e2f3c",
+		KubernetesVersion: "v1.29.2",
+		CloudProvider:     "aws",
+		NodeCount:         2,
+		Labels:            map[string]string{"team": "platform"},
+		GatheredAt:        metadata.GatheredAt,
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, metadata); !equal {
+		t.Errorf("unexpected metadata:\n%s", diff)
+	}
+
+	// the kube-system namespace is required to identify the cluster
+	if _, err := gatherClusterMetadata(context.Background(), fake.NewSimpleClientset(), nil); err == nil {
+		t.Errorf("expected an error without a kube-system namespace")
+	}
+}
+
+func TestCloudProvider(t *testing.T) {
+	tests := map[string]struct {
+		providerIDs []string
+		expected    string
+	}{
+		"no nodes":                {nil, ""},
+		"no provider ids":         {[]string{"", ""}, ""},
+		"single provider":         {[]string{"gce://project/europe-west1-b/node-1", ""}, "gce"},
+		"several providers":       {[]string{"azure:///subscriptions/0/node-1", "aws:///eu-west-1a/i-0123"}, cloudProviderMixed},
+		"provider id not a url":   {[]string{"i-0123"}, ""},
+		"kind nodes are reported": {[]string{"kind://docker/kind/kind-control-plane"}, "kind"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := cloudProvider(test.providerIDs); got != test.expected {
+				t.Errorf("got=%q want=%q", got, test.expected)
+			}
+		})
+	}
+}
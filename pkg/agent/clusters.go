@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// ClusterConfig is a cluster the agent gathers data from, so that an agent
+// deployed in a management cluster can report on many workload clusters.
+type ClusterConfig struct {
+	// Name identifies the cluster in the clusters of the data gatherers,
+	// and is the cluster_id of its readings.
+	Name string `yaml:"name"`
+	// Kubeconfig is the path to the kubeconfig file of the cluster. If
+	// empty, the default loading rules are used, e.g. for the cluster the
+	// agent runs in.
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	// Context is the context of the kubeconfig. Defaults to the current
+	// context.
+	Context string `yaml:"context,omitempty"`
+}
+
+// validateClusters checks that the clusters have unique names, and that the
+// data gatherers only target configured clusters.
+func validateClusters(clusters []ClusterConfig, dataGatherers []DataGatherer) error {
+	var result *multierror.Error
+
+	names := map[string]bool{}
+	for i, cluster := range clusters {
+		if cluster.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("cluster %d/%d is missing a name", i+1, len(clusters)))
+			continue
+		}
+		if names[cluster.Name] {
+			result = multierror.Append(result, fmt.Errorf("cluster %q is configured more than once", cluster.Name))
+		}
+		names[cluster.Name] = true
+	}
+
+	for _, dg := range dataGatherers {
+		if len(dg.Clusters) == 0 {
+			continue
+		}
+		if len(clusters) == 0 {
+			result = multierror.Append(result, fmt.Errorf("datagatherer %q: clusters can only be set when clusters are configured", dg.Name))
+			continue
+		}
+		if !clusterSpecific(dg.Kind) {
+			result = multierror.Append(result, fmt.Errorf("datagatherer %q: clusters cannot be set for %s data gatherers", dg.Name, dg.Kind))
+		}
+		for _, name := range dg.Clusters {
+			if !names[name] {
+				result = multierror.Append(result, fmt.Errorf("datagatherer %q: cluster %q is not configured", dg.Name, name))
+			}
+		}
+	}
+
+	return result.ErrorOrNil()
+}
+
+// expandClusters returns a data gatherer for each of the clusters targeted by
+// each data gatherer, all the clusters unless it sets clusters. The data
+// gatherers that are not specific to a cluster are returned as they are.
+func expandClusters(clusters []ClusterConfig, dataGatherers []DataGatherer) []DataGatherer {
+	if len(clusters) == 0 {
+		return dataGatherers
+	}
+
+	var result []DataGatherer
+	for _, dg := range dataGatherers {
+		if !clusterSpecific(dg.Kind) {
+			result = append(result, dg)
+			continue
+		}
+		for i := range clusters {
+			if len(dg.Clusters) > 0 && !containsString(dg.Clusters, clusters[i].Name) {
+				continue
+			}
+			clusterDg := dg
+			clusterDg.Cluster = &clusters[i]
+			result = append(result, clusterDg)
+		}
+	}
+	return result
+}
+
+// clusterSpecific returns whether the data gatherers of the kind gather data
+// from a cluster, unlike the local, exec, plugin, http, prometheus,
+// cloud-certificates, agent and venafi-policy data gatherers.
+func clusterSpecific(kind string) bool {
+	switch kind {
+	case "local", "exec", "plugin", "http", "prometheus", "cloud-certificates", "agent", "venafi-policy":
+		return false
+	}
+	return true
+}
+
+// key identifies the data gatherer among those of the agent, which have the
+// same name for each cluster they target.
+func (dg DataGatherer) key() string {
+	if dg.Cluster == nil {
+		return dg.Name
+	}
+	return dg.Cluster.Name + "/" + dg.Name
+}
+
+// dataGathererContext returns the context a data gatherer is created in,
+// with its rate limit and cluster, if any.
+func dataGathererContext(ctx context.Context, dg DataGatherer) context.Context {
+	if dg.RateLimit != nil {
+		ctx = k8s.WithRateLimit(ctx, dg.key(), *dg.RateLimit)
+	}
+	if dg.Cluster != nil {
+		ctx = k8s.WithCluster(ctx, k8s.Cluster{KubeconfigPath: dg.Cluster.Kubeconfig, Context: dg.Cluster.Context})
+	}
+	return ctx
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
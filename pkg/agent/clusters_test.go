@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestClustersConfig(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "management"
+      clusters:
+      - name: management
+      - name: workload-1
+        kubeconfig: /etc/kubeconfigs/workloads
+        context: workload-1
+      - name: workload-2
+        kubeconfig: /etc/kubeconfigs/workloads
+        context: workload-2
+      data-gatherers:
+      - name: d1
+        kind: dummy
+      - name: d2
+        kind: dummy
+        clusters: [workload-2]
+`), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the data gatherers run once per cluster they target
+	var keys []string
+	for _, dg := range config.DataGatherers {
+		keys = append(keys, dg.key())
+	}
+	expected := []string{"management/d1", "workload-1/d1", "workload-2/d1", "workload-2/d2"}
+	if diff, equal := messagediff.PrettyDiff(expected, keys); !equal {
+		t.Errorf("unexpected data gatherers:\n%s", diff)
+	}
+
+	// and their readings are tagged with the cluster
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for _, dg := range config.DataGatherers {
+		dataGatherers[dg.key()] = &dummyDataGatherer{}
+	}
+	var readings []string
+	for _, reading := range gatherData(context.Background(), config, dataGatherers) {
+		readings = append(readings, reading.ClusterID+" "+reading.DataGatherer)
+	}
+	sort.Strings(readings)
+	expected = []string{"management d1", "workload-1 d1", "workload-2 d1", "workload-2 d2"}
+	if diff, equal := messagediff.PrettyDiff(expected, readings); !equal {
+		t.Errorf("unexpected readings:\n%s", diff)
+	}
+}
+
+func TestInvalidClustersError(t *testing.T) {
+	_, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      clusters:
+      - name: workload
+      - name: workload
+      - kubeconfig: /etc/kubeconfig
+      data-gatherers:
+      - name: d1
+        kind: dummy
+        clusters: [unknown]
+`), false)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{
+		`cluster "workload" is configured more than once`,
+		"cluster 3/3 is missing a name",
+		`datagatherer "d1": cluster "unknown" is not configured`,
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, err)
+		}
+	}
+
+	_, err = ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      data-gatherers:
+      - name: d1
+        kind: dummy
+        clusters: [workload]
+`), false)
+	if err == nil || !strings.Contains(err.Error(), "clusters can only be set when clusters are configured") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
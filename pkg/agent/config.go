@@ -3,13 +3,18 @@ package agent
 import (
 	"fmt"
 	"net/url"
+	"reflect"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/jetstack/preflight/pkg/client"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/cloudcerts"
+	"github.com/jetstack/preflight/pkg/datagatherer/endpoint"
 	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
 	"github.com/jetstack/preflight/pkg/datagatherer/local"
+	"github.com/jetstack/preflight/pkg/datagatherer/venafi"
+	"github.com/jetstack/preflight/pkg/secrets"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
@@ -32,8 +37,122 @@ type Config struct {
 	// InputPath replaces DataGatherers with input data file
 	InputPath string `yaml:"input-path"`
 	// OutputPath replaces Server with output data file
-	OutputPath  string             `yaml:"output-path"`
-	VenafiCloud *VenafiCloudConfig `yaml:"venafi-cloud,omitempty"`
+	OutputPath string `yaml:"output-path"`
+	// OutputEncryption, if set, encrypts the data written to the output
+	// path.
+	OutputEncryption *OutputEncryptionConfig `yaml:"output-encryption,omitempty"`
+	VenafiCloud      *VenafiCloudConfig      `yaml:"venafi-cloud,omitempty"`
+	// OpenShift adds data gatherers for OpenShift Routes, ClusterOperators
+	// and ClusterVersions. They are no-ops on clusters without those APIs.
+	OpenShift bool `yaml:"openshift"`
+	// Onboarding, if set, enables data gatherers and namespaces
+	// progressively over several cycles.
+	Onboarding *OnboardingConfig `yaml:"onboarding,omitempty"`
+	// RateLimit, if set, limits the requests made to the Kubernetes API by
+	// all data gatherers together.
+	RateLimit *k8s.RateLimit `yaml:"rate-limit,omitempty"`
+	// Attestation, if set, writes an in-toto attestation of the provenance
+	// of each reading.
+	Attestation *AttestationConfig `yaml:"attestation,omitempty"`
+	// SQLite, if set, loads the readings of each cycle into a local SQLite
+	// database.
+	SQLite *SQLiteConfig `yaml:"sqlite,omitempty"`
+	// ResourceLimits, if set, caps the resources used by the agent itself.
+	ResourceLimits *ResourceLimitsConfig `yaml:"resource-limits,omitempty"`
+	// Timezone is the name of the time zone of the timestamps of the logs
+	// and reports, e.g. Europe/London. Defaults to the local time zone.
+	Timezone string `yaml:"timezone,omitempty"`
+	// Secrets configures the secret managers credentials can be loaded
+	// from, see --api-token.
+	Secrets *secrets.Config `yaml:"secrets,omitempty"`
+	// ConfigPush, if set, enables an endpoint the backend can push signed
+	// configurations to.
+	ConfigPush *ConfigPushConfig `yaml:"config-push,omitempty"`
+	// Mirror, if set, uploads the data to a second backend as well.
+	Mirror *MirrorConfig `yaml:"mirror,omitempty"`
+	// WorkloadIdentity, if set, authenticates to the server by exchanging
+	// a workload identity token for an access token.
+	WorkloadIdentity *WorkloadIdentityConfig `yaml:"workload-identity,omitempty"`
+	// Labels are attached to every reading and to the labels metric, so
+	// that the data can be grouped by business dimensions, e.g. team,
+	// environment or cost-center.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// PayloadSigning, if set, signs the payloads uploaded to the server and
+	// the mirror.
+	PayloadSigning *PayloadSigningConfig `yaml:"payload-signing,omitempty"`
+	// MaxPayloadBytes, if set, limits the size of the readings uploaded in
+	// a single request, see OversizedPayload.
+	MaxPayloadBytes int64 `yaml:"max-payload-bytes,omitempty"`
+	// OversizedPayload is what is done with readings exceeding
+	// MaxPayloadBytes: chunk, which requires ChunkedUpload, uploads them in
+	// several parts of the upload session, and drop drops the largest
+	// readings. It defaults to chunk with ChunkedUpload and to drop
+	// otherwise.
+	OversizedPayload string `yaml:"oversized-payload,omitempty"`
+	// MaxUploadBandwidth, if set, limits the upload bandwidth to the server
+	// and the mirror, in bytes per second.
+	MaxUploadBandwidth int64 `yaml:"max-upload-bandwidth,omitempty"`
+	// ChunkedUpload, if set, uploads the readings in an upload session,
+	// one part per reading, so that a failed part is retried on its own.
+	// It is not supported by the Venafi Cloud API.
+	ChunkedUpload bool `yaml:"chunked-upload,omitempty"`
+	// Backend is the protocol the readings are uploaded to the server with:
+	// http, the default, grpc, which streams them over gRPC, or nats or
+	// kafka, which publish them to a NATS JetStream subject or Kafka topics
+	// instead.
+	Backend string `yaml:"backend,omitempty"`
+	// GRPC configures the grpc backend.
+	GRPC *GRPCConfig `yaml:"grpc,omitempty"`
+	// NATS configures the nats backend.
+	NATS *NATSConfig `yaml:"nats,omitempty"`
+	// Kafka configures the kafka backend.
+	Kafka *KafkaConfig `yaml:"kafka,omitempty"`
+	// SchemaVersion, if set, is the version of the schema of the uploaded
+	// payloads, so that the agent can upload to a backend that doesn't
+	// ingest the latest version yet. Defaults to the latest.
+	SchemaVersion string `yaml:"schema-version,omitempty"`
+	// Cleanup, if set, removes the files left behind by previous runs at
+	// startup and periodically.
+	Cleanup *CleanupConfig `yaml:"cleanup,omitempty"`
+	// Spool, if set, writes the readings that failed to upload to disk, and
+	// uploads them once the server is reachable again.
+	Spool *SpoolConfig `yaml:"spool,omitempty"`
+	// Clusters, if set, are the clusters the data gatherers gather data
+	// from, rather than the cluster of their kubeconfig. Each data gatherer
+	// runs once per cluster it targets.
+	Clusters []ClusterConfig `yaml:"clusters,omitempty"`
+	// ClusterMetadata, if set, sends the metadata of the cluster the agent
+	// runs in with each upload: the UID of the kube-system namespace, the
+	// Kubernetes version, the cloud provider, the number of nodes and the
+	// labels.
+	ClusterMetadata bool `yaml:"cluster-metadata,omitempty"`
+	// Policies, if set, are evaluated on the gathered resources before they
+	// are uploaded, and their results uploaded with, or instead of, the
+	// data.
+	Policies *PoliciesConfig `yaml:"policies,omitempty"`
+	// ReportMode is full, the default, to upload the gathered data, or
+	// findings, to only upload results derived from it, so that the raw
+	// objects never leave the cluster.
+	ReportMode string `yaml:"report-mode,omitempty"`
+	// StatusConfigMap, if set, is the ConfigMap the agent writes its status
+	// to after each cycle, so that its health can be checked with kubectl.
+	StatusConfigMap *StatusConfigMapConfig `yaml:"status-configmap,omitempty"`
+	// Events, if set, enables the Kubernetes Events emitted when data
+	// gatherers fail repeatedly or uploads fail.
+	Events *EventsConfig `yaml:"events,omitempty"`
+	// Notifications, if set, posts a summary of the findings of each cycle
+	// to webhooks.
+	Notifications *NotificationsConfig `yaml:"notifications,omitempty"`
+	// Alerts, if set, are rules evaluated on the results of the data
+	// gatherers each cycle, which emit findings, events and metrics when
+	// they trigger.
+	Alerts *AlertsConfig `yaml:"alerts,omitempty"`
+	// Tracing, if set, exports OpenTelemetry traces of the cycles of the
+	// agent.
+	Tracing *TracingConfig `yaml:"tracing,omitempty"`
+	// MaxConcurrentGatherers is the number of data gatherers fetched at the
+	// same time. Defaults to 1.
+	MaxConcurrentGatherers int `yaml:"max-concurrent-gatherers,omitempty"`
 }
 
 type Endpoint struct {
@@ -46,7 +165,19 @@ type DataGatherer struct {
 	Kind     string `yaml:"kind"`
 	Name     string `yaml:"name"`
 	DataPath string `yaml:"data_path"`
-	Config   datagatherer.Config
+	// RateLimit, if set, limits the requests made to the Kubernetes API by
+	// this data gatherer, instead of the global rate limit.
+	RateLimit *k8s.RateLimit `yaml:"rate-limit,omitempty"`
+	// Clusters, if set, are the names of the clusters the data gatherer
+	// targets, rather than all the configured clusters.
+	Clusters []string `yaml:"clusters,omitempty"`
+	// DependsOn are the names of the data gatherers fetched before this
+	// one. It is skipped if one of them fails.
+	DependsOn []string `yaml:"depends-on,omitempty"`
+	// Cluster is the cluster the data gatherer gathers data from, set when
+	// the data gatherers are expanded for each of their clusters.
+	Cluster *ClusterConfig `yaml:"-"`
+	Config  datagatherer.Config
 }
 
 type VenafiCloudConfig struct {
@@ -57,63 +188,132 @@ type VenafiCloudConfig struct {
 	UploadPath string `yaml:"upload_path,omitempty"`
 }
 
-func reMarshal(rawConfig interface{}, config datagatherer.Config) error {
-	bb, err := yaml.Marshal(rawConfig)
-	if err != nil {
-		return nil
-	}
-
-	err = yaml.Unmarshal(bb, config)
-	if err != nil {
-		return nil
-	}
-
-	return nil
-}
-
 // UnmarshalYAML unmarshals a dataGatherer resolving the type according to Kind.
 func (dg *DataGatherer) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	aux := struct {
-		Kind      string      `yaml:"kind"`
-		Name      string      `yaml:"name"`
-		DataPath  string      `yaml:"data-path,omitempty"`
-		RawConfig interface{} `yaml:"config"`
+		// Kind is a node for the line number of errors
+		Kind      yaml.Node      `yaml:"kind"`
+		Name      string         `yaml:"name"`
+		DataPath  string         `yaml:"data-path,omitempty"`
+		RateLimit *k8s.RateLimit `yaml:"rate-limit,omitempty"`
+		Clusters  []string       `yaml:"clusters,omitempty"`
+		DependsOn []string       `yaml:"depends-on,omitempty"`
+		RawConfig yaml.Node      `yaml:"config"`
 	}{}
 	err := unmarshal(&aux)
 	if err != nil {
 		return err
 	}
 
-	dg.Kind = aux.Kind
+	dg.Kind = aux.Kind.Value
 	dg.Name = aux.Name
 	dg.DataPath = aux.DataPath
+	dg.RateLimit = aux.RateLimit
+	dg.Clusters = aux.Clusters
+	dg.DependsOn = aux.DependsOn
 
-	var cfg datagatherer.Config
+	cfg := newDataGathererConfig(dg.Kind)
+	if cfg == nil {
+		// a type error lets the decoder carry on and report the errors of
+		// the other data gatherers too
+		msg := fmt.Sprintf("cannot parse data-gatherer configuration, kind %q is not supported", dg.Kind)
+		if aux.Kind.Line > 0 {
+			msg = fmt.Sprintf("line %d: %s", aux.Kind.Line, msg)
+		}
+		return &yaml.TypeError{Errors: []string{msg}}
+	}
 
-	switch dg.Kind {
+	// the config is decoded by the decoder of the whole file, rather than
+	// re-encoded, so that its errors have line numbers and unknown fields
+	// are reported when decoding strictly
+	wrapper := reflect.New(reflect.StructOf([]reflect.StructField{
+		{Name: "Config", Type: reflect.TypeOf(cfg), Tag: `yaml:"config"`},
+		{Name: "Rest", Type: reflect.TypeOf(map[string]interface{}{}), Tag: `yaml:",inline"`},
+	}))
+	wrapper.Elem().Field(0).Set(reflect.ValueOf(cfg))
+	if err := unmarshal(wrapper.Interface()); err != nil {
+		return err
+	}
+
+	dg.Config = cfg
+
+	return nil
+}
+
+// configValidator is implemented by the data gatherer configurations that
+// can be checked when the agent config is parsed.
+type configValidator interface {
+	Validate() error
+}
+
+// newDataGathererConfig returns an empty configuration for the data gatherer
+// kind, or nil if the kind is not supported.
+func newDataGathererConfig(kind string) datagatherer.Config {
+	switch kind {
 	case "k8s":
-		cfg = &k8s.ConfigDynamic{}
+		return &k8s.ConfigDynamic{}
 	case "k8s-dynamic":
-		cfg = &k8s.ConfigDynamic{}
+		return &k8s.ConfigDynamic{}
 	case "k8s-discovery":
-		cfg = &k8s.ConfigDiscovery{}
+		return &k8s.ConfigDiscovery{}
+	case "k8s-cert-manager":
+		return &k8s.ConfigCertManager{}
+	case "k8s-rbac":
+		return &k8s.ConfigRBAC{}
+	case "k8s-webhooks":
+		return &k8s.ConfigWebhooks{}
+	case "k8s-key-hygiene":
+		return &k8s.ConfigKeyHygiene{}
+	case "k8s-ingress-tls-policy":
+		return &k8s.ConfigIngressTLSPolicy{}
+	case "k8s-cert-manager-logs":
+		return &k8s.ConfigCertManagerLogs{}
+	case "k8s-encryption-at-rest":
+		return &k8s.ConfigEncryptionAtRest{}
+	case "k8s-helm-releases":
+		return &k8s.ConfigHelmReleases{}
+	case "k8s-crds":
+		return &k8s.ConfigCRDs{}
+	case "k8s-api-deprecations":
+		return &k8s.ConfigAPIDeprecations{}
+	case "k8s-istio":
+		return &k8s.ConfigIstio{}
+	case "k8s-ingress-tls":
+		return &k8s.ConfigIngressTLS{}
+	case "k8s-tls-probe":
+		return &k8s.ConfigTLSProbe{}
+	case "k8s-issuer-health":
+		return &k8s.ConfigIssuerHealth{}
+	case "k8s-issuance":
+		return &k8s.ConfigIssuance{}
+	case "k8s-cert-manager-events":
+		return &k8s.ConfigCertManagerEvents{}
+	case "k8s-pod-security":
+		return &k8s.ConfigPodSecurity{}
+	case "k8s-resource-counts":
+		return &k8s.ConfigResourceCounts{}
+	case "k8s-control-plane-certificates":
+		return &k8s.ConfigControlPlaneCertificates{}
 	case "local":
-		cfg = &local.Config{}
+		return &local.Config{}
+	case "exec":
+		return &local.ExecConfig{}
+	case "plugin":
+		return &local.PluginConfig{}
+	case "http":
+		return &endpoint.Config{}
+	case "prometheus":
+		return &endpoint.PrometheusConfig{}
+	case "cloud-certificates":
+		return &cloudcerts.Config{}
+	case "venafi-policy":
+		return &venafi.Config{}
+	case "agent":
+		return &selfReportConfig{}
 	// dummy dataGatherer is just used for testing
 	case "dummy":
-		cfg = &dummyConfig{}
-	default:
-		return fmt.Errorf("cannot parse data-gatherer configuration, kind %q is not supported", dg.Kind)
-	}
-
-	// we encode aux.RawConfig, which is just a map of reflect.Values, into yaml and decode it again to the right type.
-	err = reMarshal(aux.RawConfig, cfg)
-	if err != nil {
-		return err
+		return &dummyConfig{}
 	}
-
-	dg.Config = cfg
-
 	return nil
 }
 
@@ -128,6 +328,25 @@ func (c *Config) Dump() (string, error) {
 	return string(d), nil
 }
 
+// setDefaults sets the server, the endpoint protocol and the OpenShift data
+// gatherers if they are not configured.
+func (c *Config) setDefaults(isVenafiCloudMode bool) {
+	if c.Server == "" && c.Endpoint.Host == "" && c.Endpoint.Path == "" {
+		c.Server = "https://preflight.jetstack.io"
+		if c.VenafiCloud != nil || isVenafiCloudMode {
+			c.Server = client.VenafiCloudProdURL
+		}
+	}
+
+	if c.Endpoint.Protocol == "" && c.Server == "" {
+		c.Endpoint.Protocol = "http"
+	}
+
+	if c.OpenShift {
+		c.DataGatherers = addOpenShiftDataGatherers(c.DataGatherers)
+	}
+}
+
 func (c *Config) validate(isVenafiCloudMode bool) error {
 	var result *multierror.Error
 
@@ -162,6 +381,162 @@ func (c *Config) validate(isVenafiCloudMode bool) error {
 		if v.Name == "" {
 			result = multierror.Append(result, fmt.Errorf("datagatherer %d/%d is missing a name", i+1, len(c.DataGatherers)))
 		}
+		if v.RateLimit != nil {
+			if err := v.RateLimit.Validate(); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datagatherer %q: %s", v.Name, err))
+			}
+		}
+		if validator, ok := v.Config.(configValidator); ok {
+			if err := validator.Validate(); err != nil {
+				result = multierror.Append(result, fmt.Errorf("datagatherer %q: %s", v.Name, err))
+			}
+		}
+	}
+
+	if err := validateDependencies(c.DataGatherers); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if c.MaxConcurrentGatherers < 0 {
+		result = multierror.Append(result, fmt.Errorf("max-concurrent-gatherers must not be negative"))
+	}
+
+	if c.RateLimit != nil {
+		if err := c.RateLimit.Validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Timezone != "" {
+		if _, err := time.LoadLocation(c.Timezone); err != nil {
+			result = multierror.Append(result, fmt.Errorf("timezone %q is not valid: %s", c.Timezone, err))
+		}
+	}
+
+	if c.SQLite != nil {
+		if err := c.SQLite.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.ResourceLimits != nil {
+		if err := c.ResourceLimits.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Secrets != nil {
+		if err := c.Secrets.Validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.ConfigPush != nil {
+		if err := c.ConfigPush.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Mirror != nil {
+		if err := c.Mirror.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.WorkloadIdentity != nil {
+		if err := c.WorkloadIdentity.validate("workload-identity"); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Onboarding != nil {
+		if err := c.Onboarding.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.OutputEncryption != nil {
+		if err := c.OutputEncryption.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.PayloadSigning != nil {
+		if err := c.PayloadSigning.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if err := c.validateUploadLimits(); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if c.ChunkedUpload && (c.VenafiCloud != nil || isVenafiCloudMode) {
+		result = multierror.Append(result, fmt.Errorf("chunked-upload is not supported in Venafi Cloud mode"))
+	}
+	if err := c.validateBackend(isVenafiCloudMode); err != nil {
+		result = multierror.Append(result, err)
+	}
+	if err := c.validateSchemaVersion(); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if err := validateLabels(c.Labels); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if c.Cleanup != nil {
+		if err := c.Cleanup.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Spool != nil {
+		if err := c.Spool.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Policies != nil {
+		if err := c.Policies.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if err := validateReportMode(c.ReportMode); err != nil {
+		result = multierror.Append(result, err)
+	}
+
+	if c.StatusConfigMap != nil {
+		if err := c.StatusConfigMap.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Events != nil {
+		if err := c.Events.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Notifications != nil {
+		if err := c.Notifications.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Alerts != nil {
+		if err := c.Alerts.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if c.Tracing != nil {
+		if err := c.Tracing.validate(); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+
+	if err := validateClusters(c.Clusters, c.DataGatherers); err != nil {
+		result = multierror.Append(result, err)
 	}
 
 	return result.ErrorOrNil()
@@ -176,21 +551,14 @@ func ParseConfig(data []byte, isVenafiCloudMode bool) (Config, error) {
 		return config, err
 	}
 
-	if config.Server == "" && config.Endpoint.Host == "" && config.Endpoint.Path == "" {
-		config.Server = "https://preflight.jetstack.io"
-		if config.VenafiCloud != nil || isVenafiCloudMode {
-			config.Server = client.VenafiCloudProdURL
-		}
-	}
-
-	if config.Endpoint.Protocol == "" && config.Server == "" {
-		config.Endpoint.Protocol = "http"
-	}
+	config.setDefaults(isVenafiCloudMode)
 
 	err = config.validate(isVenafiCloudMode)
 	if err != nil {
 		return config, err
 	}
 
+	config.DataGatherers = expandClusters(config.Clusters, config.DataGatherers)
+
 	return config, nil
 }
@@ -8,6 +8,8 @@ import (
 
 	"github.com/kylelemons/godebug/diff"
 	"gopkg.in/d4l3k/messagediff.v1"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
 )
 
 func TestValidConfigLoad(t *testing.T) {
@@ -248,6 +250,129 @@ func TestInvalidServerError(t *testing.T) {
 	}
 }
 
+func TestInvalidNamespacePatternError(t *testing.T) {
+	_, parseError := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "my_org"
+      cluster_id: "my_cluster"
+      data-gatherers:
+        - kind: k8s-dynamic
+          name: k8s/pods
+          config:
+            resource-type:
+              version: v1
+              resource: pods
+            include-namespaces:
+            - "team-["`), false)
+
+	if parseError == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	expectedErrorLines := []string{
+		"1 error occurred:",
+		"\t* datagatherer \"k8s/pods\": invalid configuration: invalid namespace glob pattern \"team-[\": syntax error in pattern",
+		"\n",
+	}
+
+	expectedError := strings.Join(expectedErrorLines, "\n")
+
+	gotError := parseError.Error()
+
+	if gotError != expectedError {
+		t.Errorf("\ngot=\n%v\nwant=\n%s\ndiff=\n%s", gotError, expectedError, diff.Diff(gotError, expectedError))
+	}
+}
+
+func TestRateLimitConfigLoad(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "my_org"
+      cluster_id: "my_cluster"
+      rate-limit:
+        qps: 20
+        burst: 40
+      data-gatherers:
+        - kind: k8s-dynamic
+          name: k8s/pods
+          rate-limit:
+            qps: 2
+          config:
+            resource-type:
+              version: v1
+              resource: pods`), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if diff, equal := messagediff.PrettyDiff(&k8s.RateLimit{QPS: 20, Burst: 40}, config.RateLimit); !equal {
+		t.Errorf("unexpected rate limit:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(&k8s.RateLimit{QPS: 2}, config.DataGatherers[0].RateLimit); !equal {
+		t.Errorf("unexpected data gatherer rate limit:\n%s", diff)
+	}
+}
+
+func TestInvalidRateLimitError(t *testing.T) {
+	_, parseError := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "my_org"
+      cluster_id: "my_cluster"
+      rate-limit:
+        qps: 0
+      data-gatherers:
+        - kind: dummy
+          name: dummy
+          rate-limit:
+            qps: 1
+            burst: -1`), false)
+
+	if parseError == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	expectedErrorLines := []string{
+		"2 errors occurred:",
+		"\t* datagatherer \"dummy\": rate-limit.burst must not be negative",
+		"\t* rate-limit.qps must be positive",
+		"\n",
+	}
+
+	expectedError := strings.Join(expectedErrorLines, "\n")
+
+	gotError := parseError.Error()
+
+	if gotError != expectedError {
+		t.Errorf("\ngot=\n%v\nwant=\n%s\ndiff=\n%s", gotError, expectedError, diff.Diff(gotError, expectedError))
+	}
+}
+
+func TestInvalidTimezoneError(t *testing.T) {
+	_, parseError := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "my_org"
+      cluster_id: "my_cluster"
+      timezone: Europe/Atlantis`), false)
+
+	if parseError == nil {
+		t.Fatalf("expected error, got nil")
+	}
+
+	expectedErrorLines := []string{
+		"1 error occurred:",
+		"\t* timezone \"Europe/Atlantis\" is not valid: unknown time zone Europe/Atlantis",
+		"\n",
+	}
+
+	expectedError := strings.Join(expectedErrorLines, "\n")
+
+	gotError := parseError.Error()
+
+	if gotError != expectedError {
+		t.Errorf("\ngot=\n%v\nwant=\n%s\ndiff=\n%s", gotError, expectedError, diff.Diff(gotError, expectedError))
+	}
+}
+
 func TestInvalidDataGathered(t *testing.T) {
 	_, parseError := ParseConfig([]byte(`
       endpoint:
@@ -261,7 +386,40 @@ func TestInvalidDataGathered(t *testing.T) {
 		t.Fatalf("expected error, got nil")
 	}
 
-	if got, want := parseError.Error(), `cannot parse data-gatherer configuration, kind "foo" is not supported`; got != want {
+	if got, want := parseError.Error(), "yaml: unmarshal errors:\n  line 7: cannot parse data-gatherer configuration, kind \"foo\" is not supported"; got != want {
 		t.Errorf("\ngot=\n%v\nwant=\n%s\ndiff=\n%s", got, want, diff.Diff(got, want))
 	}
 }
+
+func TestOpenShiftConfigLoad(t *testing.T) {
+	loadedConfig, err := ParseConfig([]byte(`
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      openshift: true
+      data-gatherers:
+      - name: k8s/routes.v1.route.openshift.io
+        kind: dummy
+`), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, dg := range loadedConfig.DataGatherers {
+		names = append(names, dg.Name)
+	}
+
+	expected := []string{
+		"k8s/routes.v1.route.openshift.io",
+		"k8s/clusteroperators.v1.config.openshift.io",
+		"k8s/clusterversions.v1.config.openshift.io",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, names); !equal {
+		t.Errorf("Diff %s", diff)
+	}
+
+	// the configured data gatherer takes precedence over the built-in one
+	if loadedConfig.DataGatherers[0].Kind != "dummy" {
+		t.Errorf("expected configured routes data gatherer to be kept, got kind %q", loadedConfig.DataGatherers[0].Kind)
+	}
+}
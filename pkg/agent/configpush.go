@@ -0,0 +1,382 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+const (
+	// signatureHeader holds the base64 encoded signature of a pushed
+	// configuration.
+	signatureHeader = "X-Signature"
+	// signatureTimestampHeader holds the RFC 3339 time at which the
+	// configuration was signed, which is signed along with it.
+	signatureTimestampHeader = "X-Signature-Timestamp"
+
+	defaultConfigPushMaxAge = 5 * time.Minute
+	maxPushedConfigSize     = 1 << 20
+)
+
+// pushableConfigFields are the settings, by YAML name, that a pushed
+// configuration can change: those the datagathering loop reads each cycle.
+// The others, e.g. the server, the backend, the credentials, the mirror or
+// the spool, are only read at startup or make the agent read, write or send
+// data elsewhere, so a pushed configuration must leave them unchanged.
+var pushableConfigFields = map[string]bool{
+	"period":                   true,
+	"data-gatherers":           true,
+	"openshift":                true,
+	"labels":                   true,
+	"policies":                 true,
+	"report-mode":              true,
+	"alerts":                   true,
+	"resource-limits":          true,
+	"cluster-metadata":         true,
+	"max-concurrent-gatherers": true,
+}
+
+// pushableDataGathererKinds are the kinds of the data gatherers a pushed
+// configuration can have: those reading the Kubernetes API of the cluster.
+// The local, exec, plugin, http and prometheus data gatherers, which read
+// files, run commands or send requests to arbitrary URLs, and the
+// k8s-tls-probe data gatherer, which connects to arbitrary addresses, can
+// only be configured in the configuration file.
+var pushableDataGathererKinds = map[string]bool{
+	"k8s":                            true,
+	"k8s-dynamic":                    true,
+	"k8s-discovery":                  true,
+	"k8s-cert-manager":               true,
+	"k8s-rbac":                       true,
+	"k8s-webhooks":                   true,
+	"k8s-key-hygiene":                true,
+	"k8s-ingress-tls-policy":         true,
+	"k8s-cert-manager-logs":          true,
+	"k8s-encryption-at-rest":         true,
+	"k8s-helm-releases":              true,
+	"k8s-crds":                       true,
+	"k8s-api-deprecations":           true,
+	"k8s-istio":                      true,
+	"k8s-ingress-tls":                true,
+	"k8s-issuer-health":              true,
+	"k8s-issuance":                   true,
+	"k8s-cert-manager-events":        true,
+	"k8s-pod-security":               true,
+	"k8s-resource-counts":            true,
+	"k8s-control-plane-certificates": true,
+}
+
+// ConfigPushConfig enables an admin endpoint the backend can push updated
+// configurations to.
+type ConfigPushConfig struct {
+	// Listen is the address of the endpoint, e.g. :8443.
+	Listen string `yaml:"listen"`
+	// PublicKeyPath is the path to the PEM encoded PKIX ECDSA or Ed25519
+	// public key the configurations must be signed with.
+	PublicKeyPath string `yaml:"public-key-path"`
+	// TLSCertPath and TLSKeyPath, if set, serve the endpoint over HTTPS.
+	TLSCertPath string `yaml:"tls-cert-path,omitempty"`
+	TLSKeyPath  string `yaml:"tls-key-path,omitempty"`
+	// MaxAge is how long after being signed a configuration is accepted.
+	// Defaults to 5m.
+	MaxAge time.Duration `yaml:"max-age,omitempty"`
+}
+
+func (c *ConfigPushConfig) validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("config-push.listen is required")
+	}
+	if c.PublicKeyPath == "" {
+		return fmt.Errorf("config-push.public-key-path is required")
+	}
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		return fmt.Errorf("config-push.tls-cert-path and config-push.tls-key-path must be set together")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("config-push.max-age must not be negative")
+	}
+	return nil
+}
+
+// configPush is a verified and valid configuration waiting to be applied by
+// the datagathering loop, which sends the outcome to result.
+type configPush struct {
+	config Config
+	result chan error
+}
+
+// configPushHandler verifies the configurations pushed to the admin endpoint
+// and passes them to the datagathering loop.
+type configPushHandler struct {
+	publicKey         crypto.PublicKey
+	maxAge            time.Duration
+	isVenafiCloudMode bool
+	now               func() time.Time
+	pushes            chan<- configPush
+
+	mu sync.Mutex
+	// current is the configuration currently applied, which the pushed
+	// configurations are checked against.
+	current Config
+	// lastSignedAt is the signature time of the last applied configuration,
+	// older ones are rejected so that they can't be replayed.
+	lastSignedAt time.Time
+}
+
+// startConfigPushServer serves the admin endpoint of the configuration and
+// returns the channel of the pushed configurations.
+func startConfigPushServer(config Config, isVenafiCloudMode bool) (<-chan configPush, error) {
+	c := config.ConfigPush
+	publicKey, err := loadPublicKey(c.PublicKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	pushes := make(chan configPush)
+	handler := &configPushHandler{
+		publicKey:         publicKey,
+		maxAge:            c.MaxAge,
+		isVenafiCloudMode: isVenafiCloudMode,
+		now:               time.Now,
+		pushes:            pushes,
+		current:           config,
+	}
+	if handler.maxAge == 0 {
+		handler.maxAge = defaultConfigPushMaxAge
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/config", handler)
+	go func() {
+		var err error
+		if c.TLSCertPath != "" {
+			err = http.ListenAndServeTLS(c.Listen, c.TLSCertPath, c.TLSKeyPath, mux)
+		} else {
+			err = http.ListenAndServe(c.Listen, mux)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("failed to run config push server: %s", err)
+		}
+	}()
+
+	return pushes, nil
+}
+
+func (h *configPushHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPushedConfigSize+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read configuration: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPushedConfigSize {
+		http.Error(w, "configuration too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	signedAt, err := h.verify(r.Header.Get(signatureTimestampHeader), r.Header.Get(signatureHeader), body)
+	if err != nil {
+		log.Printf("rejected pushed configuration: %s", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	config, err := ParseConfig(body, h.isVenafiCloudMode)
+	if err == nil {
+		err = checkPushedConfig(h.current, config)
+	}
+	if err != nil {
+		log.Printf("rejected pushed configuration: %s", err)
+		http.Error(w, fmt.Sprintf("invalid configuration: %s", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	push := configPush{config: config, result: make(chan error, 1)}
+	select {
+	case h.pushes <- push:
+	case <-r.Context().Done():
+		return
+	}
+	if err := <-push.result; err != nil {
+		log.Printf("failed to apply pushed configuration, keeping the current configuration: %s", err)
+		http.Error(w, fmt.Sprintf("failed to apply configuration, the current configuration is kept: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	h.lastSignedAt = signedAt
+	h.current = config
+	log.Printf("applied configuration signed at %s", signedAt.In(location).Format(time.RFC3339))
+	fmt.Fprintln(w, "configuration applied")
+}
+
+// checkPushedConfig checks that a pushed configuration only changes the
+// pushableConfigFields of the current configuration, and only has pushable
+// data gatherers.
+func checkPushedConfig(current, pushed Config) error {
+	var changed []string
+	currentValue, pushedValue := reflect.ValueOf(current), reflect.ValueOf(pushed)
+	for i := 0; i < currentValue.NumField(); i++ {
+		name := strings.Split(currentValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if pushableConfigFields[name] {
+			continue
+		}
+		if !reflect.DeepEqual(currentValue.Field(i).Interface(), pushedValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%s can only be changed in the configuration file", strings.Join(changed, ", "))
+	}
+	return checkPushedDataGatherers(pushed.DataGatherers)
+}
+
+// checkPushedDataGatherers checks that the data gatherers of a pushed
+// configuration are of the pushableDataGathererKinds, and don't read their
+// data or kubeconfig from a file.
+func checkPushedDataGatherers(dataGatherers []DataGatherer) error {
+	for _, dg := range dataGatherers {
+		if !pushableDataGathererKinds[dg.Kind] {
+			return fmt.Errorf("datagatherer %q: %s data gatherers cannot be pushed", dg.Name, dg.Kind)
+		}
+		if dg.DataPath != "" {
+			return fmt.Errorf("datagatherer %q: data-path cannot be pushed", dg.Name)
+		}
+		if kubeconfigPath(dg.Config) != "" {
+			return fmt.Errorf("datagatherer %q: kubeconfig cannot be pushed", dg.Name)
+		}
+		// the EncryptionConfiguration is read from the node
+		if c, ok := dg.Config.(*k8s.ConfigEncryptionAtRest); ok && c.EncryptionConfigPath != "" {
+			return fmt.Errorf("datagatherer %q: encryption-config-path cannot be pushed", dg.Name)
+		}
+	}
+	return nil
+}
+
+// kubeconfigPath returns the kubeconfig of the config of a k8s data
+// gatherer, which all have a KubeConfigPath field.
+func kubeconfigPath(config interface{}) string {
+	v := reflect.Indirect(reflect.ValueOf(config))
+	if v.Kind() != reflect.Struct {
+		return ""
+	}
+	if f := v.FieldByName("KubeConfigPath"); f.IsValid() && f.Kind() == reflect.String {
+		return f.String()
+	}
+	return ""
+}
+
+// verify checks the signature of the configuration and that it was signed
+// recently and after the current configuration.
+func (h *configPushHandler) verify(timestamp, signature string, body []byte) (time.Time, error) {
+	if timestamp == "" || signature == "" {
+		return time.Time{}, fmt.Errorf("the %s and %s headers are required", signatureHeader, signatureTimestampHeader)
+	}
+	signedAt, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header: %s", signatureTimestampHeader, err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header: %s", signatureHeader, err)
+	}
+	if !verifySignature(h.publicKey, signedMessage(timestamp, body), sig) {
+		return time.Time{}, fmt.Errorf("invalid signature")
+	}
+
+	if age := h.now().Sub(signedAt); age > h.maxAge || age < -h.maxAge {
+		return time.Time{}, fmt.Errorf("the configuration was signed at %s, more than %s ago", timestamp, h.maxAge)
+	}
+	if !signedAt.After(h.lastSignedAt) {
+		return time.Time{}, fmt.Errorf("the configuration was signed at %s, before the current configuration", timestamp)
+	}
+	return signedAt, nil
+}
+
+// signedMessage is what is signed: the timestamp and the configuration,
+// separated by a newline.
+func signedMessage(timestamp string, body []byte) []byte {
+	return append([]byte(timestamp+"\n"), body...)
+}
+
+func verifySignature(publicKey crypto.PublicKey, message, sig []byte) bool {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	}
+	return false
+}
+
+// loadPublicKey reads a PEM encoded PKIX ECDSA or Ed25519 public key.
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode public key %q: no PEM data found", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %q: %w", path, err)
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T, only ECDSA and Ed25519 keys are supported", key)
+}
+
+// startDataGatherers starts the data gatherers of a configuration in a
+// context of their own. If any of them fails to start, those already started
+// are stopped and an error is returned.
+func startDataGatherers(ctx context.Context, config Config) (map[string]datagatherer.DataGatherer, context.CancelFunc, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for _, dgConfig := range config.DataGatherers {
+		dg, err := newDataGatherer(ctx, dgConfig)
+		if err != nil {
+			cancel()
+			stopDataGatherers(dataGatherers)
+			return nil, nil, err
+		}
+		dataGatherers[dgConfig.key()] = dg
+	}
+	return dataGatherers, cancel, nil
+}
+
+// stopDataGatherers clears the caches of data gatherers whose context was
+// cancelled.
+func stopDataGatherers(dataGatherers map[string]datagatherer.DataGatherer) {
+	for name, dg := range dataGatherers {
+		if err := dg.Delete(); err != nil {
+			log.Printf("failed to stop %q data gatherer: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+const pushedConfig = `
+server: "http://localhost:8080"
+period: 1m
+organization_id: "example"
+cluster_id: "example-cluster"
+data-gatherers:
+- name: d1
+  kind: k8s-discovery
+`
+
+func TestConfigPushHandler(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	current, err := ParseConfig([]byte(pushedConfig), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	pushes := make(chan configPush)
+	h := &configPushHandler{
+		publicKey: public,
+		maxAge:    defaultConfigPushMaxAge,
+		now:       func() time.Time { return now },
+		pushes:    pushes,
+		current:   current,
+	}
+
+	// the datagathering loop fails to apply configurations with a period of
+	// 2m
+	go func() {
+		for push := range pushes {
+			if push.config.Period == 2*time.Minute {
+				push.result <- fmt.Errorf("failed to start data gatherers")
+				continue
+			}
+			push.result <- nil
+		}
+	}()
+	defer close(pushes)
+
+	push := func(body string, signedAt time.Time, key ed25519.PrivateKey) int {
+		timestamp := signedAt.Format(time.RFC3339)
+		req := httptest.NewRequest(http.MethodPost, "/config", bytes.NewBufferString(body))
+		req.Header.Set(signatureTimestampHeader, timestamp)
+		req.Header.Set(signatureHeader, base64.StdEncoding.EncodeToString(ed25519.Sign(key, signedMessage(timestamp, []byte(body)))))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	tests := []struct {
+		name     string
+		body     string
+		signedAt time.Time
+		key      ed25519.PrivateKey
+		expected int
+	}{
+		{"wrong key", pushedConfig, now, otherKey, http.StatusUnauthorized},
+		{"signed too long ago", pushedConfig, now.Add(-time.Hour), private, http.StatusUnauthorized},
+		{"invalid configuration", "period: 1m\ndata-gatherers:\n- name: d1\n  kind: nope\n", now, private, http.StatusUnprocessableEntity},
+		{"exec data gatherer", pushedConfig + "- name: e1\n  kind: exec\n  config:\n    command: date\n", now, private, http.StatusUnprocessableEntity},
+		{"plugin data gatherer", pushedConfig + "- name: p1\n  kind: plugin\n  config:\n    path: /plugins/vault\n", now, private, http.StatusUnprocessableEntity},
+		{"local data gatherer", pushedConfig + "- name: l1\n  kind: local\n  config:\n    data-path: /etc/passwd\n", now, private, http.StatusUnprocessableEntity},
+		{"http data gatherer", pushedConfig + "- name: h1\n  kind: http\n  config:\n    url: http://169.254.169.254/\n", now, private, http.StatusUnprocessableEntity},
+		{"data-path", pushedConfig + "  data-path: /etc/passwd\n", now, private, http.StatusUnprocessableEntity},
+		{"kubeconfig", pushedConfig + "  config:\n    kubeconfig: /root/.kube/config\n", now, private, http.StatusUnprocessableEntity},
+		{"encryption-config-path", pushedConfig + "- name: e2\n  kind: k8s-encryption-at-rest\n  config:\n    encryption-config-path: /etc/shadow\n", now, private, http.StatusUnprocessableEntity},
+		{"changed server", strings.Replace(pushedConfig, "localhost:8080", "example.com", 1), now, private, http.StatusUnprocessableEntity},
+		{"added mirror", pushedConfig + "mirror:\n  server: http://example.com\n", now, private, http.StatusUnprocessableEntity},
+		{"failed to apply", strings.Replace(pushedConfig, "1m", "2m", 1), now, private, http.StatusInternalServerError},
+		{"applied", pushedConfig, now.Add(-time.Minute), private, http.StatusOK},
+		{"replayed", pushedConfig, now.Add(-time.Minute), private, http.StatusUnauthorized},
+		{"older than the current configuration", pushedConfig, now.Add(-2 * time.Minute), private, http.StatusUnauthorized},
+		{"newer", pushedConfig, now, private, http.StatusOK},
+		{"changed labels and period", strings.Replace(pushedConfig, "1m", "5m", 1) + "labels:\n  team: a\n", now.Add(time.Second), private, http.StatusOK},
+	}
+	for _, tc := range tests {
+		if code := push(tc.body, tc.signedAt, tc.key); code != tc.expected {
+			t.Errorf("%s: unexpected status code: got=%d want=%d", tc.name, code, tc.expected)
+		}
+	}
+
+	// the signature headers are required
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/config", bytes.NewBufferString(pushedConfig)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected status code for an unsigned configuration: %d", rec.Code)
+	}
+}
+
+func TestCheckPushedConfig(t *testing.T) {
+	current, err := ParseConfig([]byte(pushedConfig), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tests := []struct {
+		name     string
+		change   func(c *Config)
+		expected string
+	}{
+		{"period and labels", func(c *Config) { c.Period, c.Labels = 5*time.Minute, map[string]string{"team": "a"} }, ""},
+		{"server and backend", func(c *Config) { c.Server, c.Backend = "http://example.com", BackendGRPC }, "server, backend can only be changed in the configuration file"},
+		{"spool", func(c *Config) { c.Spool = &SpoolConfig{Directory: "/tmp"} }, "spool can only be changed in the configuration file"},
+		{"local", func(c *Config) { c.DataGatherers[0].Kind = "local" }, `datagatherer "d1": local data gatherers cannot be pushed`},
+		{"tls probe", func(c *Config) { c.DataGatherers[0].Kind = "k8s-tls-probe" }, `datagatherer "d1": k8s-tls-probe data gatherers cannot be pushed`},
+		{"data-path", func(c *Config) { c.DataGatherers[0].DataPath = "/data.json" }, `datagatherer "d1": data-path cannot be pushed`},
+		{"kubeconfig", func(c *Config) { c.DataGatherers[0].Config = &k8s.ConfigDiscovery{KubeConfigPath: "/kubeconfig"} }, `datagatherer "d1": kubeconfig cannot be pushed`},
+		{"encryption-config-path", func(c *Config) {
+			c.DataGatherers[0].Kind = "k8s-encryption-at-rest"
+			c.DataGatherers[0].Config = &k8s.ConfigEncryptionAtRest{EncryptionConfigPath: "/etc/kubernetes/enc.yaml"}
+		}, `datagatherer "d1": encryption-config-path cannot be pushed`},
+		{"encryption-config-map", func(c *Config) {
+			c.DataGatherers[0].Kind = "k8s-encryption-at-rest"
+			c.DataGatherers[0].Config = &k8s.ConfigEncryptionAtRest{EncryptionConfigMap: &k8s.EncryptionConfigMapRef{Namespace: "kube-system", Name: "encryption"}}
+		}, ""},
+	}
+	for _, tc := range tests {
+		pushed, err := ParseConfig([]byte(pushedConfig), false)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tc.change(&pushed)
+		err = checkPushedConfig(current, pushed)
+		if tc.expected == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if tc.expected != "" && (err == nil || err.Error() != tc.expected) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.expected, err)
+		}
+	}
+}
+
+func TestStartDataGatherers(t *testing.T) {
+	config := Config{DataGatherers: []DataGatherer{
+		{Name: "a", Kind: "dummy", Config: &dummyConfig{}},
+		{Name: "b", Kind: "dummy", Config: &dummyConfig{}},
+	}}
+	dataGatherers, cancel, err := startDataGatherers(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cancel()
+	if len(dataGatherers) != 2 {
+		t.Errorf("expected 2 data gatherers, got %d", len(dataGatherers))
+	}
+
+	// none are kept if one fails to start
+	config.DataGatherers[1].Config = &dummyConfig{wantOnCreationErr: true}
+	if _, _, err := startDataGatherers(context.Background(), config); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	// data gatherers reading from a file are not supported
+	config.DataGatherers[1] = DataGatherer{Name: "c", Kind: "dummy", DataPath: "/data.json", Config: &dummyConfig{}}
+	if _, _, err := startDataGatherers(context.Background(), config); err == nil || !strings.Contains(err.Error(), "data-path override present") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	key, err := loadPublicKey(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !public.Equal(key) {
+		t.Errorf("unexpected key %v", key)
+	}
+}
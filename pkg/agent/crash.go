@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// StateFilePath is where the agent records its state, to detect whether the
+// previous run terminated cleanly. If empty, crashes are not detected.
+var StateFilePath string
+
+const (
+	phaseStarting  = "starting"
+	phaseGathering = "gathering"
+	phaseWaiting   = "waiting"
+)
+
+// agentState is the content of the state file.
+type agentState struct {
+	PID        int      `json:"pid"`
+	StartedAt  api.Time `json:"started_at"`
+	Cycle      int      `json:"cycle"`
+	Phase      string   `json:"phase"`
+	LastUpdate api.Time `json:"last_update"`
+	// Clean is set when the agent terminates cleanly.
+	Clean bool `json:"clean"`
+	// Crashes is the number of unclean terminations so far.
+	Crashes int `json:"crashes"`
+}
+
+// stateMarker records the state of the agent in a file, so that the next run
+// can tell if it crashed.
+type stateMarker struct {
+	mu    sync.Mutex
+	path  string
+	state agentState
+	now   func() time.Time
+}
+
+// newStateMarker reads the state left by the previous run and records the
+// start of this one. A crash report is returned if the previous run did not
+// terminate cleanly.
+func newStateMarker(path string, now func() time.Time) (*stateMarker, *api.CrashReport, error) {
+	m := &stateMarker{path: path, now: now}
+
+	var previous *agentState
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, nil, fmt.Errorf("failed to read state file: %w", err)
+	default:
+		previous = &agentState{}
+		if err := json.Unmarshal(data, previous); err != nil {
+			// a state file truncated by the crash is still a crash
+			previous = &agentState{}
+		}
+	}
+
+	var report *api.CrashReport
+	if previous != nil {
+		m.state.Crashes = previous.Crashes
+		if !previous.Clean {
+			m.state.Crashes++
+			report = &api.CrashReport{
+				PID:        previous.PID,
+				StartedAt:  previous.StartedAt,
+				Cycle:      previous.Cycle,
+				Phase:      previous.Phase,
+				LastUpdate: previous.LastUpdate,
+				Crashes:    m.state.Crashes,
+			}
+		}
+	}
+
+	m.state.PID = os.Getpid()
+	m.state.StartedAt = api.Time{Time: now()}
+	if err := m.update(phaseStarting); err != nil {
+		return nil, nil, err
+	}
+
+	return m, report, nil
+}
+
+// update records the current phase, counting a new cycle each time the agent
+// starts gathering.
+func (m *stateMarker) update(phase string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if phase == phaseGathering {
+		m.state.Cycle++
+	}
+	m.state.Phase = phase
+	return m.write()
+}
+
+// clean records that the agent terminated cleanly.
+func (m *stateMarker) clean() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state.Clean = true
+	return m.write()
+}
+
+func (m *stateMarker) write() error {
+	m.state.LastUpdate = api.Time{Time: m.now()}
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %w", err)
+	}
+	// write to a temporary file first, so that a crash while writing does not
+	// leave a truncated state file
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestStateMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.state")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	// first run, nothing to report
+	marker, report, err := newStateMarker(path, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report != nil {
+		t.Errorf("expected no crash report, got %+v", report)
+	}
+	for _, phase := range []string{phaseGathering, phaseWaiting, phaseGathering} {
+		if err := marker.update(phase); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// the first run crashed while gathering
+	startedAt := marker.state.StartedAt
+	marker, report, err = newStateMarker(path, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &api.CrashReport{
+		PID:        marker.state.PID,
+		StartedAt:  startedAt,
+		Cycle:      2,
+		Phase:      phaseGathering,
+		LastUpdate: api.Time{Time: now},
+		Crashes:    1,
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, report); !equal {
+		t.Errorf("unexpected crash report:\n%s", diff)
+	}
+
+	// the second run terminated cleanly, the crashes are still counted
+	if err := marker.clean(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	marker, report, err = newStateMarker(path, clock)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report != nil {
+		t.Errorf("expected no crash report, got %+v", report)
+	}
+	if marker.state.Crashes != 1 {
+		t.Errorf("unexpected number of crashes: got=%d want=1", marker.state.Crashes)
+	}
+}
+
+func TestStateMarkerTruncatedStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.state")
+	if err := ioutil.WriteFile(path, []byte(`{"pid": 1`), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, report, err := newStateMarker(path, time.Now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report == nil || report.Crashes != 1 {
+		t.Errorf("expected a crash to be reported, got %+v", report)
+	}
+}
+
+func TestHandleTerminationSignals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopping := handleTerminationSignals(cancel)
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-stopping:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the agent to be stopping after SIGTERM")
+	}
+	if ctx.Err() == nil {
+		t.Errorf("expected the context of the agent to be cancelled")
+	}
+}
+
+func TestTerminationSignalInterruptsUpload(t *testing.T) {
+	// the upload would be retried in an hour
+	defer func(interval time.Duration) { uploadRetryInterval = interval }(uploadRetryInterval)
+	uploadRetryInterval = time.Hour
+	requests := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "agent.state")
+	marker, _, err := newStateMarker(path, time.Now)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	config := Config{Server: server.URL, OrganizationID: "example", ClusterID: "example-cluster"}
+	c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handleTerminationSignals(cancel)
+	if err := marker.update(phaseGathering); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gatherAndOutputData(ctx, config, c, &api.AgentMetadata{}, nil, map[string]datagatherer.DataGatherer{}, nil)
+	}()
+
+	select {
+	case <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected an upload")
+	}
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the cycle to be interrupted by SIGTERM")
+	}
+	shutdown(marker, nil)
+
+	// the next run finds a clean termination
+	if _, report, err := newStateMarker(path, time.Now); err != nil || report != nil {
+		t.Errorf("expected a clean termination, got %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"filippo.io/age"
+	"github.com/hashicorp/go-multierror"
+)
+
+// OutputEncryptionConfig encrypts the data written to the output path with
+// age, so that snapshots kept in shared buckets or volumes don't expose the
+// inventory of the cluster. They are decrypted with `age -d -i <key file>`.
+type OutputEncryptionConfig struct {
+	// Recipients are the age public keys, e.g. age1..., the data is
+	// encrypted to.
+	Recipients []string `yaml:"recipients,omitempty"`
+	// RecipientsFile is the path to a file of age public keys, one per line,
+	// like the files accepted by `age -R`.
+	RecipientsFile string `yaml:"recipients-file,omitempty"`
+}
+
+func (c *OutputEncryptionConfig) validate() error {
+	var result *multierror.Error
+	if len(c.Recipients) == 0 && c.RecipientsFile == "" {
+		result = multierror.Append(result, fmt.Errorf("output-encryption: recipients or recipients-file is required"))
+	}
+	for _, recipient := range c.Recipients {
+		if _, err := age.ParseX25519Recipient(recipient); err != nil {
+			result = multierror.Append(result, fmt.Errorf("output-encryption.recipients: %w", err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// recipients returns the configured recipients and those of the recipients
+// file.
+func (c *OutputEncryptionConfig) recipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, s := range c.Recipients {
+		recipient, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, recipient)
+	}
+	if c.RecipientsFile != "" {
+		f, err := os.Open(c.RecipientsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read recipients file: %w", err)
+		}
+		defer f.Close()
+		fromFile, err := age.ParseRecipients(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse recipients file %s: %w", c.RecipientsFile, err)
+		}
+		recipients = append(recipients, fromFile...)
+	}
+	return recipients, nil
+}
+
+// encrypt encrypts data to the recipients, in the binary age format.
+func (c *OutputEncryptionConfig) encrypt(data []byte) ([]byte, error) {
+	recipients, err := c.recipients()
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	w, err := age.Encrypt(&out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
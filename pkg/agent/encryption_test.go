@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestOutputEncryption(t *testing.T) {
+	first, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	recipientsFile := filepath.Join(t.TempDir(), "recipients.txt")
+	if err := os.WriteFile(recipientsFile, []byte("# backup key\n"+second.Recipient().String()+"\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	config := &OutputEncryptionConfig{
+		Recipients:     []string{first.Recipient().String()},
+		RecipientsFile: recipientsFile,
+	}
+	if err := config.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	plaintext := []byte(`[{"data-gatherer": "k8s/secrets"}]`)
+	encrypted, err := config.encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bytes.Contains(encrypted, []byte("k8s/secrets")) {
+		t.Fatalf("the output is not encrypted")
+	}
+
+	// both recipients can decrypt the output
+	for _, identity := range []age.Identity{first, second} {
+		r, err := age.Decrypt(bytes.NewReader(encrypted), identity)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		decrypted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("unexpected decrypted output: %s", decrypted)
+		}
+	}
+}
+
+func TestOutputEncryptionConfigValidate(t *testing.T) {
+	if err := (&OutputEncryptionConfig{}).validate(); err == nil {
+		t.Errorf("expected an error without recipients")
+	}
+	if err := (&OutputEncryptionConfig{Recipients: []string{"not-a-key"}}).validate(); err == nil {
+		t.Errorf("expected an error for an invalid recipient")
+	}
+}
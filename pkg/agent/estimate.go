@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// EstimateGathererPath is the file containing the data gatherer to estimate
+var EstimateGathererPath string
+
+// Estimate prints the predicted number of objects, payload size and fetch
+// time of a data gatherer, without gathering all of its data.
+func Estimate(cmd *cobra.Command, args []string) {
+	b, err := os.ReadFile(EstimateGathererPath)
+	if err != nil {
+		log.Fatalf("Failed to read data gatherer file: %s", err)
+	}
+
+	var dg DataGatherer
+	if err := yaml.Unmarshal(b, &dg); err != nil {
+		log.Fatalf("Failed to parse data gatherer file: %s", err)
+	}
+
+	dynamicConfig, ok := dg.Config.(*k8s.ConfigDynamic)
+	if !ok {
+		log.Fatalf("Estimates are only supported for k8s-dynamic data gatherers, %q is of kind %q", dg.Name, dg.Kind)
+	}
+
+	estimate, err := dynamicConfig.Estimate(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to estimate data gatherer %q: %s", dg.Name, err)
+	}
+
+	printEstimate(os.Stdout, dg.Name, estimate)
+}
+
+func printEstimate(out io.Writer, name string, estimate *k8s.Estimate) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RESOURCE\tOBJECTS\tSAMPLED\tAVERAGE SIZE\tSIZE\tFETCH TIME")
+	for _, r := range estimate.Resources {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n",
+			r.GroupVersionResource, r.Count, r.Sampled, formatBytes(int64(r.AverageSize)), formatBytes(r.Size), r.FetchTime)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\n%s: %d objects, %s, fetched in about %s\n", name, estimate.Count, formatBytes(estimate.Size), estimate.FetchTime)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,287 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	json "github.com/json-iterator/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+const (
+	defaultEventsFailureThreshold = 3
+	// eventsComponent is the source component of the events.
+	eventsComponent = "jetstack-secure-agent"
+	// conditionAnnotation holds the condition of the agent on its
+	// Deployment.
+	conditionAnnotation = "preflight.jetstack.io/condition"
+	// maxEventErrorLength bounds the size of the errors in the messages of
+	// the events.
+	maxEventErrorLength = 512
+
+	eventReasonDataGathererFailed    = "DataGathererFailed"
+	eventReasonDataGathererRecovered = "DataGathererRecovered"
+	eventReasonUploadFailed          = "UploadFailed"
+	eventReasonAlertFiring           = "AlertFiring"
+	eventReasonAlertResolved         = "AlertResolved"
+)
+
+// EventsConfig enables the Kubernetes Events emitted by the agent when its
+// data gatherers fail repeatedly or its uploads fail, so that the failures
+// surface in the alerting of the cluster.
+type EventsConfig struct {
+	// FailureThreshold is the number of consecutive cycles a data gatherer
+	// fails in before a Warning event is emitted. Defaults to 3.
+	FailureThreshold int `yaml:"failure-threshold,omitempty"`
+	// Namespace of the agent. Defaults to the POD_NAMESPACE environment
+	// variable, or the namespace of the service account.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Deployment, if set, is the name of the Deployment of the agent. The
+	// events are then about the Deployment rather than the Pod of the agent,
+	// and the condition of the agent is recorded in an annotation of the
+	// Deployment.
+	Deployment string `yaml:"deployment,omitempty"`
+}
+
+func (c *EventsConfig) validate() error {
+	var errs []string
+	if c.FailureThreshold < 0 {
+		errs = append(errs, "events.failure-threshold must not be negative")
+	}
+	if c.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(c.Namespace) {
+			errs = append(errs, fmt.Sprintf("events.namespace: %s", msg))
+		}
+	}
+	if c.Deployment != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.Deployment) {
+			errs = append(errs, fmt.Sprintf("events.deployment: %s", msg))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// agentCondition is the condition of the agent recorded on its Deployment.
+type agentCondition struct {
+	Type               string   `json:"type"`
+	Status             string   `json:"status"`
+	Reason             string   `json:"reason"`
+	Message            string   `json:"message"`
+	LastTransitionTime api.Time `json:"lastTransitionTime"`
+}
+
+// eventEmitter emits the events about the failures of the agent.
+type eventEmitter struct {
+	mu         sync.Mutex
+	recorder   record.EventRecorder
+	object     *corev1.ObjectReference
+	threshold  int
+	clientset  kubernetes.Interface
+	deployment string
+	// failures is the number of consecutive cycles each data gatherer has
+	// failed in.
+	failures map[string]int
+	// alerts are the alert rules that triggered in the previous cycle.
+	alerts map[string]bool
+	// condition is the last condition recorded on the Deployment.
+	condition *agentCondition
+	now       func() time.Time
+}
+
+// agentEvents emits the events of the running agent, if enabled.
+var agentEvents *eventEmitter
+
+// startEventEmitter returns an eventEmitter recording the events with the
+// API server.
+func startEventEmitter(ctx context.Context, config EventsConfig) (*eventEmitter, error) {
+	namespace := config.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = agentNamespace(); err != nil {
+			return nil, err
+		}
+	}
+	clientset, err := k8s.NewClientSet(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events(namespace)})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventsComponent})
+	return newEventEmitter(config, namespace, recorder, clientset)
+}
+
+func newEventEmitter(config EventsConfig, namespace string, recorder record.EventRecorder, clientset kubernetes.Interface) (*eventEmitter, error) {
+	e := &eventEmitter{
+		recorder:   recorder,
+		threshold:  config.FailureThreshold,
+		clientset:  clientset,
+		deployment: config.Deployment,
+		failures:   map[string]int{},
+		alerts:     map[string]bool{},
+		now:        time.Now,
+	}
+	if e.threshold == 0 {
+		e.threshold = defaultEventsFailureThreshold
+	}
+	if config.Deployment != "" {
+		e.object = &corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: namespace, Name: config.Deployment}
+		return e, nil
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		var err error
+		if pod, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("events.deployment is not set, and the name of the pod of the agent is unknown: %w", err)
+		}
+	}
+	e.object = &corev1.ObjectReference{APIVersion: "v1", Kind: "Pod", Namespace: namespace, Name: pod}
+	return e, nil
+}
+
+// observeCycle emits a Warning event for each data gatherer that has failed
+// in FailureThreshold consecutive cycles or more, and a Normal event for
+// those that recovered. The condition of the agent is then recorded on its
+// Deployment, if it changed.
+func (e *eventEmitter) observeCycle(ctx context.Context, cycle cycleStatus) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for source := range e.failures {
+		if _, failed := cycle.Errors[source]; failed {
+			continue
+		}
+		if e.failures[source] >= e.threshold {
+			e.recorder.Eventf(e.object, corev1.EventTypeNormal, eventReasonDataGathererRecovered,
+				"data gatherer %q recovered after failing in %d consecutive cycles", source, e.failures[source])
+		}
+		delete(e.failures, source)
+	}
+
+	var failing []string
+	for _, source := range sortedSources(cycle.Errors) {
+		if source == uploadErrorSource {
+			continue
+		}
+		e.failures[source]++
+		if e.failures[source] >= e.threshold {
+			failing = append(failing, source)
+			e.recorder.Eventf(e.object, corev1.EventTypeWarning, eventReasonDataGathererFailed,
+				"data gatherer %q failed in %d consecutive cycles: %s", source, e.failures[source], truncateEventError(cycle.Errors[source]))
+		}
+	}
+
+	if e.deployment == "" {
+		return
+	}
+	condition := &agentCondition{Type: "Healthy", Status: "True", Reason: "CycleSucceeded", Message: "the last cycle succeeded"}
+	if len(failing) > 0 {
+		condition.Status, condition.Reason = "False", eventReasonDataGathererFailed
+		condition.Message = fmt.Sprintf("failing data gatherers: %s", strings.Join(failing, ", "))
+	} else if message, failed := cycle.Errors[uploadErrorSource]; failed {
+		condition.Status, condition.Reason = "False", eventReasonUploadFailed
+		condition.Message = truncateEventError(message)
+	}
+	if e.condition != nil && e.condition.Status == condition.Status && e.condition.Reason == condition.Reason && e.condition.Message == condition.Message {
+		return
+	}
+	// the transition time only changes with the status
+	condition.LastTransitionTime = api.Time{Time: e.now()}
+	if e.condition != nil && e.condition.Status == condition.Status {
+		condition.LastTransitionTime = e.condition.LastTransitionTime
+	}
+	if err := e.recordCondition(ctx, condition); err != nil {
+		log.Printf("failed to record the condition of the agent on its Deployment: %s", err)
+		return
+	}
+	e.condition = condition
+}
+
+// uploadFailed emits a Warning event about a failed upload.
+func (e *eventEmitter) uploadFailed(err error) {
+	if e == nil {
+		return
+	}
+	e.recorder.Eventf(e.object, corev1.EventTypeWarning, eventReasonUploadFailed,
+		"failed to upload the data readings: %s", truncateEventError(err.Error()))
+}
+
+// observeAlerts emits a Warning event for each alert rule that triggered in
+// the cycle, with its message, and a Normal event for those that no longer
+// trigger.
+func (e *eventEmitter) observeAlerts(firing map[string]string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for rule := range e.alerts {
+		if _, ok := firing[rule]; !ok {
+			e.recorder.Eventf(e.object, corev1.EventTypeNormal, eventReasonAlertResolved, "alert %q resolved", rule)
+			delete(e.alerts, rule)
+		}
+	}
+	for _, rule := range sortedSources(firing) {
+		e.alerts[rule] = true
+		e.recorder.Eventf(e.object, corev1.EventTypeWarning, eventReasonAlertFiring, "%s", truncateEventError(firing[rule]))
+	}
+}
+
+// recordCondition sets the condition annotation of the Deployment.
+func (e *eventEmitter) recordCondition(ctx context.Context, condition *agentCondition) error {
+	value, err := json.Marshal(condition)
+	if err != nil {
+		return err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{conditionAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = e.clientset.AppsV1().Deployments(e.object.Namespace).Patch(ctx, e.deployment, types.MergePatchType, patch, metav1.PatchOptions{
+		FieldManager: eventsComponent,
+	})
+	return err
+}
+
+func sortedSources(errors map[string]string) []string {
+	sources := make([]string, 0, len(errors))
+	for source := range errors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func truncateEventError(message string) string {
+	if len(message) > maxEventErrorLength {
+		return message[:maxEventErrorLength] + "..."
+	}
+	return message
+}
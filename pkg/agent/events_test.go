@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestEventEmitter(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "jetstack-secure", Name: "agent"},
+	})
+	recorder := record.NewFakeRecorder(10)
+	e, err := newEventEmitter(EventsConfig{FailureThreshold: 2, Deployment: "agent"}, "jetstack-secure", recorder, clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	events := func() []string {
+		var result []string
+		for {
+			select {
+			case event := <-recorder.Events:
+				result = append(result, event)
+			default:
+				return result
+			}
+		}
+	}
+	condition := func() agentCondition {
+		deployment, err := clientset.AppsV1().Deployments("jetstack-secure").Get(ctx, "agent", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var condition agentCondition
+		if err := json.Unmarshal([]byte(deployment.Annotations[conditionAnnotation]), &condition); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return condition
+	}
+	healthy := agentCondition{
+		Type:               "Healthy",
+		Status:             "True",
+		Reason:             "CycleSucceeded",
+		Message:            "the last cycle succeeded",
+		LastTransitionTime: api.Time{Time: now},
+	}
+
+	// a single failure is below the threshold
+	e.observeCycle(ctx, cycleStatus{Errors: map[string]string{"k8s/pods": "forbidden"}})
+	if diff, equal := messagediff.PrettyDiff([]string(nil), events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(healthy, condition()); !equal {
+		t.Errorf("unexpected condition:\n%s", diff)
+	}
+
+	now = now.Add(time.Minute)
+	e.observeCycle(ctx, cycleStatus{Errors: map[string]string{"k8s/pods": "forbidden", uploadErrorSource: "503"}})
+	expected := []string{`Warning DataGathererFailed data gatherer "k8s/pods" failed in 2 consecutive cycles: forbidden`}
+	if diff, equal := messagediff.PrettyDiff(expected, events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+	failing := agentCondition{
+		Type:               "Healthy",
+		Status:             "False",
+		Reason:             eventReasonDataGathererFailed,
+		Message:            "failing data gatherers: k8s/pods",
+		LastTransitionTime: api.Time{Time: now},
+	}
+	if diff, equal := messagediff.PrettyDiff(failing, condition()); !equal {
+		t.Errorf("unexpected condition:\n%s", diff)
+	}
+
+	now = now.Add(time.Minute)
+	e.observeCycle(ctx, cycleStatus{Errors: map[string]string{}})
+	expected = []string{`Normal DataGathererRecovered data gatherer "k8s/pods" recovered after failing in 2 consecutive cycles`}
+	if diff, equal := messagediff.PrettyDiff(expected, events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+	healthy.LastTransitionTime = api.Time{Time: now}
+	if diff, equal := messagediff.PrettyDiff(healthy, condition()); !equal {
+		t.Errorf("unexpected condition:\n%s", diff)
+	}
+
+	e.uploadFailed(errors.New("received response with status code 400"))
+	expected = []string{`Warning UploadFailed failed to upload the data readings: received response with status code 400`}
+	if diff, equal := messagediff.PrettyDiff(expected, events()); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+
+	// a nil emitter, when events aren't enabled, does nothing
+	var disabled *eventEmitter
+	disabled.observeCycle(ctx, cycleStatus{Errors: map[string]string{"k8s/pods": "forbidden"}})
+	disabled.uploadFailed(errors.New("failed"))
+}
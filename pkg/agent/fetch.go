@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// fetchResult is the outcome of the Fetch of a data gatherer.
+type fetchResult struct {
+	data  interface{}
+	count int
+	err   error
+}
+
+// maxConcurrentGatherers returns the number of data gatherers fetched at
+// the same time, 1 unless configured.
+func (c Config) maxConcurrentGatherers() int {
+	if c.MaxConcurrentGatherers > 0 {
+		return c.MaxConcurrentGatherers
+	}
+	return 1
+}
+
+// fetchAll fetches the data gatherers, up to concurrency at a time. A data
+// gatherer is only fetched once the data gatherers it depends on have been
+// fetched, and is failed without being fetched if one of them failed. The
+// dependencies must not have cycles, see validateDependencies.
+func fetchAll(ctx context.Context, dataGatherers map[string]datagatherer.DataGatherer, dependencies map[string][]string, concurrency int) map[string]fetchResult {
+	results := make(map[string]fetchResult, len(dataGatherers))
+	var mu sync.Mutex
+
+	done := make(map[string]chan struct{}, len(dataGatherers))
+	for k := range dataGatherers {
+		done[k] = make(chan struct{})
+	}
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for k, dg := range dataGatherers {
+		wg.Add(1)
+		go func(k string, dg datagatherer.DataGatherer) {
+			defer wg.Done()
+			defer close(done[k])
+
+			for _, dependency := range dependencies[k] {
+				<-done[dependency]
+				mu.Lock()
+				err := results[dependency].err
+				mu.Unlock()
+				if err != nil {
+					mu.Lock()
+					results[k] = fetchResult{count: -1, err: fmt.Errorf("skipped as datagatherer %s failed", dependency)}
+					mu.Unlock()
+					return
+				}
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			_, span := tracer.Start(ctx, "fetch", trace.WithAttributes(attribute.String("datagatherer", k)))
+			data, count, err := dg.Fetch()
+			endFetchSpan(span, count, err)
+
+			mu.Lock()
+			results[k] = fetchResult{data: data, count: count, err: err}
+			mu.Unlock()
+		}(k, dg)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// dependencyKeys returns the keys of the data gatherers each data gatherer
+// depends on. The dependencies of a data gatherer of a cluster are those of
+// the same cluster, or those that aren't specific to a cluster. The
+// dependencies that aren't running, e.g. because they don't target the
+// cluster, are left out.
+func dependencyKeys(dgConfigs []DataGatherer, dataGatherers map[string]datagatherer.DataGatherer) map[string][]string {
+	dependencies := map[string][]string{}
+	for _, dg := range dgConfigs {
+		if _, ok := dataGatherers[dg.key()]; !ok {
+			continue
+		}
+		for _, name := range dg.DependsOn {
+			if dg.Cluster != nil {
+				if _, ok := dataGatherers[dg.Cluster.Name+"/"+name]; ok {
+					dependencies[dg.key()] = append(dependencies[dg.key()], dg.Cluster.Name+"/"+name)
+					continue
+				}
+			}
+			if _, ok := dataGatherers[name]; ok {
+				dependencies[dg.key()] = append(dependencies[dg.key()], name)
+			}
+		}
+	}
+	return dependencies
+}
+
+// validateDependencies checks that the data gatherers only depend on
+// configured data gatherers, and that their dependencies have no cycles.
+func validateDependencies(dataGatherers []DataGatherer) error {
+	var result *multierror.Error
+
+	dependsOn := map[string][]string{}
+	for _, dg := range dataGatherers {
+		dependsOn[dg.Name] = dg.DependsOn
+	}
+	for _, dg := range dataGatherers {
+		for _, name := range dg.DependsOn {
+			if _, ok := dependsOn[name]; !ok {
+				result = multierror.Append(result, fmt.Errorf("datagatherer %q depends on datagatherer %q which is not configured", dg.Name, name))
+			}
+		}
+	}
+
+	// depth first search, reporting each cycle once from the first data
+	// gatherer found in it
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(name string)
+	visit = func(name string) {
+		switch state[name] {
+		case visiting:
+			for i := range path {
+				if path[i] == name {
+					cycle := append(append([]string{}, path[i:]...), name)
+					result = multierror.Append(result, fmt.Errorf("datagatherers have a dependency cycle: %s", strings.Join(cycle, " -> ")))
+				}
+			}
+			return
+		case visited:
+			return
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependsOn[name] {
+			visit(dependency)
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+	}
+	for _, dg := range dataGatherers {
+		visit(dg.Name)
+	}
+
+	return result.ErrorOrNil()
+}
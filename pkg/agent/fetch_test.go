@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// funcDataGatherer is a data gatherer calling fetch on Fetch.
+type funcDataGatherer struct {
+	dummyDataGatherer
+	fetch func() error
+}
+
+func (g *funcDataGatherer) Fetch() (interface{}, int, error) {
+	return nil, -1, g.fetch()
+}
+
+func TestFetchAll(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	running, maxRunning := 0, 0
+	fetch := func(name string, err error) *funcDataGatherer {
+		return &funcDataGatherer{fetch: func() error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			order = append(order, name)
+			mu.Unlock()
+			return err
+		}}
+	}
+
+	dataGatherers := map[string]datagatherer.DataGatherer{
+		"a":      fetch("a", nil),
+		"b":      fetch("b", nil),
+		"c":      fetch("c", nil),
+		"pods":   fetch("pods", nil),
+		"failed": fetch("failed", errors.New("unavailable")),
+		"after":  fetch("after", nil),
+		"never":  fetch("never", nil),
+	}
+	dependencies := map[string][]string{
+		"after": {"pods"},
+		"never": {"failed"},
+	}
+	results := fetchAll(context.Background(), dataGatherers, dependencies, 2)
+
+	if maxRunning != 2 {
+		t.Errorf("expected 2 data gatherers fetched at the same time, got %d", maxRunning)
+	}
+	positions := map[string]int{}
+	for i, name := range order {
+		positions[name] = i
+	}
+	if _, ok := positions["never"]; ok {
+		t.Errorf("expected never not to be fetched: %v", order)
+	}
+	if positions["after"] < positions["pods"] {
+		t.Errorf("expected after to be fetched after pods: %v", order)
+	}
+	if len(results) != len(dataGatherers) {
+		t.Errorf("expected a result for each data gatherer, got %d", len(results))
+	}
+	if err := results["never"].err; err == nil || err.Error() != "skipped as datagatherer failed failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if results["after"].err != nil {
+		t.Errorf("unexpected error: %s", results["after"].err)
+	}
+}
+
+func TestDependencyKeys(t *testing.T) {
+	clusterA, clusterB := &ClusterConfig{Name: "a"}, &ClusterConfig{Name: "b"}
+	dgConfigs := []DataGatherer{
+		{Name: "pods", Cluster: clusterA},
+		{Name: "versions", Cluster: clusterA, DependsOn: []string{"pods", "local"}},
+		{Name: "versions", Cluster: clusterB, DependsOn: []string{"pods", "local"}},
+		{Name: "local"},
+	}
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for _, dg := range dgConfigs {
+		dataGatherers[dg.key()] = &dummyDataGatherer{}
+	}
+
+	dependencies := dependencyKeys(dgConfigs, dataGatherers)
+	if got := dependencies["a/versions"]; len(got) != 2 || got[0] != "a/pods" || got[1] != "local" {
+		t.Errorf("unexpected dependencies of a/versions: %v", got)
+	}
+	// the pods data gatherer doesn't target cluster b
+	if got := dependencies["b/versions"]; len(got) != 1 || got[0] != "local" {
+		t.Errorf("unexpected dependencies of b/versions: %v", got)
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	err := validateDependencies([]DataGatherer{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"c"}},
+		{Name: "c", DependsOn: []string{"a", "missing"}},
+		{Name: "d", DependsOn: []string{"a"}},
+	})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := "2 errors occurred:\n\t* datagatherer \"c\" depends on datagatherer \"missing\" which is not configured\n\t* datagatherers have a dependency cycle: a -> b -> c -> a\n\n"
+	if err.Error() != expected {
+		t.Errorf("unexpected error: %q", err)
+	}
+
+	if err := validateDependencies([]DataGatherer{{Name: "a"}, {Name: "b", DependsOn: []string{"a"}}}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
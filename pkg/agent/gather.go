@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// The output formats of `agent gather`.
+	GatherOutputJSON = "json"
+	GatherOutputYAML = "yaml"
+
+	// gatherSyncTimeout bounds the wait for the initial sync of the data
+	// gatherer run by `agent gather`.
+	gatherSyncTimeout = time.Minute
+)
+
+var (
+	// GatherName is the name of the data gatherer `agent gather` runs.
+	GatherName string
+	// GatherConfigPath is the config file the data gatherer of `agent
+	// gather` is read from. It defaults to the agent config file.
+	GatherConfigPath string
+	// GatherOutputFormat is the format `agent gather` prints the reading
+	// in, json or yaml.
+	GatherOutputFormat string
+)
+
+// Gather runs a single data gatherer of the agent config once, and prints
+// its reading, so that the config of a data gatherer can be debugged
+// without running a whole cycle.
+func Gather(cmd *cobra.Command, args []string) {
+	path := GatherConfigPath
+	if path == "" {
+		path = ConfigFilePath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read config file: %s", err)
+	}
+	config, err := ParseConfig(data, VenafiCloudMode || ClientID != "")
+	if err != nil {
+		log.Fatalf("Failed to parse config file: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := gather(ctx, config, GatherName, GatherOutputFormat, os.Stdout); err != nil {
+		log.Fatalf("Failed to gather data: %s", err)
+	}
+}
+
+// gather fetches the data gatherer of config with the name, or the
+// <cluster>/<name> of the data gatherers of other clusters, and prints its
+// reading. The data gatherers it depends on aren't run.
+func gather(ctx context.Context, config Config, name, format string, out io.Writer) error {
+	if format == "" {
+		format = GatherOutputJSON
+	}
+	if format != GatherOutputJSON && format != GatherOutputYAML {
+		return fmt.Errorf("the output must be %s or %s, got %q", GatherOutputJSON, GatherOutputYAML, format)
+	}
+
+	var matches []DataGatherer
+	for _, dgConfig := range config.DataGatherers {
+		if dgConfig.Name == name || dgConfig.key() == name {
+			matches = append(matches, dgConfig)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		names := make([]string, 0, len(config.DataGatherers))
+		for _, dgConfig := range config.DataGatherers {
+			names = append(names, dgConfig.key())
+		}
+		sort.Strings(names)
+		return fmt.Errorf("no data gatherer named %q, the configured ones are: %s", name, strings.Join(names, ", "))
+	case len(matches) > 1:
+		return fmt.Errorf("several data gatherers are named %q, use <cluster>/%s to pick one", name, name)
+	}
+	dgConfig := matches[0]
+	if dgConfig.DataPath != "" {
+		return fmt.Errorf("the data gatherer %q reads its data from %s", dgConfig.Name, dgConfig.DataPath)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	dg, err := dgConfig.Config.NewDataGatherer(dataGathererContext(ctx, dgConfig))
+	if err != nil {
+		return fmt.Errorf("failed to instantiate %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+	}
+	defer func() {
+		if err := dg.Delete(); err != nil {
+			log.Printf("failed to stop %q data gatherer: %s", dgConfig.Name, err)
+		}
+	}()
+	if err := dg.Run(ctx.Done()); err != nil {
+		return fmt.Errorf("failed to start %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+	}
+	syncCtx, syncCancel := context.WithTimeout(ctx, gatherSyncTimeout)
+	defer syncCancel()
+	if err := dg.WaitForCacheSync(syncCtx.Done()); err != nil {
+		return fmt.Errorf("failed to sync %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+	}
+
+	started := time.Now()
+	data, count, err := dg.Fetch()
+	if err != nil {
+		return fmt.Errorf("failed to fetch %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+	}
+	if count >= 0 {
+		log.Printf("Gathered %d items from %q in %s", count, dgConfig.key(), time.Since(started).Round(time.Millisecond))
+	} else {
+		log.Printf("Gathered data from %q in %s", dgConfig.key(), time.Since(started).Round(time.Millisecond))
+	}
+
+	clusterID := config.ClusterID
+	if dgConfig.Cluster != nil {
+		clusterID = dgConfig.Cluster.Name
+	}
+	data, findings := splitFindings(data)
+	reading := &api.DataReading{
+		ClusterID:     clusterID,
+		DataGatherer:  dgConfig.Name,
+		Timestamp:     api.Time{Time: started},
+		Data:          data,
+		SchemaVersion: schemaVersion,
+		DataVersion:   api.DefaultDataVersion,
+		Findings:      findings,
+		Labels:        config.Labels,
+	}
+
+	encoded, err := json.MarshalIndent(reading, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal the reading: %w", err)
+	}
+	if format == GatherOutputYAML {
+		if encoded, err = yaml.JSONToYAML(encoded); err != nil {
+			return fmt.Errorf("failed to convert the reading to YAML: %w", err)
+		}
+	} else {
+		encoded = append(encoded, '\n')
+	}
+	_, err = out.Write(encoded)
+	return err
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+	"sigs.k8s.io/yaml"
+)
+
+func TestGather(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "pods.json")
+	if err := os.WriteFile(path, []byte(`{"pods": 3}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseConfig([]byte(fmt.Sprintf(`server: https://platform.jetstack.io
+organization_id: example
+cluster_id: example-cluster
+data-gatherers:
+- kind: local
+  name: local/pods
+  config:
+    data-path: %s
+- kind: local
+  name: local/other
+  config:
+    data-path: %s
+`, dir, filepath.Join(dir, "missing.json"))), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]interface{}{
+		"cluster_id":     "example-cluster",
+		"data-gatherer":  "local/pods",
+		"data":           map[string]interface{}{path: map[string]interface{}{"pods": float64(3)}},
+		"schema_version": schemaVersion,
+		"data_version":   "v1",
+	}
+	for _, format := range []string{GatherOutputJSON, GatherOutputYAML} {
+		var out bytes.Buffer
+		if err := gather(context.Background(), config, "local/pods", format, &out); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var reading map[string]interface{}
+		if format == GatherOutputYAML {
+			err = yaml.Unmarshal(out.Bytes(), &reading)
+		} else {
+			err = json.Unmarshal(out.Bytes(), &reading)
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, ok := reading["timestamp"]; !ok {
+			t.Errorf("expected a timestamp in %s", out.String())
+		}
+		delete(reading, "timestamp")
+		if diff, equal := messagediff.PrettyDiff(expected, reading); !equal {
+			t.Errorf("unexpected %s reading:\n%s", format, diff)
+		}
+	}
+
+	for name, expected := range map[string]string{
+		"local/nodes": `no data gatherer named "local/nodes", the configured ones are: local/other, local/pods`,
+		"local/other": `failed to fetch "local" data gatherer "local/other": stat ` + filepath.Join(dir, "missing.json") + ": no such file or directory",
+	} {
+		err := gather(context.Background(), config, name, GatherOutputJSON, &bytes.Buffer{})
+		if err == nil || err.Error() != expected {
+			t.Errorf("expected error %q, got %v", expected, err)
+		}
+	}
+	if err := gather(context.Background(), config, "local/pods", "xml", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "the output must be json or yaml") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
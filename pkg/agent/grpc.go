@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// GRPCConfig configures the grpc backend.
+type GRPCConfig struct {
+	// Address is the host and port of the gRPC endpoint of the server.
+	// Defaults to the host of the server, on port 443.
+	Address string `yaml:"address,omitempty"`
+	// Insecure connects without TLS.
+	Insecure bool `yaml:"insecure,omitempty"`
+	// Timeout is the deadline of each upload. Defaults to 1m.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (c *GRPCConfig) validate() error {
+	if c.Address != "" {
+		if _, _, err := net.SplitHostPort(c.Address); err != nil {
+			return fmt.Errorf("grpc.address must be a host and port: %s", err)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("grpc.timeout must not be negative")
+	}
+	return nil
+}
+
+// createGRPCClient creates the client of the grpc backend, authenticating
+// with the API tokens of the credentials, if any. The other credentials are
+// only supported by the HTTP backend.
+func createGRPCClient(creds backendCredentials, config Config, agentMetadata *api.AgentMetadata, baseURL string) (client.Client, error) {
+	if creds.clientID != "" || creds.credentialsPath != "" || creds.workloadIdentity != nil {
+		return nil, fmt.Errorf("the %s backend only supports API token authentication", BackendGRPC)
+	}
+
+	var grpcConfig GRPCConfig
+	if config.GRPC != nil {
+		grpcConfig = *config.GRPC
+	}
+	target, err := grpcTarget(grpcConfig.Address, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	options := client.GRPCOptions{
+		Insecure: grpcConfig.Insecure,
+		Timeout:  grpcConfig.Timeout,
+	}
+	if creds.apiToken != "" {
+		log.Println("An API token was specified, using API token authentication over gRPC.")
+		if options.Tokens, err = apiTokenSources(creds.apiToken, creds.nextAPIToken, config); err != nil {
+			return nil, err
+		}
+	} else {
+		log.Println("No credentials were specified, uploading over gRPC with no authentication.")
+	}
+	log.Printf("Uploading data readings over gRPC to %s", target)
+	return client.NewGRPCClient(agentMetadata, target, options)
+}
+
+// grpcTarget returns the configured address, or the host of the server on
+// port 443.
+func grpcTarget(address, baseURL string) (string, error) {
+	if address != "" {
+		return address, nil
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("cannot derive the gRPC address from the server %q, set grpc.address", baseURL)
+	}
+	return net.JoinHostPort(u.Hostname(), "443"), nil
+}
@@ -0,0 +1,190 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jetstack/preflight/api"
+	uploadv1 "github.com/jetstack/preflight/api/upload/v1"
+)
+
+// fakeUploadServer records the uploads, failing the first unavailable
+// attempts as if it was starting up. If block is set, the uploads block
+// until they are cancelled, and started receives each of them.
+type fakeUploadServer struct {
+	uploadv1.UnimplementedUploadServiceServer
+
+	unavailable int
+	block       bool
+	started     chan struct{}
+	attempts    int
+	headers     []*uploadv1.UploadHeader
+	readings    []*uploadv1.DataReading
+}
+
+func (s *fakeUploadServer) UploadDataReadings(stream uploadv1.UploadService_UploadDataReadingsServer) error {
+	s.attempts++
+	if s.block {
+		s.started <- struct{}{}
+		<-stream.Context().Done()
+		return stream.Context().Err()
+	}
+	if s.attempts <= s.unavailable {
+		return grpcstatus.Error(codes.Unavailable, "starting up")
+	}
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	if auth := md.Get("authorization"); len(auth) != 1 || auth[0] != "Bearer token" {
+		return grpcstatus.Error(codes.Unauthenticated, "invalid token")
+	}
+	count := int64(0)
+	for {
+		request, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if header := request.GetHeader(); header != nil {
+			s.headers = append(s.headers, header)
+			continue
+		}
+		s.readings = append(s.readings, request.GetReading())
+		count++
+	}
+	return stream.SendAndClose(&uploadv1.UploadDataReadingsResponse{Readings: count})
+}
+
+func TestGRPCBackend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &fakeUploadServer{unavailable: 1}
+	server := grpc.NewServer()
+	uploadv1.RegisterUploadServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	defer func(interval time.Duration) { uploadRetryInterval = interval }(uploadRetryInterval)
+	uploadRetryInterval = time.Millisecond
+
+	config := Config{
+		OrganizationID: "example",
+		ClusterID:      "example-cluster",
+		Backend:        BackendGRPC,
+		GRPC:           &GRPCConfig{Address: listener.Addr().String(), Insecure: true},
+	}
+	c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{Version: "v1.0.0"}, "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the upload is retried by the agent, the client makes a single attempt
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = uploadReadings(context.Background(), config, false, c, &api.AgentMetadata{}, []*api.DataReading{
+		{DataGatherer: "k8s/pods", Timestamp: api.Time{Time: timestamp}, Data: map[string]int{"pods": 3}, SchemaVersion: "v2.0.0", Labels: map[string]string{"team": "a"}},
+		{DataGatherer: "k8s/secrets", Timestamp: api.Time{Time: timestamp}, Data: []string{}},
+	}, "retrying")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fake.attempts != 2 {
+		t.Errorf("expected the unavailable upload to be retried once, got %d attempts", fake.attempts)
+	}
+
+	if len(fake.headers) != 1 || fake.headers[0].OrganizationId != "example" || fake.headers[0].ClusterId != "example-cluster" || fake.headers[0].SchemaVersion != api.SchemaVersion {
+		t.Fatalf("unexpected headers: %v", fake.headers)
+	}
+	var agentMetadata api.AgentMetadata
+	if err := json.Unmarshal(fake.headers[0].AgentMetadata, &agentMetadata); err != nil || agentMetadata.Version != "v1.0.0" {
+		t.Errorf("unexpected agent metadata: %q", fake.headers[0].AgentMetadata)
+	}
+
+	expected := []*uploadv1.DataReading{
+		{
+			DataGatherer: "k8s/pods",
+			Timestamp:    timestamppb.New(timestamp),
+			Data:         []byte(`{"pods":3}`),
+			// the reading of the older schema version is migrated
+			SchemaVersion: "v2.1.0",
+			Labels:        map[string]string{"team": "a"},
+			DataVersion:   "v1",
+		},
+		{
+			DataGatherer: "k8s/secrets",
+			Timestamp:    timestamppb.New(timestamp),
+			Data:         []byte(`[]`),
+		},
+	}
+	if len(fake.readings) != len(expected) {
+		t.Fatalf("expected %d readings, got %d", len(expected), len(fake.readings))
+	}
+	for i := range expected {
+		if !proto.Equal(expected[i], fake.readings[i]) {
+			t.Errorf("unexpected reading %d: expected %v, got %v", i, expected[i], fake.readings[i])
+		}
+	}
+
+	// the rejected token fails the upload
+	c, err = createClient(backendCredentials{apiToken: "other"}, config, &api.AgentMetadata{}, "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = c.PostDataReadings("example", "example-cluster", nil)
+	if grpcstatus.Code(err) != codes.Unauthenticated || fake.attempts != 3 {
+		t.Errorf("unexpected error after %d attempts: %v", fake.attempts, err)
+	}
+}
+
+func TestGRPCBackendUploadCancelled(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeUploadServer{block: true, started: make(chan struct{}, 1)}
+	server := grpc.NewServer()
+	uploadv1.RegisterUploadServiceServer(server, fake)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	config := Config{
+		OrganizationID: "example",
+		ClusterID:      "example-cluster",
+		Backend:        BackendGRPC,
+		GRPC:           &GRPCConfig{Address: listener.Addr().String(), Insecure: true, Timeout: time.Hour},
+	}
+	c, err := createClient(backendCredentials{}, config, &api.AgentMetadata{}, "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the context of the cycle is cancelled during the upload, which is
+	// neither waited for nor retried
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-fake.started
+		cancel()
+	}()
+	err = uploadReadings(ctx, config, false, c, &api.AgentMetadata{}, []*api.DataReading{{DataGatherer: "k8s/pods"}}, "retrying")
+	if err == nil || !strings.Contains(err.Error(), "code = Canceled") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if fake.attempts != 1 {
+		t.Errorf("expected a single attempt, got %d", fake.attempts)
+	}
+}
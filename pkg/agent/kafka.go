@@ -0,0 +1,114 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// KafkaConfig configures the kafka backend.
+type KafkaConfig struct {
+	// Brokers are the host and port of the bootstrap brokers.
+	Brokers []string `yaml:"brokers"`
+	// Topic is the topic the readings are produced to, a record per reading
+	// with its data gatherer in the Preflight-Data-Gatherer header.
+	Topic string `yaml:"topic"`
+	// TopicPerGatherer, if set, produces the readings of each data gatherer
+	// to its own topic instead, the topic suffixed with the name of the data
+	// gatherer, e.g. <topic>.k8s_pods.
+	TopicPerGatherer bool `yaml:"topic-per-gatherer,omitempty"`
+	// Timeout is the deadline of each upload. Defaults to 1m.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// TLS, if set, connects to the brokers over TLS.
+	TLS *KafkaTLSConfig `yaml:"tls,omitempty"`
+	// SASL, if set, authenticates to the brokers with SASL.
+	SASL *KafkaSASLConfig `yaml:"sasl,omitempty"`
+}
+
+// KafkaTLSConfig configures the TLS connections to the brokers.
+type KafkaTLSConfig struct {
+	// CAPath, if set, is the path of the CA certificates the brokers are
+	// verified with, rather than the system ones.
+	CAPath string `yaml:"ca-path,omitempty"`
+	// TLSCertPath and TLSKeyPath, if set, are the client certificate
+	// presented to the brokers.
+	TLSCertPath string `yaml:"tls-cert-path,omitempty"`
+	TLSKeyPath  string `yaml:"tls-key-path,omitempty"`
+}
+
+// KafkaSASLConfig configures the SASL authentication to the brokers.
+type KafkaSASLConfig struct {
+	// Mechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512.
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	// PasswordPath is the path of a file holding the password, read again
+	// for each upload.
+	PasswordPath string `yaml:"password-path"`
+}
+
+func (c *KafkaConfig) validate() error {
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("kafka.brokers is required")
+	}
+	for _, broker := range c.Brokers {
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			return fmt.Errorf("kafka.brokers must be hosts and ports: %s", err)
+		}
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("kafka.topic is required")
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("kafka.timeout must not be negative")
+	}
+	if c.TLS != nil && (c.TLS.TLSCertPath == "") != (c.TLS.TLSKeyPath == "") {
+		return fmt.Errorf("kafka.tls.tls-cert-path and kafka.tls.tls-key-path must be set together")
+	}
+	if c.SASL != nil {
+		switch c.SASL.Mechanism {
+		case client.KafkaSASLPlain, client.KafkaSASLSCRAMSHA256, client.KafkaSASLSCRAMSHA512:
+		default:
+			return fmt.Errorf("kafka.sasl.mechanism must be %s, %s or %s", client.KafkaSASLPlain, client.KafkaSASLSCRAMSHA256, client.KafkaSASLSCRAMSHA512)
+		}
+		if c.SASL.Username == "" {
+			return fmt.Errorf("kafka.sasl.username is required")
+		}
+		if c.SASL.PasswordPath == "" {
+			return fmt.Errorf("kafka.sasl.password-path is required")
+		}
+	}
+	return nil
+}
+
+// createKafkaClient creates the client of the kafka backend, which
+// authenticates with the credentials of its own configuration rather than
+// those of the server.
+func createKafkaClient(creds backendCredentials, config Config) (client.Client, error) {
+	if creds.clientID != "" || creds.credentialsPath != "" || creds.apiToken != "" || creds.workloadIdentity != nil {
+		return nil, fmt.Errorf("the %s backend authenticates with the kafka credentials, not the credentials of the server", BackendKafka)
+	}
+
+	c := config.Kafka
+	opts := client.KafkaOptions{
+		Brokers:          c.Brokers,
+		Topic:            c.Topic,
+		TopicPerGatherer: c.TopicPerGatherer,
+		Timeout:          c.Timeout,
+	}
+	if c.TLS != nil {
+		opts.TLS = true
+		opts.CAPath = c.TLS.CAPath
+		opts.TLSCertPath = c.TLS.TLSCertPath
+		opts.TLSKeyPath = c.TLS.TLSKeyPath
+	}
+	if c.SASL != nil {
+		opts.SASLMechanism = c.SASL.Mechanism
+		opts.Username = c.SASL.Username
+		opts.PasswordPath = c.SASL.PasswordPath
+	}
+	log.Printf("Producing data readings to the Kafka topic %s", c.Topic)
+	return client.NewKafkaClient(opts)
+}
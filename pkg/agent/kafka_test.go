@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/metadata"
+	"github.com/segmentio/kafka-go/protocol/produce"
+	"github.com/segmentio/kafka-go/protocol/saslauthenticate"
+	"github.com/segmentio/kafka-go/protocol/saslhandshake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// kafkaTestRecord is a record produced to the fake broker.
+type kafkaTestRecord struct {
+	Topic     string
+	Partition int32
+	Key       string
+	Value     string
+	Headers   map[string]string
+}
+
+// fakeKafkaBroker is a single broker cluster, leader of the two partitions
+// of each of its topics, which authenticates with SASL PLAIN if a password is
+// set.
+type fakeKafkaBroker struct {
+	t        *testing.T
+	listener net.Listener
+	password string
+	topics   []string
+
+	mu      sync.Mutex
+	records []kafkaTestRecord
+}
+
+func newFakeKafkaBroker(t *testing.T, password string, topics ...string) *fakeKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeKafkaBroker{t: t, listener: listener, password: password, topics: topics}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+func (b *fakeKafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	authenticated := b.password == ""
+	for {
+		version, correlationID, _, request, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return
+		}
+		var response protocol.Message
+		switch request := request.(type) {
+		case *apiversions.Request:
+			keys := &apiversions.Response{}
+			for _, key := range []protocol.ApiKey{protocol.Produce, protocol.Metadata, protocol.SaslHandshake, protocol.ApiVersions, protocol.SaslAuthenticate} {
+				keys.ApiKeys = append(keys.ApiKeys, apiversions.ApiKeyResponse{ApiKey: int16(key), MinVersion: key.MinVersion(), MaxVersion: key.MaxVersion()})
+			}
+			response = keys
+		case *saslhandshake.Request:
+			response = &saslhandshake.Response{Mechanisms: []string{request.Mechanism}}
+		case *saslauthenticate.Request:
+			authenticated = string(request.AuthBytes) == "\x00agent\x00"+b.password
+			if authenticated {
+				response = &saslauthenticate.Response{}
+			} else {
+				response = &saslauthenticate.Response{ErrorCode: 58, ErrorMessage: "invalid credentials"}
+			}
+		case *metadata.Request:
+			if !authenticated {
+				return
+			}
+			host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+			portNumber, _ := strconv.Atoi(port)
+			topics := &metadata.Response{
+				Brokers:      []metadata.ResponseBroker{{NodeID: 1, Host: host, Port: int32(portNumber)}},
+				ControllerID: 1,
+			}
+			names := request.TopicNames
+			if names == nil {
+				names = b.topics
+			}
+			for _, topic := range names {
+				partitions := []metadata.ResponsePartition{
+					{PartitionIndex: 0, LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}},
+					{PartitionIndex: 1, LeaderID: 1, ReplicaNodes: []int32{1}, IsrNodes: []int32{1}},
+				}
+				topics.Topics = append(topics.Topics, metadata.ResponseTopic{Name: topic, Partitions: partitions})
+			}
+			response = topics
+		case *produce.Request:
+			if !authenticated {
+				return
+			}
+			if request.Acks != -1 {
+				b.t.Errorf("expected acks from all the replicas, got %d", request.Acks)
+			}
+			produced := &produce.Response{}
+			for _, topic := range request.Topics {
+				partitions := []produce.ResponsePartition{}
+				for _, partition := range topic.Partitions {
+					b.readRecords(topic.Topic, partition.Partition, partition.RecordSet.Records)
+					partitions = append(partitions, produce.ResponsePartition{Partition: partition.Partition})
+				}
+				produced.Topics = append(produced.Topics, produce.ResponseTopic{Topic: topic.Topic, Partitions: partitions})
+			}
+			response = produced
+		default:
+			b.t.Errorf("unexpected request %T", request)
+			return
+		}
+		if err := protocol.WriteResponse(conn, version, correlationID, response); err != nil {
+			return
+		}
+	}
+}
+
+// readRecords records the records produced to a partition.
+func (b *fakeKafkaBroker) readRecords(topic string, partition int32, records protocol.RecordReader) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		record, err := records.ReadRecord()
+		if err != nil {
+			if err != io.EOF {
+				b.t.Errorf("invalid record: %s", err)
+			}
+			return
+		}
+		key, _ := protocol.ReadAll(record.Key)
+		value, _ := protocol.ReadAll(record.Value)
+		rec := kafkaTestRecord{Topic: topic, Partition: partition, Key: string(key), Value: string(value), Headers: map[string]string{}}
+		for _, header := range record.Headers {
+			rec.Headers[header.Key] = string(header.Value)
+		}
+		b.records = append(b.records, rec)
+	}
+}
+
+func TestKafkaBackend(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "secret", "preflight", "preflight.k8s_pods", "preflight.k8s_secrets")
+	passwordPath := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordPath, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	readings := []*api.DataReading{
+		{DataGatherer: "k8s/pods", Timestamp: api.Time{Time: timestamp}, Data: map[string]int{"pods": 3}},
+		{DataGatherer: "k8s/secrets", Timestamp: api.Time{Time: timestamp}, Data: []string{}},
+	}
+	for _, topicPerGatherer := range []bool{false, true} {
+		config := Config{
+			Backend: BackendKafka,
+			Kafka: &KafkaConfig{
+				Brokers:          []string{"127.0.0.1:1", broker.listener.Addr().String()},
+				Topic:            "preflight",
+				TopicPerGatherer: topicPerGatherer,
+				Timeout:          5 * time.Second,
+				SASL:             &KafkaSASLConfig{Mechanism: "PLAIN", Username: "agent", PasswordPath: passwordPath},
+			},
+		}
+		if err := config.validateBackend(false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		c, err := createClient(backendCredentials{}, config, &api.AgentMetadata{}, "https://example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := c.PostDataReadings("example", "example-cluster", readings); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	headers := func(dataGatherer string) map[string]string {
+		return map[string]string{
+			"Content-Type":              "application/json",
+			"Preflight-Organization-Id": "example",
+			"Preflight-Cluster-Id":      "example-cluster",
+			"Preflight-Data-Gatherer":   dataGatherer,
+		}
+	}
+	pods := `{"data-gatherer":"k8s/pods","timestamp":"2024-01-02T03:04:05Z","data":{"pods":3},"schema_version":""}`
+	secrets := `{"data-gatherer":"k8s/secrets","timestamp":"2024-01-02T03:04:05Z","data":[],"schema_version":""}`
+	// the partition of the cluster, as the Java client would pick it
+	expected := []kafkaTestRecord{
+		{Topic: "preflight", Partition: 1, Key: "example-cluster", Value: pods, Headers: headers("k8s/pods")},
+		{Topic: "preflight", Partition: 1, Key: "example-cluster", Value: secrets, Headers: headers("k8s/secrets")},
+		{Topic: "preflight.k8s_pods", Partition: 1, Key: "example-cluster", Value: pods, Headers: headers("k8s/pods")},
+		{Topic: "preflight.k8s_secrets", Partition: 1, Key: "example-cluster", Value: secrets, Headers: headers("k8s/secrets")},
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	// the topics are produced to in any order
+	sort.SliceStable(broker.records, func(i, j int) bool { return broker.records[i].Topic < broker.records[j].Topic })
+	if diff, equal := messagediff.PrettyDiff(expected, broker.records); !equal {
+		t.Errorf("unexpected records:\n%s", diff)
+	}
+}
+
+func TestKafkaBackendAuthenticationFailure(t *testing.T) {
+	broker := newFakeKafkaBroker(t, "secret")
+	passwordPath := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordPath, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		Backend: BackendKafka,
+		Kafka: &KafkaConfig{
+			Brokers: []string{broker.listener.Addr().String()},
+			Topic:   "preflight",
+			SASL:    &KafkaSASLConfig{Mechanism: "PLAIN", Username: "agent", PasswordPath: passwordPath},
+		},
+	}
+	c, err := createClient(backendCredentials{}, config, &api.AgentMetadata{}, "https://example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = c.PostDataReadings("example", "example-cluster", []*api.DataReading{{DataGatherer: "k8s/pods"}})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !errors.Is(err, kafka.SASLAuthenticationFailed) {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
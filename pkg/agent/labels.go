@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var invalidMetricLabelChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// metricLabelName is the name of a label in the labels metric: the label
+// prefixed with label_, like the Kubernetes labels exposed by
+// kube-state-metrics, with the characters Prometheus doesn't allow replaced
+// by underscores, e.g. label_cost_center for cost-center.
+func metricLabelName(label string) string {
+	return "label_" + invalidMetricLabelChars.ReplaceAllString(label, "_")
+}
+
+// validateLabels checks that the labels are not empty and can be told apart
+// in the labels metric.
+func validateLabels(labels map[string]string) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result *multierror.Error
+	names := map[string]string{}
+	for _, key := range keys {
+		if key == "" {
+			result = multierror.Append(result, fmt.Errorf("labels: label names must not be empty"))
+			continue
+		}
+		name := metricLabelName(key)
+		if other, ok := names[name]; ok {
+			result = multierror.Append(result, fmt.Errorf("labels: %q and %q are both exposed as %s in metrics", other, key, name))
+			continue
+		}
+		names[name] = key
+	}
+	return result.ErrorOrNil()
+}
+
+// newLabelsMetric creates the labels metric, which is always 1 and has the
+// labels of the agent, so that they can be joined with its other metrics on
+// the organization and cluster.
+func newLabelsMetric(config Config) prometheus.Gauge {
+	constLabels := prometheus.Labels{
+		"organization": config.OrganizationID,
+		"cluster":      config.ClusterID,
+	}
+	for key, value := range config.Labels {
+		constLabels[metricLabelName(key)] = value
+	}
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "jscp",
+		Subsystem:   "agent",
+		Name:        "labels",
+		Help:        "Identity labels of the jscp in-cluster agent, as label_<name> labels. Always 1.",
+		ConstLabels: constLabels,
+	})
+	gauge.Set(1)
+	return gauge
+}
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestValidateLabels(t *testing.T) {
+	if err := validateLabels(map[string]string{"team": "payments", "cost-center": "cc-42"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err := validateLabels(map[string]string{"cost-center": "a", "cost_center": "b", "": "c"})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{"must not be empty", `"cost-center" and "cost_center" are both exposed as label_cost_center`} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected error to contain %q, got: %s", expected, err)
+		}
+	}
+}
+
+func TestLabelsMetric(t *testing.T) {
+	metric := newLabelsMetric(Config{
+		OrganizationID: "org",
+		ClusterID:      "cluster",
+		Labels:         map[string]string{"team": "payments", "cost-center": "cc-42"},
+	})
+	expected := `
+# HELP jscp_agent_labels Identity labels of the jscp in-cluster agent, as label_<name> labels. Always 1.
+# TYPE jscp_agent_labels gauge
+jscp_agent_labels{cluster="cluster",label_cost_center="cc-42",label_team="payments",organization="org"} 1
+`
+	if err := testutil.CollectAndCompare(metric, strings.NewReader(expected)); err != nil {
+		t.Errorf("unexpected metric: %s", err)
+	}
+}
@@ -12,4 +12,39 @@ var (
 			Name:      "data_readings_upload_size",
 			Help:      "Data readings upload size (in bytes) sent by the jscp in-cluster agent.",
 		}, []string{"organization", "cluster"})
+	metricUncleanTerminations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jscp",
+			Subsystem: "agent",
+			Name:      "unclean_terminations_total",
+			Help:      "Number of times the jscp in-cluster agent did not terminate cleanly, as recorded in its state file.",
+		}, []string{"organization", "cluster"})
+	metricCycleCPUSeconds = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jscp",
+			Subsystem: "agent",
+			Name:      "cycle_cpu_seconds",
+			Help:      "CPU time used by the jscp in-cluster agent in its previous cycle.",
+		}, []string{"organization", "cluster"})
+	metricResourceLimitExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jscp",
+			Subsystem: "agent",
+			Name:      "resource_limit_exceeded_total",
+			Help:      "Number of cycles in which the jscp in-cluster agent exceeded one of its configured resource limits.",
+		}, []string{"organization", "cluster", "limit"})
+	metricMirrorUploadFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "jscp",
+			Subsystem: "agent",
+			Name:      "mirror_upload_failures_total",
+			Help:      "Number of cycles in which the jscp in-cluster agent failed to upload data to its mirror.",
+		}, []string{"organization", "cluster"})
+	metricAlertsFiring = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "jscp",
+			Subsystem: "agent",
+			Name:      "alerts_firing",
+			Help:      "Number of values each alert rule of the jscp in-cluster agent triggered for in its previous cycle.",
+		}, []string{"organization", "cluster", "rule"})
 )
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// MirrorConfig configures a second backend the data is uploaded to, along
+// with the server, e.g. to validate a new backend API during a migration.
+// The mirror has its own API, credentials and organization and cluster IDs,
+// and failing to upload to it doesn't stop the agent.
+type MirrorConfig struct {
+	// Server is the base URL of the mirror.
+	Server string `yaml:"server"`
+	// OrganizationID and ClusterID default to those of the server.
+	OrganizationID string `yaml:"organization_id,omitempty"`
+	ClusterID      string `yaml:"cluster_id,omitempty"`
+	// VenafiCloud, if set, uploads to the mirror with the Venafi Cloud API.
+	// It is implied by ClientID.
+	VenafiCloud *VenafiCloudConfig `yaml:"venafi-cloud,omitempty"`
+	// ClientID and PrivateKeyPath are the Venafi Cloud service account of
+	// the mirror.
+	ClientID       string `yaml:"client-id,omitempty"`
+	PrivateKeyPath string `yaml:"private-key-path,omitempty"`
+	// CredentialsPath is the path to the credentials file of the mirror.
+	CredentialsPath string `yaml:"credentials-file,omitempty"`
+	// APIToken is the API token of the mirror, or a reference to it in a
+	// secret manager.
+	APIToken string `yaml:"api-token,omitempty"`
+	// NextAPIToken is used once the mirror rejects APIToken, during a
+	// rotation.
+	NextAPIToken string `yaml:"next-api-token,omitempty"`
+	// WorkloadIdentity, if set, authenticates to the mirror with a workload
+	// identity token.
+	WorkloadIdentity *WorkloadIdentityConfig `yaml:"workload-identity,omitempty"`
+}
+
+func (c *MirrorConfig) validate() error {
+	var result *multierror.Error
+	if c.Server == "" {
+		result = multierror.Append(result, fmt.Errorf("mirror.server is required"))
+	}
+	if c.ClientID != "" && c.PrivateKeyPath == "" {
+		result = multierror.Append(result, fmt.Errorf("mirror.private-key-path is required with mirror.client-id"))
+	}
+	if c.NextAPIToken != "" && c.APIToken == "" {
+		result = multierror.Append(result, fmt.Errorf("mirror.api-token is required with mirror.next-api-token"))
+	}
+	if c.WorkloadIdentity != nil {
+		if err := c.WorkloadIdentity.validate("mirror.workload-identity"); err != nil {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+func (c *MirrorConfig) venafiCloudMode() bool {
+	return c.VenafiCloud != nil || c.ClientID != ""
+}
+
+// mirror uploads data to the mirror backend.
+type mirror struct {
+	config          Config
+	venafiCloudMode bool
+	client          client.Client
+	agentMetadata   *api.AgentMetadata
+}
+
+// newMirror creates the client of the mirror configured in config.
+func newMirror(config Config, agentMetadata *api.AgentMetadata) (*mirror, error) {
+	m := config.Mirror
+	mirrorConfig := config
+	mirrorConfig.Server = m.Server
+	mirrorConfig.Endpoint = Endpoint{}
+	mirrorConfig.VenafiCloud = m.VenafiCloud
+	// the mirror is always uploaded to over HTTP
+	mirrorConfig.Backend = ""
+	mirrorConfig.GRPC = nil
+	mirrorConfig.NATS = nil
+	mirrorConfig.Kafka = nil
+	if m.OrganizationID != "" {
+		mirrorConfig.OrganizationID = m.OrganizationID
+	}
+	if m.ClusterID != "" {
+		mirrorConfig.ClusterID = m.ClusterID
+	}
+
+	log.Printf("Creating client for mirror %s", m.Server)
+	c, err := createClient(backendCredentials{
+		clientID:         m.ClientID,
+		privateKeyPath:   m.PrivateKeyPath,
+		credentialsPath:  m.CredentialsPath,
+		apiToken:         m.APIToken,
+		nextAPIToken:     m.NextAPIToken,
+		venafiCloudMode:  m.venafiCloudMode(),
+		workloadIdentity: m.WorkloadIdentity,
+	}, mirrorConfig, agentMetadata, m.Server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mirror{config: mirrorConfig, venafiCloudMode: m.venafiCloudMode(), client: c, agentMetadata: agentMetadata}, nil
+}
+
+// post uploads the readings to the mirror, retrying like uploads to the
+// server. Failures are logged and counted, but not fatal.
+func (m *mirror) post(ctx context.Context, readings []*api.DataReading) {
+	ctx, span := tracer.Start(ctx, "mirror upload", trace.WithAttributes(attribute.String("server", m.config.Server)))
+	defer span.End()
+	err := uploadReadings(ctx, m.config, m.venafiCloudMode, m.client, m.agentMetadata, readings, "retrying upload to mirror")
+	if err != nil {
+		metricMirrorUploadFailures.With(
+			prometheus.Labels{"organization": m.config.OrganizationID, "cluster": m.config.ClusterID},
+		).Inc()
+		log.Printf("failed to upload data to mirror %s: %s", m.config.Server, err)
+	}
+}
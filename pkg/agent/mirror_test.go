@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestMirror(t *testing.T) {
+	var paths, tokens []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		paths = append(paths, r.URL.Path)
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	defer func(d time.Duration) { BackoffMaxTime = d }(BackoffMaxTime)
+	BackoffMaxTime = time.Nanosecond
+
+	config := Config{
+		Server:         "https://preflight.jetstack.io",
+		OrganizationID: "example",
+		ClusterID:      "example-cluster",
+		Mirror:         &MirrorConfig{Server: server.URL, ClusterID: "mirror-cluster", APIToken: "mirror-token"},
+	}
+	m, err := newMirror(config, &api.AgentMetadata{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	m.post(context.Background(), []*api.DataReading{{DataGatherer: "dummy"}})
+	if len(paths) != 1 || paths[0] != "/api/v1/org/example/datareadings/mirror-cluster" {
+		t.Errorf("unexpected requests: %v", paths)
+	}
+	if len(tokens) != 1 || tokens[0] != "Bearer mirror-token" {
+		t.Errorf("unexpected authorization: %v", tokens)
+	}
+
+	// failures are counted but not fatal
+	status = http.StatusInternalServerError
+	m.post(context.Background(), []*api.DataReading{{DataGatherer: "dummy"}})
+	failures := testutil.ToFloat64(metricMirrorUploadFailures.With(prometheus.Labels{"organization": "example", "cluster": "mirror-cluster"}))
+	if failures != 1 {
+		t.Errorf("expected 1 failure, got %v", failures)
+	}
+}
+
+func TestMirrorConfigValidate(t *testing.T) {
+	err := (&MirrorConfig{ClientID: "id"}).validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := "2 errors occurred:\n\t* mirror.server is required\n\t* mirror.private-key-path is required with mirror.client-id\n\n"
+	if err.Error() != expected {
+		t.Errorf("unexpected error: %q", err)
+	}
+}
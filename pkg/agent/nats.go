@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// NATSConfig configures the nats backend.
+type NATSConfig struct {
+	// URL is the URL of the NATS server, or a comma separated list of them,
+	// e.g. nats://nats.example.com:4222. Use tls:// to require TLS.
+	URL string `yaml:"url"`
+	// Subject is the subject the readings are published to. It must be
+	// bound to a JetStream stream.
+	Subject string `yaml:"subject"`
+	// Mode is snapshot, the default, publishing the readings of a gathering
+	// as a single message, or per-gatherer, publishing a message per reading
+	// to the subject suffixed with the name of its data gatherer.
+	Mode string `yaml:"mode,omitempty"`
+	// Timeout is the deadline of the acknowledgement of each message by
+	// JetStream. Defaults to 1m.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// CredentialsPath is the path of a .creds file holding a user JWT and
+	// its NKey seed.
+	CredentialsPath string `yaml:"credentials-path,omitempty"`
+	// NKeySeedPath is the path of a file holding an NKey seed.
+	NKeySeedPath string `yaml:"nkey-seed-path,omitempty"`
+	// TokenPath is the path of a file holding a token, read again on every
+	// connection.
+	TokenPath string `yaml:"token-path,omitempty"`
+	// Username and PasswordPath authenticate with a user and password.
+	Username     string `yaml:"username,omitempty"`
+	PasswordPath string `yaml:"password-path,omitempty"`
+
+	// CAPath, if set, is the path of the CA certificates the server is
+	// verified with, rather than the system ones.
+	CAPath string `yaml:"ca-path,omitempty"`
+	// TLSCertPath and TLSKeyPath, if set, are the client certificate
+	// presented to the server.
+	TLSCertPath string `yaml:"tls-cert-path,omitempty"`
+	TLSKeyPath  string `yaml:"tls-key-path,omitempty"`
+}
+
+func (c *NATSConfig) validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("nats.url is required")
+	}
+	if c.Subject == "" {
+		return fmt.Errorf("nats.subject is required")
+	}
+	switch c.Mode {
+	case "", client.NATSModeSnapshot, client.NATSModePerGatherer:
+	default:
+		return fmt.Errorf("nats.mode must be %s or %s", client.NATSModeSnapshot, client.NATSModePerGatherer)
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("nats.timeout must not be negative")
+	}
+
+	credentials := 0
+	for _, set := range []bool{c.CredentialsPath != "", c.NKeySeedPath != "", c.TokenPath != "", c.Username != ""} {
+		if set {
+			credentials++
+		}
+	}
+	if credentials > 1 {
+		return fmt.Errorf("only one of nats.credentials-path, nats.nkey-seed-path, nats.token-path and nats.username can be set")
+	}
+	if (c.Username == "") != (c.PasswordPath == "") {
+		return fmt.Errorf("nats.username and nats.password-path must be set together")
+	}
+	if (c.TLSCertPath == "") != (c.TLSKeyPath == "") {
+		return fmt.Errorf("nats.tls-cert-path and nats.tls-key-path must be set together")
+	}
+	return nil
+}
+
+// createNATSClient creates the client of the nats backend, which
+// authenticates with the credentials of its own configuration rather than
+// those of the server.
+func createNATSClient(creds backendCredentials, config Config, agentMetadata *api.AgentMetadata) (client.Client, error) {
+	if creds.clientID != "" || creds.credentialsPath != "" || creds.apiToken != "" || creds.workloadIdentity != nil {
+		return nil, fmt.Errorf("the %s backend authenticates with the nats credentials, not the credentials of the server", BackendNATS)
+	}
+
+	c := config.NATS
+	log.Printf("Publishing data readings to the NATS subject %s", c.Subject)
+	return client.NewNATSClient(agentMetadata, c.URL, client.NATSOptions{
+		Subject:         c.Subject,
+		Mode:            c.Mode,
+		Timeout:         c.Timeout,
+		CredentialsPath: c.CredentialsPath,
+		NKeySeedPath:    c.NKeySeedPath,
+		TokenPath:       c.TokenPath,
+		Username:        c.Username,
+		PasswordPath:    c.PasswordPath,
+		CAPath:          c.CAPath,
+		TLSCertPath:     c.TLSCertPath,
+		TLSKeyPath:      c.TLSKeyPath,
+	})
+}
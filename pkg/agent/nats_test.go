@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// natsMsg is a message published to the fake NATS server.
+type natsMsg struct {
+	Subject string
+	Headers map[string]string
+	Data    string
+}
+
+// fakeNATSServer speaks enough of the NATS protocol to accept a connection
+// and acknowledge the messages published to JetStream.
+type fakeNATSServer struct {
+	listener net.Listener
+	token    string
+
+	mu       sync.Mutex
+	messages []natsMsg
+}
+
+func newFakeNATSServer(t *testing.T, token string) *fakeNATSServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeNATSServer{listener: listener, token: token}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeNATSServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeNATSServer) published() []natsMsg {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]natsMsg(nil), s.messages...)
+}
+
+func (s *fakeNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"headers\":true,\"max_payload\":1048576,\"auth_required\":true}\r\n")
+
+	// inboxes are the subscriptions of the client by sid, the agent only
+	// subscribes to its inboxes
+	inboxes := map[string]string{}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			var connect struct {
+				AuthToken string `json:"auth_token"`
+			}
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "CONNECT ")), &connect)
+			if connect.AuthToken != s.token {
+				fmt.Fprintf(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case "SUB":
+			inboxes[fields[len(fields)-1]] = strings.TrimSuffix(fields[1], "*")
+		case "HPUB":
+			headerSize, _ := strconv.Atoi(fields[3])
+			size, _ := strconv.Atoi(fields[4])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			msg := natsMsg{Subject: fields[1], Headers: map[string]string{}, Data: string(payload[headerSize:size])}
+			for _, header := range strings.Split(string(payload[:headerSize]), "\r\n")[1:] {
+				if key, value, ok := strings.Cut(header, ": "); ok {
+					msg.Headers[key] = value
+				}
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			seq := len(s.messages)
+			s.mu.Unlock()
+
+			for sid, prefix := range inboxes {
+				if strings.HasPrefix(fields[2], prefix) {
+					ack := fmt.Sprintf(`{"stream":"PREFLIGHT","seq":%d}`, seq)
+					fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[2], sid, len(ack), ack)
+				}
+			}
+		}
+	}
+}
+
+func TestNATSBackend(t *testing.T) {
+	server := newFakeNATSServer(t, "secret")
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	readings := []*api.DataReading{
+		{DataGatherer: "k8s/pods", Timestamp: api.Time{Time: timestamp}, Data: map[string]int{"pods": 3}},
+		{DataGatherer: "k8s/secrets", Timestamp: api.Time{Time: timestamp.Add(time.Second)}, Data: []string{}},
+	}
+	for _, mode := range []string{client.NATSModeSnapshot, client.NATSModePerGatherer} {
+		config := Config{
+			OrganizationID: "example",
+			ClusterID:      "example-cluster",
+			Backend:        BackendNATS,
+			NATS: &NATSConfig{
+				URL:       server.url(),
+				Subject:   "preflight.readings",
+				Mode:      mode,
+				Timeout:   5 * time.Second,
+				TokenPath: tokenPath,
+			},
+		}
+		if err := config.validateBackend(false); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		c, err := createClient(backendCredentials{}, config, &api.AgentMetadata{Version: "v1.0.0"}, "https://example.com")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := c.PostDataReadings("example", "example-cluster", readings); err != nil {
+			t.Fatalf("%s: unexpected error: %s", mode, err)
+		}
+	}
+
+	messages := server.published()
+	if len(messages) != 3 {
+		t.Fatalf("expected 3 messages, got %d", len(messages))
+	}
+	var snapshot api.DataReadingsPost
+	if err := json.Unmarshal([]byte(messages[0].Data), &snapshot); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if snapshot.AgentMetadata.Version != "v1.0.0" || len(snapshot.DataReadings) != 2 {
+		t.Errorf("unexpected snapshot: %s", messages[0].Data)
+	}
+	messages[0].Data = ""
+
+	expected := []natsMsg{
+		{
+			Subject: "preflight.readings",
+			Headers: map[string]string{
+				"Content-Type":              "application/json",
+				"Nats-Msg-Id":               "example-cluster/2024-01-02T03:04:06Z",
+				"Preflight-Organization-Id": "example",
+				"Preflight-Cluster-Id":      "example-cluster",
+			},
+		},
+		{
+			Subject: "preflight.readings.k8s_pods",
+			Headers: map[string]string{
+				"Content-Type":              "application/json",
+				"Nats-Msg-Id":               "example-cluster/k8s/pods/2024-01-02T03:04:05Z",
+				"Preflight-Organization-Id": "example",
+				"Preflight-Cluster-Id":      "example-cluster",
+				"Preflight-Data-Gatherer":   "k8s/pods",
+			},
+			Data: `{"data-gatherer":"k8s/pods","timestamp":"2024-01-02T03:04:05Z","data":{"pods":3},"schema_version":""}`,
+		},
+		{
+			Subject: "preflight.readings.k8s_secrets",
+			Headers: map[string]string{
+				"Content-Type":              "application/json",
+				"Nats-Msg-Id":               "example-cluster/k8s/secrets/2024-01-02T03:04:06Z",
+				"Preflight-Organization-Id": "example",
+				"Preflight-Cluster-Id":      "example-cluster",
+				"Preflight-Data-Gatherer":   "k8s/secrets",
+			},
+			Data: `{"data-gatherer":"k8s/secrets","timestamp":"2024-01-02T03:04:06Z","data":[],"schema_version":""}`,
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, messages); !equal {
+		t.Errorf("unexpected messages:\n%s", diff)
+	}
+}
+
+func TestNATSBackendCredentials(t *testing.T) {
+	config := Config{Backend: BackendNATS, NATS: &NATSConfig{URL: "nats://127.0.0.1:4222", Subject: "preflight.readings"}}
+	_, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, "https://example.com")
+	expected := "the nats backend authenticates with the nats credentials, not the credentials of the server"
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// The formats of the webhooks.
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+	WebhookFormatTeams = "teams"
+
+	defaultNotificationsMinSeverity = api.SeverityMedium
+	defaultNotificationsTimeout     = 10 * time.Second
+)
+
+// defaultNotificationTemplate is the template of the text of the
+// notifications.
+const defaultNotificationTemplate = `{{.Total}} finding{{if ne .Total 1}}s{{end}} in cluster {{.ClusterID}}:
+{{range .Summaries}}- {{.Count}} {{.RuleID}} ({{.Severity}}, {{.DataGatherer}}): {{.Message}}{{if .Others}} and {{.Others}} more{{end}}
+{{end}}`
+
+// NotificationsConfig posts a summary of the findings of each cycle to
+// webhooks.
+type NotificationsConfig struct {
+	// MinSeverity is the lowest severity of the findings notified, unless a
+	// webhook sets its own. Defaults to medium.
+	MinSeverity api.Severity `yaml:"min-severity,omitempty"`
+	// Timeout is the timeout of each post. Defaults to 10s.
+	Timeout  time.Duration   `yaml:"timeout,omitempty"`
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// WebhookConfig is a webhook the notifications are posted to.
+type WebhookConfig struct {
+	// Name identifies the webhook in the logs.
+	Name string `yaml:"name"`
+	// URL of the webhook. As the URLs of Slack and Teams webhooks are
+	// secrets, it can be read from the file at URLPath instead.
+	URL     string `yaml:"url,omitempty"`
+	URLPath string `yaml:"url-path,omitempty"`
+	// Format is json, the default, posting the summary as JSON, or slack or
+	// teams, posting the text of the summary as an incoming webhook message.
+	Format string `yaml:"format,omitempty"`
+	// Template, if set, is a Go template rendering the text of the
+	// message, or the whole body with the json format, from the summary.
+	Template string `yaml:"template,omitempty"`
+	// MinSeverity, if set, overrides the minimum severity of the
+	// notifications for this webhook.
+	MinSeverity api.Severity `yaml:"min-severity,omitempty"`
+	// Headers are added to the requests, e.g. for authentication.
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+func (c *NotificationsConfig) validate() error {
+	var result *multierror.Error
+	if c.MinSeverity != "" && c.MinSeverity.Rank() < 0 {
+		result = multierror.Append(result, fmt.Errorf("notifications.min-severity: invalid severity %q", c.MinSeverity))
+	}
+	if c.Timeout < 0 {
+		result = multierror.Append(result, fmt.Errorf("notifications.timeout must not be negative"))
+	}
+	if len(c.Webhooks) == 0 {
+		result = multierror.Append(result, fmt.Errorf("notifications.webhooks is required"))
+	}
+	names := map[string]bool{}
+	for i, webhook := range c.Webhooks {
+		prefix := fmt.Sprintf("notifications.webhooks[%d]", i)
+		if webhook.Name == "" {
+			result = multierror.Append(result, fmt.Errorf("%s is missing a name", prefix))
+		} else if names[webhook.Name] {
+			result = multierror.Append(result, fmt.Errorf("%s: duplicate name %q", prefix, webhook.Name))
+		}
+		names[webhook.Name] = true
+		if (webhook.URL == "") == (webhook.URLPath == "") {
+			result = multierror.Append(result, fmt.Errorf("%s: exactly one of url and url-path must be set", prefix))
+		} else if webhook.URL != "" {
+			if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				result = multierror.Append(result, fmt.Errorf("%s.url is not a valid http(s) URL", prefix))
+			}
+		}
+		switch webhook.Format {
+		case "", WebhookFormatJSON, WebhookFormatSlack, WebhookFormatTeams:
+		default:
+			result = multierror.Append(result, fmt.Errorf("%s.format must be %s, %s or %s", prefix, WebhookFormatJSON, WebhookFormatSlack, WebhookFormatTeams))
+		}
+		if webhook.MinSeverity != "" && webhook.MinSeverity.Rank() < 0 {
+			result = multierror.Append(result, fmt.Errorf("%s.min-severity: invalid severity %q", prefix, webhook.MinSeverity))
+		}
+		if _, err := webhook.template(); err != nil {
+			result = multierror.Append(result, fmt.Errorf("%s.template: %s", prefix, err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// template parses the template of the webhook, nil if there is none.
+func (c *WebhookConfig) template() (*template.Template, error) {
+	if c.Template == "" {
+		return nil, nil
+	}
+	return template.New(c.Name).Option("missingkey=error").Parse(c.Template)
+}
+
+// notification is the summary of the findings of a cycle, which the
+// templates render.
+type notification struct {
+	OrganizationID string           `json:"organization_id,omitempty"`
+	ClusterID      string           `json:"cluster_id"`
+	Time           time.Time        `json:"time"`
+	Total          int              `json:"total"`
+	Severities     map[string]int   `json:"severities"`
+	Summaries      []findingSummary `json:"summaries"`
+}
+
+// findingSummary is the findings of a rule of a data gatherer.
+type findingSummary struct {
+	DataGatherer string       `json:"data_gatherer"`
+	RuleID       string       `json:"rule_id"`
+	Severity     api.Severity `json:"severity"`
+	Count        int          `json:"count"`
+	// Message is the message of the first finding, and Others the number
+	// of the other findings.
+	Message   string   `json:"message"`
+	Others    int      `json:"-"`
+	Resources []string `json:"resources"`
+}
+
+// summarizeFindings summarizes the findings of the readings of at least
+// minSeverity, the most severe and most frequent first.
+func summarizeFindings(config Config, readings []*api.DataReading, minSeverity api.Severity, now time.Time) notification {
+	n := notification{
+		OrganizationID: config.OrganizationID,
+		ClusterID:      config.ClusterID,
+		Time:           now.UTC(),
+		Severities:     map[string]int{},
+		Summaries:      []findingSummary{},
+	}
+	index := map[[2]string]int{}
+	for _, reading := range readings {
+		for _, finding := range reading.Findings {
+			if finding.Severity.Rank() < minSeverity.Rank() {
+				continue
+			}
+			n.Total++
+			n.Severities[string(finding.Severity)]++
+			key := [2]string{reading.DataGatherer, finding.RuleID}
+			i, ok := index[key]
+			if !ok {
+				i = len(n.Summaries)
+				index[key] = i
+				n.Summaries = append(n.Summaries, findingSummary{
+					DataGatherer: reading.DataGatherer,
+					RuleID:       finding.RuleID,
+					Message:      finding.Message,
+				})
+			}
+			summary := &n.Summaries[i]
+			summary.Count++
+			summary.Others = summary.Count - 1
+			if finding.Severity.Rank() > summary.Severity.Rank() {
+				summary.Severity = finding.Severity
+			}
+			summary.Resources = append(summary.Resources, finding.Resource.String())
+		}
+	}
+	sort.SliceStable(n.Summaries, func(i, j int) bool {
+		a, b := n.Summaries[i], n.Summaries[j]
+		if a.Severity != b.Severity {
+			return a.Severity.Rank() > b.Severity.Rank()
+		}
+		return a.Count > b.Count
+	})
+	return n
+}
+
+// notifyFindings posts the summary of the findings of the readings to the
+// webhooks. The webhooks with no findings to report are skipped, and the
+// failures are logged rather than failing the cycle.
+func notifyFindings(ctx context.Context, config Config, readings []*api.DataReading) {
+	c := config.Notifications
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultNotificationsTimeout
+	}
+	httpClient := &http.Client{Timeout: timeout}
+	now := time.Now()
+	for _, webhook := range c.Webhooks {
+		minSeverity := webhook.MinSeverity
+		if minSeverity == "" {
+			minSeverity = c.MinSeverity
+		}
+		if minSeverity == "" {
+			minSeverity = defaultNotificationsMinSeverity
+		}
+		n := summarizeFindings(config, readings, minSeverity, now)
+		if n.Total == 0 {
+			continue
+		}
+		if err := postNotification(ctx, httpClient, webhook, n); err != nil {
+			log.Printf("failed to notify webhook %q: %s", webhook.Name, err)
+		}
+	}
+}
+
+// postNotification renders and posts the notification to the webhook.
+func postNotification(ctx context.Context, httpClient *http.Client, webhook WebhookConfig, n notification) error {
+	body, err := renderNotification(webhook, n)
+	if err != nil {
+		return err
+	}
+	webhookURL := webhook.URL
+	if webhook.URLPath != "" {
+		b, err := os.ReadFile(webhook.URLPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the url: %w", err)
+		}
+		webhookURL = strings.TrimSpace(string(b))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range webhook.Headers {
+		req.Header.Set(key, value)
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		errorContent, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, errorContent)
+	}
+	return nil
+}
+
+// renderNotification returns the body posted to the webhook.
+func renderNotification(webhook WebhookConfig, n notification) ([]byte, error) {
+	tmpl, err := webhook.template()
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		tmpl = template.Must(template.New("default").Parse(defaultNotificationTemplate))
+	} else if webhook.Format == "" || webhook.Format == WebhookFormatJSON {
+		// the template renders the whole body
+		var body bytes.Buffer
+		if err := tmpl.Execute(&body, n); err != nil {
+			return nil, fmt.Errorf("failed to render the template: %w", err)
+		}
+		return body.Bytes(), nil
+	}
+
+	var text strings.Builder
+	if err := tmpl.Execute(&text, n); err != nil {
+		return nil, fmt.Errorf("failed to render the template: %w", err)
+	}
+	switch webhook.Format {
+	case WebhookFormatSlack:
+		return json.Marshal(map[string]string{"text": text.String()})
+	case WebhookFormatTeams:
+		// Teams collapses single line breaks
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("%d findings in cluster %s", n.Total, n.ClusterID),
+			"text":     strings.ReplaceAll(strings.TrimSpace(text.String()), "\n", "\n\n"),
+		})
+	default:
+		return json.Marshal(struct {
+			notification
+			Text string `json:"text"`
+		}{n, text.String()})
+	}
+}
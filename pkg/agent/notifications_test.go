@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func notificationReadings() []*api.DataReading {
+	expiring := func(name string) api.Finding {
+		return api.Finding{
+			RuleID:   "certificate-expiring",
+			Severity: api.SeverityHigh,
+			Resource: api.ResourceRef{Kind: "Certificate", Namespace: "default", Name: name},
+			Message:  name + " expires within 7 days",
+		}
+	}
+	return []*api.DataReading{
+		{
+			DataGatherer: "k8s/certificates",
+			Findings:     []api.Finding{expiring("web"), expiring("api"), expiring("db")},
+		},
+		{
+			DataGatherer: "k8s/images",
+			Findings: []api.Finding{
+				{RuleID: "outdated-image", Severity: api.SeverityMedium, Resource: api.ResourceRef{Kind: "Deployment", Namespace: "default", Name: "web"}, Message: "nginx:1.19 is outdated"},
+				{RuleID: "latest-tag", Severity: api.SeverityLow, Resource: api.ResourceRef{Kind: "Deployment", Namespace: "default", Name: "web"}, Message: "nginx:latest"},
+			},
+		},
+		{
+			DataGatherer: "k8s/keys",
+			Findings: []api.Finding{
+				{RuleID: "weak-key", Severity: api.SeverityCritical, Resource: api.ResourceRef{Kind: "Secret", Namespace: "default", Name: "legacy"}, Message: "RSA key size 1024 is below the minimum of 2048"},
+			},
+		},
+	}
+}
+
+func TestSummarizeFindings(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	n := summarizeFindings(Config{ClusterID: "example-cluster"}, notificationReadings(), api.SeverityMedium, now)
+	expected := notification{
+		ClusterID:  "example-cluster",
+		Time:       now,
+		Total:      5,
+		Severities: map[string]int{"critical": 1, "high": 3, "medium": 1},
+		Summaries: []findingSummary{
+			{DataGatherer: "k8s/keys", RuleID: "weak-key", Severity: api.SeverityCritical, Count: 1, Message: "RSA key size 1024 is below the minimum of 2048", Resources: []string{"Secret/default/legacy"}},
+			{DataGatherer: "k8s/certificates", RuleID: "certificate-expiring", Severity: api.SeverityHigh, Count: 3, Others: 2, Message: "web expires within 7 days", Resources: []string{"Certificate/default/web", "Certificate/default/api", "Certificate/default/db"}},
+			{DataGatherer: "k8s/images", RuleID: "outdated-image", Severity: api.SeverityMedium, Count: 1, Message: "nginx:1.19 is outdated", Resources: []string{"Deployment/default/web"}},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, n); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+}
+
+func TestNotifyFindings(t *testing.T) {
+	bodies := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies[r.URL.Path] = string(body)
+		if r.URL.Path == "/json" && r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("missing authorization header")
+		}
+	}))
+	defer server.Close()
+
+	urlPath := filepath.Join(t.TempDir(), "url")
+	if err := os.WriteFile(urlPath, []byte(server.URL+"/slack\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		ClusterID: "example-cluster",
+		Notifications: &NotificationsConfig{
+			Webhooks: []WebhookConfig{
+				{Name: "slack", URLPath: urlPath, Format: WebhookFormatSlack, MinSeverity: api.SeverityHigh},
+				{Name: "teams", URL: server.URL + "/teams", Format: WebhookFormatTeams, Template: `{{range .Summaries}}{{.Count}} {{.RuleID}}` + "\n" + `{{end}}`},
+				{Name: "json", URL: server.URL + "/json", Headers: map[string]string{"Authorization": "Bearer token"}},
+				{Name: "custom", URL: server.URL + "/custom", Template: `{"cluster": "{{.ClusterID}}", "critical": {{index .Severities "critical"}}}`},
+				{Name: "quiet", URL: server.URL + "/quiet", MinSeverity: api.SeverityCritical},
+			},
+		},
+	}
+	if err := config.Notifications.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	readings := notificationReadings()
+	readings[2].Findings = nil
+	notifyFindings(context.Background(), config, readings)
+
+	expectedSlack := `{"text":"3 findings in cluster example-cluster:\n- 3 certificate-expiring (high, k8s/certificates): web expires within 7 days and 2 more\n"}`
+	if bodies["/slack"] != expectedSlack {
+		t.Errorf("unexpected slack body: %s", bodies["/slack"])
+	}
+	expectedTeams := `{"@context":"https://schema.org/extensions","@type":"MessageCard","summary":"4 findings in cluster example-cluster","text":"3 certificate-expiring\n\n1 outdated-image"}`
+	if bodies["/teams"] != expectedTeams {
+		t.Errorf("unexpected teams body: %s", bodies["/teams"])
+	}
+	if bodies["/custom"] != `{"cluster": "example-cluster", "critical": 0}` {
+		t.Errorf("unexpected custom body: %s", bodies["/custom"])
+	}
+	if _, ok := bodies["/quiet"]; ok {
+		t.Errorf("expected no notification without findings")
+	}
+
+	var body struct {
+		notification
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal([]byte(bodies["/json"]), &body); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if body.Total != 4 || len(body.Summaries) != 2 || !strings.HasPrefix(body.Text, "4 findings in cluster example-cluster:\n") {
+		t.Errorf("unexpected json body: %s", bodies["/json"])
+	}
+}
+
+func TestNotificationsConfigValidate(t *testing.T) {
+	config := NotificationsConfig{
+		MinSeverity: "urgent",
+		Webhooks: []WebhookConfig{
+			{Name: "a", URL: "ftp://example.com", Format: "email"},
+			{Name: "a", Template: "{{.Total"},
+		},
+	}
+	err := config.validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{
+		`notifications.min-severity: invalid severity "urgent"`,
+		"notifications.webhooks[0].url is not a valid http(s) URL",
+		"notifications.webhooks[0].format must be json, slack or teams",
+		`notifications.webhooks[1]: duplicate name "a"`,
+		"notifications.webhooks[1]: exactly one of url and url-path must be set",
+		"notifications.webhooks[1].template: template: a:1: unclosed action",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %s", expected, err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// OnboardingConfig enables data gatherers and namespaces progressively over
+// several cycles, so that the first run on a large cluster does not overwhelm
+// the API server or the backend.
+type OnboardingConfig struct {
+	// DataGatherersPerCycle is the number of data gatherers started each
+	// cycle. Defaults to 1.
+	DataGatherersPerCycle int `yaml:"data-gatherers-per-cycle"`
+	// NamespacesPerCycle is the number of namespaces added to the readings
+	// each cycle. If 0, all namespaces are sent straight away.
+	NamespacesPerCycle int `yaml:"namespaces-per-cycle"`
+	// MaxErrorRate is the fraction of failing data gatherers above which
+	// onboarding is paused.
+	MaxErrorRate float64 `yaml:"max-error-rate"`
+	// MaxPayloadSize is the size in bytes of the readings above which
+	// onboarding is paused. If 0, the size is not limited.
+	MaxPayloadSize int `yaml:"max-payload-size"`
+}
+
+func (o *OnboardingConfig) validate() error {
+	if o.DataGatherersPerCycle < 0 {
+		return fmt.Errorf("onboarding.data-gatherers-per-cycle must not be negative")
+	}
+	if o.NamespacesPerCycle < 0 {
+		return fmt.Errorf("onboarding.namespaces-per-cycle must not be negative")
+	}
+	if o.MaxErrorRate < 0 || o.MaxErrorRate > 1 {
+		return fmt.Errorf("onboarding.max-error-rate must be between 0 and 1")
+	}
+	if o.MaxPayloadSize < 0 {
+		return fmt.Errorf("onboarding.max-payload-size must not be negative")
+	}
+	return nil
+}
+
+// onboarding tracks the progress of onboarding across cycles.
+type onboarding struct {
+	config OnboardingConfig
+	// pending are the data gatherers that have not been started yet.
+	pending []DataGatherer
+	// namespaces is the number of namespaces included in the readings.
+	namespaces int
+	// totalNamespaces is the number of namespaces seen in the last readings.
+	totalNamespaces int
+	// filtered is set once readings have been filtered, from then on
+	// totalNamespaces is known.
+	filtered bool
+	// healthy is false if the last cycle exceeded the error rate or payload
+	// size.
+	healthy bool
+}
+
+func newOnboarding(config OnboardingConfig, dataGatherers []DataGatherer) *onboarding {
+	if config.DataGatherersPerCycle == 0 {
+		config.DataGatherersPerCycle = 1
+	}
+	return &onboarding{
+		config:  config,
+		pending: dataGatherers,
+		healthy: true,
+	}
+}
+
+// complete returns true once all data gatherers and namespaces are enabled.
+func (o *onboarding) complete() bool {
+	if len(o.pending) > 0 {
+		return false
+	}
+	return o.config.NamespacesPerCycle == 0 || (o.filtered && o.namespaces >= o.totalNamespaces)
+}
+
+// next returns the data gatherers to start this cycle and includes more
+// namespaces, unless the last cycle was unhealthy.
+func (o *onboarding) next() []DataGatherer {
+	if o.complete() {
+		return nil
+	}
+	if !o.healthy {
+		log.Printf("onboarding paused, waiting for the error rate and payload size to go down")
+		return nil
+	}
+
+	o.namespaces += o.config.NamespacesPerCycle
+
+	n := o.config.DataGatherersPerCycle
+	if n > len(o.pending) {
+		n = len(o.pending)
+	}
+	next := o.pending[:n]
+	o.pending = o.pending[n:]
+
+	if o.complete() {
+		log.Printf("onboarding complete, all data gatherers and namespaces are enabled")
+	}
+
+	return next
+}
+
+// filter removes the resources of namespaces that have not been enabled yet
+// from the readings. Namespaces are enabled in alphabetical order.
+func (o *onboarding) filter(readings []*api.DataReading) []*api.DataReading {
+	if o.config.NamespacesPerCycle == 0 {
+		return readings
+	}
+
+	seen := map[string]bool{}
+	for _, reading := range readings {
+		for _, item := range readingItems(reading) {
+			if namespace := resourceNamespace(item); namespace != "" {
+				seen[namespace] = true
+			}
+		}
+	}
+	namespaces := make([]string, 0, len(seen))
+	for namespace := range seen {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	o.totalNamespaces = len(namespaces)
+	o.filtered = true
+
+	if o.namespaces >= len(namespaces) {
+		return readings
+	}
+	enabled := map[string]bool{}
+	for _, namespace := range namespaces[:o.namespaces] {
+		enabled[namespace] = true
+	}
+
+	filtered := make([]*api.DataReading, 0, len(readings))
+	for _, reading := range readings {
+		items := readingItems(reading)
+		if items == nil {
+			filtered = append(filtered, reading)
+			continue
+		}
+
+		var kept = []*api.GatheredResource{}
+		for _, item := range items {
+			if namespace := resourceNamespace(item); namespace == "" || enabled[namespace] {
+				kept = append(kept, item)
+			}
+		}
+		copied := *reading
+		copied.Data = map[string]interface{}{"items": kept}
+		filtered = append(filtered, &copied)
+	}
+
+	log.Printf("onboarding %d of %d namespaces", o.namespaces, len(namespaces))
+
+	return filtered
+}
+
+// record checks the error rate and payload size of a cycle, pausing
+// onboarding if either is too high.
+func (o *onboarding) record(readings []*api.DataReading, dataGatherers int) {
+	o.healthy = true
+
+	if dataGatherers > 0 {
+		errorRate := float64(dataGatherers-len(readings)) / float64(dataGatherers)
+		if errorRate > o.config.MaxErrorRate {
+			log.Printf("onboarding error rate %.2f is above the maximum of %.2f", errorRate, o.config.MaxErrorRate)
+			o.healthy = false
+		}
+	}
+
+	if o.config.MaxPayloadSize > 0 {
+		data, err := json.Marshal(readings)
+		if err != nil {
+			log.Printf("failed to measure the onboarding payload size: %s", err)
+			o.healthy = false
+			return
+		}
+		if len(data) > o.config.MaxPayloadSize {
+			log.Printf("onboarding payload size of %d bytes is above the maximum of %d bytes", len(data), o.config.MaxPayloadSize)
+			o.healthy = false
+		}
+	}
+}
+
+// readingItems returns the resources of a k8s-dynamic reading, or nil for
+// other readings.
+func readingItems(reading *api.DataReading) []*api.GatheredResource {
+	data, ok := reading.Data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	items, _ := data["items"].([]*api.GatheredResource)
+	return items
+}
+
+func resourceNamespace(item *api.GatheredResource) string {
+	if resource, ok := item.Resource.(interface{ GetNamespace() string }); ok {
+		return resource.GetNamespace()
+	}
+	return ""
+}
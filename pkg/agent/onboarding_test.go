@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func testReading(namespaces ...string) *api.DataReading {
+	items := []*api.GatheredResource{}
+	for _, namespace := range namespaces {
+		resource := &unstructured.Unstructured{}
+		resource.SetNamespace(namespace)
+		resource.SetName("example")
+		items = append(items, &api.GatheredResource{Resource: resource})
+	}
+	return &api.DataReading{DataGatherer: "k8s/pods", Data: map[string]interface{}{"items": items}}
+}
+
+func readingNamespaces(readings []*api.DataReading) []string {
+	var namespaces []string
+	for _, reading := range readings {
+		for _, item := range readingItems(reading) {
+			namespaces = append(namespaces, resourceNamespace(item))
+		}
+	}
+	return namespaces
+}
+
+func TestOnboarding(t *testing.T) {
+	dataGatherers := []DataGatherer{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+	o := newOnboarding(OnboardingConfig{DataGatherersPerCycle: 2, NamespacesPerCycle: 2}, dataGatherers)
+	reading := testReading("d", "c", "b", "a", "")
+
+	// first cycle
+	if diff, equal := messagediff.PrettyDiff(dataGatherers[:2], o.next()); !equal {
+		t.Errorf("unexpected data gatherers:\n%s", diff)
+	}
+	readings := o.filter([]*api.DataReading{reading})
+	if diff, equal := messagediff.PrettyDiff([]string{"b", "a", ""}, readingNamespaces(readings)); !equal {
+		t.Errorf("unexpected namespaces:\n%s", diff)
+	}
+	// one of the two data gatherers failed
+	o.record(readings, 2)
+
+	// onboarding is paused
+	if next := o.next(); len(next) != 0 {
+		t.Errorf("expected onboarding to be paused, got %v", next)
+	}
+	o.record(readings, 1)
+
+	// second cycle
+	if diff, equal := messagediff.PrettyDiff(dataGatherers[2:], o.next()); !equal {
+		t.Errorf("unexpected data gatherers:\n%s", diff)
+	}
+	readings = o.filter([]*api.DataReading{reading})
+	if diff, equal := messagediff.PrettyDiff([]string{"d", "c", "b", "a", ""}, readingNamespaces(readings)); !equal {
+		t.Errorf("unexpected namespaces:\n%s", diff)
+	}
+	if !o.complete() {
+		t.Errorf("expected onboarding to be complete")
+	}
+}
+
+func TestOnboardingMaxPayloadSize(t *testing.T) {
+	o := newOnboarding(OnboardingConfig{MaxPayloadSize: 10}, []DataGatherer{{Name: "a"}, {Name: "b"}})
+
+	o.next()
+	o.record([]*api.DataReading{testReading("a")}, 1)
+	if o.healthy {
+		t.Errorf("expected onboarding to be paused when the payload size is exceeded")
+	}
+}
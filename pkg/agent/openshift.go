@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// openShiftResources are the resources gathered when OpenShift support is
+// enabled in the config.
+var openShiftResources = []schema.GroupVersionResource{
+	{Group: "route.openshift.io", Version: "v1", Resource: "routes"},
+	{Group: "config.openshift.io", Version: "v1", Resource: "clusteroperators"},
+	{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"},
+}
+
+// addOpenShiftDataGatherers appends an optional k8s-dynamic data gatherer for
+// each of the openShiftResources, unless a data gatherer with the same name
+// has already been configured.
+func addOpenShiftDataGatherers(dataGatherers []DataGatherer) []DataGatherer {
+	names := map[string]bool{}
+	for _, dg := range dataGatherers {
+		names[dg.Name] = true
+	}
+
+	for _, gvr := range openShiftResources {
+		name := "k8s/" + gvr.Resource + "." + gvr.Version + "." + gvr.Group
+		if names[name] {
+			continue
+		}
+		dataGatherers = append(dataGatherers, DataGatherer{
+			Kind: "k8s-dynamic",
+			Name: name,
+			Config: &k8s.ConfigDynamic{
+				GroupVersionResource: gvr,
+				Optional:             true,
+			},
+		})
+	}
+
+	return dataGatherers
+}
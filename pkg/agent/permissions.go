@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// CheckPermissionsOnStartup flag causes the agent to check the permissions
+// of all data gatherers before the first run, and to exit if any is missing
+var CheckPermissionsOnStartup bool
+
+// permissionsChecker is implemented by the configurations of the data
+// gatherers that read from the Kubernetes API.
+type permissionsChecker interface {
+	CheckPermissions(ctx context.Context) ([]k8s.PermissionCheck, error)
+}
+
+// gathererPermissions is the result of checking the permissions of a data
+// gatherer.
+type gathererPermissions struct {
+	Name   string
+	Kind   string
+	Checks []k8s.PermissionCheck
+	Err    error
+}
+
+// missing returns the number of permissions that are not allowed.
+func (p gathererPermissions) missing() int {
+	n := 0
+	for _, check := range p.Checks {
+		if !check.Allowed {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckPermissions prints a report of the permissions the configured data
+// gatherers are missing, and exits with a non-zero status if any is.
+func CheckPermissions(cmd *cobra.Command, args []string) {
+	b, err := os.ReadFile(ConfigFilePath)
+	if err != nil {
+		log.Fatalf("Failed to read config file: %s", err)
+	}
+	config, err := ParseConfig(b, VenafiCloudMode || ClientID != "")
+	if err != nil {
+		log.Fatalf("Failed to parse config file: %s", err)
+	}
+
+	results := checkPermissions(context.Background(), config.DataGatherers)
+	if !printPermissionsReport(os.Stdout, results) {
+		os.Exit(1)
+	}
+}
+
+// checkPermissionsOnStartup logs a report of the missing permissions of the
+// data gatherers and exits if any is missing.
+func checkPermissionsOnStartup(ctx context.Context, dataGatherers []DataGatherer) {
+	log.Printf("checking the permissions of the data gatherers")
+	results := checkPermissions(ctx, dataGatherers)
+	if !printPermissionsReport(log.Writer(), results) {
+		log.Fatalf("the agent is missing permissions, see the report above")
+	}
+}
+
+// checkPermissions reviews the permissions of each data gatherer, with the
+// rate limit and in the cluster of the data gatherer if it has one.
+func checkPermissions(ctx context.Context, dataGatherers []DataGatherer) []gathererPermissions {
+	var results []gathererPermissions
+	for _, dg := range dataGatherers {
+		checker, ok := dg.Config.(permissionsChecker)
+		if !ok {
+			continue
+		}
+
+		checks, err := checker.CheckPermissions(dataGathererContext(ctx, dg))
+		results = append(results, gathererPermissions{Name: dg.key(), Kind: dg.Kind, Checks: checks, Err: err})
+	}
+	return results
+}
+
+// printPermissionsReport prints the missing permissions of each data
+// gatherer and returns whether none is missing.
+func printPermissionsReport(out io.Writer, results []gathererPermissions) bool {
+	ok := true
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATA GATHERER\tKIND\tPERMISSIONS\tMISSING")
+	for _, result := range results {
+		switch {
+		case result.Err != nil:
+			ok = false
+			fmt.Fprintf(w, "%s\t%s\t-\terror: %s\n", result.Name, result.Kind, result.Err)
+		default:
+			missing := result.missing()
+			if missing > 0 {
+				ok = false
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", result.Name, result.Kind, len(result.Checks), missing)
+		}
+	}
+	w.Flush()
+
+	for _, result := range results {
+		if result.missing() == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "\n%s is not allowed to:\n", result.Name)
+		for _, check := range result.Checks {
+			if check.Allowed {
+				continue
+			}
+			fmt.Fprintf(out, "  - %s%s\n", check.Permission, reasonSuffix(check.Reason))
+		}
+	}
+
+	if ok {
+		fmt.Fprintln(out, "\nall the data gatherers have the permissions they need")
+	}
+	return ok
+}
+
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
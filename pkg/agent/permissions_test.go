@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+func TestPrintPermissionsReport(t *testing.T) {
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	results := []gathererPermissions{
+		{
+			Name: "k8s/pods",
+			Kind: "k8s-dynamic",
+			Checks: []k8s.PermissionCheck{
+				{Permission: k8s.Permission{Verb: "list", GroupVersionResource: pods}, Allowed: true},
+				{Permission: k8s.Permission{Verb: "watch", GroupVersionResource: pods}, Reason: "no RBAC policy matched"},
+			},
+		},
+		{Name: "k8s/rbac", Kind: "k8s-rbac", Err: fmt.Errorf("connection refused")},
+	}
+
+	var out bytes.Buffer
+	if printPermissionsReport(&out, results) {
+		t.Errorf("expected the report to fail")
+	}
+	expected := `DATA GATHERER  KIND         PERMISSIONS  MISSING
+k8s/pods       k8s-dynamic  2            1
+k8s/rbac       k8s-rbac     -            error: connection refused
+
+k8s/pods is not allowed to:
+  - watch "/v1, Resource=pods" in all namespaces (no RBAC policy matched)
+`
+	if out.String() != expected {
+		t.Errorf("unexpected report:\n%s\nwant:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	results[0].Checks[1].Allowed = true
+	if !printPermissionsReport(&out, results[:1]) {
+		t.Errorf("expected the report to succeed")
+	}
+}
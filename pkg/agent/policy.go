@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"github.com/hashicorp/go-multierror"
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// PolicyModeAlongside uploads the policy results alongside the data of
+	// the readings.
+	PolicyModeAlongside = "alongside"
+	// PolicyModeInstead uploads the policy results instead of the data of
+	// the readings the rules were evaluated on.
+	PolicyModeInstead = "instead"
+)
+
+// PoliciesConfig configures the rules evaluated by the agent on the gathered
+// resources before they are uploaded, so that policies can be checked on
+// premises.
+type PoliciesConfig struct {
+	// Mode is alongside, the default, to upload the results with the data,
+	// or instead, to upload the results without the data of the readings
+	// the rules were evaluated on.
+	Mode  string       `yaml:"mode,omitempty"`
+	Rules []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule is a CEL expression evaluated on each gathered resource. The
+// resource is the `resource` variable of the expressions.
+type PolicyRule struct {
+	// ID identifies the rule in the results and findings.
+	ID string `yaml:"id"`
+	// DataGatherers are the names of the data gatherers whose resources
+	// the rule is evaluated on. If empty, the rule is evaluated on the
+	// resources of all the data gatherers.
+	DataGatherers []string `yaml:"data-gatherers,omitempty"`
+	// Match, if set, is an expression selecting the resources the rule is
+	// evaluated on.
+	Match string `yaml:"match,omitempty"`
+	// Expression is true for the resources that pass the rule.
+	Expression string `yaml:"expression"`
+	// Severity is the severity of the findings of the resources failing
+	// the rule. Defaults to medium.
+	Severity api.Severity `yaml:"severity,omitempty"`
+	// Message is the message of the findings.
+	Message string `yaml:"message,omitempty"`
+	// Remediation is the remediation hint of the findings.
+	Remediation string `yaml:"remediation,omitempty"`
+}
+
+func (c *PoliciesConfig) validate() error {
+	var result *multierror.Error
+	if c.Mode != "" && c.Mode != PolicyModeAlongside && c.Mode != PolicyModeInstead {
+		result = multierror.Append(result, fmt.Errorf("policies.mode must be %s or %s", PolicyModeAlongside, PolicyModeInstead))
+	}
+	if _, err := newPolicyEngine(*c); err != nil {
+		result = multierror.Append(result, err)
+	}
+	return result.ErrorOrNil()
+}
+
+// policyEngine evaluates the compiled rules.
+type policyEngine struct {
+	mode  string
+	rules []*compiledPolicyRule
+}
+
+type compiledPolicyRule struct {
+	PolicyRule
+	dataGatherers map[string]bool
+	match         cel.Program
+	expression    cel.Program
+}
+
+// newPolicyEngine compiles the rules, and returns all their errors.
+func newPolicyEngine(config PoliciesConfig) (*policyEngine, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("resource", cel.DynType),
+		cel.CrossTypeNumericComparisons(true),
+		ext.Strings(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the CEL environment: %w", err)
+	}
+
+	var result *multierror.Error
+	engine := &policyEngine{mode: config.Mode}
+	if engine.mode == "" {
+		engine.mode = PolicyModeAlongside
+	}
+	ids := map[string]bool{}
+	for i, rule := range config.Rules {
+		if rule.ID == "" {
+			result = multierror.Append(result, fmt.Errorf("policies.rules[%d] is missing an id", i))
+			continue
+		}
+		if ids[rule.ID] {
+			result = multierror.Append(result, fmt.Errorf("policies.rules[%d]: duplicate id %q", i, rule.ID))
+			continue
+		}
+		ids[rule.ID] = true
+		switch rule.Severity {
+		case "":
+			rule.Severity = api.SeverityMedium
+		case api.SeverityInfo, api.SeverityLow, api.SeverityMedium, api.SeverityHigh, api.SeverityCritical:
+		default:
+			result = multierror.Append(result, fmt.Errorf("policies.rules[%d]: invalid severity %q", i, rule.Severity))
+			continue
+		}
+		if rule.Expression == "" {
+			result = multierror.Append(result, fmt.Errorf("policies.rules[%d] is missing an expression", i))
+			continue
+		}
+
+		compiled := &compiledPolicyRule{PolicyRule: rule}
+		if compiled.expression, err = compilePolicyExpression(env, rule.Expression); err != nil {
+			result = multierror.Append(result, fmt.Errorf("policies.rules[%d].expression: %s", i, err))
+			continue
+		}
+		if rule.Match != "" {
+			if compiled.match, err = compilePolicyExpression(env, rule.Match); err != nil {
+				result = multierror.Append(result, fmt.Errorf("policies.rules[%d].match: %s", i, err))
+				continue
+			}
+		}
+		if len(rule.DataGatherers) > 0 {
+			compiled.dataGatherers = map[string]bool{}
+			for _, name := range rule.DataGatherers {
+				compiled.dataGatherers[name] = true
+			}
+		}
+		engine.rules = append(engine.rules, compiled)
+	}
+	if result != nil {
+		return nil, result
+	}
+	return engine, nil
+}
+
+// compilePolicyExpression compiles an expression that must evaluate to a
+// bool.
+func compilePolicyExpression(env *cel.Env, expression string) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if t := ast.OutputType(); t != cel.BoolType && t != cel.DynType {
+		return nil, fmt.Errorf("must evaluate to a bool, not %s", t)
+	}
+	return env.Program(ast)
+}
+
+// applyPolicies evaluates the configured policies on the readings, if any.
+// The rules were already compiled when the config was validated, so errors
+// are only logged.
+func applyPolicies(config Config, readings []*api.DataReading) {
+	if config.Policies == nil {
+		return
+	}
+	engine, err := newPolicyEngine(*config.Policies)
+	if err != nil {
+		log.Printf("not evaluating policies: %s", err)
+		return
+	}
+	engine.apply(readings)
+}
+
+// apply evaluates the rules on the resources of the readings. The results
+// are added to the PolicyResults of the readings, and the failures to their
+// Findings. In the instead mode, the data of the readings the rules were
+// evaluated on is dropped.
+func (e *policyEngine) apply(readings []*api.DataReading) {
+	for _, reading := range readings {
+		var rules []*compiledPolicyRule
+		for _, rule := range e.rules {
+			if rule.dataGatherers == nil || rule.dataGatherers[reading.DataGatherer] {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		resources, err := policyResources(reading.Data)
+		if err != nil {
+			log.Printf("not evaluating policies on the data of %q: %s", reading.DataGatherer, err)
+			continue
+		}
+		if resources == nil {
+			continue
+		}
+
+		for _, rule := range rules {
+			for _, resource := range resources {
+				if result, ok := rule.evaluate(resource); ok {
+					reading.PolicyResults = append(reading.PolicyResults, result)
+					if !result.Passed && result.Error == "" {
+						reading.Findings = append(reading.Findings, rule.finding(result.Resource))
+					}
+				}
+			}
+		}
+		if e.mode == PolicyModeInstead {
+			reading.Data = nil
+		}
+	}
+}
+
+// evaluate returns the result of the rule for the resource, and false if
+// the rule doesn't match the resource.
+func (r *compiledPolicyRule) evaluate(resource map[string]interface{}) (api.PolicyResult, bool) {
+	result := api.PolicyResult{RuleID: r.ID, Resource: policyResourceRef(resource)}
+	activation := map[string]interface{}{"resource": resource}
+	if r.match != nil {
+		out, _, err := r.match.Eval(activation)
+		if err != nil {
+			result.Error = fmt.Sprintf("match: %s", err)
+			return result, true
+		}
+		if matched, ok := out.Value().(bool); !ok || !matched {
+			return result, false
+		}
+	}
+	out, _, err := r.expression.Eval(activation)
+	if err != nil {
+		result.Error = err.Error()
+		return result, true
+	}
+	passed, ok := out.Value().(bool)
+	if !ok {
+		result.Error = fmt.Sprintf("the expression evaluated to %v, not a bool", out.Value())
+		return result, true
+	}
+	result.Passed = passed
+	return result, true
+}
+
+func (r *compiledPolicyRule) finding(resource api.ResourceRef) api.Finding {
+	message := r.Message
+	if message == "" {
+		message = fmt.Sprintf("the resource violates the policy %s", r.ID)
+	}
+	return api.Finding{
+		RuleID:      r.ID,
+		Severity:    r.Severity,
+		Resource:    resource,
+		Message:     message,
+		Remediation: r.Remediation,
+	}
+}
+
+// policyResources returns the resources of the data of a reading, the items
+// of the data gatherers based on k8s-dynamic, or nil if the data holds no
+// resources. The data is converted to its JSON representation first, so
+// that the rules see the same fields as the backend, whether the readings
+// were gathered or read from a file. Deleted resources are left out.
+func policyResources(data interface{}) ([]map[string]interface{}, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var decoded struct {
+		Items []struct {
+			Resource  map[string]interface{} `json:"resource"`
+			DeletedAt string                 `json:"deleted_at"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		// the data of the other data gatherers has another shape
+		return nil, nil
+	}
+	if decoded.Items == nil {
+		return nil, nil
+	}
+	resources := make([]map[string]interface{}, 0, len(decoded.Items))
+	for _, item := range decoded.Items {
+		if item.Resource != nil && item.DeletedAt == "" {
+			resources = append(resources, item.Resource)
+		}
+	}
+	return resources, nil
+}
+
+// policyResourceRef returns the kind, namespace and name of a resource.
+func policyResourceRef(resource map[string]interface{}) api.ResourceRef {
+	ref := api.ResourceRef{}
+	ref.Kind, _ = resource["kind"].(string)
+	if metadata, ok := resource["metadata"].(map[string]interface{}); ok {
+		ref.Namespace, _ = metadata["namespace"].(string)
+		ref.Name, _ = metadata["name"].(string)
+	}
+	return ref
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func testPodReading(dataGatherer string, pods ...map[string]interface{}) *api.DataReading {
+	items := []*api.GatheredResource{}
+	for _, pod := range pods {
+		items = append(items, &api.GatheredResource{Resource: &unstructured.Unstructured{Object: pod}})
+	}
+	return &api.DataReading{DataGatherer: dataGatherer, Data: map[string]interface{}{"items": items}}
+}
+
+func testPod(namespace, name, image string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "app", "image": image}},
+		},
+	}
+}
+
+func TestPolicyEngine(t *testing.T) {
+	engine, err := newPolicyEngine(PoliciesConfig{
+		Rules: []PolicyRule{
+			{
+				ID:            "no-latest-tag",
+				DataGatherers: []string{"k8s/pods"},
+				Match:         `resource.metadata.namespace != "kube-system"`,
+				Expression:    `resource.spec.containers.all(c, !c.image.endsWith(":latest"))`,
+				Severity:      api.SeverityHigh,
+				Message:       "a container uses the latest tag",
+			},
+			{
+				ID:         "team-label",
+				Expression: `resource.metadata.labels.team != ""`,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pods := testPodReading("k8s/pods",
+		testPod("shop", "api", "api:1.2.3"),
+		testPod("shop", "web", "web:latest"),
+		testPod("kube-system", "proxy", "proxy:latest"),
+	)
+	other := &api.DataReading{DataGatherer: "k8s-rbac", Data: map[string]interface{}{"roles": []string{"admin"}}}
+	engine.apply([]*api.DataReading{pods, other})
+
+	expectedResults := []api.PolicyResult{
+		{RuleID: "no-latest-tag", Resource: api.ResourceRef{Kind: "Pod", Namespace: "shop", Name: "api"}, Passed: true},
+		{RuleID: "no-latest-tag", Resource: api.ResourceRef{Kind: "Pod", Namespace: "shop", Name: "web"}},
+		{RuleID: "team-label", Resource: api.ResourceRef{Kind: "Pod", Namespace: "shop", Name: "api"}, Error: "no such key: labels"},
+		{RuleID: "team-label", Resource: api.ResourceRef{Kind: "Pod", Namespace: "shop", Name: "web"}, Error: "no such key: labels"},
+		{RuleID: "team-label", Resource: api.ResourceRef{Kind: "Pod", Namespace: "kube-system", Name: "proxy"}, Error: "no such key: labels"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedResults, pods.PolicyResults); !equal {
+		t.Errorf("unexpected results:\n%s", diff)
+	}
+	expectedFindings := []api.Finding{
+		{RuleID: "no-latest-tag", Severity: api.SeverityHigh, Resource: api.ResourceRef{Kind: "Pod", Namespace: "shop", Name: "web"}, Message: "a container uses the latest tag"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedFindings, pods.Findings); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+	if pods.Data == nil {
+		t.Errorf("expected the data to be kept alongside the results")
+	}
+	if other.PolicyResults != nil || other.Findings != nil {
+		t.Errorf("expected no results for the data without resources, got %v", other.PolicyResults)
+	}
+}
+
+func TestPolicyEngine_Instead(t *testing.T) {
+	engine, err := newPolicyEngine(PoliciesConfig{
+		Mode:  PolicyModeInstead,
+		Rules: []PolicyRule{{ID: "named", DataGatherers: []string{"k8s/pods"}, Expression: `resource.metadata.name.size() > 0`}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pods := testPodReading("k8s/pods", testPod("shop", "api", "api:1.2.3"))
+	deployments := testPodReading("k8s/deployments")
+	engine.apply([]*api.DataReading{pods, deployments})
+
+	if pods.Data != nil {
+		t.Errorf("expected the data to be replaced by the results, got %v", pods.Data)
+	}
+	if len(pods.PolicyResults) != 1 || !pods.PolicyResults[0].Passed {
+		t.Errorf("unexpected results: %v", pods.PolicyResults)
+	}
+	if deployments.Data == nil {
+		t.Errorf("expected the data of the other data gatherers to be kept")
+	}
+}
+
+func TestPoliciesConfig_validate(t *testing.T) {
+	config := PoliciesConfig{
+		Mode: "replace",
+		Rules: []PolicyRule{
+			{ID: "a", Expression: `"not" + "bool"`},
+			{ID: "a", Expression: `true`},
+			{ID: "b", Expression: `resource.(`},
+			{ID: "c", Expression: `true`, Severity: "urgent"},
+			{Expression: `true`},
+		},
+	}
+	err := config.validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, expected := range []string{
+		"policies.mode must be alongside or instead",
+		"policies.rules[0].expression",
+		`policies.rules[1]: duplicate id "a"`,
+		"policies.rules[2].expression: ERROR",
+		`policies.rules[3]: invalid severity "urgent"`,
+		"policies.rules[4] is missing an id",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in the error, got:\n%s", expected, err)
+		}
+	}
+}
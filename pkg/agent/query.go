@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/query"
+)
+
+// QueryDatabasePath is the SQLite database queried by the query command
+var QueryDatabasePath string
+
+// SQLiteConfig loads the readings of each cycle into a local SQLite
+// database, which can be queried with `agent query`.
+type SQLiteConfig struct {
+	// Path is the path of the database file.
+	Path string `yaml:"path"`
+	// KeepCycles is the number of cycles kept in the database. Defaults to
+	// 1. If negative, all cycles are kept.
+	KeepCycles int `yaml:"keep-cycles"`
+}
+
+func (s *SQLiteConfig) validate() error {
+	if s.Path == "" {
+		return fmt.Errorf("sqlite.path is required")
+	}
+	return nil
+}
+
+// loadReadings stores the readings of a cycle in the database.
+func (s *SQLiteConfig) loadReadings(readings []*api.DataReading, gatheredAt time.Time) error {
+	keepCycles := s.KeepCycles
+	switch {
+	case keepCycles == 0:
+		keepCycles = 1
+	case keepCycles < 0:
+		keepCycles = 0
+	}
+
+	db, err := query.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Load(readings, gatheredAt, keepCycles)
+}
+
+// Query runs an SQL query against the database of readings and prints the
+// result.
+func Query(cmd *cobra.Command, args []string) {
+	db, err := query.OpenReadOnly(QueryDatabasePath)
+	if err != nil {
+		log.Fatalf("Failed to open database: %s", err)
+	}
+	defer db.Close()
+
+	if err := db.Query(os.Stdout, args[0]); err != nil {
+		log.Fatalf("Failed to run query: %s", err)
+	}
+}
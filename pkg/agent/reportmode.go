@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// ReportModeFull uploads the data of the readings as gathered.
+	ReportModeFull = "full"
+	// ReportModeFindings only uploads results derived from the gathered
+	// objects: summaries, findings and policy results.
+	ReportModeFindings = "findings"
+)
+
+// derivedDataKinds are the kinds of data gatherers whose data is derived
+// from the objects they read, rather than the objects themselves. Their data
+// is uploaded as is in the findings report mode.
+var derivedDataKinds = map[string]bool{
+	"k8s-discovery":                  true,
+	"k8s-rbac":                       true,
+	"k8s-webhooks":                   true,
+	"k8s-key-hygiene":                true,
+	"k8s-ingress-tls-policy":         true,
+	"k8s-cert-manager-logs":          true,
+	"k8s-encryption-at-rest":         true,
+	"k8s-helm-releases":              true,
+	"k8s-crds":                       true,
+	"k8s-api-deprecations":           true,
+	"k8s-istio":                      true,
+	"k8s-ingress-tls":                true,
+	"k8s-tls-probe":                  true,
+	"k8s-issuer-health":              true,
+	"k8s-issuance":                   true,
+	"k8s-cert-manager-events":        true,
+	"k8s-pod-security":               true,
+	"k8s-resource-counts":            true,
+	"k8s-control-plane-certificates": true,
+	"venafi-policy":                  true,
+	"agent":                          true,
+}
+
+func validateReportMode(mode string) error {
+	switch mode {
+	case "", ReportModeFull, ReportModeFindings:
+		return nil
+	}
+	return fmt.Errorf("report-mode must be %s or %s", ReportModeFull, ReportModeFindings)
+}
+
+// summarizeReadings replaces the data of the readings with derived results
+// in the findings report mode, so that the raw objects never leave the
+// cluster. The data of the data gatherers implementing
+// datagatherer.Summarizer is replaced by its summary, that of the derived
+// data kinds is kept, and that of the other data gatherers is dropped. The
+// findings and policy results of the readings are kept.
+func summarizeReadings(config Config, readings []*api.DataReading, dataGatherers map[string]datagatherer.DataGatherer) {
+	if config.ReportMode != ReportModeFindings {
+		return
+	}
+	for _, reading := range readings {
+		dgConfig, ok := readingDataGatherer(config, reading)
+		if !ok {
+			reading.Data = nil
+			continue
+		}
+		if summarizer, ok := dataGatherers[dgConfig.key()].(datagatherer.Summarizer); ok {
+			summary, err := summarizer.Summarize(reading.Data)
+			if err != nil {
+				log.Printf("failed to summarize the data of %q, dropping it: %s", dgConfig.key(), err)
+			}
+			reading.Data = summary
+			reading.DataVersion = api.SummaryDataVersion
+			continue
+		}
+		if !derivedDataKinds[dgConfig.Kind] {
+			reading.Data = nil
+		}
+	}
+}
+
+// readingDataGatherer returns the config of the data gatherer of a reading.
+func readingDataGatherer(config Config, reading *api.DataReading) (DataGatherer, bool) {
+	for _, dgConfig := range config.DataGatherers {
+		clusterID := config.ClusterID
+		if dgConfig.Cluster != nil {
+			clusterID = dgConfig.Cluster.Name
+		}
+		if dgConfig.Name == reading.DataGatherer && clusterID == reading.ClusterID {
+			return dgConfig, true
+		}
+	}
+	return DataGatherer{}, false
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// summarizingDataGatherer summarizes its data as the number of its items.
+type summarizingDataGatherer struct {
+	dummyDataGatherer
+}
+
+func (g *summarizingDataGatherer) Summarize(data interface{}) (interface{}, error) {
+	return len(data.(map[string]interface{})["items"].([]*api.GatheredResource)), nil
+}
+
+func TestSummarizeReadings(t *testing.T) {
+	config := Config{
+		ClusterID:  "my-cluster",
+		ReportMode: ReportModeFindings,
+		DataGatherers: []DataGatherer{
+			{Name: "k8s/pods", Kind: "k8s-dynamic"},
+			{Name: "k8s/pods", Kind: "k8s-dynamic", Cluster: &ClusterConfig{Name: "other"}},
+			{Name: "rbac", Kind: "k8s-rbac"},
+			{Name: "files", Kind: "local"},
+		},
+	}
+	dataGatherers := map[string]datagatherer.DataGatherer{
+		"k8s/pods":       &summarizingDataGatherer{},
+		"other/k8s/pods": &dummyDataGatherer{},
+		"rbac":           &dummyDataGatherer{},
+		"files":          &dummyDataGatherer{},
+	}
+	finding := api.Finding{RuleID: "no-latest-tag"}
+	pods := testReading("a", "b")
+	pods.ClusterID = "my-cluster"
+	pods.Findings = []api.Finding{finding}
+	otherPods := testReading("a")
+	otherPods.ClusterID = "other"
+	rbac := &api.DataReading{DataGatherer: "rbac", ClusterID: "my-cluster", Data: map[string]interface{}{"subjects": []string{}}}
+	files := &api.DataReading{DataGatherer: "files", ClusterID: "my-cluster", Data: []byte("raw")}
+
+	summarizeReadings(config, []*api.DataReading{pods, otherPods, rbac, files}, dataGatherers)
+
+	if pods.Data != 2 {
+		t.Errorf("expected the data to be summarized, got %v", pods.Data)
+	}
+	if len(pods.Findings) != 1 {
+		t.Errorf("expected the findings to be kept, got %v", pods.Findings)
+	}
+	if otherPods.Data != nil {
+		t.Errorf("expected the data of a data gatherer without summary to be dropped, got %v", otherPods.Data)
+	}
+	if rbac.Data == nil {
+		t.Errorf("expected the derived data to be kept")
+	}
+	if files.Data != nil {
+		t.Errorf("expected the raw data to be dropped, got %v", files.Data)
+	}
+}
+
+func TestSummarizeReadings_Full(t *testing.T) {
+	files := &api.DataReading{DataGatherer: "files", Data: []byte("raw")}
+	summarizeReadings(Config{DataGatherers: []DataGatherer{{Name: "files", Kind: "local"}}}, []*api.DataReading{files}, nil)
+	if files.Data == nil {
+		t.Errorf("expected the data to be kept in the full report mode")
+	}
+}
+
+func TestDerivedDataKinds(t *testing.T) {
+	kinds := map[string]bool{}
+	for _, kind := range dataGathererKinds {
+		kinds[kind] = true
+	}
+	for kind := range derivedDataKinds {
+		if !kinds[kind] {
+			t.Errorf("derived data kind %q is not a data gatherer kind", kind)
+		}
+	}
+}
@@ -1,22 +1,24 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/cenkalti/backoff"
 	"github.com/hashicorp/go-multierror"
 	json "github.com/json-iterator/go"
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,6 +28,8 @@ import (
 	"github.com/jetstack/preflight/api"
 	"github.com/jetstack/preflight/pkg/client"
 	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/secrets"
 	"github.com/jetstack/preflight/pkg/version"
 )
 
@@ -63,8 +67,14 @@ var BackoffMaxTime time.Duration
 var StrictMode bool
 
 // APIToken is an authentication token used for the backend API as an alternative to oauth flows.
+// It can also reference a token in a secret manager, e.g. vault://secret/data/agent#token.
 var APIToken string
 
+// NextAPIToken is the API token that replaces APIToken during a rotation. It
+// is used once the backend rejects APIToken, so that uploads don't fail
+// between the rotation in the backend and the update of the agent.
+var NextAPIToken string
+
 // Profiling flag enabled pprof endpoints to run on the agent
 var Profiling bool
 
@@ -76,14 +86,58 @@ var Prometheus bool
 // In v2 the agent posts data readings using api.gathereredResources
 // Any requests without a schema version set will be interpreted
 // as using v1 by the backend. In v1 the agent sends
-// raw resource data of unstructuredList
-const schemaVersion string = "v2.0.0"
+// raw resource data of unstructuredList. Since v2.1.0 the payloads and the
+// data of the readings are versioned too, see client.MigrateReadings.
+const schemaVersion string = api.SchemaVersion
 
 // Run starts the agent process
 func Run(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	config, preflightClient := getConfiguration()
+
+	// detect whether the previous run crashed before anything else can
+	// fail, so that crash loops are counted
+	var marker *stateMarker
+	var previousCrash *api.CrashReport
+	if StateFilePath != "" {
+		var err error
+		marker, previousCrash, err = newStateMarker(StateFilePath, time.Now)
+		if err != nil {
+			log.Fatalf("failed to record agent state: %s", err)
+		}
+	}
+	stopping := handleTerminationSignals(cancel)
+
+	config, preflightClient, agentMetadata := getConfiguration(previousCrash)
+
+	// remove the files left behind by previous runs, e.g. while crash
+	// looping, before the agent writes new ones
+	if config.Cleanup != nil {
+		cleaner := newCleaner(*config.Cleanup, StateFilePath)
+		cleaner.clean()
+		go cleaner.run(ctx, config.Cleanup.Interval)
+	}
+
+	var dataMirror *mirror
+	if config.Mirror != nil {
+		var err error
+		if dataMirror, err = newMirror(config, agentMetadata); err != nil {
+			log.Fatalf("failed to create mirror client: %s", err)
+		}
+	}
+
+	if config.Timezone != "" {
+		if err := setTimezone(config.Timezone); err != nil {
+			log.Fatalf("failed to set timezone: %s", err)
+		}
+		log.Printf("using timezone %s for timestamps", config.Timezone)
+	}
+
+	// reported once the timezone is set
+	if previousCrash != nil {
+		log.Printf("the previous run of the agent (pid %d) did not terminate cleanly, it was %s in cycle %d at %s",
+			previousCrash.PID, previousCrash.Phase, previousCrash.Cycle, previousCrash.LastUpdate.In(location).Format(api.TimeFormat))
+	}
 
 	if Profiling {
 		log.Printf("pprof profiling was enabled.\nRunning profiling on port :6060")
@@ -98,6 +152,12 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Printf("Prometheus was enabled.\nRunning prometheus server on port :8081")
 		go func() {
 			prometheus.MustRegister(metricPayloadSize)
+			prometheus.MustRegister(metricUncleanTerminations)
+			prometheus.MustRegister(metricCycleCPUSeconds)
+			prometheus.MustRegister(metricResourceLimitExceeded)
+			prometheus.MustRegister(metricMirrorUploadFailures)
+			prometheus.MustRegister(metricAlertsFiring)
+			prometheus.MustRegister(newLabelsMetric(config))
 			metricsServer := http.NewServeMux()
 			metricsServer.Handle("/metrics", promhttp.Handler())
 			err := http.ListenAndServe(":8081", metricsServer)
@@ -107,49 +167,67 @@ func Run(cmd *cobra.Command, args []string) {
 		}()
 	}
 
-	dataGatherers := map[string]datagatherer.DataGatherer{}
-	var wg sync.WaitGroup
+	if previousCrash != nil {
+		metricUncleanTerminations.With(
+			prometheus.Labels{"organization": config.OrganizationID, "cluster": config.ClusterID},
+		).Add(float64(previousCrash.Crashes))
+	}
 
-	// load datagatherer config and boot each one
-	for _, dgConfig := range config.DataGatherers {
-		kind := dgConfig.Kind
-		if dgConfig.DataPath != "" {
-			kind = "local"
-			log.Fatalf("running data gatherer %s of type %s as Local, data-path override present: %s", dgConfig.Name, dgConfig.Kind, dgConfig.DataPath)
+	var shutdownTracing func(context.Context) error
+	if config.Tracing != nil {
+		var err error
+		if shutdownTracing, err = startTracing(ctx, *config.Tracing, config.ClusterID); err != nil {
+			log.Fatalf("failed to start tracing: %s", err)
 		}
+	}
 
-		newDg, err := dgConfig.Config.NewDataGatherer(ctx)
-		if err != nil {
-			log.Fatalf("failed to instantiate %q data gatherer  %q: %v", kind, dgConfig.Name, err)
+	if config.Events != nil {
+		var err error
+		if agentEvents, err = startEventEmitter(ctx, *config.Events); err != nil {
+			log.Printf("not emitting events: %s", err)
 		}
+	}
 
-		log.Printf("starting %q datagatherer", dgConfig.Name)
+	// share a single rate limiter between the Kubernetes clients of all data
+	// gatherers, except those with a rate limit of their own
+	if config.RateLimit != nil {
+		k8s.SetSharedRateLimit(*config.RateLimit)
+	}
 
-		// start the data gatherers and wait for the cache sync
-		if err := newDg.Run(ctx.Done()); err != nil {
-			log.Printf("failed to start %q data gatherer %q: %v", kind, dgConfig.Name, err)
-		}
+	// report all the missing permissions before the first run, rather than
+	// failing on the first data gatherer that is missing some
+	if CheckPermissionsOnStartup {
+		checkPermissionsOnStartup(ctx, config.DataGatherers)
+	}
 
-		// bootCtx is a context with a timeout to allow the informer 5
-		// seconds to perform an initial sync. It may fail, and that's fine
-		// too, it will backoff and retry of its own accord. Initial boot
-		// will only be delayed by a max of 5 seconds.
-		bootCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-		defer cancel()
+	// the usage of the first cycle includes starting up
+	monitor := newUsageMonitor()
 
-		// wait for the informer to complete an initial sync, we do this to
-		// attempt to have an initial set of data for the first upload of
-		// the run.
-		if err := newDg.WaitForCacheSync(bootCtx.Done()); err != nil {
-			// log sync failure, this might recover in future
-			log.Printf("failed to complete initial sync of %q data gatherer %q: %v", kind, dgConfig.Name, err)
+	var configPushes <-chan configPush
+	if config.ConfigPush != nil && !OneShot {
+		var err error
+		if configPushes, err = startConfigPushServer(config, VenafiCloudMode); err != nil {
+			log.Fatalf("failed to start config push server: %s", err)
 		}
+		log.Printf("accepting configurations pushed to %s/config", config.ConfigPush.Listen)
+	}
 
-		// regardless of success, this dataGatherers has been given a
-		// chance to sync its cache and we will now continue as normal. We
-		// assume at the informers will either recover or the log messages
-		// above will help operators correct the issue.
-		dataGatherers[dgConfig.Name] = newDg
+	// the data gatherers are stopped, by cancelling their context, when a
+	// pushed configuration replaces them
+	dgCtx, cancelDataGatherers := context.WithCancel(ctx)
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	var wg sync.WaitGroup
+
+	// load datagatherer config and boot each one, unless onboarding is
+	// configured, in which case they are started progressively in the
+	// datagathering loop
+	var onboarding *onboarding
+	if config.Onboarding != nil {
+		onboarding = newOnboarding(*config.Onboarding, config.DataGatherers)
+	} else {
+		for _, dgConfig := range config.DataGatherers {
+			dataGatherers[dgConfig.key()] = startDataGatherer(dgCtx, dgConfig)
+		}
 	}
 
 	// wait for initial sync period to complete. if unsuccessful, then crash
@@ -167,6 +245,9 @@ func Run(cmd *cobra.Command, args []string) {
 		log.Fatalf("datagatherers inital sync failed due to timeout of 60 seconds")
 	}
 
+	// a period set as a flag takes precedence over pushed configurations
+	periodFromFlag := Period != 0
+
 	// begin the datagathering loop, periodically sending data to the
 	// configured output using data in datagatherer caches or refreshing from
 	// APIs each cycle depending on datagatherer implementation
@@ -177,17 +258,201 @@ func Run(cmd *cobra.Command, args []string) {
 			Period = config.Period
 		}
 
-		gatherAndOutputData(config, preflightClient, dataGatherers)
+		if recordResourceUsage(config, monitor, agentMetadata) {
+			if onboarding != nil {
+				for _, dgConfig := range onboarding.next() {
+					dataGatherers[dgConfig.key()] = startDataGatherer(dgCtx, dgConfig)
+				}
+			}
+
+			updateState(marker, phaseGathering)
+			if config.ClusterMetadata {
+				enrichAgentMetadata(ctx, config, agentMetadata)
+			}
+			agentStatus.startCycle()
+			cycleCtx, span := tracer.Start(ctx, "cycle")
+			gatherAndOutputData(cycleCtx, config, preflightClient, agentMetadata, dataMirror, dataGatherers, onboarding)
+			span.End()
+			// the crash has been reported with the data
+			agentMetadata.PreviousCrash = nil
+			cycle := agentStatus.finishCycle()
+			agentEvents.observeCycle(ctx, cycle)
+			if config.StatusConfigMap != nil {
+				writeStatusConfigMap(ctx, *config.StatusConfigMap, cycle)
+			}
+		}
 
 		if OneShot {
 			break
 		}
 
-		time.Sleep(Period)
+		updateState(marker, phaseWaiting)
+		stop := false
+		select {
+		case <-time.After(Period):
+		case <-stopping:
+			stop = true
+		case push := <-configPushes:
+			// the new data gatherers all start at once, and replace the
+			// current ones only if they all start
+			newDataGatherers, cancel, err := startDataGatherers(ctx, push.config)
+			if err == nil {
+				cancelDataGatherers()
+				stopDataGatherers(dataGatherers)
+				config, dataGatherers, cancelDataGatherers = push.config, newDataGatherers, cancel
+				onboarding = nil
+				if !periodFromFlag && config.Period > 0 {
+					Period = config.Period
+				}
+			}
+			push.result <- err
+		}
+		if stop {
+			break
+		}
+	}
+	cancelDataGatherers()
+	shutdown(marker, shutdownTracing)
+}
+
+// shutdown flushes the traces, if they are exported, and records the clean
+// termination of the agent, if the state is recorded.
+func shutdown(marker *stateMarker, shutdownTracing func(context.Context) error) {
+	if shutdownTracing != nil {
+		// flush the spans of the last cycle
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			log.Printf("failed to flush the traces: %s", err)
+		}
+		cancel()
+	}
+
+	if marker != nil {
+		if err := marker.clean(); err != nil {
+			log.Printf("failed to record clean termination: %s", err)
+		}
 	}
 }
 
-func getConfiguration() (Config, client.Client) {
+// recordResourceUsage samples the resources used by the agent, to be sent
+// with the data and exposed as metrics, and returns whether data should be
+// gathered in this cycle given the resource limits.
+func recordResourceUsage(config Config, monitor *usageMonitor, agentMetadata *api.AgentMetadata) bool {
+	usage := monitor.sample()
+	labels := prometheus.Labels{"organization": config.OrganizationID, "cluster": config.ClusterID}
+	metricCycleCPUSeconds.With(labels).Set(usage.CycleCPUSeconds)
+
+	if config.ResourceLimits != nil {
+		usage.ExceededLimits = config.ResourceLimits.exceeded(usage)
+	}
+	agentMetadata.ResourceUsage = &usage
+	if len(usage.ExceededLimits) == 0 {
+		return true
+	}
+
+	for _, limit := range usage.ExceededLimits {
+		metricResourceLimitExceeded.With(prometheus.Labels{
+			"organization": config.OrganizationID, "cluster": config.ClusterID, "limit": limit,
+		}).Inc()
+	}
+	log.Printf("the agent exceeds its resource limits %s, using %s of memory, %.1fs of CPU in the previous cycle and %d goroutines",
+		strings.Join(usage.ExceededLimits, ", "), formatBytes(int64(usage.MemoryBytes)), usage.CycleCPUSeconds, usage.Goroutines)
+
+	switch config.ResourceLimits.onExceeded() {
+	case onExceededExit:
+		log.Fatalf("exiting as the resource limits are exceeded")
+	case onExceededSkipCycle:
+		debug.FreeOSMemory()
+		log.Printf("skipping data gathering in this cycle as the resource limits are exceeded")
+		return false
+	}
+	return true
+}
+
+// handleTerminationSignals returns a channel that is closed on SIGTERM or
+// SIGINT, once the agent should shut down. The current cycle is interrupted
+// by calling cancel, so that its uploads stop retrying and the agent exits
+// within the grace period of its pod. A second signal terminates the agent
+// right away.
+func handleTerminationSignals(cancel context.CancelFunc) <-chan struct{} {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	stopping := make(chan struct{})
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		log.Printf("received %s, shutting down", sig)
+		close(stopping)
+		cancel()
+	}()
+	return stopping
+}
+
+// updateState records the phase of the agent, if the state is recorded.
+func updateState(marker *stateMarker, phase string) {
+	if marker == nil {
+		return
+	}
+	if err := marker.update(phase); err != nil {
+		log.Printf("failed to record agent state: %s", err)
+	}
+}
+
+// startDataGatherer instantiates and starts a data gatherer, giving it a
+// chance to complete an initial sync.
+func startDataGatherer(ctx context.Context, dgConfig DataGatherer) datagatherer.DataGatherer {
+	dg, err := newDataGatherer(ctx, dgConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dg
+}
+
+// newDataGatherer is like startDataGatherer, but returns an error if the data
+// gatherer can't be instantiated.
+func newDataGatherer(ctx context.Context, dgConfig DataGatherer) (datagatherer.DataGatherer, error) {
+	kind := dgConfig.Kind
+	if dgConfig.DataPath != "" {
+		return nil, fmt.Errorf("running data gatherer %s of type %s as Local, data-path override present: %s", dgConfig.Name, dgConfig.Kind, dgConfig.DataPath)
+	}
+
+	ctx = dataGathererContext(ctx, dgConfig)
+
+	newDg, err := dgConfig.Config.NewDataGatherer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate %q data gatherer  %q: %v", kind, dgConfig.Name, err)
+	}
+
+	log.Printf("starting %q datagatherer", dgConfig.Name)
+
+	// start the data gatherers and wait for the cache sync
+	if err := newDg.Run(ctx.Done()); err != nil {
+		log.Printf("failed to start %q data gatherer %q: %v", kind, dgConfig.Name, err)
+	}
+
+	// bootCtx is a context with a timeout to allow the informer 5
+	// seconds to perform an initial sync. It may fail, and that's fine
+	// too, it will backoff and retry of its own accord. Initial boot
+	// will only be delayed by a max of 5 seconds.
+	bootCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	// wait for the informer to complete an initial sync, we do this to
+	// attempt to have an initial set of data for the first upload of
+	// the run.
+	if err := newDg.WaitForCacheSync(bootCtx.Done()); err != nil {
+		// log sync failure, this might recover in future
+		log.Printf("failed to complete initial sync of %q data gatherer %q: %v", kind, dgConfig.Name, err)
+	}
+
+	// regardless of success, this dataGatherers has been given a
+	// chance to sync its cache and we will now continue as normal. We
+	// assume at the informers will either recover or the log messages
+	// above will help operators correct the issue.
+	return newDg, nil
+}
+
+func getConfiguration(previousCrash *api.CrashReport) (Config, client.Client, *api.AgentMetadata) {
 	log.Printf("Preflight agent version: %s (%s)", version.PreflightVersion, version.Commit)
 	file, err := os.Open(ConfigFilePath)
 	if err != nil {
@@ -231,55 +496,133 @@ func getConfiguration() (Config, client.Client) {
 
 	log.Printf("Loaded config: \n%s", dump)
 
+	agentMetadata := &api.AgentMetadata{
+		Version:       version.PreflightVersion,
+		ClusterID:     config.ClusterID,
+		PreviousCrash: previousCrash,
+	}
+
+	preflightClient, err := createClient(backendCredentials{
+		clientID:         ClientID,
+		privateKeyPath:   PrivateKeyPath,
+		credentialsPath:  CredentialsPath,
+		apiToken:         APIToken,
+		nextAPIToken:     NextAPIToken,
+		venafiCloudMode:  VenafiCloudMode,
+		workloadIdentity: config.WorkloadIdentity,
+	}, config, agentMetadata, baseURL)
+	if err != nil {
+		log.Fatalf("failed to create client: %v", err)
+	}
+
+	return config, preflightClient, agentMetadata
+}
+
+// backendCredentials are the credentials used to upload data to a backend,
+// set by flags for the server and in the configuration for the mirror.
+type backendCredentials struct {
+	clientID        string
+	privateKeyPath  string
+	credentialsPath string
+	apiToken        string
+	nextAPIToken    string
+	venafiCloudMode bool
+	// workloadIdentity is set in the configuration for both backends
+	workloadIdentity *WorkloadIdentityConfig
+}
+
+// createClient creates the client of a backend, limiting its bandwidth and
+// signing its payloads if configured.
+func createClient(creds backendCredentials, config Config, agentMetadata *api.AgentMetadata, baseURL string) (client.Client, error) {
+	if err := client.SetSchemaVersion(config.uploadSchemaVersion()); err != nil {
+		return nil, err
+	}
+	switch config.Backend {
+	case BackendGRPC:
+		return createGRPCClient(creds, config, agentMetadata, baseURL)
+	case BackendNATS:
+		return createNATSClient(creds, config, agentMetadata)
+	case BackendKafka:
+		return createKafkaClient(creds, config)
+	}
+	c, err := createBackendClient(creds, config, agentMetadata, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if config.MaxUploadBandwidth > 0 {
+		if err := client.LimitUploadBandwidth(c, config.MaxUploadBandwidth); err != nil {
+			return nil, err
+		}
+	}
+	if config.PayloadSigning == nil {
+		return c, nil
+	}
+	signer, err := config.PayloadSigning.loadSigner()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load payload signing key: %w", err)
+	}
+	if err := client.SignPayloads(c, signer); err != nil {
+		return nil, err
+	}
+	log.Println("Payload signing was configured, the uploaded payloads are signed.")
+	return c, nil
+}
+
+// createBackendClient creates the client of a backend for the first of the
+// credentials that is set: a Venafi Cloud service account, a credentials
+// file, a workload identity, or an API token.
+func createBackendClient(creds backendCredentials, config Config, agentMetadata *api.AgentMetadata, baseURL string) (client.Client, error) {
 	var credentials client.Credentials
-	if ClientID != "" {
+	var loadCredentials func() (*client.OAuthCredentials, error)
+	if creds.clientID != "" {
 		credentials = &client.VenafiSvcAccountCredentials{
-			ClientID:       ClientID,
-			PrivateKeyFile: PrivateKeyPath,
+			ClientID:       creds.clientID,
+			PrivateKeyFile: creds.privateKeyPath,
 		}
-	} else if CredentialsPath != "" {
-		file, err = os.Open(CredentialsPath)
-		if err != nil {
-			log.Fatalf("Failed to load credentials from file %s", CredentialsPath)
-		}
-		defer file.Close()
-
-		b, err = io.ReadAll(file)
+	} else if creds.credentialsPath != "" {
+		b, err := os.ReadFile(creds.credentialsPath)
 		if err != nil {
-			log.Fatalf("Failed to read credentials file: %v", err)
+			return nil, fmt.Errorf("failed to read credentials file %s: %w", creds.credentialsPath, err)
 		}
-		if VenafiCloudMode {
+		if creds.venafiCloudMode {
 			credentials, err = client.ParseVenafiCredentials(b)
 		} else {
 			credentials, err = client.ParseOAuthCredentials(b)
+			loadCredentials = func() (*client.OAuthCredentials, error) {
+				b, err := os.ReadFile(creds.credentialsPath)
+				if err != nil {
+					return nil, err
+				}
+				return client.ParseOAuthCredentials(b)
+			}
 		}
 		if err != nil {
-			log.Fatalf("Failed to parse credentials file: %s", err)
+			return nil, fmt.Errorf("failed to parse credentials file: %w", err)
 		}
 	}
 
-	agentMetadata := &api.AgentMetadata{
-		Version:   version.PreflightVersion,
-		ClusterID: config.ClusterID,
-	}
-
-	var preflightClient client.Client
 	switch {
 	case credentials != nil:
-		preflightClient, err = createCredentialClient(credentials, config, agentMetadata, baseURL)
-	case APIToken != "":
+		c, err := createCredentialClient(credentials, config, agentMetadata, baseURL)
+		if oauthClient, ok := c.(*client.OAuthClient); ok && loadCredentials != nil {
+			// the credentials file is read again if the backend rejects
+			// the access token, in case the credentials were rotated
+			oauthClient.SetCredentialsLoader(loadCredentials)
+		}
+		return c, err
+	case creds.workloadIdentity != nil:
+		log.Println("A workload identity was configured, using token exchange authentication.")
+		return client.NewTokenExchangeClient(agentMetadata, creds.workloadIdentity.tokenExchangeConfig(), baseURL)
+	case secrets.IsReference(creds.apiToken):
+		log.Println("An API token was specified in a secret manager, using API token authentication.")
+		return createAPITokenClient(creds.apiToken, creds.nextAPIToken, config, agentMetadata, baseURL)
+	case creds.apiToken != "":
 		log.Println("An API token was specified, using API token authentication.")
-		preflightClient, err = client.NewAPITokenClient(agentMetadata, APIToken, baseURL)
+		return createAPITokenClient(creds.apiToken, creds.nextAPIToken, config, agentMetadata, baseURL)
 	default:
 		log.Println("No credentials were specified, using with no authentication.")
-		preflightClient, err = client.NewUnauthenticatedClient(agentMetadata, baseURL)
+		return client.NewUnauthenticatedClient(agentMetadata, baseURL)
 	}
-
-	if err != nil {
-		log.Fatalf("failed to create client: %v", err)
-	}
-
-	return config, preflightClient
 }
 
 func createCredentialClient(credentials client.Credentials, config Config, agentMetadata *api.AgentMetadata, baseURL string) (client.Client, error) {
@@ -304,8 +647,9 @@ func createCredentialClient(credentials client.Credentials, config Config, agent
 	}
 }
 
-func gatherAndOutputData(config Config, preflightClient client.Client, dataGatherers map[string]datagatherer.DataGatherer) {
+func gatherAndOutputData(ctx context.Context, config Config, preflightClient client.Client, agentMetadata *api.AgentMetadata, dataMirror *mirror, dataGatherers map[string]datagatherer.DataGatherer, onboarding *onboarding) {
 	var readings []*api.DataReading
+	startedOn := time.Now()
 
 	// Input/OutputPath flag overwrites agent.yaml configuration
 	if InputPath == "" {
@@ -325,8 +669,47 @@ func gatherAndOutputData(config Config, preflightClient client.Client, dataGathe
 		if err != nil {
 			log.Fatalf("failed to unmarshal local data file: %s", err)
 		}
+		// the readings written by older agents are upgraded
+		if readings, err = client.MigrateReadings(readings, schemaVersion); err != nil {
+			log.Fatalf("failed to migrate local data file: %s", err)
+		}
+		applyPolicies(config, readings)
+		evaluateAlerts(config, readings)
 	} else {
-		readings = gatherData(config, dataGatherers)
+		readings = gatherData(ctx, config, dataGatherers)
+		if onboarding != nil {
+			readings = onboarding.filter(readings)
+			onboarding.record(readings, len(dataGatherers))
+		}
+		applyPolicies(config, readings)
+		evaluateAlerts(config, readings)
+		summarizeReadings(config, readings, dataGatherers)
+		agentStatus.recordReadings(len(readings))
+
+		if config.Attestation != nil {
+			if path := config.Attestation.attestationOutputPath(OutputPath); path != "" {
+				if err := writeAttestations(config, readings, startedOn, path); err != nil {
+					log.Fatalf("failed to attest readings: %s", err)
+				}
+				log.Printf("Attestations saved to local file: %s", path)
+			} else {
+				log.Printf("not writing attestations, neither attestation.output-path nor output-path are set")
+			}
+		}
+	}
+
+	// the readings of an interrupted cycle may be incomplete
+	if ctx.Err() != nil {
+		log.Printf("not outputting the readings, the agent is shutting down")
+		return
+	}
+
+	if config.SQLite != nil {
+		if err := config.SQLite.loadReadings(readings, startedOn); err != nil {
+			log.Printf("failed to load readings into the SQLite database: %s", err)
+		} else {
+			log.Printf("Data loaded into the SQLite database: %s", config.SQLite.Path)
+		}
 	}
 
 	if OutputPath != "" {
@@ -334,36 +717,102 @@ func gatherAndOutputData(config Config, preflightClient client.Client, dataGathe
 		if err != nil {
 			log.Fatal("failed to marshal JSON")
 		}
+		if config.OutputEncryption != nil {
+			if data, err = config.OutputEncryption.encrypt(data); err != nil {
+				log.Fatalf("failed to encrypt output: %s", err)
+			}
+		}
 		err = ioutil.WriteFile(OutputPath, data, 0644)
 		if err != nil {
 			log.Fatalf("failed to output to local file: %s", err)
 		}
 		log.Printf("Data saved to local file: %s", OutputPath)
 	} else {
-		backOff := backoff.NewExponentialBackOff()
-		backOff.InitialInterval = 30 * time.Second
-		backOff.MaxInterval = 3 * time.Minute
-		backOff.MaxElapsedTime = BackoffMaxTime
-		post := func() error {
-			return postData(config, preflightClient, readings)
-		}
-		err := backoff.RetryNotify(post, backOff, func(err error, t time.Duration) {
-			log.Printf("retrying in %v after error: %s", t, err)
-		})
-		if err != nil {
+		// the mirror is uploaded to concurrently and the cycle ends once
+		// both uploads are done
+		mirrorDone := make(chan struct{})
+		if dataMirror != nil {
+			go func() {
+				defer close(mirrorDone)
+				dataMirror.post(ctx, readings)
+			}()
+		} else {
+			close(mirrorDone)
+		}
+
+		upload := func(readings []*api.DataReading) error {
+			return uploadReadings(ctx, config, VenafiCloudMode, preflightClient, agentMetadata, readings, "retrying")
+		}
+		var err error
+		if config.Spool != nil {
+			err = newSpool(*config.Spool).upload(readings, upload)
+		} else {
+			err = upload(readings)
+		}
+		if err != nil && ctx.Err() != nil {
+			log.Printf("the upload was interrupted, the agent is shutting down: %v", err)
+		} else if err != nil {
 			log.Fatalf("Exiting due to fatal error uploading: %v", err)
 		}
+		<-mirrorDone
+
+	}
 
+	if config.Notifications != nil {
+		notifyFindings(ctx, config, readings)
 	}
 }
 
-func gatherData(config Config, dataGatherers map[string]datagatherer.DataGatherer) []*api.DataReading {
+// splitFindings moves the findings reported by a data gatherer under the
+// `findings` key of its data to the Findings section of the reading.
+func splitFindings(data interface{}) (interface{}, []api.Finding) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return data, nil
+	}
+	findings, ok := m["findings"].([]api.Finding)
+	if !ok {
+		return data, nil
+	}
+	rest := make(map[string]interface{}, len(m)-1)
+	for key, value := range m {
+		if key != "findings" {
+			rest[key] = value
+		}
+	}
+	return rest, findings
+}
+
+func gatherData(ctx context.Context, config Config, dataGatherers map[string]datagatherer.DataGatherer) []*api.DataReading {
 	var readings []*api.DataReading
+	ctx, span := tracer.Start(ctx, "gather")
+	defer span.End()
+
+	// the data gatherers of the clusters other than that of the agent are
+	// keyed by cluster as well as name
+	dgConfigs := map[string]DataGatherer{}
+	for _, dgConfig := range config.DataGatherers {
+		dgConfigs[dgConfig.key()] = dgConfig
+	}
+
+	agentStatus.setConfig(config)
+
+	// the APIs installed since the previous cycle, e.g. with CRDs, are
+	// discovered again
+	k8s.InvalidateDiscoveryCaches()
+
+	results := fetchAll(ctx, dataGatherers, dependencyKeys(config.DataGatherers, dataGatherers), config.maxConcurrentGatherers())
+	keys := make([]string, 0, len(results))
+	for k := range results {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
 	var dgError *multierror.Error
-	for k, dg := range dataGatherers {
-		dgData, count, err := dg.Fetch()
+	for _, k := range keys {
+		dgData, count, err := results[k].data, results[k].count, results[k].err
 		if err != nil {
+			agentStatus.recordError(k, err)
 			dgError = multierror.Append(dgError, fmt.Errorf("error in datagatherer %s: %w", k, err))
 
 			continue
@@ -374,12 +823,20 @@ func gatherData(config Config, dataGatherers map[string]datagatherer.DataGathere
 		} else {
 			log.Printf("successfully gathered data from %q datagatherer", k)
 		}
+		name, clusterID := k, config.ClusterID
+		if dgConfig, ok := dgConfigs[k]; ok && dgConfig.Cluster != nil {
+			name, clusterID = dgConfig.Name, dgConfig.Cluster.Name
+		}
+		dgData, findings := splitFindings(dgData)
 		readings = append(readings, &api.DataReading{
-			ClusterID:     config.ClusterID,
-			DataGatherer:  k,
+			ClusterID:     clusterID,
+			DataGatherer:  name,
 			Timestamp:     api.Time{Time: time.Now()},
 			Data:          dgData,
 			SchemaVersion: schemaVersion,
+			DataVersion:   api.DefaultDataVersion,
+			Findings:      findings,
+			Labels:        config.Labels,
 		})
 	}
 
@@ -402,12 +859,12 @@ func gatherData(config Config, dataGatherers map[string]datagatherer.DataGathere
 	return readings
 }
 
-func postData(config Config, preflightClient client.Client, readings []*api.DataReading) error {
+func postData(ctx context.Context, config Config, venafiCloudMode bool, preflightClient client.Client, readings []*api.DataReading) error {
 	baseURL := config.Server
 
 	log.Println("Posting data to:", baseURL)
 
-	if VenafiCloudMode {
+	if venafiCloudMode {
 		// orgID and clusterID are not required for Venafi Cloud auth
 		err := preflightClient.PostDataReadingsWithOptions(readings, client.Options{
 			ClusterName:        config.ClusterID,
@@ -422,26 +879,33 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 	}
 
 	if config.OrganizationID == "" {
-		data, err := json.Marshal(readings)
+		readings, err := client.MigrateReadings(readings, client.SchemaVersion())
 		if err != nil {
-			log.Fatalf("Cannot marshal readings: %+v", err)
+			return err
 		}
+		// the readings are encoded as they are sent, the upload size is
+		// known once they are
+		body := newCountingReader(client.NewDataReadingsReader(readings))
+		defer body.Close()
 
-		// log and collect metrics about the upload size
-		metric := metricPayloadSize.With(
-			prometheus.Labels{"organization": config.OrganizationID, "cluster": config.ClusterID},
-		)
-		metric.Set(float64(len(data)))
-		log.Printf("Data readings upload size: %d", len(data))
 		path := config.Endpoint.Path
 		if path == "" {
 			path = "/api/v1/datareadings"
 		}
-		res, err := preflightClient.Post(path, bytes.NewBuffer(data))
+		res, err := preflightClient.Post(path, body)
 
 		if err != nil {
 			return fmt.Errorf("failed to post data: %+v", err)
 		}
+
+		// log and collect metrics about the upload size
+		metric := metricPayloadSize.With(
+			prometheus.Labels{"organization": config.OrganizationID, "cluster": config.ClusterID},
+		)
+		size := body.count()
+		metric.Set(float64(size))
+		agentStatus.recordPayloadSize(size)
+		log.Printf("Data readings upload size: %d", size)
 		if code := res.StatusCode; code < 200 || code >= 300 {
 			errorContent := ""
 			body, _ := ioutil.ReadAll(res.Body)
@@ -460,7 +924,12 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 		return fmt.Errorf("post to server failed: missing clusterID from agent configuration")
 	}
 
-	err := preflightClient.PostDataReadings(config.OrganizationID, config.ClusterID, readings)
+	var err error
+	if c, ok := preflightClient.(client.ContextClient); ok {
+		err = c.PostDataReadingsContext(ctx, config.OrganizationID, config.ClusterID, readings)
+	} else {
+		err = preflightClient.PostDataReadings(config.OrganizationID, config.ClusterID, readings)
+	}
 	if err != nil {
 		return fmt.Errorf("post to server failed: %+v", err)
 	}
@@ -468,3 +937,30 @@ func postData(config Config, preflightClient client.Client, readings []*api.Data
 
 	return nil
 }
+
+// countingReader counts the bytes read. When it is reopened to retry a
+// request, the bytes are counted from the start again.
+type countingReader struct {
+	client.ReopenableReader
+	n *int64
+}
+
+func newCountingReader(r client.ReopenableReader) *countingReader {
+	return &countingReader{ReopenableReader: r, n: new(int64)}
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReopenableReader.Read(p)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func (r *countingReader) Reopen() client.ReopenableReader {
+	atomic.StoreInt64(r.n, 0)
+	return &countingReader{ReopenableReader: r.ReopenableReader.Reopen(), n: r.n}
+}
+
+// count returns the number of bytes read.
+func (r *countingReader) count() int64 {
+	return atomic.LoadInt64(r.n)
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestSplitFindings(t *testing.T) {
+	findings := []api.Finding{{
+		RuleID:   "weak-key",
+		Severity: api.SeverityHigh,
+		Resource: api.ResourceRef{Kind: "Secret", Namespace: "default", Name: "tls"},
+		Message:  "RSA key size 1024 is below the minimum of 2048",
+	}}
+
+	data, got := splitFindings(map[string]interface{}{"keys": []string{"a"}, "findings": findings})
+	if diff, equal := messagediff.PrettyDiff(map[string]interface{}{"keys": []string{"a"}}, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(findings, got); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+
+	// data of other data gatherers is left as is
+	items := map[string]interface{}{"items": []interface{}{}}
+	data, got = splitFindings(items)
+	if diff, equal := messagediff.PrettyDiff(items, data); !equal || got != nil {
+		t.Errorf("unexpected data or findings:\n%s\n%v", diff, got)
+	}
+}
+
+func TestStreamedUpload(t *testing.T) {
+	var bodies [][]byte
+	var encodings [][]string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, body)
+		encodings = append(encodings, r.TransferEncoding)
+		if r.Header.Get("Authorization") != "Bearer two" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer ts.Close()
+
+	// the token is rotated, so the upload is retried after the first
+	// request is rejected
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("two"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := createAPITokenClient("one", "file://"+path, Config{}, &api.AgentMetadata{Version: "test"}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	readings := []*api.DataReading{
+		{DataGatherer: "a", Data: map[string]interface{}{"items": []interface{}{"x"}}},
+		{DataGatherer: "b", Data: "<html>"},
+	}
+	if err := c.PostDataReadings("org", "cluster", readings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+	expected, err := json.Marshal(readings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, body := range bodies {
+		// the payload is encoded as it is sent, so its length is unknown
+		if diff, equal := messagediff.PrettyDiff([]string{"chunked"}, encodings[i]); !equal {
+			t.Errorf("request %d was not streamed:\n%s", i, diff)
+		}
+		var payload struct {
+			AgentMetadata *api.AgentMetadata `json:"agent_metadata"`
+			DataReadings  json.RawMessage    `json:"data_readings"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("failed to decode request %d: %s\n%s", i, err, body)
+		}
+		if payload.AgentMetadata == nil || payload.AgentMetadata.Version != "test" {
+			t.Errorf("unexpected agent metadata in request %d: %+v", i, payload.AgentMetadata)
+		}
+		if string(payload.DataReadings) != string(expected) {
+			t.Errorf("unexpected readings in request %d:\n%s\nwant:\n%s", i, payload.DataReadings, expected)
+		}
+	}
+}
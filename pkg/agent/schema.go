@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// jsonSchema is a JSON Schema.
+type jsonSchema map[string]interface{}
+
+// dataGathererKinds are the kinds of data gatherers that can be configured.
+var dataGathererKinds = []string{
+	"k8s",
+	"k8s-dynamic",
+	"k8s-discovery",
+	"k8s-cert-manager",
+	"k8s-rbac",
+	"k8s-webhooks",
+	"k8s-key-hygiene",
+	"k8s-ingress-tls-policy",
+	"k8s-cert-manager-logs",
+	"k8s-encryption-at-rest",
+	"k8s-helm-releases",
+	"k8s-crds",
+	"k8s-api-deprecations",
+	"k8s-istio",
+	"k8s-ingress-tls",
+	"k8s-tls-probe",
+	"k8s-issuer-health",
+	"k8s-issuance",
+	"k8s-cert-manager-events",
+	"k8s-pod-security",
+	"k8s-resource-counts",
+	"k8s-control-plane-certificates",
+	"local",
+	"exec",
+	"plugin",
+	"http",
+	"prometheus",
+	"cloud-certificates",
+	"venafi-policy",
+	"agent",
+}
+
+// PrintConfigSchema prints the JSON Schema of the agent config.
+func PrintConfigSchema(cmd *cobra.Command, args []string) {
+	data, err := json.MarshalIndent(configSchema(), "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to marshal the config schema: %s", err)
+	}
+	fmt.Fprintln(os.Stdout, string(data))
+}
+
+// configSchema returns the JSON Schema of the agent config, including the
+// config of every kind of data gatherer, for editors and pipelines to check
+// the config before it is deployed.
+func configSchema() jsonSchema {
+	s := typeSchema(reflect.TypeOf(Config{}))
+	s["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	s["title"] = "Jetstack Secure agent configuration"
+	return s
+}
+
+// typeSchema returns the schema of the YAML encoding of a type. Struct fields
+// without a yaml tag are not decoded from the config and are left out.
+func typeSchema(t reflect.Type) jsonSchema {
+	// types that are not decoded from their fields, or not only
+	switch t {
+	case reflect.TypeOf(time.Duration(0)):
+		return jsonSchema{
+			"type":        []string{"string", "integer"},
+			"description": "A duration such as 1h30m, or a number of nanoseconds.",
+		}
+	case reflect.TypeOf(DataGatherer{}):
+		return dataGathererSchema()
+	case reflect.TypeOf(k8s.ConfigDynamic{}):
+		return dynamicConfigSchema()
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return jsonSchema{"type": "string"}
+	case reflect.Bool:
+		return jsonSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return jsonSchema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonSchema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return jsonSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonSchema{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return jsonSchema{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return jsonSchema{}
+}
+
+func structSchema(t reflect.Type) jsonSchema {
+	properties := jsonSchema{}
+	addStructProperties(t, properties)
+	return jsonSchema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func addStructProperties(t reflect.Type, properties jsonSchema) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("yaml")
+		if !field.IsExported() || tag == "" || tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if strings.Contains(options, "inline") {
+			addStructProperties(field.Type, properties)
+			continue
+		}
+		properties[name] = typeSchema(field.Type)
+	}
+}
+
+// dataGathererSchema is the schema of a data gatherer, whose config depends
+// on its kind.
+func dataGathererSchema() jsonSchema {
+	var kinds []jsonSchema
+	for _, kind := range dataGathererKinds {
+		kinds = append(kinds, jsonSchema{
+			"if": jsonSchema{
+				"properties": jsonSchema{"kind": jsonSchema{"const": kind}},
+			},
+			"then": jsonSchema{
+				"properties": jsonSchema{"config": typeSchema(reflect.TypeOf(newDataGathererConfig(kind)))},
+			},
+		})
+	}
+
+	return jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"kind":       jsonSchema{"type": "string", "enum": dataGathererKinds},
+			"name":       jsonSchema{"type": "string"},
+			"data-path":  jsonSchema{"type": "string"},
+			"rate-limit": typeSchema(reflect.TypeOf(k8s.RateLimit{})),
+			"clusters":   jsonSchema{"type": "array", "items": jsonSchema{"type": "string"}},
+			"depends-on": jsonSchema{"type": "array", "items": jsonSchema{"type": "string"}},
+			"config":     jsonSchema{"type": "object"},
+		},
+		"required":             []string{"kind", "name"},
+		"additionalProperties": false,
+		"allOf":                kinds,
+	}
+}
+
+// dynamicConfigSchema adds the resource type of the k8s-dynamic data
+// gatherer, which can be a single resource type or a list of them.
+func dynamicConfigSchema() jsonSchema {
+	s := structSchema(reflect.TypeOf(k8s.ConfigDynamic{}))
+	resourceType := jsonSchema{
+		"type": "object",
+		"properties": jsonSchema{
+			"group":    jsonSchema{"type": "string"},
+			"version":  jsonSchema{"type": "string"},
+			"resource": jsonSchema{"type": "string"},
+		},
+		"required":             []string{"resource"},
+		"additionalProperties": false,
+	}
+	s["properties"].(jsonSchema)["resource-type"] = jsonSchema{
+		"oneOf": []jsonSchema{
+			resourceType,
+			{"type": "array", "items": resourceType, "minItems": 1},
+		},
+	}
+	s["required"] = []string{"resource-type"}
+	return s
+}
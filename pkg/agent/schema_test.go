@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestConfigSchema(t *testing.T) {
+	s := configSchema()
+	properties := s["properties"].(jsonSchema)
+
+	for _, name := range []string{"organization_id", "period", "data-gatherers", "rate-limit", "venafi-cloud"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("expected property %q in the schema", name)
+		}
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"string", "integer"}, properties["period"].(jsonSchema)["type"]); !equal {
+		t.Errorf("unexpected period type:\n%s", diff)
+	}
+
+	dataGatherer := properties["data-gatherers"].(jsonSchema)["items"].(jsonSchema)
+	kinds := dataGatherer["allOf"].([]jsonSchema)
+	if len(kinds) != len(dataGathererKinds) {
+		t.Fatalf("expected a config schema for each of the %d kinds, got %d", len(dataGathererKinds), len(kinds))
+	}
+	for i, kind := range dataGathererKinds {
+		if newDataGathererConfig(kind) == nil {
+			t.Errorf("kind %q is not supported", kind)
+		}
+		condition := kinds[i]["if"].(jsonSchema)["properties"].(jsonSchema)["kind"].(jsonSchema)["const"]
+		if condition != kind {
+			t.Errorf("expected the config schema of kind %q, got %q", kind, condition)
+		}
+	}
+
+	// the resource type of k8s-dynamic is decoded by its UnmarshalYAML
+	dynamic := kinds[1]["then"].(jsonSchema)["properties"].(jsonSchema)["config"].(jsonSchema)
+	dynamicProperties := dynamic["properties"].(jsonSchema)
+	if _, ok := dynamicProperties["resource-type"]; !ok {
+		t.Errorf("expected the resource-type property in the k8s-dynamic config schema")
+	}
+	// fields without a yaml tag are not part of the config
+	for name := range dynamicProperties {
+		if name == "GroupVersionResource" || name == "AdditionalGroupVersionResources" {
+			t.Errorf("unexpected property %q in the k8s-dynamic config schema", name)
+		}
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"resource-type"}, dynamic["required"]); !equal {
+		t.Errorf("unexpected required properties:\n%s", diff)
+	}
+}
@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestMigrateReadings(t *testing.T) {
+	readings := []*api.DataReading{
+		{DataGatherer: "old", SchemaVersion: "v2.0.0"},
+		{DataGatherer: "new", SchemaVersion: "v2.1.0", DataVersion: api.SummaryDataVersion},
+		{DataGatherer: "unversioned"},
+	}
+
+	upgraded, err := client.MigrateReadings(readings, "v2.1.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []*api.DataReading{
+		{DataGatherer: "old", SchemaVersion: "v2.1.0", DataVersion: api.DefaultDataVersion},
+		{DataGatherer: "new", SchemaVersion: "v2.1.0", DataVersion: api.SummaryDataVersion},
+		{DataGatherer: "unversioned"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, upgraded); !equal {
+		t.Errorf("unexpected upgraded readings:\n%s", diff)
+	}
+	// the migrated readings are copies
+	if readings[0].SchemaVersion != "v2.0.0" || readings[0].DataVersion != "" {
+		t.Errorf("the reading was changed: %+v", readings[0])
+	}
+
+	downgraded, err := client.MigrateReadings(upgraded, "v2.0.0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = []*api.DataReading{
+		{DataGatherer: "old", SchemaVersion: "v2.0.0"},
+		{DataGatherer: "new", SchemaVersion: "v2.0.0"},
+		{DataGatherer: "unversioned"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, downgraded); !equal {
+		t.Errorf("unexpected downgraded readings:\n%s", diff)
+	}
+
+	if _, err := client.MigrateReadings(readings, "v3.0.0"); err == nil || err.Error() != `unknown schema version "v3.0.0"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+	_, err = client.MigrateReadings([]*api.DataReading{{DataGatherer: "future", SchemaVersion: "v3.0.0"}}, "v2.1.0")
+	if err == nil || err.Error() != `the reading of future has the unknown schema version "v3.0.0"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateSchemaVersion(t *testing.T) {
+	for version, expected := range map[string]string{
+		"":       "",
+		"v2.0.0": "",
+		"v2.1.0": "",
+		"v1":     `schema-version must be one of v2.0.0, v2.1.0, got "v1"`,
+	} {
+		config := Config{SchemaVersion: version}
+		err := config.validateSchemaVersion()
+		if expected == "" && err != nil {
+			t.Errorf("%q: unexpected error: %s", version, err)
+		}
+		if expected != "" && (err == nil || err.Error() != expected) {
+			t.Errorf("%q: expected error %q, got %v", version, expected, err)
+		}
+	}
+}
+
+func TestUploadSchemaVersion(t *testing.T) {
+	defer client.SetSchemaVersion(api.SchemaVersion)
+
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payload = nil
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("unexpected body %s: %s", body, err)
+		}
+	}))
+	defer server.Close()
+
+	readings := []*api.DataReading{
+		{DataGatherer: "k8s/pods", Data: map[string]int{"pods": 3}, SchemaVersion: schemaVersion, DataVersion: api.DefaultDataVersion},
+	}
+	for _, tc := range []struct {
+		config        Config
+		schemaVersion interface{}
+		reading       map[string]interface{}
+	}{
+		{
+			config:        Config{},
+			schemaVersion: "v2.1.0",
+			reading:       map[string]interface{}{"schema_version": "v2.1.0", "data_version": "v1"},
+		},
+		{
+			config:        Config{SchemaVersion: "v2.0.0"},
+			schemaVersion: nil,
+			reading:       map[string]interface{}{"schema_version": "v2.0.0"},
+		},
+	} {
+		c, err := createClient(backendCredentials{apiToken: "token"}, tc.config, &api.AgentMetadata{}, server.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := c.PostDataReadings("example", "example-cluster", readings); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if payload["schema_version"] != tc.schemaVersion {
+			t.Errorf("expected the schema version %v, got %v", tc.schemaVersion, payload["schema_version"])
+		}
+		reading := payload["data_readings"].([]interface{})[0].(map[string]interface{})
+		versions := map[string]interface{}{}
+		for _, key := range []string{"schema_version", "data_version"} {
+			if value, ok := reading[key]; ok {
+				versions[key] = value
+			}
+		}
+		if diff, equal := messagediff.PrettyDiff(tc.reading, versions); !equal {
+			t.Errorf("unexpected versions of the reading:\n%s", diff)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/version"
+)
+
+const (
+	// selfReportErrorWindow is how long errors are counted in the self
+	// report.
+	selfReportErrorWindow = time.Hour
+	// uploadErrorSource is the source of the errors of the uploads in the
+	// self report, the other sources being the data gatherers.
+	uploadErrorSource = "upload"
+	redactedValue     = "REDACTED"
+)
+
+// redactedConfigKeys are the keys of the config whose values are credentials.
+var redactedConfigKeys = map[string]bool{
+	"api-token":      true,
+	"next-api-token": true,
+}
+
+// selfReportConfig configures the agent data gatherer, which reports on the
+// agent itself, so that outdated or misconfigured agents can be detected.
+type selfReportConfig struct{}
+
+func (c *selfReportConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	return &selfReportDataGatherer{status: agentStatus}, nil
+}
+
+type selfReportDataGatherer struct {
+	status *status
+}
+
+func (g *selfReportDataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *selfReportDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *selfReportDataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *selfReportDataGatherer) Fetch() (interface{}, int, error) {
+	report, err := g.status.report()
+	if err != nil {
+		return nil, -1, err
+	}
+	return report, -1, nil
+}
+
+// selfReport is the data of the agent data gatherer.
+type selfReport struct {
+	Version   string   `json:"version"`
+	Commit    string   `json:"commit"`
+	BuildDate string   `json:"build_date"`
+	GoVersion string   `json:"go_version"`
+	Platform  string   `json:"platform"`
+	StartedAt api.Time `json:"started_at"`
+	// Config is the effective configuration of the agent, with the
+	// credentials redacted.
+	Config        map[string]interface{}     `json:"config"`
+	DataGatherers []selfReportDataGathererID `json:"data_gatherers"`
+	// Errors are the number of errors of each data gatherer, and of the
+	// uploads, in the last hour. Sources without errors are omitted.
+	Errors map[string]int `json:"errors"`
+}
+
+type selfReportDataGathererID struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Cluster string `json:"cluster,omitempty"`
+}
+
+// status is what the agent reports about itself: its configuration and
+// recent errors.
+type status struct {
+	mu        sync.Mutex
+	startedAt time.Time
+	config    Config
+	// errors are the times and sources of the recent errors, oldest first.
+	errors []statusError
+	// cycle is the status of the current, or last, datagathering cycle.
+	cycle cycleStatus
+	now   func() time.Time
+}
+
+// cycleStatus is the outcome of a datagathering cycle.
+type cycleStatus struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Readings is the number of readings gathered.
+	Readings int
+	// PayloadSize is the size in bytes of the uploaded readings, or 0 if it
+	// isn't known.
+	PayloadSize int64
+	// Errors are the last errors of each data gatherer, and of the uploads,
+	// during the cycle.
+	Errors map[string]string
+}
+
+type statusError struct {
+	source string
+	at     time.Time
+}
+
+// agentStatus is the status of the running agent.
+var agentStatus = newStatus()
+
+func newStatus() *status {
+	return &status{startedAt: time.Now(), now: time.Now}
+}
+
+// setConfig records the configuration the agent is running.
+func (s *status) setConfig(config Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// recordError records an error of a data gatherer, or of the uploads.
+func (s *status) recordError(source string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors = append(s.errors, statusError{source: source, at: s.now()})
+	s.prune()
+	if s.cycle.Errors != nil {
+		s.cycle.Errors[source] = err.Error()
+	}
+}
+
+// startCycle records the start of a datagathering cycle.
+func (s *status) startCycle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycle = cycleStatus{StartedAt: s.now(), Errors: map[string]string{}}
+}
+
+// recordReadings records the number of readings gathered in the cycle.
+func (s *status) recordReadings(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycle.Readings = n
+}
+
+// recordPayloadSize records the size of the readings uploaded in the cycle.
+func (s *status) recordPayloadSize(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycle.PayloadSize = n
+}
+
+// finishCycle records the end of the datagathering cycle and returns its
+// status.
+func (s *status) finishCycle() cycleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cycle.FinishedAt = s.now()
+	cycle := s.cycle
+	cycle.Errors = make(map[string]string, len(s.cycle.Errors))
+	for source, message := range s.cycle.Errors {
+		cycle.Errors[source] = message
+	}
+	return cycle
+}
+
+// prune forgets the errors older than the error window.
+func (s *status) prune() {
+	cutoff := s.now().Add(-selfReportErrorWindow)
+	i := 0
+	for i < len(s.errors) && s.errors[i].at.Before(cutoff) {
+		i++
+	}
+	s.errors = s.errors[i:]
+}
+
+func (s *status) report() (*selfReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := redactConfig(s.config)
+	if err != nil {
+		return nil, err
+	}
+	dataGatherers := make([]selfReportDataGathererID, 0, len(s.config.DataGatherers))
+	for _, dg := range s.config.DataGatherers {
+		id := selfReportDataGathererID{Name: dg.Name, Kind: dg.Kind}
+		if dg.Cluster != nil {
+			id.Cluster = dg.Cluster.Name
+		}
+		dataGatherers = append(dataGatherers, id)
+	}
+	s.prune()
+	errors := map[string]int{}
+	for _, e := range s.errors {
+		errors[e.source]++
+	}
+
+	return &selfReport{
+		Version:       version.PreflightVersion,
+		Commit:        version.Commit,
+		BuildDate:     version.BuildDate,
+		GoVersion:     version.GoVersion,
+		Platform:      version.Platform,
+		StartedAt:     api.Time{Time: s.startedAt},
+		Config:        config,
+		DataGatherers: dataGatherers,
+		Errors:        errors,
+	}, nil
+}
+
+// redactConfig returns the config as it is written in YAML, with the values
+// of the credentials replaced.
+func redactConfig(config Config) (map[string]interface{}, error) {
+	data, err := yaml.Marshal(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the config: %w", err)
+	}
+	var result map[string]interface{}
+	if err := yaml.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode the config: %w", err)
+	}
+	redact(result)
+	return result, nil
+}
+
+// redact replaces the values of the credentials in a decoded YAML document:
+// those of redactedConfigKeys, and those whose key contains password.
+func redact(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if _, scalar := child.(string); scalar && (redactedConfigKeys[key] || strings.Contains(strings.ToLower(key), "password")) {
+				if child != "" {
+					v[key] = redactedValue
+				}
+				continue
+			}
+			redact(child)
+		}
+	case []interface{}:
+		for _, child := range v {
+			redact(child)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestSelfReport(t *testing.T) {
+	config, err := ParseConfig([]byte(`
+      server: "http://localhost:8080"
+      organization_id: "example"
+      cluster_id: "example-cluster"
+      mirror:
+        server: "https://mirror.example.com"
+        api-token: "s3cr3t"
+      data-gatherers:
+      - name: d1
+        kind: dummy
+      - name: agent
+        kind: agent
+`), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	s := newStatus()
+	s.now = func() time.Time { return now }
+	s.setConfig(config)
+	s.recordError("d1", fmt.Errorf("failed"))
+	now = now.Add(45 * time.Minute)
+	s.recordError("d1", fmt.Errorf("failed"))
+	s.recordError(uploadErrorSource, fmt.Errorf("failed"))
+	now = now.Add(30 * time.Minute)
+
+	dg := &selfReportDataGatherer{status: s}
+	data, _, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	report := data.(*selfReport)
+
+	// only the errors of the last hour are counted
+	if diff, equal := messagediff.PrettyDiff(map[string]int{"d1": 1, uploadErrorSource: 1}, report.Errors); !equal {
+		t.Errorf("unexpected errors:\n%s", diff)
+	}
+	expected := []selfReportDataGathererID{{Name: "d1", Kind: "dummy"}, {Name: "agent", Kind: "agent"}}
+	if diff, equal := messagediff.PrettyDiff(expected, report.DataGatherers); !equal {
+		t.Errorf("unexpected data gatherers:\n%s", diff)
+	}
+
+	// the credentials are redacted from the config
+	mirror := report.Config["mirror"].(map[string]interface{})
+	if mirror["api-token"] != redactedValue {
+		t.Errorf("expected the api token to be redacted, got %v", mirror["api-token"])
+	}
+	if mirror["server"] != "https://mirror.example.com" {
+		t.Errorf("unexpected mirror server: %v", mirror["server"])
+	}
+	if report.Config["cluster_id"] != "example-cluster" {
+		t.Errorf("unexpected cluster_id: %v", report.Config["cluster_id"])
+	}
+}
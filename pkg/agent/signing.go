@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// PayloadSigningConfig enables the signing of the uploaded payloads, with
+// the signature sent in the X-Signature header, so that the backend can
+// verify their integrity and origin.
+type PayloadSigningConfig struct {
+	// PrivateKeyPath is the path to a PEM encoded PKCS #8 ECDSA or Ed25519
+	// private key.
+	PrivateKeyPath string `yaml:"private-key-path,omitempty"`
+	// HMACKeyPath is the path to a file holding a key shared with the
+	// backend, to sign the payloads with HMAC-SHA256 instead.
+	HMACKeyPath string `yaml:"hmac-key-path,omitempty"`
+}
+
+func (c *PayloadSigningConfig) validate() error {
+	if (c.PrivateKeyPath == "") == (c.HMACKeyPath == "") {
+		return fmt.Errorf("payload-signing: exactly one of private-key-path and hmac-key-path is required")
+	}
+	return nil
+}
+
+// loadSigner loads the signing key.
+func (c *PayloadSigningConfig) loadSigner() (*client.PayloadSigner, error) {
+	if c.PrivateKeyPath != "" {
+		return client.LoadPayloadSigner(c.PrivateKeyPath)
+	}
+	key, err := os.ReadFile(c.HMACKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HMAC key: %w", err)
+	}
+	return client.NewHMACPayloadSigner([]byte(strings.TrimRight(string(key), "\r\n")))
+}
@@ -0,0 +1,102 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestPayloadSigning(t *testing.T) {
+	dir := t.TempDir()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	privateKeyPath := filepath.Join(dir, "key.pem")
+	hmacKeyPath := filepath.Join(dir, "hmac-key")
+	if err := os.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := os.WriteFile(hmacKeyPath, []byte("shared-secret\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := map[string]struct {
+		config    PayloadSigningConfig
+		algorithm string
+		verify    func(message, sig []byte) bool
+	}{
+		"ed25519": {
+			config:    PayloadSigningConfig{PrivateKeyPath: privateKeyPath},
+			algorithm: "ed25519",
+			verify: func(message, sig []byte) bool {
+				return ed25519.Verify(public, message, sig)
+			},
+		},
+		"hmac": {
+			config:    PayloadSigningConfig{HMACKeyPath: hmacKeyPath},
+			algorithm: "hmac-sha256",
+			verify: func(message, sig []byte) bool {
+				mac := hmac.New(sha256.New, []byte("shared-secret"))
+				mac.Write(message)
+				return hmac.Equal(mac.Sum(nil), sig)
+			},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			verified := false
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if algorithm := r.Header.Get(client.SignatureAlgorithmHeader); algorithm != tc.algorithm {
+					t.Errorf("unexpected algorithm %q", algorithm)
+				}
+				sig, err := base64.StdEncoding.DecodeString(r.Header.Get(client.SignatureHeader))
+				if err != nil {
+					t.Errorf("invalid signature header: %s", err)
+				}
+				message := append([]byte(r.Header.Get(client.SignatureTimestampHeader)+"\n"), body...)
+				verified = tc.verify(message, sig)
+			}))
+			defer ts.Close()
+
+			config := Config{PayloadSigning: &tc.config}
+			c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, ts.URL)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := c.PostDataReadings("org", "cluster", nil); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !verified {
+				t.Errorf("the payload signature could not be verified")
+			}
+		})
+	}
+}
+
+func TestPayloadSigningConfigValidate(t *testing.T) {
+	if err := (&PayloadSigningConfig{}).validate(); err == nil {
+		t.Errorf("expected an error without a key")
+	}
+	if err := (&PayloadSigningConfig{PrivateKeyPath: "a", HMACKeyPath: "b"}).validate(); err == nil {
+		t.Errorf("expected an error with both keys")
+	}
+}
@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	json "github.com/json-iterator/go"
+	"github.com/spf13/cobra"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+var (
+	// SimulateFixturesPath is the directory of Kubernetes manifests that
+	// `agent simulate` gathers data from.
+	SimulateFixturesPath string
+	// SimulateConfigPath is the config file run by `agent simulate`. It
+	// defaults to the agent config file.
+	SimulateConfigPath string
+	// SimulateOutputPath, if set, is the file `agent simulate` writes the
+	// readings to.
+	SimulateOutputPath string
+)
+
+// simulation is what a cycle of the agent would produce.
+type simulation struct {
+	Readings []*api.DataReading
+	// Outputs describe where the readings would be sent or written.
+	Outputs []string
+}
+
+// Simulate runs the data gatherers of the agent config against the objects
+// of the fixtures rather than a cluster, and prints the readings, findings
+// and outputs a cycle of the agent would produce, without uploading or
+// writing anything.
+func Simulate(cmd *cobra.Command, args []string) {
+	path := SimulateConfigPath
+	if path == "" {
+		path = ConfigFilePath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read config file: %s", err)
+	}
+	config, err := ParseConfig(data, VenafiCloudMode || ClientID != "")
+	if err != nil {
+		log.Fatalf("Failed to parse config file: %s", err)
+	}
+
+	fixtures, err := k8s.LoadFixtures(SimulateFixturesPath)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures: %s", err)
+	}
+	log.Printf("Loaded %d objects from %s", fixtures.Len(), SimulateFixturesPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result, err := simulate(ctx, config, VenafiCloudMode || ClientID != "", fixtures)
+	if err != nil {
+		log.Fatalf("Simulation failed: %s", err)
+	}
+	printSimulation(os.Stdout, result)
+
+	if SimulateOutputPath != "" {
+		data, err := json.MarshalIndent(result.Readings, "", "  ")
+		if err != nil {
+			log.Fatalf("failed to marshal JSON: %s", err)
+		}
+		if err := os.WriteFile(SimulateOutputPath, data, 0644); err != nil {
+			log.Fatalf("failed to write readings: %s", err)
+		}
+		log.Printf("Readings saved to local file: %s", SimulateOutputPath)
+	}
+}
+
+// simulate gathers the readings of the data gatherers of config from the
+// fixtures, as the agent would in a cycle, and describes their outputs.
+func simulate(ctx context.Context, config Config, venafiCloudMode bool, fixtures *k8s.Fixtures) (*simulation, error) {
+	dataGatherers := map[string]datagatherer.DataGatherer{}
+	for _, dgConfig := range config.DataGatherers {
+		dg, err := fixtures.NewDataGatherer(ctx, dgConfig.Config)
+		if errors.Is(err, k8s.ErrFixturesUnsupported) {
+			dg, err = dgConfig.Config.NewDataGatherer(ctx)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to instantiate %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+		}
+		if err := dg.Run(ctx.Done()); err != nil {
+			return nil, fmt.Errorf("failed to start %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+		}
+		if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+			return nil, fmt.Errorf("failed to sync %q data gatherer %q: %w", dgConfig.Kind, dgConfig.Name, err)
+		}
+		dataGatherers[dgConfig.key()] = dg
+	}
+
+	readings := gatherData(ctx, config, dataGatherers)
+	sort.Slice(readings, func(i, j int) bool { return readings[i].DataGatherer < readings[j].DataGatherer })
+
+	outputs, err := simulateOutputs(config, venafiCloudMode, readings)
+	if err != nil {
+		return nil, err
+	}
+	return &simulation{Readings: readings, Outputs: outputs}, nil
+}
+
+// simulateOutputs describes where gatherAndOutputData would send or write
+// the readings.
+func simulateOutputs(config Config, venafiCloudMode bool, readings []*api.DataReading) ([]string, error) {
+	var outputs []string
+	if config.Attestation != nil {
+		if path := config.Attestation.attestationOutputPath(config.OutputPath); path != "" {
+			outputs = append(outputs, fmt.Sprintf("attestations written to %s", path))
+		}
+	}
+	if config.SQLite != nil {
+		outputs = append(outputs, fmt.Sprintf("readings loaded into the SQLite database %s", config.SQLite.Path))
+	}
+	if config.OutputPath != "" {
+		output := fmt.Sprintf("readings written to %s", config.OutputPath)
+		if config.OutputEncryption != nil {
+			output += ", encrypted with age"
+		}
+		return append(outputs, output), nil
+	}
+
+	upload, err := simulateUpload(config, venafiCloudMode, readings)
+	if err != nil {
+		return nil, err
+	}
+	outputs = append(outputs, fmt.Sprintf("readings uploaded to %s %s", config.Server, upload))
+	if config.Spool != nil {
+		outputs = append(outputs, fmt.Sprintf("readings that fail to upload spooled to %s", config.Spool.Directory))
+	}
+	if config.Mirror != nil {
+		upload, err := simulateUpload(config, config.Mirror.venafiCloudMode(), readings)
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, fmt.Sprintf("readings uploaded to the mirror %s %s", config.Mirror.Server, upload))
+	}
+	return outputs, nil
+}
+
+// simulateUpload describes the requests uploadReadings would make.
+func simulateUpload(config Config, venafiCloudMode bool, readings []*api.DataReading) (string, error) {
+	if config.ChunkedUpload && !venafiCloudMode {
+		parts, err := uploadParts(config, readings)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("in an upload session of %d part(s)", len(parts)), nil
+	}
+	payloads, err := limitPayload(readings, config.MaxPayloadBytes, oversizedPayloadDrop)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("in %d request(s)", len(payloads)), nil
+}
+
+func printSimulation(out io.Writer, result *simulation) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "DATA GATHERER\tSIZE\tFINDINGS")
+	var findings []api.Finding
+	for _, reading := range result.Readings {
+		size := "-"
+		if n, err := encodedSize(reading); err == nil {
+			size = formatBytes(int64(n))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", reading.DataGatherer, size, len(reading.Findings))
+		findings = append(findings, reading.Findings...)
+	}
+	w.Flush()
+
+	if len(findings) > 0 {
+		fmt.Fprintln(out, "\nFindings:")
+		w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, finding := range findings {
+			resource := finding.Resource.Kind + " " + finding.Resource.Name
+			if finding.Resource.Namespace != "" {
+				resource = finding.Resource.Kind + " " + finding.Resource.Namespace + "/" + finding.Resource.Name
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", finding.Severity, finding.RuleID, resource, finding.Message)
+		}
+		w.Flush()
+	}
+
+	fmt.Fprintln(out, "\nOutputs, nothing was sent or written:")
+	for _, output := range result.Outputs {
+		fmt.Fprintf(out, "  %s\n", output)
+	}
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+const simulateFixtures = `apiVersion: v1
+kind: Secret
+metadata:
+  name: example
+  namespace: default
+data:
+  password: c2VjcmV0
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: everything
+rules:
+- apiGroups: ["*"]
+  resources: ["*"]
+  verbs: ["*"]
+`
+
+const simulateConfig = `server: https://platform.jetstack.io
+organization_id: example
+cluster_id: example-cluster
+chunked-upload: true
+mirror:
+  server: https://mirror.example.com
+  api-token: token
+data-gatherers:
+- kind: k8s-dynamic
+  name: k8s/secrets
+  config:
+    resource-type:
+      version: v1
+      resource: secrets
+- kind: k8s-rbac
+  name: k8s-rbac
+`
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "objects.yaml"), []byte(simulateFixtures), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fixtures, err := k8s.LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	config, err := ParseConfig([]byte(simulateConfig), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result, err := simulate(ctx, config, false, fixtures)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(result.Readings) != 2 || result.Readings[0].DataGatherer != "k8s-rbac" || result.Readings[1].DataGatherer != "k8s/secrets" {
+		t.Fatalf("unexpected readings: %+v", result.Readings)
+	}
+	if len(result.Readings[0].Findings) != 1 || result.Readings[0].Findings[0].RuleID != "wildcard-grant" {
+		t.Errorf("expected a wildcard-grant finding, got %+v", result.Readings[0].Findings)
+	}
+	// the data of secrets is redacted
+	data, err := json.Marshal(result.Readings[1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "c2VjcmV0") {
+		t.Errorf("the data of the secret was not redacted: %s", data)
+	}
+
+	expected := []string{
+		"readings uploaded to https://platform.jetstack.io in an upload session of 2 part(s)",
+		"readings uploaded to the mirror https://mirror.example.com in an upload session of 2 part(s)",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, result.Outputs); !equal {
+		t.Errorf("unexpected outputs:\n%s", diff)
+	}
+}
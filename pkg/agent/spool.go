@@ -0,0 +1,221 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	defaultSpoolMaxSize = 100 * 1024 * 1024
+	defaultSpoolMaxAge  = 24 * time.Hour
+
+	spoolFilePrefix = "readings-"
+	spoolFileSuffix = ".json"
+)
+
+// SpoolConfig configures the spool the readings that failed to upload are
+// written to, to be uploaded once the server is reachable again, e.g. after
+// a network partition or an outage of the backend.
+type SpoolConfig struct {
+	// Directory holds the spooled readings, one file per cycle. It is
+	// created if it doesn't exist.
+	Directory string `yaml:"directory"`
+	// MaxSize is the total size of the spooled readings, in bytes, above
+	// which the oldest are evicted. Defaults to 100MiB.
+	MaxSize int64 `yaml:"max-size,omitempty"`
+	// MaxAge is how long readings are spooled before they are evicted.
+	// Defaults to 24h.
+	MaxAge time.Duration `yaml:"max-age,omitempty"`
+}
+
+func (c *SpoolConfig) validate() error {
+	if c.Directory == "" {
+		return fmt.Errorf("spool.directory is required")
+	}
+	if c.MaxSize < 0 {
+		return fmt.Errorf("spool.max-size must not be negative")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("spool.max-age must not be negative")
+	}
+	return nil
+}
+
+// spool is a queue of readings on disk, in the order they were gathered.
+type spool struct {
+	directory string
+	maxSize   int64
+	maxAge    time.Duration
+	now       func() time.Time
+}
+
+// spooledReadings is a file of the spool.
+type spooledReadings struct {
+	path     string
+	gathered time.Time
+	size     int64
+}
+
+func newSpool(config SpoolConfig) *spool {
+	s := &spool{
+		directory: config.Directory,
+		maxSize:   config.MaxSize,
+		maxAge:    config.MaxAge,
+		now:       time.Now,
+	}
+	if s.maxSize == 0 {
+		s.maxSize = defaultSpoolMaxSize
+	}
+	if s.maxAge == 0 {
+		s.maxAge = defaultSpoolMaxAge
+	}
+	return s
+}
+
+// upload uploads the spooled readings, oldest first, then readings. The
+// readings are spooled if they, or any spooled readings, fail to upload, in
+// which case no error is returned unless they can't be spooled.
+func (s *spool) upload(readings []*api.DataReading, upload func([]*api.DataReading) error) error {
+	err := s.replay(upload)
+	if err == nil {
+		err = upload(readings)
+	}
+	if err == nil {
+		return nil
+	}
+
+	if spoolErr := s.push(readings); spoolErr != nil {
+		return fmt.Errorf("%w, and failed to spool the readings: %s", err, spoolErr)
+	}
+	log.Printf("failed to upload the readings, they were spooled to upload later: %s", err)
+	return nil
+}
+
+// replay uploads the spooled readings, oldest first, removing them once they
+// are uploaded. It stops at the first failure.
+func (s *spool) replay(upload func([]*api.DataReading) error) error {
+	entries, err := s.evict()
+	if err != nil {
+		return err
+	}
+	for i, entry := range entries {
+		data, err := os.ReadFile(entry.path)
+		if err != nil {
+			return fmt.Errorf("failed to read spooled readings: %w", err)
+		}
+		var readings []*api.DataReading
+		if err := json.Unmarshal(data, &readings); err != nil {
+			log.Printf("removing spooled readings %s, they cannot be parsed: %s", entry.path, err)
+			os.Remove(entry.path)
+			continue
+		}
+
+		log.Printf("uploading the readings gathered at %s from the spool, %d left", entry.gathered.Format(time.RFC3339), len(entries)-i-1)
+		if err := upload(readings); err != nil {
+			return err
+		}
+		if err := os.Remove(entry.path); err != nil {
+			return fmt.Errorf("failed to remove uploaded readings from the spool: %w", err)
+		}
+	}
+	return nil
+}
+
+// push writes readings to the spool, then evicts the readings exceeding the
+// limits of the spool.
+func (s *spool) push(readings []*api.DataReading) error {
+	if err := os.MkdirAll(s.directory, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(readings)
+	if err != nil {
+		return err
+	}
+	// written to a temporary file first so that a crash doesn't leave a
+	// truncated file behind
+	path := filepath.Join(s.directory, fmt.Sprintf("%s%020d%s", spoolFilePrefix, s.now().UnixNano(), spoolFileSuffix))
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	_, err = s.evict()
+	return err
+}
+
+// evict removes the spooled readings older than maxAge, then the oldest ones
+// until the spool is within maxSize. It returns the remaining readings,
+// oldest first.
+func (s *spool) evict() ([]spooledReadings, error) {
+	entries, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+
+	total := int64(0)
+	var kept []spooledReadings
+	for _, entry := range entries {
+		if s.now().Sub(entry.gathered) > s.maxAge {
+			log.Printf("evicting the readings gathered at %s from the spool, they are older than %s", entry.gathered.Format(time.RFC3339), s.maxAge)
+			os.Remove(entry.path)
+			continue
+		}
+		kept = append(kept, entry)
+		total += entry.size
+	}
+	for len(kept) > 0 && total > s.maxSize {
+		entry := kept[0]
+		log.Printf("evicting the readings gathered at %s from the spool, which exceeds %d bytes", entry.gathered.Format(time.RFC3339), s.maxSize)
+		os.Remove(entry.path)
+		kept = kept[1:]
+		total -= entry.size
+	}
+	return kept, nil
+}
+
+// list returns the spooled readings, oldest first.
+func (s *spool) list() ([]spooledReadings, error) {
+	files, err := os.ReadDir(s.directory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var entries []spooledReadings
+	for _, file := range files {
+		name := file.Name()
+		if !file.Type().IsRegular() || !strings.HasPrefix(name, spoolFilePrefix) || !strings.HasSuffix(name, spoolFileSuffix) {
+			continue
+		}
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, spoolFilePrefix), spoolFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, spooledReadings{
+			path:     filepath.Join(s.directory, name),
+			gathered: time.Unix(0, nanos),
+			size:     info.Size(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].gathered.Before(entries[j].gathered) })
+	return entries, nil
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestSpool(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	s := newSpool(SpoolConfig{Directory: t.TempDir()})
+	s.now = func() time.Time { return now }
+
+	var uploaded []string
+	failing := true
+	upload := func(readings []*api.DataReading) error {
+		if failing {
+			return fmt.Errorf("connection refused")
+		}
+		uploaded = append(uploaded, readings[0].DataGatherer)
+		return nil
+	}
+	cycle := func(name string) {
+		t.Helper()
+		if err := s.upload([]*api.DataReading{{DataGatherer: name}}, upload); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		now = now.Add(time.Minute)
+	}
+
+	// the readings are spooled while uploads fail
+	cycle("first")
+	cycle("second")
+	entries, err := s.list()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 spooled readings, got %d", len(entries))
+	}
+
+	// and uploaded in order once uploads succeed
+	failing = false
+	cycle("third")
+	if diff, equal := messagediff.PrettyDiff([]string{"first", "second", "third"}, uploaded); !equal {
+		t.Errorf("unexpected uploads:\n%s", diff)
+	}
+	if entries, _ := s.list(); len(entries) != 0 {
+		t.Errorf("expected the spool to be empty, got %d readings", len(entries))
+	}
+}
+
+func TestSpoolReplayStopsAtFailure(t *testing.T) {
+	s := newSpool(SpoolConfig{Directory: t.TempDir()})
+	for i := 0; i < 3; i++ {
+		now := time.Date(2024, 1, 2, 3, i, 0, 0, time.UTC)
+		s.now = func() time.Time { return now }
+		if err := s.push([]*api.DataReading{{DataGatherer: fmt.Sprint(i)}}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// the readings of a cycle are spooled without being uploaded when
+	// spooled readings fail to upload, to keep the order
+	s.now = func() time.Time { return time.Date(2024, 1, 2, 3, 3, 0, 0, time.UTC) }
+	var attempts []string
+	err := s.upload([]*api.DataReading{{DataGatherer: "3"}}, func(readings []*api.DataReading) error {
+		attempts = append(attempts, readings[0].DataGatherer)
+		if readings[0].DataGatherer == "1" {
+			return fmt.Errorf("service unavailable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"0", "1"}, attempts); !equal {
+		t.Errorf("unexpected upload attempts:\n%s", diff)
+	}
+	if entries, _ := s.list(); len(entries) != 3 {
+		t.Errorf("expected 3 spooled readings, got %d", len(entries))
+	}
+}
+
+func TestSpoolEviction(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	reading := []*api.DataReading{{DataGatherer: "dummy", Data: strings.Repeat("x", 1000)}}
+
+	s := newSpool(SpoolConfig{Directory: t.TempDir(), MaxAge: time.Hour, MaxSize: 2500})
+	s.now = func() time.Time { return now }
+	push := func() {
+		t.Helper()
+		if err := s.push(reading); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	gathered := func() []time.Time {
+		entries, err := s.list()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var result []time.Time
+		for _, entry := range entries {
+			result = append(result, entry.gathered.UTC())
+		}
+		return result
+	}
+
+	// the oldest readings are evicted above max-size
+	push()
+	now = now.Add(time.Minute)
+	push()
+	now = now.Add(time.Minute)
+	push()
+	expected := []time.Time{now.Add(-time.Minute), now}
+	if diff, equal := messagediff.PrettyDiff(expected, gathered()); !equal {
+		t.Errorf("unexpected spooled readings:\n%s", diff)
+	}
+
+	// and those older than max-age
+	now = now.Add(2 * time.Hour)
+	push()
+	if diff, equal := messagediff.PrettyDiff([]time.Time{now}, gathered()); !equal {
+		t.Errorf("unexpected spooled readings:\n%s", diff)
+	}
+
+	// no temporary files are left behind
+	files, _ := os.ReadDir(s.directory)
+	if len(files) != 1 {
+		t.Errorf("expected a single file in the spool, got %d", len(files))
+	}
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	json "github.com/json-iterator/go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+	"github.com/jetstack/preflight/pkg/version"
+)
+
+const (
+	defaultStatusConfigMapName = "jetstack-secure-agent-status"
+	// statusFieldManager is the field manager of the server-side apply
+	// requests writing the status ConfigMap.
+	statusFieldManager = "jetstack-secure-agent"
+	// serviceAccountNamespacePath holds the namespace of the pod when
+	// running in-cluster.
+	serviceAccountNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// StatusConfigMapConfig configures the ConfigMap the agent writes the
+// outcome of each cycle to: when it ran, how many readings it gathered, the
+// size of the upload and the errors.
+type StatusConfigMapConfig struct {
+	// Name of the ConfigMap. Defaults to jetstack-secure-agent-status.
+	Name string `yaml:"name,omitempty"`
+	// Namespace of the ConfigMap. Defaults to the namespace of the agent,
+	// from the POD_NAMESPACE environment variable or the service account.
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+func (c *StatusConfigMapConfig) validate() error {
+	var errs []string
+	if c.Name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.Name) {
+			errs = append(errs, fmt.Sprintf("status-configmap.name: %s", msg))
+		}
+	}
+	if c.Namespace != "" {
+		for _, msg := range validation.IsDNS1123Label(c.Namespace) {
+			errs = append(errs, fmt.Sprintf("status-configmap.namespace: %s", msg))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// writeStatusConfigMap writes the status of the cycle to the ConfigMap.
+// Failing to write it doesn't stop the agent, the error is only logged.
+func writeStatusConfigMap(ctx context.Context, config StatusConfigMapConfig, cycle cycleStatus) {
+	clientset, err := k8s.NewClientSet(ctx, "")
+	if err != nil {
+		log.Printf("failed to write the status ConfigMap: %s", err)
+		return
+	}
+	if err := applyStatusConfigMap(ctx, clientset, config, cycle); err != nil {
+		log.Printf("failed to write the status ConfigMap: %s", err)
+	}
+}
+
+// applyStatusConfigMap creates or updates the ConfigMap with server-side
+// apply, which only needs the permission to patch it.
+func applyStatusConfigMap(ctx context.Context, clientset kubernetes.Interface, config StatusConfigMapConfig, cycle cycleStatus) error {
+	name := config.Name
+	if name == "" {
+		name = defaultStatusConfigMapName
+	}
+	namespace := config.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = agentNamespace(); err != nil {
+			return err
+		}
+	}
+
+	data, err := statusConfigMapData(cycle)
+	if err != nil {
+		return err
+	}
+	configMap := corev1ac.ConfigMap(name, namespace).
+		WithLabels(map[string]string{"app.kubernetes.io/managed-by": statusFieldManager}).
+		WithData(data)
+	_, err = clientset.CoreV1().ConfigMaps(namespace).Apply(ctx, configMap, metav1.ApplyOptions{
+		FieldManager: statusFieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return nil
+}
+
+// statusConfigMapData returns the data of the status ConfigMap. The errors
+// are a JSON object of the last error of each data gatherer, and of the
+// uploads, during the cycle. The payload size is left out if it isn't known,
+// and server-side apply then removes it from the ConfigMap.
+func statusConfigMapData(cycle cycleStatus) (map[string]string, error) {
+	errors, err := json.Marshal(cycle.Errors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the errors: %w", err)
+	}
+	data := map[string]string{
+		"version":      version.PreflightVersion,
+		"lastRunStart": cycle.StartedAt.In(location).Format(time.RFC3339),
+		"lastRunEnd":   cycle.FinishedAt.In(location).Format(time.RFC3339),
+		"lastRunTime":  cycle.FinishedAt.Sub(cycle.StartedAt).Round(time.Millisecond).String(),
+		"readings":     strconv.Itoa(cycle.Readings),
+		"healthy":      strconv.FormatBool(len(cycle.Errors) == 0),
+		"errors":       string(errors),
+	}
+	if cycle.PayloadSize > 0 {
+		data["payloadSize"] = strconv.FormatInt(cycle.PayloadSize, 10)
+	}
+	return data, nil
+}
+
+// agentNamespace returns the namespace the agent runs in.
+func agentNamespace() (string, error) {
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace, nil
+	}
+	data, err := os.ReadFile(serviceAccountNamespacePath)
+	if err != nil {
+		return "", fmt.Errorf("status-configmap.namespace is not set, and the namespace of the agent is unknown: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
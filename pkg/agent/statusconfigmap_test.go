@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/jetstack/preflight/pkg/version"
+)
+
+func TestApplyStatusConfigMap(t *testing.T) {
+	// the timestamps are in the time zone of the agent
+	defer func(loc *time.Location) { location = loc }(location)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	location = tokyo
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := newStatus()
+	now := start
+	s.now = func() time.Time { return now }
+
+	s.recordError("before", fmt.Errorf("not part of the cycle"))
+	s.startCycle()
+	s.recordError("k8s/pods", fmt.Errorf("first"))
+	s.recordError("k8s/pods", fmt.Errorf("timed out"))
+	s.recordReadings(3)
+	s.recordPayloadSize(1234)
+	now = now.Add(1500 * time.Millisecond)
+	cycle := s.finishCycle()
+
+	// the fake clientset doesn't support server-side apply
+	clientset := fake.NewSimpleClientset()
+	var patch k8stesting.PatchAction
+	clientset.PrependReactor("patch", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch = action.(k8stesting.PatchAction)
+		return true, &corev1.ConfigMap{}, nil
+	})
+
+	config := StatusConfigMapConfig{Namespace: "jetstack-secure"}
+	if err := applyStatusConfigMap(context.Background(), clientset, config, cycle); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if patch == nil {
+		t.Fatalf("expected the ConfigMap to be applied")
+	}
+	if patch.GetPatchType() != types.ApplyPatchType {
+		t.Errorf("expected a server-side apply, got %s", patch.GetPatchType())
+	}
+	if patch.GetNamespace() != "jetstack-secure" || patch.GetName() != defaultStatusConfigMapName {
+		t.Errorf("unexpected ConfigMap %s/%s", patch.GetNamespace(), patch.GetName())
+	}
+
+	var applied corev1.ConfigMap
+	if err := json.Unmarshal(patch.GetPatch(), &applied); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]string{
+		"version":      version.PreflightVersion,
+		"lastRunStart": "2024-01-02T12:04:05+09:00",
+		"lastRunEnd":   "2024-01-02T12:04:06+09:00",
+		"lastRunTime":  "1.5s",
+		"readings":     "3",
+		"payloadSize":  "1234",
+		"healthy":      "false",
+		"errors":       `{"k8s/pods":"timed out"}`,
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, applied.Data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestStatusConfigMapConfigValidate(t *testing.T) {
+	if err := (&StatusConfigMapConfig{}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err := (&StatusConfigMapConfig{Name: "Agent_Status", Namespace: "jetstack.secure"}).validate()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"io"
+	"log"
+	"time"
+	// embed the time zone database, as the agent image doesn't have one
+	_ "time/tzdata"
+)
+
+// logTimeFormat is the format of the timestamps of the standard logger.
+const logTimeFormat = "2006/01/02 15:04:05 "
+
+// location is the time zone of the timestamps of the logs and reports of the
+// agent.
+var location = time.Local
+
+// setTimezone makes the logs and reports use the time zone, which is the
+// name of a location in the IANA Time Zone database, e.g. Europe/London.
+func setTimezone(name string) error {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	location = loc
+
+	log.SetOutput(&timestampWriter{out: log.Writer(), location: loc, now: time.Now})
+	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime | log.Lmicroseconds | log.LUTC))
+	return nil
+}
+
+// timestampWriter prefixes each line written by the standard logger with a
+// timestamp in a time zone, as the logger only supports local time and UTC.
+type timestampWriter struct {
+	out      io.Writer
+	location *time.Location
+	now      func() time.Time
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	line := append([]byte(w.now().In(w.location).Format(logTimeFormat)), p...)
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
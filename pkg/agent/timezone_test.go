@@ -0,0 +1,24 @@
+package agent
+
+import (
+	"bytes"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	var out bytes.Buffer
+	logger := log.New(&timestampWriter{out: &out, location: loc, now: now}, "", 0)
+	logger.Printf("gathering data")
+
+	if got, want := out.String(), "2024/01/02 08:34:05 gathering data\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
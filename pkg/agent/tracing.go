@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jetstack/preflight/pkg/version"
+)
+
+const tracingServiceName = "jetstack-secure-agent"
+
+// tracer traces the cycles of the agent. Its spans are dropped unless
+// tracing is configured.
+var tracer = otel.Tracer("github.com/jetstack/preflight/pkg/agent")
+
+// TracingConfig configures the export of OpenTelemetry traces of the cycles
+// of the agent over OTLP/HTTP: a span per cycle, per data gatherer fetch,
+// per upload and per upload attempt.
+type TracingConfig struct {
+	// Endpoint is the host and port of the OTLP/HTTP collector, e.g.
+	// otel-collector:4318. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable, or localhost:4318.
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// URLPath is the path traces are sent to. Defaults to /v1/traces.
+	URLPath string `yaml:"url-path,omitempty"`
+	// Insecure sends the traces over HTTP rather than HTTPS.
+	Insecure bool `yaml:"insecure,omitempty"`
+	// Headers are sent with the traces, e.g. for authentication.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// SampleRatio is the ratio of the cycles traced, between 0 and 1.
+	// Defaults to 1.
+	SampleRatio *float64 `yaml:"sample-ratio,omitempty"`
+}
+
+func (c *TracingConfig) validate() error {
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		return fmt.Errorf("tracing.sample-ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// startTracing registers a tracer provider exporting the spans to the
+// configured collector. The returned function flushes the remaining spans.
+func startTracing(ctx context.Context, config TracingConfig, clusterID string) (func(context.Context) error, error) {
+	var options []otlptracehttp.Option
+	if config.Endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+	}
+	if config.URLPath != "" {
+		options = append(options, otlptracehttp.WithURLPath(config.URLPath))
+	}
+	if config.Insecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(config.Headers))
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+	}
+
+	ratio := 1.0
+	if config.SampleRatio != nil {
+		ratio = *config.SampleRatio
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("service.name", tracingServiceName),
+		attribute.String("service.version", version.PreflightVersion),
+	}
+	if clusterID != "" {
+		attributes = append(attributes, attribute.String("k8s.cluster.name", clusterID))
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(attributes...)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// endFetchSpan records the outcome of a data gatherer fetch and ends its
+// span.
+func endFetchSpan(span trace.Span, count int, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if count >= 0 {
+		span.SetAttributes(attribute.Int("count", count))
+	}
+	span.End()
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	defer func(d time.Duration) { uploadRetryInterval = d }(uploadRetryInterval)
+	uploadRetryInterval = time.Millisecond
+
+	ctx, span := tracer.Start(context.Background(), "cycle")
+	gatherData(ctx, Config{}, map[string]datagatherer.DataGatherer{
+		"ok":     &dummyDataGatherer{},
+		"failed": &dummyDataGatherer{AlwaysFail: true},
+	})
+	attempts := 0
+	err := retryUpload(ctx, func() error {
+		attempts++
+		if attempts < 2 {
+			return errors.New("unavailable")
+		}
+		return nil
+	}, "retrying")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	span.End()
+
+	fetchStatus := map[string]codes.Code{}
+	var attemptStatus []codes.Code
+	for _, s := range recorder.Ended() {
+		if s.Name() != "cycle" && s.Parent().SpanID() == [8]byte{} {
+			t.Errorf("span %q has no parent", s.Name())
+		}
+		switch s.Name() {
+		case "fetch":
+			for _, a := range s.Attributes() {
+				if a.Key == "datagatherer" {
+					fetchStatus[a.Value.AsString()] = s.Status().Code
+				}
+			}
+		case "upload attempt":
+			attemptStatus = append(attemptStatus, s.Status().Code)
+		}
+	}
+	if len(fetchStatus) != 2 || fetchStatus["ok"] != codes.Unset || fetchStatus["failed"] != codes.Error {
+		t.Errorf("unexpected fetch spans: %v", fetchStatus)
+	}
+	if len(attemptStatus) != 2 || attemptStatus[0] != codes.Error || attemptStatus[1] != codes.Unset {
+		t.Errorf("unexpected upload attempt spans: %v", attemptStatus)
+	}
+}
+
+func TestTracingConfigValidate(t *testing.T) {
+	ratio := 1.5
+	if err := (&TracingConfig{SampleRatio: &ratio}).validate(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
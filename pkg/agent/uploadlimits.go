@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	json "github.com/json-iterator/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+const (
+	// oversizedPayloadChunk uploads the readings in several parts of an
+	// upload session.
+	oversizedPayloadChunk = "chunk"
+	// oversizedPayloadDrop drops the largest readings.
+	oversizedPayloadDrop = "drop"
+)
+
+// validateUploadLimits checks the max-payload-bytes, max-upload-bandwidth and
+// oversized-payload settings.
+func (c *Config) validateUploadLimits() error {
+	if c.MaxPayloadBytes < 0 {
+		return fmt.Errorf("max-payload-bytes must not be negative")
+	}
+	if c.MaxUploadBandwidth < 0 {
+		return fmt.Errorf("max-upload-bandwidth must not be negative")
+	}
+	switch c.OversizedPayload {
+	case "", oversizedPayloadChunk, oversizedPayloadDrop:
+	default:
+		return fmt.Errorf("oversized-payload must be %s or %s, got %q", oversizedPayloadChunk, oversizedPayloadDrop, c.OversizedPayload)
+	}
+	// each request to the datareadings endpoint replaces the previous
+	// readings, so they can only be split in an upload session
+	if c.OversizedPayload == oversizedPayloadChunk && !c.ChunkedUpload {
+		return fmt.Errorf("oversized-payload %s requires chunked-upload", oversizedPayloadChunk)
+	}
+	return nil
+}
+
+// readingSize is the size of the JSON encoding of a reading.
+type readingSize struct {
+	reading *api.DataReading
+	size    int
+}
+
+func readingSizes(readings []*api.DataReading) ([]readingSize, int, error) {
+	sizes := make([]readingSize, len(readings))
+	total := 0
+	for i, reading := range readings {
+		size, err := encodedSize(reading)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to marshal reading %q: %w", reading.DataGatherer, err)
+		}
+		sizes[i] = readingSize{reading: reading, size: size}
+		total += size
+	}
+	return sizes, total, nil
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// encodedSize returns the size of the JSON encoding of v without keeping the
+// encoding: the encoder writes out each gathered resource, which marshals
+// itself, as it goes, so only one of them is encoded in memory at a time.
+func encodedSize(v interface{}) (int, error) {
+	var counter byteCounter
+	if err := json.NewEncoder(&counter).Encode(v); err != nil {
+		return 0, err
+	}
+	// Encode terminates the value with a newline
+	return int(counter) - 1, nil
+}
+
+// limitPayload splits the readings into the payloads to upload, so that the
+// readings of each payload are at most maxBytes. When oversized is drop, the
+// largest readings are dropped until the rest fit in a single payload;
+// otherwise, the readings are packed into several payloads, in order. Any
+// reading larger than maxBytes on its own is dropped. The dropped readings
+// are logged.
+func limitPayload(readings []*api.DataReading, maxBytes int64, oversized string) ([][]*api.DataReading, error) {
+	if maxBytes <= 0 {
+		return [][]*api.DataReading{readings}, nil
+	}
+	sizes, total, err := readingSizes(readings)
+	if err != nil {
+		return nil, err
+	}
+	if int64(total) <= maxBytes {
+		return [][]*api.DataReading{readings}, nil
+	}
+
+	dropped := map[*api.DataReading]bool{}
+	if oversized == oversizedPayloadDrop {
+		bySize := append([]readingSize(nil), sizes...)
+		sort.SliceStable(bySize, func(i, j int) bool { return bySize[i].size > bySize[j].size })
+		for _, rs := range bySize {
+			if int64(total) <= maxBytes {
+				break
+			}
+			dropped[rs.reading] = true
+			total -= rs.size
+		}
+	}
+
+	var payloads [][]*api.DataReading
+	var current []*api.DataReading
+	currentSize := int64(0)
+	for _, rs := range sizes {
+		if !dropped[rs.reading] && int64(rs.size) > maxBytes {
+			dropped[rs.reading] = true
+		}
+		if dropped[rs.reading] {
+			log.Printf("warning: dropping the reading of %q, its %d bytes exceed max-payload-bytes (%d)", rs.reading.DataGatherer, rs.size, maxBytes)
+			continue
+		}
+		if currentSize+int64(rs.size) > maxBytes && len(current) > 0 {
+			payloads = append(payloads, current)
+			current, currentSize = nil, 0
+		}
+		current = append(current, rs.reading)
+		currentSize += int64(rs.size)
+	}
+	if len(current) > 0 {
+		payloads = append(payloads, current)
+	}
+	if len(payloads) > 1 {
+		log.Printf("the readings exceed max-payload-bytes (%d), uploading them in %d parts", maxBytes, len(payloads))
+	}
+
+	return payloads, nil
+}
+
+// uploadRetryInterval is the initial interval of the exponential backoff
+// of the uploads.
+var uploadRetryInterval = 30 * time.Second
+
+// uploadReadings uploads the readings within the payload size limit of the
+// config in a single request, retried with an exponential backoff. As the
+// server replaces its readings of the cluster with those of each request, the
+// largest readings are dropped to fit in the limit rather than split across
+// requests. With chunked-upload, the readings are uploaded in an upload
+// session instead, which can split them.
+func uploadReadings(ctx context.Context, config Config, venafiCloudMode bool, preflightClient client.Client, agentMetadata *api.AgentMetadata, readings []*api.DataReading, retryMessage string) error {
+	ctx, span := tracer.Start(ctx, "upload", trace.WithAttributes(attribute.Int("readings", len(readings))))
+	defer span.End()
+	if config.ChunkedUpload && !venafiCloudMode {
+		return uploadChunked(ctx, config, preflightClient, agentMetadata, readings, retryMessage)
+	}
+	payloads, err := limitPayload(readings, config.MaxPayloadBytes, oversizedPayloadDrop)
+	if err != nil {
+		return err
+	}
+	for _, payload := range payloads {
+		err := retryUpload(ctx, func() error {
+			return postData(ctx, config, venafiCloudMode, preflightClient, payload)
+		}, retryMessage)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retryUpload calls upload until it succeeds, with an exponential backoff
+// of up to BackoffMaxTime, or until ctx is done. Each attempt is traced in
+// its own span.
+func retryUpload(ctx context.Context, upload func() error, retryMessage string) error {
+	backOff := backoff.NewExponentialBackOff()
+	backOff.InitialInterval = uploadRetryInterval
+	backOff.MaxInterval = 3 * time.Minute
+	backOff.MaxElapsedTime = BackoffMaxTime
+	attempt := 0
+	tracedUpload := func() error {
+		if err := ctx.Err(); err != nil {
+			return backoff.Permanent(err)
+		}
+		attempt++
+		_, span := tracer.Start(ctx, "upload attempt", trace.WithAttributes(attribute.Int("attempt", attempt)))
+		defer span.End()
+		err := upload()
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+	return backoff.RetryNotify(tracedUpload, backoff.WithContext(backOff, ctx), func(err error, t time.Duration) {
+		agentStatus.recordError(uploadErrorSource, err)
+		agentEvents.uploadFailed(err)
+		log.Printf("%s in %v after error: %s", retryMessage, t, err)
+	})
+}
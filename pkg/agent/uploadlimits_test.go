@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	json "github.com/json-iterator/go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestLimitPayload(t *testing.T) {
+	reading := func(name string, size int) *api.DataReading {
+		return &api.DataReading{DataGatherer: name, Data: strings.Repeat("x", size)}
+	}
+	a, b, c, huge := reading("a", 400), reading("b", 400), reading("c", 100), reading("huge", 2000)
+	names := func(payloads [][]*api.DataReading) [][]string {
+		var result [][]string
+		for _, payload := range payloads {
+			var names []string
+			for _, r := range payload {
+				names = append(names, r.DataGatherer)
+			}
+			result = append(result, names)
+		}
+		return result
+	}
+
+	tests := map[string]struct {
+		readings  []*api.DataReading
+		maxBytes  int64
+		oversized string
+		expected  [][]string
+	}{
+		"no limit":     {readings: []*api.DataReading{a, b, huge}, expected: [][]string{{"a", "b", "huge"}}},
+		"within":       {readings: []*api.DataReading{a, c}, maxBytes: 1000, expected: [][]string{{"a", "c"}}},
+		"chunked":      {readings: []*api.DataReading{a, b, c}, maxBytes: 1000, expected: [][]string{{"a", "b"}, {"c"}}},
+		"too large":    {readings: []*api.DataReading{a, huge, c}, maxBytes: 1000, expected: [][]string{{"a", "c"}}},
+		"drop":         {readings: []*api.DataReading{a, b, c}, maxBytes: 1000, oversized: "drop", expected: [][]string{{"b", "c"}}},
+		"drop largest": {readings: []*api.DataReading{a, huge, c}, maxBytes: 1000, oversized: "drop", expected: [][]string{{"a", "c"}}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			payloads, err := limitPayload(tc.readings, tc.maxBytes, tc.oversized)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.expected, names(payloads)); !equal {
+				t.Errorf("unexpected payloads:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEncodedSize(t *testing.T) {
+	reading := &api.DataReading{
+		DataGatherer: "k8s/pods",
+		Timestamp:    api.Time{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Data: map[string]interface{}{"items": []*api.GatheredResource{
+			{Resource: map[string]interface{}{"name": "a<b>"}},
+			{Resource: map[string]interface{}{"name": "c"}},
+		}},
+	}
+	data, err := json.Marshal(reading)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	size, err := encodedSize(reading)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if size != len(data) {
+		t.Errorf("unexpected size: got=%d want=%d", size, len(data))
+	}
+}
+
+func TestUploadBandwidthLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	config := Config{MaxUploadBandwidth: 10000}
+	c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// the first 10000 bytes are sent at once, the next 15000 take 1.5s
+	started := time.Now()
+	readings := []*api.DataReading{{DataGatherer: "a", Data: strings.Repeat("x", 25000)}}
+	if err := c.PostDataReadings("org", "cluster", readings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("the upload took %s, faster than the bandwidth limit allows", elapsed)
+	}
+}
+
+func TestValidateUploadLimits(t *testing.T) {
+	if err := (&Config{OversizedPayload: "truncate"}).validateUploadLimits(); err == nil {
+		t.Errorf("expected an error for an unknown oversized-payload")
+	}
+	if err := (&Config{MaxPayloadBytes: 1 << 20, OversizedPayload: "drop"}).validateUploadLimits(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := (&Config{MaxPayloadBytes: 1 << 20, OversizedPayload: "chunk"}).validateUploadLimits(); err == nil || err.Error() != "oversized-payload chunk requires chunked-upload" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (&Config{MaxPayloadBytes: 1 << 20, OversizedPayload: "chunk", ChunkedUpload: true}).validateUploadLimits(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestUploadReadingsSinglePayload(t *testing.T) {
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+	}))
+	defer ts.Close()
+
+	config := Config{OrganizationID: "example", ClusterID: "example-cluster", MaxPayloadBytes: 1000}
+	c, err := createClient(backendCredentials{apiToken: "token"}, config, &api.AgentMetadata{}, ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	readings := []*api.DataReading{
+		{DataGatherer: "a", Data: strings.Repeat("x", 400)},
+		{DataGatherer: "b", Data: strings.Repeat("y", 500)},
+		{DataGatherer: "c", Data: strings.Repeat("z", 100)},
+	}
+	// each request replaces the readings of the cluster, so the largest
+	// reading is dropped rather than uploaded in a second request
+	if err := uploadReadings(context.Background(), config, false, c, &api.AgentMetadata{}, readings, "retrying"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("expected a single request, got %d", len(bodies))
+	}
+	if strings.Contains(bodies[0], "yyy") || !strings.Contains(bodies[0], "xxx") || !strings.Contains(bodies[0], "zzz") {
+		t.Errorf("expected the reading of b to be dropped, got %s", bodies[0])
+	}
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// onExceededReport only reports the exceeded limits.
+	onExceededReport = "report"
+	// onExceededSkipCycle skips gathering for the cycle, after returning
+	// free memory to the operating system.
+	onExceededSkipCycle = "skip-cycle"
+	// onExceededExit exits the agent, so that it is restarted.
+	onExceededExit = "exit"
+)
+
+// ResourceLimitsConfig caps the resources the agent itself uses. The usage is
+// sampled at the start of each cycle and reported with the data.
+type ResourceLimitsConfig struct {
+	// MaxMemory is the resident memory in bytes above which the limits are
+	// exceeded. If 0, the memory is not limited.
+	MaxMemory uint64 `yaml:"max-memory"`
+	// MaxCycleCPUSeconds is the CPU time used in a cycle above which the
+	// limits are exceeded. If 0, the CPU time is not limited.
+	MaxCycleCPUSeconds float64 `yaml:"max-cycle-cpu-seconds"`
+	// MaxGoroutines is the number of goroutines above which the limits are
+	// exceeded. If 0, the goroutines are not limited.
+	MaxGoroutines int `yaml:"max-goroutines"`
+	// OnExceeded is what the agent does when a limit is exceeded: report,
+	// skip-cycle or exit. Defaults to report.
+	OnExceeded string `yaml:"on-exceeded"`
+}
+
+func (l *ResourceLimitsConfig) validate() error {
+	if l.MaxCycleCPUSeconds < 0 {
+		return fmt.Errorf("resource-limits.max-cycle-cpu-seconds must not be negative")
+	}
+	if l.MaxGoroutines < 0 {
+		return fmt.Errorf("resource-limits.max-goroutines must not be negative")
+	}
+	switch l.OnExceeded {
+	case "", onExceededReport, onExceededSkipCycle, onExceededExit:
+	default:
+		return fmt.Errorf("resource-limits.on-exceeded must be one of %s, %s or %s", onExceededReport, onExceededSkipCycle, onExceededExit)
+	}
+	return nil
+}
+
+// exceeded returns the limits the usage exceeds.
+func (l *ResourceLimitsConfig) exceeded(usage api.ResourceUsage) []string {
+	var exceeded []string
+	if l.MaxMemory > 0 && usage.MemoryBytes > l.MaxMemory {
+		exceeded = append(exceeded, "max-memory")
+	}
+	if l.MaxCycleCPUSeconds > 0 && usage.CycleCPUSeconds > l.MaxCycleCPUSeconds {
+		exceeded = append(exceeded, "max-cycle-cpu-seconds")
+	}
+	if l.MaxGoroutines > 0 && usage.Goroutines > l.MaxGoroutines {
+		exceeded = append(exceeded, "max-goroutines")
+	}
+	return exceeded
+}
+
+func (l *ResourceLimitsConfig) onExceeded() string {
+	if l.OnExceeded == "" {
+		return onExceededReport
+	}
+	return l.OnExceeded
+}
+
+// processUsage is a reading of the cumulative resource usage of the process.
+type processUsage struct {
+	cpuSeconds  float64
+	memoryBytes uint64
+	goroutines  int
+}
+
+// usageMonitor samples the resource usage of the agent each cycle.
+type usageMonitor struct {
+	read func() processUsage
+	now  func() time.Time
+	// previousCPUSeconds is the CPU time of the previous sample.
+	previousCPUSeconds float64
+}
+
+func newUsageMonitor() *usageMonitor {
+	m := &usageMonitor{read: readProcessUsage, now: time.Now}
+	m.previousCPUSeconds = m.read().cpuSeconds
+	return m
+}
+
+// sample returns the usage since the previous sample.
+func (m *usageMonitor) sample() api.ResourceUsage {
+	usage := m.read()
+	cycleCPUSeconds := usage.cpuSeconds - m.previousCPUSeconds
+	m.previousCPUSeconds = usage.cpuSeconds
+
+	return api.ResourceUsage{
+		SampledAt:       api.Time{Time: m.now()},
+		CPUSeconds:      usage.cpuSeconds,
+		CycleCPUSeconds: cycleCPUSeconds,
+		MemoryBytes:     usage.memoryBytes,
+		Goroutines:      usage.goroutines,
+	}
+}
+
+// cpuMetrics are the runtime metrics that add up to the CPU time used by the
+// process, as estimated by the Go runtime.
+var cpuMetrics = []string{
+	"/cpu/classes/user:cpu-seconds",
+	"/cpu/classes/gc/total:cpu-seconds",
+	"/cpu/classes/scavenge/total:cpu-seconds",
+}
+
+// readProcessUsage reads the usage of the process from the Go runtime. The
+// resident memory is read from /proc where available, and otherwise
+// estimated as the memory the runtime has mapped and not released.
+func readProcessUsage() processUsage {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	for _, name := range cpuMetrics {
+		samples = append(samples, metrics.Sample{Name: name})
+	}
+	metrics.Read(samples)
+
+	var usage processUsage
+	for _, sample := range samples[2:] {
+		if sample.Value.Kind() == metrics.KindFloat64 {
+			usage.cpuSeconds += sample.Value.Float64()
+		}
+	}
+
+	if rss, ok := readRSS(); ok {
+		usage.memoryBytes = rss
+	} else if samples[0].Value.Kind() == metrics.KindUint64 && samples[1].Value.Kind() == metrics.KindUint64 {
+		usage.memoryBytes = samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	}
+
+	usage.goroutines = runtime.NumGoroutine()
+	return usage
+}
+
+// readRSS reads the resident memory of the process from /proc/self/statm.
+func readRSS() (uint64, bool) {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * uint64(os.Getpagesize()), true
+}
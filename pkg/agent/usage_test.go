@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestUsageMonitor(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	readings := []processUsage{
+		{cpuSeconds: 1.5},
+		{cpuSeconds: 4, memoryBytes: 100 << 20, goroutines: 40},
+		{cpuSeconds: 4.5, memoryBytes: 120 << 20, goroutines: 42},
+	}
+	monitor := &usageMonitor{
+		read: func() processUsage {
+			usage := readings[0]
+			readings = readings[1:]
+			return usage
+		},
+		now: func() time.Time { return now },
+	}
+	monitor.previousCPUSeconds = monitor.read().cpuSeconds
+
+	first := monitor.sample()
+	second := monitor.sample()
+
+	expected := []api.ResourceUsage{
+		{SampledAt: api.Time{Time: now}, CPUSeconds: 4, CycleCPUSeconds: 2.5, MemoryBytes: 100 << 20, Goroutines: 40},
+		{SampledAt: api.Time{Time: now}, CPUSeconds: 4.5, CycleCPUSeconds: 0.5, MemoryBytes: 120 << 20, Goroutines: 42},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, []api.ResourceUsage{first, second}); !equal {
+		t.Errorf("unexpected usage:\n%s", diff)
+	}
+}
+
+func TestResourceLimitsExceeded(t *testing.T) {
+	limits := ResourceLimitsConfig{MaxMemory: 100 << 20, MaxCycleCPUSeconds: 10, MaxGoroutines: 50}
+
+	tests := map[string]struct {
+		usage    api.ResourceUsage
+		expected []string
+	}{
+		"within limits": {
+			usage: api.ResourceUsage{MemoryBytes: 100 << 20, CycleCPUSeconds: 10, Goroutines: 50},
+		},
+		"memory": {
+			usage:    api.ResourceUsage{MemoryBytes: 101 << 20},
+			expected: []string{"max-memory"},
+		},
+		"all": {
+			usage:    api.ResourceUsage{MemoryBytes: 200 << 20, CycleCPUSeconds: 11, Goroutines: 51},
+			expected: []string{"max-memory", "max-cycle-cpu-seconds", "max-goroutines"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			if diff, equal := messagediff.PrettyDiff(tc.expected, limits.exceeded(tc.usage)); !equal {
+				t.Errorf("unexpected exceeded limits:\n%s", diff)
+			}
+		})
+	}
+
+	// a limit of zero is not enforced
+	if exceeded := (&ResourceLimitsConfig{}).exceeded(api.ResourceUsage{MemoryBytes: 1 << 30, Goroutines: 1000}); exceeded != nil {
+		t.Errorf("expected no exceeded limits, got %v", exceeded)
+	}
+}
+
+func TestResourceLimitsValidate(t *testing.T) {
+	for _, limits := range []ResourceLimitsConfig{{MaxCycleCPUSeconds: -1}, {MaxGoroutines: -1}, {OnExceeded: "panic"}} {
+		if err := limits.validate(); err == nil {
+			t.Errorf("expected an error for %+v", limits)
+		}
+	}
+	if err := (&ResourceLimitsConfig{MaxMemory: 1 << 30, OnExceeded: onExceededSkipCycle}).validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestReadProcessUsage(t *testing.T) {
+	usage := readProcessUsage()
+	if usage.memoryBytes == 0 {
+		t.Errorf("expected the memory to be read")
+	}
+	if usage.goroutines == 0 {
+		t.Errorf("expected the goroutines to be counted")
+	}
+}
@@ -0,0 +1,224 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateConfigPath is the config file checked by `agent validate`. It
+// defaults to the agent config file.
+var ValidateConfigPath string
+
+// configProblem is a mistake found in the agent config.
+type configProblem struct {
+	// Line is the line of the config file, or 0 if it isn't known.
+	Line    int
+	Message string
+}
+
+func (p configProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// Validate parses the agent config strictly and prints all the problems found
+// in it, rather than stopping at the first one. It exits with a non-zero
+// status if there are any.
+func Validate(cmd *cobra.Command, args []string) {
+	path := ValidateConfigPath
+	if path == "" {
+		path = ConfigFilePath
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to read config file: %s", err)
+	}
+
+	problems := validateConfig(data, VenafiCloudMode || ClientID != "")
+	printConfigProblems(os.Stdout, path, problems)
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printConfigProblems(out io.Writer, path string, problems []configProblem) {
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "%s is valid\n", path)
+		return
+	}
+	for _, problem := range problems {
+		if problem.Line == 0 {
+			fmt.Fprintf(out, "%s: %s\n", path, problem.Message)
+			continue
+		}
+		fmt.Fprintf(out, "%s:%d: %s\n", path, problem.Line, problem.Message)
+	}
+	fmt.Fprintf(out, "%d problem(s) found\n", len(problems))
+}
+
+// validateConfig returns the problems found in the config: syntax and type
+// errors, unknown fields, files that don't exist and the errors of the
+// validation done when the agent starts. The problems are sorted by line.
+func validateConfig(data []byte, isVenafiCloudMode bool) []configProblem {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []configProblem{syntaxProblem(err)}
+	}
+
+	var problems []configProblem
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		var typeErr *yaml.TypeError
+		switch {
+		case errors.Is(err, io.EOF):
+			return []configProblem{{Message: "the config is empty"}}
+		case errors.As(err, &typeErr):
+			// the decoder carries on after type errors
+			for _, msg := range typeErr.Errors {
+				problems = append(problems, typeProblem(msg))
+			}
+		default:
+			return []configProblem{syntaxProblem(err)}
+		}
+	}
+
+	problems = append(problems, fileReferenceProblems(&root)...)
+
+	config.setDefaults(isVenafiCloudMode)
+	if err := config.validate(isVenafiCloudMode); err != nil {
+		var merr *multierror.Error
+		if errors.As(err, &merr) {
+			for _, err := range merr.Errors {
+				problems = append(problems, configProblem{Message: err.Error()})
+			}
+		} else {
+			problems = append(problems, configProblem{Message: err.Error()})
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		// problems without a line number come last
+		if (problems[i].Line == 0) != (problems[j].Line == 0) {
+			return problems[j].Line == 0
+		}
+		return problems[i].Line < problems[j].Line
+	})
+	return problems
+}
+
+var (
+	lineRegexp          = regexp.MustCompile(`^line (\d+): (.*)$`)
+	unknownFieldRegexp  = regexp.MustCompile(`^field (\S+) not found in type .*$`)
+	alreadySetKeyRegexp = regexp.MustCompile(`^mapping key "(\S+)" already defined at line \d+$`)
+)
+
+// typeProblem splits the line number from an error of the YAML decoder, and
+// replaces the Go types in the messages about unknown fields, as they are
+// the unexported types the config is decoded into.
+func typeProblem(msg string) configProblem {
+	problem := configProblem{Message: msg}
+	if m := lineRegexp.FindStringSubmatch(msg); m != nil {
+		problem.Line, _ = strconv.Atoi(m[1])
+		problem.Message = m[2]
+	}
+	if m := unknownFieldRegexp.FindStringSubmatch(problem.Message); m != nil {
+		problem.Message = fmt.Sprintf("unknown field %q", m[1])
+	}
+	if m := alreadySetKeyRegexp.FindStringSubmatch(problem.Message); m != nil {
+		problem.Message = fmt.Sprintf("field %q is set more than once", m[1])
+	}
+	return problem
+}
+
+func syntaxProblem(err error) configProblem {
+	return typeProblem(strings.TrimPrefix(err.Error(), "yaml: "))
+}
+
+// fileReferenceProblems checks that the files the config refers to exist:
+// the input path, the attestation signing key, the keys of the config push
+// endpoint, the credentials of the mirror, and the kubeconfig and data paths
+// of the data gatherers.
+func fileReferenceProblems(root *yaml.Node) []configProblem {
+	if len(root.Content) == 0 {
+		return nil
+	}
+	doc := root.Content[0]
+
+	var problems []configProblem
+	check := func(field string, node *yaml.Node) {
+		if node == nil || node.Kind != yaml.ScalarNode || node.Value == "" {
+			return
+		}
+		if _, err := os.Stat(node.Value); err != nil {
+			problems = append(problems, configProblem{
+				Line:    node.Line,
+				Message: fmt.Sprintf("%s %q cannot be read: %s", field, node.Value, statError(err)),
+			})
+		}
+	}
+
+	check("input-path", mappingValue(doc, "input-path"))
+	check("attestation.signing-key-path", mappingValue(mappingValue(doc, "attestation"), "signing-key-path"))
+	check("output-encryption.recipients-file", mappingValue(mappingValue(doc, "output-encryption"), "recipients-file"))
+	for _, field := range []string{"public-key-path", "tls-cert-path", "tls-key-path"} {
+		check("config-push."+field, mappingValue(mappingValue(doc, "config-push"), field))
+	}
+	for _, field := range []string{"private-key-path", "credentials-file"} {
+		check("mirror."+field, mappingValue(mappingValue(doc, "mirror"), field))
+	}
+	for _, field := range []string{"private-key-path", "hmac-key-path"} {
+		check("payload-signing."+field, mappingValue(mappingValue(doc, "payload-signing"), field))
+	}
+
+	if gatherers := mappingValue(doc, "data-gatherers"); gatherers != nil && gatherers.Kind == yaml.SequenceNode {
+		for i, gatherer := range gatherers.Content {
+			check(fmt.Sprintf("data-gatherers[%d].data-path", i), mappingValue(gatherer, "data-path"))
+			config := mappingValue(gatherer, "config")
+			check(fmt.Sprintf("data-gatherers[%d].config.kubeconfig", i), mappingValue(config, "kubeconfig"))
+			check(fmt.Sprintf("data-gatherers[%d].config.data-path", i), mappingValue(config, "data-path"))
+		}
+	}
+
+	return problems
+}
+
+// mappingValue returns the value of a key of a mapping node, or nil.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func statError(err error) string {
+	if errors.Is(err, os.ErrNotExist) {
+		return "no such file"
+	}
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestValidateConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte("{}"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := map[string]struct {
+		config   string
+		expected []configProblem
+	}{
+		"valid": {
+			config: `
+organization_id: my-org
+cluster_id: my-cluster
+period: 1h
+data-gatherers:
+- kind: k8s-dynamic
+  name: k8s/pods
+  config:
+    kubeconfig: ` + kubeconfig + `
+    resource-type:
+      version: v1
+      resource: pods
+`,
+		},
+		"all problems at once": {
+			config: `
+organization_id: my-org
+period: forever
+unknown: true
+data-gatherers:
+- kind: k8s-dynamic
+  name: k8s/pods
+  config:
+    kubeconfig: /does/not/exist
+    resource-type:
+      version: v1
+      resource: pods
+    include-namespace: [default]
+- kind: foo
+  name: foo
+- kind: k8s-ingress-tls-policy
+  name: k8s/ingress-tls-policy
+  config:
+    config-maps:
+    - controller: traefik
+      namespace: traefik
+      name: traefik
+`,
+			expected: []configProblem{
+				{Line: 3, Message: "cannot unmarshal !!str `forever` into time.Duration"},
+				{Line: 4, Message: `unknown field "unknown"`},
+				{Line: 9, Message: `data-gatherers[0].config.kubeconfig "/does/not/exist" cannot be read: no such file`},
+				{Line: 13, Message: `unknown field "include-namespace"`},
+				{Line: 14, Message: `cannot parse data-gatherer configuration, kind "foo" is not supported`},
+				{Message: "cluster_id is required"},
+				{Message: `datagatherer "k8s/ingress-tls-policy": config-maps[0]: unsupported controller "traefik"`},
+			},
+		},
+		"syntax error": {
+			config: `
+organization_id: my-org
+data-gatherers:
+- kind: k8s-dynamic
+	name: k8s/pods
+`,
+			expected: []configProblem{
+				{Line: 4, Message: "found a tab character that violates indentation"},
+			},
+		},
+		"empty": {
+			config:   "",
+			expected: []configProblem{{Message: "the config is empty"}},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			problems := validateConfig([]byte(tc.config), false)
+			if diff, equal := messagediff.PrettyDiff(tc.expected, problems); !equal {
+				t.Errorf("unexpected problems:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPrintConfigProblems(t *testing.T) {
+	var out bytes.Buffer
+	printConfigProblems(&out, "agent.yaml", []configProblem{
+		{Line: 4, Message: `unknown field "unknown"`},
+		{Message: "cluster_id is required"},
+	})
+	expected := `agent.yaml:4: unknown field "unknown"
+agent.yaml: cluster_id is required
+2 problem(s) found
+`
+	if out.String() != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+// defaultWorkloadIdentityTokenPath is where the token of the agent's
+// service account is mounted by default.
+const defaultWorkloadIdentityTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// WorkloadIdentityConfig configures the agent to authenticate to the backend
+// by exchanging a workload identity token for an access token with OAuth2
+// token exchange, rather than with long-lived credentials.
+type WorkloadIdentityConfig struct {
+	// TokenURL is the token endpoint of the authorization server.
+	TokenURL string `yaml:"token-url"`
+	// TokenPath is the file holding the workload identity token: a
+	// projected service account token, or the token file of a cloud
+	// workload identity such as AWS_WEB_IDENTITY_TOKEN_FILE. Defaults to the
+	// token of the agent's service account.
+	TokenPath string `yaml:"token-path,omitempty"`
+	// TokenType is the token type URI of the token. Defaults to
+	// urn:ietf:params:oauth:token-type:jwt.
+	TokenType string `yaml:"token-type,omitempty"`
+	// Audience, Scope and ClientID are sent with the token exchange request
+	// if set.
+	Audience string `yaml:"audience,omitempty"`
+	Scope    string `yaml:"scope,omitempty"`
+	ClientID string `yaml:"client-id,omitempty"`
+}
+
+func (c *WorkloadIdentityConfig) validate(prefix string) error {
+	if c.TokenURL == "" {
+		return fmt.Errorf("%s.token-url is required", prefix)
+	}
+	return nil
+}
+
+func (c *WorkloadIdentityConfig) tokenExchangeConfig() client.TokenExchangeConfig {
+	tokenPath := c.TokenPath
+	if tokenPath == "" {
+		tokenPath = defaultWorkloadIdentityTokenPath
+	}
+	return client.TokenExchangeConfig{
+		TokenURL:         c.TokenURL,
+		SubjectTokenPath: tokenPath,
+		SubjectTokenType: c.TokenType,
+		Audience:         c.Audience,
+		Scope:            c.Scope,
+		ClientID:         c.ClientID,
+	}
+}
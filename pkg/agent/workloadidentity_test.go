@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/client"
+)
+
+func TestWorkloadIdentityClient(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("sa-token-1\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exchanges := 0
+	rejectNext := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/oauth/token":
+			_ = r.ParseForm()
+			if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:token-exchange" ||
+				r.Form.Get("subject_token_type") != client.JWTTokenType ||
+				r.Form.Get("audience") != "https://preflight.jetstack.io/api/v1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			exchanges++
+			fmt.Fprintf(w, `{"access_token": "access-%s", "expires_in": 3600}`, r.Form.Get("subject_token"))
+		case "/api/v1/org/example/datareadings/example-cluster":
+			if rejectNext {
+				rejectNext = false
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer access-sa-token-"+fmt.Sprint(exchanges) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	workloadIdentity := &WorkloadIdentityConfig{
+		TokenURL:  server.URL + "/oauth/token",
+		TokenPath: tokenPath,
+		Audience:  "https://preflight.jetstack.io/api/v1",
+	}
+	c, err := createClient(backendCredentials{workloadIdentity: workloadIdentity}, Config{}, &api.AgentMetadata{}, server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	post := func() error {
+		return c.PostDataReadings("example", "example-cluster", []*api.DataReading{{DataGatherer: "dummy"}})
+	}
+	for i := 0; i < 2; i++ {
+		if err := post(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if exchanges != 1 {
+		t.Errorf("expected the access token to be reused, got %d exchanges", exchanges)
+	}
+
+	// a rejected access token is exchanged again, with the rotated token
+	if err := os.WriteFile(tokenPath, []byte("sa-token-2\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rejectNext = true
+	if err := post(); err == nil {
+		t.Fatalf("expected the rejected request to fail")
+	}
+	if err := post(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if exchanges != 2 {
+		t.Errorf("expected the token to be exchanged again, got %d exchanges", exchanges)
+	}
+}
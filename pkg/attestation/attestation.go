@@ -0,0 +1,288 @@
+// Package attestation generates in-toto attestations recording the provenance
+// of data readings, in the SLSA provenance format, so that consumers of
+// archived readings can check where and how they were gathered.
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/version"
+)
+
+const (
+	// StatementType is the type of in-toto statements.
+	StatementType = "https://in-toto.io/Statement/v1"
+	// PredicateType is the type of the SLSA provenance predicate.
+	PredicateType = "https://slsa.dev/provenance/v1"
+	// BuildType identifies the gathering of a data reading by the agent.
+	BuildType = "https://github.com/jetstack/jetstack-secure/data-reading/v1"
+	// BuilderID identifies the agent as the builder of data readings.
+	BuilderID = "https://github.com/jetstack/jetstack-secure/agent"
+	// PayloadType is the DSSE payload type of in-toto statements.
+	PayloadType = "application/vnd.in-toto+json"
+)
+
+// Statement is an in-toto statement about a data reading.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is the data reading the statement is about.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is a SLSA provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+// BuildDefinition describes how the data reading was gathered.
+type BuildDefinition struct {
+	BuildType          string                 `json:"buildType"`
+	ExternalParameters map[string]interface{} `json:"externalParameters"`
+	InternalParameters map[string]interface{} `json:"internalParameters,omitempty"`
+}
+
+// RunDetails describes the agent that gathered the data reading.
+type RunDetails struct {
+	Builder  Builder  `json:"builder"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// Builder identifies the agent.
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+// Metadata holds the timestamps of the gathering.
+type Metadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// Options are the details of the gathering that are not part of the reading.
+type Options struct {
+	// Host is the name of the host or pod running the agent.
+	Host string
+	// ConfigDigest is the SHA-256 digest of the data gatherer configuration.
+	ConfigDigest string
+	// StartedOn is the start of the gathering cycle.
+	StartedOn time.Time
+}
+
+// Digest returns the hex encoded SHA-256 digest of the JSON encoding of v.
+func Digest(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// New creates a statement about the provenance of a data reading. The
+// subject digest is the SHA-256 digest of the JSON encoding of the reading.
+func New(reading *api.DataReading, opts Options) (*Statement, error) {
+	digest, err := Digest(reading)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the digest of reading %q: %w", reading.DataGatherer, err)
+	}
+
+	externalParameters := map[string]interface{}{
+		"dataGatherer":  reading.DataGatherer,
+		"schemaVersion": reading.SchemaVersion,
+	}
+	if reading.ClusterID != "" {
+		externalParameters["clusterID"] = reading.ClusterID
+	}
+	if opts.ConfigDigest != "" {
+		externalParameters["configDigest"] = map[string]string{"sha256": opts.ConfigDigest}
+	}
+
+	var internalParameters map[string]interface{}
+	if opts.Host != "" {
+		internalParameters = map[string]interface{}{"host": opts.Host}
+	}
+
+	metadata := Metadata{InvocationID: fmt.Sprintf("%s/%d", reading.DataGatherer, reading.Timestamp.Unix())}
+	if !opts.StartedOn.IsZero() {
+		startedOn := opts.StartedOn.UTC()
+		metadata.StartedOn = &startedOn
+	}
+	if !reading.Timestamp.IsZero() {
+		finishedOn := reading.Timestamp.UTC()
+		metadata.FinishedOn = &finishedOn
+	}
+
+	return &Statement{
+		Type: StatementType,
+		Subject: []Subject{
+			{Name: reading.DataGatherer, Digest: map[string]string{"sha256": digest}},
+		},
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType:          BuildType,
+				ExternalParameters: externalParameters,
+				InternalParameters: internalParameters,
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{
+					ID: BuilderID,
+					Version: map[string]string{
+						"preflight": version.PreflightVersion,
+						"commit":    version.Commit,
+					},
+				},
+				Metadata: metadata,
+			},
+		},
+	}, nil
+}
+
+// Envelope is a DSSE envelope holding a statement and its signatures.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+// Signature is a signature of an envelope.
+type Signature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   []byte `json:"sig"`
+}
+
+// Signer signs envelopes with an ECDSA or Ed25519 private key.
+type Signer struct {
+	key   crypto.Signer
+	keyID string
+}
+
+// LoadSigner reads a PEM encoded PKCS #8 ECDSA or Ed25519 private key.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode signing key %q: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %q: %w", path, err)
+	}
+
+	return NewSigner(key)
+}
+
+// NewSigner creates a signer from an ECDSA or Ed25519 private key. The key ID
+// is the SHA-256 digest of the PKIX encoding of the public key.
+func NewSigner(key interface{}) (*Signer, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	switch signer.(type) {
+	case *ecdsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T, only ECDSA and Ed25519 keys are supported", key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+
+	return &Signer{key: signer, keyID: hex.EncodeToString(sum[:])}, nil
+}
+
+// Envelop wraps a statement in an envelope, signed if signer is not nil.
+func Envelop(statement *Statement, signer *Signer) (*Envelope, error) {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal statement: %w", err)
+	}
+
+	envelope := &Envelope{PayloadType: PayloadType, Payload: payload, Signatures: []Signature{}}
+	if signer == nil {
+		return envelope, nil
+	}
+
+	message := pae(PayloadType, payload)
+	var sig []byte
+	switch key := signer.key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, message)
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(message)
+		if sig, err = ecdsa.SignASN1(rand.Reader, key, digest[:]); err != nil {
+			return nil, fmt.Errorf("failed to sign statement: %w", err)
+		}
+	}
+	envelope.Signatures = append(envelope.Signatures, Signature{KeyID: signer.keyID, Sig: sig})
+
+	return envelope, nil
+}
+
+// Verify checks that the envelope has a valid signature by the public key and
+// returns its statement.
+func Verify(envelope *Envelope, publicKey crypto.PublicKey) (*Statement, error) {
+	message := pae(envelope.PayloadType, envelope.Payload)
+	digest := sha256.Sum256(message)
+
+	verified := false
+	for _, signature := range envelope.Signatures {
+		switch key := publicKey.(type) {
+		case ed25519.PublicKey:
+			verified = ed25519.Verify(key, message, signature.Sig)
+		case *ecdsa.PublicKey:
+			verified = ecdsa.VerifyASN1(key, digest[:], signature.Sig)
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", publicKey)
+		}
+		if verified {
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("no valid signature found")
+	}
+
+	var statement Statement
+	if err := json.Unmarshal(envelope.Payload, &statement); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal statement: %w", err)
+	}
+	return &statement, nil
+}
+
+// pae is the DSSE pre-authentication encoding of the payload.
+func pae(payloadType string, payload []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	b.Write(payload)
+	return b.Bytes()
+}
@@ -0,0 +1,141 @@
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func testReading() *api.DataReading {
+	return &api.DataReading{
+		ClusterID:     "my-cluster",
+		DataGatherer:  "k8s/pods",
+		Timestamp:     api.Time{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Data:          map[string]interface{}{"items": []interface{}{}},
+		SchemaVersion: "v2.0.0",
+	}
+}
+
+func TestNew(t *testing.T) {
+	reading := testReading()
+	startedOn := time.Date(2024, 1, 2, 3, 4, 0, 0, time.UTC)
+	statement, err := New(reading, Options{Host: "agent-0", ConfigDigest: "abc", StartedOn: startedOn})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	digest, err := Digest(reading)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	finishedOn := reading.Timestamp.Time
+	expected := Provenance{
+		BuildDefinition: BuildDefinition{
+			BuildType: BuildType,
+			ExternalParameters: map[string]interface{}{
+				"dataGatherer":  "k8s/pods",
+				"schemaVersion": "v2.0.0",
+				"clusterID":     "my-cluster",
+				"configDigest":  map[string]string{"sha256": "abc"},
+			},
+			InternalParameters: map[string]interface{}{"host": "agent-0"},
+		},
+		RunDetails: RunDetails{
+			Builder: Builder{ID: BuilderID, Version: statement.Predicate.RunDetails.Builder.Version},
+			Metadata: Metadata{
+				InvocationID: "k8s/pods/1704164645",
+				StartedOn:    &startedOn,
+				FinishedOn:   &finishedOn,
+			},
+		},
+	}
+
+	if diff, equal := messagediff.PrettyDiff([]Subject{{Name: "k8s/pods", Digest: map[string]string{"sha256": digest}}}, statement.Subject); !equal {
+		t.Errorf("unexpected subject:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, statement.Predicate); !equal {
+		t.Errorf("unexpected predicate:\n%s", diff)
+	}
+}
+
+func TestEnvelopAndVerify(t *testing.T) {
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for name, key := range map[string]interface{}{"ed25519": ed25519Key, "ecdsa": ecdsaKey} {
+		t.Run(name, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			path := filepath.Join(t.TempDir(), "key.pem")
+			if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			signer, err := LoadSigner(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			statement, err := New(testReading(), Options{})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			envelope, err := Envelop(statement, signer)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(envelope.Signatures) != 1 || envelope.Signatures[0].KeyID == "" {
+				t.Fatalf("expected one signature with a key ID, got %+v", envelope.Signatures)
+			}
+
+			verified, err := Verify(envelope, signer.key.Public())
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if diff, equal := messagediff.PrettyDiff(statement.Subject, verified.Subject); !equal {
+				t.Errorf("unexpected subject:\n%s", diff)
+			}
+
+			// a tampered payload fails verification
+			envelope.Payload = append(envelope.Payload, ' ')
+			if _, err := Verify(envelope, signer.key.Public()); err == nil {
+				t.Errorf("expected tampered envelope to fail verification")
+			}
+		})
+	}
+}
+
+func TestEnvelopUnsigned(t *testing.T) {
+	statement, err := New(testReading(), Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	envelope, err := Envelop(statement, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(envelope.Signatures) != 0 {
+		t.Errorf("expected no signatures, got %d", len(envelope.Signatures))
+	}
+	if _, err := Verify(envelope, ed25519.PublicKey(make([]byte, ed25519.PublicKeySize))); err == nil {
+		t.Errorf("expected unsigned envelope to fail verification")
+	}
+}
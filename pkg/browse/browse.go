@@ -0,0 +1,192 @@
+// Package browse implements an interactive terminal browser for archived data
+// readings, to review what the agent sends before it leaves the cluster.
+package browse
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// clusterScope is the namespace shown for cluster scoped resources.
+const clusterScope = "(cluster)"
+
+// node is an entry in the tree of a set of readings. Readings are organised
+// by data gatherer, namespace, kind and object. Leaf nodes hold the data that
+// is printed when they are selected.
+type node struct {
+	name     string
+	parent   *node
+	children []*node
+	data     interface{}
+}
+
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &node{name: name, parent: n}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *node) path() string {
+	if n.parent == nil {
+		return "/"
+	}
+	var names []string
+	for c := n; c.parent != nil; c = c.parent {
+		names = append([]string{c.name}, names...)
+	}
+	return "/" + strings.Join(names, "/")
+}
+
+// leaves returns the number of objects below the node.
+func (n *node) leaves() int {
+	if len(n.children) == 0 {
+		return 1
+	}
+	count := 0
+	for _, c := range n.children {
+		count += c.leaves()
+	}
+	return count
+}
+
+func (n *node) sort() {
+	sort.Slice(n.children, func(i, j int) bool {
+		return n.children[i].name < n.children[j].name
+	})
+	for _, c := range n.children {
+		c.sort()
+	}
+}
+
+// newTree builds the tree of the readings. Readings with a list of `items`,
+// as produced by the k8s-dynamic data gatherer, are split by namespace, kind
+// and object, other readings are a single leaf.
+func newTree(readings []*api.DataReading) *node {
+	root := &node{}
+	for _, reading := range readings {
+		gatherer := root.child(reading.DataGatherer)
+
+		items, ok := readingItems(reading.Data)
+		if !ok {
+			gatherer.data = reading.Data
+			continue
+		}
+		for _, item := range items {
+			resource, _ := item["resource"].(map[string]interface{})
+			kind, _ := resource["kind"].(string)
+			metadata, _ := resource["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			namespace, _ := metadata["namespace"].(string)
+			if namespace == "" {
+				namespace = clusterScope
+			}
+			gatherer.child(namespace).child(kind).child(name).data = item
+		}
+	}
+	root.sort()
+
+	return root
+}
+
+func readingItems(data interface{}) ([]map[string]interface{}, bool) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	list, ok := m["items"].([]interface{})
+	if !ok {
+		return nil, false
+	}
+	var items []map[string]interface{}
+	for _, i := range list {
+		item, ok := i.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		items = append(items, item)
+	}
+	return items, true
+}
+
+// Browse runs the browser, reading commands from in and writing to out until
+// the input ends or the user quits.
+func Browse(in io.Reader, out io.Writer, readings []*api.DataReading) error {
+	current := newTree(readings)
+	scanner := bufio.NewScanner(in)
+
+	printHelp(out)
+	list(out, current)
+	for {
+		fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+
+		switch command := strings.TrimSpace(scanner.Text()); command {
+		case "":
+			list(out, current)
+		case "q", "quit", "exit":
+			return nil
+		case "?", "h", "help":
+			printHelp(out)
+		case "..", "u", "up":
+			if current.parent != nil {
+				current = current.parent
+			}
+			list(out, current)
+		default:
+			index, err := strconv.Atoi(command)
+			if err != nil || index < 1 || index > len(current.children) {
+				fmt.Fprintf(out, "unknown entry %q, type ? for help\n", command)
+				continue
+			}
+			selected := current.children[index-1]
+			if len(selected.children) == 0 {
+				if err := printData(out, selected); err != nil {
+					return err
+				}
+				continue
+			}
+			current = selected
+			list(out, current)
+		}
+	}
+}
+
+func printHelp(out io.Writer) {
+	fmt.Fprintln(out, "enter the number of an entry to open it, .. to go up, q to quit")
+}
+
+func list(out io.Writer, n *node) {
+	fmt.Fprintf(out, "%s (%d objects)\n", n.path(), n.leaves())
+	width := len(strconv.Itoa(len(n.children)))
+	for i, c := range n.children {
+		if len(c.children) == 0 {
+			fmt.Fprintf(out, "  %*d) %s\n", width, i+1, c.name)
+		} else {
+			fmt.Fprintf(out, "  %*d) %s/ (%d)\n", width, i+1, c.name, c.leaves())
+		}
+	}
+}
+
+func printData(out io.Writer, n *node) error {
+	b, err := json.MarshalIndent(n.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", n.path(), err)
+	}
+	fmt.Fprintf(out, "%s\n%s\n", n.path(), b)
+	return nil
+}
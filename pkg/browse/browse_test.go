@@ -0,0 +1,44 @@
+package browse
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestBrowse(t *testing.T) {
+	var readings []*api.DataReading
+	err := json.Unmarshal([]byte(`[
+		{"data-gatherer": "k8s/pods", "data": {"items": [
+			{"resource": {"kind": "Pod", "metadata": {"name": "b", "namespace": "default"}}},
+			{"resource": {"kind": "Pod", "metadata": {"name": "a", "namespace": "default"}}},
+			{"resource": {"kind": "Pod", "metadata": {"name": "c", "namespace": "other"}}}
+		]}},
+		{"data-gatherer": "k8s-discovery", "data": {"server_version": {"gitVersion": "v1.27.0"}}}
+	]`), &readings)
+	if err != nil {
+		t.Fatalf("failed to parse readings: %s", err)
+	}
+
+	var out bytes.Buffer
+	// open k8s/pods, default, Pod and print a, then go up and quit
+	in := strings.NewReader("2\n1\n1\n1\n..\nq\n")
+	if err := Browse(in, &out, readings); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, expected := range []string{
+		"/ (4 objects)\n  1) k8s-discovery\n  2) k8s/pods/ (3)\n",
+		"/k8s/pods (3 objects)\n  1) default/ (2)\n  2) other/ (1)\n",
+		"/k8s/pods/default/Pod (2 objects)\n  1) a\n  2) b\n",
+		"/k8s/pods/default/Pod/a\n{\n  \"resource\": {",
+		"> /k8s/pods/default (2 objects)",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
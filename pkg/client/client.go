@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -26,6 +27,11 @@ type (
 		Post(path string, body io.Reader) (*http.Response, error)
 	}
 
+	// The ContextClient interface is implemented by the clients whose uploads of data readings can be cancelled.
+	ContextClient interface {
+		PostDataReadingsContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error
+	}
+
 	// The Credentials interface describes methods for credential types to implement for verification.
 	Credentials interface {
 		IsClientSet() bool
@@ -33,6 +39,14 @@ type (
 	}
 )
 
+// The headers set on the messages of the backends publishing the readings to
+// a message broker rather than uploading them.
+const (
+	OrganizationIDHeader = "Preflight-Organization-Id"
+	ClusterIDHeader      = "Preflight-Cluster-Id"
+	DataGathererHeader   = "Preflight-Data-Gatherer"
+)
+
 func fullURL(baseURL, path string) string {
 	base := baseURL
 	for strings.HasSuffix(base, "/") {
@@ -43,3 +57,16 @@ func fullURL(baseURL, path string) string {
 	}
 	return fmt.Sprintf("%s/%s", base, path)
 }
+
+// nameToken returns name as a single token of a NATS subject or a Kafka topic
+// name, replacing the characters other than letters, digits, - and _ with _,
+// e.g. k8s/pods becomes k8s_pods.
+func nameToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -2,12 +2,13 @@ package client
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/jetstack/preflight/api"
@@ -17,22 +18,57 @@ type (
 	// The APITokenClient type is a Client implementation used to upload data readings to the Jetstack Secure platform
 	// using API tokens as its authentication method.
 	APITokenClient struct {
-		apiToken      string
 		baseURL       string
 		agentMetadata *api.AgentMetadata
 		client        *http.Client
+
+		mu sync.Mutex
+		// tokens are the current API token followed by the next one, if any.
+		tokens []APITokenSource
+		// current is the index of the token in use.
+		current int
+	}
+
+	// APITokenSource provides the API token of an APITokenClient.
+	APITokenSource interface {
+		// Token returns the API token.
+		Token() (string, error)
+		// Reload is called when the backend rejects the token, so that the
+		// next call to Token loads it again in case it was rotated.
+		Reload()
 	}
+
+	// StaticAPIToken is an APITokenSource for a token that never changes.
+	StaticAPIToken string
 )
 
+// Token returns the token.
+func (t StaticAPIToken) Token() (string, error) {
+	return string(t), nil
+}
+
+// Reload does nothing as the token can't change.
+func (t StaticAPIToken) Reload() {}
+
 // NewAPITokenClient returns a new instance of the APITokenClient type that will perform HTTP requests using
 // the provided API token for authentication.
 func NewAPITokenClient(agentMetadata *api.AgentMetadata, apiToken, baseURL string) (*APITokenClient, error) {
+	return NewAPITokenSourceClient(agentMetadata, baseURL, StaticAPIToken(apiToken))
+}
+
+// NewAPITokenSourceClient returns a new instance of the APITokenClient type that reads the API token from tokens
+// before each request, for tokens that can change while the agent runs. The first token is the current one. If the
+// backend rejects it, the following token, e.g. the next token of a rotation, is used instead.
+func NewAPITokenSourceClient(agentMetadata *api.AgentMetadata, baseURL string, tokens ...APITokenSource) (*APITokenClient, error) {
 	if baseURL == "" {
 		return nil, fmt.Errorf("cannot create APITokenClient: baseURL cannot be empty")
 	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot create APITokenClient: no API token")
+	}
 
 	return &APITokenClient{
-		apiToken:      apiToken,
+		tokens:        tokens,
 		agentMetadata: agentMetadata,
 		baseURL:       baseURL,
 		client:        &http.Client{Timeout: time.Minute},
@@ -48,17 +84,15 @@ func (c *APITokenClient) PostDataReadingsWithOptions(readings []*api.DataReading
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *APITokenClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
-	payload := api.DataReadingsPost{
-		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
-		DataReadings:   readings,
-	}
-	data, err := json.Marshal(payload)
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
 	if err != nil {
 		return err
 	}
-
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	// the payload is encoded again if the request is retried, rather than
+	// being held in memory
+	res, err := c.postWithRetries(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), func() io.ReadCloser {
+		return newDataReadingsPostReader(payload)
+	})
 	if err != nil {
 		return err
 	}
@@ -77,15 +111,88 @@ func (c *APITokenClient) PostDataReadings(orgID, clusterID string, readings []*a
 	return nil
 }
 
-// Post performs an HTTP POST request.
+// Post performs an HTTP POST request. If the backend rejects the API token, it
+// is reloaded and the request retried in case the token was rotated, then the
+// next token is tried, so that rotating the token doesn't interrupt uploads.
+// A body that is a ReopenableReader is reopened to retry the request, the
+// others are held in memory.
 func (c *APITokenClient) Post(path string, body io.Reader) (*http.Response, error) {
+	if reopenable, ok := body.(ReopenableReader); ok {
+		attempts := 0
+		return c.postWithRetries(path, func() io.ReadCloser {
+			if attempts++; attempts > 1 {
+				reopenable = reopenable.Reopen()
+			}
+			return reopenable
+		})
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.postWithRetries(path, func() io.ReadCloser {
+		return io.NopCloser(bytes.NewReader(data))
+	})
+}
+
+// postWithRetries is Post with a body that is created again for each
+// attempt.
+func (c *APITokenClient) postWithRetries(path string, newBody func() io.ReadCloser) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var res *http.Response
+	for i := range c.tokens {
+		n := (c.current + i) % len(c.tokens)
+		source := c.tokens[n]
+
+		token, err := source.Token()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API token: %w", err)
+		}
+		res, err = c.post(path, newBody(), token)
+		if err != nil || res.StatusCode != http.StatusUnauthorized {
+			c.use(n)
+			return res, err
+		}
+
+		source.Reload()
+		if reloaded, err := source.Token(); err == nil && reloaded != token {
+			res.Body.Close()
+			res, err = c.post(path, newBody(), reloaded)
+			if err != nil || res.StatusCode != http.StatusUnauthorized {
+				c.use(n)
+				return res, err
+			}
+		}
+
+		if i < len(c.tokens)-1 {
+			res.Body.Close()
+		}
+	}
+
+	return res, nil
+}
+
+// use makes the nth token the current one.
+func (c *APITokenClient) use(n int) {
+	if n != c.current {
+		log.Printf("the API token was rejected, using the next API token")
+		c.current = n
+	}
+}
+
+func (c *APITokenClient) post(path string, body io.ReadCloser, apiToken string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), body)
 	if err != nil {
+		body.Close()
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiToken))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiToken))
 
 	return c.client.Do(req)
 }
@@ -0,0 +1,252 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/jetstack/preflight/api"
+	uploadv1 "github.com/jetstack/preflight/api/upload/v1"
+)
+
+type (
+	// The GRPCClient type is a Client implementation used to upload data readings to the Jetstack Secure platform
+	// over gRPC, streaming one message per reading rather than a single JSON document, optionally authenticating
+	// with API tokens.
+	GRPCClient struct {
+		agentMetadata *api.AgentMetadata
+		upload        uploadv1.UploadServiceClient
+		timeout       time.Duration
+
+		mu sync.Mutex
+		// tokens are the current API token followed by the next one, if any.
+		tokens []APITokenSource
+		// current is the index of the token in use.
+		current int
+	}
+
+	// GRPCOptions are the options of a GRPCClient.
+	GRPCOptions struct {
+		// Insecure disables TLS.
+		Insecure bool
+		// Timeout is the deadline of each upload.
+		Timeout time.Duration
+		// Tokens are the API tokens, the current one first. No token is
+		// sent if there are none.
+		Tokens []APITokenSource
+	}
+)
+
+// NewGRPCClient returns a new instance of the GRPCClient type that uploads the data readings to the gRPC endpoint
+// at target, a host and port. The connection is established on the first upload.
+func NewGRPCClient(agentMetadata *api.AgentMetadata, target string, opts GRPCOptions) (*GRPCClient, error) {
+	if target == "" {
+		return nil, fmt.Errorf("cannot create GRPCClient: target cannot be empty")
+	}
+
+	transportCredentials := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if opts.Insecure {
+		transportCredentials = insecure.NewCredentials()
+	}
+	conn, err := grpc.Dial(target,
+		grpc.WithTransportCredentials(transportCredentials),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create GRPCClient: %w", err)
+	}
+
+	c := &GRPCClient{
+		agentMetadata: agentMetadata,
+		upload:        uploadv1.NewUploadServiceClient(conn),
+		timeout:       opts.Timeout,
+		tokens:        opts.Tokens,
+	}
+	if c.timeout == 0 {
+		c.timeout = defaultRequestTimeout
+	}
+	return c, nil
+}
+
+// PostDataReadingsWithOptions uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
+// viewing in the user-interface.
+func (c *GRPCClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
+	return c.PostDataReadings(opts.OrgID, opts.ClusterID, readings)
+}
+
+// PostDataReadings streams the slice of api.DataReading to the Jetstack Secure backend, see
+// PostDataReadingsContext.
+func (c *GRPCClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	return c.PostDataReadingsContext(context.Background(), orgID, clusterID, readings)
+}
+
+// PostDataReadingsContext streams the slice of api.DataReading to the Jetstack Secure backend, until ctx is done. A
+// failed upload is not retried, that is up to the caller.
+func (c *GRPCClient) PostDataReadingsContext(ctx context.Context, orgID, clusterID string, readings []*api.DataReading) error {
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
+	if err != nil {
+		return err
+	}
+	readings = payload.DataReadings
+	agentMetadata, err := json.Marshal(payload.AgentMetadata)
+	if err != nil {
+		return err
+	}
+	header := &uploadv1.UploadHeader{
+		OrganizationId: orgID,
+		ClusterId:      clusterID,
+		AgentMetadata:  agentMetadata,
+		DataGatherTime: timestamp(payload.DataGatherTime),
+		SchemaVersion:  payload.SchemaVersion,
+	}
+
+	return c.uploadWithTokens(ctx, header, readings)
+}
+
+// Post is not supported, the gRPC backend only uploads data readings.
+func (c *GRPCClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return nil, fmt.Errorf("POST %s is not supported by the grpc backend", path)
+}
+
+// uploadWithTokens uploads the readings with the current API token. If the
+// backend rejects it, it is reloaded and the upload retried in case the token
+// was rotated, then the next token is tried, like the APITokenClient does.
+func (c *GRPCClient) uploadWithTokens(ctx context.Context, header *uploadv1.UploadHeader, readings []*api.DataReading) error {
+	if len(c.tokens) == 0 {
+		return c.uploadReadings(ctx, header, readings, "")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for i := range c.tokens {
+		n := (c.current + i) % len(c.tokens)
+		source := c.tokens[n]
+
+		token, tokenErr := source.Token()
+		if tokenErr != nil {
+			return fmt.Errorf("failed to load API token: %w", tokenErr)
+		}
+		err = c.uploadReadings(ctx, header, readings, token)
+		if status.Code(err) != codes.Unauthenticated {
+			c.use(n)
+			return err
+		}
+
+		source.Reload()
+		if reloaded, tokenErr := source.Token(); tokenErr == nil && reloaded != token {
+			err = c.uploadReadings(ctx, header, readings, reloaded)
+			if status.Code(err) != codes.Unauthenticated {
+				c.use(n)
+				return err
+			}
+		}
+	}
+	return err
+}
+
+// use makes the nth token the current one.
+func (c *GRPCClient) use(n int) {
+	if n != c.current {
+		log.Printf("the API token was rejected, using the next API token")
+		c.current = n
+	}
+}
+
+// uploadReadings streams the header and the readings within the deadline.
+// The readings are encoded one at a time as they are sent.
+func (c *GRPCClient) uploadReadings(ctx context.Context, header *uploadv1.UploadHeader, readings []*api.DataReading, token string) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+	}
+
+	stream, err := c.upload.UploadDataReadings(ctx)
+	if err != nil {
+		return err
+	}
+	send := func(request *uploadv1.UploadDataReadingsRequest) error {
+		err := stream.Send(request)
+		if err == io.EOF {
+			// the server ended the stream, its status is returned by
+			// CloseAndRecv
+			_, err = stream.CloseAndRecv()
+		}
+		return err
+	}
+
+	if err := send(&uploadv1.UploadDataReadingsRequest{Payload: &uploadv1.UploadDataReadingsRequest_Header{Header: header}}); err != nil {
+		return err
+	}
+	for _, reading := range readings {
+		message, err := dataReadingMessage(reading)
+		if err != nil {
+			return err
+		}
+		if err := send(&uploadv1.UploadDataReadingsRequest{Payload: &uploadv1.UploadDataReadingsRequest_Reading{Reading: message}}); err != nil {
+			return err
+		}
+	}
+	response, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if response.Readings != int64(len(readings)) {
+		return fmt.Errorf("the server received %d readings out of %d", response.Readings, len(readings))
+	}
+	return nil
+}
+
+// dataReadingMessage returns the DataReading message of a reading, with its
+// data, findings and policy results JSON encoded.
+func dataReadingMessage(reading *api.DataReading) (*uploadv1.DataReading, error) {
+	data, err := json.Marshal(reading.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the data of %s: %w", reading.DataGatherer, err)
+	}
+	message := &uploadv1.DataReading{
+		ClusterId:     reading.ClusterID,
+		DataGatherer:  reading.DataGatherer,
+		Timestamp:     timestamp(reading.Timestamp.Time),
+		Data:          data,
+		SchemaVersion: reading.SchemaVersion,
+		Labels:        reading.Labels,
+		DataVersion:   reading.DataVersion,
+	}
+	if len(reading.Findings) > 0 {
+		if message.Findings, err = json.Marshal(reading.Findings); err != nil {
+			return nil, err
+		}
+	}
+	if len(reading.PolicyResults) > 0 {
+		if message.PolicyResults, err = json.Marshal(reading.PolicyResults); err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// timestamp returns the google.protobuf.Timestamp of t, nil if it is zero.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
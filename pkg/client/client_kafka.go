@@ -0,0 +1,208 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// The SASL mechanisms supported by the KafkaClient.
+const (
+	KafkaSASLPlain       = "PLAIN"
+	KafkaSASLSCRAMSHA256 = "SCRAM-SHA-256"
+	KafkaSASLSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+type (
+	// The KafkaClient type is a Client implementation used to produce data readings to Kafka topics, one record per
+	// reading, for customers who want the data in their event streaming platform rather than uploaded to the Jetstack
+	// Secure platform. The records are produced at least once.
+	KafkaClient struct {
+		opts      KafkaOptions
+		tlsConfig *tls.Config
+	}
+
+	// KafkaOptions are the options of a KafkaClient.
+	KafkaOptions struct {
+		// Brokers are the host and port of the bootstrap brokers.
+		Brokers []string
+		// Topic is the topic the readings are produced to. If
+		// TopicPerGatherer is set, it is the prefix of the topic of each data
+		// gatherer instead, e.g. the readings of k8s/pods are produced to
+		// <Topic>.k8s_pods.
+		Topic            string
+		TopicPerGatherer bool
+		// Timeout is the deadline of each upload.
+		Timeout time.Duration
+
+		// TLS connects to the brokers over TLS, verified with the CA
+		// certificates at CAPath rather than the system ones if it is set,
+		// presenting the client certificate at TLSCertPath and TLSKeyPath if
+		// they are set.
+		TLS         bool
+		CAPath      string
+		TLSCertPath string
+		TLSKeyPath  string
+
+		// SASLMechanism, if set, authenticates with Username and the
+		// password at PasswordPath, read again for each upload.
+		SASLMechanism string
+		Username      string
+		PasswordPath  string
+	}
+)
+
+// NewKafkaClient returns a new instance of the KafkaClient type. The brokers are connected to for each upload.
+func NewKafkaClient(opts KafkaOptions) (*KafkaClient, error) {
+	if len(opts.Brokers) == 0 {
+		return nil, fmt.Errorf("cannot create KafkaClient: brokers cannot be empty")
+	}
+	if opts.Topic == "" {
+		return nil, fmt.Errorf("cannot create KafkaClient: topic cannot be empty")
+	}
+	switch opts.SASLMechanism {
+	case "", KafkaSASLPlain, KafkaSASLSCRAMSHA256, KafkaSASLSCRAMSHA512:
+	default:
+		return nil, fmt.Errorf("cannot create KafkaClient: unsupported SASL mechanism %q", opts.SASLMechanism)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultRequestTimeout
+	}
+
+	c := &KafkaClient{opts: opts}
+	if opts.TLS {
+		c.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if opts.CAPath != "" {
+			ca, err := os.ReadFile(opts.CAPath)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create KafkaClient: %w", err)
+			}
+			c.tlsConfig.RootCAs = x509.NewCertPool()
+			if !c.tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("cannot create KafkaClient: no certificates found in %s", opts.CAPath)
+			}
+		}
+		if opts.TLSCertPath != "" {
+			cert, err := tls.LoadX509KeyPair(opts.TLSCertPath, opts.TLSKeyPath)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create KafkaClient: %w", err)
+			}
+			c.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return c, nil
+}
+
+// PostDataReadingsWithOptions produces the slice of api.DataReading to the Kafka topics.
+func (c *KafkaClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
+	return c.PostDataReadings(opts.OrgID, opts.ClusterID, readings)
+}
+
+// PostDataReadings produces a record per api.DataReading, keyed by the cluster ID so that the records of a cluster
+// are ordered, and waits for all the in-sync replicas to acknowledge them. The type of each record is in its
+// headers.
+func (c *KafkaClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	readings, err := MigrateReadings(readings, SchemaVersion())
+	if err != nil {
+		return err
+	}
+	messages := make([]kafka.Message, 0, len(readings))
+	for _, reading := range readings {
+		value, err := json.Marshal(reading)
+		if err != nil {
+			return err
+		}
+		topic := c.opts.Topic
+		if c.opts.TopicPerGatherer {
+			topic += "." + nameToken(reading.DataGatherer)
+		}
+		messages = append(messages, kafka.Message{
+			Topic: topic,
+			Key:   []byte(clusterID),
+			Value: value,
+			Headers: []kafka.Header{
+				{Key: "Content-Type", Value: []byte("application/json")},
+				{Key: OrganizationIDHeader, Value: []byte(orgID)},
+				{Key: ClusterIDHeader, Value: []byte(clusterID)},
+				{Key: DataGathererHeader, Value: []byte(reading.DataGatherer)},
+			},
+		})
+	}
+
+	mechanism, err := c.saslMechanism()
+	if err != nil {
+		return err
+	}
+	transport := &kafka.Transport{
+		ClientID: "jetstack-secure-agent",
+		TLS:      c.tlsConfig,
+		SASL:     mechanism,
+	}
+	defer transport.CloseIdleConnections()
+	writer := &kafka.Writer{
+		Addr: kafka.TCP(c.opts.Brokers...),
+		// the records of a topic share a key, so they go to the partition
+		// the Java client would pick for it
+		Balancer:     &kafka.Murmur2Balancer{},
+		RequiredAcks: kafka.RequireAll,
+		// the upload is retried as a whole by the agent
+		MaxAttempts: 1,
+		// the records are all written at once, there is nothing to wait for
+		BatchSize:    len(messages),
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: c.opts.Timeout,
+		Transport:    transport,
+	}
+	defer writer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	if err := writer.WriteMessages(ctx, messages...); err != nil {
+		return fmt.Errorf("failed to produce the readings: %w", err)
+	}
+	return nil
+}
+
+// Post is not supported, the Kafka backend only produces data readings.
+func (c *KafkaClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return nil, fmt.Errorf("POST %s is not supported by the kafka backend", path)
+}
+
+// saslMechanism returns the SASL mechanism to authenticate with, nil if there is none. The password is read again so
+// that a rotated one is picked up by the next upload.
+func (c *KafkaClient) saslMechanism() (sasl.Mechanism, error) {
+	if c.opts.SASLMechanism == "" {
+		return nil, nil
+	}
+	password, err := os.ReadFile(c.opts.PasswordPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read password: %w", err)
+	}
+	username, pass := c.opts.Username, strings.TrimSpace(string(password))
+
+	switch c.opts.SASLMechanism {
+	case KafkaSASLSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, username, pass)
+	case KafkaSASLSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, username, pass)
+	default:
+		return plain.Mechanism{Username: username, Password: pass}, nil
+	}
+}
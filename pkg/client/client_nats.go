@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// NATSModeSnapshot publishes the readings of a gathering as a single
+	// message, encoded like the payloads uploaded over HTTP.
+	NATSModeSnapshot = "snapshot"
+	// NATSModePerGatherer publishes one message per reading, to the subject
+	// suffixed with the name of its data gatherer.
+	NATSModePerGatherer = "per-gatherer"
+)
+
+type (
+	// The NATSClient type is a Client implementation used to publish data readings to a NATS JetStream subject, for
+	// deployments fanning the data into their own pipelines rather than uploading it to the Jetstack Secure platform.
+	NATSClient struct {
+		agentMetadata *api.AgentMetadata
+		conn          *nats.Conn
+		js            nats.JetStreamContext
+		subject       string
+		mode          string
+		timeout       time.Duration
+	}
+
+	// NATSOptions are the options of a NATSClient.
+	NATSOptions struct {
+		// Subject is the subject the readings are published to.
+		Subject string
+		// Mode is NATSModeSnapshot, the default, or NATSModePerGatherer.
+		Mode string
+		// Timeout is the deadline of the acknowledgement of each message by
+		// JetStream.
+		Timeout time.Duration
+		// CredentialsPath is the path of a .creds file holding a user JWT
+		// and its NKey seed.
+		CredentialsPath string
+		// NKeySeedPath is the path of a file holding an NKey seed.
+		NKeySeedPath string
+		// TokenPath is the path of a file holding a token, read again on
+		// every connection so that it can be rotated.
+		TokenPath string
+		// Username and PasswordPath authenticate with a user and password.
+		Username     string
+		PasswordPath string
+		// CAPath is the path of the CA certificates the server is verified
+		// with, rather than the system ones.
+		CAPath string
+		// TLSCertPath and TLSKeyPath are the client certificate presented
+		// to the server.
+		TLSCertPath string
+		TLSKeyPath  string
+	}
+)
+
+// NewNATSClient returns a new instance of the NATSClient type that publishes to the NATS servers at url, a comma
+// separated list. The connection is retried in the background if the servers can't be reached, so that the agent
+// starts anyway, and the publishing fails until they can.
+func NewNATSClient(agentMetadata *api.AgentMetadata, url string, opts NATSOptions) (*NATSClient, error) {
+	if url == "" {
+		return nil, fmt.Errorf("cannot create NATSClient: url cannot be empty")
+	}
+	if opts.Subject == "" {
+		return nil, fmt.Errorf("cannot create NATSClient: subject cannot be empty")
+	}
+
+	options := []nats.Option{
+		nats.Name("jetstack-secure-agent"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("disconnected from NATS: %s", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			log.Printf("reconnected to NATS at %s", nc.ConnectedUrlRedacted())
+		}),
+	}
+	switch {
+	case opts.CredentialsPath != "":
+		options = append(options, nats.UserCredentials(opts.CredentialsPath))
+	case opts.NKeySeedPath != "":
+		option, err := nats.NkeyOptionFromSeed(opts.NKeySeedPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create NATSClient: %w", err)
+		}
+		options = append(options, option)
+	case opts.TokenPath != "":
+		path := opts.TokenPath
+		options = append(options, nats.TokenHandler(func() string {
+			token, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("failed to read the NATS token: %s", err)
+			}
+			return strings.TrimSpace(string(token))
+		}))
+	case opts.Username != "":
+		password, err := os.ReadFile(opts.PasswordPath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create NATSClient: failed to read password: %w", err)
+		}
+		options = append(options, nats.UserInfo(opts.Username, strings.TrimSpace(string(password))))
+	}
+	if opts.CAPath != "" {
+		options = append(options, nats.RootCAs(opts.CAPath))
+	}
+	if opts.TLSCertPath != "" {
+		options = append(options, nats.ClientCert(opts.TLSCertPath, opts.TLSKeyPath))
+	}
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create NATSClient: %w", err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot create NATSClient: %w", err)
+	}
+
+	c := &NATSClient{
+		agentMetadata: agentMetadata,
+		conn:          conn,
+		js:            js,
+		subject:       opts.Subject,
+		mode:          opts.Mode,
+		timeout:       opts.Timeout,
+	}
+	if c.mode == "" {
+		c.mode = NATSModeSnapshot
+	}
+	if c.timeout == 0 {
+		c.timeout = defaultRequestTimeout
+	}
+	return c, nil
+}
+
+// PostDataReadingsWithOptions publishes the slice of api.DataReading to the NATS JetStream subject.
+func (c *NATSClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
+	return c.PostDataReadings(opts.OrgID, opts.ClusterID, readings)
+}
+
+// PostDataReadings publishes the slice of api.DataReading to the NATS JetStream subject, and waits for JetStream to
+// acknowledge them. The messages have an ID derived from the readings, so that JetStream drops the duplicates if the
+// publishing is retried.
+func (c *NATSClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	if c.mode == NATSModePerGatherer {
+		readings, err := MigrateReadings(readings, SchemaVersion())
+		if err != nil {
+			return err
+		}
+		for _, reading := range readings {
+			data, err := json.Marshal(reading)
+			if err != nil {
+				return err
+			}
+			msg := c.newMsg(c.subject+"."+nameToken(reading.DataGatherer), orgID, clusterID, data)
+			msg.Header.Set(DataGathererHeader, reading.DataGatherer)
+			id := fmt.Sprintf("%s/%s/%s", clusterID, reading.DataGatherer, reading.Timestamp.UTC().Format(time.RFC3339Nano))
+			if err := c.publish(msg, id); err != nil {
+				return fmt.Errorf("failed to publish the reading of %s: %w", reading.DataGatherer, err)
+			}
+		}
+		return nil
+	}
+
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	// the snapshot is identified by its latest reading, as the gather time
+	// changes when the publishing is retried
+	var latest time.Time
+	for _, reading := range readings {
+		if reading.Timestamp.After(latest) {
+			latest = reading.Timestamp.Time
+		}
+	}
+	id := fmt.Sprintf("%s/%s", clusterID, latest.UTC().Format(time.RFC3339Nano))
+	return c.publish(c.newMsg(c.subject, orgID, clusterID, data), id)
+}
+
+// Post is not supported, the NATS backend only publishes data readings.
+func (c *NATSClient) Post(path string, body io.Reader) (*http.Response, error) {
+	return nil, fmt.Errorf("POST %s is not supported by the nats backend", path)
+}
+
+func (c *NATSClient) newMsg(subject, orgID, clusterID string, data []byte) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = data
+	msg.Header.Set("Content-Type", "application/json")
+	msg.Header.Set(OrganizationIDHeader, orgID)
+	msg.Header.Set(ClusterIDHeader, clusterID)
+	return msg
+}
+
+// publish publishes the message within the deadline. A message larger than
+// the maximum payload of the server is rejected before being sent.
+func (c *NATSClient) publish(msg *nats.Msg, id string) error {
+	if max := c.conn.MaxPayload(); max > 0 && int64(len(msg.Data)) > max {
+		return fmt.Errorf("the message is %d bytes, more than the %d bytes allowed by the NATS server", len(msg.Data), max)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := c.js.PublishMsg(msg, nats.MsgId(id), nats.Context(ctx))
+	return err
+}
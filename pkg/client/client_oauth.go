@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -26,6 +25,9 @@ type (
 		baseURL       string
 		agentMetadata *api.AgentMetadata
 		client        *http.Client
+		// loadCredentials, if set, loads the credentials again when the
+		// backend rejects the access token, in case they were rotated.
+		loadCredentials func() (*OAuthCredentials, error)
 	}
 
 	accessToken struct {
@@ -76,11 +78,7 @@ func NewOAuthClient(agentMetadata *api.AgentMetadata, credentials *OAuthCredenti
 		return nil, fmt.Errorf("cannot create OAuthClient: baseURL cannot be empty")
 	}
 
-	if !credentials.IsClientSet() {
-		credentials.ClientID = ClientID
-		credentials.ClientSecret = ClientSecret
-		credentials.AuthServerDomain = AuthServerDomain
-	}
+	setDefaultClient(credentials)
 
 	if !credentials.IsClientSet() {
 		return nil, fmt.Errorf("cannot create OAuthClient: invalid OAuth2 client configuration")
@@ -95,6 +93,23 @@ func NewOAuthClient(agentMetadata *api.AgentMetadata, credentials *OAuthCredenti
 	}, nil
 }
 
+// setDefaultClient sets the OAuth2 client injected at build time if the
+// credentials don't have one.
+func setDefaultClient(credentials *OAuthCredentials) {
+	if !credentials.IsClientSet() {
+		credentials.ClientID = ClientID
+		credentials.ClientSecret = ClientSecret
+		credentials.AuthServerDomain = AuthServerDomain
+	}
+}
+
+// SetCredentialsLoader sets a function loading the credentials again when the
+// backend rejects the access token, e.g. from a credentials file that is
+// updated when the credentials are rotated.
+func (c *OAuthClient) SetCredentialsLoader(load func() (*OAuthCredentials, error)) {
+	c.loadCredentials = load
+}
+
 func (c *OAuthClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
 	return c.PostDataReadings(opts.OrgID, opts.ClusterID, readings)
 }
@@ -102,17 +117,14 @@ func (c *OAuthClient) PostDataReadingsWithOptions(readings []*api.DataReading, o
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *OAuthClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
-	payload := api.DataReadingsPost{
-		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
-		DataReadings:   readings,
-	}
-	data, err := json.Marshal(payload)
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
 	if err != nil {
 		return err
 	}
+	body := newDataReadingsPostReader(payload)
+	defer body.Close()
 
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), body)
 	if err != nil {
 		return err
 	}
@@ -131,7 +143,8 @@ func (c *OAuthClient) PostDataReadings(orgID, clusterID string, readings []*api.
 	return nil
 }
 
-// Post performs an HTTP POST request.
+// Post performs an HTTP POST request. If the backend rejects the access token, a
+// new one is requested by the next request.
 func (c *OAuthClient) Post(path string, body io.Reader) (*http.Response, error) {
 	token, err := c.getValidAccessToken()
 	if err != nil {
@@ -149,7 +162,28 @@ func (c *OAuthClient) Post(path string, body io.Reader) (*http.Response, error)
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token.bearer))
 	}
 
-	return c.client.Do(req)
+	res, err := c.client.Do(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		c.reset()
+	}
+	return res, err
+}
+
+// reset drops the access token so that a new one is requested by the next
+// request, with the credentials loaded again if they can be.
+func (c *OAuthClient) reset() {
+	c.accessToken = &accessToken{}
+	if c.loadCredentials == nil {
+		return
+	}
+	credentials, err := c.loadCredentials()
+	if err != nil || credentials.Validate() != nil {
+		return
+	}
+	setDefaultClient(credentials)
+	if credentials.IsClientSet() {
+		c.credentials = credentials
+	}
 }
 
 // getValidAccessToken returns a valid access token. It will fetch a new access
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	// JWTTokenType is the type of Kubernetes service account tokens and of
+	// the identity tokens of most cloud workload identity providers.
+	JWTTokenType = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+type (
+	// The TokenExchangeClient type is a Client implementation used to upload data readings to the Jetstack Secure
+	// platform using OAuth2 token exchange (RFC 8693) as its authentication method: a workload identity token, such
+	// as a projected Kubernetes service account token, is exchanged for an access token, so that no long-lived
+	// credentials are needed.
+	TokenExchangeClient struct {
+		config        TokenExchangeConfig
+		baseURL       string
+		agentMetadata *api.AgentMetadata
+		client        *http.Client
+
+		mu          sync.Mutex
+		accessToken *accessToken
+	}
+
+	// TokenExchangeConfig defines the token exchange request.
+	TokenExchangeConfig struct {
+		// TokenURL is the token endpoint of the authorization server.
+		TokenURL string
+		// SubjectTokenPath is the file holding the workload identity token. It is read on every exchange as the
+		// token is rotated by the kubelet or the cloud provider.
+		SubjectTokenPath string
+		// SubjectTokenType defaults to JWTTokenType.
+		SubjectTokenType string
+		// Audience, Scope and ClientID are optional parameters of the exchange.
+		Audience string
+		Scope    string
+		ClientID string
+	}
+)
+
+// NewTokenExchangeClient returns a new instance of the TokenExchangeClient type that will perform HTTP requests
+// using access tokens obtained by exchanging a workload identity token.
+func NewTokenExchangeClient(agentMetadata *api.AgentMetadata, config TokenExchangeConfig, baseURL string) (*TokenExchangeClient, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("cannot create TokenExchangeClient: baseURL cannot be empty")
+	}
+	if config.TokenURL == "" || config.SubjectTokenPath == "" {
+		return nil, fmt.Errorf("cannot create TokenExchangeClient: the token URL and subject token path are required")
+	}
+	if config.SubjectTokenType == "" {
+		config.SubjectTokenType = JWTTokenType
+	}
+
+	return &TokenExchangeClient{
+		config:        config,
+		agentMetadata: agentMetadata,
+		baseURL:       baseURL,
+		accessToken:   &accessToken{},
+		client:        &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// PostDataReadingsWithOptions uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
+// viewing in the user-interface.
+func (c *TokenExchangeClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
+	return c.PostDataReadings(opts.OrgID, opts.ClusterID, readings)
+}
+
+// PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
+// viewing in the user-interface.
+func (c *TokenExchangeClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
+	if err != nil {
+		return err
+	}
+	body := newDataReadingsPostReader(payload)
+	defer body.Close()
+
+	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		errorContent := ""
+		body, err := ioutil.ReadAll(res.Body)
+		if err == nil {
+			errorContent = string(body)
+		}
+
+		return fmt.Errorf("received response with status code %d. Body: [%s]", code, errorContent)
+	}
+
+	return nil
+}
+
+// Post performs an HTTP POST request. The access token is exchanged again
+// if the backend rejects it.
+func (c *TokenExchangeClient) Post(path string, body io.Reader) (*http.Response, error) {
+	token, err := c.getValidAccessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fullURL(c.baseURL, path), body)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	res, err := c.client.Do(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		c.mu.Lock()
+		c.accessToken = &accessToken{}
+		c.mu.Unlock()
+	}
+	return res, err
+}
+
+// getValidAccessToken returns the current access token, exchanging the
+// workload identity token for a new one if it is missing or expired.
+func (c *TokenExchangeClient) getValidAccessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken.needsRenew() {
+		token, err := c.exchangeToken()
+		if err != nil {
+			return "", err
+		}
+		c.accessToken = token
+	}
+
+	return c.accessToken.bearer, nil
+}
+
+func (c *TokenExchangeClient) exchangeToken() (*accessToken, error) {
+	subjectToken, err := os.ReadFile(c.config.SubjectTokenPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workload identity token: %w", err)
+	}
+
+	payload := url.Values{}
+	payload.Set("grant_type", tokenExchangeGrantType)
+	payload.Set("subject_token", strings.TrimSpace(string(subjectToken)))
+	payload.Set("subject_token_type", c.config.SubjectTokenType)
+	if c.config.Audience != "" {
+		payload.Set("audience", c.config.Audience)
+	}
+	if c.config.Scope != "" {
+		payload.Set("scope", c.config.Scope)
+	}
+	if c.config.ClientID != "" {
+		payload.Set("client_id", c.config.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.config.TokenURL, strings.NewReader(payload.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange workload identity token: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if status := res.StatusCode; status < 200 || status >= 300 {
+		return nil, fmt.Errorf("auth server did not exchange the workload identity token: (status %d) %s", status, string(body))
+	}
+
+	response := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   uint   `json:"expires_in"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode token exchange response: %w", err)
+	}
+	if response.AccessToken == "" || response.ExpiresIn == 0 {
+		return nil, fmt.Errorf("auth server did not provide an access token with an expiration")
+	}
+
+	// renew the access token before it expires
+	expiresIn := time.Duration(response.ExpiresIn) * time.Second
+	return &accessToken{
+		bearer:         response.AccessToken,
+		expirationDate: time.Now().Add(expiresIn - expiresIn/10),
+	}, nil
+}
@@ -1,8 +1,6 @@
 package client
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -44,17 +42,14 @@ func (c *UnauthenticatedClient) PostDataReadingsWithOptions(readings []*api.Data
 // PostDataReadings uploads the slice of api.DataReading to the Jetstack Secure backend to be processed for later
 // viewing in the user-interface.
 func (c *UnauthenticatedClient) PostDataReadings(orgID, clusterID string, readings []*api.DataReading) error {
-	payload := api.DataReadingsPost{
-		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
-		DataReadings:   readings,
-	}
-	data, err := json.Marshal(payload)
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
 	if err != nil {
 		return err
 	}
+	body := newDataReadingsPostReader(payload)
+	defer body.Close()
 
-	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), bytes.NewBuffer(data))
+	res, err := c.Post(filepath.Join("/api/v1/org", orgID, "datareadings", clusterID), body)
 	if err != nil {
 		return err
 	}
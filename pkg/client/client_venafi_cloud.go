@@ -1,7 +1,6 @@
 package client
 
 import (
-	"bytes"
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
@@ -157,15 +156,12 @@ func (c *VenafiSvcAccountCredentials) IsClientSet() bool {
 // PostDataReadingsWithOptions uploads the slice of api.DataReading to the Venafi Cloud backend to be processed.
 // The Options are then passed as URL params in the request
 func (c *VenafiCloudClient) PostDataReadingsWithOptions(readings []*api.DataReading, opts Options) error {
-	payload := api.DataReadingsPost{
-		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
-		DataReadings:   readings,
-	}
-	data, err := json.Marshal(payload)
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
 	if err != nil {
 		return err
 	}
+	body := newDataReadingsPostReader(payload)
+	defer body.Close()
 
 	if !strings.HasSuffix(c.uploadPath, "/") {
 		c.uploadPath = fmt.Sprintf("%s/", c.uploadPath)
@@ -187,7 +183,7 @@ func (c *VenafiCloudClient) PostDataReadingsWithOptions(readings []*api.DataRead
 	}
 	venafiCloudUploadURL.RawQuery = query.Encode()
 
-	res, err := c.Post(venafiCloudUploadURL.String(), bytes.NewBuffer(data))
+	res, err := c.Post(venafiCloudUploadURL.String(), body)
 	if err != nil {
 		return err
 	}
@@ -210,20 +206,17 @@ func (c *VenafiCloudClient) PostDataReadingsWithOptions(readings []*api.DataRead
 func (c *VenafiCloudClient) PostDataReadings(_ string, _ string, readings []*api.DataReading) error {
 	// orgID and clusterID are ignored in Venafi Cloud auth
 
-	payload := api.DataReadingsPost{
-		AgentMetadata:  c.agentMetadata,
-		DataGatherTime: time.Now().UTC(),
-		DataReadings:   readings,
-	}
-	data, err := json.Marshal(payload)
+	payload, err := newDataReadingsPost(c.agentMetadata, readings)
 	if err != nil {
 		return err
 	}
+	body := newDataReadingsPostReader(payload)
+	defer body.Close()
 
 	if !strings.HasSuffix(c.uploadPath, "/") {
 		c.uploadPath = fmt.Sprintf("%s/", c.uploadPath)
 	}
-	res, err := c.Post(filepath.Join(c.uploadPath, c.uploaderID), bytes.NewBuffer(data))
+	res, err := c.Post(filepath.Join(c.uploadPath, c.uploaderID), body)
 	if err != nil {
 		return err
 	}
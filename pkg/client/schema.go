@@ -0,0 +1,127 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// SchemaVersions are the versions of the schema of the payloads the
+// readings can be converted to, the oldest first:
+//
+//   - v2.0.0 is the schema of the payloads before they were versioned.
+//   - v2.1.0 adds the schema_version of the payloads and the data_version of
+//     the readings.
+var SchemaVersions = []string{"v2.0.0", api.SchemaVersion}
+
+// schemaMigrations convert the readings between consecutive versions:
+// schemaMigrations[i] converts them from SchemaVersions[i] to
+// SchemaVersions[i+1] with up, and back with down.
+var schemaMigrations = []struct {
+	up, down func(reading *api.DataReading)
+}{
+	{
+		up: func(reading *api.DataReading) {
+			if reading.DataVersion == "" {
+				reading.DataVersion = api.DefaultDataVersion
+			}
+		},
+		down: func(reading *api.DataReading) {
+			reading.DataVersion = ""
+		},
+	},
+}
+
+var (
+	uploadSchemaVersionMu sync.RWMutex
+	uploadSchemaVersion   = api.SchemaVersion
+)
+
+// SetSchemaVersion sets the version of the schema of the payloads uploaded
+// by the clients, so that an agent can upload to a backend that doesn't
+// ingest the latest version yet.
+func SetSchemaVersion(version string) error {
+	if schemaVersionIndex(version) < 0 {
+		return fmt.Errorf("unknown schema version %q, the supported ones are %s", version, strings.Join(SchemaVersions, ", "))
+	}
+	uploadSchemaVersionMu.Lock()
+	defer uploadSchemaVersionMu.Unlock()
+	uploadSchemaVersion = version
+	return nil
+}
+
+// SchemaVersion returns the version of the schema of the payloads uploaded
+// by the clients.
+func SchemaVersion() string {
+	uploadSchemaVersionMu.RLock()
+	defer uploadSchemaVersionMu.RUnlock()
+	return uploadSchemaVersion
+}
+
+func schemaVersionIndex(version string) int {
+	for i, v := range SchemaVersions {
+		if v == version {
+			return i
+		}
+	}
+	return -1
+}
+
+// MigrateReadings returns the readings converted to the schema version,
+// e.g. to upload the readings spooled by an older agent, or to upload to a
+// backend that doesn't ingest the latest version yet. The readings that are
+// converted are copied rather than changed. The readings without a schema
+// version, e.g. those of files written by hand, are left as they are.
+func MigrateReadings(readings []*api.DataReading, version string) ([]*api.DataReading, error) {
+	target := schemaVersionIndex(version)
+	if target < 0 {
+		return nil, fmt.Errorf("unknown schema version %q", version)
+	}
+	if readings == nil {
+		return nil, nil
+	}
+	migrated := make([]*api.DataReading, len(readings))
+	for i, reading := range readings {
+		if reading.SchemaVersion == "" || reading.SchemaVersion == version {
+			migrated[i] = reading
+			continue
+		}
+		from := schemaVersionIndex(reading.SchemaVersion)
+		if from < 0 {
+			return nil, fmt.Errorf("the reading of %s has the unknown schema version %q", reading.DataGatherer, reading.SchemaVersion)
+		}
+		copied := *reading
+		for ; from < target; from++ {
+			schemaMigrations[from].up(&copied)
+		}
+		for ; from > target; from-- {
+			schemaMigrations[from-1].down(&copied)
+		}
+		copied.SchemaVersion = version
+		migrated[i] = &copied
+	}
+	return migrated, nil
+}
+
+// newDataReadingsPost returns the payload uploading the readings, converted
+// to the schema version of the uploads.
+func newDataReadingsPost(agentMetadata *api.AgentMetadata, readings []*api.DataReading) (api.DataReadingsPost, error) {
+	version := SchemaVersion()
+	readings, err := MigrateReadings(readings, version)
+	if err != nil {
+		return api.DataReadingsPost{}, err
+	}
+	payload := api.DataReadingsPost{
+		AgentMetadata:  agentMetadata,
+		DataGatherTime: time.Now().UTC(),
+		DataReadings:   readings,
+	}
+	// the payloads of the first version aren't versioned
+	if version != SchemaVersions[0] {
+		payload.SchemaVersion = version
+	}
+	return payload, nil
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	// SignatureHeader holds the base64 encoded signature of an uploaded
+	// payload.
+	SignatureHeader = "X-Signature"
+	// SignatureTimestampHeader holds the RFC 3339 time at which the payload
+	// was signed, which is signed along with it.
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	// SignatureAlgorithmHeader holds the signature algorithm: hmac-sha256,
+	// ed25519 or ecdsa-sha256.
+	SignatureAlgorithmHeader = "X-Signature-Algorithm"
+	// SignatureKeyIDHeader holds the hex encoded SHA-256 digest of the PKIX
+	// encoding of the public key, for asymmetric signatures.
+	SignatureKeyIDHeader = "X-Signature-Key-ID"
+)
+
+// PayloadSigner signs the payloads uploaded by a client, so that the backend
+// can verify their integrity and origin. The signed message is the timestamp
+// and the payload, separated by a newline.
+type PayloadSigner struct {
+	algorithm string
+	keyID     string
+	sign      func(message []byte) ([]byte, error)
+	now       func() time.Time
+}
+
+// NewHMACPayloadSigner creates a signer computing the HMAC-SHA256 of the
+// payloads with a key shared with the backend.
+func NewHMACPayloadSigner(key []byte) (*PayloadSigner, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("the HMAC key is empty")
+	}
+	return &PayloadSigner{
+		algorithm: "hmac-sha256",
+		sign: func(message []byte) ([]byte, error) {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(message)
+			return mac.Sum(nil), nil
+		},
+		now: time.Now,
+	}, nil
+}
+
+// NewPayloadSigner creates a signer from an ECDSA or Ed25519 private key.
+// ECDSA signatures are ASN.1 encoded signatures of the SHA-256 digest.
+func NewPayloadSigner(key interface{}) (*PayloadSigner, error) {
+	var algorithm string
+	var sign func(message []byte) ([]byte, error)
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		algorithm = "ed25519"
+		sign = func(message []byte) ([]byte, error) {
+			return ed25519.Sign(key, message), nil
+		}
+	case *ecdsa.PrivateKey:
+		algorithm = "ecdsa-sha256"
+		sign = func(message []byte) ([]byte, error) {
+			digest := sha256.Sum256(message)
+			return ecdsa.SignASN1(rand.Reader, key, digest[:])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T, only ECDSA and Ed25519 keys are supported", key)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key.(crypto.Signer).Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+	sum := sha256.Sum256(der)
+
+	return &PayloadSigner{algorithm: algorithm, keyID: hex.EncodeToString(sum[:]), sign: sign, now: time.Now}, nil
+}
+
+// LoadPayloadSigner reads a PEM encoded PKCS #8 ECDSA or Ed25519 private key.
+func LoadPayloadSigner(path string) (*PayloadSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode signing key %q: no PEM data found", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %q: %w", path, err)
+	}
+	return NewPayloadSigner(key)
+}
+
+// SignRequest sets the signature headers of a request for its body, which is
+// read and replaced.
+func (s *PayloadSigner) SignRequest(req *http.Request) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return err
+		}
+		req.Body.Close()
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	timestamp := s.now().UTC().Format(time.RFC3339)
+	sig, err := s.sign(append([]byte(timestamp+"\n"), body...))
+	if err != nil {
+		return fmt.Errorf("failed to sign payload: %w", err)
+	}
+
+	req.Header.Set(SignatureHeader, base64.StdEncoding.EncodeToString(sig))
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureAlgorithmHeader, s.algorithm)
+	if s.keyID != "" {
+		req.Header.Set(SignatureKeyIDHeader, s.keyID)
+	}
+	return nil
+}
+
+// signingTransport signs the requests it sends.
+type signingTransport struct {
+	signer *PayloadSigner
+	next   http.RoundTripper
+}
+
+func (t *signingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper must not modify the request
+	req = req.Clone(req.Context())
+	if err := t.signer.SignRequest(req); err != nil {
+		return nil, err
+	}
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
+}
+
+// SignPayloads makes a client sign the payloads it uploads with signer.
+func SignPayloads(c Client, signer *PayloadSigner) error {
+	return wrapTransport(c, func(next http.RoundTripper) http.RoundTripper {
+		return &signingTransport{signer: signer, next: next}
+	})
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// ReopenableReader is a reader that can be read again from the start, so that
+// a request can be retried without holding its body in memory.
+type ReopenableReader interface {
+	io.ReadCloser
+	// Reopen returns a new reader of the same content, from the start.
+	Reopen() ReopenableReader
+}
+
+// jsonStream is the reader of the JSON written by encode, see streamJSON.
+type jsonStream struct {
+	io.ReadCloser
+	encode func(w *bufio.Writer) error
+}
+
+// Reopen runs the encoding again.
+func (s *jsonStream) Reopen() ReopenableReader {
+	return streamJSON(s.encode)
+}
+
+// streamJSON returns a reader of the JSON written by encode, which runs as
+// the reader is read, through a pipe, so that the encoding doesn't have to be
+// held in memory. Encoding errors are returned by Read. The reader must be
+// closed, which stops the encoding if it wasn't read to the end.
+func streamJSON(encode func(w *bufio.Writer) error) ReopenableReader {
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		err := encode(w)
+		if err == nil {
+			err = w.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return &jsonStream{ReadCloser: pr, encode: encode}
+}
+
+// NewDataReadingsReader returns a reader of the JSON array of readings. The
+// readings are encoded one at a time as the reader is read, so that only the
+// encoding of one reading is held in memory rather than that of all of them.
+func NewDataReadingsReader(readings []*api.DataReading) ReopenableReader {
+	return streamJSON(func(w *bufio.Writer) error {
+		return writeDataReadings(w, readings)
+	})
+}
+
+// newDataReadingsPostReader is like NewDataReadingsReader, for the payload of
+// an upload.
+func newDataReadingsPostReader(payload api.DataReadingsPost) ReopenableReader {
+	return streamJSON(func(w *bufio.Writer) error {
+		// the fields before the readings are small, and encoded at once
+		var header bytes.Buffer
+		header.WriteByte('{')
+		if payload.SchemaVersion != "" {
+			version, err := json.Marshal(payload.SchemaVersion)
+			if err != nil {
+				return err
+			}
+			header.WriteString(`"schema_version":`)
+			header.Write(version)
+			header.WriteByte(',')
+		}
+		metadata, err := json.Marshal(payload.AgentMetadata)
+		if err != nil {
+			return err
+		}
+		gatherTime, err := json.Marshal(payload.DataGatherTime)
+		if err != nil {
+			return err
+		}
+		header.WriteString(`"agent_metadata":`)
+		header.Write(metadata)
+		header.WriteString(`,"data_gather_time":`)
+		header.Write(gatherTime)
+		header.WriteString(`,"data_readings":`)
+		if _, err := w.Write(header.Bytes()); err != nil {
+			return err
+		}
+		if err := writeDataReadings(w, payload.DataReadings); err != nil {
+			return err
+		}
+		return w.WriteByte('}')
+	})
+}
+
+// writeDataReadings writes the JSON array of readings, encoding one reading
+// at a time.
+func writeDataReadings(w *bufio.Writer, readings []*api.DataReading) error {
+	if readings == nil {
+		_, err := w.WriteString("null")
+		return err
+	}
+	if err := w.WriteByte('['); err != nil {
+		return err
+	}
+	for i, reading := range readings {
+		if i > 0 {
+			if err := w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(reading)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return w.WriteByte(']')
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// httpClient returns the HTTP client of one of the clients of this package.
+func httpClient(c Client) (*http.Client, error) {
+	switch c := c.(type) {
+	case *APITokenClient:
+		return c.client, nil
+	case *OAuthClient:
+		return c.client, nil
+	case *TokenExchangeClient:
+		return c.client, nil
+	case *UnauthenticatedClient:
+		return c.client, nil
+	case *VenafiCloudClient:
+		return c.client, nil
+	}
+	return nil, fmt.Errorf("unsupported client type %T", c)
+}
+
+// wrapTransport wraps the transport of the HTTP client of c.
+func wrapTransport(c Client, wrap func(next http.RoundTripper) http.RoundTripper) error {
+	hc, err := httpClient(c)
+	if err != nil {
+		return err
+	}
+	next := hc.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	hc.Transport = wrap(next)
+	return nil
+}
+
+// defaultRequestTimeout is the timeout of the requests of the clients.
+const defaultRequestTimeout = time.Minute
+
+// LimitUploadBandwidth limits the rate at which a client sends request
+// bodies to bytesPerSecond, across all its requests. The timeout of each
+// request is extended by the time its body takes to send at that rate. For
+// bodies of unknown length, e.g. streamed payloads, the timeout is instead
+// restarted whenever a part of the body is sent.
+func LimitUploadBandwidth(c Client, bytesPerSecond int64) error {
+	if bytesPerSecond <= 0 {
+		return fmt.Errorf("the upload bandwidth must be positive")
+	}
+	burst := int(bytesPerSecond)
+	if burst > 64*1024 {
+		burst = 64 * 1024
+	}
+	limiter := rate.NewLimiter(rate.Limit(bytesPerSecond), burst)
+
+	hc, err := httpClient(c)
+	if err != nil {
+		return err
+	}
+	// the timeout depends on the size of each request, see RoundTrip
+	hc.Timeout = 0
+
+	return wrapTransport(c, func(next http.RoundTripper) http.RoundTripper {
+		return &throttlingTransport{limiter: limiter, bytesPerSecond: bytesPerSecond, next: next}
+	})
+}
+
+// throttlingTransport limits the rate at which request bodies are sent.
+type throttlingTransport struct {
+	limiter        *rate.Limiter
+	bytesPerSecond int64
+	next           http.RoundTripper
+}
+
+func (t *throttlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var onRead func()
+	if req.Body != nil && req.Body != http.NoBody && req.ContentLength <= 0 {
+		ctx, cancel = context.WithCancel(req.Context())
+		timer := time.AfterFunc(defaultRequestTimeout, cancel)
+		onRead = func() { timer.Reset(defaultRequestTimeout) }
+		stop := cancel
+		cancel = func() {
+			timer.Stop()
+			stop()
+		}
+	} else {
+		timeout := defaultRequestTimeout + time.Duration(req.ContentLength/t.bytesPerSecond)*time.Second
+		ctx, cancel = context.WithTimeout(req.Context(), timeout)
+	}
+	req = req.Clone(ctx)
+	if req.Body != nil {
+		req.Body = &throttledReader{ReadCloser: req.Body, limiter: t.limiter, ctx: ctx, onRead: onRead}
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the timeout covers reading the response
+	if onRead != nil {
+		onRead()
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// throttledReader waits for the limiter before returning what it reads.
+type throttledReader struct {
+	io.ReadCloser
+	limiter *rate.Limiter
+	ctx     context.Context
+	// onRead, if set, is called after each read.
+	onRead func()
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if burst := r.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	if r.onRead != nil {
+		r.onRead()
+	}
+	return n, err
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// UploadSession is a chunked upload of data readings to the Jetstack Secure
+// backend. The session is started with the agent metadata, the readings are
+// then uploaded in numbered parts, and the backend processes them once the
+// session is completed. Uploading a part again replaces it, so that a part
+// that failed can be retried on its own.
+type UploadSession struct {
+	client Client
+	path   string
+	// ID identifies the session in the backend.
+	ID string
+}
+
+// StartUploadSession starts a chunked upload of the data readings of a
+// cluster, using the Post method of the client so that the session is
+// authenticated like the other requests of the client.
+func StartUploadSession(c Client, orgID, clusterID string, agentMetadata *api.AgentMetadata, dataGatherTime time.Time) (*UploadSession, error) {
+	path := filepath.Join("/api/v1/org", orgID, "datareadings", clusterID, "uploads")
+	payload, err := newDataReadingsPost(agentMetadata, nil)
+	if err != nil {
+		return nil, err
+	}
+	payload.DataGatherTime = dataGatherTime.UTC()
+	response := struct {
+		UploadID string `json:"upload_id"`
+	}{}
+	if err := postJSON(c, path, payload, &response); err != nil {
+		return nil, fmt.Errorf("failed to start upload session: %w", err)
+	}
+	if response.UploadID == "" {
+		return nil, fmt.Errorf("failed to start upload session: the backend did not return an upload_id")
+	}
+
+	return &UploadSession{client: c, path: filepath.Join(path, response.UploadID), ID: response.UploadID}, nil
+}
+
+// UploadPart uploads the readings of a part. Parts are numbered from 1.
+func (s *UploadSession) UploadPart(number int, readings []*api.DataReading) error {
+	readings, err := MigrateReadings(readings, SchemaVersion())
+	if err != nil {
+		return err
+	}
+	body := streamJSON(func(w *bufio.Writer) error {
+		if _, err := w.WriteString(`{"data_readings":`); err != nil {
+			return err
+		}
+		if err := writeDataReadings(w, readings); err != nil {
+			return err
+		}
+		return w.WriteByte('}')
+	})
+	defer body.Close()
+	if err := post(s.client, filepath.Join(s.path, "parts", strconv.Itoa(number)), body, nil); err != nil {
+		return fmt.Errorf("failed to upload part %d of upload session %s: %w", number, s.ID, err)
+	}
+	return nil
+}
+
+// Complete finalizes the session once all its parts are uploaded. The
+// backend checks that it received the given number of parts.
+func (s *UploadSession) Complete(parts int) error {
+	payload := struct {
+		Parts int `json:"parts"`
+	}{Parts: parts}
+	if err := postJSON(s.client, filepath.Join(s.path, "complete"), payload, nil); err != nil {
+		return fmt.Errorf("failed to complete upload session %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// postJSON posts payload as JSON and decodes the response into response, if
+// it is not nil.
+func postJSON(c Client, path string, payload, response interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(c, path, bytes.NewBuffer(data), response)
+}
+
+// post is postJSON with a body that is already encoded.
+func post(c Client, path string, body io.Reader, response interface{}) error {
+	res, err := c.Post(path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if code := res.StatusCode; code < 200 || code >= 300 {
+		errorContent := ""
+		body, err := ioutil.ReadAll(res.Body)
+		if err == nil {
+			errorContent = string(body)
+		}
+
+		return fmt.Errorf("received response with status code %d. Body: [%s]", code, errorContent)
+	}
+
+	if response == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
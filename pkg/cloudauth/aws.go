@@ -0,0 +1,201 @@
+// Package cloudauth authenticates the agent to the APIs of the cloud
+// providers, with static credentials from the environment or with the
+// workload identity of the agent: IAM roles for service accounts on AWS, the
+// metadata server on GCP, and Azure AD workload identity or managed identity
+// on Azure.
+package cloudauth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials are AWS access keys, which expire if they are temporary.
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Expires         time.Time
+}
+
+// AWS provides AWS credentials. They are read from the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables or,
+// with IAM roles for service accounts, obtained for the role in AWS_ROLE_ARN
+// with the token in AWS_WEB_IDENTITY_TOKEN_FILE.
+type AWS struct {
+	// Endpoint returns the endpoint of a service in a region.
+	Endpoint func(service, region string) string
+	Client   *http.Client
+	Now      func() time.Time
+
+	mu          sync.Mutex
+	credentials *AWSCredentials
+}
+
+// NewAWS returns an AWS credentials provider.
+func NewAWS() *AWS {
+	return &AWS{
+		Endpoint: AWSEndpoint,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Now:      time.Now,
+	}
+}
+
+// AWSEndpoint returns the endpoint of an AWS service in a region.
+func AWSEndpoint(service, region string) string {
+	return fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+}
+
+// AWSRegion returns the region of the AWS_REGION or AWS_DEFAULT_REGION
+// environment variables.
+func AWSRegion() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+// Credentials returns static credentials from the environment or the
+// temporary credentials of the web identity role, which are renewed before
+// they expire.
+func (a *AWS) Credentials(ctx context.Context, region string) (*AWSCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return &AWSCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	roleARN, tokenFile := os.Getenv("AWS_ROLE_ARN"), os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return nil, fmt.Errorf("no AWS credentials, set AWS_ACCESS_KEY_ID or AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.credentials != nil && a.Now().Add(5*time.Minute).Before(a.credentials.Expires) {
+		return a.credentials, nil
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the web identity token: %w", err)
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {roleARN},
+		"RoleSessionName":  {"jetstack-secure-agent"},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint("sts", region)+"/", strings.NewReader(query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := a.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assume role %q: %w", roleARN, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return nil, fmt.Errorf("failed to assume role %q: received response with status code %d. Body: [%s]", roleARN, res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	var response struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode the credentials of role %q: %w", roleARN, err)
+	}
+
+	a.credentials = &AWSCredentials{
+		AccessKeyID:     response.Credentials.AccessKeyID,
+		SecretAccessKey: response.Credentials.SecretAccessKey,
+		SessionToken:    response.Credentials.SessionToken,
+		Expires:         response.Credentials.Expiration,
+	}
+	return a.credentials, nil
+}
+
+// SignV4 signs a request with AWS Signature Version 4. The host, the
+// Content-Type and the X-Amz-* headers are signed.
+func SignV4(req *http.Request, body []byte, credentials *AWSCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,143 @@
+package cloudauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultAzureAuthorityHost = "https://login.microsoftonline.com/"
+
+// Azure provides Azure AD access tokens. With Azure AD workload identity, the
+// federated token in AZURE_FEDERATED_TOKEN_FILE is exchanged for an access
+// token of the AZURE_CLIENT_ID application in the AZURE_TENANT_ID tenant.
+// Otherwise the token of the managed identity of the node is requested from
+// the instance metadata service.
+type Azure struct {
+	// IMDSEndpoint is the endpoint of the instance metadata service.
+	IMDSEndpoint string
+	Client       *http.Client
+	Now          func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]azureToken
+}
+
+type azureToken struct {
+	value   string
+	expires time.Time
+}
+
+// NewAzure returns an Azure access token provider.
+func NewAzure() *Azure {
+	return &Azure{
+		IMDSEndpoint: "http://169.254.169.254",
+		Client:       &http.Client{Timeout: 30 * time.Second},
+		Now:          time.Now,
+		tokens:       map[string]azureToken{},
+	}
+}
+
+// AccessToken returns an access token for the resource, e.g.
+// https://vault.azure.net, fetching a new one when the previous one
+// expires.
+func (a *Azure) AccessToken(ctx context.Context, resource string) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if token, ok := a.tokens[resource]; ok && a.Now().Before(token.expires) {
+		return token.value, nil
+	}
+
+	var req *http.Request
+	var err error
+	if tokenFile := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); tokenFile != "" {
+		req, err = a.workloadIdentityRequest(ctx, tokenFile, resource)
+	} else {
+		req, err = a.managedIdentityRequest(ctx, resource)
+	}
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		AccessToken string       `json:"access_token"`
+		ExpiresIn   azureSeconds `json:"expires_in"`
+	}
+	if err := doJSON(a.Client, req, &response); err != nil {
+		return "", fmt.Errorf("failed to get an Azure access token: %w", err)
+	}
+
+	a.tokens[resource] = azureToken{
+		value:   response.AccessToken,
+		expires: a.Now().Add(time.Duration(response.ExpiresIn) * time.Second * 9 / 10),
+	}
+	return response.AccessToken, nil
+}
+
+// workloadIdentityRequest exchanges the federated token for an access token
+// with the client credentials flow.
+func (a *Azure) workloadIdentityRequest(ctx context.Context, tokenFile, resource string) (*http.Request, error) {
+	clientID, tenantID := os.Getenv("AZURE_CLIENT_ID"), os.Getenv("AZURE_TENANT_ID")
+	if clientID == "" || tenantID == "" {
+		return nil, fmt.Errorf("AZURE_CLIENT_ID and AZURE_TENANT_ID are required with AZURE_FEDERATED_TOKEN_FILE")
+	}
+	authorityHost := os.Getenv("AZURE_AUTHORITY_HOST")
+	if authorityHost == "" {
+		authorityHost = defaultAzureAuthorityHost
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the federated token: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":            {"client_credentials"},
+		"client_id":             {clientID},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(token))},
+		"scope":                 {strings.TrimSuffix(resource, "/") + "/.default"},
+	}
+	endpoint := strings.TrimSuffix(authorityHost, "/") + "/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// managedIdentityRequest requests the token of the managed identity, the one
+// of AZURE_CLIENT_ID if set.
+func (a *Azure) managedIdentityRequest(ctx context.Context, resource string) (*http.Request, error) {
+	query := url.Values{
+		"api-version": {"2018-02-01"},
+		"resource":    {resource},
+	}
+	if clientID := os.Getenv("AZURE_CLIENT_ID"); clientID != "" {
+		query.Set("client_id", clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.IMDSEndpoint+"/metadata/identity/oauth2/token?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	return req, nil
+}
+
+// azureSeconds is a number of seconds, which the instance metadata service
+// returns as a string and Azure AD as a number.
+type azureSeconds int64
+
+func (s *azureSeconds) UnmarshalJSON(data []byte) error {
+	seconds, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number of seconds %s", data)
+	}
+	*s = azureSeconds(seconds)
+	return nil
+}
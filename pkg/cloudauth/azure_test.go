@@ -0,0 +1,52 @@
+package cloudauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAzureWorkloadIdentity(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" ||
+			r.FormValue("client_id") != "client" ||
+			r.FormValue("client_assertion") != "federated-token" ||
+			r.FormValue("scope") != "https://vault.azure.net/.default" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "azure-token", "expires_in": 3599}`)
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", tokenFile)
+	t.Setenv("AZURE_CLIENT_ID", "client")
+	t.Setenv("AZURE_TENANT_ID", "tenant")
+	t.Setenv("AZURE_AUTHORITY_HOST", server.URL+"/")
+
+	a := NewAzure()
+	for i := 0; i < 2; i++ {
+		token, err := a.AccessToken(context.Background(), "https://vault.azure.net")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if token != "azure-token" {
+			t.Errorf("unexpected token: %q", token)
+		}
+	}
+	// the token is cached until it expires
+	if requests != 1 {
+		t.Errorf("expected 1 token request, got %d", requests)
+	}
+}
@@ -0,0 +1,81 @@
+package cloudauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GCP provides GCP OAuth2 access tokens. The token is read from the
+// GOOGLE_OAUTH_ACCESS_TOKEN environment variable or, on GCP, from the
+// metadata server, which provides the token of the Workload Identity service
+// account on GKE.
+type GCP struct {
+	MetadataEndpoint string
+	Client           *http.Client
+	Now              func() time.Time
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+// NewGCP returns a GCP access token provider.
+func NewGCP() *GCP {
+	return &GCP{
+		MetadataEndpoint: "http://metadata.google.internal",
+		Client:           &http.Client{Timeout: 30 * time.Second},
+		Now:              time.Now,
+	}
+}
+
+// AccessToken returns an OAuth2 access token, fetching a new one from the
+// metadata server when the previous one expires.
+func (g *GCP) AccessToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && g.Now().Before(g.tokenExpires) {
+		return g.token, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.MetadataEndpoint+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var response struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := doJSON(g.Client, req, &response); err != nil {
+		return "", fmt.Errorf("failed to get an access token from the metadata server: %w", err)
+	}
+
+	g.token = response.AccessToken
+	g.tokenExpires = g.Now().Add(time.Duration(response.ExpiresIn) * time.Second * 9 / 10)
+	return g.token, nil
+}
+
+// doJSON sends the request and decodes the JSON body of its 200 response.
+func doJSON(client *http.Client, req *http.Request, v interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,124 @@
+package cloudcerts
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/cloudauth"
+)
+
+// acmKeyTypes are all the key types of the ACM certificates, which are
+// listed explicitly since ListCertificates only returns RSA 2048 and RSA 1024
+// certificates by default.
+var acmKeyTypes = []string{"RSA_1024", "RSA_2048", "RSA_3072", "RSA_4096", "EC_prime256v1", "EC_secp384r1", "EC_secp521r1"}
+
+// acmCertificateSummary is a certificate of the ListCertificates response of
+// ACM. The times are in seconds since the epoch.
+type acmCertificateSummary struct {
+	CertificateArn                  string   `json:"CertificateArn"`
+	DomainName                      string   `json:"DomainName"`
+	SubjectAlternativeNameSummaries []string `json:"SubjectAlternativeNameSummaries"`
+	Status                          string   `json:"Status"`
+	Type                            string   `json:"Type"`
+	KeyAlgorithm                    string   `json:"KeyAlgorithm"`
+	InUse                           *bool    `json:"InUse"`
+	NotBefore                       float64  `json:"NotBefore"`
+	NotAfter                        float64  `json:"NotAfter"`
+}
+
+// listACM lists the certificates of AWS Certificate Manager in a region.
+func (g *DataGatherer) listACM(region string) ([]Certificate, error) {
+	credentials, err := g.aws.Credentials(g.ctx, region)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	nextToken := ""
+	for {
+		request := map[string]interface{}{
+			"MaxItems": 1000,
+			"Includes": map[string]interface{}{"keyTypes": acmKeyTypes},
+		}
+		if nextToken != "" {
+			request["NextToken"] = nextToken
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(g.ctx, http.MethodPost, g.awsEndpoint("acm", region)+"/", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", "CertificateManager.ListCertificates")
+		cloudauth.SignV4(req, body, credentials, region, "acm", g.now())
+
+		var response struct {
+			CertificateSummaryList []acmCertificateSummary `json:"CertificateSummaryList"`
+			NextToken              string                  `json:"NextToken"`
+		}
+		if err := g.do(req, &response); err != nil {
+			return nil, err
+		}
+		for _, summary := range response.CertificateSummaryList {
+			certificates = append(certificates, acmCertificate(region, summary))
+		}
+		if response.NextToken == "" {
+			return certificates, nil
+		}
+		nextToken = response.NextToken
+	}
+}
+
+func acmCertificate(region string, summary acmCertificateSummary) Certificate {
+	certificate := Certificate{
+		Provider:     ProviderAWS,
+		Location:     region,
+		ID:           summary.CertificateArn,
+		Name:         summary.DomainName,
+		Domains:      summary.SubjectAlternativeNameSummaries,
+		Status:       summary.Status,
+		Type:         summary.Type,
+		KeyAlgorithm: summary.KeyAlgorithm,
+		InUse:        summary.InUse,
+		NotBefore:    epochTime(summary.NotBefore),
+		NotAfter:     epochTime(summary.NotAfter),
+	}
+	if len(certificate.Domains) == 0 && summary.DomainName != "" {
+		certificate.Domains = []string{summary.DomainName}
+	}
+	return certificate
+}
+
+// epochTime converts seconds since the epoch, or nil if they are zero.
+func epochTime(seconds float64) *time.Time {
+	if seconds == 0 {
+		return nil
+	}
+	t := time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+	return &t
+}
+
+// do sends the request and decodes the JSON body of its 200 response.
+func (g *DataGatherer) do(req *http.Request, v interface{}) error {
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
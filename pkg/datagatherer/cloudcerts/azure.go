@@ -0,0 +1,74 @@
+package cloudcerts
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	// keyVaultResource is the resource of the access tokens of Key Vault.
+	keyVaultResource = "https://vault.azure.net"
+	// keyVaultAPIVersion is the version of the Key Vault API.
+	keyVaultAPIVersion = "7.4"
+)
+
+// keyVaultCertificate is a certificate of the list of certificates of Azure
+// Key Vault. The times are in seconds since the epoch.
+type keyVaultCertificate struct {
+	ID         string `json:"id"`
+	Attributes struct {
+		Enabled   *bool   `json:"enabled"`
+		NotBefore float64 `json:"nbf"`
+		Expires   float64 `json:"exp"`
+	} `json:"attributes"`
+}
+
+// listKeyVault lists the certificates of an Azure key vault.
+func (g *DataGatherer) listKeyVault(vault string) ([]Certificate, error) {
+	token, err := g.azure.AccessToken(g.ctx, keyVaultResource)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	next := strings.TrimSuffix(vault, "/") + "/certificates?api-version=" + keyVaultAPIVersion
+	for next != "" {
+		req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		var response struct {
+			Value    []keyVaultCertificate `json:"value"`
+			NextLink string                `json:"nextLink"`
+		}
+		if err := g.do(req, &response); err != nil {
+			return nil, err
+		}
+		for _, c := range response.Value {
+			certificate := Certificate{
+				Provider:  ProviderAzure,
+				Location:  vault,
+				ID:        c.ID,
+				Name:      lastSegment(c.ID),
+				NotBefore: epochTime(c.Attributes.NotBefore),
+				NotAfter:  epochTime(c.Attributes.Expires),
+			}
+			if c.Attributes.Enabled != nil {
+				certificate.Status = "disabled"
+				if *c.Attributes.Enabled {
+					certificate.Status = "enabled"
+				}
+			}
+			certificates = append(certificates, certificate)
+		}
+		next = response.NextLink
+	}
+	return certificates, nil
+}
+
+// lastSegment returns the last segment of a resource name or URL.
+func lastSegment(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
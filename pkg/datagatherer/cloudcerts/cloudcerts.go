@@ -0,0 +1,239 @@
+// Package cloudcerts contains a data gatherer that lists the certificates
+// managed by the cloud providers: AWS Certificate Manager, GCP Certificate
+// Manager and Azure Key Vault, so that the certificates terminating TLS
+// outside of the cluster appear in the same report.
+package cloudcerts
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/cloudauth"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// ProviderAWS is AWS Certificate Manager.
+	ProviderAWS = "aws"
+	// ProviderGCP is GCP Certificate Manager.
+	ProviderGCP = "gcp"
+	// ProviderAzure is Azure Key Vault.
+	ProviderAzure = "azure"
+
+	// defaultTimeout is the timeout of the requests.
+	defaultTimeout = 30 * time.Second
+)
+
+// Config is the configuration of the cloud-certificates data gatherer.
+type Config struct {
+	// Provider is aws, gcp or azure.
+	Provider string `yaml:"provider"`
+	// Regions are the AWS regions whose certificates are listed. Defaults to
+	// the AWS_REGION or AWS_DEFAULT_REGION environment variables.
+	Regions []string `yaml:"regions,omitempty"`
+	// Projects are the GCP projects whose certificates are listed.
+	Projects []string `yaml:"projects,omitempty"`
+	// Locations are the GCP locations whose certificates are listed.
+	// Defaults to global.
+	Locations []string `yaml:"locations,omitempty"`
+	// Vaults are the URLs of the Azure key vaults whose certificates are
+	// listed, e.g. https://example.vault.azure.net.
+	Vaults []string `yaml:"vaults,omitempty"`
+	// Timeout is the timeout of the requests. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// Validate checks the configuration, without connecting to the cloud
+// provider, so that mistakes are reported when the agent config is parsed.
+func (c *Config) Validate() error {
+	var errors []string
+	switch c.Provider {
+	case ProviderAWS:
+	case ProviderGCP:
+		if len(c.Projects) == 0 {
+			errors = append(errors, "at least one project is required for gcp")
+		}
+	case ProviderAzure:
+		if len(c.Vaults) == 0 {
+			errors = append(errors, "at least one vault is required for azure")
+		}
+		for _, vault := range c.Vaults {
+			if u, err := url.Parse(vault); err != nil || u.Scheme == "" || u.Host == "" {
+				errors = append(errors, fmt.Sprintf("invalid vault url %q", vault))
+			}
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("provider must be %s, %s or %s, got %q", ProviderAWS, ProviderGCP, ProviderAzure, c.Provider))
+	}
+	if len(c.Regions) > 0 && c.Provider != ProviderAWS {
+		errors = append(errors, "regions can only be set for aws")
+	}
+	if (len(c.Projects) > 0 || len(c.Locations) > 0) && c.Provider != ProviderGCP {
+		errors = append(errors, "projects and locations can only be set for gcp")
+	}
+	if len(c.Vaults) > 0 && c.Provider != ProviderAzure {
+		errors = append(errors, "vaults can only be set for azure")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new DataGatherer.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &DataGatherer{
+		ctx:         ctx,
+		provider:    c.Provider,
+		regions:     c.Regions,
+		projects:    c.Projects,
+		locations:   c.Locations,
+		vaults:      c.Vaults,
+		client:      &http.Client{Timeout: c.Timeout},
+		awsEndpoint: cloudauth.AWSEndpoint,
+		gcpEndpoint: "https://certificatemanager.googleapis.com",
+		aws:         cloudauth.NewAWS(),
+		gcp:         cloudauth.NewGCP(),
+		azure:       cloudauth.NewAzure(),
+		now:         time.Now,
+	}
+	if c.Timeout == 0 {
+		g.client.Timeout = defaultTimeout
+	}
+	if c.Provider == ProviderAWS && len(g.regions) == 0 {
+		region := cloudauth.AWSRegion()
+		if region == "" {
+			return nil, fmt.Errorf("the AWS region is not set, set regions or AWS_REGION")
+		}
+		g.regions = []string{region}
+	}
+	if len(g.locations) == 0 {
+		g.locations = []string{"global"}
+	}
+	return g, nil
+}
+
+// DataGatherer lists the certificates of a cloud provider.
+type DataGatherer struct {
+	ctx       context.Context
+	provider  string
+	regions   []string
+	projects  []string
+	locations []string
+	vaults    []string
+	client    *http.Client
+
+	awsEndpoint func(service, region string) string
+	gcpEndpoint string
+	aws         *cloudauth.AWS
+	gcp         *cloudauth.GCP
+	azure       *cloudauth.Azure
+	now         func() time.Time
+}
+
+// CloudCertificates is the data of the cloud-certificates data gatherer.
+type CloudCertificates struct {
+	Certificates []Certificate `json:"certificates"`
+	// Errors are the errors listing the certificates of locations, so that
+	// one unreadable location doesn't hide the others.
+	Errors []LocationError `json:"errors,omitempty"`
+}
+
+// Certificate is a certificate managed by a cloud provider. Its private key
+// is never read.
+type Certificate struct {
+	Provider string `json:"provider"`
+	// Location is the AWS region, the GCP project and location, or the
+	// Azure key vault of the certificate.
+	Location string `json:"location"`
+	// ID is the ARN, the resource name or the Key Vault ID of the
+	// certificate.
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Domains   []string   `json:"domains,omitempty"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+	// Status is the status of the certificate as reported by the provider,
+	// e.g. ISSUED, ACTIVE or enabled.
+	Status string `json:"status,omitempty"`
+	// Type is how the certificate is managed, e.g. AMAZON_ISSUED,
+	// IMPORTED, managed or self-managed.
+	Type         string `json:"type,omitempty"`
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// InUse is whether the certificate is associated with another resource,
+	// e.g. a load balancer, for AWS.
+	InUse *bool `json:"inUse,omitempty"`
+}
+
+// LocationError is the error listing the certificates of a location.
+type LocationError struct {
+	Location string `json:"location"`
+	Error    string `json:"error"`
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the certificates of each location. The data gatherer only
+// fails if none of the locations can be listed.
+func (g *DataGatherer) Fetch() (interface{}, int, error) {
+	var locations []string
+	var list func(location string) ([]Certificate, error)
+	switch g.provider {
+	case ProviderAWS:
+		locations, list = g.regions, g.listACM
+	case ProviderGCP:
+		for _, project := range g.projects {
+			for _, location := range g.locations {
+				locations = append(locations, "projects/"+project+"/locations/"+location)
+			}
+		}
+		list = g.listCertificateManager
+	case ProviderAzure:
+		locations, list = g.vaults, g.listKeyVault
+	}
+
+	result := &CloudCertificates{Certificates: []Certificate{}}
+	for _, location := range locations {
+		certificates, err := list(location)
+		if err != nil {
+			result.Errors = append(result.Errors, LocationError{Location: location, Error: err.Error()})
+			continue
+		}
+		result.Certificates = append(result.Certificates, certificates...)
+	}
+	if len(locations) > 0 && len(result.Errors) == len(locations) {
+		return nil, -1, fmt.Errorf("failed to list the certificates of %s: %s", result.Errors[0].Location, result.Errors[0].Error)
+	}
+
+	sort.SliceStable(result.Certificates, func(i, j int) bool {
+		return result.Certificates[i].ID < result.Certificates[j].ID
+	})
+	return result, len(result.Certificates), nil
+}
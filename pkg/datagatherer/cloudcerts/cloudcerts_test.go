@@ -0,0 +1,216 @@
+package cloudcerts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func newTestDataGatherer(t *testing.T, config *Config, server *httptest.Server) *DataGatherer {
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g := dg.(*DataGatherer)
+	g.awsEndpoint = func(service, region string) string { return server.URL + "/" + region }
+	g.gcpEndpoint = server.URL
+	g.azure.IMDSEndpoint = server.URL
+	return g
+}
+
+func fetch(t *testing.T, g *DataGatherer) *CloudCertificates {
+	data, count, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result := data.(*CloudCertificates)
+	if count != len(result.Certificates) {
+		t.Errorf("expected a count of %d, got %d", len(result.Certificates), count)
+	}
+	return result
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestFetchAWS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/us-east-1/" {
+			http.Error(w, `{"__type":"AccessDeniedException"}`, http.StatusBadRequest)
+			return
+		}
+		var request struct {
+			NextToken string
+			Includes  struct{ KeyTypes []string }
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		if r.Header.Get("X-Amz-Target") != "CertificateManager.ListCertificates" ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/acm/") ||
+			len(request.Includes.KeyTypes) != len(acmKeyTypes) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if request.NextToken == "" {
+			fmt.Fprint(w, `{"CertificateSummaryList": [{"CertificateArn": "arn:aws:acm:eu-west-1:123:certificate/b", "DomainName": "example.com",
+				"SubjectAlternativeNameSummaries": ["example.com", "www.example.com"], "Status": "ISSUED", "Type": "AMAZON_ISSUED",
+				"KeyAlgorithm": "RSA-2048", "InUse": true, "NotBefore": 1700000000, "NotAfter": 1733000000}], "NextToken": "page-2"}`)
+			return
+		}
+		fmt.Fprint(w, `{"CertificateSummaryList": [{"CertificateArn": "arn:aws:acm:eu-west-1:123:certificate/a", "DomainName": "api.example.com",
+			"Status": "PENDING_VALIDATION", "Type": "AMAZON_ISSUED", "KeyAlgorithm": "EC-prime256v1", "InUse": false}]}`)
+	}))
+	defer server.Close()
+
+	g := newTestDataGatherer(t, &Config{Provider: ProviderAWS, Regions: []string{"eu-west-1", "us-east-1"}}, server)
+	expected := &CloudCertificates{
+		Certificates: []Certificate{
+			{
+				Provider:     ProviderAWS,
+				Location:     "eu-west-1",
+				ID:           "arn:aws:acm:eu-west-1:123:certificate/a",
+				Name:         "api.example.com",
+				Domains:      []string{"api.example.com"},
+				Status:       "PENDING_VALIDATION",
+				Type:         "AMAZON_ISSUED",
+				KeyAlgorithm: "EC-prime256v1",
+				InUse:        boolPtr(false),
+			},
+			{
+				Provider:     ProviderAWS,
+				Location:     "eu-west-1",
+				ID:           "arn:aws:acm:eu-west-1:123:certificate/b",
+				Name:         "example.com",
+				Domains:      []string{"example.com", "www.example.com"},
+				NotBefore:    timePtr(time.Unix(1700000000, 0).UTC()),
+				NotAfter:     timePtr(time.Unix(1733000000, 0).UTC()),
+				Status:       "ISSUED",
+				Type:         "AMAZON_ISSUED",
+				KeyAlgorithm: "RSA-2048",
+				InUse:        boolPtr(true),
+			},
+		},
+		Errors: []LocationError{
+			{Location: "us-east-1", Error: `received response with status code 400. Body: [{"__type":"AccessDeniedException"}]`},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, fetch(t, g)); !equal {
+		t.Errorf("unexpected certificates:\n%s", diff)
+	}
+}
+
+func TestFetchGCP(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "gcp-token")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/locations/global/certificates" || r.Header.Get("Authorization") != "Bearer gcp-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"certificates": [
+			{"name": "projects/p/locations/global/certificates/web", "sanDnsnames": ["example.com"], "expireTime": "2024-12-01T00:00:00Z", "managed": {"state": "ACTIVE"}},
+			{"name": "projects/p/locations/global/certificates/legacy", "selfManaged": {}}]}`)
+	}))
+	defer server.Close()
+
+	g := newTestDataGatherer(t, &Config{Provider: ProviderGCP, Projects: []string{"p"}}, server)
+	expected := &CloudCertificates{
+		Certificates: []Certificate{
+			{
+				Provider: ProviderGCP,
+				Location: "projects/p/locations/global",
+				ID:       "projects/p/locations/global/certificates/legacy",
+				Name:     "legacy",
+				Type:     "self-managed",
+			},
+			{
+				Provider: ProviderGCP,
+				Location: "projects/p/locations/global",
+				ID:       "projects/p/locations/global/certificates/web",
+				Name:     "web",
+				Domains:  []string{"example.com"},
+				NotAfter: timePtr(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)),
+				Status:   "ACTIVE",
+				Type:     "managed",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, fetch(t, g)); !equal {
+		t.Errorf("unexpected certificates:\n%s", diff)
+	}
+}
+
+func TestFetchAzure(t *testing.T) {
+	t.Setenv("AZURE_FEDERATED_TOKEN_FILE", "")
+	t.Setenv("AZURE_CLIENT_ID", "")
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/metadata/identity/oauth2/token":
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != keyVaultResource {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "azure-token", "expires_in": "3599"}`)
+		case r.Header.Get("Authorization") != "Bearer azure-token":
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/certificates" && r.URL.Query().Get("page") == "":
+			fmt.Fprintf(w, `{"value": [{"id": "%[1]s/certificates/web", "attributes": {"enabled": true, "nbf": 1700000000, "exp": 1733000000}}],
+				"nextLink": "%[1]s/certificates?api-version=7.4&page=2"}`, server.URL)
+		case r.URL.Path == "/certificates":
+			fmt.Fprintf(w, `{"value": [{"id": "%s/certificates/old", "attributes": {"enabled": false}}]}`, server.URL)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	g := newTestDataGatherer(t, &Config{Provider: ProviderAzure, Vaults: []string{server.URL}}, server)
+	expected := &CloudCertificates{
+		Certificates: []Certificate{
+			{
+				Provider: ProviderAzure,
+				Location: server.URL,
+				ID:       server.URL + "/certificates/old",
+				Name:     "old",
+				Status:   "disabled",
+			},
+			{
+				Provider:  ProviderAzure,
+				Location:  server.URL,
+				ID:        server.URL + "/certificates/web",
+				Name:      "web",
+				NotBefore: timePtr(time.Unix(1700000000, 0).UTC()),
+				NotAfter:  timePtr(time.Unix(1733000000, 0).UTC()),
+				Status:    "enabled",
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, fetch(t, g)); !equal {
+		t.Errorf("unexpected certificates:\n%s", diff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := (&Config{Provider: ProviderAzure, Regions: []string{"eu-west-1"}, Vaults: []string{"vault"}}).Validate()
+	expected := `invalid vault url "vault", regions can only be set for aws`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package cloudcerts
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// gcpCertificate is a certificate of GCP Certificate Manager.
+type gcpCertificate struct {
+	Name        string     `json:"name"`
+	SANDNSNames []string   `json:"sanDnsnames"`
+	ExpireTime  *time.Time `json:"expireTime"`
+	Managed     *struct {
+		State string `json:"state"`
+	} `json:"managed"`
+	SelfManaged *struct{} `json:"selfManaged"`
+}
+
+// listCertificateManager lists the certificates of GCP Certificate Manager
+// in a location, projects/<project>/locations/<location>.
+func (g *DataGatherer) listCertificateManager(location string) ([]Certificate, error) {
+	token, err := g.gcp.AccessToken(g.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, g.gcpEndpoint+"/v1/"+location+"/certificates?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		var response struct {
+			Certificates  []gcpCertificate `json:"certificates"`
+			NextPageToken string           `json:"nextPageToken"`
+		}
+		if err := g.do(req, &response); err != nil {
+			return nil, err
+		}
+		for _, c := range response.Certificates {
+			certificate := Certificate{
+				Provider: ProviderGCP,
+				Location: location,
+				ID:       c.Name,
+				Name:     lastSegment(c.Name),
+				Domains:  c.SANDNSNames,
+				NotAfter: c.ExpireTime,
+			}
+			switch {
+			case c.Managed != nil:
+				certificate.Type, certificate.Status = "managed", c.Managed.State
+			case c.SelfManaged != nil:
+				certificate.Type = "self-managed"
+			}
+			certificates = append(certificates, certificate)
+		}
+		if response.NextPageToken == "" {
+			return certificates, nil
+		}
+		pageToken = response.NextPageToken
+	}
+}
@@ -23,3 +23,11 @@ type DataGatherer interface {
 	// Delete, clear the cache of the DataGatherer if one is being used
 	Delete() error
 }
+
+// Summarizer is implemented by the DataGatherers that gather raw objects and
+// can summarize them, so that only derived results are uploaded in the
+// findings report mode.
+type Summarizer interface {
+	// Summarize returns the summary of data, as returned by Fetch.
+	Summarize(data interface{}) (interface{}, error)
+}
@@ -0,0 +1,216 @@
+// Package endpoint contains the data gatherers that fetch the data served by
+// HTTP endpoints: any endpoint, e.g. an internal inventory service, and the
+// query API of Prometheus.
+package endpoint
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultTimeout is the timeout of the requests.
+	defaultTimeout = 30 * time.Second
+	// defaultAuthHeader is the header the credentials are sent in.
+	defaultAuthHeader = "Authorization"
+	// maxResponseBytes is the size of the body above which the data gatherer
+	// fails, so that a misconfigured URL doesn't exhaust the memory of the
+	// agent.
+	maxResponseBytes = 10 << 20
+)
+
+// Config is the configuration of the http data gatherer.
+type Config struct {
+	// URL is the URL fetched with a GET request.
+	URL string `yaml:"url"`
+	// Headers are sent with the request.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// CredentialsPath is the path to the file holding the value of the
+	// AuthHeader, e.g. "Bearer <token>". The file is read before each Fetch,
+	// so that the credentials can be rotated.
+	CredentialsPath string `yaml:"credentials-path,omitempty"`
+	// AuthHeader is the header the credentials are sent in. Defaults to
+	// Authorization.
+	AuthHeader string `yaml:"auth-header,omitempty"`
+	// CAPath is the path to the PEM encoded CA certificates the server
+	// certificate is verified with, instead of the system ones.
+	CAPath string `yaml:"ca-path,omitempty"`
+	// Timeout is the timeout of the request. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Format is the format of the body, json or text. Defaults to json.
+	Format string `yaml:"format,omitempty"`
+}
+
+// Validate checks the configuration, without connecting to the endpoint, so
+// that mistakes are reported when the agent config is parsed.
+func (c *Config) Validate() error {
+	var errors []string
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors = append(errors, fmt.Sprintf("invalid url %q", c.URL))
+	}
+	if c.AuthHeader != "" && c.CredentialsPath == "" {
+		errors = append(errors, "auth-header requires credentials-path")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+	if c.Format != "" && c.Format != "json" && c.Format != "text" {
+		errors = append(errors, fmt.Sprintf("format must be json or text, not %q", c.Format))
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new DataGatherer.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(c.CAPath, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &DataGatherer{
+		ctx:             ctx,
+		url:             c.URL,
+		headers:         c.Headers,
+		credentialsPath: c.CredentialsPath,
+		authHeader:      c.AuthHeader,
+		text:            c.Format == "text",
+		client:          client,
+	}
+	if g.authHeader == "" {
+		g.authHeader = defaultAuthHeader
+	}
+	return g, nil
+}
+
+// newClient returns a client verifying the certificates of the servers with
+// the CA certificates of caPath, if set, with the timeout, or the default
+// one.
+func newClient(caPath string, timeout time.Duration) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caPath != "" {
+		data, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA certificates: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM encoded certificates in %s", caPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	if timeout == 0 {
+		timeout = defaultTimeout
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// setCredentials sets the header to the credentials read from path.
+func setCredentials(req *http.Request, path, header string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the credentials: %w", err)
+	}
+	req.Header.Set(header, strings.TrimSpace(string(data)))
+	return nil
+}
+
+// get sends the request and returns the body of its 2xx response.
+func get(client *http.Client, req *http.Request) ([]byte, error) {
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the response: %w", err)
+	}
+	if len(body) > maxResponseBytes {
+		return nil, fmt.Errorf("the response of %s exceeds %d bytes", req.URL.Redacted(), maxResponseBytes)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, fmt.Errorf("received response with status code %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// DataGatherer fetches the data served by an HTTP endpoint.
+type DataGatherer struct {
+	ctx             context.Context
+	url             string
+	headers         map[string]string
+	credentialsPath string
+	authHeader      string
+	text            bool
+	client          *http.Client
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch gets the URL and returns the body, parsed unless its format is
+// text. Responses other than 2xx fail the data gatherer.
+func (g *DataGatherer) Fetch() (interface{}, int, error) {
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, g.url, nil)
+	if err != nil {
+		return nil, -1, err
+	}
+	for name, value := range g.headers {
+		req.Header.Set(name, value)
+	}
+	if g.credentialsPath != "" {
+		if err := setCredentials(req, g.credentialsPath, g.authHeader); err != nil {
+			return nil, -1, err
+		}
+	}
+	if !g.text {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	body, err := get(g.client, req)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if g.text {
+		return string(body), -1, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, -1, fmt.Errorf("failed to parse the response of %s: %w", g.url, err)
+	}
+	return data, -1, nil
+}
@@ -0,0 +1,93 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fetch(config *Config) (interface{}, error) {
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := dg.Fetch()
+	return data, err
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Tenant") != "example" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/inventory":
+			w.Write([]byte(`{"services": ["a", "b"]}`))
+		case "/metrics":
+			w.Write([]byte("certmanager_certificate_ready_status 1\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	caPath := writeFile(t, "ca.crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	config := &Config{
+		URL:             server.URL + "/inventory",
+		Headers:         map[string]string{"X-Tenant": "example"},
+		CredentialsPath: writeFile(t, "token", "Bearer token\n"),
+		CAPath:          caPath,
+	}
+	data, err := fetch(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{"services": []interface{}{"a", "b"}}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+
+	config.URL, config.Format = server.URL+"/metrics", "text"
+	data, err = fetch(config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if data != "certmanager_certificate_ready_status 1\n" {
+		t.Errorf("unexpected data: %q", data)
+	}
+
+	config.URL = server.URL + "/missing"
+	if _, err := fetch(config); err == nil || !strings.HasPrefix(err.Error(), "received response with status code 404") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the server certificate isn't trusted without the CA
+	config.URL, config.CAPath = server.URL+"/inventory", ""
+	if _, err := fetch(config); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	err := (&Config{URL: "ftp://example.com", AuthHeader: "X-Token", Format: "xml"}).Validate()
+	expected := `invalid url "ftp://example.com", auth-header requires credentials-path, format must be json or text, not "xml"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package endpoint
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// prometheusQueryPath is the path of the instant queries of the Prometheus
+// HTTP API.
+const prometheusQueryPath = "/api/v1/query"
+
+// PrometheusConfig is the configuration of the prometheus data gatherer.
+type PrometheusConfig struct {
+	// URL is the base URL of Prometheus, e.g.
+	// http://prometheus-operated.monitoring:9090.
+	URL string `yaml:"url"`
+	// Queries are the PromQL instant queries run on every Fetch.
+	Queries []PrometheusQuery `yaml:"queries"`
+	// CredentialsPath is the path to the file holding the value of the
+	// AuthHeader, e.g. "Bearer <token>". The file is read before each Fetch,
+	// so that the credentials can be rotated.
+	CredentialsPath string `yaml:"credentials-path,omitempty"`
+	// AuthHeader is the header the credentials are sent in. Defaults to
+	// Authorization.
+	AuthHeader string `yaml:"auth-header,omitempty"`
+	// CAPath is the path to the PEM encoded CA certificates the server
+	// certificate is verified with, instead of the system ones.
+	CAPath string `yaml:"ca-path,omitempty"`
+	// Timeout is the timeout of each query. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// PrometheusQuery is a named PromQL instant query.
+type PrometheusQuery struct {
+	Name  string `yaml:"name"`
+	Query string `yaml:"query"`
+}
+
+// Validate checks the configuration, without connecting to Prometheus, so
+// that mistakes are reported when the agent config is parsed.
+func (c *PrometheusConfig) Validate() error {
+	var errors []string
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errors = append(errors, fmt.Sprintf("invalid url %q", c.URL))
+	}
+	if len(c.Queries) == 0 {
+		errors = append(errors, "at least one query is required")
+	}
+	names := map[string]bool{}
+	for i, query := range c.Queries {
+		if query.Name == "" || query.Query == "" {
+			errors = append(errors, fmt.Sprintf("queries[%d] must have a name and a query", i))
+		}
+		if names[query.Name] {
+			errors = append(errors, fmt.Sprintf("query %q is configured more than once", query.Name))
+		}
+		names[query.Name] = true
+	}
+	if c.AuthHeader != "" && c.CredentialsPath == "" {
+		errors = append(errors, "auth-header requires credentials-path")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new PrometheusDataGatherer.
+func (c *PrometheusConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(c.CAPath, c.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &PrometheusDataGatherer{
+		ctx:             ctx,
+		queryURL:        strings.TrimSuffix(c.URL, "/") + prometheusQueryPath,
+		queries:         c.Queries,
+		credentialsPath: c.CredentialsPath,
+		authHeader:      c.AuthHeader,
+		client:          client,
+	}
+	if g.authHeader == "" {
+		g.authHeader = defaultAuthHeader
+	}
+	return g, nil
+}
+
+// PrometheusDataGatherer runs PromQL instant queries, so that metrics such
+// as certificate_expiration_timestamp_seconds can be included in the
+// reports without an exporter pipeline.
+type PrometheusDataGatherer struct {
+	ctx             context.Context
+	queryURL        string
+	queries         []PrometheusQuery
+	credentialsPath string
+	authHeader      string
+	client          *http.Client
+}
+
+// PrometheusResult is the result of a query.
+type PrometheusResult struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+	// Error is the error running the query, if any.
+	Error string `json:"error,omitempty"`
+	// ResultType is vector or scalar.
+	ResultType string             `json:"resultType,omitempty"`
+	Samples    []PrometheusSample `json:"samples,omitempty"`
+}
+
+// PrometheusSample is a sample of the result of a query. The value is kept
+// as the string Prometheus returns, since it can be NaN or infinite.
+type PrometheusSample struct {
+	Metric    map[string]string `json:"metric,omitempty"`
+	Value     string            `json:"value"`
+	Timestamp float64           `json:"timestamp"`
+}
+
+func (g *PrometheusDataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *PrometheusDataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *PrometheusDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch runs each query. The errors of the queries are reported in their
+// result, so that one failing query doesn't hide the others. The count is
+// the number of samples.
+func (g *PrometheusDataGatherer) Fetch() (interface{}, int, error) {
+	results := make([]*PrometheusResult, 0, len(g.queries))
+	count := 0
+	for _, query := range g.queries {
+		result, err := g.query(query)
+		if err != nil {
+			result = &PrometheusResult{Name: query.Name, Query: query.Query, Error: err.Error()}
+		}
+		count += len(result.Samples)
+		results = append(results, result)
+	}
+	return map[string]interface{}{"results": results}, count, nil
+}
+
+// prometheusResponse is the response of the Prometheus HTTP API to an
+// instant query.
+type prometheusResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// query runs an instant query.
+func (g *PrometheusDataGatherer) query(query PrometheusQuery) (*PrometheusResult, error) {
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, g.queryURL+"?"+url.Values{"query": {query.Query}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if g.credentialsPath != "" {
+		if err := setCredentials(req, g.credentialsPath, g.authHeader); err != nil {
+			return nil, err
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	body, err := get(g.client, req)
+	if err != nil {
+		return nil, err
+	}
+	var response prometheusResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse the response: %w", err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("query failed: %s", response.Error)
+	}
+
+	result := &PrometheusResult{Name: query.Name, Query: query.Query, ResultType: response.Data.ResultType}
+	switch response.Data.ResultType {
+	case "vector":
+		var vector []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]interface{}    `json:"value"`
+		}
+		if err := json.Unmarshal(response.Data.Result, &vector); err != nil {
+			return nil, fmt.Errorf("failed to parse the vector: %w", err)
+		}
+		for _, v := range vector {
+			sample, err := prometheusSample(v.Value)
+			if err != nil {
+				return nil, err
+			}
+			sample.Metric = v.Metric
+			result.Samples = append(result.Samples, sample)
+		}
+	case "scalar":
+		var scalar [2]interface{}
+		if err := json.Unmarshal(response.Data.Result, &scalar); err != nil {
+			return nil, fmt.Errorf("failed to parse the scalar: %w", err)
+		}
+		sample, err := prometheusSample(scalar)
+		if err != nil {
+			return nil, err
+		}
+		result.Samples = append(result.Samples, sample)
+	default:
+		return nil, fmt.Errorf("unsupported result type %q", response.Data.ResultType)
+	}
+	return result, nil
+}
+
+// prometheusSample converts a [timestamp, "value"] pair of the Prometheus
+// HTTP API.
+func prometheusSample(value [2]interface{}) (PrometheusSample, error) {
+	timestamp, ok := value[0].(float64)
+	if !ok {
+		return PrometheusSample{}, fmt.Errorf("invalid sample timestamp %v", value[0])
+	}
+	v, ok := value[1].(string)
+	if !ok {
+		return PrometheusSample{}, fmt.Errorf("invalid sample value %v", value[1])
+	}
+	return PrometheusSample{Value: v, Timestamp: timestamp}, nil
+}
@@ -0,0 +1,86 @@
+package endpoint
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestPrometheusFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != prometheusQueryPath || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("query") {
+		case "certmanager_certificate_expiration_timestamp_seconds":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[
+				{"metric":{"name":"web","namespace":"default"},"value":[1700000000.5,"1702592000"]},
+				{"metric":{"name":"api","namespace":"default"},"value":[1700000000.5,"NaN"]}]}}`))
+		case "scalar(1)":
+			w.Write([]byte(`{"status":"success","data":{"resultType":"scalar","result":[1700000000.5,"1"]}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+		}
+	}))
+	defer server.Close()
+
+	config := &PrometheusConfig{
+		URL: server.URL + "/",
+		Queries: []PrometheusQuery{
+			{Name: "expiry", Query: "certmanager_certificate_expiration_timestamp_seconds"},
+			{Name: "one", Query: "scalar(1)"},
+			{Name: "invalid", Query: "sum("},
+		},
+		CredentialsPath: writeFile(t, "token", "Bearer token"),
+	}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 samples, got %d", count)
+	}
+
+	expected := map[string]interface{}{"results": []*PrometheusResult{
+		{
+			Name:       "expiry",
+			Query:      "certmanager_certificate_expiration_timestamp_seconds",
+			ResultType: "vector",
+			Samples: []PrometheusSample{
+				{Metric: map[string]string{"name": "web", "namespace": "default"}, Value: "1702592000", Timestamp: 1700000000.5},
+				{Metric: map[string]string{"name": "api", "namespace": "default"}, Value: "NaN", Timestamp: 1700000000.5},
+			},
+		},
+		{
+			Name:       "one",
+			Query:      "scalar(1)",
+			ResultType: "scalar",
+			Samples:    []PrometheusSample{{Value: "1", Timestamp: 1700000000.5}},
+		},
+		{
+			Name:  "invalid",
+			Query: "sum(",
+			Error: `received response with status code 400: {"status":"error","errorType":"bad_data","error":"parse error"}`,
+		},
+	}}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestPrometheusValidate(t *testing.T) {
+	err := (&PrometheusConfig{URL: "prometheus:9090", Queries: []PrometheusQuery{{Name: "a", Query: "up"}, {Name: "a", Query: "up"}}}).Validate()
+	expected := `invalid url "prometheus:9090", query "a" is configured more than once`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
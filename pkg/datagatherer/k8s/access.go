@@ -0,0 +1,439 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// accessVerbs are the verbs the informers of the dynamic data gatherer need.
+var accessVerbs = []string{"list", "watch"}
+
+// Permission is an access to the Kubernetes API a data gatherer needs.
+type Permission struct {
+	Verb                 string
+	GroupVersionResource schema.GroupVersionResource
+	// Namespace is empty for cluster scoped resources, or for namespaced
+	// resources in all namespaces.
+	Namespace string
+	// Name restricts the permission to a single object, if not empty.
+	Name string
+	// Subresource is the subresource of the resource, e.g. log for pods.
+	Subresource string
+}
+
+// String describes the permission, e.g. `list "/v1, Resource=pods" in all namespaces`.
+func (p Permission) String() string {
+	resource := fmt.Sprintf("%q", p.GroupVersionResource)
+	if p.Subresource != "" {
+		resource = fmt.Sprintf("%s subresource %q", resource, p.Subresource)
+	}
+	if p.Name != "" {
+		resource = fmt.Sprintf("%s %q", resource, p.Name)
+	}
+	return fmt.Sprintf("%s %s %s", p.Verb, resource, scopeDescription(p.Namespace))
+}
+
+// PermissionCheck is the result of the review of a permission.
+type PermissionCheck struct {
+	Permission
+	Allowed bool
+	// Reason is the explanation of the authorizer, if any.
+	Reason string
+}
+
+// reviewPermissions uses SelfSubjectAccessReviews to check whether the agent
+// has each of the permissions.
+func reviewPermissions(ctx context.Context, kubeconfigPath string, permissions []Permission) ([]PermissionCheck, error) {
+	clientset, err := NewClientSet(ctx, kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return checkPermissions(ctx, clientset, permissions)
+}
+
+func checkPermissions(ctx context.Context, clientset kubernetes.Interface, permissions []Permission) ([]PermissionCheck, error) {
+	checks := make([]PermissionCheck, 0, len(permissions))
+	for _, permission := range permissions {
+		check, err := reviewPermission(ctx, clientset, permission)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+func reviewPermission(ctx context.Context, clientset kubernetes.Interface, permission Permission) (PermissionCheck, error) {
+	review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace:   permission.Namespace,
+				Verb:        permission.Verb,
+				Group:       permission.GroupVersionResource.Group,
+				Version:     permission.GroupVersionResource.Version,
+				Resource:    permission.GroupVersionResource.Resource,
+				Subresource: permission.Subresource,
+				Name:        permission.Name,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return PermissionCheck{}, fmt.Errorf("failed to review access to %q: %w", permission.GroupVersionResource, err)
+	}
+	return PermissionCheck{Permission: permission, Allowed: review.Status.Allowed, Reason: review.Status.Reason}, nil
+}
+
+// checkAccess uses SelfSubjectAccessReviews to check that the agent is allowed
+// to list and watch the resource in each of the namespaces, where an empty
+// namespace means all namespaces. This reports missing RBAC permissions when
+// the data gatherer is created, rather than as failing requests later on.
+func checkAccess(ctx context.Context, clientset kubernetes.Interface, gvr schema.GroupVersionResource, namespaces []string) error {
+	for _, permission := range resourcePermissions(gvr, namespaces) {
+		check, err := reviewPermission(ctx, clientset, permission)
+		if err != nil {
+			// the review itself can fail, for instance on API servers
+			// without the authorization API, in which case any missing
+			// permission is reported by the informer
+			log.Printf("failed to check access to %q: %s", gvr, err)
+			return nil
+		}
+		if !check.Allowed {
+			return fmt.Errorf("the agent is not allowed to %s, check its RBAC permissions%s", permission, reasonDescription(check.Reason))
+		}
+	}
+	return nil
+}
+
+// resourcePermissions are the permissions to list and watch the resource in
+// each of the namespaces.
+func resourcePermissions(gvr schema.GroupVersionResource, namespaces []string) []Permission {
+	var permissions []Permission
+	for _, namespace := range namespaces {
+		for _, verb := range accessVerbs {
+			permissions = append(permissions, Permission{Verb: verb, GroupVersionResource: gvr, Namespace: namespace})
+		}
+	}
+	return permissions
+}
+
+// listPermissions are the permissions to list each of the resources in all
+// namespaces, for the data gatherers that list rather than watch resources.
+func listPermissions(gvrs ...schema.GroupVersionResource) []Permission {
+	var permissions []Permission
+	for _, gvr := range gvrs {
+		permissions = append(permissions, Permission{Verb: "list", GroupVersionResource: gvr})
+	}
+	return permissions
+}
+
+// namespacesGVR is listed to resolve namespace label selectors.
+var namespacesGVR = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigDynamic) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigDynamic) permissions() []Permission {
+	var permissions []Permission
+	if c.IncludeNamespaceLabelSelector != "" {
+		permissions = append(permissions, listPermissions(namespacesGVR)...)
+	}
+	for _, gvr := range c.GroupVersionResources() {
+		permissions = append(permissions, resourcePermissions(gvr, c.accessNamespaces())...)
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs to gather
+// the resources currently served in its API groups.
+func (c *ConfigCertManager) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	discoveryClient, err := NewDiscoveryClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	dynamicConfig, err := c.dynamicConfig(discoveryClient)
+	if err != nil || dynamicConfig == nil {
+		return nil, err
+	}
+	return dynamicConfig.CheckPermissions(ctx)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigRBAC) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigRBAC) permissions() []Permission {
+	return listPermissions(
+		rbacv1.SchemeGroupVersion.WithResource("roles"),
+		rbacv1.SchemeGroupVersion.WithResource("clusterroles"),
+		rbacv1.SchemeGroupVersion.WithResource("rolebindings"),
+		rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings"),
+	)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigWebhooks) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigWebhooks) permissions() []Permission {
+	return listPermissions(
+		admissionregistrationv1.SchemeGroupVersion.WithResource("validatingwebhookconfigurations"),
+		admissionregistrationv1.SchemeGroupVersion.WithResource("mutatingwebhookconfigurations"),
+	)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigKeyHygiene) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigKeyHygiene) permissions() []Permission {
+	return listPermissions(corev1.SchemeGroupVersion.WithResource("secrets"))
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigIngressTLSPolicy) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigIngressTLSPolicy) permissions() []Permission {
+	permissions := listPermissions(networkingv1.SchemeGroupVersion.WithResource("ingresses"))
+	permissions = append(permissions, listPermissions(traefikTLSOptions...)...)
+
+	configMaps := c.ConfigMaps
+	if len(configMaps) == 0 {
+		configMaps = defaultIngressControllerConfigMaps
+	}
+	for _, cm := range configMaps {
+		permissions = append(permissions, Permission{
+			Verb:                 "get",
+			GroupVersionResource: corev1.SchemeGroupVersion.WithResource("configmaps"),
+			Namespace:            cm.Namespace,
+			Name:                 cm.Name,
+		})
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigCertManagerLogs) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigCertManagerLogs) permissions() []Permission {
+	namespace := c.Namespace
+	if namespace == "" {
+		namespace = defaultCertManagerLogsNamespace
+	}
+	pods := corev1.SchemeGroupVersion.WithResource("pods")
+	return []Permission{
+		{Verb: "list", GroupVersionResource: pods, Namespace: namespace},
+		{Verb: "get", GroupVersionResource: pods, Namespace: namespace, Subresource: "log"},
+	}
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigCertManagerEvents) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigCertManagerEvents) permissions() []Permission {
+	return listPermissions(corev1.SchemeGroupVersion.WithResource("events"))
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigPodSecurity) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigPodSecurity) permissions() []Permission {
+	return listPermissions(
+		appsv1.SchemeGroupVersion.WithResource("deployments"),
+		appsv1.SchemeGroupVersion.WithResource("statefulsets"),
+		appsv1.SchemeGroupVersion.WithResource("daemonsets"),
+		batchv1.SchemeGroupVersion.WithResource("cronjobs"),
+		batchv1.SchemeGroupVersion.WithResource("jobs"),
+		corev1.SchemeGroupVersion.WithResource("pods"),
+	)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigResourceCounts) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigResourceCounts) permissions() []Permission {
+	gvrs := c.countedResources()
+	if !c.DisableResourceQuotas {
+		gvrs = append(gvrs[:len(gvrs):len(gvrs)], resourceQuotasGVR)
+	}
+	return listPermissions(gvrs...)
+}
+
+func (c *ConfigEncryptionAtRest) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigEncryptionAtRest) permissions() []Permission {
+	permissions := []Permission{
+		{Verb: "list", GroupVersionResource: corev1.SchemeGroupVersion.WithResource("pods"), Namespace: metav1.NamespaceSystem},
+		{Verb: "list", GroupVersionResource: corev1.SchemeGroupVersion.WithResource("nodes")},
+	}
+	if cm := c.EncryptionConfigMap; cm != nil {
+		permissions = append(permissions, Permission{
+			Verb:                 "get",
+			GroupVersionResource: corev1.SchemeGroupVersion.WithResource("configmaps"),
+			Namespace:            cm.Namespace,
+			Name:                 cm.Name,
+		})
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigHelmReleases) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigHelmReleases) permissions() []Permission {
+	return listPermissions(corev1.SchemeGroupVersion.WithResource("secrets"))
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigCRDs) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigCRDs) permissions() []Permission {
+	return listPermissions(crdGVR)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs. Listing
+// the apirequestcounts is not reviewed, as they are only served on OpenShift.
+func (c *ConfigAPIDeprecations) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigAPIDeprecations) permissions() []Permission {
+	return listPermissions(auditedResources()...)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigIstio) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigIstio) permissions() []Permission {
+	return listPermissions(istioGVRs...)
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigIngressTLS) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigTLSProbe) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigTLSProbe) permissions() []Permission {
+	var permissions []Permission
+	secrets := c.IngressHosts
+	if c.IngressHosts {
+		permissions = listPermissions(networkingv1.SchemeGroupVersion.WithResource("ingresses"))
+	}
+	for _, endpoint := range c.Endpoints {
+		secrets = secrets || endpoint.Secret != ""
+	}
+	if secrets {
+		permissions = append(permissions, Permission{Verb: "get", GroupVersionResource: corev1.SchemeGroupVersion.WithResource("secrets")})
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigControlPlaneCertificates) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigControlPlaneCertificates) permissions() []Permission {
+	permissions := listPermissions(certificatesv1.SchemeGroupVersion.WithResource("certificatesigningrequests"))
+	if !c.DisableProbes {
+		permissions = append(permissions, listPermissions(corev1.SchemeGroupVersion.WithResource("nodes"))...)
+		permissions = append(permissions, Permission{
+			Verb:                 "get",
+			GroupVersionResource: corev1.SchemeGroupVersion.WithResource("endpoints"),
+			Namespace:            metav1.NamespaceDefault,
+			Name:                 "kubernetes",
+		})
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigIssuerHealth) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigIssuerHealth) permissions() []Permission {
+	permissions := listPermissions(clusterIssuersGVR, issuersGVR)
+	if c.CheckACMEAccountSecrets {
+		permissions = append(permissions, Permission{Verb: "get", GroupVersionResource: corev1.SchemeGroupVersion.WithResource("secrets")})
+	}
+	return permissions
+}
+
+// CheckPermissions reviews the permissions the data gatherer needs.
+func (c *ConfigIssuance) CheckPermissions(ctx context.Context) ([]PermissionCheck, error) {
+	return reviewPermissions(ctx, c.KubeConfigPath, c.permissions())
+}
+
+func (c *ConfigIssuance) permissions() []Permission {
+	return listPermissions(certificateRequestsGVR, ordersGVR, challengesGVR)
+}
+
+func (c *ConfigIngressTLS) permissions() []Permission {
+	permissions := listPermissions(append([]schema.GroupVersionResource{networkingv1.SchemeGroupVersion.WithResource("ingresses")}, gatewayAPIGVRs...)...)
+	return append(permissions, Permission{Verb: "get", GroupVersionResource: corev1.SchemeGroupVersion.WithResource("secrets")})
+}
+
+// accessNamespaces returns the namespaces in which the data gatherer needs
+// access: the included namespaces if they are all plain names, or else all
+// namespaces.
+func (c *ConfigDynamic) accessNamespaces() []string {
+	if len(c.IncludeNamespaces) == 0 || hasNamespacePatterns(c.IncludeNamespaces) || c.IncludeNamespaceLabelSelector != "" {
+		return []string{metav1.NamespaceAll}
+	}
+	return c.IncludeNamespaces
+}
+
+func scopeDescription(namespace string) string {
+	if namespace == "" {
+		return "in all namespaces"
+	}
+	return fmt.Sprintf("in namespace %q", namespace)
+}
+
+func reasonDescription(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ": " + reason
+}
@@ -0,0 +1,170 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	podsGVR := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+	tests := map[string]struct {
+		namespaces []string
+		// denied is the verb and namespace that are not allowed
+		denied        string
+		reviewError   error
+		expectedError string
+	}{
+		"allowed in all namespaces": {
+			namespaces: []string{""},
+		},
+		"allowed in included namespaces": {
+			namespaces: []string{"default", "kube-system"},
+			denied:     "list/",
+		},
+		"watch forbidden in namespace": {
+			namespaces:    []string{"default", "kube-system"},
+			denied:        "watch/kube-system",
+			expectedError: `the agent is not allowed to watch "/v1, Resource=pods" in namespace "kube-system", check its RBAC permissions: no RBAC policy matched`,
+		},
+		"list forbidden in all namespaces": {
+			namespaces:    []string{""},
+			denied:        "list/",
+			expectedError: `the agent is not allowed to list "/v1, Resource=pods" in all namespaces, check its RBAC permissions: no RBAC policy matched`,
+		},
+		"review failed": {
+			namespaces:  []string{"kube-system"},
+			reviewError: fmt.Errorf("the server could not find the requested resource"),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			clientset := fakeclientset.NewSimpleClientset()
+			clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if tc.reviewError != nil {
+					return true, nil, tc.reviewError
+				}
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				review.Status.Allowed = tc.denied != attributes.Verb+"/"+attributes.Namespace
+				if !review.Status.Allowed {
+					review.Status.Reason = "no RBAC policy matched"
+				}
+				return true, review, nil
+			})
+
+			err := checkAccess(context.Background(), clientset, podsGVR, tc.namespaces)
+			if tc.expectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("expected error %q, got %v", tc.expectedError, err)
+			}
+		})
+	}
+}
+
+func TestAccessNamespaces(t *testing.T) {
+	tests := map[string]struct {
+		config   ConfigDynamic
+		expected []string
+	}{
+		"all namespaces":      {config: ConfigDynamic{}, expected: []string{""}},
+		"excluded namespaces": {config: ConfigDynamic{ExcludeNamespaces: []string{"kube-system"}}, expected: []string{""}},
+		"included namespaces": {config: ConfigDynamic{IncludeNamespaces: []string{"a", "b"}}, expected: []string{"a", "b"}},
+		"included patterns":   {config: ConfigDynamic{IncludeNamespaces: []string{"team-*"}}, expected: []string{""}},
+		"label selector": {
+			config:   ConfigDynamic{IncludeNamespaces: []string{"a"}, IncludeNamespaceLabelSelector: "monitored=true"},
+			expected: []string{""},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := tc.config.accessNamespaces()
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	secrets := Permission{Verb: "list", GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}}
+	configMap := Permission{
+		Verb:                 "get",
+		GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+		Namespace:            "ingress-nginx",
+		Name:                 "ingress-nginx-controller",
+	}
+
+	clientset := fakeclientset.NewSimpleClientset()
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = review.Spec.ResourceAttributes.Resource != "secrets"
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+
+	checks, err := checkPermissions(context.Background(), clientset, []Permission{secrets, configMap})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []PermissionCheck{
+		{Permission: secrets, Allowed: false, Reason: "no RBAC policy matched"},
+		{Permission: configMap, Allowed: true},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, checks); !equal {
+		t.Errorf("unexpected checks:\n%s", diff)
+	}
+
+	if got, want := configMap.String(), `get "/v1, Resource=configmaps" "ingress-nginx-controller" in namespace "ingress-nginx"`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// unlike when creating a data gatherer, a failed review is an error
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("the server could not find the requested resource")
+	})
+	if _, err := checkPermissions(context.Background(), clientset, []Permission{secrets}); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestDynamicPermissions(t *testing.T) {
+	config := ConfigDynamic{
+		GroupVersionResource:            schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+		AdditionalGroupVersionResources: []schema.GroupVersionResource{{Version: "v1", Resource: "services"}},
+		IncludeNamespaceLabelSelector:   "monitored=true",
+	}
+
+	var got []string
+	for _, permission := range config.permissions() {
+		got = append(got, permission.String())
+	}
+	expected := []string{
+		`list "/v1, Resource=namespaces" in all namespaces`,
+		`list "/v1, Resource=pods" in all namespaces`,
+		`watch "/v1, Resource=pods" in all namespaces`,
+		`list "/v1, Resource=services" in all namespaces`,
+		`watch "/v1, Resource=services" in all namespaces`,
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, got); !equal {
+		t.Errorf("unexpected permissions:\n%s", diff)
+	}
+}
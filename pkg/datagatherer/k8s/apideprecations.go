@@ -0,0 +1,386 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// APIDeprecationFindingUsage is reported for the objects managed with a
+	// deprecated API version that the cluster still serves.
+	APIDeprecationFindingUsage = "deprecated-api-usage"
+	// APIDeprecationFindingRequests is reported for the deprecated API
+	// versions OpenShift has received requests for in the last 24 hours.
+	APIDeprecationFindingRequests = "deprecated-api-requests"
+
+	lastAppliedConfigAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+	lastAppliedManager          = "kubectl-client-side-apply"
+)
+
+// apiRequestCountsGVR are the request counts of each API version kept by the
+// OpenShift API server.
+var apiRequestCountsGVR = schema.GroupVersionResource{Group: "apiserver.openshift.io", Version: "v1", Resource: "apirequestcounts"}
+
+// deprecatedAPI is an API version of a kind that is removed in a Kubernetes
+// release.
+type deprecatedAPI struct {
+	groupVersion string
+	kind         string
+	// removedIn is the Kubernetes release the API version is removed in.
+	removedIn string
+	// resource is the resource of the replacement, which is listed to find
+	// the objects managed with the deprecated version.
+	resource schema.GroupVersionResource
+}
+
+// deprecatedAPIs are the deprecated API versions of the built-in kinds that
+// have a replacement objects can be listed with.
+var deprecatedAPIs = []deprecatedAPI{
+	{"extensions/v1beta1", "Ingress", "1.22", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}},
+	{"networking.k8s.io/v1beta1", "Ingress", "1.22", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}},
+	{"networking.k8s.io/v1beta1", "IngressClass", "1.22", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"}},
+	{"admissionregistration.k8s.io/v1beta1", "ValidatingWebhookConfiguration", "1.22", schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "validatingwebhookconfigurations"}},
+	{"admissionregistration.k8s.io/v1beta1", "MutatingWebhookConfiguration", "1.22", schema.GroupVersionResource{Group: "admissionregistration.k8s.io", Version: "v1", Resource: "mutatingwebhookconfigurations"}},
+	{"apiextensions.k8s.io/v1beta1", "CustomResourceDefinition", "1.22", crdGVR},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRole", "1.22", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}},
+	{"rbac.authorization.k8s.io/v1beta1", "ClusterRoleBinding", "1.22", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterrolebindings"}},
+	{"rbac.authorization.k8s.io/v1beta1", "Role", "1.22", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "roles"}},
+	{"rbac.authorization.k8s.io/v1beta1", "RoleBinding", "1.22", schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings"}},
+	{"certificates.k8s.io/v1beta1", "CertificateSigningRequest", "1.22", schema.GroupVersionResource{Group: "certificates.k8s.io", Version: "v1", Resource: "certificatesigningrequests"}},
+	{"scheduling.k8s.io/v1beta1", "PriorityClass", "1.22", schema.GroupVersionResource{Group: "scheduling.k8s.io", Version: "v1", Resource: "priorityclasses"}},
+	{"batch/v1beta1", "CronJob", "1.25", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}},
+	{"policy/v1beta1", "PodDisruptionBudget", "1.25", schema.GroupVersionResource{Group: "policy", Version: "v1", Resource: "poddisruptionbudgets"}},
+	{"autoscaling/v2beta1", "HorizontalPodAutoscaler", "1.25", schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}},
+	{"autoscaling/v2beta2", "HorizontalPodAutoscaler", "1.26", schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}},
+	{"storage.k8s.io/v1beta1", "CSIStorageCapacity", "1.27", schema.GroupVersionResource{Group: "storage.k8s.io", Version: "v1", Resource: "csistoragecapacities"}},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "FlowSchema", "1.29", schema.GroupVersionResource{Group: "flowcontrol.apiserver.k8s.io", Version: "v1", Resource: "flowschemas"}},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", "PriorityLevelConfiguration", "1.29", schema.GroupVersionResource{Group: "flowcontrol.apiserver.k8s.io", Version: "v1", Resource: "prioritylevelconfigurations"}},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "FlowSchema", "1.32", schema.GroupVersionResource{Group: "flowcontrol.apiserver.k8s.io", Version: "v1", Resource: "flowschemas"}},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", "PriorityLevelConfiguration", "1.32", schema.GroupVersionResource{Group: "flowcontrol.apiserver.k8s.io", Version: "v1", Resource: "prioritylevelconfigurations"}},
+}
+
+// ConfigAPIDeprecations contains the configuration for the
+// k8s-api-deprecations data-gatherer.
+type ConfigAPIDeprecations struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+}
+
+// UnmarshalYAML unmarshals the ConfigAPIDeprecations.
+func (c *ConfigAPIDeprecations) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string `yaml:"kubeconfig"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-api-deprecations data-gatherer.
+func (c *ConfigAPIDeprecations) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	metadataClient, err := NewMetadataClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	discoveryClient, err := NewDiscoveryClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, metadataClient, cl, discoveryClient)
+}
+
+func (c *ConfigAPIDeprecations) newDataGathererWithClient(ctx context.Context, metadataClient metadata.Interface, cl dynamic.Interface, discoveryClient discovery.DiscoveryInterface) (datagatherer.DataGatherer, error) {
+	return &DataGathererAPIDeprecations{
+		ctx:             ctx,
+		metadataClient:  metadataClient,
+		cl:              cl,
+		discoveryClient: discoveryClient,
+	}, nil
+}
+
+// DataGathererAPIDeprecations reports the usage of the deprecated API
+// versions that the cluster still serves, so that the workloads that will
+// break on the next Kubernetes upgrade can be fixed beforehand. On OpenShift,
+// the request counts of the API server are reported. On all clusters, the
+// objects are audited: the managed fields record the API version each
+// manager last wrote an object with, and the last applied configuration the
+// API version kubectl applied it with.
+type DataGathererAPIDeprecations struct {
+	ctx             context.Context
+	metadataClient  metadata.Interface
+	cl              dynamic.Interface
+	discoveryClient discovery.DiscoveryInterface
+}
+
+// APIDeprecations is the data of the k8s-api-deprecations data gatherer.
+type APIDeprecations struct {
+	// ServerVersion is the version of the API server, e.g. v1.24.3.
+	ServerVersion string `json:"serverVersion"`
+	// Usages are the objects managed with a deprecated API version.
+	Usages []DeprecatedAPIUsage `json:"usages"`
+	// Requests are the requests for deprecated API versions in the last 24
+	// hours, on OpenShift.
+	Requests []DeprecatedAPIRequests `json:"requests,omitempty"`
+}
+
+// DeprecatedAPIUsage is an object managed with a deprecated API version.
+type DeprecatedAPIUsage struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	APIVersion string `json:"apiVersion"`
+	// RemovedIn is the Kubernetes release the API version is removed in.
+	RemovedIn string `json:"removedIn"`
+	// Managers are the field managers that wrote the object with the API
+	// version, e.g. helm or kubectl-client-side-apply.
+	Managers []string `json:"managers"`
+}
+
+// DeprecatedAPIRequests are the requests for a deprecated API version counted
+// by the OpenShift API server.
+type DeprecatedAPIRequests struct {
+	// Resource is the resource and API version, e.g.
+	// ingresses.v1beta1.networking.k8s.io.
+	Resource     string `json:"resource"`
+	RemovedIn    string `json:"removedIn"`
+	RequestCount int64  `json:"requestCount"`
+	// Users are the users, and their user agents, that made requests in the
+	// last 24 hours.
+	Users []string `json:"users"`
+}
+
+// apiRequestCount is the part of an OpenShift APIRequestCount that is
+// evaluated.
+type apiRequestCount struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Status            struct {
+		RemovedInRelease string `json:"removedInRelease"`
+		RequestCount     int64  `json:"requestCount"`
+		Last24h          []struct {
+			ByNode []struct {
+				ByUser []struct {
+					Username  string `json:"username"`
+					UserAgent string `json:"userAgent"`
+				} `json:"byUser"`
+			} `json:"byNode"`
+		} `json:"last24h"`
+	} `json:"status"`
+}
+
+// Run is a no-op, the objects are listed on every Fetch.
+func (g *DataGathererAPIDeprecations) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererAPIDeprecations) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererAPIDeprecations) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch audits the objects of the kinds with deprecated API versions, and
+// reads the request counts on OpenShift.
+func (g *DataGathererAPIDeprecations) Fetch() (interface{}, int, error) {
+	info, err := g.discoveryClient.ServerVersion()
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to get the Kubernetes version: %w", err)
+	}
+	// if the version can't be parsed, all the deprecated API versions are
+	// assumed to be served
+	serverVersion, _ := version.ParseGeneric(info.GitVersion)
+
+	result := &APIDeprecations{ServerVersion: info.GitVersion, Usages: []DeprecatedAPIUsage{}}
+	findings := []api.Finding{}
+
+	byResource := map[schema.GroupVersionResource][]deprecatedAPI{}
+	var resources []schema.GroupVersionResource
+	for _, deprecated := range deprecatedAPIs {
+		if serverVersion != nil && !serverVersion.LessThan(version.MustParseGeneric(deprecated.removedIn)) {
+			continue
+		}
+		if _, ok := byResource[deprecated.resource]; !ok {
+			resources = append(resources, deprecated.resource)
+		}
+		byResource[deprecated.resource] = append(byResource[deprecated.resource], deprecated)
+	}
+	for _, resource := range resources {
+		usages, err := g.audit(resource, byResource[resource])
+		if err != nil {
+			return nil, -1, err
+		}
+		for _, usage := range usages {
+			result.Usages = append(result.Usages, usage)
+			findings = append(findings, api.Finding{
+				RuleID:      APIDeprecationFindingUsage,
+				Severity:    removalSeverity(serverVersion, usage.RemovedIn),
+				Resource:    api.ResourceRef{Kind: usage.Kind, Namespace: usage.Namespace, Name: usage.Name},
+				Message:     fmt.Sprintf("managed with %s, which is removed in Kubernetes %s, by %v", usage.APIVersion, usage.RemovedIn, usage.Managers),
+				Remediation: fmt.Sprintf("Update the manifests and clients of the %s to a supported API version before upgrading to Kubernetes %s.", usage.Kind, usage.RemovedIn),
+			})
+		}
+	}
+
+	if result.Requests, err = g.requests(); err != nil {
+		return nil, -1, err
+	}
+	for _, requests := range result.Requests {
+		findings = append(findings, api.Finding{
+			RuleID:      APIDeprecationFindingRequests,
+			Severity:    removalSeverity(serverVersion, requests.RemovedIn),
+			Resource:    api.ResourceRef{Kind: "APIRequestCount", Name: requests.Resource},
+			Message:     fmt.Sprintf("%d requests for %s, which is removed in Kubernetes %s, by %v", requests.RequestCount, requests.Resource, requests.RemovedIn, requests.Users),
+			Remediation: fmt.Sprintf("Update the clients of %s to a supported API version before upgrading to Kubernetes %s.", requests.Resource, requests.RemovedIn),
+		})
+	}
+
+	response := map[string]interface{}{
+		"apiDeprecations": result,
+		"findings":        findings,
+	}
+
+	return response, len(result.Usages) + len(result.Requests), nil
+}
+
+// audit lists the objects of the resource, and returns those managed with one
+// of its deprecated API versions. Resources that are not served are skipped.
+func (g *DataGathererAPIDeprecations) audit(resource schema.GroupVersionResource, deprecated []deprecatedAPI) ([]DeprecatedAPIUsage, error) {
+	list, err := g.metadataClient.Resource(resource).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", resource, err)
+	}
+
+	var usages []DeprecatedAPIUsage
+	for _, item := range list.Items {
+		managers := map[string][]string{}
+		for _, field := range item.ManagedFields {
+			managers[field.APIVersion] = append(managers[field.APIVersion], field.Manager)
+		}
+		if applied, ok := item.Annotations[lastAppliedConfigAnnotation]; ok {
+			var object struct {
+				APIVersion string `json:"apiVersion"`
+			}
+			if json.Unmarshal([]byte(applied), &object) == nil {
+				managers[object.APIVersion] = append(managers[object.APIVersion], lastAppliedManager)
+			}
+		}
+
+		for _, d := range deprecated {
+			if len(managers[d.groupVersion]) == 0 {
+				continue
+			}
+			usages = append(usages, DeprecatedAPIUsage{
+				Kind:       d.kind,
+				Namespace:  item.Namespace,
+				Name:       item.Name,
+				APIVersion: d.groupVersion,
+				RemovedIn:  d.removedIn,
+				Managers:   uniqueSorted(managers[d.groupVersion]),
+			})
+		}
+	}
+	return usages, nil
+}
+
+// requests returns the deprecated API versions OpenShift has received
+// requests for, or nothing on other clusters.
+func (g *DataGathererAPIDeprecations) requests() ([]DeprecatedAPIRequests, error) {
+	list, err := g.cl.Resource(apiRequestCountsGVR).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", apiRequestCountsGVR, err)
+	}
+
+	var result []DeprecatedAPIRequests
+	for _, item := range list.Items {
+		var count apiRequestCount
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &count); err != nil {
+			return nil, fmt.Errorf("failed to decode apirequestcount %s: %w", item.GetName(), err)
+		}
+		if count.Status.RemovedInRelease == "" {
+			continue
+		}
+		var users []string
+		for _, hour := range count.Status.Last24h {
+			for _, node := range hour.ByNode {
+				for _, user := range node.ByUser {
+					users = append(users, fmt.Sprintf("%s (%s)", user.Username, user.UserAgent))
+				}
+			}
+		}
+		if len(users) == 0 {
+			continue
+		}
+		result = append(result, DeprecatedAPIRequests{
+			Resource:     count.Name,
+			RemovedIn:    count.Status.RemovedInRelease,
+			RequestCount: count.Status.RequestCount,
+			Users:        uniqueSorted(users),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Resource < result[j].Resource })
+	return result, nil
+}
+
+// removalSeverity is high for API versions removed in the next minor release
+// of Kubernetes, which will break on the next upgrade, and medium for later
+// ones.
+func removalSeverity(serverVersion *version.Version, removedIn string) api.Severity {
+	removed, err := version.ParseGeneric(removedIn)
+	if serverVersion == nil || err != nil {
+		return api.SeverityMedium
+	}
+	if removed.Minor() <= serverVersion.Minor()+1 && removed.Major() == serverVersion.Major() {
+		return api.SeverityHigh
+	}
+	return api.SeverityMedium
+}
+
+// auditedResources returns the resources listed to audit the objects.
+func auditedResources() []schema.GroupVersionResource {
+	var resources []schema.GroupVersionResource
+	seen := map[schema.GroupVersionResource]bool{}
+	for _, deprecated := range deprecatedAPIs {
+		if !seen[deprecated.resource] {
+			seen[deprecated.resource] = true
+			resources = append(resources, deprecated.resource)
+		}
+	}
+	return resources
+}
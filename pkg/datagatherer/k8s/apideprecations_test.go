@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic/fake"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestAPIDeprecationsGatherer_Fetch(t *testing.T) {
+	scheme := runtime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	metadataClient := fakemetadata.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "CronJob"},
+			ObjectMeta: metav1.ObjectMeta{Name: "backup", Namespace: "default", ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "helm", APIVersion: "batch/v1beta1"},
+				{Manager: "kube-controller-manager", APIVersion: "batch/v1"},
+			}},
+		},
+		&metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "autoscaling/v2", Kind: "HorizontalPodAutoscaler"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: map[string]string{
+				lastAppliedConfigAnnotation: `{"apiVersion":"autoscaling/v2beta2","kind":"HorizontalPodAutoscaler"}`,
+			}},
+		},
+		// removed before the version of the server, so no longer used
+		&metav1.PartialObjectMetadata{
+			TypeMeta: metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"},
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", ManagedFields: []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl", APIVersion: "networking.k8s.io/v1beta1"},
+			}},
+		},
+	)
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		apiRequestCountsGVR: "APIRequestCountList",
+	},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apiserver.openshift.io/v1",
+			"kind":       "APIRequestCount",
+			"metadata":   map[string]interface{}{"name": "cronjobs.v1beta1.batch"},
+			"status": map[string]interface{}{
+				"removedInRelease": "1.25",
+				"requestCount":     int64(10),
+				"last24h": []interface{}{
+					map[string]interface{}{"byNode": []interface{}{
+						map[string]interface{}{"byUser": []interface{}{
+							map[string]interface{}{"username": "system:serviceaccount:ci:deployer", "userAgent": "kubectl/v1.21.0"},
+						}},
+					}},
+				},
+			},
+		}},
+		&unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apiserver.openshift.io/v1",
+			"kind":       "APIRequestCount",
+			"metadata":   map[string]interface{}{"name": "cronjobs.v1.batch"},
+			"status":     map[string]interface{}{"requestCount": int64(20)},
+		}},
+	)
+
+	discoveryClient := &fakediscovery.FakeDiscovery{
+		Fake:               &fakeclientset.NewSimpleClientset().Fake,
+		FakedServerVersion: &version.Info{GitVersion: "v1.24.3"},
+	}
+
+	config := ConfigAPIDeprecations{}
+	dg, err := config.newDataGathererWithClient(context.Background(), metadataClient, cl, discoveryClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 usages and requests, got %d", count)
+	}
+
+	expected := &APIDeprecations{
+		ServerVersion: "v1.24.3",
+		Usages: []DeprecatedAPIUsage{
+			{Kind: "CronJob", Namespace: "default", Name: "backup", APIVersion: "batch/v1beta1", RemovedIn: "1.25", Managers: []string{"helm"}},
+			{Kind: "HorizontalPodAutoscaler", Namespace: "default", Name: "web", APIVersion: "autoscaling/v2beta2", RemovedIn: "1.26", Managers: []string{lastAppliedManager}},
+		},
+		Requests: []DeprecatedAPIRequests{
+			{Resource: "cronjobs.v1beta1.batch", RemovedIn: "1.25", RequestCount: 10, Users: []string{"system:serviceaccount:ci:deployer (kubectl/v1.21.0)"}},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data.(map[string]interface{})["apiDeprecations"]); !equal {
+		t.Errorf("unexpected API deprecations:\n%s", diff)
+	}
+
+	var findings []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		findings = append(findings, finding.Resource.Name+" "+finding.RuleID+" "+string(finding.Severity))
+	}
+	expectedFindings := []string{
+		"backup " + APIDeprecationFindingUsage + " high",
+		"web " + APIDeprecationFindingUsage + " medium",
+		"cronjobs.v1beta1.batch " + APIDeprecationFindingRequests + " high",
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedFindings, findings); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
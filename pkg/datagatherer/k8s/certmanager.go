@@ -0,0 +1,299 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// certManagerSyncTimeout bounds the wait for the caches of the resources
+// discovered by the k8s-cert-manager data gatherer to sync.
+const certManagerSyncTimeout = 30 * time.Second
+
+// defaultCertManagerGroups are the API groups gathered by the k8s-cert-manager
+// data gatherer if none are configured.
+var defaultCertManagerGroups = []string{"cert-manager.io", "acme.cert-manager.io"}
+
+// ConfigCertManager contains the configuration for the k8s-cert-manager
+// data gatherer.
+type ConfigCertManager struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// Groups are the API groups whose resources are gathered.
+	Groups []string `yaml:"groups"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// FieldFilters select and remove fields of the gathered resources.
+	FieldFilters FieldFilters `yaml:"field-filters"`
+}
+
+// UnmarshalYAML unmarshals the ConfigCertManager, defaulting the API groups.
+func (c *ConfigCertManager) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath    string       `yaml:"kubeconfig"`
+		Groups            []string     `yaml:"groups"`
+		ExcludeNamespaces []string     `yaml:"exclude-namespaces"`
+		IncludeNamespaces []string     `yaml:"include-namespaces"`
+		FieldFilters      FieldFilters `yaml:"field-filters"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.Groups = aux.Groups
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+	c.FieldFilters = aux.FieldFilters
+
+	return nil
+}
+
+// NewDataGatherer constructs a data gatherer for the resources served in the
+// configured API groups. They are discovered again each time it fetches, so
+// that the resources installed after the agent started are picked up.
+func (c *ConfigCertManager) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	discoveryClient, err := NewDiscoveryClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newDataGathererWithClient(ctx, discoveryClient, func(ctx context.Context, dynamicConfig *ConfigDynamic) (datagatherer.DataGatherer, error) {
+		return dynamicConfig.NewDataGatherer(ctx)
+	}), nil
+}
+
+func (c *ConfigCertManager) newDataGathererWithClient(ctx context.Context, cl discovery.DiscoveryInterface, newDynamic func(context.Context, *ConfigDynamic) (datagatherer.DataGatherer, error)) *DataGathererCertManager {
+	return &DataGathererCertManager{
+		ctx:        ctx,
+		config:     c,
+		cl:         cl,
+		newDynamic: newDynamic,
+	}
+}
+
+// DataGathererCertManager gathers the resources served in the API groups of
+// cert-manager with a k8s-dynamic data gatherer. The resources are
+// discovered before each fetch, and the k8s-dynamic data gatherer is
+// replaced when they change, e.g. when cert-manager is installed or
+// upgraded.
+type DataGathererCertManager struct {
+	ctx        context.Context
+	config     *ConfigCertManager
+	cl         discovery.DiscoveryInterface
+	newDynamic func(context.Context, *ConfigDynamic) (datagatherer.DataGatherer, error)
+
+	mu sync.Mutex
+	// gvrs are the resources gathered by dynamic, which is nil if there are
+	// none.
+	gvrs    []schema.GroupVersionResource
+	dynamic datagatherer.DataGatherer
+	// cancel stops the informers of dynamic.
+	cancel context.CancelFunc
+}
+
+// Run discovers the resources and starts gathering them.
+func (g *DataGathererCertManager) Run(stopCh <-chan struct{}) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.discover()
+}
+
+// WaitForCacheSync waits for the caches of the discovered resources to sync.
+func (g *DataGathererCertManager) WaitForCacheSync(stopCh <-chan struct{}) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.dynamic == nil {
+		return nil
+	}
+	return g.dynamic.WaitForCacheSync(stopCh)
+}
+
+// Delete stops gathering the resources and clears their caches.
+func (g *DataGathererCertManager) Delete() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stop()
+}
+
+// Fetch discovers the resources again, and returns the items of all of them
+// in one list, which is empty if none of the API groups are served.
+func (g *DataGathererCertManager) Fetch() (interface{}, int, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.discover(); err != nil {
+		return nil, -1, err
+	}
+	if g.dynamic == nil {
+		return map[string]interface{}{"items": []*api.GatheredResource{}}, 0, nil
+	}
+	return g.dynamic.Fetch()
+}
+
+// discover discovers the resources served in the API groups and, if they
+// changed, replaces the k8s-dynamic data gatherer with one gathering them
+// and waits for its caches to sync.
+func (g *DataGathererCertManager) discover() error {
+	dynamicConfig, err := g.config.dynamicConfig(g.cl)
+	if err != nil {
+		return err
+	}
+	var gvrs []schema.GroupVersionResource
+	if dynamicConfig != nil {
+		gvrs = dynamicConfig.GroupVersionResources()
+	}
+	if reflect.DeepEqual(gvrs, g.gvrs) {
+		return nil
+	}
+
+	if err := g.stop(); err != nil {
+		return err
+	}
+	if dynamicConfig == nil {
+		log.Printf("no resources are served in API groups %q, the k8s-cert-manager datagatherer gathers none", g.config.groups())
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(g.ctx)
+	dg, err := g.newDynamic(ctx, dynamicConfig)
+	if err != nil {
+		cancel()
+		return err
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		cancel()
+		return err
+	}
+	syncCtx, syncCancel := context.WithTimeout(ctx, certManagerSyncTimeout)
+	defer syncCancel()
+	if err := dg.WaitForCacheSync(syncCtx.Done()); err != nil {
+		cancel()
+		return err
+	}
+	log.Printf("the k8s-cert-manager datagatherer gathers %s", formatGroupVersionResources(gvrs))
+	g.gvrs, g.dynamic, g.cancel = gvrs, dg, cancel
+	return nil
+}
+
+// stop stops the k8s-dynamic data gatherer, if any.
+func (g *DataGathererCertManager) stop() error {
+	if g.dynamic == nil {
+		return nil
+	}
+	g.cancel()
+	err := g.dynamic.Delete()
+	g.gvrs, g.dynamic, g.cancel = nil, nil, nil
+	return err
+}
+
+func formatGroupVersionResources(gvrs []schema.GroupVersionResource) string {
+	names := make([]string, len(gvrs))
+	for i, gvr := range gvrs {
+		names[i] = gvr.Resource + "." + gvr.GroupVersion().String()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c *ConfigCertManager) groups() []string {
+	if len(c.Groups) == 0 {
+		return defaultCertManagerGroups
+	}
+	return c.Groups
+}
+
+// dynamicConfig returns the configuration of a k8s-dynamic data gatherer for
+// every resource discovered in the configured API groups, or nil if there are
+// none.
+func (c *ConfigCertManager) dynamicConfig(cl discovery.DiscoveryInterface) (*ConfigDynamic, error) {
+	gvrs, err := discoverGroupResources(cl, c.groups())
+	if err != nil {
+		return nil, err
+	}
+	if len(gvrs) == 0 {
+		return nil, nil
+	}
+
+	return &ConfigDynamic{
+		KubeConfigPath:                  c.KubeConfigPath,
+		GroupVersionResource:            gvrs[0],
+		AdditionalGroupVersionResources: gvrs[1:],
+		ExcludeNamespaces:               c.ExcludeNamespaces,
+		IncludeNamespaces:               c.IncludeNamespaces,
+		FieldFilters:                    c.FieldFilters,
+	}, nil
+}
+
+// discoverGroupResources returns the resources served in the preferred
+// version of each of the given API groups. Subresources and resources that
+// cannot be listed and watched are ignored.
+func discoverGroupResources(cl discovery.DiscoveryInterface, groups []string) ([]schema.GroupVersionResource, error) {
+	serverGroups, err := cl.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover API groups: %w", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, group := range groups {
+		wanted[group] = true
+	}
+
+	var gvrs []schema.GroupVersionResource
+	for _, group := range serverGroups.Groups {
+		if !wanted[group.Name] {
+			continue
+		}
+
+		groupVersion := group.PreferredVersion.GroupVersion
+		resources, err := cl.ServerResourcesForGroupVersion(groupVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover resources for %q: %w", groupVersion, err)
+		}
+
+		for _, resource := range resources.APIResources {
+			if strings.Contains(resource.Name, "/") || !hasVerbs(resource.Verbs, "list", "watch") {
+				continue
+			}
+			gvrs = append(gvrs, schema.GroupVersionResource{
+				Group:    group.Name,
+				Version:  group.PreferredVersion.Version,
+				Resource: resource.Name,
+			})
+		}
+	}
+
+	sort.Slice(gvrs, func(i, j int) bool {
+		return gvrs[i].String() < gvrs[j].String()
+	})
+
+	return gvrs, nil
+}
+
+func hasVerbs(verbs []string, required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, v := range verbs {
+			if v == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+func TestConfigCertManagerDynamicConfig(t *testing.T) {
+	listWatch := []string{"get", "list", "watch"}
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "certificates", Verbs: listWatch},
+				{Name: "certificates/status", Verbs: []string{"get", "patch", "update"}},
+				{Name: "issuers", Verbs: listWatch},
+			},
+		},
+		{
+			GroupVersion: "acme.cert-manager.io/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "orders", Verbs: listWatch},
+				{Name: "challenges", Verbs: listWatch},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{{Name: "deployments", Verbs: listWatch}},
+		},
+	}
+
+	config := ConfigCertManager{IncludeNamespaces: []string{"default"}}
+	dynamicConfig, err := config.dynamicConfig(cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []schema.GroupVersionResource{
+		{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"},
+		{Group: "acme.cert-manager.io", Version: "v1", Resource: "orders"},
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+		{Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, dynamicConfig.GroupVersionResources()); !equal {
+		t.Errorf("unexpected resources:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"default"}, dynamicConfig.IncludeNamespaces); !equal {
+		t.Errorf("unexpected namespaces:\n%s", diff)
+	}
+
+	config = ConfigCertManager{Groups: []string{"example.com"}}
+	dynamicConfig, err = config.dynamicConfig(cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if dynamicConfig != nil {
+		t.Errorf("expected no config when no resources are served, got %+v", dynamicConfig)
+	}
+}
+
+// fakeDynamicDataGatherer records the resources it was created for, and
+// whether it was deleted.
+type fakeDynamicDataGatherer struct {
+	gvrs    []schema.GroupVersionResource
+	deleted bool
+}
+
+func (g *fakeDynamicDataGatherer) Run(stopCh <-chan struct{}) error              { return nil }
+func (g *fakeDynamicDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error { return nil }
+func (g *fakeDynamicDataGatherer) Delete() error                                 { g.deleted = true; return nil }
+
+func (g *fakeDynamicDataGatherer) Fetch() (interface{}, int, error) {
+	return map[string]interface{}{"items": []*api.GatheredResource{{}}}, 1, nil
+}
+
+func TestDataGathererCertManagerRediscovers(t *testing.T) {
+	listWatch := []string{"get", "list", "watch"}
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	var created []*fakeDynamicDataGatherer
+	dg := (&ConfigCertManager{}).newDataGathererWithClient(context.Background(), cl, func(ctx context.Context, c *ConfigDynamic) (datagatherer.DataGatherer, error) {
+		created = append(created, &fakeDynamicDataGatherer{gvrs: c.GroupVersionResources()})
+		return created[len(created)-1], nil
+	})
+
+	// cert-manager is not installed when the agent starts
+	if err := dg.Run(nil); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if _, count, err := dg.Fetch(); err != nil || count != 0 {
+		t.Fatalf("expected no items, got %d, %v", count, err)
+	}
+
+	// it is installed later
+	cl.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "cert-manager.io/v1",
+		APIResources: []metav1.APIResource{{Name: "certificates", Verbs: listWatch}},
+	}}
+	if _, count, err := dg.Fetch(); err != nil || count != 1 {
+		t.Fatalf("expected an item, got %d, %v", count, err)
+	}
+	if _, _, err := dg.Fetch(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(created) != 1 {
+		t.Fatalf("expected a single k8s-dynamic data gatherer, got %d", len(created))
+	}
+
+	// a resource is added by an upgrade
+	cl.Resources[0].APIResources = append(cl.Resources[0].APIResources, metav1.APIResource{Name: "issuers", Verbs: listWatch})
+	if _, _, err := dg.Fetch(); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if len(created) != 2 || !created[0].deleted {
+		t.Fatalf("expected the k8s-dynamic data gatherer to be replaced")
+	}
+	expected := []schema.GroupVersionResource{
+		{Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+		{Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, created[1].gvrs); !equal {
+		t.Errorf("unexpected resources:\n%s", diff)
+	}
+
+	if err := dg.Delete(); err != nil || !created[1].deleted {
+		t.Errorf("expected the k8s-dynamic data gatherer to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	defaultCertManagerEventsWindow = time.Hour
+
+	// maxEventMessageLength bounds the size of the messages of the events.
+	maxEventMessageLength = 512
+)
+
+// defaultCertManagerEventComponents are the prefixes of the components of
+// the events gathered if none are configured. The controllers of
+// cert-manager report events as cert-manager-<controller>, e.g.
+// cert-manager-certificates-issuing.
+var defaultCertManagerEventComponents = []string{"cert-manager"}
+
+// ConfigCertManagerEvents contains the configuration for the
+// k8s-cert-manager-events data-gatherer.
+type ConfigCertManagerEvents struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// Components are the prefixes of the components whose events are
+	// gathered. Defaults to cert-manager.
+	Components []string `yaml:"components"`
+	// Window is how far back the events are gathered on each Fetch.
+	// Defaults to 1h.
+	Window time.Duration `yaml:"window"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// UnmarshalYAML unmarshals the ConfigCertManagerEvents.
+func (c *ConfigCertManagerEvents) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath    string        `yaml:"kubeconfig"`
+		Components        []string      `yaml:"components"`
+		Window            time.Duration `yaml:"window"`
+		ExcludeNamespaces []string      `yaml:"exclude-namespaces"`
+		IncludeNamespaces []string      `yaml:"include-namespaces"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.Components = aux.Components
+	c.Window = aux.Window
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigCertManagerEvents) Validate() error {
+	var errors []string
+	if c.Window < 0 {
+		errors = append(errors, "window must not be negative")
+	}
+	for _, component := range c.Components {
+		if component == "" {
+			errors = append(errors, "components must not be empty")
+			break
+		}
+	}
+	if _, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-cert-manager-events data-gatherer.
+func (c *ConfigCertManagerEvents) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigCertManagerEvents) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	namespaceFilter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	g := &DataGathererCertManagerEvents{
+		ctx:             ctx,
+		clientset:       clientset,
+		components:      c.Components,
+		window:          c.Window,
+		namespaceFilter: namespaceFilter,
+	}
+	if len(g.components) == 0 {
+		g.components = defaultCertManagerEventComponents
+	}
+	if g.window == 0 {
+		g.window = defaultCertManagerEventsWindow
+	}
+
+	return g, nil
+}
+
+// DataGathererCertManagerEvents gathers the recent events reported by the
+// cert-manager components, deduplicated and grouped by the object they are
+// about. The events give the context of issuance failures that the
+// snapshots of the objects miss, as they only hold their latest status.
+type DataGathererCertManagerEvents struct {
+	ctx             context.Context
+	clientset       kubernetes.Interface
+	components      []string
+	window          time.Duration
+	namespaceFilter *namespaceFilter
+}
+
+// CertManagerEvents is the data of the k8s-cert-manager-events data
+// gatherer.
+type CertManagerEvents struct {
+	// Window is how far back the events were gathered.
+	Window  string                     `json:"window"`
+	Objects []*CertManagerEventsObject `json:"objects"`
+}
+
+// CertManagerEventsObject holds the events about an object.
+type CertManagerEventsObject struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Warnings is the number of warning events about the object.
+	Warnings int                      `json:"warnings"`
+	Events   []*CertManagerEventGroup `json:"events"`
+}
+
+// CertManagerEventGroup aggregates the events about an object with the same
+// type, reason and message.
+type CertManagerEventGroup struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	// Message is the message of the events, truncated.
+	Message   string   `json:"message"`
+	Component string   `json:"component"`
+	Count     int      `json:"count"`
+	FirstSeen api.Time `json:"firstSeen"`
+	LastSeen  api.Time `json:"lastSeen"`
+}
+
+// Run is a no-op, the events are listed on every Fetch.
+func (g *DataGathererCertManagerEvents) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererCertManagerEvents) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererCertManagerEvents) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the events, keeps those of the cert-manager components seen
+// within the window and groups them by object. The objects with the most
+// recent events come first.
+func (g *DataGathererCertManagerEvents) Fetch() (interface{}, int, error) {
+	events, err := g.clientset.CoreV1().Events(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	since := clock.now().Add(-g.window)
+	objects := map[corev1.ObjectReference]*CertManagerEventsObject{}
+	groups := map[corev1.ObjectReference]map[string]*CertManagerEventGroup{}
+	for i := range events.Items {
+		event := &events.Items[i]
+		component := eventComponent(event)
+		if !g.isCertManagerComponent(component) || !g.namespaceFilter.isIncluded(event.InvolvedObject.Namespace) {
+			continue
+		}
+		firstSeen, lastSeen := eventTimes(event)
+		if lastSeen.Before(since) {
+			continue
+		}
+
+		ref := corev1.ObjectReference{
+			Kind:      event.InvolvedObject.Kind,
+			Namespace: event.InvolvedObject.Namespace,
+			Name:      event.InvolvedObject.Name,
+		}
+		object, ok := objects[ref]
+		if !ok {
+			object = &CertManagerEventsObject{Kind: ref.Kind, Namespace: ref.Namespace, Name: ref.Name}
+			objects[ref] = object
+			groups[ref] = map[string]*CertManagerEventGroup{}
+		}
+
+		count := int(event.Count)
+		if event.Series != nil && int(event.Series.Count) > count {
+			count = int(event.Series.Count)
+		}
+		if count < 1 {
+			count = 1
+		}
+		if event.Type == corev1.EventTypeWarning {
+			object.Warnings += count
+		}
+
+		message := event.Message
+		if len(message) > maxEventMessageLength {
+			message = message[:maxEventMessageLength] + "..."
+		}
+		key := event.Type + "/" + event.Reason + "/" + message
+		group, ok := groups[ref][key]
+		if !ok {
+			group = &CertManagerEventGroup{
+				Type:      event.Type,
+				Reason:    event.Reason,
+				Message:   message,
+				Component: component,
+				FirstSeen: api.Time{Time: firstSeen},
+				LastSeen:  api.Time{Time: lastSeen},
+			}
+			groups[ref][key] = group
+			object.Events = append(object.Events, group)
+		}
+		group.Count += count
+		if firstSeen.Before(group.FirstSeen.Time) {
+			group.FirstSeen = api.Time{Time: firstSeen}
+		}
+		if lastSeen.After(group.LastSeen.Time) {
+			group.LastSeen = api.Time{Time: lastSeen}
+		}
+	}
+
+	result := &CertManagerEvents{
+		Window:  g.window.String(),
+		Objects: []*CertManagerEventsObject{},
+	}
+	for _, object := range objects {
+		sort.SliceStable(object.Events, func(i, j int) bool {
+			if !object.Events[i].LastSeen.Equal(object.Events[j].LastSeen.Time) {
+				return object.Events[i].LastSeen.After(object.Events[j].LastSeen.Time)
+			}
+			return object.Events[i].Reason < object.Events[j].Reason
+		})
+		result.Objects = append(result.Objects, object)
+	}
+	sort.SliceStable(result.Objects, func(i, j int) bool {
+		a, b := result.Objects[i], result.Objects[j]
+		if !a.Events[0].LastSeen.Equal(b.Events[0].LastSeen.Time) {
+			return a.Events[0].LastSeen.After(b.Events[0].LastSeen.Time)
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return result, len(result.Objects), nil
+}
+
+// isCertManagerComponent returns true if the component has one of the
+// configured prefixes.
+func (g *DataGathererCertManagerEvents) isCertManagerComponent(component string) bool {
+	for _, prefix := range g.components {
+		if strings.HasPrefix(component, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventComponent returns the component that reported the event, from the
+// events.k8s.io fields if set, or the legacy source.
+func eventComponent(event *corev1.Event) string {
+	if event.ReportingController != "" {
+		return event.ReportingController
+	}
+	return event.Source.Component
+}
+
+// eventTimes returns when the event was first and last seen. Depending on
+// the API and the version of the client that recorded the event, these are
+// the first and last timestamps, the event time and the last observed time
+// of the series, or only the creation timestamp.
+func eventTimes(event *corev1.Event) (time.Time, time.Time) {
+	first := event.FirstTimestamp.Time
+	if first.IsZero() {
+		first = event.EventTime.Time
+	}
+	if first.IsZero() {
+		first = event.CreationTimestamp.Time
+	}
+	last := event.LastTimestamp.Time
+	if event.Series != nil && event.Series.LastObservedTime.Time.After(last) {
+		last = event.Series.LastObservedTime.Time
+	}
+	if last.IsZero() {
+		last = first
+	}
+	return first.UTC(), last.UTC()
+}
@@ -0,0 +1,81 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func testEvent(name, component string, object corev1.ObjectReference, eventType, reason, message string, count int32, first, last time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Namespace: object.Namespace, Name: name},
+		InvolvedObject: object,
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Count:          count,
+		Source:         corev1.EventSource{Component: component},
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+func TestCertManagerEventsGatherer_Fetch(t *testing.T) {
+	// the fake clock is at 2021-03-16T18:22:15Z
+	now := time.Unix(1615918935, 0).UTC()
+	certificate := corev1.ObjectReference{Kind: "Certificate", Namespace: "shop", Name: "api"}
+	order := corev1.ObjectReference{Kind: "Order", Namespace: "shop", Name: "api-1-123"}
+	pod := corev1.ObjectReference{Kind: "Pod", Namespace: "shop", Name: "api-0"}
+
+	clientset := fake.NewSimpleClientset([]runtime.Object{
+		testEvent("a", "cert-manager-certificates-issuing", certificate, corev1.EventTypeWarning, "Failed", "The certificate request has failed", 3, now.Add(-50*time.Minute), now.Add(-20*time.Minute)),
+		testEvent("b", "cert-manager-certificates-issuing", certificate, corev1.EventTypeWarning, "Failed", "The certificate request has failed", 2, now.Add(-15*time.Minute), now.Add(-10*time.Minute)),
+		testEvent("c", "cert-manager-certificates-trigger", certificate, corev1.EventTypeNormal, "Issuing", "Issuing certificate as Secret does not exist", 1, now.Add(-55*time.Minute), now.Add(-55*time.Minute)),
+		testEvent("d", "cert-manager-certificates-trigger", certificate, corev1.EventTypeNormal, "Issuing", "Renewing certificate", 1, now.Add(-3*time.Hour), now.Add(-2*time.Hour)),
+		testEvent("e", "cert-manager-orders", order, corev1.EventTypeWarning, "Solver", "Failed to determine a valid solver configuration", 1, now.Add(-5*time.Minute), now.Add(-5*time.Minute)),
+		testEvent("f", "kubelet", pod, corev1.EventTypeWarning, "BackOff", "Back-off restarting failed container", 10, now.Add(-5*time.Minute), now.Add(-time.Minute)),
+	}...)
+
+	dg, err := (&ConfigCertManagerEvents{}).newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 objects, got %d", count)
+	}
+
+	at := func(d time.Duration) api.Time { return api.Time{Time: now.Add(d)} }
+	expected := &CertManagerEvents{
+		Window: "1h0m0s",
+		Objects: []*CertManagerEventsObject{
+			{
+				Kind: "Order", Namespace: "shop", Name: "api-1-123", Warnings: 1,
+				Events: []*CertManagerEventGroup{
+					{Type: "Warning", Reason: "Solver", Message: "Failed to determine a valid solver configuration", Component: "cert-manager-orders", Count: 1, FirstSeen: at(-5 * time.Minute), LastSeen: at(-5 * time.Minute)},
+				},
+			},
+			{
+				Kind: "Certificate", Namespace: "shop", Name: "api", Warnings: 5,
+				Events: []*CertManagerEventGroup{
+					{Type: "Warning", Reason: "Failed", Message: "The certificate request has failed", Component: "cert-manager-certificates-issuing", Count: 5, FirstSeen: at(-50 * time.Minute), LastSeen: at(-10 * time.Minute)},
+					{Type: "Normal", Reason: "Issuing", Message: "Issuing certificate as Secret does not exist", Component: "cert-manager-certificates-trigger", Count: 1, FirstSeen: at(-55 * time.Minute), LastSeen: at(-55 * time.Minute)},
+				},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected events:\n%s", diff)
+	}
+}
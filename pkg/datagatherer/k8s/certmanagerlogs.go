@@ -0,0 +1,399 @@
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	defaultCertManagerLogsNamespace     = "cert-manager"
+	defaultCertManagerLogsLabelSelector = "app.kubernetes.io/instance=cert-manager,app.kubernetes.io/component in (controller,webhook)"
+	defaultCertManagerLogsWindow        = time.Hour
+	defaultCertManagerLogsMaxBytes      = 1 << 20
+
+	// maxErrorSampleLength and maxErrorResources bound the size of each
+	// error signature in the summary.
+	maxErrorSampleLength = 512
+	maxErrorResources    = 10
+)
+
+// ConfigCertManagerLogs contains the configuration for the
+// k8s-cert-manager-logs data-gatherer.
+type ConfigCertManagerLogs struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// Namespace is the namespace cert-manager is deployed in. Defaults to
+	// cert-manager.
+	Namespace string `yaml:"namespace"`
+	// LabelSelector selects the pods whose logs are read. Defaults to the
+	// controller and webhook pods of the upstream Helm chart.
+	LabelSelector string `yaml:"label-selector"`
+	// Window is how far back the logs are read on each Fetch. Defaults to
+	// 1h.
+	Window time.Duration `yaml:"window"`
+	// MaxBytes is the maximum number of bytes read from the logs of each
+	// container. Defaults to 1MiB.
+	MaxBytes int64 `yaml:"max-bytes"`
+}
+
+// UnmarshalYAML unmarshals the ConfigCertManagerLogs.
+func (c *ConfigCertManagerLogs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string        `yaml:"kubeconfig"`
+		Namespace      string        `yaml:"namespace"`
+		LabelSelector  string        `yaml:"label-selector"`
+		Window         time.Duration `yaml:"window"`
+		MaxBytes       int64         `yaml:"max-bytes"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.Namespace = aux.Namespace
+	c.LabelSelector = aux.LabelSelector
+	c.Window = aux.Window
+	c.MaxBytes = aux.MaxBytes
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigCertManagerLogs) Validate() error {
+	var errors []string
+	if c.LabelSelector != "" {
+		if _, err := labels.Parse(c.LabelSelector); err != nil {
+			errors = append(errors, fmt.Sprintf("invalid label-selector: %s", err))
+		}
+	}
+	if c.Window < 0 {
+		errors = append(errors, "window must not be negative")
+	}
+	if c.MaxBytes < 0 {
+		errors = append(errors, "max-bytes must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-cert-manager-logs data-gatherer.
+func (c *ConfigCertManagerLogs) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigCertManagerLogs) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	g := &DataGathererCertManagerLogs{
+		ctx:           ctx,
+		clientset:     clientset,
+		namespace:     c.Namespace,
+		labelSelector: c.LabelSelector,
+		window:        c.Window,
+		maxBytes:      c.MaxBytes,
+	}
+	if g.namespace == "" {
+		g.namespace = defaultCertManagerLogsNamespace
+	}
+	if g.labelSelector == "" {
+		g.labelSelector = defaultCertManagerLogsLabelSelector
+	}
+	if g.window == 0 {
+		g.window = defaultCertManagerLogsWindow
+	}
+	if g.maxBytes == 0 {
+		g.maxBytes = defaultCertManagerLogsMaxBytes
+	}
+	g.streamLogs = g.streamPodLogs
+
+	return g, nil
+}
+
+// DataGathererCertManagerLogs reads the recent logs of the cert-manager
+// controller and webhook and summarizes their errors by signature, such as
+// ACME failures or webhook timeouts, to give context on failures that the
+// status of the cert-manager resources doesn't explain.
+type DataGathererCertManagerLogs struct {
+	ctx           context.Context
+	clientset     kubernetes.Interface
+	namespace     string
+	labelSelector string
+	window        time.Duration
+	maxBytes      int64
+	// streamLogs opens the logs of a container, replaced in tests as the
+	// fake clientset always returns the same logs.
+	streamLogs func(pod, container string) (io.ReadCloser, error)
+}
+
+// CertManagerLogs is the data of the k8s-cert-manager-logs data gatherer.
+type CertManagerLogs struct {
+	// Window is how far back the logs were read.
+	Window     string                 `json:"window"`
+	Containers []CertManagerLogSource `json:"containers"`
+	Errors     []CertManagerLogErrors `json:"errors"`
+}
+
+// CertManagerLogSource describes the logs read from a container.
+type CertManagerLogSource struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Lines     int    `json:"lines"`
+	Errors    int    `json:"errors"`
+	// Error is set when the logs could not be read.
+	Error string `json:"error,omitempty"`
+}
+
+// CertManagerLogErrors aggregates the error lines with the same signature.
+type CertManagerLogErrors struct {
+	// Signature classifies the errors, e.g. acme-rate-limited.
+	Signature string    `json:"signature"`
+	Count     int       `json:"count"`
+	FirstSeen *api.Time `json:"firstSeen,omitempty"`
+	LastSeen  *api.Time `json:"lastSeen,omitempty"`
+	// Containers are the pod/container the errors were logged by.
+	Containers []string `json:"containers"`
+	// Resources are the keys of the resources the errors are about, e.g.
+	// namespace/name, up to 10.
+	Resources []string `json:"resources,omitempty"`
+	// Sample is the first error line, truncated.
+	Sample string `json:"sample"`
+}
+
+// errorSignature classifies an error line matching pattern.
+type errorSignature struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// errorSignatures are checked in order, the first matching one classifies
+// the error. Errors matching none are classified as other.
+var errorSignatures = []errorSignature{
+	{"acme-rate-limited", regexp.MustCompile(`(?i)urn:ietf:params:acme:error:rateLimited|too many (certificates|failed authorizations|new orders)`)},
+	{"acme-authorization-failed", regexp.MustCompile(`(?i)urn:ietf:params:acme:error:(unauthorized|dns|connection|caa|incorrectResponse|tls)|acme: authorization error`)},
+	{"acme-account-error", regexp.MustCompile(`(?i)urn:ietf:params:acme:error:(accountDoesNotExist|externalAccountRequired)|failed to (register|verify) ACME account`)},
+	{"acme-error", regexp.MustCompile(`(?i)acme: |urn:ietf:params:acme:error:`)},
+	{"webhook-timeout", regexp.MustCompile(`(?i)failed calling webhook.*(context deadline exceeded|timeout|timed out)`)},
+	{"webhook-unavailable", regexp.MustCompile(`(?i)failed calling webhook|webhook.*(connection refused|no endpoints available)`)},
+	{"tls-handshake-error", regexp.MustCompile(`(?i)tls: |x509: `)},
+	{"issuer-not-ready", regexp.MustCompile(`(?i)issuer[^"]* not ready|IssuerNotReady|referenced issuer`)},
+	{"secret-not-found", regexp.MustCompile(`(?i)secrets? \\?"[^"\\]*\\?" not found`)},
+	{"api-conflict", regexp.MustCompile(`(?i)the object has been modified`)},
+	{"api-timeout", regexp.MustCompile(`(?i)context deadline exceeded|i/o timeout|connection refused`)},
+}
+
+var (
+	// klogErrorLine matches the header of klog error lines, e.g.
+	// `E0102 03:04:05.000000       1 controller.go:167]`.
+	klogErrorLine = regexp.MustCompile(`^E\d{4} `)
+	// jsonErrorLine matches the level of JSON error lines.
+	jsonErrorLine = regexp.MustCompile(`"(level|severity)":\s*"(?i:error)"`)
+	// resourceKey matches the key of the resource of klog lines.
+	resourceKey = regexp.MustCompile(`\bkey="([^"]+)"`)
+)
+
+// Run is a no-op, the logs are read on every Fetch.
+func (g *DataGathererCertManagerLogs) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererCertManagerLogs) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererCertManagerLogs) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch reads the logs of the window from each container of the selected
+// pods and summarizes their errors. Logs that cannot be read are reported
+// with an error rather than failing the Fetch.
+func (g *DataGathererCertManagerLogs) Fetch() (interface{}, int, error) {
+	pods, err := g.clientset.CoreV1().Pods(g.namespace).List(g.ctx, metav1.ListOptions{LabelSelector: g.labelSelector})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list pods in %s: %w", g.namespace, err)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+
+	summary := newLogSummary()
+	result := &CertManagerLogs{
+		Window:     g.window.String(),
+		Containers: []CertManagerLogSource{},
+	}
+	for _, pod := range pods.Items {
+		for _, container := range pod.Spec.Containers {
+			source := CertManagerLogSource{Pod: pod.Name, Container: container.Name}
+			logs, err := g.streamLogs(pod.Name, container.Name)
+			if err != nil {
+				source.Error = err.Error()
+			} else {
+				source.Lines, source.Errors, err = summary.read(logs, pod.Name+"/"+container.Name)
+				logs.Close()
+				if err != nil {
+					source.Error = err.Error()
+				}
+			}
+			result.Containers = append(result.Containers, source)
+		}
+	}
+	result.Errors = summary.errors()
+
+	errorCount := 0
+	for _, e := range result.Errors {
+		errorCount += e.Count
+	}
+
+	return result, errorCount, nil
+}
+
+// streamPodLogs opens the logs of the window of a container, with their
+// timestamps.
+func (g *DataGathererCertManagerLogs) streamPodLogs(pod, container string) (io.ReadCloser, error) {
+	sinceSeconds := int64(g.window.Seconds())
+	return g.clientset.CoreV1().Pods(g.namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container:    container,
+		SinceSeconds: &sinceSeconds,
+		LimitBytes:   &g.maxBytes,
+		Timestamps:   true,
+	}).Stream(g.ctx)
+}
+
+// logSummary aggregates error lines by signature.
+type logSummary struct {
+	bySignature map[string]*CertManagerLogErrors
+	containers  map[string]map[string]bool
+	resources   map[string]map[string]bool
+}
+
+func newLogSummary() *logSummary {
+	return &logSummary{
+		bySignature: map[string]*CertManagerLogErrors{},
+		containers:  map[string]map[string]bool{},
+		resources:   map[string]map[string]bool{},
+	}
+}
+
+// read adds the error lines of logs, prefixed with their timestamps, to the
+// summary and returns the number of lines and of error lines.
+func (s *logSummary) read(logs io.Reader, container string) (int, int, error) {
+	lines, errorLines := 0, 0
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines++
+		timestamp, message := splitLogTimestamp(scanner.Text())
+		if !isErrorLine(message) {
+			continue
+		}
+		errorLines++
+		s.add(timestamp, message, container)
+	}
+	return lines, errorLines, scanner.Err()
+}
+
+func (s *logSummary) add(timestamp *time.Time, message, container string) {
+	signature := classifyError(message)
+	e, ok := s.bySignature[signature]
+	if !ok {
+		sample := message
+		if len(sample) > maxErrorSampleLength {
+			sample = sample[:maxErrorSampleLength] + "..."
+		}
+		e = &CertManagerLogErrors{Signature: signature, Sample: sample}
+		s.bySignature[signature] = e
+		s.containers[signature] = map[string]bool{}
+		s.resources[signature] = map[string]bool{}
+	}
+	e.Count++
+	if timestamp != nil {
+		if e.FirstSeen == nil || timestamp.Before(e.FirstSeen.Time) {
+			e.FirstSeen = &api.Time{Time: *timestamp}
+		}
+		if e.LastSeen == nil || timestamp.After(e.LastSeen.Time) {
+			e.LastSeen = &api.Time{Time: *timestamp}
+		}
+	}
+	if !s.containers[signature][container] {
+		s.containers[signature][container] = true
+		e.Containers = append(e.Containers, container)
+	}
+	if match := resourceKey.FindStringSubmatch(message); match != nil {
+		if key := match[1]; !s.resources[signature][key] && len(e.Resources) < maxErrorResources {
+			s.resources[signature][key] = true
+			e.Resources = append(e.Resources, key)
+		}
+	}
+}
+
+// errors returns the error signatures, the most frequent first.
+func (s *logSummary) errors() []CertManagerLogErrors {
+	errors := []CertManagerLogErrors{}
+	for _, e := range s.bySignature {
+		sort.Strings(e.Containers)
+		sort.Strings(e.Resources)
+		errors = append(errors, *e)
+	}
+	sort.Slice(errors, func(i, j int) bool {
+		if errors[i].Count != errors[j].Count {
+			return errors[i].Count > errors[j].Count
+		}
+		return errors[i].Signature < errors[j].Signature
+	})
+	return errors
+}
+
+// splitLogTimestamp splits the timestamp the API server prefixes log lines
+// with from the message.
+func splitLogTimestamp(line string) (*time.Time, string) {
+	prefix, message, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, line
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, prefix)
+	if err != nil {
+		return nil, line
+	}
+	return &timestamp, message
+}
+
+// isErrorLine returns true for klog and JSON error lines.
+func isErrorLine(message string) bool {
+	return klogErrorLine.MatchString(message) || jsonErrorLine.MatchString(message)
+}
+
+func classifyError(message string) string {
+	for _, signature := range errorSignatures {
+		if signature.pattern.MatchString(message) {
+			return signature.name
+		}
+	}
+	return "other"
+}
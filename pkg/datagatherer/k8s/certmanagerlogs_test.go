@@ -0,0 +1,137 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const controllerLogs = `2024-01-02T03:00:00Z I0102 03:00:00.000000       1 controller.go:162] "syncing item" key="default/example"
+2024-01-02T03:01:00Z E0102 03:01:00.000000       1 controller.go:167] "re-queuing item due to error processing" err="acme: urn:ietf:params:acme:error:rateLimited: too many certificates already issued" key="default/example"
+2024-01-02T03:02:00Z E0102 03:02:00.000000       1 controller.go:167] "re-queuing item due to error processing" err="acme: urn:ietf:params:acme:error:rateLimited: too many certificates already issued" key="prod/api"
+2024-01-02T03:03:00Z E0102 03:03:00.000000       1 sync.go:270] "failed to create Order" err="Internal error occurred: failed calling webhook \"webhook.cert-manager.io\": context deadline exceeded" key="default/example"
+`
+
+const webhookLogs = `2024-01-02T03:04:00Z {"level":"error","msg":"http: TLS handshake error from 10.0.0.1:1234: remote error: tls: bad certificate"}
+2024-01-02T03:05:00Z {"level":"info","msg":"serving"}
+`
+
+func TestCertManagerLogsGatherer_Fetch(t *testing.T) {
+	pod := func(name, container, component string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "cert-manager",
+				Name:      name,
+				Labels:    map[string]string{"app.kubernetes.io/instance": "cert-manager", "app.kubernetes.io/component": component},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: container}}},
+		}
+	}
+	clientset := fakeclientset.NewSimpleClientset(
+		pod("cert-manager-abc", "cert-manager-controller", "controller"),
+		pod("cert-manager-webhook-def", "cert-manager-webhook", "webhook"),
+		pod("cert-manager-webhook-ghi", "cert-manager-webhook", "webhook"),
+		pod("cert-manager-cainjector-jkl", "cert-manager-cainjector", "cainjector"),
+	)
+
+	config := &ConfigCertManagerLogs{}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g := dg.(*DataGathererCertManagerLogs)
+	g.streamLogs = func(pod, container string) (io.ReadCloser, error) {
+		switch pod {
+		case "cert-manager-abc":
+			return io.NopCloser(strings.NewReader(controllerLogs)), nil
+		case "cert-manager-webhook-def":
+			return io.NopCloser(strings.NewReader(webhookLogs)), nil
+		}
+		return nil, fmt.Errorf("container is waiting to start")
+	}
+
+	data, count, err := g.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 errors, got %d", count)
+	}
+
+	at := func(minute int) *api.Time {
+		return &api.Time{Time: time.Date(2024, 1, 2, 3, minute, 0, 0, time.UTC)}
+	}
+	expected := &CertManagerLogs{
+		Window: "1h0m0s",
+		Containers: []CertManagerLogSource{
+			{Pod: "cert-manager-abc", Container: "cert-manager-controller", Lines: 4, Errors: 3},
+			{Pod: "cert-manager-webhook-def", Container: "cert-manager-webhook", Lines: 2, Errors: 1},
+			{Pod: "cert-manager-webhook-ghi", Container: "cert-manager-webhook", Error: "container is waiting to start"},
+		},
+		Errors: []CertManagerLogErrors{
+			{
+				Signature:  "acme-rate-limited",
+				Count:      2,
+				FirstSeen:  at(1),
+				LastSeen:   at(2),
+				Containers: []string{"cert-manager-abc/cert-manager-controller"},
+				Resources:  []string{"default/example", "prod/api"},
+				Sample:     `E0102 03:01:00.000000       1 controller.go:167] "re-queuing item due to error processing" err="acme: urn:ietf:params:acme:error:rateLimited: too many certificates already issued" key="default/example"`,
+			},
+			{
+				Signature:  "tls-handshake-error",
+				Count:      1,
+				FirstSeen:  at(4),
+				LastSeen:   at(4),
+				Containers: []string{"cert-manager-webhook-def/cert-manager-webhook"},
+				Sample:     `{"level":"error","msg":"http: TLS handshake error from 10.0.0.1:1234: remote error: tls: bad certificate"}`,
+			},
+			{
+				Signature:  "webhook-timeout",
+				Count:      1,
+				FirstSeen:  at(3),
+				LastSeen:   at(3),
+				Containers: []string{"cert-manager-abc/cert-manager-controller"},
+				Resources:  []string{"default/example"},
+				Sample:     `E0102 03:03:00.000000       1 sync.go:270] "failed to create Order" err="Internal error occurred: failed calling webhook \"webhook.cert-manager.io\": context deadline exceeded" key="default/example"`,
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := map[string]string{
+		`err="acme: authorization error for example.com: 403 urn:ietf:params:acme:error:unauthorized"`: "acme-authorization-failed",
+		`err="failed calling webhook \"webhook.cert-manager.io\": connection refused"`:                 "webhook-unavailable",
+		`err="secret \"ca-key-pair\" not found"`:                                                       "secret-not-found",
+		`err="Operation cannot be fulfilled: the object has been modified"`:                            "api-conflict",
+		`err="something unexpected"`:                                                                   "other",
+	}
+	for message, expected := range tests {
+		if got := classifyError(message); got != expected {
+			t.Errorf("classifyError(%q) = %q, want %q", message, got, expected)
+		}
+	}
+}
+
+func TestConfigCertManagerLogs_Validate(t *testing.T) {
+	if err := (&ConfigCertManagerLogs{LabelSelector: "app in (", Window: -time.Minute}).Validate(); err == nil {
+		t.Errorf("expected an error")
+	}
+	if err := (&ConfigCertManagerLogs{LabelSelector: "app=cert-manager", Window: time.Hour}).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -2,73 +2,102 @@
 package k8s
 
 import (
+	"context"
+	"os"
+
 	"github.com/pkg/errors"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-// NewDynamicClient creates a new 'dynamic' clientset using the provided kubeconfig.
+// NewDynamicClient returns a 'dynamic' client using the provided kubeconfig.
 // If kubeconfigPath is not set/empty, it will attempt to load configuration using
-// the default loading rules.
-func NewDynamicClient(kubeconfigPath string) (dynamic.Interface, error) {
-	cfg, err := loadRESTConfig(kubeconfigPath)
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
-	cl, err := dynamic.NewForConfig(cfg)
+// the default loading rules. Requests are rate limited as configured in ctx,
+// see WithRateLimit. The client is shared with the other data gatherers using
+// the same kubeconfig and rate limit.
+func NewDynamicClient(ctx context.Context, kubeconfigPath string) (dynamic.Interface, error) {
+	conn, err := getConnection(ctx, kubeconfigPath)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	return cl, nil
+	return conn.dynamic, nil
 }
 
-// NewDiscoveryClient creates a new 'discovery' client using the provided
-// kubeconfig.  If kubeconfigPath is not set/empty, it will attempt to load
+// NewMetadataClient returns a 'metadata' client using the provided
+// kubeconfig. If kubeconfigPath is not set/empty, it will attempt to load
 // configuration using the default loading rules.
-func NewDiscoveryClient(kubeconfigPath string) (discovery.DiscoveryClient, error) {
-	var discoveryClient *discovery.DiscoveryClient
-
-	cfg, err := loadRESTConfig(kubeconfigPath)
+func NewMetadataClient(ctx context.Context, kubeconfigPath string) (metadata.Interface, error) {
+	conn, err := getConnection(ctx, kubeconfigPath)
 	if err != nil {
-		return discovery.DiscoveryClient{}, errors.WithStack(err)
+		return nil, err
 	}
+	return conn.metadata, nil
+}
 
-	discoveryClient, err = discovery.NewDiscoveryClientForConfig(cfg)
+// NewDiscoveryClient returns a 'discovery' client using the provided
+// kubeconfig.  If kubeconfigPath is not set/empty, it will attempt to load
+// configuration using the default loading rules. The discovery information is
+// cached in memory and shared by all data gatherers.
+func NewDiscoveryClient(ctx context.Context, kubeconfigPath string) (discovery.CachedDiscoveryInterface, error) {
+	conn, err := getConnection(ctx, kubeconfigPath)
 	if err != nil {
-		return *discoveryClient, errors.WithStack(err)
+		return nil, err
 	}
-
-	return *discoveryClient, nil
+	return conn.discovery, nil
 }
 
-// NewClientSet creates a new kubernetes clientset using the provided kubeconfig.
+// NewClientSet returns a kubernetes clientset using the provided kubeconfig.
 // If kubeconfigPath is not set/empty, it will attempt to load configuration using
 // the default loading rules.
-func NewClientSet(kubeconfigPath string) (kubernetes.Interface, error) {
-	var clientset *kubernetes.Clientset
-	cfg, err := loadRESTConfig(kubeconfigPath)
+func NewClientSet(ctx context.Context, kubeconfigPath string) (kubernetes.Interface, error) {
+	conn, err := getConnection(ctx, kubeconfigPath)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	clientset, err = kubernetes.NewForConfig(cfg)
+	return conn.clientset, nil
+}
+
+// loadRESTConfig loads the kubeconfig, or that of the cluster of the
+// context, and sets the rate limiter of the context, or the shared one, if
+// any.
+func loadRESTConfig(ctx context.Context, path string) (*rest.Config, error) {
+	cfg, err := loadKubeconfig(clusterOf(ctx, path))
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return nil, err
 	}
-	return clientset, nil
+	if limiter := rateLimiter(ctx); limiter != nil {
+		cfg.RateLimiter = limiter
+	}
+	return cfg, nil
 }
 
-func loadRESTConfig(path string) (*rest.Config, error) {
-	switch path {
+func loadKubeconfig(cluster Cluster) (*rest.Config, error) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: cluster.Context}
+	switch cluster.KubeconfigPath {
 	// If the kubeconfig path is not provided, use the default loading rules
 	// so we read the regular KUBECONFIG variable or create a non-interactive
 	// client for agents running in cluster
 	case "":
+		// Unless KUBECONFIG is set, agents running in cluster use the
+		// projected service account token, which client-go reads again
+		// periodically so that bound tokens are refreshed before they expire.
+		if os.Getenv(clientcmd.RecommendedConfigPathEnvVar) == "" && cluster.Context == "" {
+			cfg, err := rest.InClusterConfig()
+			if err == nil {
+				return cfg, nil
+			}
+			if !errors.Is(err, rest.ErrNotInCluster) {
+				return nil, errors.WithStack(err)
+			}
+		}
+
 		loadingrules := clientcmd.NewDefaultClientConfigLoadingRules()
 		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			loadingrules, &clientcmd.ConfigOverrides{}).ClientConfig()
+			loadingrules, overrides).ClientConfig()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -76,8 +105,8 @@ func loadRESTConfig(path string) (*rest.Config, error) {
 	// Otherwise use the explicitly named kubeconfig file.
 	default:
 		cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
-			&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
-			&clientcmd.ConfigOverrides{}).ClientConfig()
+			&clientcmd.ClientConfigLoadingRules{ExplicitPath: cluster.KubeconfigPath},
+			overrides).ClientConfig()
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
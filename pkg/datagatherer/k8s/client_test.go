@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -17,7 +18,7 @@ import (
 func TestNewDynamicClient_ExplicitKubeconfig(t *testing.T) {
 	kc := createValidTestConfig()
 	path := writeConfigToFile(t, kc)
-	_, err := NewDynamicClient(path)
+	_, err := NewDynamicClient(context.Background(), path)
 	if err != nil {
 		t.Error("failed to create client: ", err)
 	}
@@ -28,7 +29,7 @@ func TestNewDynamicClient_InferredKubeconfig(t *testing.T) {
 	path := writeConfigToFile(t, kc)
 	cleanupFn := temporarilySetEnv("KUBECONFIG", path)
 	defer cleanupFn()
-	_, err := NewDynamicClient("")
+	_, err := NewDynamicClient(context.Background(), "")
 	if err != nil {
 		t.Error("failed to create client: ", err)
 	}
@@ -37,7 +38,7 @@ func TestNewDynamicClient_InferredKubeconfig(t *testing.T) {
 func TestNewDiscoveryClient_ExplicitKubeconfig(t *testing.T) {
 	kc := createValidTestConfig()
 	path := writeConfigToFile(t, kc)
-	_, err := NewDiscoveryClient(path)
+	_, err := NewDiscoveryClient(context.Background(), path)
 	if err != nil {
 		t.Error("failed to create client: ", err)
 	}
@@ -48,7 +49,7 @@ func TestNewDiscoveryClient_InferredKubeconfig(t *testing.T) {
 	path := writeConfigToFile(t, kc)
 	cleanupFn := temporarilySetEnv("KUBECONFIG", path)
 	defer cleanupFn()
-	_, err := NewDiscoveryClient("")
+	_, err := NewDiscoveryClient(context.Background(), "")
 	if err != nil {
 		t.Error("failed to create client: ", err)
 	}
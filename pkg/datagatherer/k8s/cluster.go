@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+)
+
+// Cluster is a context of a kubeconfig file. Data gatherers created with a
+// cluster in their context connect to that cluster rather than to the one of
+// their own kubeconfig, so that a single configuration can gather data from
+// several clusters.
+type Cluster struct {
+	// KubeconfigPath is the path to the kubeconfig file. If empty, the
+	// default loading rules are used.
+	KubeconfigPath string
+	// Context is the context of the kubeconfig to use. If empty, the current
+	// context is used.
+	Context string
+}
+
+// String describes the cluster in errors.
+func (c Cluster) String() string {
+	name := "the current context"
+	if c.Context != "" {
+		name = fmt.Sprintf("context %q", c.Context)
+	}
+	if c.KubeconfigPath == "" {
+		return name + " of the default kubeconfig"
+	}
+	return fmt.Sprintf("%s of kubeconfig %q", name, c.KubeconfigPath)
+}
+
+type clusterKey struct{}
+
+// WithCluster returns a context in which the clients created by a data
+// gatherer connect to the cluster.
+func WithCluster(ctx context.Context, cluster Cluster) context.Context {
+	return context.WithValue(ctx, clusterKey{}, cluster)
+}
+
+// clusterOf returns the cluster set in the context, or the cluster of the
+// kubeconfig of the data gatherer.
+func clusterOf(ctx context.Context, kubeconfigPath string) Cluster {
+	if cluster, ok := ctx.Value(clusterKey{}).(Cluster); ok {
+		return cluster
+	}
+	return Cluster{KubeconfigPath: kubeconfigPath}
+}
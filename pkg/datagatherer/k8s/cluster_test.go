@@ -0,0 +1,49 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestLoadRESTConfigCluster(t *testing.T) {
+	config := createValidTestConfig()
+	config.Clusters["workload"] = &clientcmdapi.Cluster{Server: "https://workload.example.com"}
+	config.Contexts["workload"] = &clientcmdapi.Context{Cluster: "workload", AuthInfo: "clean"}
+	path := writeConfigToFile(t, config)
+	other := writeConfigToFile(t, createValidTestConfig())
+
+	// the current context of the kubeconfig is used by default
+	cfg, err := loadRESTConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Host != "https://example.com:8080" {
+		t.Errorf("unexpected host: %s", cfg.Host)
+	}
+
+	// the cluster of the context overrides the kubeconfig of the data
+	// gatherer
+	ctx := WithCluster(context.Background(), Cluster{KubeconfigPath: path, Context: "workload"})
+	cfg, err = loadRESTConfig(ctx, other)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.Host != "https://workload.example.com" {
+		t.Errorf("unexpected host: %s", cfg.Host)
+	}
+
+	// and the clusters get connections of their own
+	first, err := getConnection(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := getConnection(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first == second {
+		t.Errorf("expected the clusters to have connections of their own")
+	}
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// connection is a connection to a cluster. Its clients share a single HTTP
+// client, so that the data gatherers reuse the same TLS connections, and a
+// single discovery cache.
+type connection struct {
+	dynamic   dynamic.Interface
+	metadata  metadata.Interface
+	clientset kubernetes.Interface
+	discovery discovery.CachedDiscoveryInterface
+}
+
+// connectionKey identifies the connections that can be shared. Data gatherers
+// with a rate limit of their own need a connection of their own.
+type connectionKey struct {
+	cluster     Cluster
+	rateLimiter flowcontrol.RateLimiter
+}
+
+var (
+	connectionsMu sync.Mutex
+	connections   = map[connectionKey]*connection{}
+)
+
+// getConnection returns the connection for the kubeconfig, or the cluster of
+// the context, and the rate limiter of the context, creating it the first
+// time.
+func getConnection(ctx context.Context, kubeconfigPath string) (*connection, error) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	key := connectionKey{cluster: clusterOf(ctx, kubeconfigPath), rateLimiter: rateLimiter(ctx)}
+	if conn, ok := connections[key]; ok {
+		return conn, nil
+	}
+
+	cfg, err := loadRESTConfig(ctx, kubeconfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn, err := newConnection(cfg)
+	if err != nil {
+		return nil, err
+	}
+	connections[key] = conn
+
+	return conn, nil
+}
+
+// InvalidateDiscoveryCaches invalidates the discovery caches of all the
+// connections, so that the APIs installed or removed since, e.g. with CRDs,
+// are discovered again. The agent calls it at the start of each cycle. The
+// caches are refilled the next time discovery is used.
+func InvalidateDiscoveryCaches() {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	for _, conn := range connections {
+		conn.discovery.Invalidate()
+	}
+}
+
+func newConnection(cfg *rest.Config) (*connection, error) {
+	httpClient, err := rest.HTTPClientFor(cfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	conn := &connection{}
+	if conn.dynamic, err = dynamic.NewForConfigAndClient(cfg, httpClient); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.metadata, err = metadata.NewForConfigAndClient(cfg, httpClient); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if conn.clientset, err = kubernetes.NewForConfigAndClient(cfg, httpClient); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfigAndClient(cfg, httpClient)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	conn.discovery = memory.NewMemCacheClient(discoveryClient)
+
+	return conn, nil
+}
@@ -0,0 +1,122 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestGetConnection(t *testing.T) {
+	path := writeConfigToFile(t, createValidTestConfig())
+	defer func() { connections = map[connectionKey]*connection{} }()
+	defer func() { rateLimiters = map[dataGathererRateLimit]flowcontrol.RateLimiter{} }()
+
+	// data gatherers using the same kubeconfig share the clients
+	first, err := NewDynamicClient(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := NewDynamicClient(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first != second {
+		t.Errorf("expected the dynamic client to be shared")
+	}
+
+	firstDiscovery, err := NewDiscoveryClient(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	secondDiscovery, err := NewDiscoveryClient(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if firstDiscovery != secondDiscovery {
+		t.Errorf("expected the discovery cache to be shared")
+	}
+
+	// a data gatherer with a rate limit of its own has its own connection
+	ctx := WithRateLimit(context.Background(), "pods", RateLimit{QPS: 1})
+	limited, err := NewDynamicClient(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if limited == first {
+		t.Errorf("expected a rate limited data gatherer to have its own client")
+	}
+	again, err := NewDynamicClient(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if limited != again {
+		t.Errorf("expected the clients of a rate limited data gatherer to be shared")
+	}
+}
+
+func TestNewDataGathererReusesConnection(t *testing.T) {
+	path := writeConfigToFile(t, createValidTestConfig())
+	defer func() { connections = map[connectionKey]*connection{} }()
+	defer func() { rateLimiters = map[dataGathererRateLimit]flowcontrol.RateLimiter{} }()
+
+	// a data gatherer with a rate limit of its own is created again each
+	// time the config is pushed, and must not open a new connection
+	config := &ConfigDiscovery{KubeConfigPath: path}
+	first, err := config.NewDataGatherer(WithRateLimit(context.Background(), "discovery", RateLimit{QPS: 1}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := config.NewDataGatherer(WithRateLimit(context.Background(), "discovery", RateLimit{QPS: 1}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first.(*DataGathererDiscovery).cl != second.(*DataGathererDiscovery).cl {
+		t.Errorf("expected the data gatherer created again to reuse its connection")
+	}
+	if len(connections) != 1 {
+		t.Errorf("expected a single connection, got %d", len(connections))
+	}
+
+	// another data gatherer with the same rate limit has its own
+	other, err := config.NewDataGatherer(WithRateLimit(context.Background(), "other", RateLimit{QPS: 1}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if other.(*DataGathererDiscovery).cl == first.(*DataGathererDiscovery).cl {
+		t.Errorf("expected another data gatherer to have its own connection")
+	}
+}
+
+func TestInvalidateDiscoveryCaches(t *testing.T) {
+	defer func() { connections = map[connectionKey]*connection{} }()
+	fake := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "secrets", Kind: "Secret"}}},
+	}}}
+	conn := &connection{discovery: memory.NewMemCacheClient(fake)}
+	connections[connectionKey{}] = conn
+	if _, err := conn.discovery.ServerResourcesForGroupVersion("v1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// a CRD installed after the cache was filled is only discovered once
+	// the cache is invalidated
+	fake.Resources = append(fake.Resources, &metav1.APIResourceList{
+		GroupVersion: "cert-manager.io/v1", APIResources: []metav1.APIResource{{Name: "certificates", Kind: "Certificate"}},
+	})
+	if _, err := conn.discovery.ServerResourcesForGroupVersion("cert-manager.io/v1"); err == nil {
+		t.Errorf("expected the cached discovery not to know the CRD")
+	}
+	InvalidateDiscoveryCaches()
+	resources, err := conn.discovery.ServerResourcesForGroupVersion("cert-manager.io/v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(resources.APIResources) != 1 || resources.APIResources[0].Kind != "Certificate" {
+		t.Errorf("unexpected resources: %v", resources.APIResources)
+	}
+}
@@ -0,0 +1,423 @@
+package k8s
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultControlPlaneExpiryWarning is how long before their expiry the
+	// certificates are reported as expiring.
+	defaultControlPlaneExpiryWarning = 30 * 24 * time.Hour
+	// defaultKubeletPort is the port of the kubelet if the node doesn't
+	// report it.
+	defaultKubeletPort = 10250
+
+	// ControlPlaneCertificateSourceCSR is the source of the certificates
+	// issued through the CertificateSigningRequest API.
+	ControlPlaneCertificateSourceCSR = "csr"
+	// ControlPlaneCertificateSourceKubelet is the source of the serving
+	// certificates of the kubelets.
+	ControlPlaneCertificateSourceKubelet = "kubelet"
+	// ControlPlaneCertificateSourceAPIServer is the source of the serving
+	// certificates of the API servers.
+	ControlPlaneCertificateSourceAPIServer = "apiserver"
+
+	// ControlPlaneFindingExpired is reported for the certificates that have
+	// expired.
+	ControlPlaneFindingExpired = "control-plane-certificate-expired"
+	// ControlPlaneFindingExpiring is reported for the certificates that
+	// expire within the expiry warning.
+	ControlPlaneFindingExpiring = "control-plane-certificate-expiring"
+)
+
+// kubeletSigners are the signers of the kubelet certificates.
+var kubeletSigners = map[string]bool{
+	certificatesv1.KubeletServingSignerName:             true,
+	certificatesv1.KubeAPIServerClientKubeletSignerName: true,
+}
+
+// ConfigControlPlaneCertificates contains the configuration for the
+// k8s-control-plane-certificates data-gatherer.
+type ConfigControlPlaneCertificates struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// DisableProbes disables the TLS handshakes with the kubelets and the
+	// API servers, so that only the CertificateSigningRequests are read.
+	DisableProbes bool `yaml:"disable-probes"`
+	// ExpiryWarning is how long before their expiry the certificates are
+	// reported as expiring. Defaults to 720h.
+	ExpiryWarning time.Duration `yaml:"expiry-warning"`
+	// Timeout is the timeout of each handshake. Defaults to 5s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// UnmarshalYAML unmarshals the ConfigControlPlaneCertificates.
+func (c *ConfigControlPlaneCertificates) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string        `yaml:"kubeconfig"`
+		DisableProbes  bool          `yaml:"disable-probes"`
+		ExpiryWarning  time.Duration `yaml:"expiry-warning"`
+		Timeout        time.Duration `yaml:"timeout"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.DisableProbes = aux.DisableProbes
+	c.ExpiryWarning = aux.ExpiryWarning
+	c.Timeout = aux.Timeout
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigControlPlaneCertificates) Validate() error {
+	var errors []string
+	if c.ExpiryWarning < 0 {
+		errors = append(errors, "expiry-warning must not be negative")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the
+// k8s-control-plane-certificates data-gatherer.
+func (c *ConfigControlPlaneCertificates) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigControlPlaneCertificates) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	g := &DataGathererControlPlaneCertificates{
+		ctx:           ctx,
+		clientset:     clientset,
+		probes:        !c.DisableProbes,
+		expiryWarning: c.ExpiryWarning,
+		timeout:       c.Timeout,
+		dial:          (&net.Dialer{}).DialContext,
+		now:           time.Now,
+	}
+	if g.expiryWarning == 0 {
+		g.expiryWarning = defaultControlPlaneExpiryWarning
+	}
+	if g.timeout == 0 {
+		g.timeout = defaultTLSProbeTimeout
+	}
+
+	return g, nil
+}
+
+// DataGathererControlPlaneCertificates reports the expiry of the
+// certificates of the kubelets and the API servers: the kubelet client and
+// serving certificates issued through the CertificateSigningRequest API, and
+// the serving certificates of the kubelets and the API servers, read with a
+// TLS handshake. Expired kubelet certificates take nodes out of the cluster,
+// and are a common cause of outages.
+type DataGathererControlPlaneCertificates struct {
+	ctx           context.Context
+	clientset     kubernetes.Interface
+	probes        bool
+	expiryWarning time.Duration
+	timeout       time.Duration
+
+	// dial connects to the kubelets and the API servers, it is replaced in
+	// tests.
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+	now  func() time.Time
+}
+
+// ControlPlaneCertificate is a certificate of a kubelet or an API server.
+type ControlPlaneCertificate struct {
+	// Source is csr, kubelet or apiserver.
+	Source string `json:"source"`
+	// Node is the node of the kubelet certificates.
+	Node string `json:"node,omitempty"`
+	// Name is the name of the CertificateSigningRequest, or the address the
+	// certificate was served on.
+	Name string `json:"name"`
+	// SignerName is the signer of the CertificateSigningRequest.
+	SignerName string    `json:"signerName,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Issuer     string    `json:"issuer,omitempty"`
+	NotBefore  *api.Time `json:"notBefore,omitempty"`
+	NotAfter   *api.Time `json:"notAfter,omitempty"`
+	// Error is the error of the handshake, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Run is a no-op, the certificates are read on every Fetch.
+func (g *DataGathererControlPlaneCertificates) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererControlPlaneCertificates) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererControlPlaneCertificates) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch reads the kubelet certificates of the CertificateSigningRequests and
+// probes the kubelets and the API servers, unless the probes are disabled.
+// The CertificateSigningRequest API is skipped if the agent isn't allowed to
+// list them, and the failed handshakes are reported in the certificates
+// rather than failing the Fetch.
+func (g *DataGathererControlPlaneCertificates) Fetch() (interface{}, int, error) {
+	certificates, err := g.csrCertificates()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if g.probes {
+		nodes, err := g.clientset.CoreV1().Nodes().List(g.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to list nodes: %w", err)
+		}
+		for _, node := range nodes.Items {
+			address := kubeletAddress(&node)
+			if address == "" {
+				continue
+			}
+			certificate := ControlPlaneCertificate{Source: ControlPlaneCertificateSourceKubelet, Node: node.Name, Name: address}
+			g.probe(&certificate)
+			certificates = append(certificates, certificate)
+		}
+
+		addresses, err := g.apiServerAddresses()
+		if err != nil {
+			return nil, -1, err
+		}
+		for _, address := range addresses {
+			certificate := ControlPlaneCertificate{Source: ControlPlaneCertificateSourceAPIServer, Name: address}
+			g.probe(&certificate)
+			certificates = append(certificates, certificate)
+		}
+	}
+
+	findings := []api.Finding{}
+	now := g.now()
+	for _, certificate := range certificates {
+		if certificate.NotAfter == nil {
+			continue
+		}
+		resource := controlPlaneCertificateResource(certificate)
+		switch {
+		case !now.Before(certificate.NotAfter.Time):
+			findings = append(findings, controlPlaneCertificateFinding(ControlPlaneFindingExpired, resource,
+				fmt.Sprintf("the %s certificate %s expired at %s", certificate.Source, certificate.Name, certificate.NotAfter.Format(time.RFC3339))))
+		case now.Add(g.expiryWarning).After(certificate.NotAfter.Time):
+			findings = append(findings, controlPlaneCertificateFinding(ControlPlaneFindingExpiring, resource,
+				fmt.Sprintf("the %s certificate %s expires at %s", certificate.Source, certificate.Name, certificate.NotAfter.Format(time.RFC3339))))
+		}
+	}
+
+	response := map[string]interface{}{
+		"certificates": certificates,
+		"findings":     findings,
+	}
+
+	return response, len(certificates), nil
+}
+
+// csrCertificates returns the latest certificate of each node and kubelet
+// signer issued through the CertificateSigningRequest API. Issued
+// CertificateSigningRequests are garbage collected after an hour, so only
+// the recently renewed certificates are found.
+func (g *DataGathererControlPlaneCertificates) csrCertificates() ([]ControlPlaneCertificate, error) {
+	csrs, err := g.clientset.CertificatesV1().CertificateSigningRequests().List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsForbidden(err) {
+		return []ControlPlaneCertificate{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificatesigningrequests: %w", err)
+	}
+
+	latest := map[string]ControlPlaneCertificate{}
+	for _, csr := range csrs.Items {
+		if !kubeletSigners[csr.Spec.SignerName] || len(csr.Status.Certificate) == 0 {
+			continue
+		}
+		block, _ := pem.Decode(csr.Status.Certificate)
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		certificate := newControlPlaneCertificate(cert)
+		certificate.Source = ControlPlaneCertificateSourceCSR
+		certificate.Node = strings.TrimPrefix(csr.Spec.Username, "system:node:")
+		certificate.Name = csr.Name
+		certificate.SignerName = csr.Spec.SignerName
+
+		key := certificate.Node + "/" + certificate.SignerName
+		if previous, ok := latest[key]; !ok || previous.NotAfter.Before(certificate.NotAfter.Time) {
+			latest[key] = certificate
+		}
+	}
+
+	certificates := make([]ControlPlaneCertificate, 0, len(latest))
+	for _, certificate := range latest {
+		certificates = append(certificates, certificate)
+	}
+	sort.Slice(certificates, func(i, j int) bool {
+		if certificates[i].Node != certificates[j].Node {
+			return certificates[i].Node < certificates[j].Node
+		}
+		return certificates[i].SignerName < certificates[j].SignerName
+	})
+	return certificates, nil
+}
+
+// apiServerAddresses returns the addresses of the API servers, from the
+// Endpoints of the kubernetes Service.
+func (g *DataGathererControlPlaneCertificates) apiServerAddresses() ([]string, error) {
+	endpoints, err := g.clientset.CoreV1().Endpoints(metav1.NamespaceDefault).Get(g.ctx, "kubernetes", metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the endpoints of the kubernetes service: %w", err)
+	}
+	var addresses []string
+	for _, subset := range endpoints.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != "https" {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				addresses = append(addresses, net.JoinHostPort(address.IP, strconv.Itoa(int(port.Port))))
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// probe performs a handshake with the address of the certificate and
+// records the certificate it served.
+func (g *DataGathererControlPlaneCertificates) probe(certificate *ControlPlaneCertificate) {
+	ctx, cancel := context.WithTimeout(g.ctx, g.timeout)
+	defer cancel()
+
+	rawConn, err := g.dial(ctx, "tcp", certificate.Name)
+	if err != nil {
+		certificate.Error = err.Error()
+		return
+	}
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		// the kubelet serving certificates are often self-signed, and the
+		// certificates are recorded rather than verified
+		InsecureSkipVerify: true,
+	})
+	if err := conn.HandshakeContext(ctx); err != nil {
+		certificate.Error = err.Error()
+		return
+	}
+	if peers := conn.ConnectionState().PeerCertificates; len(peers) > 0 {
+		served := newControlPlaneCertificate(peers[0])
+		certificate.Subject, certificate.Issuer = served.Subject, served.Issuer
+		certificate.NotBefore, certificate.NotAfter = served.NotBefore, served.NotAfter
+	}
+}
+
+// kubeletAddress returns the address of the kubelet of the node, on its
+// internal IP, or nothing if the node has none.
+func kubeletAddress(node *corev1.Node) string {
+	port := int(node.Status.DaemonEndpoints.KubeletEndpoint.Port)
+	if port == 0 {
+		port = defaultKubeletPort
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeInternalIP {
+			return net.JoinHostPort(address.Address, strconv.Itoa(port))
+		}
+	}
+	return ""
+}
+
+func newControlPlaneCertificate(cert *x509.Certificate) ControlPlaneCertificate {
+	return ControlPlaneCertificate{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: &api.Time{Time: cert.NotBefore.UTC()},
+		NotAfter:  &api.Time{Time: cert.NotAfter.UTC()},
+	}
+}
+
+// controlPlaneCertificateResource returns the Node of the kubelet
+// certificates, or the endpoint of the API server.
+func controlPlaneCertificateResource(certificate ControlPlaneCertificate) api.ResourceRef {
+	if certificate.Node != "" {
+		return api.ResourceRef{Kind: "Node", Name: certificate.Node}
+	}
+	return api.ResourceRef{Kind: "Endpoint", Name: certificate.Name}
+}
+
+// controlPlaneFindingSeverities and controlPlaneFindingRemediations hold the
+// severity and the remediation hint of each rule.
+var (
+	controlPlaneFindingSeverities = map[string]api.Severity{
+		ControlPlaneFindingExpired:  api.SeverityCritical,
+		ControlPlaneFindingExpiring: api.SeverityHigh,
+	}
+	controlPlaneFindingRemediations = map[string]string{
+		ControlPlaneFindingExpired:  "Renew the certificate, e.g. with kubeadm certs renew, and restart the component serving it.",
+		ControlPlaneFindingExpiring: "Check that certificate rotation is enabled for the kubelets, with rotateCertificates and serverTLSBootstrap, or renew the control plane certificates, e.g. with kubeadm certs renew.",
+	}
+)
+
+func controlPlaneCertificateFinding(ruleID string, resource api.ResourceRef, message string) api.Finding {
+	return api.Finding{
+		RuleID:      ruleID,
+		Severity:    controlPlaneFindingSeverities[ruleID],
+		Resource:    resource,
+		Message:     message,
+		Remediation: controlPlaneFindingRemediations[ruleID],
+	}
+}
@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestControlPlaneCertificatesGatherer_Fetch(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	now := time.Now()
+	csr := func(name, node, signer string, certificate []byte) *certificatesv1.CertificateSigningRequest {
+		return &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       certificatesv1.CertificateSigningRequestSpec{Username: "system:node:" + node, SignerName: signer},
+			Status:     certificatesv1.CertificateSigningRequestStatus{Certificate: certificate},
+		}
+	}
+	clientset := fakeclientset.NewSimpleClientset(
+		csr("csr-old", "node-1", certificatesv1.KubeletServingSignerName, encodeTestCertForHosts(t, now.Add(-24*time.Hour), "node-1")),
+		csr("csr-serving", "node-1", certificatesv1.KubeletServingSignerName, encodeTestCertForHosts(t, now.Add(10*24*time.Hour), "node-1")),
+		csr("csr-client", "node-1", certificatesv1.KubeAPIServerClientKubeletSignerName, encodeTestCertForHosts(t, now.Add(-time.Hour))),
+		csr("csr-pending", "node-2", certificatesv1.KubeletServingSignerName, nil),
+		csr("csr-other", "node-2", "example.com/signer", encodeTestCertForHosts(t, now.Add(-time.Hour))),
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+			Status: corev1.NodeStatus{
+				Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+			},
+		},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "kubernetes"},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.10"}},
+				Ports:     []corev1.EndpointPort{{Name: "https", Port: 6443}},
+			}},
+		},
+	)
+
+	dg, err := (&ConfigControlPlaneCertificates{}).newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the kubelet is served by the test server, the API server is down
+	dg.(*DataGathererControlPlaneCertificates).dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address != "10.0.0.1:10250" {
+			return nil, errors.New("connection refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	dg.(*DataGathererControlPlaneCertificates).now = func() time.Time { return now }
+
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	response := data.(map[string]interface{})
+	certificates := response["certificates"].([]ControlPlaneCertificate)
+	if count != 4 {
+		t.Errorf("expected 4 certificates, got %d", count)
+	}
+
+	var summaries []string
+	for _, certificate := range certificates {
+		summary := certificate.Source + " " + certificate.Node + " " + certificate.Name
+		if certificate.Error != "" {
+			summary += " failed"
+		}
+		summaries = append(summaries, summary)
+	}
+	expected := []string{
+		"csr node-1 csr-client",
+		"csr node-1 csr-serving",
+		"kubelet node-1 10.0.0.1:10250",
+		"apiserver  10.0.0.10:6443 failed",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, summaries); !equal {
+		t.Errorf("unexpected certificates:\n%s", diff)
+	}
+	if served := certificates[2].NotAfter; served == nil || !served.Equal(server.Certificate().NotAfter) {
+		t.Errorf("expected the served certificate to expire at %s, got %v", server.Certificate().NotAfter, served)
+	}
+
+	var rules []string
+	for _, finding := range response["findings"].([]api.Finding) {
+		rules = append(rules, finding.RuleID+" "+finding.Resource.Kind+"/"+finding.Resource.Name)
+	}
+	expectedRules := []string{
+		"control-plane-certificate-expired Node/node-1",
+		"control-plane-certificate-expiring Node/node-1",
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestControlPlaneCertificatesGatherer_DisableProbes(t *testing.T) {
+	clientset := fakeclientset.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+		},
+	})
+	dg, err := (&ConfigControlPlaneCertificates{DisableProbes: true}).newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	dg.(*DataGathererControlPlaneCertificates).dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		t.Errorf("unexpected probe of %s", address)
+		return nil, errors.New("connection refused")
+	}
+
+	_, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 0 {
+		t.Errorf("expected no certificates, got %d", count)
+	}
+}
@@ -0,0 +1,294 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// allCRDGroups configures the k8s-crds data gatherer to report the CRDs
+	// of all API groups.
+	allCRDGroups = "*"
+
+	// CRDFindingDeprecatedVersionServed is reported for the deprecated
+	// versions of a CRD that are still served.
+	CRDFindingDeprecatedVersionServed = "deprecated-version-served"
+	// CRDFindingStoredVersionRemoved is reported for the versions objects
+	// may still be stored in that the CRD no longer defines, which can't be
+	// read until they are migrated.
+	CRDFindingStoredVersionRemoved = "stored-version-removed"
+	// CRDFindingConversionMissing is reported for CRDs serving several
+	// versions without a conversion webhook.
+	CRDFindingConversionMissing = "conversion-webhook-missing"
+)
+
+// crdGVR is the resource of the CustomResourceDefinitions.
+var crdGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// defaultCRDGroups are the API groups whose CRDs are reported by the k8s-crds
+// data gatherer if none are configured: those of cert-manager and of the
+// Venafi and Jetstack components, and their subdomains.
+var defaultCRDGroups = []string{"cert-manager.io", "jetstack.io", "venafi.com"}
+
+// ConfigCRDs contains the configuration for the k8s-crds data-gatherer.
+type ConfigCRDs struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// Groups are the API groups whose CRDs are reported, including their
+	// subdomains, or * for all groups.
+	Groups []string `yaml:"groups"`
+}
+
+// UnmarshalYAML unmarshals the ConfigCRDs.
+func (c *ConfigCRDs) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string   `yaml:"kubeconfig"`
+		Groups         []string `yaml:"groups"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.Groups = aux.Groups
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-crds data-gatherer.
+func (c *ConfigCRDs) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, cl)
+}
+
+func (c *ConfigCRDs) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface) (datagatherer.DataGatherer, error) {
+	groups := c.Groups
+	if len(groups) == 0 {
+		groups = defaultCRDGroups
+	}
+	return &DataGathererCRDs{
+		ctx:    ctx,
+		cl:     cl,
+		groups: groups,
+	}, nil
+}
+
+// DataGathererCRDs reports the versions of the CustomResourceDefinitions and
+// how they are converted, rather than the CRDs themselves, whose schemas are
+// large.
+type DataGathererCRDs struct {
+	ctx    context.Context
+	cl     dynamic.Interface
+	groups []string
+}
+
+// CRD describes the versions of a CustomResourceDefinition.
+type CRD struct {
+	Name  string `json:"name"`
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+	// Scope is Namespaced or Cluster.
+	Scope    string       `json:"scope"`
+	Versions []CRDVersion `json:"versions"`
+	// StoredVersions are the versions objects may be stored in in etcd.
+	StoredVersions []string `json:"storedVersions"`
+	// Conversion is the conversion strategy, None or Webhook.
+	Conversion string `json:"conversion"`
+	// ConversionWebhook is the namespace/name of the Service, or the URL, of
+	// the conversion webhook, if any.
+	ConversionWebhook string `json:"conversionWebhook,omitempty"`
+}
+
+// CRDVersion is a version defined by a CRD.
+type CRDVersion struct {
+	Name               string `json:"name"`
+	Served             bool   `json:"served"`
+	Storage            bool   `json:"storage"`
+	Deprecated         bool   `json:"deprecated,omitempty"`
+	DeprecationWarning string `json:"deprecationWarning,omitempty"`
+}
+
+// customResourceDefinition is the part of an apiextensions.k8s.io/v1
+// CustomResourceDefinition that is evaluated.
+type customResourceDefinition struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind string `json:"kind"`
+		} `json:"names"`
+		Scope      string       `json:"scope"`
+		Versions   []CRDVersion `json:"versions"`
+		Conversion *struct {
+			Strategy string `json:"strategy"`
+			Webhook  *struct {
+				ClientConfig *struct {
+					URL     *string `json:"url"`
+					Service *struct {
+						Namespace string `json:"namespace"`
+						Name      string `json:"name"`
+					} `json:"service"`
+				} `json:"clientConfig"`
+			} `json:"webhook"`
+		} `json:"conversion"`
+	} `json:"spec"`
+	Status struct {
+		StoredVersions []string `json:"storedVersions"`
+	} `json:"status"`
+}
+
+// Run is a no-op, CRDs are listed on every Fetch.
+func (g *DataGathererCRDs) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererCRDs) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererCRDs) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the CRDs of the configured API groups and analyses their
+// versions.
+func (g *DataGathererCRDs) Fetch() (interface{}, int, error) {
+	list, err := g.cl.Resource(crdGVR).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list customresourcedefinitions: %w", err)
+	}
+
+	crds := []*CRD{}
+	findings := []api.Finding{}
+	for _, item := range list.Items {
+		var definition customResourceDefinition
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &definition); err != nil {
+			return nil, -1, fmt.Errorf("failed to decode customresourcedefinition %s: %w", item.GetName(), err)
+		}
+		if !g.matches(definition.Spec.Group) {
+			continue
+		}
+		crd := newCRD(&definition)
+		crds = append(crds, crd)
+		findings = append(findings, crdFindings(crd)...)
+	}
+	sort.Slice(crds, func(i, j int) bool { return crds[i].Name < crds[j].Name })
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Resource.Name < findings[j].Resource.Name })
+
+	response := map[string]interface{}{
+		"crds":     crds,
+		"findings": findings,
+	}
+
+	return response, len(crds), nil
+}
+
+// matches returns whether the group is one of the configured groups, or a
+// subdomain of one of them.
+func (g *DataGathererCRDs) matches(group string) bool {
+	for _, configured := range g.groups {
+		if configured == allCRDGroups || group == configured || strings.HasSuffix(group, "."+configured) {
+			return true
+		}
+	}
+	return false
+}
+
+func newCRD(definition *customResourceDefinition) *CRD {
+	crd := &CRD{
+		Name:           definition.Name,
+		Group:          definition.Spec.Group,
+		Kind:           definition.Spec.Names.Kind,
+		Scope:          definition.Spec.Scope,
+		Versions:       definition.Spec.Versions,
+		StoredVersions: definition.Status.StoredVersions,
+		Conversion:     "None",
+	}
+	if crd.Versions == nil {
+		crd.Versions = []CRDVersion{}
+	}
+	if crd.StoredVersions == nil {
+		crd.StoredVersions = []string{}
+	}
+	if conversion := definition.Spec.Conversion; conversion != nil && conversion.Strategy != "" {
+		crd.Conversion = conversion.Strategy
+		if conversion.Webhook != nil && conversion.Webhook.ClientConfig != nil {
+			clientConfig := conversion.Webhook.ClientConfig
+			switch {
+			case clientConfig.Service != nil:
+				crd.ConversionWebhook = clientConfig.Service.Namespace + "/" + clientConfig.Service.Name
+			case clientConfig.URL != nil:
+				crd.ConversionWebhook = *clientConfig.URL
+			}
+		}
+	}
+	return crd
+}
+
+// crdFindings reports the deprecated versions that are still served, the
+// stored versions that are no longer defined, and the CRDs serving several
+// versions without a conversion webhook.
+func crdFindings(crd *CRD) []api.Finding {
+	var findings []api.Finding
+	finding := func(ruleID string, severity api.Severity, message, remediation string) {
+		findings = append(findings, api.Finding{
+			RuleID:      ruleID,
+			Severity:    severity,
+			Resource:    api.ResourceRef{Kind: "CustomResourceDefinition", Name: crd.Name},
+			Message:     message,
+			Remediation: remediation,
+		})
+	}
+
+	defined := map[string]bool{}
+	served := 0
+	for _, version := range crd.Versions {
+		defined[version.Name] = true
+		if !version.Served {
+			continue
+		}
+		served++
+		if version.Deprecated {
+			message := fmt.Sprintf("deprecated version %s is still served", version.Name)
+			if version.DeprecationWarning != "" {
+				message += ": " + version.DeprecationWarning
+			}
+			finding(CRDFindingDeprecatedVersionServed, api.SeverityLow, message,
+				fmt.Sprintf("Migrate the clients and manifests using %s/%s to a supported version.", crd.Group, version.Name))
+		}
+	}
+	for _, stored := range crd.StoredVersions {
+		if !defined[stored] {
+			finding(CRDFindingStoredVersionRemoved, api.SeverityHigh,
+				fmt.Sprintf("objects may be stored in version %s, which is no longer defined", stored),
+				"Restore the version, migrate the stored objects to the storage version, then remove the version from status.storedVersions.")
+		}
+	}
+	if served > 1 && crd.Conversion != "Webhook" {
+		finding(CRDFindingConversionMissing, api.SeverityInfo,
+			fmt.Sprintf("%d versions are served without a conversion webhook", served),
+			"Configure a conversion webhook, or only serve versions with the same schema.")
+	}
+	return findings
+}
@@ -0,0 +1,174 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const testCRDs = `
+- apiVersion: apiextensions.k8s.io/v1
+  kind: CustomResourceDefinition
+  metadata:
+    name: certificates.cert-manager.io
+  spec:
+    group: cert-manager.io
+    names:
+      kind: Certificate
+      plural: certificates
+    scope: Namespaced
+    conversion:
+      strategy: Webhook
+      webhook:
+        conversionReviewVersions: [v1]
+        clientConfig:
+          service:
+            namespace: cert-manager
+            name: cert-manager-webhook
+    versions:
+    - name: v1alpha2
+      served: true
+      storage: false
+      deprecated: true
+      deprecationWarning: cert-manager.io/v1alpha2 Certificate is deprecated
+      schema:
+        openAPIV3Schema:
+          type: object
+    - name: v1
+      served: true
+      storage: true
+      schema:
+        openAPIV3Schema:
+          type: object
+  status:
+    storedVersions: [v1alpha1, v1]
+- apiVersion: apiextensions.k8s.io/v1
+  kind: CustomResourceDefinition
+  metadata:
+    name: venaficlusterissuers.jetstack.io
+  spec:
+    group: jetstack.io
+    names:
+      kind: VenafiClusterIssuer
+      plural: venaficlusterissuers
+    scope: Cluster
+    versions:
+    - name: v1alpha1
+      served: true
+      storage: true
+    - name: v1alpha2
+      served: true
+      storage: false
+  status:
+    storedVersions: [v1alpha1]
+- apiVersion: apiextensions.k8s.io/v1
+  kind: CustomResourceDefinition
+  metadata:
+    name: widgets.example.com
+  spec:
+    group: example.com
+    names:
+      kind: Widget
+      plural: widgets
+    scope: Namespaced
+    versions:
+    - name: v1
+      served: true
+      storage: true
+`
+
+func TestCRDsGatherer_Fetch(t *testing.T) {
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(testCRDs), &items); err != nil {
+		t.Fatal(err)
+	}
+	var objects []runtime.Object
+	for _, item := range items {
+		objects = append(objects, &unstructured.Unstructured{Object: item})
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		crdGVR: "CustomResourceDefinitionList",
+	}, objects...)
+
+	config := ConfigCRDs{}
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected the CRDs of the default groups only, got %d", count)
+	}
+
+	expectedCRDs := []*CRD{
+		{
+			Name:  "certificates.cert-manager.io",
+			Group: "cert-manager.io",
+			Kind:  "Certificate",
+			Scope: "Namespaced",
+			Versions: []CRDVersion{
+				{Name: "v1alpha2", Served: true, Deprecated: true, DeprecationWarning: "cert-manager.io/v1alpha2 Certificate is deprecated"},
+				{Name: "v1", Served: true, Storage: true},
+			},
+			StoredVersions:    []string{"v1alpha1", "v1"},
+			Conversion:        "Webhook",
+			ConversionWebhook: "cert-manager/cert-manager-webhook",
+		},
+		{
+			Name:  "venaficlusterissuers.jetstack.io",
+			Group: "jetstack.io",
+			Kind:  "VenafiClusterIssuer",
+			Scope: "Cluster",
+			Versions: []CRDVersion{
+				{Name: "v1alpha1", Served: true, Storage: true},
+				{Name: "v1alpha2", Served: true},
+			},
+			StoredVersions: []string{"v1alpha1"},
+			Conversion:     "None",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedCRDs, data.(map[string]interface{})["crds"]); !equal {
+		t.Errorf("unexpected CRDs:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"certificates.cert-manager.io " + CRDFindingDeprecatedVersionServed,
+		"certificates.cert-manager.io " + CRDFindingStoredVersionRemoved,
+		"venaficlusterissuers.jetstack.io " + CRDFindingConversionMissing,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestCRDsGatherer_Groups(t *testing.T) {
+	g := &DataGathererCRDs{groups: []string{"cert-manager.io"}}
+	for group, expected := range map[string]bool{
+		"cert-manager.io":      true,
+		"acme.cert-manager.io": true,
+		"evilcert-manager.io":  false,
+		"example.com":          false,
+	} {
+		if got := g.matches(group); got != expected {
+			t.Errorf("%s: got=%v want=%v", group, got, expected)
+		}
+	}
+	if !(&DataGathererCRDs{groups: []string{allCRDGroups}}).matches("example.com") {
+		t.Errorf("expected * to match all groups")
+	}
+}
@@ -32,7 +32,7 @@ func (c *ConfigDiscovery) UnmarshalYAML(unmarshal func(interface{}) error) error
 // NewDataGatherer constructs a new instance of the generic K8s data-gatherer for the provided
 // GroupVersionResource.
 func (c *ConfigDiscovery) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
-	cl, err := NewDiscoveryClient(c.KubeConfigPath)
+	cl, err := NewDiscoveryClient(ctx, c.KubeConfigPath)
 	if err != nil {
 		return nil, err
 	}
@@ -43,7 +43,7 @@ func (c *ConfigDiscovery) NewDataGatherer(ctx context.Context) (datagatherer.Dat
 // DataGathererDiscovery stores the config for a k8s-discovery datagatherer
 type DataGathererDiscovery struct {
 	// The 'discovery' client used for fetching data.
-	cl discovery.DiscoveryClient
+	cl discovery.DiscoveryInterface
 }
 
 func (g *DataGathererDiscovery) Run(stopCh <-chan struct{}) error {
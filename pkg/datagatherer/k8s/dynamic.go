@@ -13,16 +13,22 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	k8scache "k8s.io/client-go/tools/cache"
 
 	"github.com/jetstack/preflight/api"
@@ -35,23 +41,77 @@ type ConfigDynamic struct {
 	KubeConfigPath string `yaml:"kubeconfig"`
 	// GroupVersionResource identifies the resource type to gather.
 	GroupVersionResource schema.GroupVersionResource
-	// ExcludeNamespaces is a list of namespaces to exclude.
+	// AdditionalGroupVersionResources are gathered into the same result set
+	// as GroupVersionResource. They are set when `resource-type` is given as
+	// a list, in which case GroupVersionResource is the first entry.
+	AdditionalGroupVersionResources []schema.GroupVersionResource
+	// ExcludeNamespaces is a list of namespaces to exclude. Entries can be
+	// glob patterns, regular expressions between slashes, or negated with a
+	// leading `!`.
 	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
-	// IncludeNamespaces is a list of namespaces to include.
+	// IncludeNamespaces is a list of namespaces to include, in the same
+	// format as ExcludeNamespaces.
 	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// IncludeNamespaceLabelSelector limits the gathered resources to the
+	// namespaces matching the label selector. The namespaces are listed each
+	// time the data is fetched, so labelling a namespace is enough to start
+	// gathering from it.
+	IncludeNamespaceLabelSelector string `yaml:"include-namespace-label-selector"`
+	// Optional makes the data gatherer a no-op if the resource type is not
+	// served by the cluster, rather than failing to sync.
+	Optional bool `yaml:"optional"`
+	// FieldFilters select and remove fields of the gathered resources.
+	FieldFilters FieldFilters `yaml:"field-filters"`
+	// DisableSanitization keeps `metadata.managedFields` and the
+	// `kubectl.kubernetes.io/last-applied-configuration` annotation, which
+	// are otherwise removed from all resources as they are received.
+	DisableSanitization bool `yaml:"disable-sanitization"`
+	// MetadataOnly gathers only the metadata of the resources, e.g. their
+	// names, labels and owner references, using metadata-only requests. The
+	// spec and status of the resources are neither received nor held in
+	// memory.
+	MetadataOnly bool `yaml:"metadata-only"`
+}
+
+type resourceType struct {
+	Group    string `yaml:"group"`
+	Version  string `yaml:"version"`
+	Resource string `yaml:"resource"`
+}
+
+// resourceTypes is the `resource-type` of a ConfigDynamic, which can either
+// be a single resource type or a list of them.
+type resourceTypes []resourceType
+
+// UnmarshalYAML unmarshals a single resource type or a list of them.
+func (r *resourceTypes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single resourceType
+	if err := unmarshal(&single); err == nil {
+		*r = resourceTypes{single}
+		return nil
+	}
+
+	var list []resourceType
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*r = list
+
+	return nil
 }
 
 // UnmarshalYAML unmarshals the ConfigDynamic resolving GroupVersionResource.
 func (c *ConfigDynamic) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	aux := struct {
-		KubeConfigPath string `yaml:"kubeconfig"`
-		ResourceType   struct {
-			Group    string `yaml:"group"`
-			Version  string `yaml:"version"`
-			Resource string `yaml:"resource"`
-		} `yaml:"resource-type"`
-		ExcludeNamespaces []string `yaml:"exclude-namespaces"`
-		IncludeNamespaces []string `yaml:"include-namespaces"`
+		KubeConfigPath                string        `yaml:"kubeconfig"`
+		ResourceType                  resourceTypes `yaml:"resource-type"`
+		ExcludeNamespaces             []string      `yaml:"exclude-namespaces"`
+		IncludeNamespaces             []string      `yaml:"include-namespaces"`
+		IncludeNamespaceLabelSelector string        `yaml:"include-namespace-label-selector"`
+		Optional                      bool          `yaml:"optional"`
+		FieldFilters                  FieldFilters  `yaml:"field-filters"`
+		DisableSanitization           bool          `yaml:"disable-sanitization"`
+		MetadataOnly                  bool          `yaml:"metadata-only"`
 	}{}
 	err := unmarshal(&aux)
 	if err != nil {
@@ -59,15 +119,55 @@ func (c *ConfigDynamic) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 
 	c.KubeConfigPath = aux.KubeConfigPath
-	c.GroupVersionResource.Group = aux.ResourceType.Group
-	c.GroupVersionResource.Version = aux.ResourceType.Version
-	c.GroupVersionResource.Resource = aux.ResourceType.Resource
+	c.GroupVersionResource = schema.GroupVersionResource{}
+	c.AdditionalGroupVersionResources = nil
+	for i, rt := range aux.ResourceType {
+		gvr := schema.GroupVersionResource{
+			Group:    rt.Group,
+			Version:  rt.Version,
+			Resource: rt.Resource,
+		}
+		if i == 0 {
+			c.GroupVersionResource = gvr
+			continue
+		}
+		c.AdditionalGroupVersionResources = append(c.AdditionalGroupVersionResources, gvr)
+	}
 	c.ExcludeNamespaces = aux.ExcludeNamespaces
 	c.IncludeNamespaces = aux.IncludeNamespaces
+	c.IncludeNamespaceLabelSelector = aux.IncludeNamespaceLabelSelector
+	c.Optional = aux.Optional
+	c.FieldFilters = aux.FieldFilters
+	c.DisableSanitization = aux.DisableSanitization
+	c.MetadataOnly = aux.MetadataOnly
+
+	return nil
+}
+
+// GroupVersionResources returns all the resource types gathered by the
+// data-gatherer.
+func (c *ConfigDynamic) GroupVersionResources() []schema.GroupVersionResource {
+	return append([]schema.GroupVersionResource{c.GroupVersionResource}, c.AdditionalGroupVersionResources...)
+}
 
+// ValidateNamespaces checks the patterns of the included and excluded
+// namespaces, so that mistakes are reported when the config is parsed.
+func (c *ConfigDynamic) ValidateNamespaces() error {
+	if _, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces); err != nil {
+		return err
+	}
+	if _, err := labels.Parse(c.IncludeNamespaceLabelSelector); err != nil {
+		return fmt.Errorf("invalid namespace label selector %q: %s", c.IncludeNamespaceLabelSelector, err)
+	}
 	return nil
 }
 
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigDynamic) Validate() error {
+	return c.validate()
+}
+
 // validate validates the configuration.
 func (c *ConfigDynamic) validate() error {
 	var errors []string
@@ -79,6 +179,25 @@ func (c *ConfigDynamic) validate() error {
 		errors = append(errors, "invalid configuration: GroupVersionResource.Resource cannot be empty")
 	}
 
+	seen := map[schema.GroupVersionResource]bool{c.GroupVersionResource: true}
+	for _, gvr := range c.AdditionalGroupVersionResources {
+		if gvr.Resource == "" {
+			errors = append(errors, "invalid configuration: AdditionalGroupVersionResources.Resource cannot be empty")
+		}
+		if seen[gvr] {
+			errors = append(errors, fmt.Sprintf("invalid configuration: resource type %q is listed more than once", gvr))
+		}
+		seen[gvr] = true
+	}
+
+	if err := c.ValidateNamespaces(); err != nil {
+		errors = append(errors, fmt.Sprintf("invalid configuration: %s", err))
+	}
+
+	if err := c.FieldFilters.validate(); err != nil {
+		errors = append(errors, fmt.Sprintf("invalid configuration: %s", err))
+	}
+
 	if len(errors) > 0 {
 		return fmt.Errorf(strings.Join(errors, ", "))
 	}
@@ -129,16 +248,52 @@ var kubernetesNativeResources = map[schema.GroupVersionResource]sharedInformerFu
 
 // NewDataGatherer constructs a new instance of the generic K8s data-gatherer for the provided
 func (c *ConfigDynamic) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
-	cl, err := NewDynamicClient(c.KubeConfigPath)
+	if len(c.AdditionalGroupVersionResources) > 0 {
+		return c.newMultiDataGatherer(ctx, func(single *ConfigDynamic) (datagatherer.DataGatherer, error) {
+			return single.NewDataGatherer(ctx)
+		})
+	}
+
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
 	if err != nil {
 		return nil, err
 	}
 
-	if isNativeResource(c.GroupVersionResource) {
-		clientset, err := NewClientSet(c.KubeConfigPath)
+	var discoveryClient discovery.DiscoveryInterface
+	if c.Optional || c.MetadataOnly {
+		if discoveryClient, err = NewDiscoveryClient(ctx, c.KubeConfigPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Optional {
+		served, err := isServedResource(discoveryClient, c.GroupVersionResource)
+		if err != nil {
+			return nil, err
+		}
+		if !served {
+			log.Printf("resource %q is not served by the cluster, skipping optional datagatherer", c.GroupVersionResource)
+			return &dataGathererNoop{}, nil
+		}
+	}
+
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if err := checkAccess(ctx, clientset, c.GroupVersionResource, c.accessNamespaces()); err != nil {
+		return nil, err
+	}
+
+	if c.MetadataOnly {
+		metadataClient, err := NewMetadataClient(ctx, c.KubeConfigPath)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			return nil, err
 		}
+		return c.newMetadataDataGathererWithClient(ctx, metadataClient, discoveryClient, clientset)
+	}
+
+	if isNativeResource(c.GroupVersionResource) {
 		return c.newDataGathererWithClient(ctx, nil, clientset)
 	}
 
@@ -149,20 +304,11 @@ func (c *ConfigDynamic) newDataGathererWithClient(ctx context.Context, cl dynami
 	if err := c.validate(); err != nil {
 		return nil, err
 	}
-	// init shared informer for selected namespaces
-	fieldSelector := generateFieldSelector(c.ExcludeNamespaces)
-	// init cache to store gathered resources
-	dgCache := cache.New(5*time.Minute, 30*time.Second)
-
-	newDataGatherer := &DataGathererDynamic{
-		ctx:                  ctx,
-		cl:                   cl,
-		k8sClientSet:         clientset,
-		groupVersionResource: c.GroupVersionResource,
-		fieldSelector:        fieldSelector,
-		namespaces:           c.IncludeNamespaces,
-		cache:                dgCache,
+	newDataGatherer, err := c.newDataGathererDynamic(ctx, cl, clientset)
+	if err != nil {
+		return nil, err
 	}
+	fieldSelector := newDataGatherer.fieldSelector
 
 	// In order to reduce memory usage that might come from using Dynamic Informers
 	// * https://github.com/kyverno/kyverno/issues/1832#issuecomment-968782166
@@ -181,18 +327,10 @@ func (c *ConfigDynamic) newDataGathererWithClient(ctx context.Context, cl dynami
 			}))
 		newDataGatherer.nativeSharedInformer = factory
 		informer := informerFunc(factory)
-		informer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
-			AddFunc: func(obj interface{}) {
-				onAdd(obj, dgCache)
-			},
-			UpdateFunc: func(old, new interface{}) {
-				onUpdate(old, new, dgCache)
-			},
-			DeleteFunc: func(obj interface{}) {
-				onDelete(obj, dgCache)
-			},
-		})
-		newDataGatherer.informer = informer
+		if err := newDataGatherer.setTransform(informer); err != nil {
+			return nil, err
+		}
+		newDataGatherer.addEventHandlers(informer)
 		return newDataGatherer, nil
 	}
 
@@ -204,21 +342,175 @@ func (c *ConfigDynamic) newDataGathererWithClient(ctx context.Context, cl dynami
 	)
 	resourceInformer := factory.ForResource(c.GroupVersionResource)
 	informer := resourceInformer.Informer()
+	if err := newDataGatherer.setTransform(informer); err != nil {
+		return nil, err
+	}
 	newDataGatherer.dynamicSharedInformer = factory
+	newDataGatherer.addEventHandlers(informer)
+
+	return newDataGatherer, nil
+}
+
+// newMetadataDataGathererWithClient creates a data gatherer watching only
+// the metadata of the resources, with a metadata informer. The kind of the
+// resource is resolved with discovery, as the metadata API doesn't return
+// it.
+func (c *ConfigDynamic) newMetadataDataGathererWithClient(ctx context.Context, metadataClient metadata.Interface, discoveryClient discovery.DiscoveryInterface, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	kind, err := resourceKind(discoveryClient, c.GroupVersionResource)
+	if err != nil {
+		return nil, err
+	}
+	newDataGatherer, err := c.newDataGathererDynamic(ctx, nil, clientset)
+	if err != nil {
+		return nil, err
+	}
+	fieldSelector := newDataGatherer.fieldSelector
+
+	factory := metadatainformer.NewFilteredSharedInformerFactory(
+		metadataClient,
+		60*time.Second,
+		metav1.NamespaceAll,
+		func(options *metav1.ListOptions) { options.FieldSelector = fieldSelector },
+	)
+	informer := factory.ForResource(c.GroupVersionResource).Informer()
+	transform := metadataTransform(c.GroupVersionResource.GroupVersion().WithKind(kind), newDataGatherer.sanitize)
+	if err := informer.SetTransform(transform); err != nil {
+		return nil, fmt.Errorf("failed to set transform on informer: %s", err)
+	}
+	newDataGatherer.metadataSharedInformer = factory
+	newDataGatherer.addEventHandlers(informer)
+
+	return newDataGatherer, nil
+}
+
+// newDataGathererDynamic returns a data gatherer without an informer.
+func (c *ConfigDynamic) newDataGathererDynamic(ctx context.Context, cl dynamic.Interface, clientset kubernetes.Interface) (*DataGathererDynamic, error) {
+	// init shared informer for selected namespaces
+	fieldSelector := generateFieldSelector(c.ExcludeNamespaces)
+	namespaceFilter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	// init cache to store gathered resources
+	dgCache := cache.New(5*time.Minute, 30*time.Second)
+
+	return &DataGathererDynamic{
+		ctx:                  ctx,
+		cl:                   cl,
+		k8sClientSet:         clientset,
+		groupVersionResource: c.GroupVersionResource,
+		fieldSelector:        fieldSelector,
+		namespaces:           c.IncludeNamespaces,
+		namespaceFilter:      namespaceFilter,
+		namespaceSelector:    c.IncludeNamespaceLabelSelector,
+		fieldFilters:         c.FieldFilters,
+		sanitize:             !c.DisableSanitization,
+		cache:                dgCache,
+	}, nil
+}
+
+// addEventHandlers keeps the cache of the data gatherer up to date with the
+// events of the informer.
+func (g *DataGathererDynamic) addEventHandlers(informer k8scache.SharedIndexInformer) {
 	informer.AddEventHandler(k8scache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
-			onAdd(obj, dgCache)
+			onAdd(obj, g.cache)
 		},
 		UpdateFunc: func(old, new interface{}) {
-			onUpdate(old, new, dgCache)
+			onUpdate(old, new, g.cache)
 		},
 		DeleteFunc: func(obj interface{}) {
-			onDelete(obj, dgCache)
+			onDelete(obj, g.cache)
 		},
 	})
-	newDataGatherer.informer = informer
+	g.informer = informer
+}
 
-	return newDataGatherer, nil
+// newMultiDataGatherer creates a data gatherer for each of the configured
+// resource types, sharing all other settings, and combines them using
+// newDataGatherer.
+func (c *ConfigDynamic) newMultiDataGatherer(ctx context.Context, newDataGatherer func(*ConfigDynamic) (datagatherer.DataGatherer, error)) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	multi := &dataGathererMulti{cluster: clusterOf(ctx, c.KubeConfigPath)}
+	for _, gvr := range c.GroupVersionResources() {
+		single := *c
+		single.GroupVersionResource = gvr
+		single.AdditionalGroupVersionResources = nil
+
+		dg, err := newDataGatherer(&single)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create data gatherer for %q: %w", gvr, err)
+		}
+		multi.dataGatherers = append(multi.dataGatherers, dg)
+		multi.resources = append(multi.resources, gvr)
+	}
+
+	return multi, nil
+}
+
+// dataGathererMulti combines the items of several dynamic data gatherers
+// into a single result set.
+type dataGathererMulti struct {
+	dataGatherers []datagatherer.DataGatherer
+	// resources are the resource types of the data gatherers.
+	resources []schema.GroupVersionResource
+	// cluster is the cluster the data gatherers gather data from.
+	cluster Cluster
+}
+
+func (g *dataGathererMulti) Run(stopCh <-chan struct{}) error {
+	for _, dg := range g.dataGatherers {
+		if err := dg.Run(stopCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *dataGathererMulti) WaitForCacheSync(stopCh <-chan struct{}) error {
+	for _, dg := range g.dataGatherers {
+		if err := dg.WaitForCacheSync(stopCh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *dataGathererMulti) Delete() error {
+	for _, dg := range g.dataGatherers {
+		if err := dg.Delete(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fetch returns the items of all the data gatherers in one list.
+func (g *dataGathererMulti) Fetch() (interface{}, int, error) {
+	var items = []*api.GatheredResource{}
+	for i, dg := range g.dataGatherers {
+		data, _, err := dg.Fetch()
+		if err != nil {
+			return nil, -1, err
+		}
+		result, ok := data.(map[string]interface{})
+		var list []*api.GatheredResource
+		if ok {
+			list, ok = result["items"].([]*api.GatheredResource)
+		}
+		if !ok {
+			return nil, -1, fmt.Errorf("unexpected data of type %T returned for %q from %s", data, g.resources[i], g.cluster)
+		}
+		items = append(items, list...)
+	}
+
+	return map[string]interface{}{"items": items}, len(items), nil
 }
 
 // DataGathererDynamic is a generic gatherer for Kubernetes. It knows how to request
@@ -240,17 +532,29 @@ type DataGathererDynamic struct {
 	// This field *must* be omitted when the groupVersionResource refers to a
 	// non-namespaced resource.
 	namespaces []string
+	// namespaceFilter selects the namespaces of the returned resources, from
+	// the included and excluded namespaces and patterns.
+	namespaceFilter *namespaceFilter
+	// namespaceSelector, if specified, is a label selector limiting the
+	// namespaces of the returned resources. It is resolved on each Fetch.
+	namespaceSelector string
 	// fieldSelector is a field selector string used to filter resources
 	// returned by the Kubernetes API.
 	// https://kubernetes.io/docs/concepts/overview/working-with-objects/field-selectors/
 	fieldSelector string
+	// fieldFilters are applied to the resources after the built-in redaction.
+	fieldFilters FieldFilters
+	// sanitize removes managedFields and the last-applied-configuration
+	// annotation from all resources.
+	sanitize bool
 	// cache holds all resources watched by the data gatherer, default object expiry time 5 minutes
 	// 30 seconds purge time https://pkg.go.dev/github.com/patrickmn/go-cache
 	cache *cache.Cache
 	// informer watches the events around the targeted resource and updates the cache
-	informer              k8scache.SharedIndexInformer
-	dynamicSharedInformer dynamicinformer.DynamicSharedInformerFactory
-	nativeSharedInformer  informers.SharedInformerFactory
+	informer               k8scache.SharedIndexInformer
+	dynamicSharedInformer  dynamicinformer.DynamicSharedInformerFactory
+	nativeSharedInformer   informers.SharedInformerFactory
+	metadataSharedInformer metadatainformer.SharedInformerFactory
 
 	// isInitialized is set to true when data is first collected, prior to
 	// this the fetch method will return an error
@@ -260,7 +564,7 @@ type DataGathererDynamic struct {
 // Run starts the dynamic data gatherer's informers for resource collection.
 // Returns error if the data gatherer informer wasn't initialized
 func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
-	if g.dynamicSharedInformer == nil && g.nativeSharedInformer == nil {
+	if g.dynamicSharedInformer == nil && g.nativeSharedInformer == nil && g.metadataSharedInformer == nil {
 		return fmt.Errorf("informer was not initialized, impossible to start")
 	}
 
@@ -268,6 +572,8 @@ func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
 	err := g.informer.SetWatchErrorHandler(func(r *k8scache.Reflector, err error) {
 		if strings.Contains(fmt.Sprintf("%s", err), "the server could not find the requested resource") {
 			log.Printf("server missing resource for datagatherer of %q ", g.groupVersionResource)
+		} else if k8serrors.IsForbidden(err) {
+			log.Printf("datagatherer informer for %q is not allowed to list or watch the resource, check the RBAC permissions of the agent: %s", g.groupVersionResource, err)
 		} else {
 			log.Printf("datagatherer informer for %q has failed and is backing off due to error: %s", g.groupVersionResource, err)
 		}
@@ -285,6 +591,10 @@ func (g *DataGathererDynamic) Run(stopCh <-chan struct{}) error {
 		g.nativeSharedInformer.Start(stopCh)
 	}
 
+	if g.metadataSharedInformer != nil {
+		g.metadataSharedInformer.Start(stopCh)
+	}
+
 	return nil
 }
 
@@ -315,10 +625,14 @@ func (g *DataGathererDynamic) Fetch() (interface{}, int, error) {
 	var list = map[string]interface{}{}
 	var items = []*api.GatheredResource{}
 
-	fetchNamespaces := g.namespaces
-	if len(fetchNamespaces) == 0 {
-		// then they must have been looking for all namespaces
-		fetchNamespaces = []string{metav1.NamespaceAll}
+	// resolve the namespaces matching the label selector
+	var selectedNamespaces map[string]bool
+	if g.namespaceSelector != "" {
+		var err error
+		selectedNamespaces, err = listSelectedNamespaces(g.ctx, g.cl, g.k8sClientSet, g.namespaceSelector)
+		if err != nil {
+			return nil, -1, err
+		}
 	}
 
 	//delete expired items from the cache
@@ -328,7 +642,10 @@ func (g *DataGathererDynamic) Fetch() (interface{}, int, error) {
 		cacheObject := item.Object.(*api.GatheredResource)
 		if resource, ok := cacheObject.Resource.(cacheResource); ok {
 			namespace := resource.GetNamespace()
-			if isIncludedNamespace(namespace, fetchNamespaces) {
+			if selectedNamespaces != nil && !selectedNamespaces[namespace] {
+				continue
+			}
+			if g.namespaceFilter.isIncluded(namespace) {
 				items = append(items, cacheObject)
 			}
 			continue
@@ -337,18 +654,71 @@ func (g *DataGathererDynamic) Fetch() (interface{}, int, error) {
 	}
 
 	// Redact Secret data
-	err := redactList(items)
+	err := redactList(items, g.sanitize)
 	if err != nil {
 		return nil, -1, errors.WithStack(err)
 	}
 
+	// Apply the configured field filters. The cached resources are left
+	// untouched, the filtered copies are only used for this reading.
+	if !g.fieldFilters.empty() {
+		for i, item := range items {
+			filtered, err := g.fieldFilters.apply(item.Resource)
+			if err != nil {
+				return nil, -1, errors.WithStack(err)
+			}
+			items[i] = &api.GatheredResource{
+				Resource:  filtered,
+				DeletedAt: item.DeletedAt,
+			}
+		}
+	}
+
 	// add gathered resources to items
 	list["items"] = items
 
 	return list, len(items), nil
 }
 
-func redactList(list []*api.GatheredResource) error {
+// setTransform sanitizes resources as they are received by the informer, so
+// that the removed fields are not held in memory either.
+func (g *DataGathererDynamic) setTransform(informer k8scache.SharedIndexInformer) error {
+	if !g.sanitize {
+		return nil
+	}
+	if err := informer.SetTransform(sanitize); err != nil {
+		return fmt.Errorf("failed to set transform on informer: %s", err)
+	}
+	return nil
+}
+
+// metadataTransform converts the metadata received by a metadata informer
+// to unstructured objects of the given kind, so that they are handled like
+// the resources of the other informers. The metadata API returns them as
+// PartialObjectMetadata.
+func metadataTransform(gvk schema.GroupVersionKind, sanitizeObjects bool) k8scache.TransformFunc {
+	return func(obj interface{}) (interface{}, error) {
+		partial, ok := obj.(*metav1.PartialObjectMetadata)
+		if !ok {
+			return obj, nil
+		}
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(partial)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert the metadata of %q: %s", partial.GetName(), err)
+		}
+		resource := &unstructured.Unstructured{Object: content}
+		resource.SetGroupVersionKind(gvk)
+		if sanitizeObjects {
+			return sanitize(resource)
+		}
+		return resource, nil
+	}
+}
+
+// redactList redacts the data of Secrets and Routes, and removes
+// managedFields and the last-applied-configuration annotation from all
+// resources if sanitize is set.
+func redactList(list []*api.GatheredResource, sanitize bool) error {
 	for i := range list {
 		if item, ok := list[i].Resource.(*unstructured.Unstructured); ok {
 			// Determine the kind of items in case this is a generic 'mixed' list.
@@ -364,15 +734,21 @@ func redactList(list []*api.GatheredResource) error {
 				// secret object
 				if gvk.Kind == "Secret" && (gvk.Group == "core" || gvk.Group == "") {
 					Select(SecretSelectedFields, resource)
+					// the last applied configuration can contain the secret data
+					Redact(RedactFields, resource)
 
 					// route object
 				} else if gvk.Kind == "Route" && gvk.Group == "route.openshift.io" {
 					Select(RouteSelectedFields, resource)
+					// the last applied configuration can contain the TLS key
+					Redact(RedactFields, resource)
 				}
 			}
 
 			// remove managedFields from all resources
-			Redact(RedactFields, resource)
+			if sanitize {
+				Redact(RedactFields, resource)
+			}
 			continue
 		}
 
@@ -382,8 +758,10 @@ func redactList(list []*api.GatheredResource) error {
 		// all objects fetched from sharedIndexInformers is now redacted
 		// removing the managedFields and `kubectl.kubernetes.io/last-applied-configuration` annotation
 		if item, ok := list[i].Resource.(objectMeta); ok {
-			item.GetObjectMeta().SetManagedFields(nil)
-			delete(item.GetObjectMeta().GetAnnotations(), "kubectl.kubernetes.io/last-applied-configuration")
+			if sanitize {
+				item.GetObjectMeta().SetManagedFields(nil)
+				delete(item.GetObjectMeta().GetAnnotations(), lastAppliedConfigurationAnnotation)
+			}
 
 			resource := item.(runtime.Object)
 			gvks, _, err := scheme.Scheme.ObjectKinds(resource)
@@ -421,11 +799,23 @@ func namespaceResourceInterface(iface dynamic.NamespaceableResourceInterface, na
 }
 
 // generateFieldSelector creates a field selector string from a list of
-// namespaces to exclude.
+// namespaces to exclude. Patterns can't be expressed as field selectors, so
+// only plain namespace names are excluded by the API server, unless a negated
+// pattern matches them. The rest are filtered when fetching.
 func generateFieldSelector(excludeNamespaces []string) string {
+	var negated []namespacePattern
+	for _, excludeNamespace := range excludeNamespaces {
+		if p, err := parseNamespacePattern(excludeNamespace); err == nil && p.negate {
+			negated = append(negated, p)
+		}
+	}
+
 	fieldSelector := fields.Nothing()
 	for _, excludeNamespace := range excludeNamespaces {
-		if excludeNamespace == "" {
+		if excludeNamespace == "" || IsNamespacePattern(excludeNamespace) {
+			continue
+		}
+		if matchesAny(negated, excludeNamespace) {
 			continue
 		}
 		fieldSelector = fields.AndSelectors(fields.OneTermNotEqualSelector("metadata.namespace", excludeNamespace), fieldSelector)
@@ -433,16 +823,56 @@ func generateFieldSelector(excludeNamespaces []string) string {
 	return fieldSelector.String()
 }
 
-func isIncludedNamespace(namespace string, namespaces []string) bool {
-	if namespaces[0] == metav1.NamespaceAll {
-		return true
+// isServedResource uses discovery to check whether the API server serves the
+// given resource.
+func isServedResource(cl discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (bool, error) {
+	resources, err := cl.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if k8serrors.IsNotFound(err) || errors.Is(err, memory.ErrCacheNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover resources for %q: %w", gvr.GroupVersion(), err)
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// resourceKind uses discovery to resolve the kind of the given resource.
+func resourceKind(cl discovery.DiscoveryInterface, gvr schema.GroupVersionResource) (string, error) {
+	resources, err := cl.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return "", fmt.Errorf("failed to discover resources for %q: %w", gvr.GroupVersion(), err)
 	}
-	for _, current := range namespaces {
-		if namespace == current {
-			return true
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			return resource.Kind, nil
 		}
 	}
-	return false
+	return "", fmt.Errorf("resource %q is not served by the cluster", gvr)
+}
+
+// dataGathererNoop is used in place of an optional data gatherer whose
+// resource is not served. It always returns an empty list of items.
+type dataGathererNoop struct{}
+
+func (g *dataGathererNoop) Run(stopCh <-chan struct{}) error {
+	return nil
+}
+
+func (g *dataGathererNoop) WaitForCacheSync(stopCh <-chan struct{}) error {
+	return nil
+}
+
+func (g *dataGathererNoop) Delete() error {
+	return nil
+}
+
+func (g *dataGathererNoop) Fetch() (interface{}, int, error) {
+	return map[string]interface{}{"items": []*api.GatheredResource{}}, 0, nil
 }
 
 func isNativeResource(gvr schema.GroupVersionResource) bool {
@@ -13,6 +13,7 @@ import (
 
 	"github.com/d4l3k/messagediff"
 	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,9 +23,13 @@ import (
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 
+	"k8s.io/client-go/discovery/cached/memory"
+	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/dynamic/fake"
 	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
 	k8scache "k8s.io/client-go/tools/cache"
 )
 
@@ -216,6 +221,11 @@ exclude-namespaces:
 # from the config file
 include-namespaces:
 - default
+include-namespace-label-selector: "jetstack-secure/monitored=true"
+field-filters:
+  exclude:
+  - metadata.annotations
+metadata-only: true
 `
 
 	expectedGVR := schema.GroupVersionResource{
@@ -251,6 +261,361 @@ include-namespaces:
 	if got, want := cfg.IncludeNamespaces, expectedIncludeNamespaces; !reflect.DeepEqual(got, want) {
 		t.Errorf("IncludeNamespaces does not match: got=%+v want=%+v", got, want)
 	}
+	if got, want := cfg.IncludeNamespaceLabelSelector, "jetstack-secure/monitored=true"; got != want {
+		t.Errorf("IncludeNamespaceLabelSelector does not match: got=%q; want=%q", got, want)
+	}
+	if got, want := cfg.FieldFilters.Exclude, []string{"metadata.annotations"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldFilters.Exclude does not match: got=%+v want=%+v", got, want)
+	}
+	if !cfg.MetadataOnly {
+		t.Errorf("MetadataOnly does not match: got=false want=true")
+	}
+}
+
+func TestUnmarshalDynamicConfigResourceTypeList(t *testing.T) {
+	textCfg := `
+resource-type:
+- group: apps
+  version: v1
+  resource: deployments
+- group: apps
+  version: v1
+  resource: statefulsets
+exclude-namespaces:
+- kube-system
+`
+
+	cfg := ConfigDynamic{}
+	err := yaml.Unmarshal([]byte(textCfg), &cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []schema.GroupVersionResource{
+		{Group: "apps", Version: "v1", Resource: "deployments"},
+		{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	}
+	if got := cfg.GroupVersionResources(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("GroupVersionResources does not match: got=%+v want=%+v", got, expected)
+	}
+	if got, want := cfg.GroupVersionResource, expected[0]; got != want {
+		t.Errorf("GroupVersionResource does not match: got=%+v want=%+v", got, want)
+	}
+}
+
+// unexpectedDataGatherer returns data that isn't a list of resources.
+type unexpectedDataGatherer struct {
+	fakeDynamicDataGatherer
+}
+
+func (g *unexpectedDataGatherer) Fetch() (interface{}, int, error) {
+	return []string{"unexpected"}, 1, nil
+}
+
+func TestDynamicGathererMultipleResources_FetchUnexpectedData(t *testing.T) {
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	barGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+	config := ConfigDynamic{
+		GroupVersionResource:            fooGVR,
+		AdditionalGroupVersionResources: []schema.GroupVersionResource{barGVR},
+	}
+	ctx := WithCluster(context.Background(), Cluster{KubeconfigPath: "/etc/kubeconfig", Context: "workload"})
+	dg, err := config.newMultiDataGatherer(ctx, func(single *ConfigDynamic) (datagatherer.DataGatherer, error) {
+		if single.GroupVersionResource == barGVR {
+			return &unexpectedDataGatherer{}, nil
+		}
+		return &fakeDynamicDataGatherer{}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	// the data gatherer returning unexpected data fails the fetch rather
+	// than panicking
+	_, _, err = dg.Fetch()
+	expected := `unexpected data of type []string returned for "foobar/v1, Resource=bars" from context "workload" of kubeconfig "/etc/kubeconfig"`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: got=%v want=%s", err, expected)
+	}
+}
+
+func TestDynamicGathererMultipleResources_Fetch(t *testing.T) {
+	ctx := context.Background()
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	barGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+	config := ConfigDynamic{
+		GroupVersionResource:            fooGVR,
+		AdditionalGroupVersionResources: []schema.GroupVersionResource{barGVR},
+		IncludeNamespaces:               []string{"testns"},
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		fooGVR: "UnstructuredList",
+		barGVR: "UnstructuredList",
+	},
+		getObject("foobar/v1", "Foo", "testfoo", "testns", false),
+		getObject("foobar/v1", "Bar", "testbar", "testns", false),
+		getObject("foobar/v1", "Bar", "otherbar", "otherns", false),
+	)
+
+	dg, err := config.newMultiDataGatherer(ctx, func(single *ConfigDynamic) (datagatherer.DataGatherer, error) {
+		return single.newDataGathererWithClient(ctx, cl, nil)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	list := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+	sortGatheredResources(list)
+
+	expected := []*api.GatheredResource{
+		{Resource: getObject("foobar/v1", "Bar", "testbar", "testns", false)},
+		{Resource: getObject("foobar/v1", "Foo", "testfoo", "testns", false)},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, list); !equal {
+		t.Errorf("\n%s", diff)
+	}
+	if count != len(expected) {
+		t.Errorf("wrong count of resources reported: got %d, want %d", count, len(expected))
+	}
+}
+
+func TestDynamicGathererFieldFilters_Fetch(t *testing.T) {
+	ctx := context.Background()
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	config := ConfigDynamic{
+		GroupVersionResource: fooGVR,
+		FieldFilters:         FieldFilters{Exclude: []string{"metadata.uid"}},
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		fooGVR: "UnstructuredList",
+	}, getObject("foobar/v1", "Foo", "testfoo", "testns", false))
+
+	dg, err := config.newDataGathererWithClient(ctx, cl, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, _, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	list := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+
+	expected := getObject("foobar/v1", "Foo", "testfoo", "testns", false)
+	unstructured.RemoveNestedField(expected.Object, "metadata", "uid")
+	if diff, equal := messagediff.PrettyDiff([]*api.GatheredResource{{Resource: expected}}, list); !equal {
+		t.Errorf("\n%s", diff)
+	}
+
+	// the cached resource keeps the filtered field
+	for _, item := range dg.(*DataGathererDynamic).cache.Items() {
+		if item.Object.(*api.GatheredResource).Resource.(*unstructured.Unstructured).GetUID() == "" {
+			t.Errorf("expected the cached resource not to be filtered")
+		}
+	}
+}
+
+func TestDynamicGathererNamespaceLabelSelector_Fetch(t *testing.T) {
+	ctx := context.Background()
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	namespaceGVR := schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+	config := ConfigDynamic{
+		GroupVersionResource:          fooGVR,
+		IncludeNamespaceLabelSelector: "jetstack-secure/monitored=true",
+	}
+
+	monitored := getObject("v1", "Namespace", "monitored", "", false)
+	monitored.SetLabels(map[string]string{"jetstack-secure/monitored": "true"})
+	other := getObject("v1", "Namespace", "other", "", false)
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		fooGVR:       "UnstructuredList",
+		namespaceGVR: "NamespaceList",
+	},
+		monitored,
+		other,
+		getObject("foobar/v1", "Foo", "monitoredfoo", "monitored", false),
+		getObject("foobar/v1", "Foo", "otherfoo", "other", false),
+	)
+
+	dg, err := config.newDataGathererWithClient(ctx, cl, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	fetchNamespaces := func() []string {
+		res, _, err := dg.Fetch()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		var namespaces []string
+		for _, item := range res.(map[string]interface{})["items"].([]*api.GatheredResource) {
+			namespaces = append(namespaces, item.Resource.(*unstructured.Unstructured).GetNamespace())
+		}
+		sort.Strings(namespaces)
+		return namespaces
+	}
+
+	if diff, equal := messagediff.PrettyDiff([]string{"monitored"}, fetchNamespaces()); !equal {
+		t.Errorf("\n%s", diff)
+	}
+
+	// the namespaces are resolved again on each fetch
+	other.SetLabels(map[string]string{"jetstack-secure/monitored": "true"})
+	if _, err := cl.Resource(namespaceGVR).Update(ctx, other, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"monitored", "other"}, fetchNamespaces()); !equal {
+		t.Errorf("\n%s", diff)
+	}
+}
+
+func TestDynamicGathererDisableSanitization_Fetch(t *testing.T) {
+	ctx := context.Background()
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	secretGVR := schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	secretData := map[string]interface{}{"tls.key": "secret"}
+
+	tests := map[string]struct {
+		config   ConfigDynamic
+		object   *unstructured.Unstructured
+		expected *unstructured.Unstructured
+	}{
+		"managedFields are removed by default": {
+			config:   ConfigDynamic{GroupVersionResource: fooGVR},
+			object:   getObject("foobar/v1", "Foo", "testfoo", "testns", true),
+			expected: getObject("foobar/v1", "Foo", "testfoo", "testns", false),
+		},
+		"managedFields are kept if sanitization is disabled": {
+			config:   ConfigDynamic{GroupVersionResource: fooGVR, DisableSanitization: true},
+			object:   getObject("foobar/v1", "Foo", "testfoo", "testns", true),
+			expected: getObject("foobar/v1", "Foo", "testfoo", "testns", true),
+		},
+		"last applied configuration is always removed from secrets": {
+			config:   ConfigDynamic{GroupVersionResource: secretGVR, DisableSanitization: true},
+			object:   getSecret("testsecret", "testns", secretData, true, true),
+			expected: getSecret("testsecret", "testns", nil, true, false),
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+				tc.config.GroupVersionResource: "UnstructuredList",
+			}, tc.object)
+
+			dg, err := tc.config.newDataGathererWithClient(ctx, cl, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if err := dg.Run(ctx.Done()); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			res, _, err := dg.Fetch()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			list := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+			if diff, equal := messagediff.PrettyDiff([]*api.GatheredResource{{Resource: tc.expected}}, list); !equal {
+				t.Errorf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDynamicGathererMetadataOnly_Fetch(t *testing.T) {
+	ctx := context.Background()
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+
+	scheme := runtime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	metadataClient := fakemetadata.NewSimpleMetadataClient(scheme, &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: "foobar/v1", Kind: "Foo"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "testfoo",
+			Namespace:       "testns",
+			UID:             "testfoo1",
+			Labels:          map[string]string{"app": "test"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "foobar/v1", Kind: "Bar", Name: "testbar", UID: "testbar1"}},
+			ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+		},
+	})
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	discoveryClient.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "foobar/v1",
+			APIResources: []metav1.APIResource{{Name: "foos", Kind: "Foo", Namespaced: true}},
+		},
+	}
+
+	config := ConfigDynamic{GroupVersionResource: fooGVR, MetadataOnly: true}
+	dg, err := config.newMetadataDataGathererWithClient(ctx, metadataClient, discoveryClient, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.Run(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if err := dg.WaitForCacheSync(ctx.Done()); err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 item, got %d", count)
+	}
+	expected := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "foobar/v1",
+		"kind":       "Foo",
+		"metadata": map[string]interface{}{
+			"name":              "testfoo",
+			"namespace":         "testns",
+			"uid":               "testfoo1",
+			"creationTimestamp": nil,
+			"labels":            map[string]interface{}{"app": "test"},
+			"ownerReferences": []interface{}{
+				map[string]interface{}{"apiVersion": "foobar/v1", "kind": "Bar", "name": "testbar", "uid": "testbar1"},
+			},
+		},
+	}}
+	list := res.(map[string]interface{})["items"].([]*api.GatheredResource)
+	if diff, equal := messagediff.PrettyDiff([]*api.GatheredResource{{Resource: expected}}, list); !equal {
+		t.Errorf("\n%s", diff)
+	}
+
+	// the kind of the resource must be served
+	config.GroupVersionResource = schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "bars"}
+	if _, err := config.newMetadataDataGathererWithClient(ctx, metadataClient, discoveryClient, nil); err == nil {
+		t.Errorf("expected an error for a resource that isn't served")
+	}
 }
 
 func TestConfigDynamicValidate(t *testing.T) {
@@ -275,6 +640,22 @@ func TestConfigDynamicValidate(t *testing.T) {
 			},
 			ExpectedError: "cannot set excluded and included namespaces",
 		},
+		{
+			Config: ConfigDynamic{
+				GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+				AdditionalGroupVersionResources: []schema.GroupVersionResource{
+					{Version: "v1", Resource: "pods"},
+				},
+			},
+			ExpectedError: `invalid configuration: resource type "/v1, Resource=pods" is listed more than once`,
+		},
+		{
+			Config: ConfigDynamic{
+				GroupVersionResource:          schema.GroupVersionResource{Version: "v1", Resource: "pods"},
+				IncludeNamespaceLabelSelector: "jetstack-secure/monitored in (",
+			},
+			ExpectedError: `invalid configuration: invalid namespace label selector "jetstack-secure/monitored in ("`,
+		},
 	}
 
 	for _, test := range tests {
@@ -285,6 +666,55 @@ func TestConfigDynamicValidate(t *testing.T) {
 	}
 }
 
+func TestIsServedResource(t *testing.T) {
+	cl := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}}
+	cl.Resources = []*metav1.APIResourceList{
+		{
+			GroupVersion: "route.openshift.io/v1",
+			APIResources: []metav1.APIResource{{Name: "routes"}},
+		},
+	}
+
+	tests := map[string]struct {
+		gvr      schema.GroupVersionResource
+		expected bool
+	}{
+		"served resource": {
+			gvr:      schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "routes"},
+			expected: true,
+		},
+		"missing resource in served group": {
+			gvr:      schema.GroupVersionResource{Group: "route.openshift.io", Version: "v1", Resource: "foos"},
+			expected: false,
+		},
+		"missing group": {
+			gvr:      schema.GroupVersionResource{Group: "config.openshift.io", Version: "v1", Resource: "clusterversions"},
+			expected: false,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			served, err := isServedResource(cl, tc.gvr)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if served != tc.expected {
+				t.Errorf("got %t, want %t", served, tc.expected)
+			}
+
+			// the shared discovery client is cached
+			served, err = isServedResource(memory.NewMemCacheClient(cl), tc.gvr)
+			if err != nil {
+				t.Fatalf("unexpected error with cached discovery: %+v", err)
+			}
+			if served != tc.expected {
+				t.Errorf("got %t with cached discovery, want %t", served, tc.expected)
+			}
+		})
+	}
+}
+
 func TestGenerateFieldSelector(t *testing.T) {
 	tests := []struct {
 		ExcludeNamespaces     []string
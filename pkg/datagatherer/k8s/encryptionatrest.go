@@ -0,0 +1,403 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// EncryptionAtRestEnabled means that Secrets are written encrypted.
+	EncryptionAtRestEnabled = "enabled"
+	// EncryptionAtRestDisabled means that Secrets are written in plain text.
+	EncryptionAtRestDisabled = "disabled"
+	// EncryptionAtRestConfigured means that the API server has an encryption
+	// configuration that could not be read.
+	EncryptionAtRestConfigured = "configured"
+	// EncryptionAtRestUnknown means that the API server is not visible, e.g.
+	// on managed clusters.
+	EncryptionAtRestUnknown = "unknown"
+
+	// EncryptionAtRestFindingDisabled is reported when Secrets are not
+	// encrypted at rest.
+	EncryptionAtRestFindingDisabled = "encryption-at-rest-disabled"
+
+	encryptionProviderConfigFlag = "--encryption-provider-config"
+	apiServerLabelSelector       = "component=kube-apiserver"
+)
+
+// ConfigEncryptionAtRest contains the configuration for the
+// k8s-encryption-at-rest data-gatherer.
+type ConfigEncryptionAtRest struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// EncryptionConfigPath is the path to the EncryptionConfiguration of
+	// the API server, if it is mounted in the agent.
+	EncryptionConfigPath string `yaml:"encryption-config-path"`
+	// EncryptionConfigMap is the ConfigMap holding the EncryptionConfiguration
+	// of the API server, if it is published in the cluster.
+	EncryptionConfigMap *EncryptionConfigMapRef `yaml:"encryption-config-map"`
+}
+
+// EncryptionConfigMapRef identifies the ConfigMap key holding an
+// EncryptionConfiguration.
+type EncryptionConfigMapRef struct {
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+	// Key is the key of the EncryptionConfiguration. Defaults to the only
+	// key of the ConfigMap.
+	Key string `yaml:"key"`
+}
+
+// UnmarshalYAML unmarshals the ConfigEncryptionAtRest.
+func (c *ConfigEncryptionAtRest) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath       string                  `yaml:"kubeconfig"`
+		EncryptionConfigPath string                  `yaml:"encryption-config-path"`
+		EncryptionConfigMap  *EncryptionConfigMapRef `yaml:"encryption-config-map"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.EncryptionConfigPath = aux.EncryptionConfigPath
+	c.EncryptionConfigMap = aux.EncryptionConfigMap
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigEncryptionAtRest) Validate() error {
+	return c.validate()
+}
+
+// validate validates the configuration.
+func (c *ConfigEncryptionAtRest) validate() error {
+	var errors []string
+	if c.EncryptionConfigMap != nil {
+		if c.EncryptionConfigPath != "" {
+			errors = append(errors, "only one of encryption-config-path and encryption-config-map can be set")
+		}
+		if c.EncryptionConfigMap.Namespace == "" || c.EncryptionConfigMap.Name == "" {
+			errors = append(errors, "encryption-config-map: namespace and name are required")
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-encryption-at-rest data-gatherer.
+func (c *ConfigEncryptionAtRest) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigEncryptionAtRest) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	return &DataGathererEncryptionAtRest{
+		ctx:                  ctx,
+		clientset:            clientset,
+		encryptionConfigPath: c.EncryptionConfigPath,
+		encryptionConfigMap:  c.EncryptionConfigMap,
+	}, nil
+}
+
+// DataGathererEncryptionAtRest detects whether Secrets are encrypted at rest
+// in etcd, from the flags of the API server pods and its
+// EncryptionConfiguration where they are readable, and reports the managed
+// platform of the cluster otherwise.
+type DataGathererEncryptionAtRest struct {
+	ctx                  context.Context
+	clientset            kubernetes.Interface
+	encryptionConfigPath string
+	encryptionConfigMap  *EncryptionConfigMapRef
+}
+
+// EncryptionAtRest is the data of the k8s-encryption-at-rest data gatherer.
+type EncryptionAtRest struct {
+	// Status is one of enabled, disabled, configured or unknown.
+	Status string `json:"status"`
+	// Reason explains how the status was determined.
+	Reason string `json:"reason"`
+	// Platform is the managed Kubernetes platform of the cluster, e.g. eks,
+	// if it could be detected from the node labels.
+	Platform   string                `json:"platform,omitempty"`
+	APIServers []APIServerEncryption `json:"apiServers"`
+	// Providers are the encryption providers of Secrets, in order, the
+	// first one being used to write them. Only set when the
+	// EncryptionConfiguration is readable.
+	Providers []string `json:"providers,omitempty"`
+	// EncryptionConfig is the file or the ConfigMap key the
+	// EncryptionConfiguration was read from.
+	EncryptionConfig string `json:"encryptionConfig,omitempty"`
+}
+
+// APIServerEncryption is the encryption flag of an API server pod.
+type APIServerEncryption struct {
+	Pod string `json:"pod"`
+	// EncryptionProviderConfig is the value of the
+	// --encryption-provider-config flag, empty if it is not set.
+	EncryptionProviderConfig string `json:"encryptionProviderConfig,omitempty"`
+}
+
+// encryptionConfiguration is the part of the
+// apiserver.config.k8s.io/v1 EncryptionConfiguration that is evaluated.
+type encryptionConfiguration struct {
+	Kind      string `json:"kind"`
+	Resources []struct {
+		Resources []string                 `json:"resources"`
+		Providers []map[string]interface{} `json:"providers"`
+	} `json:"resources"`
+}
+
+// managedPlatformLabels are node labels set by managed Kubernetes platforms.
+var managedPlatformLabels = map[string]string{
+	"eks.amazonaws.com/nodegroup":    "eks",
+	"eks.amazonaws.com/compute-type": "eks",
+	"cloud.google.com/gke-nodepool":  "gke",
+	"kubernetes.azure.com/cluster":   "aks",
+}
+
+// Run is a no-op, the configuration is read on every Fetch.
+func (g *DataGathererEncryptionAtRest) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererEncryptionAtRest) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererEncryptionAtRest) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch determines the encryption at rest of Secrets and reports a finding
+// if they are not encrypted.
+func (g *DataGathererEncryptionAtRest) Fetch() (interface{}, int, error) {
+	pods, err := g.clientset.CoreV1().Pods(metav1.NamespaceSystem).List(g.ctx, metav1.ListOptions{LabelSelector: apiServerLabelSelector})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list API server pods: %w", err)
+	}
+	result := &EncryptionAtRest{APIServers: []APIServerEncryption{}}
+	for _, pod := range pods.Items {
+		server := APIServerEncryption{Pod: pod.Name}
+		for _, container := range pod.Spec.Containers {
+			if value := flagValue(append(container.Command, container.Args...), encryptionProviderConfigFlag); value != "" {
+				server.EncryptionProviderConfig = value
+			}
+		}
+		result.APIServers = append(result.APIServers, server)
+	}
+	sort.Slice(result.APIServers, func(i, j int) bool { return result.APIServers[i].Pod < result.APIServers[j].Pod })
+
+	nodes, err := g.clientset.CoreV1().Nodes().List(g.ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for _, node := range nodes.Items {
+		for label, platform := range managedPlatformLabels {
+			if _, ok := node.Labels[label]; ok {
+				result.Platform = platform
+			}
+		}
+	}
+
+	switch {
+	case g.encryptionConfigPath != "":
+		providers, err := readEncryptionProviders(g.encryptionConfigPath)
+		if err != nil {
+			return nil, -1, err
+		}
+		result.Providers = providers
+		result.EncryptionConfig = g.encryptionConfigPath
+	case g.encryptionConfigMap != nil:
+		providers, source, err := g.configMapEncryptionProviders()
+		if err != nil {
+			return nil, -1, err
+		}
+		result.Providers = providers
+		result.EncryptionConfig = source
+	}
+
+	result.Status, result.Reason = encryptionStatus(result)
+
+	findings := []api.Finding{}
+	if result.Status == EncryptionAtRestDisabled {
+		findings = append(findings, api.Finding{
+			RuleID:      EncryptionAtRestFindingDisabled,
+			Severity:    api.SeverityHigh,
+			Resource:    api.ResourceRef{Kind: "Namespace", Name: metav1.NamespaceSystem},
+			Message:     "Secrets are not encrypted at rest: " + result.Reason,
+			Remediation: "Configure an EncryptionConfiguration with a kms, aescbc, aesgcm or secretbox provider for secrets, and pass it to the API server with --encryption-provider-config.",
+		})
+	}
+
+	response := map[string]interface{}{
+		"encryptionAtRest": result,
+		"findings":         findings,
+	}
+
+	return response, len(findings), nil
+}
+
+// encryptionStatus evaluates the status, from the most to the least
+// reliable source: the EncryptionConfiguration, the API server flags, and
+// the managed platform.
+func encryptionStatus(result *EncryptionAtRest) (string, string) {
+	if result.Providers != nil {
+		if len(result.Providers) == 0 {
+			return EncryptionAtRestDisabled, "the EncryptionConfiguration has no providers for secrets"
+		}
+		if result.Providers[0] == "identity" {
+			return EncryptionAtRestDisabled, "the first provider for secrets of the EncryptionConfiguration is identity"
+		}
+		return EncryptionAtRestEnabled, fmt.Sprintf("secrets are written with the %s provider of the EncryptionConfiguration", result.Providers[0])
+	}
+
+	if len(result.APIServers) > 0 {
+		var unencrypted []string
+		for _, server := range result.APIServers {
+			if server.EncryptionProviderConfig == "" {
+				unencrypted = append(unencrypted, server.Pod)
+			}
+		}
+		if len(unencrypted) > 0 {
+			return EncryptionAtRestDisabled, fmt.Sprintf("%s is not set on %s", encryptionProviderConfigFlag, strings.Join(unencrypted, ", "))
+		}
+		return EncryptionAtRestConfigured, fmt.Sprintf("%s is set on all the API servers, set encryption-config-path or encryption-config-map to check its providers", encryptionProviderConfigFlag)
+	}
+
+	if result.Platform != "" {
+		return EncryptionAtRestUnknown, fmt.Sprintf("the control plane is managed by %s, check the secrets encryption setting of the cluster in its API", result.Platform)
+	}
+	return EncryptionAtRestUnknown, "the API server pods are not visible in kube-system"
+}
+
+// configMapEncryptionProviders returns the providers for secrets of the
+// EncryptionConfiguration in the configured ConfigMap, and the ConfigMap key
+// it was read from. A missing ConfigMap is not an error, the status is then
+// determined from the API server flags.
+func (g *DataGathererEncryptionAtRest) configMapEncryptionProviders() ([]string, string, error) {
+	ref := g.encryptionConfigMap
+	cm, err := g.clientset.CoreV1().ConfigMaps(ref.Namespace).Get(g.ctx, ref.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get configmap %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+
+	key := ref.Key
+	if key == "" {
+		if len(cm.Data) != 1 {
+			return nil, "", fmt.Errorf("configmap %s/%s has %d keys, set the key of the EncryptionConfiguration", ref.Namespace, ref.Name, len(cm.Data))
+		}
+		for k := range cm.Data {
+			key = k
+		}
+	}
+	data, ok := cm.Data[key]
+	if !ok {
+		return nil, "", fmt.Errorf("configmap %s/%s has no key %q", ref.Namespace, ref.Name, key)
+	}
+
+	source := fmt.Sprintf("configmap %s/%s key %s", ref.Namespace, ref.Name, key)
+	providers, err := parseEncryptionProviders([]byte(data), source)
+	if err != nil {
+		return nil, "", err
+	}
+	return providers, source, nil
+}
+
+// readEncryptionProviders returns the names of the providers for secrets of
+// an EncryptionConfiguration file, or an empty list if secrets are not
+// listed.
+func readEncryptionProviders(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EncryptionConfiguration: %w", err)
+	}
+	return parseEncryptionProviders(data, path)
+}
+
+// parseEncryptionProviders returns the names of the providers for secrets of
+// an EncryptionConfiguration read from source.
+func parseEncryptionProviders(data []byte, source string) ([]string, error) {
+	var config encryptionConfiguration
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse EncryptionConfiguration %s: %w", source, err)
+	}
+	if config.Kind != "EncryptionConfiguration" {
+		return nil, fmt.Errorf("%s is not an EncryptionConfiguration", source)
+	}
+
+	providers := []string{}
+	for _, resources := range config.Resources {
+		if !containsSecrets(resources.Resources) {
+			continue
+		}
+		for _, provider := range resources.Providers {
+			for name := range provider {
+				providers = append(providers, name)
+			}
+		}
+		break
+	}
+	return providers, nil
+}
+
+// containsSecrets returns true if the resources of an EncryptionConfiguration
+// include Secrets, including through wildcards.
+func containsSecrets(resources []string) bool {
+	for _, resource := range resources {
+		switch resource {
+		case "secrets", "*.", "*.*":
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value of a flag in command line arguments, given as
+// either --flag=value or --flag value.
+func flagValue(args []string, flag string) string {
+	for i, arg := range args {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return value
+		}
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
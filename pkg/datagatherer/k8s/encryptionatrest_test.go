@@ -0,0 +1,240 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const testEncryptionConfiguration = `apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- resources:
+  - configmaps
+  providers:
+  - identity: {}
+- resources:
+  - secrets
+  providers:
+  - %s
+  - identity: {}
+`
+
+func TestEncryptionAtRestGatherer_Fetch(t *testing.T) {
+	apiServer := func(name string, args ...string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "kube-system",
+				Name:      name,
+				Labels:    map[string]string{"component": "kube-apiserver"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:    "kube-apiserver",
+				Command: append([]string{"kube-apiserver"}, args...),
+			}}},
+		}
+	}
+	node := func(labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: labels}}
+	}
+	writeConfig := func(t *testing.T, provider string) string {
+		path := filepath.Join(t.TempDir(), "encryption.yaml")
+		if err := os.WriteFile(path, []byte(fmt.Sprintf(testEncryptionConfiguration, provider)), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := map[string]struct {
+		objects          []runtime.Object
+		provider         string
+		expectedStatus   string
+		expectedPlatform string
+		expectedFinding  bool
+	}{
+		"flag not set": {
+			objects:         []runtime.Object{apiServer("kube-apiserver-a", "--secure-port=6443"), node(nil)},
+			expectedStatus:  EncryptionAtRestDisabled,
+			expectedFinding: true,
+		},
+		"flag set on some api servers": {
+			objects: []runtime.Object{
+				apiServer("kube-apiserver-a", "--encryption-provider-config=/etc/kubernetes/enc.yaml"),
+				apiServer("kube-apiserver-b"),
+			},
+			expectedStatus:  EncryptionAtRestDisabled,
+			expectedFinding: true,
+		},
+		"flag set": {
+			objects:        []runtime.Object{apiServer("kube-apiserver-a", "--encryption-provider-config", "/etc/kubernetes/enc.yaml")},
+			expectedStatus: EncryptionAtRestConfigured,
+		},
+		"secrets encrypted": {
+			objects:        []runtime.Object{apiServer("kube-apiserver-a", "--encryption-provider-config=/etc/kubernetes/enc.yaml")},
+			provider:       "aescbc: {keys: [{name: key1, secret: c2VjcmV0}]}",
+			expectedStatus: EncryptionAtRestEnabled,
+		},
+		"identity first": {
+			objects:         []runtime.Object{apiServer("kube-apiserver-a", "--encryption-provider-config=/etc/kubernetes/enc.yaml")},
+			provider:        "identity: {}",
+			expectedStatus:  EncryptionAtRestDisabled,
+			expectedFinding: true,
+		},
+		"managed cluster": {
+			objects:          []runtime.Object{node(map[string]string{"cloud.google.com/gke-nodepool": "default-pool"})},
+			expectedStatus:   EncryptionAtRestUnknown,
+			expectedPlatform: "gke",
+		},
+		"no api servers": {
+			expectedStatus: EncryptionAtRestUnknown,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &ConfigEncryptionAtRest{}
+			if test.provider != "" {
+				config.EncryptionConfigPath = writeConfig(t, test.provider)
+			}
+			dg, err := config.newDataGathererWithClient(context.Background(), fakeclientset.NewSimpleClientset(test.objects...))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, count, err := dg.Fetch()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			response := data.(map[string]interface{})
+			result := response["encryptionAtRest"].(*EncryptionAtRest)
+			if result.Status != test.expectedStatus {
+				t.Errorf("expected status %q, got %q: %s", test.expectedStatus, result.Status, result.Reason)
+			}
+			if result.Platform != test.expectedPlatform {
+				t.Errorf("expected platform %q, got %q", test.expectedPlatform, result.Platform)
+			}
+
+			findings := response["findings"].([]api.Finding)
+			if test.expectedFinding != (len(findings) == 1) || count != len(findings) {
+				t.Fatalf("unexpected findings: %+v", findings)
+			}
+			if test.expectedFinding {
+				expected := api.ResourceRef{Kind: "Namespace", Name: "kube-system"}
+				if diff, equal := messagediff.PrettyDiff(expected, findings[0].Resource); !equal {
+					t.Errorf("unexpected finding resource:\n%s", diff)
+				}
+				if findings[0].RuleID != EncryptionAtRestFindingDisabled || findings[0].Severity != api.SeverityHigh {
+					t.Errorf("unexpected finding: %+v", findings[0])
+				}
+			}
+		})
+	}
+}
+
+func TestReadEncryptionProviders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "encryption.yaml")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(testEncryptionConfiguration, "kms: {apiVersion: v2, name: vault, endpoint: unix:///kms.sock}")), 0600); err != nil {
+		t.Fatal(err)
+	}
+	providers, err := readEncryptionProviders(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"kms", "identity"}, providers); !equal {
+		t.Errorf("unexpected providers:\n%s", diff)
+	}
+
+	if err := os.WriteFile(path, []byte("kind: Config\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEncryptionProviders(path); err == nil {
+		t.Errorf("expected an error for a file that is not an EncryptionConfiguration")
+	}
+}
+
+func TestEncryptionAtRestGatherer_FetchConfigMap(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "encryption-config"},
+		Data: map[string]string{
+			"config.yaml": fmt.Sprintf(testEncryptionConfiguration, "secretbox: {keys: [{name: key1, secret: c2VjcmV0}]}"),
+		},
+	}
+
+	tests := map[string]struct {
+		objects          []runtime.Object
+		ref              EncryptionConfigMapRef
+		expectedStatus   string
+		expectedSource   string
+		expectedProvider []string
+		expectedErr      string
+	}{
+		"only key": {
+			objects:          []runtime.Object{configMap},
+			ref:              EncryptionConfigMapRef{Namespace: "kube-system", Name: "encryption-config"},
+			expectedStatus:   EncryptionAtRestEnabled,
+			expectedSource:   "configmap kube-system/encryption-config key config.yaml",
+			expectedProvider: []string{"secretbox", "identity"},
+		},
+		"missing key": {
+			objects:     []runtime.Object{configMap},
+			ref:         EncryptionConfigMapRef{Namespace: "kube-system", Name: "encryption-config", Key: "other.yaml"},
+			expectedErr: `configmap kube-system/encryption-config has no key "other.yaml"`,
+		},
+		"missing configmap": {
+			ref:            EncryptionConfigMapRef{Namespace: "kube-system", Name: "encryption-config"},
+			expectedStatus: EncryptionAtRestUnknown,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &ConfigEncryptionAtRest{EncryptionConfigMap: &test.ref}
+			dg, err := config.newDataGathererWithClient(context.Background(), fakeclientset.NewSimpleClientset(test.objects...))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			data, _, err := dg.Fetch()
+			if test.expectedErr != "" {
+				if err == nil || err.Error() != test.expectedErr {
+					t.Fatalf("expected error %q, got %v", test.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			result := data.(map[string]interface{})["encryptionAtRest"].(*EncryptionAtRest)
+			if result.Status != test.expectedStatus {
+				t.Errorf("expected status %q, got %q: %s", test.expectedStatus, result.Status, result.Reason)
+			}
+			if result.EncryptionConfig != test.expectedSource {
+				t.Errorf("expected source %q, got %q", test.expectedSource, result.EncryptionConfig)
+			}
+			if diff, equal := messagediff.PrettyDiff(test.expectedProvider, result.Providers); !equal {
+				t.Errorf("unexpected providers:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConfigEncryptionAtRest_Validate(t *testing.T) {
+	config := &ConfigEncryptionAtRest{
+		EncryptionConfigPath: "/etc/kubernetes/enc.yaml",
+		EncryptionConfigMap:  &EncryptionConfigMapRef{Name: "encryption-config"},
+	}
+	expected := "only one of encryption-config-path and encryption-config-map can be set, encryption-config-map: namespace and name are required"
+	if err := config.Validate(); err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
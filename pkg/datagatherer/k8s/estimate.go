@@ -0,0 +1,249 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const (
+	// estimateSampleSize is the number of full objects fetched to estimate
+	// the average size of a resource.
+	estimateSampleSize = 20
+	// estimatePageSize is the page size used to count the objects when the
+	// API server does not report the number of remaining items.
+	estimatePageSize = 500
+)
+
+// Estimate is the predicted impact of enabling a k8s-dynamic data gatherer.
+type Estimate struct {
+	Resources []ResourceEstimate
+	// Count is the number of objects that would be gathered.
+	Count int
+	// Size is the predicted size in bytes of the gathered objects, after
+	// redaction and field filtering.
+	Size int64
+	// FetchTime is the predicted time taken to list all objects.
+	FetchTime time.Duration
+}
+
+// ResourceEstimate is the estimate for a single resource type.
+type ResourceEstimate struct {
+	GroupVersionResource schema.GroupVersionResource
+	Count                int
+	// Sampled is the number of objects fetched to estimate AverageSize.
+	Sampled     int
+	AverageSize int
+	Size        int64
+	FetchTime   time.Duration
+}
+
+// Estimate predicts the number of objects, the size of the reading and the
+// time to fetch it, without gathering all resources. Objects are counted with
+// metadata-only list requests and a small sample of full objects is used to
+// estimate their average size.
+func (c *ConfigDynamic) Estimate(ctx context.Context) (*Estimate, error) {
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	metadataClient, err := NewMetadataClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.estimateWithClient(ctx, cl, metadataClient)
+}
+
+func (c *ConfigDynamic) estimateWithClient(ctx context.Context, cl dynamic.Interface, metadataClient metadata.Interface) (*Estimate, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	// plain namespace names are listed one by one, patterns require listing
+	// all namespaces and filtering the objects
+	namespaces := c.IncludeNamespaces
+	if len(namespaces) == 0 || hasNamespacePatterns(namespaces) {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	fieldSelector := generateFieldSelector(c.ExcludeNamespaces)
+	var filter *namespaceFilter
+	if hasNamespacePatterns(c.IncludeNamespaces) || hasNamespacePatterns(c.ExcludeNamespaces) {
+		var err error
+		if filter, err = newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces); err != nil {
+			return nil, err
+		}
+	}
+	// the namespaces matching the label selector are listed one by one
+	if c.IncludeNamespaceLabelSelector != "" {
+		var err error
+		if namespaces, err = c.selectedNamespaces(ctx, metadataClient); err != nil {
+			return nil, err
+		}
+		filter = nil
+	}
+
+	estimate := &Estimate{}
+	for _, gvr := range c.GroupVersionResources() {
+		resourceEstimate := ResourceEstimate{GroupVersionResource: gvr}
+		var sampleSize int64
+		var sampleTime time.Duration
+
+		for _, namespace := range namespaces {
+			count, err := countObjects(ctx, metadataClient.Resource(gvr).Namespace(namespace), fieldSelector, filter)
+			if err != nil {
+				return nil, fmt.Errorf("failed to count %q: %w", gvr, err)
+			}
+			resourceEstimate.Count += count
+
+			if resourceEstimate.Sampled >= estimateSampleSize {
+				continue
+			}
+			start := time.Now()
+			sample, err := c.sample(ctx, cl, metadataClient, gvr, namespace, metav1.ListOptions{
+				FieldSelector: fieldSelector,
+				Limit:         int64(estimateSampleSize - resourceEstimate.Sampled),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list %q: %w", gvr, err)
+			}
+			sampleTime += time.Since(start)
+
+			for i := range sample {
+				if filter != nil && !filter.isIncluded(sample[i].GetNamespace()) {
+					continue
+				}
+				size, err := c.gatheredSize(&api.GatheredResource{Resource: &sample[i]})
+				if err != nil {
+					return nil, err
+				}
+				sampleSize += size
+				resourceEstimate.Sampled++
+			}
+		}
+
+		if resourceEstimate.Sampled > 0 {
+			resourceEstimate.AverageSize = int(sampleSize / int64(resourceEstimate.Sampled))
+			resourceEstimate.FetchTime = sampleTime / time.Duration(resourceEstimate.Sampled) * time.Duration(resourceEstimate.Count)
+		}
+		resourceEstimate.Size = int64(resourceEstimate.AverageSize) * int64(resourceEstimate.Count)
+
+		estimate.Resources = append(estimate.Resources, resourceEstimate)
+		estimate.Count += resourceEstimate.Count
+		estimate.Size += resourceEstimate.Size
+		estimate.FetchTime += resourceEstimate.FetchTime
+	}
+
+	return estimate, nil
+}
+
+// sample lists objects of a resource as they would be gathered, with only
+// their metadata if MetadataOnly is set.
+func (c *ConfigDynamic) sample(ctx context.Context, cl dynamic.Interface, metadataClient metadata.Interface, gvr schema.GroupVersionResource, namespace string, options metav1.ListOptions) ([]unstructured.Unstructured, error) {
+	if !c.MetadataOnly {
+		list, err := namespaceResourceInterface(cl.Resource(gvr), namespace).List(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		return list.Items, nil
+	}
+
+	list, err := metadataClient.Resource(gvr).Namespace(namespace).List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]unstructured.Unstructured, 0, len(list.Items))
+	for i := range list.Items {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, unstructured.Unstructured{Object: content})
+	}
+	return items, nil
+}
+
+// selectedNamespaces returns the namespaces matching the label selector and
+// the included and excluded namespaces, in alphabetical order.
+func (c *ConfigDynamic) selectedNamespaces(ctx context.Context, metadataClient metadata.Interface) ([]string, error) {
+	filter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	list, err := metadataClient.Resource(corev1.SchemeGroupVersion.WithResource("namespaces")).List(ctx, metav1.ListOptions{
+		LabelSelector: c.IncludeNamespaceLabelSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching %q: %w", c.IncludeNamespaceLabelSelector, err)
+	}
+
+	var namespaces []string
+	for _, item := range list.Items {
+		if filter.isIncluded(item.GetName()) {
+			namespaces = append(namespaces, item.GetName())
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// gatheredSize returns the size of a resource as it would be sent, after
+// redaction and field filtering.
+func (c *ConfigDynamic) gatheredSize(resource *api.GatheredResource) (int64, error) {
+	list := []*api.GatheredResource{resource}
+	if err := redactList(list, !c.DisableSanitization); err != nil {
+		return 0, err
+	}
+	if !c.FieldFilters.empty() {
+		filtered, err := c.FieldFilters.apply(resource.Resource)
+		if err != nil {
+			return 0, err
+		}
+		resource = &api.GatheredResource{Resource: filtered}
+	}
+
+	b, err := json.Marshal(resource)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal resource: %w", err)
+	}
+	return int64(len(b)), nil
+}
+
+// countObjects counts objects using metadata-only list requests. The number of
+// remaining items reported by the API server is used where available, to
+// avoid listing all objects, unless the objects have to be filtered by
+// namespace.
+func countObjects(ctx context.Context, cl metadata.ResourceInterface, fieldSelector string, filter *namespaceFilter) (int, error) {
+	count := 0
+	options := metav1.ListOptions{FieldSelector: fieldSelector, Limit: estimatePageSize}
+	for {
+		list, err := cl.List(ctx, options)
+		if err != nil {
+			return 0, err
+		}
+		for _, item := range list.Items {
+			if filter == nil || filter.isIncluded(item.GetNamespace()) {
+				count++
+			}
+		}
+		if filter == nil && list.RemainingItemCount != nil {
+			return count + int(*list.RemainingItemCount), nil
+		}
+		if list.Continue == "" {
+			return count, nil
+		}
+		options.Continue = list.Continue
+	}
+}
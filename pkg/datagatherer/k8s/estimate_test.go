@@ -0,0 +1,64 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestConfigDynamicEstimate(t *testing.T) {
+	fooGVR := schema.GroupVersionResource{Group: "foobar", Version: "v1", Resource: "foos"}
+	objects := []runtime.Object{
+		getObject("foobar/v1", "Foo", "a", "testns", true),
+		getObject("foobar/v1", "Foo", "b", "testns", true),
+		getObject("foobar/v1", "Foo", "c", "testns", true),
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		fooGVR: "UnstructuredList",
+	}, objects...)
+
+	var metadataObjects []runtime.Object
+	for _, name := range []string{"a", "b", "c"} {
+		metadataObjects = append(metadataObjects, &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "foobar/v1", Kind: "Foo"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testns"},
+		})
+	}
+	scheme := runtime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	metadataClient := fakemetadata.NewSimpleMetadataClient(scheme, metadataObjects...)
+
+	config := ConfigDynamic{GroupVersionResource: fooGVR}
+	estimate, err := config.estimateWithClient(context.Background(), cl, metadataClient)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	if estimate.Count != 3 {
+		t.Errorf("expected 3 objects, got %d", estimate.Count)
+	}
+	if len(estimate.Resources) != 1 || estimate.Resources[0].Sampled != 3 {
+		t.Fatalf("expected 3 sampled objects, got %+v", estimate.Resources)
+	}
+
+	// managedFields are redacted before the size is measured
+	redacted := getObject("foobar/v1", "Foo", "a", "testns", false)
+	b, err := json.Marshal(&api.GatheredResource{Resource: redacted})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if got, want := estimate.Resources[0].AverageSize, len(b); got != want {
+		t.Errorf("unexpected average size: got %d, want %d", got, want)
+	}
+	if got, want := estimate.Size, int64(3*len(b)); got != want {
+		t.Errorf("unexpected size: got %d, want %d", got, want)
+	}
+}
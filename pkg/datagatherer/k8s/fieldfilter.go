@@ -2,11 +2,15 @@ package k8s
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/Jeffail/gabs/v2"
 	json "github.com/json-iterator/go"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 // SecretSelectedFields is the list of fields sent from Secret objects to the
@@ -50,12 +54,34 @@ var RouteSelectedFields = []string{
 	"status",
 }
 
-// RedactFields are removed from all objects
+// lastAppliedConfigurationAnnotation is set by `kubectl apply` and contains
+// the full object, including any secret data.
+const lastAppliedConfigurationAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// RedactFields are removed from all objects, unless sanitization is disabled
 var RedactFields = []string{
 	"metadata.managedFields",
 	"/metadata/annotations/kubectl.kubernetes.io~1last-applied-configuration",
 }
 
+// sanitize removes managedFields and the last-applied-configuration
+// annotation from an object. It is used as an informer transform, so objects
+// it does not recognise, such as tombstones, are returned unchanged.
+func sanitize(obj interface{}) (interface{}, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return obj, nil
+	}
+	accessor.SetManagedFields(nil)
+	if annotations := accessor.GetAnnotations(); annotations != nil {
+		if _, ok := annotations[lastAppliedConfigurationAnnotation]; ok {
+			delete(annotations, lastAppliedConfigurationAnnotation)
+			accessor.SetAnnotations(annotations)
+		}
+	}
+	return obj, nil
+}
+
 // Select removes all but the supplied fields from the resource
 func Select(fields []string, resource *unstructured.Unstructured) error {
 	// convert the object to JSON for field filtering
@@ -117,18 +143,20 @@ func Redact(fields []string, resource *unstructured.Unstructured) error {
 
 	// craft a new object excluding redacted fields
 	for _, v := range fields {
+		pathComponents := gabs.DotPathToSlice(v)
 		// also support JSONPointers for keys containing '.' chars
 		if strings.HasPrefix(v, "/") {
-			pathComponents, err := gabs.JSONPointerToSlice(v)
+			pathComponents, err = gabs.JSONPointerToSlice(v)
 			if err != nil {
 				return fmt.Errorf("invalid JSONPointer: %s", v)
 			}
-			if jsonParsed.Exists(pathComponents...) {
-				jsonParsed.Delete(pathComponents...)
-			}
-		} else {
-			if jsonParsed.ExistsP(v) {
-				jsonParsed.DeleteP(v)
+		}
+		// delete in reverse order, so that removing an array element does not
+		// change the index of the elements still to be removed
+		paths := expandWildcards(jsonParsed.Data(), pathComponents)
+		for i := len(paths) - 1; i >= 0; i-- {
+			if jsonParsed.Exists(paths[i]...) {
+				jsonParsed.Delete(paths[i]...)
 			}
 		}
 	}
@@ -141,3 +169,132 @@ func Redact(fields []string, resource *unstructured.Unstructured) error {
 
 	return nil
 }
+
+// expandWildcards returns the paths matched by a path that may contain `*`
+// segments, which match every element of an array or every key of an object.
+func expandWildcards(data interface{}, path []string) [][]string {
+	for i, segment := range path {
+		if segment != "*" {
+			continue
+		}
+
+		var keys []string
+		switch parent := gabs.Wrap(data).Search(path[:i]...).Data().(type) {
+		case []interface{}:
+			for j := range parent {
+				keys = append(keys, strconv.Itoa(j))
+			}
+		case map[string]interface{}:
+			for k := range parent {
+				keys = append(keys, k)
+			}
+		}
+
+		var paths [][]string
+		for _, key := range keys {
+			expanded := append(append(append([]string{}, path[:i]...), key), path[i+1:]...)
+			paths = append(paths, expandWildcards(data, expanded)...)
+		}
+		return paths
+	}
+
+	return [][]string{path}
+}
+
+// FieldFilters are user configured filters applied to each gathered resource
+// before it is sent, on top of the built-in redaction. Fields are given as dot
+// separated paths, simple JSONPath expressions such as
+// `$.spec.containers[*].env`, or JSONPointers.
+type FieldFilters struct {
+	// Include, if set, removes all but the listed fields.
+	Include []string `yaml:"include"`
+	// Exclude removes the listed fields. A `*` segment matches all elements
+	// of an array or all keys of an object.
+	Exclude []string `yaml:"exclude"`
+}
+
+var jsonPathIndex = regexp.MustCompile(`\[(\*|\d+)\]`)
+
+// normaliseFieldPath converts a simple JSONPath expression to the dot
+// separated paths used by Select and Redact.
+func normaliseFieldPath(path string) string {
+	if strings.HasPrefix(path, "/") {
+		return path
+	}
+	path = strings.TrimPrefix(path, "$")
+	path = jsonPathIndex.ReplaceAllString(path, ".$1")
+	return strings.TrimPrefix(path, ".")
+}
+
+func (f FieldFilters) empty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+func (f FieldFilters) validate() error {
+	var errors []string
+	for _, path := range f.Include {
+		if path == "" {
+			errors = append(errors, "field-filters.include cannot contain an empty path")
+			continue
+		}
+		for _, segment := range gabs.DotPathToSlice(normaliseFieldPath(path)) {
+			if segment == "*" {
+				errors = append(errors, fmt.Sprintf("field-filters.include does not support wildcards: %q", path))
+			}
+		}
+	}
+	for _, path := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if strings.HasPrefix(path, "/") {
+			if _, err := gabs.JSONPointerToSlice(path); err != nil {
+				errors = append(errors, fmt.Sprintf("invalid JSONPointer: %q", path))
+			}
+		}
+	}
+	for _, path := range f.Exclude {
+		if path == "" {
+			errors = append(errors, "field-filters.exclude cannot contain an empty path")
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// apply returns a filtered copy of a gathered resource.
+func (f FieldFilters) apply(resource interface{}) (*unstructured.Unstructured, error) {
+	var filtered *unstructured.Unstructured
+	switch r := resource.(type) {
+	case *unstructured.Unstructured:
+		filtered = r.DeepCopy()
+	case runtime.Object:
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert resource: %s", err)
+		}
+		filtered = &unstructured.Unstructured{Object: object}
+	default:
+		return nil, fmt.Errorf("unsupported resource type %T", resource)
+	}
+
+	if len(f.Include) > 0 {
+		if err := Select(normaliseFieldPaths(f.Include), filtered); err != nil {
+			return nil, err
+		}
+	}
+	if err := Redact(normaliseFieldPaths(f.Exclude), filtered); err != nil {
+		return nil, err
+	}
+
+	return filtered, nil
+}
+
+func normaliseFieldPaths(paths []string) []string {
+	normalised := make([]string, 0, len(paths))
+	for _, path := range paths {
+		normalised = append(normalised, normaliseFieldPath(path))
+	}
+	return normalised
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -298,3 +300,59 @@ func TestRedactMissingField(t *testing.T) {
 		t.Fatalf("unexpected JSON: \ngot \n%s\nwant\n%s", string(bytes), expectedJSON)
 	}
 }
+
+func TestFieldFiltersApply(t *testing.T) {
+	pod := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "example",
+			Namespace:   "example",
+			Annotations: map[string]string{"token": "secret"},
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "a", Image: "a:1", Env: []corev1.EnvVar{{Name: "PASSWORD", Value: "secret"}}},
+				{Name: "b", Image: "b:1", Env: []corev1.EnvVar{{Name: "TOKEN", Value: "secret"}}},
+			},
+		},
+	}
+
+	filters := FieldFilters{
+		Include: []string{"kind", "metadata", "$.spec.containers"},
+		Exclude: []string{"$.spec.containers[*].env", "/metadata/annotations/token"},
+	}
+	if err := filters.validate(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	filtered, err := filters.apply(pod)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	bytes, err := json.Marshal(filtered)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedJSON := `{"kind":"Pod","metadata":{"annotations":{},"creationTimestamp":null,"name":"example","namespace":"example"},"spec":{"containers":[{"image":"a:1","name":"a","resources":{}},{"image":"b:1","name":"b","resources":{}}]}}`
+	if string(bytes) != expectedJSON {
+		t.Fatalf("unexpected JSON: \ngot \n%s\nwant\n%s", string(bytes), expectedJSON)
+	}
+
+	// the original object is left untouched
+	if len(pod.Spec.Containers[0].Env) != 1 {
+		t.Errorf("expected the original resource not to be modified")
+	}
+}
+
+func TestFieldFiltersValidate(t *testing.T) {
+	filters := FieldFilters{
+		Include: []string{"spec.containers.*.image"},
+		Exclude: []string{""},
+	}
+	err := filters.validate()
+	expected := `field-filters.include does not support wildcards: "spec.containers.*.image", field-filters.exclude cannot contain an empty path`
+	if err == nil || err.Error() != expected {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
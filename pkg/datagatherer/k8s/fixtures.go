@@ -0,0 +1,278 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/metadata"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ErrFixturesUnsupported is returned by Fixtures.NewDataGatherer for the data
+// gatherers that don't read from Kubernetes.
+var ErrFixturesUnsupported = errors.New("the data gatherer does not read from Kubernetes")
+
+// Fixtures are Kubernetes objects served by fake clients, so that the data
+// gatherers can be run against them without a cluster, e.g. to check a
+// configuration with `agent simulate`.
+type Fixtures struct {
+	objects []*unstructured.Unstructured
+}
+
+// LoadFixtures reads the objects of the YAML and JSON manifests in dir and its
+// subdirectories. Manifests can hold several documents and List objects.
+func LoadFixtures(dir string) (*Fixtures, error) {
+	fixtures := &Fixtures{}
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".yaml", ".yml", ".json":
+		default:
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		objects, err := readManifest(path)
+		if err != nil {
+			return fmt.Errorf("failed to read fixtures from %s: %w", path, err)
+		}
+		fixtures.objects = append(fixtures.objects, objects...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// Len returns the number of objects.
+func (f *Fixtures) Len() int {
+	return len(f.objects)
+}
+
+func readManifest(path string) ([]*unstructured.Unstructured, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var objects []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(file, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("object %q has no kind or apiVersion", obj.GetName())
+		}
+		if !obj.IsList() {
+			objects = append(objects, obj)
+			continue
+		}
+		err := obj.EachListItem(func(item runtime.Object) error {
+			objects = append(objects, item.(*unstructured.Unstructured))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// NewDataGatherer constructs the data gatherer of config with clients
+// serving the fixtures. It returns ErrFixturesUnsupported if config is not
+// the config of a Kubernetes data gatherer.
+func (f *Fixtures) NewDataGatherer(ctx context.Context, config datagatherer.Config) (datagatherer.DataGatherer, error) {
+	switch c := config.(type) {
+	case *ConfigDynamic:
+		return f.newDynamicDataGatherer(ctx, c)
+	case *ConfigCertManager:
+		return c.newDataGathererWithClient(ctx, f.discoveryClient(), f.newDynamicDataGatherer), nil
+	case *ConfigDiscovery:
+		return &DataGathererDiscovery{cl: f.discoveryClient()}, nil
+	case *ConfigIngressTLSPolicy:
+		return c.newDataGathererWithClient(ctx, f.dynamicClient(traefikTLSOptions...), f.clientset())
+	case *ConfigRBAC:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigWebhooks:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigKeyHygiene:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigCertManagerLogs:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigCertManagerEvents:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigPodSecurity:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigResourceCounts:
+		return c.newDataGathererWithClient(ctx, f.metadataClient(), f.clientset())
+	case *ConfigEncryptionAtRest:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigHelmReleases:
+		return c.newDataGathererWithClient(ctx, f.clientset())
+	case *ConfigCRDs:
+		return c.newDataGathererWithClient(ctx, f.dynamicClient(crdGVR))
+	case *ConfigAPIDeprecations:
+		return c.newDataGathererWithClient(ctx, f.metadataClient(), f.dynamicClient(apiRequestCountsGVR), f.discoveryClient())
+	case *ConfigIssuerHealth:
+		return c.newDataGathererWithClient(ctx, f.dynamicClient(clusterIssuersGVR, issuersGVR), f.clientset())
+	case *ConfigIssuance:
+		return c.newDataGathererWithClient(ctx, f.dynamicClient(certificateRequestsGVR, ordersGVR, challengesGVR))
+	case *ConfigIngressTLS:
+		return c.newDataGathererWithClient(ctx, f.clientset(), f.dynamicClient(gatewayAPIGVRs...))
+	case *ConfigIstio:
+		// the fake client serves the resources even if the fixtures don't
+		// hold any, which is reported as Istio being installed
+		for _, gvr := range istioGVRs {
+			if f.serves(gvr) {
+				return c.newDataGathererWithClient(ctx, f.dynamicClient(istioGVRs...))
+			}
+		}
+		return &dataGathererNoop{}, nil
+	}
+	return nil, ErrFixturesUnsupported
+}
+
+func (f *Fixtures) newDynamicDataGatherer(ctx context.Context, c *ConfigDynamic) (datagatherer.DataGatherer, error) {
+	cl, clientset := f.dynamicClient(c.GroupVersionResources()...), f.clientset()
+	newDataGatherer := func(single *ConfigDynamic) (datagatherer.DataGatherer, error) {
+		if single.MetadataOnly {
+			return single.newMetadataDataGathererWithClient(ctx, f.metadataClient(), f.discoveryClient(), clientset)
+		}
+		return single.newDataGathererWithClient(ctx, cl, clientset)
+	}
+	if len(c.AdditionalGroupVersionResources) > 0 {
+		return c.newMultiDataGatherer(ctx, newDataGatherer)
+	}
+	if c.Optional && !f.serves(c.GroupVersionResource) {
+		return &dataGathererNoop{}, nil
+	}
+	return newDataGatherer(c)
+}
+
+// clientset returns a fake clientset serving the objects of the built-in
+// kinds.
+func (f *Fixtures) clientset() kubernetes.Interface {
+	var objects []runtime.Object
+	for _, obj := range f.objects {
+		typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+		if err != nil {
+			continue
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+			continue
+		}
+		objects = append(objects, typed)
+	}
+	return fakeclientset.NewSimpleClientset(objects...)
+}
+
+// dynamicClient returns a fake dynamic client serving all the objects. The
+// resources the data gatherer lists must be registered, in addition to those
+// of the fixtures, as the fake client cannot list unknown resources.
+func (f *Fixtures) dynamicClient(gvrs ...schema.GroupVersionResource) dynamic.Interface {
+	listKinds := map[schema.GroupVersionResource]string{
+		{Version: "v1", Resource: "namespaces"}: "NamespaceList",
+	}
+	for _, gvr := range gvrs {
+		listKinds[gvr] = gvr.Resource + "List"
+	}
+	objects := make([]runtime.Object, 0, len(f.objects))
+	for _, obj := range f.objects {
+		gvr, _ := meta.UnsafeGuessKindToResource(obj.GroupVersionKind())
+		listKinds[gvr] = obj.GetKind() + "List"
+		objects = append(objects, obj.DeepCopy())
+	}
+	return fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+}
+
+// metadataClient returns a fake metadata client serving the metadata of all
+// the objects.
+func (f *Fixtures) metadataClient() metadata.Interface {
+	s := runtime.NewScheme()
+	metav1.AddMetaToScheme(s)
+	objects := make([]runtime.Object, 0, len(f.objects))
+	for _, obj := range f.objects {
+		partial := &metav1.PartialObjectMetadata{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, partial); err != nil {
+			continue
+		}
+		objects = append(objects, partial)
+	}
+	return fakemetadata.NewSimpleMetadataClient(s, objects...)
+}
+
+// discoveryClient returns a fake discovery client serving the resources of
+// the fixtures.
+func (f *Fixtures) discoveryClient() discovery.DiscoveryInterface {
+	resources := map[string]map[string]metav1.APIResource{}
+	for _, obj := range f.objects {
+		gvk := obj.GroupVersionKind()
+		gvr, _ := meta.UnsafeGuessKindToResource(gvk)
+		groupVersion := gvk.GroupVersion().String()
+		if resources[groupVersion] == nil {
+			resources[groupVersion] = map[string]metav1.APIResource{}
+		}
+		resources[groupVersion][gvr.Resource] = metav1.APIResource{
+			Name:       gvr.Resource,
+			Kind:       gvk.Kind,
+			Namespaced: obj.GetNamespace() != "",
+			Verbs:      metav1.Verbs{"get", "list", "watch"},
+		}
+	}
+
+	fake := &fakediscovery.FakeDiscovery{Fake: &fakeclientset.NewSimpleClientset().Fake}
+	for groupVersion, byName := range resources {
+		list := &metav1.APIResourceList{GroupVersion: groupVersion}
+		for _, resource := range byName {
+			list.APIResources = append(list.APIResources, resource)
+		}
+		sort.Slice(list.APIResources, func(i, j int) bool { return list.APIResources[i].Name < list.APIResources[j].Name })
+		fake.Resources = append(fake.Resources, list)
+	}
+	sort.Slice(fake.Resources, func(i, j int) bool {
+		return fake.Resources[i].GroupVersion < fake.Resources[j].GroupVersion
+	})
+	return fake
+}
+
+// serves returns true if the fixtures hold objects of the resource.
+func (f *Fixtures) serves(gvr schema.GroupVersionResource) bool {
+	for _, obj := range f.objects {
+		if objGVR, _ := meta.UnsafeGuessKindToResource(obj.GroupVersionKind()); objGVR == gvr {
+			return true
+		}
+	}
+	return false
+}
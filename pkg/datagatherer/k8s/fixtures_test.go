@@ -0,0 +1,71 @@
+package k8s
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFixtures(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "cert-manager"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"secrets.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  name: a
+  namespace: default
+---
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Secret
+  metadata:
+    name: b
+    namespace: default
+`,
+		"cert-manager/issuer.json": `{"apiVersion": "cert-manager.io/v1", "kind": "ClusterIssuer", "metadata": {"name": "letsencrypt"}}`,
+		"README.md":                "not a manifest",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fixtures.Len() != 3 {
+		t.Fatalf("expected 3 objects, got %d", fixtures.Len())
+	}
+
+	// the objects of built-in kinds are served by the clientset, the others
+	// by the dynamic and discovery clients
+	dg, err := fixtures.NewDataGatherer(context.Background(), &ConfigCertManager{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := dg.Run(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, count, err := dg.Fetch(); err != nil || count == 0 {
+		t.Errorf("expected the cert-manager resources of the fixtures to be gathered, got %d, %v", count, err)
+	}
+	if _, err := fixtures.NewDataGatherer(context.Background(), &ConfigKeyHygiene{}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "invalid.yaml"), []byte("metadata:\n  name: c\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFixtures(dir); err == nil {
+		t.Errorf("expected an error for an object without a kind")
+	}
+}
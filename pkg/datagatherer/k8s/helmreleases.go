@@ -0,0 +1,304 @@
+package k8s
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// helmReleaseSecretType is the type of the Secrets the Helm v3 storage
+	// driver keeps the releases in, one Secret per revision.
+	helmReleaseSecretType = "helm.sh/release.v1"
+	helmReleaseKey        = "release"
+
+	// HelmFindingOutdatedChart is reported for releases of a chart older
+	// than the minimum version configured for the chart.
+	HelmFindingOutdatedChart = "outdated-chart"
+)
+
+// gzipMagic starts the gzip encoded releases.
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// ConfigHelmReleases contains the configuration for the k8s-helm-releases
+// data-gatherer.
+type ConfigHelmReleases struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// MinChartVersions are the minimum versions of charts, by chart name,
+	// e.g. cert-manager: v1.12.0. Releases of older versions are reported.
+	MinChartVersions map[string]string `yaml:"min-chart-versions"`
+}
+
+// UnmarshalYAML unmarshals the ConfigHelmReleases.
+func (c *ConfigHelmReleases) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath   string            `yaml:"kubeconfig"`
+		MinChartVersions map[string]string `yaml:"min-chart-versions"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.MinChartVersions = aux.MinChartVersions
+
+	return nil
+}
+
+// Validate checks that the minimum chart versions are versions.
+func (c *ConfigHelmReleases) Validate() error {
+	for chart, minVersion := range c.MinChartVersions {
+		if _, err := version.ParseGeneric(minVersion); err != nil {
+			return fmt.Errorf("min-chart-versions: invalid version %q for chart %q: %s", minVersion, chart, err)
+		}
+	}
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-helm-releases data-gatherer.
+func (c *ConfigHelmReleases) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigHelmReleases) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	minChartVersions := map[string]*version.Version{}
+	for chart, minVersion := range c.MinChartVersions {
+		minChartVersions[chart] = version.MustParseGeneric(minVersion)
+	}
+	return &DataGathererHelmReleases{
+		ctx:              ctx,
+		clientset:        clientset,
+		minChartVersions: minChartVersions,
+	}, nil
+}
+
+// DataGathererHelmReleases decodes the Helm v3 release Secrets, and reports
+// the chart and status of the latest revision of each release. The values of
+// the releases never leave the agent, only their checksum is reported.
+type DataGathererHelmReleases struct {
+	ctx              context.Context
+	clientset        kubernetes.Interface
+	minChartVersions map[string]*version.Version
+}
+
+// HelmRelease is the latest revision of a Helm release.
+type HelmRelease struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Revision  int    `json:"revision"`
+	// Status is the status of the revision, e.g. deployed or failed.
+	Status       string `json:"status"`
+	Chart        string `json:"chart"`
+	ChartVersion string `json:"chartVersion"`
+	AppVersion   string `json:"appVersion,omitempty"`
+	// ValuesChecksum is the hex encoded SHA-256 digest of the JSON encoding
+	// of the values the release was installed with, with sorted keys.
+	ValuesChecksum string   `json:"valuesChecksum"`
+	LastDeployed   api.Time `json:"lastDeployed"`
+}
+
+// helmRelease is the part of a Helm release that is decoded.
+type helmRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Version   int    `json:"version"`
+	Info      struct {
+		Status       string   `json:"status"`
+		LastDeployed api.Time `json:"last_deployed"`
+	} `json:"info"`
+	Chart struct {
+		Metadata struct {
+			Name       string `json:"name"`
+			Version    string `json:"version"`
+			AppVersion string `json:"appVersion"`
+		} `json:"metadata"`
+	} `json:"chart"`
+	Config json.RawMessage `json:"config"`
+}
+
+// Run is a no-op, Secrets are listed on every Fetch.
+func (g *DataGathererHelmReleases) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererHelmReleases) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererHelmReleases) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the release Secrets and decodes the latest revision of each
+// release.
+func (g *DataGathererHelmReleases) Fetch() (interface{}, int, error) {
+	secrets, err := g.clientset.CoreV1().Secrets(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", helmReleaseSecretType).String(),
+	})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	latest := map[string]*HelmRelease{}
+	for _, secret := range secrets.Items {
+		if secret.Type != helmReleaseSecretType {
+			continue
+		}
+		release, err := decodeHelmRelease(&secret)
+		if err != nil {
+			log.Printf("failed to decode the Helm release in Secret %s/%s: %s", secret.Namespace, secret.Name, err)
+			continue
+		}
+		key := release.Namespace + "/" + release.Name
+		if current, ok := latest[key]; !ok || release.Revision > current.Revision {
+			latest[key] = release
+		}
+	}
+
+	releases := make([]*HelmRelease, 0, len(latest))
+	for _, release := range latest {
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		if releases[i].Namespace != releases[j].Namespace {
+			return releases[i].Namespace < releases[j].Namespace
+		}
+		return releases[i].Name < releases[j].Name
+	})
+
+	findings := []api.Finding{}
+	for _, release := range releases {
+		if finding, ok := g.outdated(release); ok {
+			findings = append(findings, finding)
+		}
+	}
+
+	response := map[string]interface{}{
+		"releases": releases,
+		"findings": findings,
+	}
+
+	return response, len(releases), nil
+}
+
+// outdated returns a finding if the chart of the release is older than its
+// minimum version.
+func (g *DataGathererHelmReleases) outdated(release *HelmRelease) (api.Finding, bool) {
+	minVersion, ok := g.minChartVersions[release.Chart]
+	if !ok {
+		return api.Finding{}, false
+	}
+	chartVersion, err := version.ParseGeneric(release.ChartVersion)
+	if err != nil || !chartVersion.LessThan(minVersion) {
+		return api.Finding{}, false
+	}
+	return api.Finding{
+		RuleID:      HelmFindingOutdatedChart,
+		Severity:    api.SeverityMedium,
+		Resource:    api.ResourceRef{Kind: "HelmRelease", Namespace: release.Namespace, Name: release.Name},
+		Message:     fmt.Sprintf("chart %s %s is older than the minimum version %s", release.Chart, release.ChartVersion, minVersion),
+		Remediation: fmt.Sprintf("Upgrade the release to version %s of the %s chart or later.", minVersion, release.Chart),
+	}, true
+}
+
+// decodeHelmRelease decodes the release of a release Secret, which is the
+// base64 encoding of the JSON encoding of the release, usually gzipped.
+func decodeHelmRelease(secret *corev1.Secret) (*HelmRelease, error) {
+	data, ok := secret.Data[helmReleaseKey]
+	if !ok {
+		return nil, fmt.Errorf("no %s key", helmReleaseKey)
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(decoded, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %w", err)
+	}
+	decoded = decoded[:n]
+	if bytes.HasPrefix(decoded, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(decoded))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		if decoded, err = io.ReadAll(r); err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+	}
+
+	var release helmRelease
+	if err := json.Unmarshal(decoded, &release); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+	}
+	checksum, err := valuesChecksum(release.Config)
+	if err != nil {
+		return nil, err
+	}
+	namespace := release.Namespace
+	if namespace == "" {
+		namespace = secret.Namespace
+	}
+	return &HelmRelease{
+		Namespace:      namespace,
+		Name:           release.Name,
+		Revision:       release.Version,
+		Status:         release.Info.Status,
+		Chart:          release.Chart.Metadata.Name,
+		ChartVersion:   release.Chart.Metadata.Version,
+		AppVersion:     release.Chart.Metadata.AppVersion,
+		ValuesChecksum: checksum,
+		LastDeployed:   release.Info.LastDeployed,
+	}, nil
+}
+
+// valuesChecksum returns the checksum of the values, re-encoded so that it
+// doesn't depend on the order of their keys.
+func valuesChecksum(raw json.RawMessage) (string, error) {
+	var values interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return "", fmt.Errorf("failed to decode values: %w", err)
+		}
+	}
+	// values that are not set are the same as empty values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,128 @@
+package k8s
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// helmReleaseSecret returns a release Secret encoded like the Helm v3 storage
+// driver does.
+func helmReleaseSecret(t *testing.T, namespace, name string, revision int, chartVersion, values string) *corev1.Secret {
+	release := fmt.Sprintf(`{
+		"name": %q,
+		"namespace": %q,
+		"version": %d,
+		"info": {"status": "deployed", "last_deployed": "2024-01-02T03:04:05.123456789Z"},
+		"chart": {"metadata": {"name": "cert-manager", "version": %q, "appVersion": %q}, "values": {"replicaCount": 1}},
+		"config": %s,
+		"manifest": "---"
+	}`, name, namespace, revision, chartVersion, chartVersion, values)
+
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(release)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", name, revision),
+			Labels:    map[string]string{"owner": "helm", "name": name},
+		},
+		Type: helmReleaseSecretType,
+		Data: map[string][]byte{"release": []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))},
+	}
+}
+
+func TestHelmReleasesGatherer_Fetch(t *testing.T) {
+	clientset := fakeclientset.NewSimpleClientset(
+		helmReleaseSecret(t, "cert-manager", "cert-manager", 1, "v1.11.0", `{"installCRDs": true, "replicaCount": 2}`),
+		helmReleaseSecret(t, "cert-manager", "cert-manager", 2, "v1.11.1", `{"replicaCount": 2, "installCRDs": true}`),
+		helmReleaseSecret(t, "other", "cert-manager", 1, "v1.13.3", `null`),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "opaque"},
+			Type:       corev1.SecretTypeOpaque,
+		},
+	)
+
+	config := ConfigHelmReleases{MinChartVersions: map[string]string{"cert-manager": "v1.12.0"}}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 releases, got %d", count)
+	}
+
+	// the checksum doesn't depend on the order of the keys of the values
+	values, err := valuesChecksum([]byte(`{"installCRDs":true,"replicaCount":2}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	empty, err := valuesChecksum(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	lastDeployed := data.(map[string]interface{})["releases"].([]*HelmRelease)[0].LastDeployed
+	expectedReleases := []*HelmRelease{
+		{
+			Namespace:      "cert-manager",
+			Name:           "cert-manager",
+			Revision:       2,
+			Status:         "deployed",
+			Chart:          "cert-manager",
+			ChartVersion:   "v1.11.1",
+			AppVersion:     "v1.11.1",
+			ValuesChecksum: values,
+			LastDeployed:   lastDeployed,
+		},
+		{
+			Namespace:      "other",
+			Name:           "cert-manager",
+			Revision:       1,
+			Status:         "deployed",
+			Chart:          "cert-manager",
+			ChartVersion:   "v1.13.3",
+			AppVersion:     "v1.13.3",
+			ValuesChecksum: empty,
+			LastDeployed:   lastDeployed,
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedReleases, data.(map[string]interface{})["releases"]); !equal {
+		t.Errorf("unexpected releases:\n%s", diff)
+	}
+	if lastDeployed.UTC().Format(api.TimeFormat) != "2024-01-02T03:04:05Z" {
+		t.Errorf("unexpected last deployed time: %s", lastDeployed)
+	}
+
+	findings := data.(map[string]interface{})["findings"].([]api.Finding)
+	if len(findings) != 1 || findings[0].RuleID != HelmFindingOutdatedChart || findings[0].Resource.Namespace != "cert-manager" {
+		t.Errorf("expected a single outdated-chart finding for cert-manager/cert-manager, got %+v", findings)
+	}
+}
+
+func TestConfigHelmReleasesValidate(t *testing.T) {
+	config := ConfigHelmReleases{MinChartVersions: map[string]string{"cert-manager": "latest"}}
+	if err := config.Validate(); err == nil {
+		t.Errorf("expected an error for an invalid version")
+	}
+}
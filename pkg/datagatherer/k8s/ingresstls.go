@@ -0,0 +1,458 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// IngressTLSFindingHostWithoutTLS is reported for the hosts that are
+	// served without TLS.
+	IngressTLSFindingHostWithoutTLS = "host-without-tls"
+	// IngressTLSFindingSecretMissing is reported for the hosts whose TLS
+	// Secret doesn't exist or holds no certificate.
+	IngressTLSFindingSecretMissing = "tls-secret-missing"
+	// IngressTLSFindingSANMismatch is reported for the hosts the certificate
+	// of their TLS Secret is not valid for.
+	IngressTLSFindingSANMismatch = "san-mismatch"
+	// IngressTLSFindingNotManaged is reported for the TLS Secrets whose
+	// certificate is not managed by cert-manager.
+	IngressTLSFindingNotManaged = "certificate-not-managed"
+
+	// certManagerCertificateNameAnnotation is set by cert-manager on the
+	// Secrets of the Certificates it manages.
+	certManagerCertificateNameAnnotation = "cert-manager.io/certificate-name"
+)
+
+var (
+	gatewayAPIGatewaysGVR   = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+	gatewayAPIHTTPRoutesGVR = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+)
+
+// gatewayAPIGVRs are the Gateway API resources listed by the k8s-ingress-tls
+// data gatherer.
+var gatewayAPIGVRs = []schema.GroupVersionResource{gatewayAPIGatewaysGVR, gatewayAPIHTTPRoutesGVR}
+
+// ConfigIngressTLS contains the configuration for the k8s-ingress-tls
+// data-gatherer.
+type ConfigIngressTLS struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+}
+
+// UnmarshalYAML unmarshals the ConfigIngressTLS.
+func (c *ConfigIngressTLS) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string `yaml:"kubeconfig"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-ingress-tls data-gatherer.
+func (c *ConfigIngressTLS) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset, cl)
+}
+
+func (c *ConfigIngressTLS) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface, cl dynamic.Interface) (datagatherer.DataGatherer, error) {
+	return &DataGathererIngressTLS{
+		ctx:       ctx,
+		clientset: clientset,
+		cl:        cl,
+	}, nil
+}
+
+// DataGathererIngressTLS joins the hosts of the Ingresses and of the Gateway
+// API HTTPRoutes with the Secrets of their certificates, and reports the
+// hosts served without TLS, with certificates not valid for them, or with
+// certificates not managed by cert-manager.
+type DataGathererIngressTLS struct {
+	ctx       context.Context
+	clientset kubernetes.Interface
+	cl        dynamic.Interface
+}
+
+// IngressTLSRoute is an Ingress or HTTPRoute and the TLS configuration of its
+// hosts.
+type IngressTLSRoute struct {
+	// Kind is Ingress or HTTPRoute.
+	Kind      string           `json:"kind"`
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Hosts     []IngressTLSHost `json:"hosts"`
+}
+
+// IngressTLSHost is a host of an Ingress or HTTPRoute.
+type IngressTLSHost struct {
+	Host string `json:"host"`
+	TLS  bool   `json:"tls"`
+	// Secret is the namespace/name of the Secret of the certificate, if any.
+	Secret string `json:"secret,omitempty"`
+	// ManagedByCertManager is true if the Secret is the Secret of a
+	// cert-manager Certificate.
+	ManagedByCertManager bool `json:"managedByCertManager,omitempty"`
+	// DNSNames are the DNS SANs of the certificate.
+	DNSNames []string  `json:"dnsNames,omitempty"`
+	NotAfter *api.Time `json:"notAfter,omitempty"`
+}
+
+// ingressTLSSecret is a TLS Secret and its certificate, if it exists.
+type ingressTLSSecret struct {
+	exists      bool
+	managed     bool
+	certificate *x509.Certificate
+}
+
+// gatewayAPIGateway is the part of a Gateway API Gateway that is evaluated.
+type gatewayAPIGateway struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Listeners []gatewayAPIListener `json:"listeners"`
+	} `json:"spec"`
+}
+
+type gatewayAPIListener struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	Protocol string `json:"protocol"`
+	TLS      *struct {
+		Mode            string `json:"mode"`
+		CertificateRefs []struct {
+			Kind      string `json:"kind"`
+			Namespace string `json:"namespace"`
+			Name      string `json:"name"`
+		} `json:"certificateRefs"`
+	} `json:"tls"`
+}
+
+// gatewayAPIHTTPRoute is the part of a Gateway API HTTPRoute that is
+// evaluated.
+type gatewayAPIHTTPRoute struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		ParentRefs []struct {
+			Kind        string `json:"kind"`
+			Namespace   string `json:"namespace"`
+			Name        string `json:"name"`
+			SectionName string `json:"sectionName"`
+		} `json:"parentRefs"`
+		Hostnames []string `json:"hostnames"`
+	} `json:"spec"`
+}
+
+// Run is a no-op, the routes are listed on every Fetch.
+func (g *DataGathererIngressTLS) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererIngressTLS) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererIngressTLS) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the Ingresses, and the Gateways and HTTPRoutes if the Gateway
+// API is installed, and gets the Secrets they reference.
+func (g *DataGathererIngressTLS) Fetch() (interface{}, int, error) {
+	secrets := map[string]*ingressTLSSecret{}
+	routes := []*IngressTLSRoute{}
+
+	ingresses, err := g.clientset.NetworkingV1().Ingresses(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	for _, ingress := range ingresses.Items {
+		route := &IngressTLSRoute{Kind: "Ingress", Namespace: ingress.Namespace, Name: ingress.Name, Hosts: []IngressTLSHost{}}
+		seen := map[string]bool{}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host == "" || seen[rule.Host] {
+				continue
+			}
+			seen[rule.Host] = true
+			host := IngressTLSHost{Host: rule.Host}
+			for _, tls := range ingress.Spec.TLS {
+				if slices.Contains(tls.Hosts, rule.Host) && tls.SecretName != "" {
+					host.TLS = true
+					host.Secret = ingress.Namespace + "/" + tls.SecretName
+					break
+				}
+			}
+			route.Hosts = append(route.Hosts, host)
+		}
+		routes = append(routes, route)
+	}
+
+	httpRoutes, err := g.httpRoutes()
+	if err != nil {
+		return nil, -1, err
+	}
+	routes = append(routes, httpRoutes...)
+
+	findings := []api.Finding{}
+	unmanaged := map[string]bool{}
+	for _, route := range routes {
+		ref := api.ResourceRef{Kind: route.Kind, Namespace: route.Namespace, Name: route.Name}
+		for i := range route.Hosts {
+			host := &route.Hosts[i]
+			if !host.TLS {
+				findings = append(findings, ingressTLSFinding(IngressTLSFindingHostWithoutTLS, ref, fmt.Sprintf("host %s is served without TLS", host.Host)))
+				continue
+			}
+			secret, err := g.secret(secrets, host.Secret)
+			if err != nil {
+				return nil, -1, err
+			}
+			if !secret.exists || secret.certificate == nil {
+				findings = append(findings, ingressTLSFinding(IngressTLSFindingSecretMissing, ref, fmt.Sprintf("the Secret %s of host %s doesn't exist or holds no certificate", host.Secret, host.Host)))
+				continue
+			}
+			host.ManagedByCertManager = secret.managed
+			host.DNSNames = secret.certificate.DNSNames
+			host.NotAfter = &api.Time{Time: secret.certificate.NotAfter}
+			if !certificateCovers(secret.certificate, host.Host) {
+				findings = append(findings, ingressTLSFinding(IngressTLSFindingSANMismatch, ref, fmt.Sprintf("the certificate in %s is not valid for host %s, only for %v", host.Secret, host.Host, host.DNSNames)))
+			}
+			if !secret.managed && !unmanaged[host.Secret] {
+				unmanaged[host.Secret] = true
+				namespace, name := splitNamespacedName(host.Secret)
+				findings = append(findings, ingressTLSFinding(IngressTLSFindingNotManaged,
+					api.ResourceRef{Kind: "Secret", Namespace: namespace, Name: name},
+					fmt.Sprintf("the certificate of host %s is not managed by cert-manager", host.Host)))
+			}
+		}
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Kind != routes[j].Kind {
+			return routes[i].Kind < routes[j].Kind
+		}
+		if routes[i].Namespace != routes[j].Namespace {
+			return routes[i].Namespace < routes[j].Namespace
+		}
+		return routes[i].Name < routes[j].Name
+	})
+
+	response := map[string]interface{}{
+		"routes":   routes,
+		"findings": findings,
+	}
+
+	return response, len(routes), nil
+}
+
+// httpRoutes returns the HTTPRoutes and their hosts, which are served with
+// TLS if they match an HTTPS listener of one of the Gateways of the route.
+// The routes without hostnames have the hostnames of the listeners.
+func (g *DataGathererIngressTLS) httpRoutes() ([]*IngressTLSRoute, error) {
+	gatewayList, err := g.cl.Resource(gatewayAPIGatewaysGVR).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gatewayAPIGatewaysGVR, err)
+	}
+	gateways := map[string]*gatewayAPIGateway{}
+	for _, item := range gatewayList.Items {
+		gateway := &gatewayAPIGateway{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, gateway); err != nil {
+			return nil, fmt.Errorf("failed to decode gateway %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		gateways[gateway.Namespace+"/"+gateway.Name] = gateway
+	}
+
+	routeList, err := g.cl.Resource(gatewayAPIHTTPRoutesGVR).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gatewayAPIHTTPRoutesGVR, err)
+	}
+	var routes []*IngressTLSRoute
+	for _, item := range routeList.Items {
+		var httpRoute gatewayAPIHTTPRoute
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &httpRoute); err != nil {
+			return nil, fmt.Errorf("failed to decode httproute %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		route := &IngressTLSRoute{Kind: "HTTPRoute", Namespace: httpRoute.Namespace, Name: httpRoute.Name, Hosts: []IngressTLSHost{}}
+		hosts := map[string]*IngressTLSHost{}
+		var order []string
+		for _, parent := range httpRoute.Spec.ParentRefs {
+			if parent.Kind != "" && parent.Kind != "Gateway" {
+				continue
+			}
+			namespace := parent.Namespace
+			if namespace == "" {
+				namespace = httpRoute.Namespace
+			}
+			gateway, ok := gateways[namespace+"/"+parent.Name]
+			if !ok {
+				continue
+			}
+			for _, listener := range gateway.Spec.Listeners {
+				if parent.SectionName != "" && parent.SectionName != listener.Name {
+					continue
+				}
+				hostnames := httpRoute.Spec.Hostnames
+				if len(hostnames) == 0 {
+					hostnames = []string{listener.Hostname}
+				}
+				for _, hostname := range hostnames {
+					if hostname == "" || !hostnameMatches(listener.Hostname, hostname) {
+						continue
+					}
+					host, ok := hosts[hostname]
+					if !ok {
+						host = &IngressTLSHost{Host: hostname}
+						hosts[hostname] = host
+						order = append(order, hostname)
+					}
+					if host.TLS || listener.Protocol != "HTTPS" || listener.TLS == nil {
+						continue
+					}
+					for _, ref := range listener.TLS.CertificateRefs {
+						if ref.Kind != "" && ref.Kind != "Secret" {
+							continue
+						}
+						secretNamespace := ref.Namespace
+						if secretNamespace == "" {
+							secretNamespace = gateway.Namespace
+						}
+						host.TLS = true
+						host.Secret = secretNamespace + "/" + ref.Name
+						break
+					}
+				}
+			}
+		}
+		for _, hostname := range order {
+			route.Hosts = append(route.Hosts, *hosts[hostname])
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// secret returns the TLS Secret of the namespace/name, getting it on first
+// use.
+func (g *DataGathererIngressTLS) secret(secrets map[string]*ingressTLSSecret, namespacedName string) (*ingressTLSSecret, error) {
+	if secret, ok := secrets[namespacedName]; ok {
+		return secret, nil
+	}
+	namespace, name := splitNamespacedName(namespacedName)
+	result := &ingressTLSSecret{}
+	secret, err := g.clientset.CoreV1().Secrets(namespace).Get(g.ctx, name, metav1.GetOptions{})
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to get secret %s: %w", namespacedName, err)
+	default:
+		result.exists = true
+		_, result.managed = secret.Annotations[certManagerCertificateNameAnnotation]
+		if block, _ := pem.Decode(secret.Data[corev1.TLSCertKey]); block != nil {
+			if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+				result.certificate = cert
+			}
+		}
+	}
+	secrets[namespacedName] = result
+	return result, nil
+}
+
+// hostnameMatches returns whether the hostname of a route matches the
+// hostname of a listener, which matches all hostnames if empty.
+func hostnameMatches(listener, hostname string) bool {
+	if listener == "" || listener == hostname {
+		return true
+	}
+	return strings.HasPrefix(listener, "*.") && strings.HasSuffix(hostname, listener[1:])
+}
+
+// certificateCovers returns whether one of the DNS SANs of the certificate
+// matches the host, a wildcard SAN matching a single label. Wildcard hosts
+// are only covered by the same wildcard SAN.
+func certificateCovers(cert *x509.Certificate, host string) bool {
+	host = strings.ToLower(host)
+	for _, name := range cert.DNSNames {
+		name = strings.ToLower(name)
+		if name == host {
+			return true
+		}
+		if strings.HasPrefix(name, "*.") && !strings.HasPrefix(host, "*.") {
+			if i := strings.Index(host, "."); i > 0 && host[i:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func splitNamespacedName(namespacedName string) (string, string) {
+	namespace, name, _ := strings.Cut(namespacedName, "/")
+	return namespace, name
+}
+
+// ingressTLSFindingSeverities and ingressTLSFindingRemediations hold the
+// severity and the remediation hint of each rule.
+var (
+	ingressTLSFindingSeverities = map[string]api.Severity{
+		IngressTLSFindingHostWithoutTLS: api.SeverityMedium,
+		IngressTLSFindingSecretMissing:  api.SeverityHigh,
+		IngressTLSFindingSANMismatch:    api.SeverityHigh,
+		IngressTLSFindingNotManaged:     api.SeverityLow,
+	}
+	ingressTLSFindingRemediations = map[string]string{
+		IngressTLSFindingHostWithoutTLS: "Add the host to a TLS section or HTTPS listener, e.g. with a certificate issued by cert-manager.",
+		IngressTLSFindingSecretMissing:  "Create the Secret, e.g. with a cert-manager Certificate, or fix the reference.",
+		IngressTLSFindingSANMismatch:    "Issue a new certificate including the host in its DNS names.",
+		IngressTLSFindingNotManaged:     "Manage the certificate with a cert-manager Certificate, so that it is renewed automatically.",
+	}
+)
+
+func ingressTLSFinding(ruleID string, resource api.ResourceRef, message string) api.Finding {
+	return api.Finding{
+		RuleID:      ruleID,
+		Severity:    ingressTLSFindingSeverities[ruleID],
+		Resource:    resource,
+		Message:     message,
+		Remediation: ingressTLSFindingRemediations[ruleID],
+	}
+}
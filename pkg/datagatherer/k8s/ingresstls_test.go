@@ -0,0 +1,152 @@
+package k8s
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func encodeTestCertForHosts(t *testing.T, notAfter time.Time, dnsNames ...string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     dnsNames,
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestIngressTLSGatherer_Fetch(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	managed := tlsSecret("shop", "shop-tls", encodeTestCertForHosts(t, notAfter, "shop.example.com"), nil)
+	managed.Annotations = map[string]string{certManagerCertificateNameAnnotation: "shop"}
+	clientset := fakeclientset.NewSimpleClientset(
+		managed,
+		tlsSecret("shop", "legacy-tls", encodeTestCertForHosts(t, notAfter, "*.legacy.example.com"), nil),
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "shop"},
+			Spec: networkingv1.IngressSpec{
+				TLS: []networkingv1.IngressTLS{
+					{Hosts: []string{"shop.example.com"}, SecretName: "shop-tls"},
+					{Hosts: []string{"www.legacy.example.com", "legacy.example.com"}, SecretName: "legacy-tls"},
+					{Hosts: []string{"missing.example.com"}, SecretName: "missing-tls"},
+				},
+				Rules: []networkingv1.IngressRule{
+					{Host: "shop.example.com"},
+					{Host: "www.legacy.example.com"},
+					{Host: "legacy.example.com"},
+					{Host: "missing.example.com"},
+					{Host: "plain.example.com"},
+				},
+			},
+		},
+	)
+
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		gatewayAPIGatewaysGVR:   "GatewayList",
+		gatewayAPIHTTPRoutesGVR: "HTTPRouteList",
+	})
+	gateway := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata":   map[string]interface{}{"namespace": "infra", "name": "public"},
+		"spec": map[string]interface{}{"listeners": []interface{}{
+			map[string]interface{}{
+				"name":     "https",
+				"hostname": "*.example.com",
+				"protocol": "HTTPS",
+				"tls": map[string]interface{}{"certificateRefs": []interface{}{
+					map[string]interface{}{"namespace": "shop", "name": "shop-tls"},
+				}},
+			},
+			map[string]interface{}{"name": "http", "protocol": "HTTP"},
+		}},
+	}}
+	route := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "HTTPRoute",
+		"metadata":   map[string]interface{}{"namespace": "shop", "name": "shop"},
+		"spec": map[string]interface{}{
+			"parentRefs": []interface{}{map[string]interface{}{"namespace": "infra", "name": "public"}},
+			"hostnames":  []interface{}{"shop.example.com", "shop.internal"},
+		},
+	}}
+	// the objects are added with their resource, as the fake client guesses
+	// gatewaies for Gateways
+	if err := cl.Tracker().Create(gatewayAPIGatewaysGVR, gateway, "infra"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Tracker().Create(gatewayAPIHTTPRoutesGVR, route, "shop"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := ConfigIngressTLS{}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset, cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 routes, got %d", count)
+	}
+
+	shopHost := IngressTLSHost{Host: "shop.example.com", TLS: true, Secret: "shop/shop-tls", ManagedByCertManager: true, DNSNames: []string{"shop.example.com"}, NotAfter: &api.Time{Time: notAfter}}
+	legacyHost := func(host string) IngressTLSHost {
+		return IngressTLSHost{Host: host, TLS: true, Secret: "shop/legacy-tls", DNSNames: []string{"*.legacy.example.com"}, NotAfter: &api.Time{Time: notAfter}}
+	}
+	expected := []*IngressTLSRoute{
+		// shop.internal only matches the HTTP listener
+		{Kind: "HTTPRoute", Namespace: "shop", Name: "shop", Hosts: []IngressTLSHost{shopHost, {Host: "shop.internal"}}},
+		{Kind: "Ingress", Namespace: "shop", Name: "shop", Hosts: []IngressTLSHost{
+			shopHost,
+			legacyHost("www.legacy.example.com"),
+			legacyHost("legacy.example.com"),
+			{Host: "missing.example.com", TLS: true, Secret: "shop/missing-tls"},
+			{Host: "plain.example.com"},
+		}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data.(map[string]interface{})["routes"]); !equal {
+		t.Errorf("unexpected routes:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Kind+" "+finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"Secret legacy-tls " + IngressTLSFindingNotManaged,
+		"Ingress shop " + IngressTLSFindingSANMismatch,
+		"Ingress shop " + IngressTLSFindingSecretMissing,
+		"Ingress shop " + IngressTLSFindingHostWithoutTLS,
+		"HTTPRoute shop " + IngressTLSFindingHostWithoutTLS,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
@@ -0,0 +1,344 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ConfigIngressTLSPolicy contains the configuration for the
+// k8s-ingress-tls-policy data-gatherer.
+type ConfigIngressTLSPolicy struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// ConfigMaps are the ingress controller ConfigMaps to evaluate. Defaults
+	// to the ConfigMaps deployed by the upstream ingress-nginx and HAProxy
+	// ingress charts.
+	ConfigMaps []IngressControllerConfigMap `yaml:"config-maps"`
+}
+
+// IngressControllerConfigMap identifies the ConfigMap of an ingress controller.
+type IngressControllerConfigMap struct {
+	// Controller is either nginx or haproxy.
+	Controller string `yaml:"controller"`
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+}
+
+// UnmarshalYAML unmarshals the ConfigIngressTLSPolicy.
+func (c *ConfigIngressTLSPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string                       `yaml:"kubeconfig"`
+		ConfigMaps     []IngressControllerConfigMap `yaml:"config-maps"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.ConfigMaps = aux.ConfigMaps
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigIngressTLSPolicy) Validate() error {
+	return c.validate()
+}
+
+// validate validates the configuration.
+func (c *ConfigIngressTLSPolicy) validate() error {
+	var errors []string
+	for i, cm := range c.ConfigMaps {
+		if _, ok := ingressControllerKeys[cm.Controller]; !ok {
+			errors = append(errors, fmt.Sprintf("config-maps[%d]: unsupported controller %q", i, cm.Controller))
+		}
+		if cm.Namespace == "" || cm.Name == "" {
+			errors = append(errors, fmt.Sprintf("config-maps[%d]: namespace and name are required", i))
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// defaultIngressControllerConfigMaps are the ConfigMaps created by the
+// upstream Helm charts of the supported ingress controllers.
+var defaultIngressControllerConfigMaps = []IngressControllerConfigMap{
+	{Controller: "nginx", Namespace: "ingress-nginx", Name: "ingress-nginx-controller"},
+	{Controller: "haproxy", Namespace: "haproxy-controller", Name: "haproxy-kubernetes-ingress"},
+	{Controller: "haproxy", Namespace: "ingress-controller", Name: "haproxy-ingress"},
+}
+
+// tlsSettingKeys are the ConfigMap keys and Ingress annotations holding TLS
+// protocols and ciphers for an ingress controller.
+type tlsSettingKeys struct {
+	protocols   []string
+	ciphers     []string
+	annotations []string
+}
+
+var ingressControllerKeys = map[string]tlsSettingKeys{
+	"nginx": {
+		protocols:   []string{"ssl-protocols"},
+		ciphers:     []string{"ssl-ciphers"},
+		annotations: []string{"nginx.ingress.kubernetes.io/ssl-ciphers"},
+	},
+	"haproxy": {
+		protocols:   []string{"ssl-options", "ssl-min-ver"},
+		ciphers:     []string{"ssl-ciphers", "ssl-cipher-suites"},
+		annotations: []string{"haproxy-ingress.github.io/ssl-ciphers", "haproxy.org/ssl-ciphers"},
+	},
+}
+
+// traefikTLSOptions are the GVRs Traefik serves its TLSOption CRD under,
+// the traefik.containo.us group being used by Traefik before v3.
+var traefikTLSOptions = []schema.GroupVersionResource{
+	{Group: "traefik.io", Version: "v1alpha1", Resource: "tlsoptions"},
+	{Group: "traefik.containo.us", Version: "v1alpha1", Resource: "tlsoptions"},
+}
+
+// NewDataGatherer constructs a new instance of the k8s-ingress-tls-policy data-gatherer.
+func (c *ConfigIngressTLSPolicy) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.newDataGathererWithClient(ctx, cl, clientset)
+}
+
+func (c *ConfigIngressTLSPolicy) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	configMaps := c.ConfigMaps
+	if len(configMaps) == 0 {
+		configMaps = defaultIngressControllerConfigMaps
+	}
+
+	return &DataGathererIngressTLSPolicy{
+		ctx:        ctx,
+		cl:         cl,
+		clientset:  clientset,
+		configMaps: configMaps,
+	}, nil
+}
+
+// DataGathererIngressTLSPolicy collects the TLS settings of ingress-nginx,
+// HAProxy and Traefik ingress controllers and evaluates them against a
+// built-in policy of weak protocols and ciphers.
+type DataGathererIngressTLSPolicy struct {
+	ctx        context.Context
+	cl         dynamic.Interface
+	clientset  kubernetes.Interface
+	configMaps []IngressControllerConfigMap
+}
+
+// IngressTLSSettings are the TLS settings found in a single object.
+type IngressTLSSettings struct {
+	Controller string   `json:"controller"`
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Protocols  []string `json:"protocols,omitempty"`
+	Ciphers    []string `json:"ciphers,omitempty"`
+}
+
+// Rule IDs of the findings reported by the k8s-ingress-tls-policy
+// data-gatherer.
+const (
+	// IngressTLSFindingWeakProtocol is reported for SSL and TLS versions
+	// before TLS 1.2.
+	IngressTLSFindingWeakProtocol = "weak-protocol"
+	// IngressTLSFindingWeakCipher is reported for ciphers using broken
+	// algorithms or no authentication.
+	IngressTLSFindingWeakCipher = "weak-cipher"
+)
+
+// weakProtocols are normalised, see normaliseTLSSetting, protocol names
+// rejected by the built-in policy.
+var weakProtocols = map[string]bool{
+	"sslv2": true, "sslv3": true,
+	"tlsv1": true, "tlsv10": true, "tlsv11": true,
+	"tls10": true, "tls11": true,
+	"versiontls10": true, "versiontls11": true,
+}
+
+// weakCipherPattern matches OpenSSL and IANA/Go cipher names using RC4, DES,
+// 3DES, NULL or export ciphers, MD5 MACs, or anonymous key exchange.
+var weakCipherPattern = regexp.MustCompile(`(?i)(RC4|DES|NULL|EXP|MD5|ANON|ADH|AECDH)`)
+
+// Run is a no-op, settings are read on every Fetch.
+func (g *DataGathererIngressTLSPolicy) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererIngressTLSPolicy) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererIngressTLSPolicy) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch reads the TLS settings of the configured ConfigMaps, of Ingress
+// annotations and of Traefik TLSOptions and evaluates them.
+func (g *DataGathererIngressTLSPolicy) Fetch() (interface{}, int, error) {
+	settings := []*IngressTLSSettings{}
+
+	for _, ref := range g.configMaps {
+		cm, err := g.clientset.CoreV1().ConfigMaps(ref.Namespace).Get(g.ctx, ref.Name, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to get configmap %s/%s: %w", ref.Namespace, ref.Name, err)
+		}
+		keys := ingressControllerKeys[ref.Controller]
+		s := &IngressTLSSettings{Controller: ref.Controller, Kind: "ConfigMap", Namespace: cm.Namespace, Name: cm.Name}
+		for _, key := range keys.protocols {
+			s.Protocols = append(s.Protocols, splitTLSSetting(cm.Data[key])...)
+		}
+		for _, key := range keys.ciphers {
+			s.Ciphers = append(s.Ciphers, splitTLSSetting(cm.Data[key])...)
+		}
+		if len(s.Protocols) > 0 || len(s.Ciphers) > 0 {
+			settings = append(settings, s)
+		}
+	}
+
+	ingresses, err := g.clientset.NetworkingV1().Ingresses(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	for _, ingress := range ingresses.Items {
+		for controller, keys := range ingressControllerKeys {
+			s := &IngressTLSSettings{Controller: controller, Kind: "Ingress", Namespace: ingress.Namespace, Name: ingress.Name}
+			for _, key := range keys.annotations {
+				s.Ciphers = append(s.Ciphers, splitTLSSetting(ingress.Annotations[key])...)
+			}
+			if len(s.Ciphers) > 0 {
+				settings = append(settings, s)
+			}
+		}
+	}
+
+	for _, gvr := range traefikTLSOptions {
+		list, err := g.cl.Resource(gvr).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to list %s: %w", gvr, err)
+		}
+		for _, item := range list.Items {
+			s := &IngressTLSSettings{Controller: "traefik", Kind: "TLSOption", Namespace: item.GetNamespace(), Name: item.GetName()}
+			if minVersion, _, _ := unstructured.NestedString(item.Object, "spec", "minVersion"); minVersion != "" {
+				s.Protocols = append(s.Protocols, minVersion)
+			}
+			cipherSuites, _, _ := unstructured.NestedStringSlice(item.Object, "spec", "cipherSuites")
+			s.Ciphers = append(s.Ciphers, cipherSuites...)
+			settings = append(settings, s)
+		}
+	}
+
+	findings := []api.Finding{}
+	for _, s := range settings {
+		findings = append(findings, evaluateTLSSettings(s)...)
+	}
+
+	response := map[string]interface{}{
+		"settings": settings,
+		"findings": findings,
+	}
+
+	return response, len(settings), nil
+}
+
+// evaluateTLSSettings applies the built-in policy to the settings of an
+// object. Protocols and ciphers prefixed with `!` or `-`, or given as HAProxy
+// `no-` options, are disabled and so are not reported.
+func evaluateTLSSettings(s *IngressTLSSettings) []api.Finding {
+	var findings []api.Finding
+	resource := api.ResourceRef{Kind: s.Kind, Namespace: s.Namespace, Name: s.Name}
+	for _, protocol := range s.Protocols {
+		if isDisabledTLSSetting(protocol) {
+			continue
+		}
+		if weakProtocols[normaliseTLSSetting(protocol)] {
+			findings = append(findings, api.Finding{
+				RuleID:      IngressTLSFindingWeakProtocol,
+				Severity:    api.SeverityHigh,
+				Resource:    resource,
+				Message:     fmt.Sprintf("%s: weak protocol %q is enabled", s.Controller, protocol),
+				Remediation: "Allow only TLS 1.2 and later.",
+			})
+		}
+	}
+	for _, cipher := range s.Ciphers {
+		if isDisabledTLSSetting(cipher) {
+			continue
+		}
+		if weakCipherPattern.MatchString(cipher) {
+			findings = append(findings, api.Finding{
+				RuleID:      IngressTLSFindingWeakCipher,
+				Severity:    api.SeverityMedium,
+				Resource:    resource,
+				Message:     fmt.Sprintf("%s: weak cipher %q is enabled", s.Controller, cipher),
+				Remediation: "Remove the cipher, or prefix it with `!` to disable it.",
+			})
+		}
+	}
+	return findings
+}
+
+// splitTLSSetting splits a list of protocols or ciphers, which controllers
+// separate with colons, commas or spaces.
+func splitTLSSetting(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ':' || r == ',' || r == ' '
+	})
+}
+
+func isDisabledTLSSetting(value string) bool {
+	return strings.HasPrefix(value, "!") || strings.HasPrefix(value, "-") || strings.HasPrefix(strings.ToLower(value), "no-")
+}
+
+// normaliseTLSSetting lower-cases a protocol name and strips punctuation so
+// that e.g. `TLSv1.1`, `tlsv11` and `VersionTLS11` can be compared.
+func normaliseTLSSetting(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(value))
+}
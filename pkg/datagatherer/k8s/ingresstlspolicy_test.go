@@ -0,0 +1,98 @@
+package k8s
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestIngressTLSPolicyGatherer_Fetch(t *testing.T) {
+	clientset := fakeclientset.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ingress-nginx", Name: "ingress-nginx-controller"},
+			Data: map[string]string{
+				"ssl-protocols": "TLSv1.1 TLSv1.2 TLSv1.3",
+				"ssl-ciphers":   "ECDHE-RSA-AES128-GCM-SHA256:DES-CBC3-SHA:!RC4",
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "haproxy-controller", Name: "haproxy-kubernetes-ingress"},
+			Data: map[string]string{
+				"ssl-options": "no-sslv3 no-tlsv10",
+			},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:   "default",
+				Name:        "legacy",
+				Annotations: map[string]string{"nginx.ingress.kubernetes.io/ssl-ciphers": "RC4-SHA"},
+			},
+		},
+	)
+
+	tlsOption := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "traefik.io/v1alpha1",
+		"kind":       "TLSOption",
+		"metadata":   map[string]interface{}{"namespace": "traefik", "name": "default"},
+		"spec": map[string]interface{}{
+			"minVersion":   "VersionTLS10",
+			"cipherSuites": []interface{}{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		},
+	}}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		traefikTLSOptions[0]: "TLSOptionList",
+		traefikTLSOptions[1]: "TLSOptionList",
+	}, tlsOption)
+
+	config := ConfigIngressTLSPolicy{}
+	dg, err := config.newDataGathererWithClient(context.Background(), cl, clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected settings from 4 objects, got %d", count)
+	}
+
+	var got []string
+	for _, f := range res.(map[string]interface{})["findings"].([]api.Finding) {
+		got = append(got, f.RuleID+" "+f.Resource.String()+": "+f.Message)
+	}
+	sort.Strings(got)
+
+	expected := []string{
+		`weak-cipher ConfigMap/ingress-nginx/ingress-nginx-controller: nginx: weak cipher "DES-CBC3-SHA" is enabled`,
+		`weak-cipher Ingress/default/legacy: nginx: weak cipher "RC4-SHA" is enabled`,
+		`weak-protocol ConfigMap/ingress-nginx/ingress-nginx-controller: nginx: weak protocol "TLSv1.1" is enabled`,
+		`weak-protocol TLSOption/traefik/default: traefik: weak protocol "VersionTLS10" is enabled`,
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, got); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestConfigIngressTLSPolicyValidate(t *testing.T) {
+	config := ConfigIngressTLSPolicy{
+		ConfigMaps: []IngressControllerConfigMap{{Controller: "apache", Namespace: "a", Name: "b"}},
+	}
+	err := config.validate()
+	if err == nil || err.Error() != `config-maps[0]: unsupported controller "apache"` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
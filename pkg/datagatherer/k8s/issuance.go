@@ -0,0 +1,482 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultStuckAfter is how long a CertificateRequest can be pending
+	// before it is reported as stuck.
+	defaultStuckAfter = time.Hour
+	// maxIssuanceMessageLength is the length the messages of the failed and
+	// pending resources are truncated to.
+	maxIssuanceMessageLength = 512
+
+	// IssuanceFindingFailed is reported for failed CertificateRequests.
+	IssuanceFindingFailed = "issuance-failed"
+	// IssuanceFindingStuck is reported for CertificateRequests that have
+	// been pending for longer than the configured duration.
+	IssuanceFindingStuck = "issuance-stuck"
+
+	// issuanceStatePending is the state of the resources that may still
+	// succeed.
+	issuanceStatePending = "pending"
+	// issuanceStateFailed is the state of the resources that won't.
+	issuanceStateFailed = "failed"
+)
+
+var (
+	certificateRequestsGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificaterequests"}
+	ordersGVR              = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "orders"}
+	challengesGVR          = schema.GroupVersionResource{Group: "acme.cert-manager.io", Version: "v1", Resource: "challenges"}
+)
+
+// ConfigIssuance contains the configuration for the k8s-issuance
+// data-gatherer.
+type ConfigIssuance struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// StuckAfter is how long a CertificateRequest can be pending before it
+	// is reported as stuck. Defaults to 1h.
+	StuckAfter time.Duration `yaml:"stuck-after"`
+}
+
+// UnmarshalYAML unmarshals the ConfigIssuance.
+func (c *ConfigIssuance) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath    string        `yaml:"kubeconfig"`
+		ExcludeNamespaces []string      `yaml:"exclude-namespaces"`
+		IncludeNamespaces []string      `yaml:"include-namespaces"`
+		StuckAfter        time.Duration `yaml:"stuck-after"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+	c.StuckAfter = aux.StuckAfter
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigIssuance) Validate() error {
+	if c.StuckAfter < 0 {
+		return fmt.Errorf("stuck-after must not be negative")
+	}
+	_, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	return err
+}
+
+// NewDataGatherer constructs a new instance of the k8s-issuance data-gatherer.
+func (c *ConfigIssuance) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, cl)
+}
+
+func (c *ConfigIssuance) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	namespaceFilter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	g := &DataGathererIssuance{
+		ctx:             ctx,
+		cl:              cl,
+		namespaceFilter: namespaceFilter,
+		stuckAfter:      c.StuckAfter,
+	}
+	if g.stuckAfter == 0 {
+		g.stuckAfter = defaultStuckAfter
+	}
+
+	return g, nil
+}
+
+// DataGathererIssuance summarizes the pending and failed
+// CertificateRequests, ACME Orders and Challenges of cert-manager, so that
+// the health of the issuance pipeline can be shown without sending the
+// resources themselves.
+type DataGathererIssuance struct {
+	ctx             context.Context
+	cl              dynamic.Interface
+	namespaceFilter *namespaceFilter
+	stuckAfter      time.Duration
+}
+
+// IssuanceStatus is a pending or failed CertificateRequest, Order or
+// Challenge.
+type IssuanceStatus struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Certificate is the name of the Certificate the resource was created
+	// for, if any.
+	Certificate string `json:"certificate,omitempty"`
+	// Owner is the name of the CertificateRequest of an Order, or of the
+	// Order of a Challenge.
+	Owner string `json:"owner,omitempty"`
+	// State is pending or failed.
+	State string `json:"state"`
+	// Reason classifies the message, e.g. dns-propagation or
+	// acme-rate-limited.
+	Reason string `json:"reason"`
+	// Message is the message of the resource, truncated.
+	Message string `json:"message,omitempty"`
+	// Type is the type of a Challenge, e.g. DNS-01.
+	Type              string   `json:"type,omitempty"`
+	DNSName           string   `json:"dnsName,omitempty"`
+	CreationTimestamp api.Time `json:"creationTimestamp"`
+}
+
+// IssuanceSummary counts the pending and failed CertificateRequests.
+type IssuanceSummary struct {
+	Pending int `json:"pending"`
+	Failed  int `json:"failed"`
+	// Reasons counts the CertificateRequests by reason.
+	Reasons map[string]int `json:"reasons"`
+}
+
+// issuanceReasons classify the messages of the resources of the issuance
+// pipeline that aren't logged as errors, before the errorSignatures of the
+// cert-manager logs are checked.
+var issuanceReasons = []errorSignature{
+	{"dns-propagation", regexp.MustCompile(`(?i)propagation|not yet propagated|DNS record for .* not yet`)},
+	{"http01-self-check", regexp.MustCompile(`(?i)self check`)},
+}
+
+// Run is a no-op, the resources are listed on every Fetch.
+func (g *DataGathererIssuance) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererIssuance) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererIssuance) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the CertificateRequests, Orders and Challenges and returns the
+// pending and failed ones. The successful ones are left out. Nothing is
+// reported if cert-manager is not installed.
+func (g *DataGathererIssuance) Fetch() (interface{}, int, error) {
+	requests, err := g.list(certificateRequestsGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+	orders, err := g.list(ordersGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+	challenges, err := g.list(challengesGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	challengeStatuses := []*IssuanceStatus{}
+	// the reasons of the failing challenges of each order
+	challengeReasons := map[string]string{}
+	for i := range challenges {
+		status := challengeStatus(&challenges[i])
+		if status == nil {
+			continue
+		}
+		challengeStatuses = append(challengeStatuses, status)
+		key := status.Namespace + "/" + status.Owner
+		if _, ok := challengeReasons[key]; !ok && status.Reason != "other" {
+			challengeReasons[key] = status.Reason
+		}
+	}
+
+	orderStatuses := []*IssuanceStatus{}
+	// the reasons of the failing orders of each certificate request
+	orderReasons := map[string]string{}
+	for i := range orders {
+		status := orderStatus(&orders[i])
+		if status == nil {
+			continue
+		}
+		if status.Reason == "other" {
+			if reason, ok := challengeReasons[status.Namespace+"/"+status.Name]; ok {
+				status.Reason = reason
+			}
+		}
+		orderStatuses = append(orderStatuses, status)
+		key := status.Namespace + "/" + status.Owner
+		if _, ok := orderReasons[key]; !ok && status.Reason != "other" {
+			orderReasons[key] = status.Reason
+		}
+	}
+
+	requestStatuses := []*IssuanceStatus{}
+	findings := []api.Finding{}
+	summary := IssuanceSummary{Reasons: map[string]int{}}
+	for i := range requests {
+		status := certificateRequestStatus(&requests[i])
+		if status == nil {
+			continue
+		}
+		if status.Reason == "other" {
+			if reason, ok := orderReasons[status.Namespace+"/"+status.Name]; ok {
+				status.Reason = reason
+			}
+		}
+		requestStatuses = append(requestStatuses, status)
+		summary.Reasons[status.Reason]++
+		if status.State == issuanceStateFailed {
+			summary.Failed++
+		} else {
+			summary.Pending++
+		}
+		if finding := g.finding(status); finding != nil {
+			findings = append(findings, *finding)
+		}
+	}
+
+	for _, statuses := range [][]*IssuanceStatus{requestStatuses, orderStatuses, challengeStatuses} {
+		sort.SliceStable(statuses, func(i, j int) bool {
+			if statuses[i].Namespace != statuses[j].Namespace {
+				return statuses[i].Namespace < statuses[j].Namespace
+			}
+			return statuses[i].Name < statuses[j].Name
+		})
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Resource.Namespace != findings[j].Resource.Namespace {
+			return findings[i].Resource.Namespace < findings[j].Resource.Namespace
+		}
+		return findings[i].Resource.Name < findings[j].Resource.Name
+	})
+
+	response := map[string]interface{}{
+		"certificateRequests": requestStatuses,
+		"orders":              orderStatuses,
+		"challenges":          challengeStatuses,
+		"summary":             summary,
+		"findings":            findings,
+	}
+
+	return response, len(requestStatuses) + len(orderStatuses) + len(challengeStatuses), nil
+}
+
+// list returns the resources of the selected namespaces, or none if the
+// resource is not served.
+func (g *DataGathererIssuance) list(gvr schema.GroupVersionResource) ([]unstructured.Unstructured, error) {
+	list, err := g.cl.Resource(gvr).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gvr, err)
+	}
+	var items []unstructured.Unstructured
+	for _, item := range list.Items {
+		if g.namespaceFilter.isIncluded(item.GetNamespace()) {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// finding returns the finding of a failed CertificateRequest, or of one
+// that has been pending for too long.
+func (g *DataGathererIssuance) finding(status *IssuanceStatus) *api.Finding {
+	finding := &api.Finding{
+		Severity: api.SeverityHigh,
+		Resource: api.ResourceRef{Kind: status.Kind, Namespace: status.Namespace, Name: status.Name},
+	}
+	subject := "the certificate request"
+	if status.Certificate != "" {
+		subject = fmt.Sprintf("the certificate request of Certificate %s", status.Certificate)
+	}
+	switch {
+	case status.State == issuanceStateFailed:
+		finding.RuleID = IssuanceFindingFailed
+		finding.Message = fmt.Sprintf("%s failed (%s)", subject, status.Reason)
+		finding.Remediation = "Check the events of the request and of its Orders and Challenges, fix the cause and let cert-manager retry."
+	case clock.now().Sub(status.CreationTimestamp.Time) > g.stuckAfter:
+		finding.RuleID = IssuanceFindingStuck
+		finding.Severity = api.SeverityMedium
+		finding.Message = fmt.Sprintf("%s has been pending for more than %s (%s)", subject, g.stuckAfter, status.Reason)
+		finding.Remediation = "Check that the request is approved, that its issuer is ready and, for ACME issuers, that the challenges can be solved."
+	default:
+		return nil
+	}
+	return finding
+}
+
+// certificateRequestStatus returns the status of a CertificateRequest that
+// isn't ready, or nil.
+func certificateRequestStatus(u *unstructured.Unstructured) *IssuanceStatus {
+	conditions := issuanceConditions(u)
+	if conditions["Ready"].status == string(metav1.ConditionTrue) {
+		return nil
+	}
+	status := newIssuanceStatus(u)
+	status.Certificate = u.GetAnnotations()["cert-manager.io/certificate-name"]
+	status.State = issuanceStatePending
+	message := conditions["Ready"].message
+	switch {
+	case conditions["Denied"].status == string(metav1.ConditionTrue):
+		status.State = issuanceStateFailed
+		message = conditions["Denied"].message
+		status.Reason = "request-denied"
+	case conditions["InvalidRequest"].status == string(metav1.ConditionTrue):
+		status.State = issuanceStateFailed
+		message = conditions["InvalidRequest"].message
+		status.Reason = "invalid-request"
+	case conditions["Ready"].reason == "Failed":
+		status.State = issuanceStateFailed
+	case conditions["Approved"].status != string(metav1.ConditionTrue) && message == "":
+		status.Reason = "pending-approval"
+	}
+	status.setMessage(message)
+	return status
+}
+
+// orderStatus returns the status of an Order that isn't valid, or nil.
+func orderStatus(u *unstructured.Unstructured) *IssuanceStatus {
+	state, _, _ := unstructured.NestedString(u.Object, "status", "state")
+	if state == "valid" {
+		return nil
+	}
+	status := newIssuanceStatus(u)
+	status.Owner = ownerName(u, "CertificateRequest")
+	status.State = acmeIssuanceState(state)
+	reason, _, _ := unstructured.NestedString(u.Object, "status", "reason")
+	status.setMessage(reason)
+	return status
+}
+
+// challengeStatus returns the status of a Challenge that isn't valid, or
+// nil.
+func challengeStatus(u *unstructured.Unstructured) *IssuanceStatus {
+	state, _, _ := unstructured.NestedString(u.Object, "status", "state")
+	if state == "valid" {
+		return nil
+	}
+	status := newIssuanceStatus(u)
+	status.Owner = ownerName(u, "Order")
+	status.State = acmeIssuanceState(state)
+	status.Type, _, _ = unstructured.NestedString(u.Object, "spec", "type")
+	status.DNSName, _, _ = unstructured.NestedString(u.Object, "spec", "dnsName")
+	reason, _, _ := unstructured.NestedString(u.Object, "status", "reason")
+	status.setMessage(reason)
+	return status
+}
+
+func newIssuanceStatus(u *unstructured.Unstructured) *IssuanceStatus {
+	return &IssuanceStatus{
+		Kind:              u.GetKind(),
+		Namespace:         u.GetNamespace(),
+		Name:              u.GetName(),
+		Certificate:       u.GetAnnotations()["cert-manager.io/certificate-name"],
+		CreationTimestamp: api.Time{Time: u.GetCreationTimestamp().UTC()},
+	}
+}
+
+// setMessage sets the truncated message and, unless it is already set, the
+// reason it is classified as.
+func (s *IssuanceStatus) setMessage(message string) {
+	if s.Reason == "" {
+		s.Reason = classifyIssuanceMessage(message)
+	}
+	if len(message) > maxIssuanceMessageLength {
+		message = message[:maxIssuanceMessageLength] + "..."
+	}
+	s.Message = message
+}
+
+// classifyIssuanceMessage returns the reason of the message, from the
+// issuanceReasons and then the errorSignatures.
+func classifyIssuanceMessage(message string) string {
+	if message == "" {
+		return "other"
+	}
+	for _, reason := range issuanceReasons {
+		if reason.pattern.MatchString(message) {
+			return reason.name
+		}
+	}
+	return classifyError(message)
+}
+
+// acmeIssuanceState maps the state of an Order or Challenge to pending or
+// failed.
+func acmeIssuanceState(state string) string {
+	switch state {
+	case "invalid", "expired", "errored":
+		return issuanceStateFailed
+	}
+	return issuanceStatePending
+}
+
+// ownerName returns the name of the owner of kind, or an empty string.
+func ownerName(u *unstructured.Unstructured, kind string) string {
+	for _, ref := range u.GetOwnerReferences() {
+		if ref.Kind == kind {
+			return ref.Name
+		}
+	}
+	return ""
+}
+
+type issuanceCondition struct {
+	status  string
+	reason  string
+	message string
+}
+
+// issuanceConditions returns the conditions of the resource by type.
+func issuanceConditions(u *unstructured.Unstructured) map[string]issuanceCondition {
+	conditions := map[string]issuanceCondition{}
+	list, _, _ := unstructured.NestedSlice(u.Object, "status", "conditions")
+	for _, item := range list {
+		condition, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		conditionType, _ := condition["type"].(string)
+		c := issuanceCondition{}
+		c.status, _ = condition["status"].(string)
+		c.reason, _ = condition["reason"].(string)
+		c.message, _ = condition["message"].(string)
+		conditions[conditionType] = c
+	}
+	return conditions
+}
@@ -0,0 +1,220 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// the fake clock is at 2021-03-16T18:22:15Z
+const testIssuanceResources = `
+- apiVersion: cert-manager.io/v1
+  kind: CertificateRequest
+  metadata:
+    name: www-1
+    namespace: shop
+    creationTimestamp: "2021-03-16T18:00:00Z"
+    annotations:
+      cert-manager.io/certificate-name: www
+  status:
+    conditions:
+    - type: Approved
+      status: "True"
+    - type: Ready
+      status: "True"
+      reason: Issued
+- apiVersion: cert-manager.io/v1
+  kind: CertificateRequest
+  metadata:
+    name: api-1
+    namespace: shop
+    creationTimestamp: "2021-03-16T10:00:00Z"
+    annotations:
+      cert-manager.io/certificate-name: api
+  status:
+    conditions:
+    - type: Approved
+      status: "True"
+    - type: Ready
+      status: "False"
+      reason: Pending
+      message: 'Waiting on certificate issuance from order shop/api-1-123: "pending"'
+- apiVersion: cert-manager.io/v1
+  kind: CertificateRequest
+  metadata:
+    name: blog-1
+    namespace: blog
+    creationTimestamp: "2021-03-16T18:20:00Z"
+    annotations:
+      cert-manager.io/certificate-name: blog
+  status:
+    conditions:
+    - type: Approved
+      status: "True"
+    - type: Ready
+      status: "False"
+      reason: Failed
+      message: 'Failed to create Order: 429 urn:ietf:params:acme:error:rateLimited: too many certificates already issued'
+- apiVersion: cert-manager.io/v1
+  kind: CertificateRequest
+  metadata:
+    name: manual
+    namespace: blog
+    creationTimestamp: "2021-03-16T18:20:00Z"
+  status:
+    conditions:
+    - type: Denied
+      status: "True"
+      message: Denied by policy
+    - type: Ready
+      status: "False"
+      reason: Denied
+- apiVersion: cert-manager.io/v1
+  kind: CertificateRequest
+  metadata:
+    name: ignored
+    namespace: kube-system
+    creationTimestamp: "2021-03-16T10:00:00Z"
+- apiVersion: acme.cert-manager.io/v1
+  kind: Order
+  metadata:
+    name: api-1-123
+    namespace: shop
+    creationTimestamp: "2021-03-16T10:00:01Z"
+    annotations:
+      cert-manager.io/certificate-name: api
+    ownerReferences:
+    - apiVersion: cert-manager.io/v1
+      kind: CertificateRequest
+      name: api-1
+      uid: "1"
+  status:
+    state: pending
+- apiVersion: acme.cert-manager.io/v1
+  kind: Challenge
+  metadata:
+    name: api-1-123-456
+    namespace: shop
+    creationTimestamp: "2021-03-16T10:00:02Z"
+    ownerReferences:
+    - apiVersion: acme.cert-manager.io/v1
+      kind: Order
+      name: api-1-123
+      uid: "2"
+  spec:
+    type: DNS-01
+    dnsName: api.shop.example.com
+  status:
+    state: pending
+    reason: 'Waiting for DNS-01 challenge propagation: DNS record for "api.shop.example.com" not yet propagated'
+- apiVersion: acme.cert-manager.io/v1
+  kind: Challenge
+  metadata:
+    name: www-1-789
+    namespace: shop
+    creationTimestamp: "2021-03-16T18:00:02Z"
+  spec:
+    type: HTTP-01
+    dnsName: www.shop.example.com
+  status:
+    state: valid
+`
+
+func TestIssuanceGatherer_Fetch(t *testing.T) {
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(testIssuanceResources), &items); err != nil {
+		t.Fatal(err)
+	}
+	var objects []runtime.Object
+	for _, item := range items {
+		objects = append(objects, &unstructured.Unstructured{Object: item})
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		certificateRequestsGVR: "CertificateRequestList",
+		ordersGVR:              "OrderList",
+		challengesGVR:          "ChallengeList",
+	}, objects...)
+
+	config := ConfigIssuance{ExcludeNamespaces: []string{"kube-system"}}
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5 resources, got %d", count)
+	}
+	result := data.(map[string]interface{})
+
+	at := func(s string) api.Time {
+		ts, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return api.Time{Time: ts}
+	}
+	expectedRequests := []*IssuanceStatus{
+		{
+			Kind: "CertificateRequest", Namespace: "blog", Name: "blog-1", Certificate: "blog", State: "failed", Reason: "acme-rate-limited",
+			Message:           "Failed to create Order: 429 urn:ietf:params:acme:error:rateLimited: too many certificates already issued",
+			CreationTimestamp: at("2021-03-16T18:20:00Z"),
+		},
+		{
+			Kind: "CertificateRequest", Namespace: "blog", Name: "manual", State: "failed", Reason: "request-denied",
+			Message: "Denied by policy", CreationTimestamp: at("2021-03-16T18:20:00Z"),
+		},
+		{
+			Kind: "CertificateRequest", Namespace: "shop", Name: "api-1", Certificate: "api", State: "pending", Reason: "dns-propagation",
+			Message:           `Waiting on certificate issuance from order shop/api-1-123: "pending"`,
+			CreationTimestamp: at("2021-03-16T10:00:00Z"),
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRequests, result["certificateRequests"]); !equal {
+		t.Errorf("unexpected certificate requests:\n%s", diff)
+	}
+	expectedChallenges := []*IssuanceStatus{
+		{
+			Kind: "Challenge", Namespace: "shop", Name: "api-1-123-456", Owner: "api-1-123", State: "pending", Reason: "dns-propagation",
+			Message:           `Waiting for DNS-01 challenge propagation: DNS record for "api.shop.example.com" not yet propagated`,
+			Type:              "DNS-01",
+			DNSName:           "api.shop.example.com",
+			CreationTimestamp: at("2021-03-16T10:00:02Z"),
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedChallenges, result["challenges"]); !equal {
+		t.Errorf("unexpected challenges:\n%s", diff)
+	}
+	if orders := result["orders"].([]*IssuanceStatus); len(orders) != 1 || orders[0].Owner != "api-1" || orders[0].Reason != "dns-propagation" {
+		t.Errorf("unexpected orders: %+v", orders)
+	}
+
+	expectedSummary := IssuanceSummary{Pending: 1, Failed: 2, Reasons: map[string]int{"acme-rate-limited": 1, "request-denied": 1, "dns-propagation": 1}}
+	if diff, equal := messagediff.PrettyDiff(expectedSummary, result["summary"]); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range result["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Namespace+"/"+finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"blog/blog-1 " + IssuanceFindingFailed,
+		"blog/manual " + IssuanceFindingFailed,
+		"shop/api-1 " + IssuanceFindingStuck,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
@@ -0,0 +1,379 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultClusterResourceNamespace is the namespace cert-manager reads the
+	// Secrets of the ClusterIssuers from, unless configured otherwise.
+	defaultClusterResourceNamespace = "cert-manager"
+	// defaultACMEDirectoryTimeout is the timeout of the requests for the
+	// directories of the ACME servers.
+	defaultACMEDirectoryTimeout = 10 * time.Second
+
+	// IssuerFindingNotReady is reported for the issuers that are not ready.
+	IssuerFindingNotReady = "issuer-not-ready"
+	// IssuerFindingACMEAccountSecretMissing is reported for the ACME issuers
+	// whose account key Secret doesn't exist.
+	IssuerFindingACMEAccountSecretMissing = "acme-account-secret-missing"
+	// IssuerFindingACMEDirectoryUnreachable is reported for the ACME issuers
+	// whose server directory can't be fetched.
+	IssuerFindingACMEDirectoryUnreachable = "acme-directory-unreachable"
+)
+
+var (
+	issuersGVR        = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "issuers"}
+	clusterIssuersGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+)
+
+// ConfigIssuerHealth contains the configuration for the k8s-issuer-health
+// data-gatherer.
+type ConfigIssuerHealth struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// ClusterResourceNamespace is the namespace of the Secrets of the
+	// ClusterIssuers, the --cluster-resource-namespace of cert-manager.
+	// Defaults to cert-manager.
+	ClusterResourceNamespace string `yaml:"cluster-resource-namespace"`
+	// CheckACMEAccountSecrets enables checking that the account key Secrets
+	// of the ACME issuers exist.
+	CheckACMEAccountSecrets bool `yaml:"check-acme-account-secrets"`
+	// CheckACMEDirectories enables fetching the directories of the ACME
+	// servers, to check that they are reachable from the cluster.
+	CheckACMEDirectories bool `yaml:"check-acme-directories"`
+	// ACMEDirectoryTimeout is the timeout of the requests for the ACME
+	// directories. Defaults to 10s.
+	ACMEDirectoryTimeout time.Duration `yaml:"acme-directory-timeout"`
+}
+
+// UnmarshalYAML unmarshals the ConfigIssuerHealth.
+func (c *ConfigIssuerHealth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath           string        `yaml:"kubeconfig"`
+		ClusterResourceNamespace string        `yaml:"cluster-resource-namespace"`
+		CheckACMEAccountSecrets  bool          `yaml:"check-acme-account-secrets"`
+		CheckACMEDirectories     bool          `yaml:"check-acme-directories"`
+		ACMEDirectoryTimeout     time.Duration `yaml:"acme-directory-timeout"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.ClusterResourceNamespace = aux.ClusterResourceNamespace
+	c.CheckACMEAccountSecrets = aux.CheckACMEAccountSecrets
+	c.CheckACMEDirectories = aux.CheckACMEDirectories
+	c.ACMEDirectoryTimeout = aux.ACMEDirectoryTimeout
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigIssuerHealth) Validate() error {
+	if c.ACMEDirectoryTimeout < 0 {
+		return fmt.Errorf("acme-directory-timeout must not be negative")
+	}
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-issuer-health data-gatherer.
+func (c *ConfigIssuerHealth) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, cl, clientset)
+}
+
+func (c *ConfigIssuerHealth) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	g := &DataGathererIssuerHealth{
+		ctx:                      ctx,
+		cl:                       cl,
+		clientset:                clientset,
+		clusterResourceNamespace: c.ClusterResourceNamespace,
+		checkAccountSecrets:      c.CheckACMEAccountSecrets,
+		checkDirectories:         c.CheckACMEDirectories,
+		httpClient:               &http.Client{Timeout: c.ACMEDirectoryTimeout},
+	}
+	if g.clusterResourceNamespace == "" {
+		g.clusterResourceNamespace = defaultClusterResourceNamespace
+	}
+	if g.httpClient.Timeout == 0 {
+		g.httpClient.Timeout = defaultACMEDirectoryTimeout
+	}
+
+	return g, nil
+}
+
+// DataGathererIssuerHealth evaluates the health of the cert-manager Issuers
+// and ClusterIssuers: their Ready condition and, optionally, whether the
+// account key Secrets of the ACME issuers exist and their servers are
+// reachable.
+type DataGathererIssuerHealth struct {
+	ctx                      context.Context
+	cl                       dynamic.Interface
+	clientset                kubernetes.Interface
+	clusterResourceNamespace string
+	checkAccountSecrets      bool
+	checkDirectories         bool
+	httpClient               *http.Client
+}
+
+// IssuerHealth is the health of an Issuer or ClusterIssuer.
+type IssuerHealth struct {
+	// Kind is Issuer or ClusterIssuer.
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// Type is the type of the issuer, e.g. acme, ca or vault.
+	Type string `json:"type"`
+	// Ready is the status of the Ready condition, Unknown if it is not set.
+	Ready              string    `json:"ready"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime *api.Time `json:"lastTransitionTime,omitempty"`
+	// Healthy is false if a finding is reported for the issuer.
+	Healthy bool        `json:"healthy"`
+	ACME    *ACMEHealth `json:"acme,omitempty"`
+}
+
+// ACMEHealth is the health of the ACME account of an issuer. The checks that
+// are not enabled are omitted.
+type ACMEHealth struct {
+	Server string `json:"server"`
+	// AccountSecret is the namespace/name of the Secret of the account key.
+	AccountSecret       string `json:"accountSecret"`
+	AccountSecretExists *bool  `json:"accountSecretExists,omitempty"`
+	DirectoryReachable  *bool  `json:"directoryReachable,omitempty"`
+	DirectoryError      string `json:"directoryError,omitempty"`
+}
+
+// certManagerIssuer is the part of an Issuer or ClusterIssuer that is
+// evaluated.
+type certManagerIssuer struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              map[string]interface{} `json:"spec"`
+	Status            struct {
+		Conditions []struct {
+			Type               string      `json:"type"`
+			Status             string      `json:"status"`
+			Reason             string      `json:"reason"`
+			Message            string      `json:"message"`
+			LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// Run is a no-op, the issuers are listed on every Fetch.
+func (g *DataGathererIssuerHealth) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererIssuerHealth) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererIssuerHealth) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the Issuers and ClusterIssuers and evaluates their health.
+// Nothing is reported if cert-manager is not installed.
+func (g *DataGathererIssuerHealth) Fetch() (interface{}, int, error) {
+	issuers := []*IssuerHealth{}
+	findings := []api.Finding{}
+	// the directories are fetched once for all the issuers of a server
+	directories := map[string]error{}
+
+	for _, gvr := range []schema.GroupVersionResource{clusterIssuersGVR, issuersGVR} {
+		list, err := g.cl.Resource(gvr).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to list %s: %w", gvr, err)
+		}
+		for _, item := range list.Items {
+			var issuer certManagerIssuer
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &issuer); err != nil {
+				return nil, -1, fmt.Errorf("failed to decode %s %s: %w", item.GetKind(), item.GetName(), err)
+			}
+			health, issuerFindings, err := g.evaluate(item.GetKind(), &issuer, directories)
+			if err != nil {
+				return nil, -1, err
+			}
+			issuers = append(issuers, health)
+			findings = append(findings, issuerFindings...)
+		}
+	}
+	sort.SliceStable(issuers, func(i, j int) bool {
+		if issuers[i].Kind != issuers[j].Kind {
+			return issuers[i].Kind < issuers[j].Kind
+		}
+		if issuers[i].Namespace != issuers[j].Namespace {
+			return issuers[i].Namespace < issuers[j].Namespace
+		}
+		return issuers[i].Name < issuers[j].Name
+	})
+
+	response := map[string]interface{}{
+		"issuers":  issuers,
+		"findings": findings,
+	}
+
+	return response, len(issuers), nil
+}
+
+// evaluate returns the health of the issuer and its findings.
+func (g *DataGathererIssuerHealth) evaluate(kind string, issuer *certManagerIssuer, directories map[string]error) (*IssuerHealth, []api.Finding, error) {
+	health := &IssuerHealth{
+		Kind:      kind,
+		Namespace: issuer.Namespace,
+		Name:      issuer.Name,
+		Type:      issuerType(issuer.Spec),
+		Ready:     string(metav1.ConditionUnknown),
+	}
+	for _, condition := range issuer.Status.Conditions {
+		if condition.Type != "Ready" {
+			continue
+		}
+		health.Ready = condition.Status
+		health.Reason = condition.Reason
+		health.Message = condition.Message
+		if !condition.LastTransitionTime.IsZero() {
+			health.LastTransitionTime = &api.Time{Time: condition.LastTransitionTime.Time}
+		}
+	}
+
+	var findings []api.Finding
+	finding := func(ruleID string, severity api.Severity, message, remediation string) {
+		findings = append(findings, api.Finding{
+			RuleID:      ruleID,
+			Severity:    severity,
+			Resource:    api.ResourceRef{Kind: kind, Namespace: issuer.Namespace, Name: issuer.Name},
+			Message:     message,
+			Remediation: remediation,
+		})
+	}
+
+	if health.Ready != string(metav1.ConditionTrue) {
+		message := fmt.Sprintf("the issuer is not ready (%s)", health.Ready)
+		if health.Message != "" {
+			message = fmt.Sprintf("the issuer is not ready: %s", health.Message)
+		}
+		finding(IssuerFindingNotReady, api.SeverityHigh, message,
+			"Check the events and status of the issuer, and the logs of cert-manager.")
+	}
+
+	if acme, ok := issuer.Spec["acme"].(map[string]interface{}); ok {
+		health.ACME = &ACMEHealth{}
+		health.ACME.Server, _ = acme["server"].(string)
+		namespace := issuer.Namespace
+		if namespace == "" {
+			namespace = g.clusterResourceNamespace
+		}
+		secretName := ""
+		if ref, ok := acme["privateKeySecretRef"].(map[string]interface{}); ok {
+			secretName, _ = ref["name"].(string)
+		}
+		health.ACME.AccountSecret = namespace + "/" + secretName
+
+		if g.checkAccountSecrets && secretName != "" {
+			_, err := g.clientset.CoreV1().Secrets(namespace).Get(g.ctx, secretName, metav1.GetOptions{})
+			if err != nil && !k8serrors.IsNotFound(err) {
+				return nil, nil, fmt.Errorf("failed to get secret %s: %w", health.ACME.AccountSecret, err)
+			}
+			exists := err == nil
+			health.ACME.AccountSecretExists = &exists
+			if !exists {
+				finding(IssuerFindingACMEAccountSecretMissing, api.SeverityHigh,
+					fmt.Sprintf("the ACME account key Secret %s doesn't exist", health.ACME.AccountSecret),
+					"Restore the Secret from a backup, or let cert-manager register a new account by recreating the issuer.")
+			}
+		}
+
+		if g.checkDirectories && health.ACME.Server != "" {
+			err, ok := directories[health.ACME.Server]
+			if !ok {
+				err = g.fetchDirectory(health.ACME.Server)
+				directories[health.ACME.Server] = err
+			}
+			reachable := err == nil
+			health.ACME.DirectoryReachable = &reachable
+			if err != nil {
+				health.ACME.DirectoryError = err.Error()
+				finding(IssuerFindingACMEDirectoryUnreachable, api.SeverityMedium,
+					fmt.Sprintf("the directory of the ACME server %s can't be fetched: %s", health.ACME.Server, err),
+					"Check that the server URL is correct, and that egress to the server is allowed by the network policies and proxies of the cluster.")
+			}
+		}
+	}
+
+	health.Healthy = len(findings) == 0
+	return health, findings, nil
+}
+
+// fetchDirectory gets the directory of the ACME server.
+func (g *DataGathererIssuerHealth) fetchDirectory(server string) error {
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, server, nil)
+	if err != nil {
+		return err
+	}
+	res, err := g.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+// issuerTypes are the keys of the spec of the issuers that configure their
+// type.
+var issuerTypes = []string{"acme", "ca", "selfSigned", "vault", "venafi"}
+
+// issuerType returns the type of the issuer. External issuers are configured
+// with other resources, which have no type in the spec.
+func issuerType(spec map[string]interface{}) string {
+	for _, t := range issuerTypes {
+		if _, ok := spec[t]; ok {
+			return t
+		}
+	}
+	return "unknown"
+}
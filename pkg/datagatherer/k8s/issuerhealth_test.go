@@ -0,0 +1,145 @@
+package k8s
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const testIssuers = `
+- apiVersion: cert-manager.io/v1
+  kind: ClusterIssuer
+  metadata:
+    name: letsencrypt
+  spec:
+    acme:
+      server: {{directory}}
+      privateKeySecretRef:
+        name: letsencrypt-account
+  status:
+    conditions:
+    - type: Ready
+      status: "True"
+      reason: ACMEAccountRegistered
+- apiVersion: cert-manager.io/v1
+  kind: ClusterIssuer
+  metadata:
+    name: letsencrypt-staging
+  spec:
+    acme:
+      server: {{unreachable}}
+      privateKeySecretRef:
+        name: letsencrypt-staging-account
+  status:
+    conditions:
+    - type: Ready
+      status: "False"
+      reason: ErrRegisterACMEAccount
+      message: Failed to register ACME account
+- apiVersion: cert-manager.io/v1
+  kind: Issuer
+  metadata:
+    name: ca
+    namespace: team-a
+  spec:
+    ca:
+      secretName: ca
+  status:
+    conditions:
+    - type: Ready
+      status: "True"
+`
+
+func TestIssuerHealthGatherer_Fetch(t *testing.T) {
+	directory := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/directory" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"newAccount": "https://acme.example.com/new-account"}`))
+	}))
+	defer directory.Close()
+
+	manifest := replaceAll(testIssuers, map[string]string{
+		"{{directory}}":   directory.URL + "/directory",
+		"{{unreachable}}": directory.URL + "/missing",
+	})
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(manifest), &items); err != nil {
+		t.Fatal(err)
+	}
+	var objects []runtime.Object
+	for _, item := range items {
+		objects = append(objects, &unstructured.Unstructured{Object: item})
+	}
+	cl := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		issuersGVR:        "IssuerList",
+		clusterIssuersGVR: "ClusterIssuerList",
+	}, objects...)
+	clientset := fakeclientset.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "letsencrypt-account"},
+	})
+
+	config := ConfigIssuerHealth{CheckACMEAccountSecrets: true, CheckACMEDirectories: true}
+	dg, err := config.newDataGathererWithClient(context.Background(), cl, clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 issuers, got %d", count)
+	}
+
+	yes, no := true, false
+	expected := []*IssuerHealth{
+		{
+			Kind: "ClusterIssuer", Name: "letsencrypt", Type: "acme", Ready: "True", Reason: "ACMEAccountRegistered", Healthy: true,
+			ACME: &ACMEHealth{Server: directory.URL + "/directory", AccountSecret: "cert-manager/letsencrypt-account", AccountSecretExists: &yes, DirectoryReachable: &yes},
+		},
+		{
+			Kind: "ClusterIssuer", Name: "letsencrypt-staging", Type: "acme", Ready: "False", Reason: "ErrRegisterACMEAccount", Message: "Failed to register ACME account",
+			ACME: &ACMEHealth{Server: directory.URL + "/missing", AccountSecret: "cert-manager/letsencrypt-staging-account", AccountSecretExists: &no, DirectoryReachable: &no, DirectoryError: "unexpected status code 404"},
+		},
+		{Kind: "Issuer", Namespace: "team-a", Name: "ca", Type: "ca", Ready: "True", Healthy: true},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data.(map[string]interface{})["issuers"]); !equal {
+		t.Errorf("unexpected issuers:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"letsencrypt-staging " + IssuerFindingNotReady,
+		"letsencrypt-staging " + IssuerFindingACMEAccountSecretMissing,
+		"letsencrypt-staging " + IssuerFindingACMEDirectoryUnreachable,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func replaceAll(s string, replacements map[string]string) string {
+	for old, replacement := range replacements {
+		s = strings.ReplaceAll(s, old, replacement)
+	}
+	return s
+}
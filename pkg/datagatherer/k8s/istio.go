@@ -0,0 +1,507 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultIstioRootNamespace is the namespace of the mesh-wide policies
+	// unless Istio is configured with another root namespace.
+	defaultIstioRootNamespace = "istio-system"
+
+	// IstioFindingMTLSDisabled is reported for the PeerAuthentications and
+	// DestinationRules disabling mutual TLS.
+	IstioFindingMTLSDisabled = "mtls-disabled"
+	// IstioFindingMTLSPermissive is reported if the mesh-wide mutual TLS mode
+	// accepts plaintext traffic.
+	IstioFindingMTLSPermissive = "mtls-permissive"
+	// IstioFindingGatewayPlaintext is reported for the Gateway servers
+	// serving HTTP without redirecting to HTTPS.
+	IstioFindingGatewayPlaintext = "gateway-plaintext"
+
+	// the sources of the certificates of Gateways and DestinationRules
+	istioCertificateSourceIstio  = "istio"
+	istioCertificateSourceSecret = "secret"
+	istioCertificateSourceFile   = "file"
+)
+
+var (
+	istioGatewaysGVR            = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "gateways"}
+	istioDestinationRulesGVR    = schema.GroupVersionResource{Group: "networking.istio.io", Version: "v1beta1", Resource: "destinationrules"}
+	istioPeerAuthenticationsGVR = schema.GroupVersionResource{Group: "security.istio.io", Version: "v1beta1", Resource: "peerauthentications"}
+)
+
+// istioGVRs are the resources listed by the k8s-istio data gatherer.
+var istioGVRs = []schema.GroupVersionResource{istioGatewaysGVR, istioDestinationRulesGVR, istioPeerAuthenticationsGVR}
+
+// ConfigIstio contains the configuration for the k8s-istio data-gatherer.
+type ConfigIstio struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// RootNamespace is the root namespace of Istio, whose PeerAuthentication
+	// without selector applies to the whole mesh. Defaults to istio-system.
+	RootNamespace string `yaml:"root-namespace"`
+}
+
+// UnmarshalYAML unmarshals the ConfigIstio.
+func (c *ConfigIstio) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string `yaml:"kubeconfig"`
+		RootNamespace  string `yaml:"root-namespace"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.RootNamespace = aux.RootNamespace
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-istio data-gatherer.
+func (c *ConfigIstio) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	cl, err := NewDynamicClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, cl)
+}
+
+func (c *ConfigIstio) newDataGathererWithClient(ctx context.Context, cl dynamic.Interface) (datagatherer.DataGatherer, error) {
+	rootNamespace := c.RootNamespace
+	if rootNamespace == "" {
+		rootNamespace = defaultIstioRootNamespace
+	}
+	return &DataGathererIstio{
+		ctx:           ctx,
+		cl:            cl,
+		rootNamespace: rootNamespace,
+	}, nil
+}
+
+// DataGathererIstio summarises the TLS configuration of an Istio service
+// mesh: the mutual TLS modes of the PeerAuthentications, and the TLS modes and
+// certificate sources of the Gateways and DestinationRules. Nothing is
+// reported if Istio is not installed.
+type DataGathererIstio struct {
+	ctx           context.Context
+	cl            dynamic.Interface
+	rootNamespace string
+}
+
+// Istio is the data of the k8s-istio data gatherer.
+type Istio struct {
+	// MeshMTLSMode is the mutual TLS mode of the mesh-wide
+	// PeerAuthentication, PERMISSIVE if there is none.
+	MeshMTLSMode        string                    `json:"meshMTLSMode"`
+	PeerAuthentications []IstioPeerAuthentication `json:"peerAuthentications"`
+	Gateways            []IstioGateway            `json:"gateways"`
+	DestinationRules    []IstioDestinationRule    `json:"destinationRules"`
+}
+
+// IstioPeerAuthentication is the mutual TLS mode a PeerAuthentication sets
+// for the workloads it selects.
+type IstioPeerAuthentication struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Scope is mesh, namespace or workload.
+	Scope    string            `json:"scope"`
+	Selector map[string]string `json:"selector,omitempty"`
+	// Mode is UNSET, DISABLE, PERMISSIVE or STRICT.
+	Mode string `json:"mode"`
+	// PortModes are the modes of the ports overriding the mode.
+	PortModes map[string]string `json:"portModes,omitempty"`
+}
+
+// IstioGateway is the TLS configuration of the servers of a Gateway.
+type IstioGateway struct {
+	Namespace string               `json:"namespace"`
+	Name      string               `json:"name"`
+	Servers   []IstioGatewayServer `json:"servers"`
+}
+
+// IstioGatewayServer is a server of a Gateway.
+type IstioGatewayServer struct {
+	Port     int64    `json:"port"`
+	Protocol string   `json:"protocol"`
+	Hosts    []string `json:"hosts"`
+	// TLSMode is SIMPLE, MUTUAL, PASSTHROUGH, AUTO_PASSTHROUGH or
+	// ISTIO_MUTUAL, or empty for plaintext servers.
+	TLSMode            string `json:"tlsMode,omitempty"`
+	HTTPSRedirect      bool   `json:"httpsRedirect,omitempty"`
+	MinProtocolVersion string `json:"minProtocolVersion,omitempty"`
+	// CertificateSource is where the certificate comes from: a Secret, a
+	// file mounted in the gateway, or the Istio CA.
+	CertificateSource string `json:"certificateSource,omitempty"`
+	// CredentialName is the name of the Secret of the certificate.
+	CredentialName string `json:"credentialName,omitempty"`
+}
+
+// IstioDestinationRule is the TLS configuration of the traffic to a host.
+type IstioDestinationRule struct {
+	Namespace string           `json:"namespace"`
+	Name      string           `json:"name"`
+	Host      string           `json:"host"`
+	TLS       []IstioClientTLS `json:"tls"`
+}
+
+// IstioClientTLS is the TLS configuration of the traffic to the host, or to
+// one of its ports.
+type IstioClientTLS struct {
+	// Port is the port the configuration applies to, or 0 for all ports.
+	Port int64 `json:"port,omitempty"`
+	// Mode is DISABLE, SIMPLE, MUTUAL or ISTIO_MUTUAL.
+	Mode              string `json:"mode"`
+	CertificateSource string `json:"certificateSource,omitempty"`
+	CredentialName    string `json:"credentialName,omitempty"`
+}
+
+// istioPeerAuthentication is the part of a PeerAuthentication that is
+// evaluated.
+type istioPeerAuthentication struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Selector *struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"selector"`
+		MTLS *struct {
+			Mode string `json:"mode"`
+		} `json:"mtls"`
+		PortLevelMTLS map[string]struct {
+			Mode string `json:"mode"`
+		} `json:"portLevelMtls"`
+	} `json:"spec"`
+}
+
+// istioGateway is the part of a Gateway that is evaluated.
+type istioGateway struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Servers []struct {
+			Port struct {
+				Number   int64  `json:"number"`
+				Protocol string `json:"protocol"`
+			} `json:"port"`
+			Hosts []string        `json:"hosts"`
+			TLS   *istioServerTLS `json:"tls"`
+		} `json:"servers"`
+	} `json:"spec"`
+}
+
+type istioServerTLS struct {
+	Mode               string `json:"mode"`
+	HTTPSRedirect      bool   `json:"httpsRedirect"`
+	MinProtocolVersion string `json:"minProtocolVersion"`
+	CredentialName     string `json:"credentialName"`
+	ServerCertificate  string `json:"serverCertificate"`
+}
+
+// istioDestinationRule is the part of a DestinationRule that is evaluated.
+type istioDestinationRule struct {
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              struct {
+		Host          string `json:"host"`
+		TrafficPolicy *struct {
+			TLS               *istioClientTLS `json:"tls"`
+			PortLevelSettings []struct {
+				Port struct {
+					Number int64 `json:"number"`
+				} `json:"port"`
+				TLS *istioClientTLS `json:"tls"`
+			} `json:"portLevelSettings"`
+		} `json:"trafficPolicy"`
+	} `json:"spec"`
+}
+
+type istioClientTLS struct {
+	Mode              string `json:"mode"`
+	CredentialName    string `json:"credentialName"`
+	ClientCertificate string `json:"clientCertificate"`
+	CACertificates    string `json:"caCertificates"`
+}
+
+// Run is a no-op, the Istio resources are listed on every Fetch.
+func (g *DataGathererIstio) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererIstio) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererIstio) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists the PeerAuthentications, Gateways and DestinationRules and
+// summarises their TLS configuration.
+func (g *DataGathererIstio) Fetch() (interface{}, int, error) {
+	result := &Istio{
+		MeshMTLSMode:        "PERMISSIVE",
+		PeerAuthentications: []IstioPeerAuthentication{},
+		Gateways:            []IstioGateway{},
+		DestinationRules:    []IstioDestinationRule{},
+	}
+	findings := []api.Finding{}
+	installed := false
+
+	items, err := g.list(istioPeerAuthenticationsGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+	installed = installed || items != nil
+	meshWide := false
+	for _, item := range items {
+		var pa istioPeerAuthentication
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &pa); err != nil {
+			return nil, -1, fmt.Errorf("failed to decode peerauthentication %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		summary := g.peerAuthentication(&pa)
+		if summary.Scope == "mesh" {
+			meshWide = true
+			if summary.Mode != "UNSET" {
+				result.MeshMTLSMode = summary.Mode
+			}
+		}
+		result.PeerAuthentications = append(result.PeerAuthentications, summary)
+		ref := api.ResourceRef{Kind: "PeerAuthentication", Namespace: summary.Namespace, Name: summary.Name}
+		if summary.Mode == "DISABLE" {
+			findings = append(findings, mtlsDisabledFinding(ref, fmt.Sprintf("mutual TLS is disabled for the %s", summary.Scope)))
+		}
+		for _, port := range sortedKeys(summary.PortModes) {
+			if summary.PortModes[port] == "DISABLE" {
+				findings = append(findings, mtlsDisabledFinding(ref, fmt.Sprintf("mutual TLS is disabled for port %s", port)))
+			}
+		}
+	}
+
+	items, err = g.list(istioGatewaysGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+	installed = installed || items != nil
+	for _, item := range items {
+		var gw istioGateway
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &gw); err != nil {
+			return nil, -1, fmt.Errorf("failed to decode gateway %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		summary := newIstioGateway(&gw)
+		result.Gateways = append(result.Gateways, summary)
+		for _, server := range summary.Servers {
+			if server.TLSMode == "" && server.Protocol == "HTTP" && !server.HTTPSRedirect {
+				findings = append(findings, api.Finding{
+					RuleID:      IstioFindingGatewayPlaintext,
+					Severity:    api.SeverityLow,
+					Resource:    api.ResourceRef{Kind: "Gateway", Namespace: summary.Namespace, Name: summary.Name},
+					Message:     fmt.Sprintf("port %d serves %v over plaintext HTTP without redirecting to HTTPS", server.Port, server.Hosts),
+					Remediation: "Set tls.httpsRedirect on the server, or serve the hosts over HTTPS only.",
+				})
+			}
+		}
+	}
+
+	items, err = g.list(istioDestinationRulesGVR)
+	if err != nil {
+		return nil, -1, err
+	}
+	installed = installed || items != nil
+	for _, item := range items {
+		var dr istioDestinationRule
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &dr); err != nil {
+			return nil, -1, fmt.Errorf("failed to decode destinationrule %s/%s: %w", item.GetNamespace(), item.GetName(), err)
+		}
+		summary := newIstioDestinationRule(&dr)
+		result.DestinationRules = append(result.DestinationRules, summary)
+		for _, tls := range summary.TLS {
+			if tls.Mode != "DISABLE" {
+				continue
+			}
+			target := "host " + summary.Host
+			if tls.Port != 0 {
+				target = fmt.Sprintf("port %d of host %s", tls.Port, summary.Host)
+			}
+			findings = append(findings, mtlsDisabledFinding(
+				api.ResourceRef{Kind: "DestinationRule", Namespace: summary.Namespace, Name: summary.Name},
+				fmt.Sprintf("TLS is disabled for the traffic to %s", target)))
+		}
+	}
+
+	if !installed {
+		return map[string]interface{}{}, 0, nil
+	}
+
+	if result.MeshMTLSMode == "PERMISSIVE" {
+		message := "the mesh-wide PeerAuthentication accepts plaintext traffic"
+		if !meshWide {
+			message = fmt.Sprintf("there is no mesh-wide PeerAuthentication in %s, so plaintext traffic is accepted", g.rootNamespace)
+		}
+		findings = append(findings, api.Finding{
+			RuleID:      IstioFindingMTLSPermissive,
+			Severity:    api.SeverityMedium,
+			Resource:    api.ResourceRef{Kind: "Namespace", Name: g.rootNamespace},
+			Message:     message,
+			Remediation: fmt.Sprintf("Once all workloads have sidecars, create a PeerAuthentication with mode STRICT and no selector in %s.", g.rootNamespace),
+		})
+	}
+
+	response := map[string]interface{}{
+		"istio":    result,
+		"findings": findings,
+	}
+
+	return response, len(result.PeerAuthentications) + len(result.Gateways) + len(result.DestinationRules), nil
+}
+
+// list returns the objects of the resource, sorted by namespace and name, or
+// nil if Istio is not installed.
+func (g *DataGathererIstio) list(gvr schema.GroupVersionResource) ([]unstructured.Unstructured, error) {
+	list, err := g.cl.Resource(gvr).Namespace(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gvr, err)
+	}
+	items := list.Items
+	if items == nil {
+		items = []unstructured.Unstructured{}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].GetNamespace() != items[j].GetNamespace() {
+			return items[i].GetNamespace() < items[j].GetNamespace()
+		}
+		return items[i].GetName() < items[j].GetName()
+	})
+	return items, nil
+}
+
+func (g *DataGathererIstio) peerAuthentication(pa *istioPeerAuthentication) IstioPeerAuthentication {
+	summary := IstioPeerAuthentication{
+		Namespace: pa.Namespace,
+		Name:      pa.Name,
+		Scope:     "namespace",
+		Mode:      "UNSET",
+	}
+	if pa.Spec.Selector != nil && len(pa.Spec.Selector.MatchLabels) > 0 {
+		summary.Scope = "workload"
+		summary.Selector = pa.Spec.Selector.MatchLabels
+	} else if pa.Namespace == g.rootNamespace {
+		summary.Scope = "mesh"
+	}
+	if pa.Spec.MTLS != nil && pa.Spec.MTLS.Mode != "" {
+		summary.Mode = pa.Spec.MTLS.Mode
+	}
+	for port, mtls := range pa.Spec.PortLevelMTLS {
+		if summary.PortModes == nil {
+			summary.PortModes = map[string]string{}
+		}
+		summary.PortModes[port] = mtls.Mode
+	}
+	return summary
+}
+
+func newIstioGateway(gw *istioGateway) IstioGateway {
+	summary := IstioGateway{Namespace: gw.Namespace, Name: gw.Name, Servers: []IstioGatewayServer{}}
+	for _, s := range gw.Spec.Servers {
+		server := IstioGatewayServer{Port: s.Port.Number, Protocol: s.Port.Protocol, Hosts: s.Hosts}
+		if s.TLS != nil {
+			server.HTTPSRedirect = s.TLS.HTTPSRedirect
+			server.MinProtocolVersion = s.TLS.MinProtocolVersion
+			// httpsRedirect alone configures an HTTP server
+			if s.TLS.Mode != "" || s.Port.Protocol != "HTTP" {
+				server.TLSMode = s.TLS.Mode
+				if server.TLSMode == "" {
+					server.TLSMode = "PASSTHROUGH"
+				}
+			}
+			server.CredentialName = s.TLS.CredentialName
+			server.CertificateSource = istioCertificateSource(server.TLSMode, s.TLS.CredentialName, s.TLS.ServerCertificate)
+		}
+		summary.Servers = append(summary.Servers, server)
+	}
+	return summary
+}
+
+func newIstioDestinationRule(dr *istioDestinationRule) IstioDestinationRule {
+	summary := IstioDestinationRule{Namespace: dr.Namespace, Name: dr.Name, Host: dr.Spec.Host, TLS: []IstioClientTLS{}}
+	policy := dr.Spec.TrafficPolicy
+	if policy == nil {
+		return summary
+	}
+	add := func(port int64, tls *istioClientTLS) {
+		if tls == nil {
+			return
+		}
+		mode := tls.Mode
+		if mode == "" {
+			mode = "DISABLE"
+		}
+		summary.TLS = append(summary.TLS, IstioClientTLS{
+			Port:              port,
+			Mode:              mode,
+			CertificateSource: istioCertificateSource(mode, tls.CredentialName, tls.ClientCertificate+tls.CACertificates),
+			CredentialName:    tls.CredentialName,
+		})
+	}
+	add(0, policy.TLS)
+	for _, settings := range policy.PortLevelSettings {
+		add(settings.Port.Number, settings.TLS)
+	}
+	return summary
+}
+
+// istioCertificateSource returns where the certificates of a TLS mode come
+// from: the Istio CA, a Secret, or files mounted in the proxy.
+func istioCertificateSource(mode, credentialName, files string) string {
+	switch {
+	case mode == "" || mode == "DISABLE" || mode == "PASSTHROUGH":
+		return ""
+	case mode == "ISTIO_MUTUAL" || mode == "AUTO_PASSTHROUGH":
+		return istioCertificateSourceIstio
+	case credentialName != "":
+		return istioCertificateSourceSecret
+	case files != "":
+		return istioCertificateSourceFile
+	}
+	return ""
+}
+
+func mtlsDisabledFinding(ref api.ResourceRef, message string) api.Finding {
+	return api.Finding{
+		RuleID:      IstioFindingMTLSDisabled,
+		Severity:    api.SeverityHigh,
+		Resource:    ref,
+		Message:     message,
+		Remediation: "Remove the exception, or use ISTIO_MUTUAL or STRICT mode if the workloads have sidecars.",
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
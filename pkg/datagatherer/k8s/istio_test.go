@@ -0,0 +1,174 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/jetstack/preflight/api"
+)
+
+const testIstioResources = `
+- apiVersion: security.istio.io/v1beta1
+  kind: PeerAuthentication
+  metadata:
+    name: default
+    namespace: istio-system
+  spec:
+    mtls:
+      mode: STRICT
+- apiVersion: security.istio.io/v1beta1
+  kind: PeerAuthentication
+  metadata:
+    name: legacy
+    namespace: shop
+  spec:
+    selector:
+      matchLabels:
+        app: legacy
+    mtls:
+      mode: STRICT
+    portLevelMtls:
+      "8080":
+        mode: DISABLE
+- apiVersion: networking.istio.io/v1beta1
+  kind: Gateway
+  metadata:
+    name: public
+    namespace: istio-ingress
+  spec:
+    servers:
+    - port:
+        number: 443
+        protocol: HTTPS
+      hosts: ["shop.example.com"]
+      tls:
+        mode: SIMPLE
+        credentialName: shop-tls
+    - port:
+        number: 80
+        protocol: HTTP
+      hosts: ["shop.example.com"]
+      tls:
+        httpsRedirect: true
+    - port:
+        number: 8080
+        protocol: HTTP
+      hosts: ["status.example.com"]
+- apiVersion: networking.istio.io/v1beta1
+  kind: DestinationRule
+  metadata:
+    name: payments
+    namespace: shop
+  spec:
+    host: payments.shop.svc.cluster.local
+    trafficPolicy:
+      tls:
+        mode: ISTIO_MUTUAL
+      portLevelSettings:
+      - port:
+          number: 9090
+        tls:
+          mode: DISABLE
+`
+
+func TestIstioGatherer_Fetch(t *testing.T) {
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal([]byte(testIstioResources), &items); err != nil {
+		t.Fatal(err)
+	}
+	cl := newIstioClient()
+	// the objects are added with their resource, as the fake client guesses
+	// gatewaies for Gateways
+	resources := map[string]schema.GroupVersionResource{
+		"Gateway":            istioGatewaysGVR,
+		"DestinationRule":    istioDestinationRulesGVR,
+		"PeerAuthentication": istioPeerAuthenticationsGVR,
+	}
+	for _, item := range items {
+		obj := &unstructured.Unstructured{Object: item}
+		if err := cl.Tracker().Create(resources[obj.GetKind()], obj, obj.GetNamespace()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := ConfigIstio{}
+	dg, err := config.newDataGathererWithClient(context.Background(), cl)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 resources, got %d", count)
+	}
+
+	expected := &Istio{
+		MeshMTLSMode: "STRICT",
+		PeerAuthentications: []IstioPeerAuthentication{
+			{Namespace: "istio-system", Name: "default", Scope: "mesh", Mode: "STRICT"},
+			{Namespace: "shop", Name: "legacy", Scope: "workload", Selector: map[string]string{"app": "legacy"}, Mode: "STRICT", PortModes: map[string]string{"8080": "DISABLE"}},
+		},
+		Gateways: []IstioGateway{
+			{Namespace: "istio-ingress", Name: "public", Servers: []IstioGatewayServer{
+				{Port: 443, Protocol: "HTTPS", Hosts: []string{"shop.example.com"}, TLSMode: "SIMPLE", CertificateSource: istioCertificateSourceSecret, CredentialName: "shop-tls"},
+				{Port: 80, Protocol: "HTTP", Hosts: []string{"shop.example.com"}, HTTPSRedirect: true},
+				{Port: 8080, Protocol: "HTTP", Hosts: []string{"status.example.com"}},
+			}},
+		},
+		DestinationRules: []IstioDestinationRule{
+			{Namespace: "shop", Name: "payments", Host: "payments.shop.svc.cluster.local", TLS: []IstioClientTLS{
+				{Mode: "ISTIO_MUTUAL", CertificateSource: istioCertificateSourceIstio},
+				{Port: 9090, Mode: "DISABLE"},
+			}},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data.(map[string]interface{})["istio"]); !equal {
+		t.Errorf("unexpected Istio configuration:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Kind+" "+finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"PeerAuthentication legacy " + IstioFindingMTLSDisabled,
+		"Gateway public " + IstioFindingGatewayPlaintext,
+		"DestinationRule payments " + IstioFindingMTLSDisabled,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestIstioGatherer_Permissive(t *testing.T) {
+	config := ConfigIstio{RootNamespace: "mesh-root"}
+	dg, err := config.newDataGathererWithClient(context.Background(), newIstioClient())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, _, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	findings := data.(map[string]interface{})["findings"].([]api.Finding)
+	if len(findings) != 1 || findings[0].RuleID != IstioFindingMTLSPermissive || findings[0].Resource.Name != "mesh-root" {
+		t.Errorf("expected the mesh to be reported as permissive, got %+v", findings)
+	}
+}
+
+func newIstioClient() *fake.FakeDynamicClient {
+	return fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		istioGatewaysGVR:            "GatewayList",
+		istioDestinationRulesGVR:    "DestinationRuleList",
+		istioPeerAuthenticationsGVR: "PeerAuthenticationList",
+	})
+}
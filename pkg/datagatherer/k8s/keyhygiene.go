@@ -0,0 +1,310 @@
+package k8s
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ConfigKeyHygiene contains the configuration for the k8s-key-hygiene data-gatherer.
+type ConfigKeyHygiene struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// MinRSAKeySize is the smallest RSA key size, in bits, that is not
+	// reported as weak. Defaults to 2048.
+	MinRSAKeySize int `yaml:"min-rsa-key-size"`
+	// MinECDSAKeySize is the smallest ECDSA curve size, in bits, that is not
+	// reported as weak. Defaults to 256.
+	MinECDSAKeySize int `yaml:"min-ecdsa-key-size"`
+}
+
+// UnmarshalYAML unmarshals the ConfigKeyHygiene.
+func (c *ConfigKeyHygiene) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath  string `yaml:"kubeconfig"`
+		MinRSAKeySize   int    `yaml:"min-rsa-key-size"`
+		MinECDSAKeySize int    `yaml:"min-ecdsa-key-size"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.MinRSAKeySize = aux.MinRSAKeySize
+	c.MinECDSAKeySize = aux.MinECDSAKeySize
+
+	return nil
+}
+
+const (
+	defaultMinRSAKeySize   = 2048
+	defaultMinECDSAKeySize = 256
+)
+
+// NewDataGatherer constructs a new instance of the k8s-key-hygiene data-gatherer.
+func (c *ConfigKeyHygiene) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigKeyHygiene) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	g := &DataGathererKeyHygiene{
+		ctx:             ctx,
+		clientset:       clientset,
+		minRSAKeySize:   c.MinRSAKeySize,
+		minECDSAKeySize: c.MinECDSAKeySize,
+	}
+	if g.minRSAKeySize == 0 {
+		g.minRSAKeySize = defaultMinRSAKeySize
+	}
+	if g.minECDSAKeySize == 0 {
+		g.minECDSAKeySize = defaultMinECDSAKeySize
+	}
+	return g, nil
+}
+
+// DataGathererKeyHygiene inspects the private keys of TLS Secrets locally.
+// Private keys never leave the agent: only the algorithm, size and a
+// fingerprint of the public key are reported, together with any findings.
+type DataGathererKeyHygiene struct {
+	ctx             context.Context
+	clientset       kubernetes.Interface
+	minRSAKeySize   int
+	minECDSAKeySize int
+}
+
+// KeyInfo describes the private key of a TLS Secret without exposing it.
+type KeyInfo struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Size      int    `json:"size,omitempty"`
+	// Fingerprint is the hex encoded SHA-256 digest of the DER encoded
+	// public key (SPKI) matching the private key.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// Rule IDs of the findings reported by the k8s-key-hygiene data-gatherer.
+const (
+	// KeyFindingInvalid is reported for a tls.key that cannot be parsed.
+	KeyFindingInvalid = "invalid-key"
+	// KeyFindingWeak is reported for keys below the configured minimum size.
+	KeyFindingWeak = "weak-key"
+	// KeyFindingMismatch is reported when tls.key does not match the public
+	// key of the leaf certificate in tls.crt.
+	KeyFindingMismatch = "key-certificate-mismatch"
+	// KeyFindingReused is reported for every Secret holding a key that is
+	// also used by a Secret in another namespace.
+	KeyFindingReused = "key-reused"
+)
+
+// Run is a no-op, Secrets are listed on every Fetch.
+func (g *DataGathererKeyHygiene) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererKeyHygiene) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererKeyHygiene) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists all TLS Secrets and checks their private keys.
+func (g *DataGathererKeyHygiene) Fetch() (interface{}, int, error) {
+	secrets, err := g.clientset.CoreV1().Secrets(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", string(corev1.SecretTypeTLS)).String(),
+	})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	keys := []*KeyInfo{}
+	findings := []api.Finding{}
+	for _, secret := range secrets.Items {
+		if secret.Type != corev1.SecretTypeTLS {
+			continue
+		}
+		info, secretFindings := g.check(&secret)
+		keys = append(keys, info)
+		findings = append(findings, secretFindings...)
+	}
+	findings = append(findings, reusedKeyFindings(keys)...)
+
+	response := map[string]interface{}{
+		"keys":     keys,
+		"findings": findings,
+	}
+
+	return response, len(keys), nil
+}
+
+// check parses the key of a single Secret. The parsed key only lives for the
+// duration of this call.
+func (g *DataGathererKeyHygiene) check(secret *corev1.Secret) (*KeyInfo, []api.Finding) {
+	info := &KeyInfo{
+		Namespace: secret.Namespace,
+		Name:      secret.Name,
+	}
+	finding := func(ruleID string, format string, args ...interface{}) []api.Finding {
+		return []api.Finding{keyFinding(ruleID, secret.Namespace, secret.Name, fmt.Sprintf(format, args...))}
+	}
+
+	key, err := parsePrivateKey(secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return info, finding(KeyFindingInvalid, "failed to parse %s: %s", corev1.TLSPrivateKeyKey, err)
+	}
+
+	public := key.(interface{ Public() crypto.PublicKey }).Public()
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return info, finding(KeyFindingInvalid, "failed to encode public key: %s", err)
+	}
+	sum := sha256.Sum256(der)
+	info.Fingerprint = hex.EncodeToString(sum[:])
+
+	var findings []api.Finding
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		info.Algorithm = "RSA"
+		info.Size = k.N.BitLen()
+		if info.Size < g.minRSAKeySize {
+			findings = append(findings, finding(KeyFindingWeak, "RSA key size %d is below the minimum of %d", info.Size, g.minRSAKeySize)...)
+		}
+	case *ecdsa.PrivateKey:
+		info.Algorithm = "ECDSA"
+		info.Size = k.Curve.Params().BitSize
+		if info.Size < g.minECDSAKeySize {
+			findings = append(findings, finding(KeyFindingWeak, "ECDSA key size %d is below the minimum of %d", info.Size, g.minECDSAKeySize)...)
+		}
+	case ed25519.PrivateKey:
+		info.Algorithm = "Ed25519"
+		info.Size = 256
+	}
+
+	if block, _ := pem.Decode(secret.Data[corev1.TLSCertKey]); block != nil {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			if matcher, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && !matcher.Equal(public) {
+				findings = append(findings, finding(KeyFindingMismatch, "%s does not match the public key of the certificate in %s", corev1.TLSPrivateKeyKey, corev1.TLSCertKey)...)
+			}
+		}
+	}
+
+	return info, findings
+}
+
+// keyFindingSeverities and keyFindingRemediations hold the severity and the
+// remediation hint of each rule.
+var (
+	keyFindingSeverities = map[string]api.Severity{
+		KeyFindingInvalid:  api.SeverityMedium,
+		KeyFindingWeak:     api.SeverityHigh,
+		KeyFindingMismatch: api.SeverityHigh,
+		KeyFindingReused:   api.SeverityMedium,
+	}
+	keyFindingRemediations = map[string]string{
+		KeyFindingInvalid:  "Replace tls.key with a PEM encoded PKCS#1, PKCS#8 or SEC 1 private key.",
+		KeyFindingWeak:     "Issue a new certificate with a larger key.",
+		KeyFindingMismatch: "Issue a new certificate for the key, or replace tls.key with the key of the certificate.",
+		KeyFindingReused:   "Issue a new certificate with its own key for each namespace.",
+	}
+)
+
+func keyFinding(ruleID, namespace, name, message string) api.Finding {
+	return api.Finding{
+		RuleID:      ruleID,
+		Severity:    keyFindingSeverities[ruleID],
+		Resource:    api.ResourceRef{Kind: "Secret", Namespace: namespace, Name: name},
+		Message:     message,
+		Remediation: keyFindingRemediations[ruleID],
+	}
+}
+
+// parsePrivateKey decodes the first PEM block of data as a PKCS#1, PKCS#8 or
+// SEC 1 private key.
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported private key format %q", block.Type)
+}
+
+// reusedKeyFindings reports every Secret whose key fingerprint is shared
+// with a Secret in a different namespace.
+func reusedKeyFindings(keys []*KeyInfo) []api.Finding {
+	byFingerprint := map[string][]*KeyInfo{}
+	for _, key := range keys {
+		if key.Fingerprint == "" {
+			continue
+		}
+		byFingerprint[key.Fingerprint] = append(byFingerprint[key.Fingerprint], key)
+	}
+
+	var findings []api.Finding
+	for _, shared := range byFingerprint {
+		namespaces := map[string]bool{}
+		for _, key := range shared {
+			namespaces[key.Namespace] = true
+		}
+		if len(namespaces) < 2 {
+			continue
+		}
+		for _, key := range shared {
+			var others []string
+			for _, other := range shared {
+				if other != key {
+					others = append(others, other.Namespace+"/"+other.Name)
+				}
+			}
+			findings = append(findings, keyFinding(KeyFindingReused, key.Namespace, key.Name, fmt.Sprintf("key is also used by %s", strings.Join(others, ", "))))
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Resource.Namespace != findings[j].Resource.Namespace {
+			return findings[i].Resource.Namespace < findings[j].Resource.Namespace
+		}
+		return findings[i].Resource.Name < findings[j].Resource.Name
+	})
+
+	return findings
+}
@@ -0,0 +1,133 @@
+package k8s
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func encodeTestKey(t *testing.T, key crypto.Signer) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func encodeTestCert(t *testing.T, key crypto.Signer) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func tlsSecret(namespace, name string, cert, key []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       cert,
+			corev1.TLSPrivateKeyKey: key,
+		},
+	}
+}
+
+func TestKeyHygieneGatherer_Fetch(t *testing.T) {
+	shared, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	weak, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	tests := map[string]struct {
+		objects  []runtime.Object
+		expected []string
+	}{
+		"a valid key has no findings": {
+			objects:  []runtime.Object{tlsSecret("a", "tls", encodeTestCert(t, shared), encodeTestKey(t, shared))},
+			expected: []string{},
+		},
+		"a weak RSA key is reported": {
+			objects:  []runtime.Object{tlsSecret("a", "tls", encodeTestCert(t, weak), encodeTestKey(t, weak))},
+			expected: []string{KeyFindingWeak},
+		},
+		"a key not matching the certificate is reported": {
+			objects:  []runtime.Object{tlsSecret("a", "tls", encodeTestCert(t, other), encodeTestKey(t, shared))},
+			expected: []string{KeyFindingMismatch},
+		},
+		"a key that cannot be parsed is reported": {
+			objects:  []runtime.Object{tlsSecret("a", "tls", nil, []byte("nope"))},
+			expected: []string{KeyFindingInvalid},
+		},
+		"a key reused across namespaces is reported for each secret": {
+			objects: []runtime.Object{
+				tlsSecret("a", "tls", encodeTestCert(t, shared), encodeTestKey(t, shared)),
+				tlsSecret("b", "tls", encodeTestCert(t, shared), encodeTestKey(t, shared)),
+			},
+			expected: []string{KeyFindingReused, KeyFindingReused},
+		},
+		"non TLS secrets are ignored": {
+			objects: []runtime.Object{
+				&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "opaque"}, Type: corev1.SecretTypeOpaque},
+			},
+			expected: []string{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := ConfigKeyHygiene{}
+			dg, err := config.newDataGathererWithClient(context.Background(), fakeclientset.NewSimpleClientset(tc.objects...))
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			res, _, err := dg.Fetch()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			data := res.(map[string]interface{})
+			findings := data["findings"].([]api.Finding)
+			got := []string{}
+			for _, f := range findings {
+				got = append(got, f.RuleID)
+			}
+			if len(got) != len(tc.expected) {
+				t.Fatalf("unexpected findings: got %v, want %v", got, tc.expected)
+			}
+			for i := range got {
+				if got[i] != tc.expected[i] {
+					t.Errorf("unexpected findings: got %v, want %v", got, tc.expected)
+				}
+			}
+
+			for _, key := range data["keys"].([]*KeyInfo) {
+				if key.Fingerprint != "" && len(key.Fingerprint) != 64 {
+					t.Errorf("unexpected fingerprint %q", key.Fingerprint)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespacePattern is an entry of `include-namespaces` or
+// `exclude-namespaces`. An entry is either a namespace name, a glob pattern
+// such as `team-*`, or a regular expression between slashes such as
+// `/^team-[0-9]+$/`. A leading `!` negates the entry.
+type namespacePattern struct {
+	negate bool
+	name   string
+	glob   string
+	regex  *regexp.Regexp
+}
+
+// IsNamespacePattern returns true if an entry of `include-namespaces` or
+// `exclude-namespaces` is anything other than a plain namespace name.
+func IsNamespacePattern(entry string) bool {
+	return strings.HasPrefix(entry, "!") || strings.ContainsAny(entry, "*?[") || isRegexEntry(entry)
+}
+
+func isRegexEntry(entry string) bool {
+	return len(entry) > 1 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/")
+}
+
+func parseNamespacePattern(entry string) (namespacePattern, error) {
+	var p namespacePattern
+	if strings.HasPrefix(entry, "!") {
+		p.negate = true
+		entry = entry[1:]
+	}
+	if entry == "" {
+		return p, fmt.Errorf("namespace pattern cannot be empty")
+	}
+
+	switch {
+	case isRegexEntry(entry):
+		regex, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return p, fmt.Errorf("invalid namespace regular expression %q: %s", entry, err)
+		}
+		p.regex = regex
+	case strings.ContainsAny(entry, "*?["):
+		if _, err := path.Match(entry, ""); err != nil {
+			return p, fmt.Errorf("invalid namespace glob pattern %q: %s", entry, err)
+		}
+		p.glob = entry
+	default:
+		p.name = entry
+	}
+
+	return p, nil
+}
+
+func (p namespacePattern) matches(namespace string) bool {
+	switch {
+	case p.regex != nil:
+		return p.regex.MatchString(namespace)
+	case p.glob != "":
+		matched, _ := path.Match(p.glob, namespace)
+		return matched
+	default:
+		return p.name == namespace
+	}
+}
+
+// hasNamespacePatterns returns true if any of the entries is a pattern.
+func hasNamespacePatterns(entries []string) bool {
+	for _, entry := range entries {
+		if IsNamespacePattern(entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceFilter decides which namespaces are gathered from the include and
+// exclude lists.
+type namespaceFilter struct {
+	include []namespacePattern
+	exclude []namespacePattern
+}
+
+func newNamespaceFilter(include, exclude []string) (*namespaceFilter, error) {
+	f := &namespaceFilter{}
+	for _, entry := range include {
+		if entry == "" {
+			continue
+		}
+		p, err := parseNamespacePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, entry := range exclude {
+		if entry == "" {
+			continue
+		}
+		p, err := parseNamespacePattern(entry)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+// isIncluded returns true if the namespace matches one of the included
+// entries, or if there are none, and it isn't excluded. Negated entries are
+// exceptions: in the include list they exclude matching namespaces and in
+// the exclude list they keep them.
+func (f *namespaceFilter) isIncluded(namespace string) bool {
+	if len(f.include) > 0 && !matchesPatterns(f.include, namespace) {
+		return false
+	}
+	if len(f.exclude) > 0 && matchesPatterns(f.exclude, namespace) {
+		return false
+	}
+	return true
+}
+
+// matchesPatterns returns true if the namespace matches any of the
+// non-negated patterns, or there are none, and none of the negated ones.
+func matchesPatterns(patterns []namespacePattern, namespace string) bool {
+	positive, matched := false, false
+	for _, p := range patterns {
+		if p.negate {
+			if p.matches(namespace) {
+				return false
+			}
+			continue
+		}
+		positive = true
+		if p.matches(namespace) {
+			matched = true
+		}
+	}
+	return matched || !positive
+}
+
+// matchesAny returns true if the namespace matches any of the patterns,
+// ignoring whether they are negated.
+func matchesAny(patterns []namespacePattern, namespace string) bool {
+	for _, p := range patterns {
+		if p.matches(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// listSelectedNamespaces returns the names of the namespaces matching the
+// label selector, using whichever of the clients is set.
+func listSelectedNamespaces(ctx context.Context, cl dynamic.Interface, clientset kubernetes.Interface, selector string) (map[string]bool, error) {
+	options := metav1.ListOptions{LabelSelector: selector}
+	namespaces := map[string]bool{}
+
+	if clientset != nil {
+		list, err := clientset.CoreV1().Namespaces().List(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces matching %q: %w", selector, err)
+		}
+		for _, namespace := range list.Items {
+			namespaces[namespace.Name] = true
+		}
+		return namespaces, nil
+	}
+
+	list, err := cl.Resource(corev1.SchemeGroupVersion.WithResource("namespaces")).List(ctx, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching %q: %w", selector, err)
+	}
+	for _, namespace := range list.Items {
+		namespaces[namespace.GetName()] = true
+	}
+	return namespaces, nil
+}
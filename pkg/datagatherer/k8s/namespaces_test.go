@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"testing"
+)
+
+func TestNamespaceFilter(t *testing.T) {
+	tests := map[string]struct {
+		include  []string
+		exclude  []string
+		included []string
+		excluded []string
+	}{
+		"no filters": {
+			included: []string{"default", "kube-system", ""},
+		},
+		"plain names": {
+			include:  []string{"default", "team-a"},
+			included: []string{"default", "team-a"},
+			excluded: []string{"team-b", ""},
+		},
+		"glob": {
+			include:  []string{"team-*"},
+			included: []string{"team-a", "team-b"},
+			excluded: []string{"default", "my-team-a"},
+		},
+		"regex": {
+			include:  []string{"/^team-[0-9]+$/"},
+			included: []string{"team-1", "team-22"},
+			excluded: []string{"team-a", "default"},
+		},
+		"negated include": {
+			include:  []string{"!kube-*"},
+			included: []string{"default", "team-a", ""},
+			excluded: []string{"kube-system", "kube-public"},
+		},
+		"include with exceptions": {
+			include:  []string{"team-*", "!team-legacy"},
+			included: []string{"team-a"},
+			excluded: []string{"team-legacy", "default"},
+		},
+		"exclude with exceptions": {
+			exclude:  []string{"kube-*", "!kube-public"},
+			included: []string{"default", "kube-public"},
+			excluded: []string{"kube-system"},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			f, err := newNamespaceFilter(tc.include, tc.exclude)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for _, ns := range tc.included {
+				if !f.isIncluded(ns) {
+					t.Errorf("expected namespace %q to be included", ns)
+				}
+			}
+			for _, ns := range tc.excluded {
+				if f.isIncluded(ns) {
+					t.Errorf("expected namespace %q to be excluded", ns)
+				}
+			}
+		})
+	}
+}
+
+func TestNamespaceFilterInvalid(t *testing.T) {
+	for _, entry := range []string{"team-[", "/team-(/", "!"} {
+		if _, err := newNamespaceFilter([]string{entry}, nil); err == nil {
+			t.Errorf("expected an error for %q", entry)
+		}
+	}
+}
+
+func TestGenerateFieldSelectorPatterns(t *testing.T) {
+	fieldSelector := generateFieldSelector([]string{"kube-system", "kube-public", "team-*", "!kube-public"})
+	if expected := "metadata.namespace!=kube-system,"; fieldSelector != expected {
+		t.Errorf("unexpected field selector: got=%q want=%q", fieldSelector, expected)
+	}
+}
@@ -0,0 +1,395 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ConfigPodSecurity contains the configuration for the k8s-pod-security
+// data-gatherer.
+type ConfigPodSecurity struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+}
+
+// UnmarshalYAML unmarshals the ConfigPodSecurity.
+func (c *ConfigPodSecurity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath    string   `yaml:"kubeconfig"`
+		ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+		IncludeNamespaces []string `yaml:"include-namespaces"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigPodSecurity) Validate() error {
+	_, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	return err
+}
+
+// NewDataGatherer constructs a new instance of the k8s-pod-security data-gatherer.
+func (c *ConfigPodSecurity) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigPodSecurity) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	namespaceFilter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	return &DataGathererPodSecurity{
+		ctx:             ctx,
+		clientset:       clientset,
+		namespaceFilter: namespaceFilter,
+	}, nil
+}
+
+// DataGathererPodSecurity evaluates the pod templates of the workloads, and
+// the pods that aren't managed by a controller, against a subset of the Pod
+// Security Standards. Only the violations are reported, not the specs.
+type DataGathererPodSecurity struct {
+	ctx             context.Context
+	clientset       kubernetes.Interface
+	namespaceFilter *namespaceFilter
+}
+
+// WorkloadSecurity is the posture of a workload with violations.
+type WorkloadSecurity struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Violations are the rule IDs of the findings of the workload.
+	Violations []string `json:"violations"`
+	// Baseline is false if one of the checks of the baseline Pod Security
+	// Standard is violated.
+	Baseline bool `json:"baseline"`
+}
+
+// PodSecuritySummary counts the evaluated workloads.
+type PodSecuritySummary struct {
+	Workloads int `json:"workloads"`
+	// Compliant is the number of workloads without violations.
+	Compliant int `json:"compliant"`
+	// Baseline is the number of workloads that pass the checks of the
+	// baseline Pod Security Standard.
+	Baseline int `json:"baseline"`
+}
+
+// Rule IDs of the findings reported by the k8s-pod-security data-gatherer.
+const (
+	// PodSecurityFindingPrivileged is reported for workloads with
+	// privileged containers.
+	PodSecurityFindingPrivileged = "privileged-container"
+	// PodSecurityFindingHostPath is reported for workloads mounting
+	// hostPath volumes.
+	PodSecurityFindingHostPath = "host-path-volume"
+	// PodSecurityFindingHostNamespaces is reported for workloads sharing the
+	// network, PID or IPC namespace of the node.
+	PodSecurityFindingHostNamespaces = "host-namespaces"
+	// PodSecurityFindingRunAsRoot is reported for workloads with containers
+	// that may run as root.
+	PodSecurityFindingRunAsRoot = "run-as-root"
+	// PodSecurityFindingMissingLimits is reported for workloads with
+	// containers without CPU or memory limits.
+	PodSecurityFindingMissingLimits = "missing-resource-limits"
+)
+
+// baselineRules are the rules that are part of the baseline Pod Security
+// Standard.
+var baselineRules = map[string]bool{
+	PodSecurityFindingPrivileged:     true,
+	PodSecurityFindingHostPath:       true,
+	PodSecurityFindingHostNamespaces: true,
+}
+
+// Run is a no-op, the workloads are listed on every Fetch.
+func (g *DataGathererPodSecurity) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererPodSecurity) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererPodSecurity) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// podSecurityWorkload is the pod template of a workload.
+type podSecurityWorkload struct {
+	kind      string
+	namespace string
+	name      string
+	spec      *corev1.PodSpec
+}
+
+// Fetch lists the workloads and evaluates their pod templates.
+func (g *DataGathererPodSecurity) Fetch() (interface{}, int, error) {
+	workloads, err := g.listWorkloads()
+	if err != nil {
+		return nil, -1, err
+	}
+
+	results := []*WorkloadSecurity{}
+	findings := []api.Finding{}
+	summary := PodSecuritySummary{}
+	for _, workload := range workloads {
+		if !g.namespaceFilter.isIncluded(workload.namespace) {
+			continue
+		}
+		summary.Workloads++
+		workloadFindings := evaluatePodSpec(workload)
+		if len(workloadFindings) == 0 {
+			summary.Compliant++
+			summary.Baseline++
+			continue
+		}
+		result := &WorkloadSecurity{
+			Kind:      workload.kind,
+			Namespace: workload.namespace,
+			Name:      workload.name,
+			Baseline:  true,
+		}
+		for _, finding := range workloadFindings {
+			result.Violations = append(result.Violations, finding.RuleID)
+			if baselineRules[finding.RuleID] {
+				result.Baseline = false
+			}
+		}
+		if result.Baseline {
+			summary.Baseline++
+		}
+		results = append(results, result)
+		findings = append(findings, workloadFindings...)
+	}
+
+	response := map[string]interface{}{
+		"workloads": results,
+		"summary":   summary,
+		"findings":  findings,
+	}
+
+	return response, len(results), nil
+}
+
+// listWorkloads returns the pod templates of the Deployments, StatefulSets,
+// DaemonSets, CronJobs and Jobs, and the specs of the Pods that aren't
+// managed by a controller, sorted by namespace, kind and name. The Jobs of
+// CronJobs and the pods of controllers are skipped, as their templates are
+// already evaluated.
+func (g *DataGathererPodSecurity) listWorkloads() ([]podSecurityWorkload, error) {
+	var workloads []podSecurityWorkload
+	add := func(kind string, meta metav1.ObjectMeta, spec *corev1.PodSpec) {
+		workloads = append(workloads, podSecurityWorkload{kind: kind, namespace: meta.Namespace, name: meta.Name, spec: spec})
+	}
+
+	deployments, err := g.clientset.AppsV1().Deployments(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
+	}
+	for i := range deployments.Items {
+		add("Deployment", deployments.Items[i].ObjectMeta, &deployments.Items[i].Spec.Template.Spec)
+	}
+
+	statefulSets, err := g.clientset.AppsV1().StatefulSets(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statefulsets: %w", err)
+	}
+	for i := range statefulSets.Items {
+		add("StatefulSet", statefulSets.Items[i].ObjectMeta, &statefulSets.Items[i].Spec.Template.Spec)
+	}
+
+	daemonSets, err := g.clientset.AppsV1().DaemonSets(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list daemonsets: %w", err)
+	}
+	for i := range daemonSets.Items {
+		add("DaemonSet", daemonSets.Items[i].ObjectMeta, &daemonSets.Items[i].Spec.Template.Spec)
+	}
+
+	cronJobs, err := g.clientset.BatchV1().CronJobs(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cronjobs: %w", err)
+	}
+	for i := range cronJobs.Items {
+		add("CronJob", cronJobs.Items[i].ObjectMeta, &cronJobs.Items[i].Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	jobs, err := g.clientset.BatchV1().Jobs(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	for i := range jobs.Items {
+		if metav1.GetControllerOf(&jobs.Items[i]) == nil {
+			add("Job", jobs.Items[i].ObjectMeta, &jobs.Items[i].Spec.Template.Spec)
+		}
+	}
+
+	pods, err := g.clientset.CoreV1().Pods(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for i := range pods.Items {
+		if metav1.GetControllerOf(&pods.Items[i]) == nil {
+			add("Pod", pods.Items[i].ObjectMeta, &pods.Items[i].Spec)
+		}
+	}
+
+	sort.SliceStable(workloads, func(i, j int) bool {
+		if workloads[i].namespace != workloads[j].namespace {
+			return workloads[i].namespace < workloads[j].namespace
+		}
+		if workloads[i].kind != workloads[j].kind {
+			return workloads[i].kind < workloads[j].kind
+		}
+		return workloads[i].name < workloads[j].name
+	})
+	return workloads, nil
+}
+
+// evaluatePodSpec returns the findings of the pod template of the workload,
+// at most one per rule.
+func evaluatePodSpec(workload podSecurityWorkload) []api.Finding {
+	spec := workload.spec
+	var findings []api.Finding
+	finding := func(ruleID, format string, args ...interface{}) {
+		findings = append(findings, api.Finding{
+			RuleID:      ruleID,
+			Severity:    podSecurityFindingSeverities[ruleID],
+			Resource:    api.ResourceRef{Kind: workload.kind, Namespace: workload.namespace, Name: workload.name},
+			Message:     fmt.Sprintf(format, args...),
+			Remediation: podSecurityFindingRemediations[ruleID],
+		})
+	}
+
+	containers := make([]corev1.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	containers = append(containers, spec.InitContainers...)
+	containers = append(containers, spec.Containers...)
+
+	var privileged, runAsRoot, missingLimits []string
+	for _, container := range containers {
+		sc := container.SecurityContext
+		if sc != nil && sc.Privileged != nil && *sc.Privileged {
+			privileged = append(privileged, container.Name)
+		}
+		if mayRunAsRoot(spec.SecurityContext, sc) {
+			runAsRoot = append(runAsRoot, container.Name)
+		}
+		if container.Resources.Limits.Cpu().IsZero() || container.Resources.Limits.Memory().IsZero() {
+			missingLimits = append(missingLimits, container.Name)
+		}
+	}
+
+	var hostPaths []string
+	for _, volume := range spec.Volumes {
+		if volume.HostPath != nil {
+			hostPaths = append(hostPaths, volume.HostPath.Path)
+		}
+	}
+
+	var hostNamespaces []string
+	if spec.HostNetwork {
+		hostNamespaces = append(hostNamespaces, "network")
+	}
+	if spec.HostPID {
+		hostNamespaces = append(hostNamespaces, "PID")
+	}
+	if spec.HostIPC {
+		hostNamespaces = append(hostNamespaces, "IPC")
+	}
+
+	if len(privileged) > 0 {
+		finding(PodSecurityFindingPrivileged, "privileged containers: %s", strings.Join(privileged, ", "))
+	}
+	if len(hostPaths) > 0 {
+		finding(PodSecurityFindingHostPath, "hostPath volumes: %s", strings.Join(hostPaths, ", "))
+	}
+	if len(hostNamespaces) > 0 {
+		finding(PodSecurityFindingHostNamespaces, "shares the namespaces of the node: %s", strings.Join(hostNamespaces, ", "))
+	}
+	if len(runAsRoot) > 0 {
+		finding(PodSecurityFindingRunAsRoot, "containers that may run as root: %s", strings.Join(runAsRoot, ", "))
+	}
+	if len(missingLimits) > 0 {
+		finding(PodSecurityFindingMissingLimits, "containers without CPU or memory limits: %s", strings.Join(missingLimits, ", "))
+	}
+
+	return findings
+}
+
+// mayRunAsRoot returns true unless the container is required to run as a
+// non-root user, by runAsNonRoot or a non-zero runAsUser. The settings of
+// the container take precedence over those of the pod.
+func mayRunAsRoot(pod *corev1.PodSecurityContext, container *corev1.SecurityContext) bool {
+	var runAsNonRoot *bool
+	var runAsUser *int64
+	if pod != nil {
+		runAsNonRoot, runAsUser = pod.RunAsNonRoot, pod.RunAsUser
+	}
+	if container != nil {
+		if container.RunAsNonRoot != nil {
+			runAsNonRoot = container.RunAsNonRoot
+		}
+		if container.RunAsUser != nil {
+			runAsUser = container.RunAsUser
+		}
+	}
+	if runAsUser != nil {
+		return *runAsUser == 0
+	}
+	return runAsNonRoot == nil || !*runAsNonRoot
+}
+
+// podSecurityFindingSeverities and podSecurityFindingRemediations hold the
+// severity and the remediation hint of each rule.
+var (
+	podSecurityFindingSeverities = map[string]api.Severity{
+		PodSecurityFindingPrivileged:     api.SeverityHigh,
+		PodSecurityFindingHostPath:       api.SeverityHigh,
+		PodSecurityFindingHostNamespaces: api.SeverityHigh,
+		PodSecurityFindingRunAsRoot:      api.SeverityMedium,
+		PodSecurityFindingMissingLimits:  api.SeverityLow,
+	}
+	podSecurityFindingRemediations = map[string]string{
+		PodSecurityFindingPrivileged:     "Remove securityContext.privileged, and add only the capabilities the container needs.",
+		PodSecurityFindingHostPath:       "Replace the hostPath volumes with persistent volumes, ConfigMaps or Secrets.",
+		PodSecurityFindingHostNamespaces: "Remove hostNetwork, hostPID and hostIPC from the pod spec.",
+		PodSecurityFindingRunAsRoot:      "Set securityContext.runAsNonRoot to true, and runAsUser to a non-zero user if the image runs as root.",
+		PodSecurityFindingMissingLimits:  "Set resources.limits.cpu and resources.limits.memory on all the containers.",
+	}
+)
@@ -0,0 +1,153 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// hardenedContainer returns a container that passes all the checks.
+func hardenedContainer(name string) corev1.Container {
+	return corev1.Container{
+		Name:            name,
+		SecurityContext: &corev1.SecurityContext{RunAsNonRoot: pointer.Bool(true)},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		},
+	}
+}
+
+func TestPodSecurityGatherer_Fetch(t *testing.T) {
+	agent := hardenedContainer("agent")
+	agent.SecurityContext.Privileged = pointer.Bool(true)
+	sidecar := hardenedContainer("sidecar")
+	sidecar.Resources = corev1.ResourceRequirements{}
+
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{hardenedContainer("api")},
+			}}},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				// the user of the container overrides runAsNonRoot of the pod
+				SecurityContext: &corev1.PodSecurityContext{RunAsNonRoot: pointer.Bool(true)},
+				Containers: []corev1.Container{
+					{
+						Name:            "web",
+						SecurityContext: &corev1.SecurityContext{RunAsUser: pointer.Int64(0)},
+						Resources:       hardenedContainer("web").Resources,
+					},
+					sidecar,
+				},
+			}}},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "monitoring", Name: "node-agent"},
+			Spec: appsv1.DaemonSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				HostNetwork: true,
+				HostPID:     true,
+				Containers:  []corev1.Container{agent},
+				Volumes: []corev1.Volume{
+					{Name: "proc", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/proc"}}},
+				},
+			}}},
+		},
+		&batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "report"},
+			Spec: batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{hardenedContainer("report")},
+			}}}}},
+		},
+		// the Job of the CronJob and the Pod of the Deployment are skipped
+		&batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "report-1", OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "batch/v1", Kind: "CronJob", Name: "report", Controller: pointer.Bool(true)},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "api-1-abcde", OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-1", Controller: pointer.Bool(true)},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "debug"},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "shell"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "etcd"},
+			Spec:       corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{{Name: "etcd"}}},
+		},
+	)
+
+	config := ConfigPodSecurity{ExcludeNamespaces: []string{"kube-system"}}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 workloads with violations, got %d", count)
+	}
+	result := data.(map[string]interface{})
+
+	expectedWorkloads := []*WorkloadSecurity{
+		{
+			Kind: "DaemonSet", Namespace: "monitoring", Name: "node-agent",
+			Violations: []string{PodSecurityFindingPrivileged, PodSecurityFindingHostPath, PodSecurityFindingHostNamespaces},
+		},
+		{
+			Kind: "Deployment", Namespace: "shop", Name: "web",
+			Violations: []string{PodSecurityFindingRunAsRoot, PodSecurityFindingMissingLimits},
+			Baseline:   true,
+		},
+		{
+			Kind: "Pod", Namespace: "shop", Name: "debug",
+			Violations: []string{PodSecurityFindingRunAsRoot, PodSecurityFindingMissingLimits},
+			Baseline:   true,
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedWorkloads, result["workloads"]); !equal {
+		t.Errorf("unexpected workloads:\n%s", diff)
+	}
+
+	expectedSummary := PodSecuritySummary{Workloads: 5, Compliant: 2, Baseline: 4}
+	if diff, equal := messagediff.PrettyDiff(expectedSummary, result["summary"]); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+
+	findings := result["findings"].([]api.Finding)
+	expectedMessages := map[string]string{
+		"node-agent " + PodSecurityFindingHostNamespaces: "shares the namespaces of the node: network, PID",
+		"node-agent " + PodSecurityFindingHostPath:       "hostPath volumes: /proc",
+		"web " + PodSecurityFindingRunAsRoot:             "containers that may run as root: web",
+		"web " + PodSecurityFindingMissingLimits:         "containers without CPU or memory limits: sidecar",
+	}
+	for _, finding := range findings {
+		if expected, ok := expectedMessages[finding.Resource.Name+" "+finding.RuleID]; ok && finding.Message != expected {
+			t.Errorf("unexpected message of %s %s: %q", finding.Resource.Name, finding.RuleID, finding.Message)
+		}
+	}
+	if len(findings) != 7 {
+		t.Errorf("expected 7 findings, got %d", len(findings))
+	}
+}
@@ -0,0 +1,99 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// RateLimit configures the client-side rate limiting of requests to the
+// Kubernetes API.
+type RateLimit struct {
+	// QPS is the sustained number of requests per second.
+	QPS float32 `yaml:"qps"`
+	// Burst is the number of requests that can be made above QPS for short
+	// periods. Defaults to QPS, rounded up.
+	Burst int `yaml:"burst"`
+}
+
+// Validate checks that the rate limit can be enforced.
+func (r RateLimit) Validate() error {
+	if r.QPS <= 0 {
+		return fmt.Errorf("rate-limit.qps must be positive")
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("rate-limit.burst must not be negative")
+	}
+	return nil
+}
+
+func (r RateLimit) newRateLimiter() flowcontrol.RateLimiter {
+	burst := r.Burst
+	if burst == 0 {
+		burst = int(r.QPS)
+		if float32(burst) < r.QPS {
+			burst++
+		}
+	}
+	return flowcontrol.NewTokenBucketRateLimiter(r.QPS, burst)
+}
+
+var (
+	sharedRateLimiterMu sync.Mutex
+	// sharedRateLimiter is used by all the clients that don't have a rate
+	// limit of their own. If nil, each client uses the client-go defaults.
+	sharedRateLimiter flowcontrol.RateLimiter
+)
+
+// SetSharedRateLimit configures a single rate limiter shared by all the
+// clients created from then on, so that the data gatherers together can't
+// exceed the rate limit.
+func SetSharedRateLimit(r RateLimit) {
+	sharedRateLimiterMu.Lock()
+	defer sharedRateLimiterMu.Unlock()
+	sharedRateLimiter = r.newRateLimiter()
+}
+
+type rateLimiterKey struct{}
+
+// dataGathererRateLimit identifies the rate limiter of a data gatherer.
+type dataGathererRateLimit struct {
+	name      string
+	rateLimit RateLimit
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	// rateLimiters are the rate limiters of the data gatherers with a rate
+	// limit of their own, so that a data gatherer created again with the
+	// same rate limit, e.g. when the config is pushed, keeps its rate limiter
+	// and, with it, its connection.
+	rateLimiters = map[dataGathererRateLimit]flowcontrol.RateLimiter{}
+)
+
+// WithRateLimit returns a context in which the clients created by the named
+// data gatherer share a rate limiter of their own, rather than the shared
+// one.
+func WithRateLimit(ctx context.Context, name string, r RateLimit) context.Context {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+	key := dataGathererRateLimit{name: name, rateLimit: r}
+	limiter, ok := rateLimiters[key]
+	if !ok {
+		limiter = r.newRateLimiter()
+		rateLimiters[key] = limiter
+	}
+	return context.WithValue(ctx, rateLimiterKey{}, limiter)
+}
+
+// rateLimiter returns the rate limiter set in the context, or the shared one.
+func rateLimiter(ctx context.Context) flowcontrol.RateLimiter {
+	if limiter, ok := ctx.Value(rateLimiterKey{}).(flowcontrol.RateLimiter); ok {
+		return limiter
+	}
+	sharedRateLimiterMu.Lock()
+	defer sharedRateLimiterMu.Unlock()
+	return sharedRateLimiter
+}
@@ -0,0 +1,67 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+func TestLoadRESTConfigRateLimit(t *testing.T) {
+	path := writeConfigToFile(t, createValidTestConfig())
+	defer func() { sharedRateLimiter = nil }()
+	defer func() { rateLimiters = map[dataGathererRateLimit]flowcontrol.RateLimiter{} }()
+
+	// client-go defaults are used unless a rate limit is set
+	cfg, err := loadRESTConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.RateLimiter != nil {
+		t.Errorf("expected no rate limiter, got %v", cfg.RateLimiter)
+	}
+
+	SetSharedRateLimit(RateLimit{QPS: 20, Burst: 40})
+	cfg, err = loadRESTConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cfg.RateLimiter != sharedRateLimiter {
+		t.Errorf("expected the shared rate limiter")
+	}
+	if qps := cfg.RateLimiter.QPS(); qps != 20 {
+		t.Errorf("unexpected QPS: got=%v want=20", qps)
+	}
+
+	// the rate limiter of the context overrides the shared one and is shared
+	// by all the clients created with that context
+	ctx := WithRateLimit(context.Background(), "pods", RateLimit{QPS: 1.5})
+	first, err := loadRESTConfig(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := loadRESTConfig(ctx, path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if first.RateLimiter == sharedRateLimiter {
+		t.Errorf("expected the rate limiter of the context")
+	}
+	if first.RateLimiter != second.RateLimiter {
+		t.Errorf("expected clients created with the same context to share a rate limiter")
+	}
+	if qps := first.RateLimiter.QPS(); qps != 1.5 {
+		t.Errorf("unexpected QPS: got=%v want=1.5", qps)
+	}
+}
+
+func TestRateLimitValidate(t *testing.T) {
+	for _, r := range []RateLimit{{QPS: 0}, {QPS: -1}, {QPS: 1, Burst: -1}} {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected an error for %+v", r)
+		}
+	}
+	if err := (RateLimit{QPS: 5}).Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,331 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ConfigRBAC contains the configuration for the k8s-rbac data-gatherer.
+type ConfigRBAC struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// IncludeSystemRoles includes the built-in `system:` roles and bindings
+	// in the snapshot. They are skipped by default as they are managed by
+	// Kubernetes and add a lot of noise to the digest.
+	IncludeSystemRoles bool `yaml:"include-system-roles"`
+}
+
+// UnmarshalYAML unmarshals the ConfigRBAC.
+func (c *ConfigRBAC) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath     string `yaml:"kubeconfig"`
+		IncludeSystemRoles bool   `yaml:"include-system-roles"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.IncludeSystemRoles = aux.IncludeSystemRoles
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-rbac data-gatherer.
+func (c *ConfigRBAC) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigRBAC) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	return &DataGathererRBAC{
+		ctx:                ctx,
+		clientset:          clientset,
+		includeSystemRoles: c.IncludeSystemRoles,
+	}, nil
+}
+
+// DataGathererRBAC collects the RBAC configuration of a cluster and reduces
+// it to a digest of which subjects can perform which verbs on which
+// resources. Roles and bindings are listed on every Fetch rather than being
+// watched, as the digest has to be recomputed as a whole anyway.
+type DataGathererRBAC struct {
+	ctx       context.Context
+	clientset kubernetes.Interface
+	// includeSystemRoles disables skipping of `system:` roles and bindings.
+	includeSystemRoles bool
+}
+
+// RBACSubject is the digest of everything a single subject has been granted.
+type RBACSubject struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Verbs maps each verb to the sorted list of resources it is granted on.
+	// Resources are formatted as `resource.group`, prefixed with
+	// `namespace/` when the grant comes from a RoleBinding.
+	Verbs map[string][]string `json:"verbs"`
+}
+
+// Rule IDs of the findings reported by the k8s-rbac data-gatherer.
+const (
+	// RBACFindingWildcard is reported for a role with a rule using `*` for
+	// verbs, resources or API groups.
+	RBACFindingWildcard = "wildcard-grant"
+	// RBACFindingClusterAdmin is reported for a binding to the cluster-admin
+	// ClusterRole.
+	RBACFindingClusterAdmin = "cluster-admin-binding"
+)
+
+const clusterAdminRole = "cluster-admin"
+
+func rbacFinding(ruleID, kind, namespace, name, message string) api.Finding {
+	f := api.Finding{
+		RuleID:   ruleID,
+		Resource: api.ResourceRef{Kind: kind, Namespace: namespace, Name: name},
+		Message:  message,
+	}
+	switch ruleID {
+	case RBACFindingWildcard:
+		f.Severity = api.SeverityMedium
+		f.Remediation = "List the verbs, resources and API groups the role needs instead of using `*`."
+	case RBACFindingClusterAdmin:
+		f.Severity = api.SeverityHigh
+		f.Remediation = "Bind the subjects to a role granting only the permissions they need."
+	}
+	return f
+}
+
+// Run is a no-op, the RBAC objects are listed on every Fetch.
+func (g *DataGathererRBAC) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererRBAC) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererRBAC) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists all Roles, ClusterRoles, RoleBindings and ClusterRoleBindings
+// and returns the per-subject digest along with any findings. The count is
+// the number of subjects in the digest.
+func (g *DataGathererRBAC) Fetch() (interface{}, int, error) {
+	rbacClient := g.clientset.RbacV1()
+
+	roles, err := rbacClient.Roles(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list roles: %w", err)
+	}
+	clusterRoles, err := rbacClient.ClusterRoles().List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list clusterroles: %w", err)
+	}
+	roleBindings, err := rbacClient.RoleBindings(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list rolebindings: %w", err)
+	}
+	clusterRoleBindings, err := rbacClient.ClusterRoleBindings().List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list clusterrolebindings: %w", err)
+	}
+
+	subjects, findings := g.digest(roles.Items, clusterRoles.Items, roleBindings.Items, clusterRoleBindings.Items)
+
+	response := map[string]interface{}{
+		"subjects": subjects,
+		"findings": findings,
+	}
+
+	return response, len(subjects), nil
+}
+
+// digest resolves every binding to the rules of the role it references and
+// accumulates the granted verbs per subject.
+func (g *DataGathererRBAC) digest(roles []rbacv1.Role, clusterRoles []rbacv1.ClusterRole, roleBindings []rbacv1.RoleBinding, clusterRoleBindings []rbacv1.ClusterRoleBinding) ([]*RBACSubject, []api.Finding) {
+	findings := []api.Finding{}
+
+	roleRules := map[string][]rbacv1.PolicyRule{}
+	for _, role := range roles {
+		if g.skip(role.Name) {
+			continue
+		}
+		roleRules[role.Namespace+"/"+role.Name] = role.Rules
+		if hasWildcardRule(role.Rules) {
+			findings = append(findings, rbacFinding(RBACFindingWildcard, "Role", role.Namespace, role.Name, "role grants wildcard verbs, resources or API groups"))
+		}
+	}
+
+	clusterRoleRules := map[string][]rbacv1.PolicyRule{}
+	for _, clusterRole := range clusterRoles {
+		// rules are still recorded for skipped roles as non-system bindings
+		// commonly reference built-in ClusterRoles like `view` or `edit`.
+		clusterRoleRules[clusterRole.Name] = clusterRole.Rules
+		if g.skip(clusterRole.Name) || clusterRole.Name == clusterAdminRole {
+			continue
+		}
+		if hasWildcardRule(clusterRole.Rules) {
+			findings = append(findings, rbacFinding(RBACFindingWildcard, "ClusterRole", "", clusterRole.Name, "clusterrole grants wildcard verbs, resources or API groups"))
+		}
+	}
+
+	subjects := map[string]*RBACSubject{}
+	grant := func(bindingSubjects []rbacv1.Subject, rules []rbacv1.PolicyRule, namespace string) {
+		for _, s := range bindingSubjects {
+			key := strings.Join([]string{s.Kind, s.Namespace, s.Name}, "/")
+			subject, ok := subjects[key]
+			if !ok {
+				subject = &RBACSubject{
+					Kind:      s.Kind,
+					Name:      s.Name,
+					Namespace: s.Namespace,
+					Verbs:     map[string][]string{},
+				}
+				subjects[key] = subject
+			}
+			for _, rule := range rules {
+				for _, verb := range rule.Verbs {
+					subject.Verbs[verb] = append(subject.Verbs[verb], ruleResources(rule, namespace)...)
+				}
+			}
+		}
+	}
+
+	for _, binding := range roleBindings {
+		if g.skip(binding.Name) {
+			continue
+		}
+		var rules []rbacv1.PolicyRule
+		if binding.RoleRef.Kind == "ClusterRole" {
+			rules = clusterRoleRules[binding.RoleRef.Name]
+			if binding.RoleRef.Name == clusterAdminRole {
+				findings = append(findings, rbacFinding(RBACFindingClusterAdmin, "RoleBinding", binding.Namespace, binding.Name,
+					fmt.Sprintf("rolebinding grants cluster-admin in namespace %q to %s", binding.Namespace, formatSubjects(binding.Subjects))))
+			}
+		} else {
+			rules = roleRules[binding.Namespace+"/"+binding.RoleRef.Name]
+		}
+		grant(binding.Subjects, rules, binding.Namespace)
+	}
+
+	for _, binding := range clusterRoleBindings {
+		if g.skip(binding.Name) {
+			continue
+		}
+		if binding.RoleRef.Name == clusterAdminRole {
+			findings = append(findings, rbacFinding(RBACFindingClusterAdmin, "ClusterRoleBinding", "", binding.Name,
+				fmt.Sprintf("clusterrolebinding grants cluster-admin to %s", formatSubjects(binding.Subjects))))
+		}
+		grant(binding.Subjects, clusterRoleRules[binding.RoleRef.Name], "")
+	}
+
+	result := make([]*RBACSubject, 0, len(subjects))
+	for _, subject := range subjects {
+		for verb, resources := range subject.Verbs {
+			subject.Verbs[verb] = uniqueSorted(resources)
+		}
+		result = append(result, subject)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return result, findings
+}
+
+// skip reports whether a role or binding is a built-in `system:` object that
+// should be left out of the snapshot.
+func (g *DataGathererRBAC) skip(name string) bool {
+	return !g.includeSystemRoles && strings.HasPrefix(name, "system:")
+}
+
+func hasWildcardRule(rules []rbacv1.PolicyRule) bool {
+	for _, rule := range rules {
+		for _, values := range [][]string{rule.Verbs, rule.Resources, rule.APIGroups} {
+			for _, v := range values {
+				if v == rbacv1.VerbAll {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ruleResources returns the resources covered by a rule in the digest
+// format. Non-resource URLs are returned as-is.
+func ruleResources(rule rbacv1.PolicyRule, namespace string) []string {
+	var resources []string
+	for _, resource := range rule.Resources {
+		groups := rule.APIGroups
+		if len(groups) == 0 {
+			groups = []string{""}
+		}
+		for _, group := range groups {
+			r := resource
+			if group != "" {
+				r = resource + "." + group
+			}
+			if namespace != "" {
+				r = namespace + "/" + r
+			}
+			resources = append(resources, r)
+		}
+	}
+	return append(resources, rule.NonResourceURLs...)
+}
+
+func formatSubjects(subjects []rbacv1.Subject) string {
+	names := make([]string, 0, len(subjects))
+	for _, s := range subjects {
+		name := s.Name
+		if s.Namespace != "" {
+			name = s.Namespace + "/" + s.Name
+		}
+		names = append(names, fmt.Sprintf("%s %q", s.Kind, name))
+	}
+	return strings.Join(names, ", ")
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		result = append(result, v)
+	}
+	sort.Strings(result)
+	return result
+}
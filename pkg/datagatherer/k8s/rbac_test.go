@@ -0,0 +1,126 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestRBACGatherer_Fetch(t *testing.T) {
+	readSecrets := []rbacv1.PolicyRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}}}
+	wildcard := []rbacv1.PolicyRule{{Verbs: []string{"*"}, APIGroups: []string{"cert-manager.io"}, Resources: []string{"certificates"}}}
+	sa := rbacv1.Subject{Kind: "ServiceAccount", Name: "agent", Namespace: "jetstack-secure"}
+
+	tests := map[string]struct {
+		config           ConfigRBAC
+		objects          []runtime.Object
+		expectedSubjects []*RBACSubject
+		expectedFindings []api.Finding
+	}{
+		"rolebinding grants are prefixed with the namespace": {
+			objects: []runtime.Object{
+				&rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "foo"}, Rules: readSecrets},
+				&rbacv1.RoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "foo"},
+					Subjects:   []rbacv1.Subject{sa},
+					RoleRef:    rbacv1.RoleRef{Kind: "Role", Name: "reader"},
+				},
+			},
+			expectedSubjects: []*RBACSubject{
+				{Kind: "ServiceAccount", Name: "agent", Namespace: "jetstack-secure", Verbs: map[string][]string{
+					"get":  {"foo/secrets"},
+					"list": {"foo/secrets"},
+				}},
+			},
+			expectedFindings: []api.Finding{},
+		},
+		"wildcards and cluster-admin are flagged": {
+			objects: []runtime.Object{
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cm-admin"}, Rules: wildcard},
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}, Rules: []rbacv1.PolicyRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}}},
+				&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "cm-admin"},
+					Subjects:   []rbacv1.Subject{sa},
+					RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "cm-admin"},
+				},
+				&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "admins"},
+					Subjects:   []rbacv1.Subject{{Kind: "Group", Name: "admins"}},
+					RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "cluster-admin"},
+				},
+			},
+			expectedSubjects: []*RBACSubject{
+				{Kind: "Group", Name: "admins", Verbs: map[string][]string{"*": {"*.*"}}},
+				{Kind: "ServiceAccount", Name: "agent", Namespace: "jetstack-secure", Verbs: map[string][]string{"*": {"certificates.cert-manager.io"}}},
+			},
+			expectedFindings: []api.Finding{
+				rbacFinding(RBACFindingWildcard, "ClusterRole", "", "cm-admin", "clusterrole grants wildcard verbs, resources or API groups"),
+				rbacFinding(RBACFindingClusterAdmin, "ClusterRoleBinding", "", "admins", `clusterrolebinding grants cluster-admin to Group "admins"`),
+			},
+		},
+		"system bindings are skipped unless enabled": {
+			objects: []runtime.Object{
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "system:reader"}, Rules: readSecrets},
+				&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "system:reader"},
+					Subjects:   []rbacv1.Subject{sa},
+					RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "system:reader"},
+				},
+			},
+			expectedSubjects: []*RBACSubject{},
+			expectedFindings: []api.Finding{},
+		},
+		"system bindings are included when enabled": {
+			config: ConfigRBAC{IncludeSystemRoles: true},
+			objects: []runtime.Object{
+				&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "system:reader"}, Rules: readSecrets},
+				&rbacv1.ClusterRoleBinding{
+					ObjectMeta: metav1.ObjectMeta{Name: "system:reader"},
+					Subjects:   []rbacv1.Subject{sa},
+					RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "system:reader"},
+				},
+			},
+			expectedSubjects: []*RBACSubject{
+				{Kind: "ServiceAccount", Name: "agent", Namespace: "jetstack-secure", Verbs: map[string][]string{
+					"get":  {"secrets"},
+					"list": {"secrets"},
+				}},
+			},
+			expectedFindings: []api.Finding{},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			clientset := fakeclientset.NewSimpleClientset(tc.objects...)
+			dg, err := tc.config.newDataGathererWithClient(context.Background(), clientset)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			res, count, err := dg.Fetch()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+
+			data := res.(map[string]interface{})
+			subjects := data["subjects"].([]*RBACSubject)
+			if diff, equal := messagediff.PrettyDiff(tc.expectedSubjects, subjects); !equal {
+				t.Errorf("unexpected subjects:\n%s", diff)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.expectedFindings, data["findings"]); !equal {
+				t.Errorf("unexpected findings:\n%s", diff)
+			}
+			if count != len(subjects) {
+				t.Errorf("wrong count: got %d, want %d", count, len(subjects))
+			}
+		})
+	}
+}
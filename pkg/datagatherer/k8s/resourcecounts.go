@@ -0,0 +1,308 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// defaultCountedResources are the resources counted by the
+// k8s-resource-counts data gatherer if none are configured.
+var defaultCountedResources = []schema.GroupVersionResource{
+	{Version: "v1", Resource: "namespaces"},
+	{Version: "v1", Resource: "pods"},
+	{Version: "v1", Resource: "services"},
+	{Version: "v1", Resource: "configmaps"},
+	{Version: "v1", Resource: "secrets"},
+	{Version: "v1", Resource: "persistentvolumeclaims"},
+	{Group: "apps", Version: "v1", Resource: "deployments"},
+	{Group: "apps", Version: "v1", Resource: "statefulsets"},
+	{Group: "apps", Version: "v1", Resource: "daemonsets"},
+	{Group: "batch", Version: "v1", Resource: "jobs"},
+	{Group: "batch", Version: "v1", Resource: "cronjobs"},
+	{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"},
+	{Group: "cert-manager.io", Version: "v1", Resource: "certificates"},
+	{Group: "cert-manager.io", Version: "v1", Resource: "issuers"},
+	{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"},
+}
+
+// resourceQuotasGVR is the resource of the ResourceQuotas.
+var resourceQuotasGVR = schema.GroupVersionResource{Version: "v1", Resource: "resourcequotas"}
+
+// ConfigResourceCounts contains the configuration for the
+// k8s-resource-counts data-gatherer.
+type ConfigResourceCounts struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// GroupVersionResources are the resources counted. Defaults to
+	// defaultCountedResources.
+	GroupVersionResources []schema.GroupVersionResource
+	// ExcludeNamespaces is a list of namespaces to exclude.
+	ExcludeNamespaces []string `yaml:"exclude-namespaces"`
+	// IncludeNamespaces is a list of namespaces to include.
+	IncludeNamespaces []string `yaml:"include-namespaces"`
+	// DisableResourceQuotas skips the summary of the ResourceQuotas.
+	DisableResourceQuotas bool `yaml:"disable-resource-quotas"`
+}
+
+// UnmarshalYAML unmarshals the ConfigResourceCounts resolving
+// GroupVersionResources.
+func (c *ConfigResourceCounts) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath        string        `yaml:"kubeconfig"`
+		ResourceTypes         resourceTypes `yaml:"resource-types"`
+		ExcludeNamespaces     []string      `yaml:"exclude-namespaces"`
+		IncludeNamespaces     []string      `yaml:"include-namespaces"`
+		DisableResourceQuotas bool          `yaml:"disable-resource-quotas"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.GroupVersionResources = nil
+	for _, rt := range aux.ResourceTypes {
+		c.GroupVersionResources = append(c.GroupVersionResources, schema.GroupVersionResource{
+			Group:    rt.Group,
+			Version:  rt.Version,
+			Resource: rt.Resource,
+		})
+	}
+	c.ExcludeNamespaces = aux.ExcludeNamespaces
+	c.IncludeNamespaces = aux.IncludeNamespaces
+	c.DisableResourceQuotas = aux.DisableResourceQuotas
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigResourceCounts) Validate() error {
+	var errors []string
+	for i, gvr := range c.GroupVersionResources {
+		if gvr.Version == "" || gvr.Resource == "" {
+			errors = append(errors, fmt.Sprintf("resource-types[%d] must have a version and a resource", i))
+		}
+	}
+	if _, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces); err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// countedResources returns the configured resources, or the default ones.
+func (c *ConfigResourceCounts) countedResources() []schema.GroupVersionResource {
+	if len(c.GroupVersionResources) > 0 {
+		return c.GroupVersionResources
+	}
+	return defaultCountedResources
+}
+
+// NewDataGatherer constructs a new instance of the k8s-resource-counts data-gatherer.
+func (c *ConfigResourceCounts) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	metadataClient, err := NewMetadataClient(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, metadataClient, clientset)
+}
+
+func (c *ConfigResourceCounts) newDataGathererWithClient(ctx context.Context, metadataClient metadata.Interface, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	namespaceFilter, err := newNamespaceFilter(c.IncludeNamespaces, c.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	return &DataGathererResourceCounts{
+		ctx:                   ctx,
+		metadataClient:        metadataClient,
+		clientset:             clientset,
+		resources:             c.countedResources(),
+		namespaceFilter:       namespaceFilter,
+		disableResourceQuotas: c.DisableResourceQuotas,
+	}, nil
+}
+
+// DataGathererResourceCounts counts the objects of each resource per
+// namespace and summarizes the ResourceQuotas, so that inventory trends can
+// be followed without uploading the contents of the objects. The objects
+// are counted with metadata-only list requests.
+type DataGathererResourceCounts struct {
+	ctx                   context.Context
+	metadataClient        metadata.Interface
+	clientset             kubernetes.Interface
+	resources             []schema.GroupVersionResource
+	namespaceFilter       *namespaceFilter
+	disableResourceQuotas bool
+}
+
+// ResourceCounts is the data of the k8s-resource-counts data gatherer.
+type ResourceCounts struct {
+	Counts []ResourceTypeCount `json:"counts"`
+	Quotas []QuotaUsage        `json:"quotas,omitempty"`
+}
+
+// ResourceTypeCount is the number of objects of a resource in a namespace.
+// The namespace of cluster scoped resources is empty.
+type ResourceTypeCount struct {
+	Group     string `json:"group,omitempty"`
+	Version   string `json:"version"`
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// QuotaUsage is the hard limits of a ResourceQuota and their usage.
+type QuotaUsage struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Hard      map[string]string `json:"hard"`
+	Used      map[string]string `json:"used"`
+}
+
+// Run is a no-op, the objects are counted on every Fetch.
+func (g *DataGathererResourceCounts) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererResourceCounts) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererResourceCounts) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch counts the objects of the resources per namespace, and lists the
+// ResourceQuotas. The resources that the cluster doesn't serve are left out.
+func (g *DataGathererResourceCounts) Fetch() (interface{}, int, error) {
+	result := &ResourceCounts{Counts: []ResourceTypeCount{}}
+	for _, gvr := range g.resources {
+		counts, err := g.countByNamespace(gvr)
+		if k8serrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to count %q: %w", gvr, err)
+		}
+		namespaces := make([]string, 0, len(counts))
+		for namespace := range counts {
+			namespaces = append(namespaces, namespace)
+		}
+		sort.Strings(namespaces)
+		for _, namespace := range namespaces {
+			result.Counts = append(result.Counts, ResourceTypeCount{
+				Group:     gvr.Group,
+				Version:   gvr.Version,
+				Resource:  gvr.Resource,
+				Namespace: namespace,
+				Count:     counts[namespace],
+			})
+		}
+	}
+
+	if !g.disableResourceQuotas {
+		quotas, err := g.quotas()
+		if err != nil {
+			return nil, -1, err
+		}
+		result.Quotas = quotas
+	}
+
+	return result, len(result.Counts), nil
+}
+
+// countByNamespace counts the objects of a resource per namespace, listing
+// their metadata only. Cluster scoped objects are counted in the empty
+// namespace, and are never filtered out.
+func (g *DataGathererResourceCounts) countByNamespace(gvr schema.GroupVersionResource) (map[string]int, error) {
+	counts := map[string]int{}
+	options := metav1.ListOptions{Limit: estimatePageSize}
+	for {
+		list, err := g.metadataClient.Resource(gvr).List(g.ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			namespace := item.GetNamespace()
+			if namespace != "" && !g.namespaceFilter.isIncluded(namespace) {
+				continue
+			}
+			counts[namespace]++
+		}
+		if list.Continue == "" {
+			return counts, nil
+		}
+		options.Continue = list.Continue
+	}
+}
+
+// quotas returns the hard limits and usage of the ResourceQuotas of the
+// included namespaces.
+func (g *DataGathererResourceCounts) quotas() ([]QuotaUsage, error) {
+	list, err := g.clientset.CoreV1().ResourceQuotas(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resourcequotas: %w", err)
+	}
+
+	var quotas []QuotaUsage
+	for _, quota := range list.Items {
+		if !g.namespaceFilter.isIncluded(quota.Namespace) {
+			continue
+		}
+		usage := QuotaUsage{
+			Namespace: quota.Namespace,
+			Name:      quota.Name,
+			Hard:      map[string]string{},
+			Used:      map[string]string{},
+		}
+		for name, quantity := range quota.Status.Hard {
+			usage.Hard[string(name)] = quantity.String()
+		}
+		// the status isn't set until the quota controller has synced it
+		if len(quota.Status.Hard) == 0 {
+			for name, quantity := range quota.Spec.Hard {
+				usage.Hard[string(name)] = quantity.String()
+			}
+		}
+		for name, quantity := range quota.Status.Used {
+			usage.Used[string(name)] = quantity.String()
+		}
+		quotas = append(quotas, usage)
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		if quotas[i].Namespace != quotas[j].Namespace {
+			return quotas[i].Namespace < quotas[j].Namespace
+		}
+		return quotas[i].Name < quotas[j].Name
+	})
+	return quotas, nil
+}
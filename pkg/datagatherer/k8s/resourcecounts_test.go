@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+)
+
+func TestResourceCountsGatherer_Fetch(t *testing.T) {
+	scheme := runtime.NewScheme()
+	metav1.AddMetaToScheme(scheme)
+	partial := func(apiVersion, kind, namespace, name string) runtime.Object {
+		return &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		}
+	}
+	metadataClient := fakemetadata.NewSimpleMetadataClient(scheme,
+		partial("v1", "Namespace", "", "shop"),
+		partial("v1", "Namespace", "", "kube-system"),
+		partial("v1", "Pod", "shop", "api-0"),
+		partial("v1", "Pod", "shop", "api-1"),
+		partial("v1", "Pod", "kube-system", "coredns"),
+		partial("v1", "Pod", "billing", "worker"),
+		partial("apps/v1", "Deployment", "shop", "api"),
+	)
+	clientset := fake.NewSimpleClientset(
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "compute"},
+			Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+				corev1.ResourcePods: resource.MustParse("10"),
+			}},
+			Status: corev1.ResourceQuotaStatus{
+				Hard: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("10")},
+				Used: corev1.ResourceList{corev1.ResourcePods: resource.MustParse("2")},
+			},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "pods"},
+			Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+				corev1.ResourcePods: resource.MustParse("100"),
+			}},
+		},
+		&corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "billing", Name: "memory"},
+			Spec: corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{
+				corev1.ResourceLimitsMemory: resource.MustParse("4Gi"),
+			}},
+		},
+	)
+
+	config := &ConfigResourceCounts{
+		GroupVersionResources: []schema.GroupVersionResource{
+			{Version: "v1", Resource: "namespaces"},
+			{Version: "v1", Resource: "pods"},
+			{Group: "apps", Version: "v1", Resource: "deployments"},
+			{Group: "apps", Version: "v1", Resource: "statefulsets"},
+		},
+		ExcludeNamespaces: []string{"billing"},
+	}
+	dg, err := config.newDataGathererWithClient(context.Background(), metadataClient, clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 counts, got %d", count)
+	}
+
+	expected := &ResourceCounts{
+		Counts: []ResourceTypeCount{
+			{Version: "v1", Resource: "namespaces", Count: 2},
+			{Version: "v1", Resource: "pods", Namespace: "kube-system", Count: 1},
+			{Version: "v1", Resource: "pods", Namespace: "shop", Count: 2},
+			{Group: "apps", Version: "v1", Resource: "deployments", Namespace: "shop", Count: 1},
+		},
+		Quotas: []QuotaUsage{
+			{
+				Namespace: "kube-system",
+				Name:      "pods",
+				Hard:      map[string]string{"pods": "100"},
+				Used:      map[string]string{},
+			},
+			{
+				Namespace: "shop",
+				Name:      "compute",
+				Hard:      map[string]string{"pods": "10"},
+				Used:      map[string]string{"pods": "2"},
+			},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+}
+
+func TestConfigResourceCounts_Validate(t *testing.T) {
+	config := &ConfigResourceCounts{
+		GroupVersionResources: []schema.GroupVersionResource{{Group: "apps", Resource: "deployments"}},
+	}
+	err := config.Validate()
+	if err == nil || err.Error() != "resource-types[0] must have a version and a resource" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
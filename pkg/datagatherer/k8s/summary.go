@@ -0,0 +1,151 @@
+package k8s
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// DynamicSummary summarizes the resources gathered by a k8s-dynamic data
+// gatherer. It is uploaded instead of the resources in the findings report
+// mode.
+type DynamicSummary struct {
+	// Resources count the resources by kind and namespace.
+	Resources []ResourceCount `json:"resources"`
+	// Certificates are the expiries of the certificates of the TLS Secrets
+	// and of the cert-manager Certificates.
+	Certificates []CertificateExpiry `json:"certificates,omitempty"`
+}
+
+// ResourceCount is the number of resources of a kind in a namespace.
+type ResourceCount struct {
+	Kind string `json:"kind"`
+	// Namespace is empty for cluster scoped resources.
+	Namespace string `json:"namespace,omitempty"`
+	Count     int    `json:"count"`
+}
+
+// CertificateExpiry is the expiry of the certificate of a TLS Secret, or of
+// a cert-manager Certificate.
+type CertificateExpiry struct {
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	NotAfter  *api.Time `json:"notAfter,omitempty"`
+	// Ready is the status of the Ready condition of a Certificate.
+	Ready string `json:"ready,omitempty"`
+	// Error is set if the certificate of a Secret can't be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summarize counts the gathered resources, and extracts the expiries of the
+// certificates of the TLS Secrets and cert-manager Certificates. Deleted
+// resources are left out.
+func (g *DataGathererDynamic) Summarize(data interface{}) (interface{}, error) {
+	list, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected data of type %T", data)
+	}
+	items, _ := list["items"].([]*api.GatheredResource)
+
+	counts := map[ResourceCount]int{}
+	summary := &DynamicSummary{
+		Resources:    []ResourceCount{},
+		Certificates: []CertificateExpiry{},
+	}
+	for _, item := range items {
+		if !item.DeletedAt.IsZero() {
+			continue
+		}
+		resource, ok := item.Resource.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		counts[ResourceCount{Kind: resource.GetKind(), Namespace: resource.GetNamespace()}]++
+		if expiry, ok := CertificateExpiryOf(resource); ok {
+			summary.Certificates = append(summary.Certificates, expiry)
+		}
+	}
+
+	for key, count := range counts {
+		key.Count = count
+		summary.Resources = append(summary.Resources, key)
+	}
+	sort.Slice(summary.Resources, func(i, j int) bool {
+		if summary.Resources[i].Kind != summary.Resources[j].Kind {
+			return summary.Resources[i].Kind < summary.Resources[j].Kind
+		}
+		return summary.Resources[i].Namespace < summary.Resources[j].Namespace
+	})
+	sort.SliceStable(summary.Certificates, func(i, j int) bool {
+		a, b := summary.Certificates[i], summary.Certificates[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	return summary, nil
+}
+
+// CertificateExpiryOf returns the expiry of the certificate of a TLS Secret or
+// a cert-manager Certificate, and false for the other resources.
+func CertificateExpiryOf(resource *unstructured.Unstructured) (CertificateExpiry, bool) {
+	expiry := CertificateExpiry{
+		Kind:      resource.GetKind(),
+		Namespace: resource.GetNamespace(),
+		Name:      resource.GetName(),
+	}
+	gvk := resource.GroupVersionKind()
+	switch {
+	case gvk.Group == "" && gvk.Kind == "Secret":
+		secretType, _, _ := unstructured.NestedString(resource.Object, "type")
+		if secretType != string(corev1.SecretTypeTLS) {
+			return expiry, false
+		}
+		encoded, _, _ := unstructured.NestedString(resource.Object, "data", corev1.TLSCertKey)
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			expiry.Error = fmt.Sprintf("failed to decode %s: %s", corev1.TLSCertKey, err)
+			return expiry, true
+		}
+		block, _ := pem.Decode(der)
+		if block == nil {
+			expiry.Error = fmt.Sprintf("no PEM encoded certificate found in %s", corev1.TLSCertKey)
+			return expiry, true
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			expiry.Error = fmt.Sprintf("failed to parse %s: %s", corev1.TLSCertKey, err)
+			return expiry, true
+		}
+		expiry.NotAfter = &api.Time{Time: cert.NotAfter.UTC()}
+		return expiry, true
+	case gvk.Group == "cert-manager.io" && gvk.Kind == "Certificate":
+		if notAfter, _, _ := unstructured.NestedString(resource.Object, "status", "notAfter"); notAfter != "" {
+			if t, err := time.Parse(time.RFC3339, notAfter); err == nil {
+				expiry.NotAfter = &api.Time{Time: t.UTC()}
+			}
+		}
+		conditions, _, _ := unstructured.NestedSlice(resource.Object, "status", "conditions")
+		for _, c := range conditions {
+			condition, _ := c.(map[string]interface{})
+			if condition["type"] == "Ready" {
+				expiry.Ready, _ = condition["status"].(string)
+			}
+		}
+		return expiry, true
+	}
+	return expiry, false
+}
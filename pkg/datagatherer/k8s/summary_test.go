@@ -0,0 +1,75 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestDynamicGatherer_Summarize(t *testing.T) {
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	resource := func(object map[string]interface{}) *api.GatheredResource {
+		return &api.GatheredResource{Resource: &unstructured.Unstructured{Object: object}}
+	}
+	deleted := resource(map[string]interface{}{
+		"apiVersion": "v1", "kind": "Secret", "type": "kubernetes.io/tls",
+		"metadata": map[string]interface{}{"namespace": "shop", "name": "old-tls"},
+	})
+	deleted.DeletedAt = api.Time{Time: notAfter}
+	data := map[string]interface{}{"items": []*api.GatheredResource{
+		resource(map[string]interface{}{
+			"apiVersion": "v1", "kind": "Secret", "type": "kubernetes.io/tls",
+			"metadata": map[string]interface{}{"namespace": "shop", "name": "shop-tls"},
+			"data": map[string]interface{}{
+				"tls.crt": base64.StdEncoding.EncodeToString(encodeTestCertForHosts(t, notAfter, "shop.example.com")),
+			},
+		}),
+		resource(map[string]interface{}{
+			"apiVersion": "v1", "kind": "Secret", "type": "kubernetes.io/tls",
+			"metadata": map[string]interface{}{"namespace": "shop", "name": "broken-tls"},
+			"data":     map[string]interface{}{"tls.crt": base64.StdEncoding.EncodeToString([]byte("not a certificate"))},
+		}),
+		resource(map[string]interface{}{
+			"apiVersion": "v1", "kind": "Secret", "type": "Opaque",
+			"metadata": map[string]interface{}{"namespace": "shop", "name": "password"},
+		}),
+		resource(map[string]interface{}{
+			"apiVersion": "cert-manager.io/v1", "kind": "Certificate",
+			"metadata": map[string]interface{}{"namespace": "shop", "name": "shop"},
+			"status": map[string]interface{}{
+				"notAfter":   "2030-01-02T03:04:05Z",
+				"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}},
+			},
+		}),
+		resource(map[string]interface{}{
+			"apiVersion": "v1", "kind": "Namespace",
+			"metadata": map[string]interface{}{"name": "shop"},
+		}),
+		deleted,
+	}}
+
+	summary, err := (&DataGathererDynamic{}).Summarize(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &DynamicSummary{
+		Resources: []ResourceCount{
+			{Kind: "Certificate", Namespace: "shop", Count: 1},
+			{Kind: "Namespace", Count: 1},
+			{Kind: "Secret", Namespace: "shop", Count: 3},
+		},
+		Certificates: []CertificateExpiry{
+			{Kind: "Certificate", Namespace: "shop", Name: "shop", NotAfter: &api.Time{Time: notAfter}, Ready: "True"},
+			{Kind: "Secret", Namespace: "shop", Name: "broken-tls", Error: "no PEM encoded certificate found in tls.crt"},
+			{Kind: "Secret", Namespace: "shop", Name: "shop-tls", NotAfter: &api.Time{Time: notAfter}},
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, summary); !equal {
+		t.Errorf("unexpected summary:\n%s", diff)
+	}
+}
@@ -0,0 +1,380 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// defaultTLSProbeTimeout is the timeout of each handshake.
+	defaultTLSProbeTimeout = 5 * time.Second
+	// tlsProbeIngressPort is the port the Ingress hosts are probed on.
+	tlsProbeIngressPort = "443"
+
+	// TLSProbeFindingDrift is reported for the endpoints serving a
+	// certificate other than the one in their declared Secret.
+	TLSProbeFindingDrift = "served-certificate-drift"
+	// TLSProbeFindingLegacyProtocol is reported for the endpoints negotiating
+	// a protocol older than TLS 1.2.
+	TLSProbeFindingLegacyProtocol = "legacy-protocol"
+	// TLSProbeFindingFailed is reported for the endpoints the handshake
+	// failed with.
+	TLSProbeFindingFailed = "probe-failed"
+)
+
+// ConfigTLSProbe contains the configuration for the k8s-tls-probe
+// data-gatherer.
+type ConfigTLSProbe struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+	// Endpoints are the endpoints to probe, e.g. the Services of the cluster.
+	Endpoints []TLSProbeEndpoint `yaml:"endpoints"`
+	// IngressHosts enables probing the TLS hosts of all the Ingresses, on
+	// port 443, and comparing the certificates they serve with their Secrets.
+	IngressHosts bool `yaml:"ingress-hosts"`
+	// Timeout is the timeout of each handshake. Defaults to 5s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// TLSProbeEndpoint is an endpoint to probe.
+type TLSProbeEndpoint struct {
+	// Address is the host and port, e.g. my-service.my-namespace.svc:443.
+	Address string `yaml:"address"`
+	// ServerName is the SNI server name. Defaults to the host of the
+	// address.
+	ServerName string `yaml:"server-name"`
+	// Secret is the namespace/name of the Secret of the certificate the
+	// endpoint is expected to serve, if any.
+	Secret string `yaml:"secret"`
+}
+
+// UnmarshalYAML unmarshals the ConfigTLSProbe.
+func (c *ConfigTLSProbe) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string             `yaml:"kubeconfig"`
+		Endpoints      []TLSProbeEndpoint `yaml:"endpoints"`
+		IngressHosts   bool               `yaml:"ingress-hosts"`
+		Timeout        time.Duration      `yaml:"timeout"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+	c.Endpoints = aux.Endpoints
+	c.IngressHosts = aux.IngressHosts
+	c.Timeout = aux.Timeout
+
+	return nil
+}
+
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *ConfigTLSProbe) Validate() error {
+	var errors []string
+	if len(c.Endpoints) == 0 && !c.IngressHosts {
+		errors = append(errors, "at least one of endpoints or ingress-hosts must be set")
+	}
+	for i, endpoint := range c.Endpoints {
+		if _, _, err := net.SplitHostPort(endpoint.Address); err != nil {
+			errors = append(errors, fmt.Sprintf("endpoints[%d]: invalid address %q: %s", i, endpoint.Address, err))
+		}
+		if endpoint.Secret != "" && strings.Count(endpoint.Secret, "/") != 1 {
+			errors = append(errors, fmt.Sprintf("endpoints[%d]: secret must be namespace/name, got %q", i, endpoint.Secret))
+		}
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-tls-probe data-gatherer.
+func (c *ConfigTLSProbe) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigTLSProbe) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	g := &DataGathererTLSProbe{
+		ctx:          ctx,
+		clientset:    clientset,
+		endpoints:    c.Endpoints,
+		ingressHosts: c.IngressHosts,
+		timeout:      c.Timeout,
+	}
+	if g.timeout == 0 {
+		g.timeout = defaultTLSProbeTimeout
+	}
+	g.dial = (&net.Dialer{}).DialContext
+
+	return g, nil
+}
+
+// DataGathererTLSProbe performs TLS handshakes with endpoints and records
+// what they actually serve: the certificate chain, the protocol version and
+// the cipher suite. The certificates are recorded, not verified, so that the
+// drift between the Secrets declared for the endpoints and the certificates
+// they serve can be detected, e.g. when a controller didn't reload a renewed
+// certificate.
+type DataGathererTLSProbe struct {
+	ctx          context.Context
+	clientset    kubernetes.Interface
+	endpoints    []TLSProbeEndpoint
+	ingressHosts bool
+	timeout      time.Duration
+
+	// dial connects to the endpoints, it is replaced in tests.
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// TLSProbeResult is what an endpoint served.
+type TLSProbeResult struct {
+	Address    string `json:"address"`
+	ServerName string `json:"serverName"`
+	// Source is endpoint for the configured endpoints, or the
+	// Ingress/namespace/name of the Ingress of the host.
+	Source string `json:"source"`
+	// Secret is the namespace/name of the Secret of the certificate the
+	// endpoint is expected to serve, if any.
+	Secret string `json:"secret,omitempty"`
+	// Error is the error of the handshake, if it failed.
+	Error       string                `json:"error,omitempty"`
+	Version     string                `json:"version,omitempty"`
+	CipherSuite string                `json:"cipherSuite,omitempty"`
+	Chain       []TLSProbeCertificate `json:"chain,omitempty"`
+	// Drift is true if the endpoint serves another certificate than the one
+	// of its Secret.
+	Drift bool `json:"drift,omitempty"`
+}
+
+// TLSProbeCertificate is a certificate of a served chain.
+type TLSProbeCertificate struct {
+	Subject  string   `json:"subject"`
+	Issuer   string   `json:"issuer"`
+	Serial   string   `json:"serial"`
+	DNSNames []string `json:"dnsNames,omitempty"`
+	NotAfter api.Time `json:"notAfter"`
+	// Fingerprint is the hex encoded SHA-256 digest of the certificate.
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Run is a no-op, the endpoints are probed on every Fetch.
+func (g *DataGathererTLSProbe) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererTLSProbe) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererTLSProbe) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch probes the configured endpoints, and the Ingress hosts if enabled.
+// The failed handshakes are reported in the results rather than failing the
+// Fetch.
+func (g *DataGathererTLSProbe) Fetch() (interface{}, int, error) {
+	results := []*TLSProbeResult{}
+	for _, endpoint := range g.endpoints {
+		serverName := endpoint.ServerName
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(endpoint.Address)
+		}
+		results = append(results, &TLSProbeResult{Address: endpoint.Address, ServerName: serverName, Source: "endpoint", Secret: endpoint.Secret})
+	}
+	if g.ingressHosts {
+		ingresses, err := g.clientset.NetworkingV1().Ingresses(metav1.NamespaceAll).List(g.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to list ingresses: %w", err)
+		}
+		for _, ingress := range ingresses.Items {
+			for _, ingressTLS := range ingress.Spec.TLS {
+				for _, host := range ingressTLS.Hosts {
+					if strings.HasPrefix(host, "*.") {
+						continue
+					}
+					result := &TLSProbeResult{
+						Address:    net.JoinHostPort(host, tlsProbeIngressPort),
+						ServerName: host,
+						Source:     "Ingress/" + ingress.Namespace + "/" + ingress.Name,
+					}
+					if ingressTLS.SecretName != "" {
+						result.Secret = ingress.Namespace + "/" + ingressTLS.SecretName
+					}
+					results = append(results, result)
+				}
+			}
+		}
+	}
+
+	fingerprints := map[string]string{}
+	findings := []api.Finding{}
+	for _, result := range results {
+		g.probe(result)
+		resource := tlsProbeResource(result)
+		if result.Error != "" {
+			findings = append(findings, tlsProbeFinding(TLSProbeFindingFailed, resource, fmt.Sprintf("the TLS handshake with %s failed: %s", result.Address, result.Error)))
+			continue
+		}
+		if result.Version == "TLS 1.0" || result.Version == "TLS 1.1" || result.Version == "SSLv3" {
+			findings = append(findings, tlsProbeFinding(TLSProbeFindingLegacyProtocol, resource, fmt.Sprintf("%s negotiated %s", result.Address, result.Version)))
+		}
+		if result.Secret == "" || len(result.Chain) == 0 {
+			continue
+		}
+		declared, ok := fingerprints[result.Secret]
+		if !ok {
+			var err error
+			if declared, err = g.secretFingerprint(result.Secret); err != nil {
+				return nil, -1, err
+			}
+			fingerprints[result.Secret] = declared
+		}
+		if declared != "" && declared != result.Chain[0].Fingerprint {
+			result.Drift = true
+			findings = append(findings, tlsProbeFinding(TLSProbeFindingDrift, resource, fmt.Sprintf("%s serves a certificate other than the one in the Secret %s", result.Address, result.Secret)))
+		}
+	}
+
+	response := map[string]interface{}{
+		"results":  results,
+		"findings": findings,
+	}
+
+	return response, len(results), nil
+}
+
+// probe performs a handshake with the endpoint of the result and records
+// what it served.
+func (g *DataGathererTLSProbe) probe(result *TLSProbeResult) {
+	ctx, cancel := context.WithTimeout(g.ctx, g.timeout)
+	defer cancel()
+
+	rawConn, err := g.dial(ctx, "tcp", result.Address)
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	defer rawConn.Close()
+
+	conn := tls.Client(rawConn, &tls.Config{
+		ServerName: result.ServerName,
+		// the served certificates are recorded rather than verified, so
+		// that those of internal CAs can be compared too
+		InsecureSkipVerify: true,
+	})
+	if err := conn.HandshakeContext(ctx); err != nil {
+		result.Error = err.Error()
+		return
+	}
+	state := conn.ConnectionState()
+	result.Version = tls.VersionName(state.Version)
+	result.CipherSuite = tls.CipherSuiteName(state.CipherSuite)
+	for _, cert := range state.PeerCertificates {
+		result.Chain = append(result.Chain, newTLSProbeCertificate(cert))
+	}
+}
+
+// secretFingerprint returns the fingerprint of the certificate of the Secret,
+// or nothing if there is none.
+func (g *DataGathererTLSProbe) secretFingerprint(namespacedName string) (string, error) {
+	namespace, name := splitNamespacedName(namespacedName)
+	secret, err := g.clientset.CoreV1().Secrets(namespace).Get(g.ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", namespacedName, err)
+	}
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return "", nil
+	}
+	return certificateFingerprint(block.Bytes), nil
+}
+
+func newTLSProbeCertificate(cert *x509.Certificate) TLSProbeCertificate {
+	return TLSProbeCertificate{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.String(),
+		DNSNames:    cert.DNSNames,
+		NotAfter:    api.Time{Time: cert.NotAfter},
+		Fingerprint: certificateFingerprint(cert.Raw),
+	}
+}
+
+func certificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// tlsProbeResource returns the Ingress of the result, or the endpoint.
+func tlsProbeResource(result *TLSProbeResult) api.ResourceRef {
+	if parts := strings.SplitN(result.Source, "/", 3); len(parts) == 3 {
+		return api.ResourceRef{Kind: parts[0], Namespace: parts[1], Name: parts[2]}
+	}
+	return api.ResourceRef{Kind: "Endpoint", Name: result.Address}
+}
+
+// tlsProbeFindingSeverities and tlsProbeFindingRemediations hold the severity
+// and the remediation hint of each rule.
+var (
+	tlsProbeFindingSeverities = map[string]api.Severity{
+		TLSProbeFindingDrift:          api.SeverityHigh,
+		TLSProbeFindingLegacyProtocol: api.SeverityMedium,
+		TLSProbeFindingFailed:         api.SeverityInfo,
+	}
+	tlsProbeFindingRemediations = map[string]string{
+		TLSProbeFindingDrift:          "Check that the server reloads its certificate when the Secret is updated, and that it serves the Secret it is configured with.",
+		TLSProbeFindingLegacyProtocol: "Configure the server with a minimum protocol version of TLS 1.2.",
+		TLSProbeFindingFailed:         "Check that the endpoint is reachable from the agent and serves TLS.",
+	}
+)
+
+func tlsProbeFinding(ruleID string, resource api.ResourceRef, message string) api.Finding {
+	return api.Finding{
+		RuleID:      ruleID,
+		Severity:    tlsProbeFindingSeverities[ruleID],
+		Resource:    resource,
+		Message:     message,
+		Remediation: tlsProbeFindingRemediations[ruleID],
+	}
+}
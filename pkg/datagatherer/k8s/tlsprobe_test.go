@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func TestTLSProbeGatherer_Fetch(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	served := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	clientset := fakeclientset.NewSimpleClientset(
+		tlsSecret("web", "current", served, nil),
+		tlsSecret("web", "renewed", encodeTestCertForHosts(t, time.Now().Add(time.Hour), "web.example.com"), nil),
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "web", Name: "web"},
+			Spec: networkingv1.IngressSpec{TLS: []networkingv1.IngressTLS{
+				{Hosts: []string{"web.example.com", "*.example.com"}, SecretName: "renewed"},
+			}},
+		},
+	)
+
+	config := ConfigTLSProbe{
+		Endpoints: []TLSProbeEndpoint{
+			{Address: "api.web.svc:443", Secret: "web/current"},
+			{Address: "down.web.svc:443"},
+		},
+		IngressHosts: true,
+	}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// all the endpoints are served by the test server, except one
+	dg.(*DataGathererTLSProbe).dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if address == "down.web.svc:443" {
+			return nil, errors.New("connection refused")
+		}
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 results, got %d", count)
+	}
+
+	results := data.(map[string]interface{})["results"].([]*TLSProbeResult)
+	var summaries []string
+	for _, result := range results {
+		summary := result.Address + " " + result.Source
+		if result.Error != "" {
+			summary += " failed"
+		} else if len(result.Chain) == 0 || result.Chain[0].Fingerprint != certificateFingerprint(server.Certificate().Raw) || result.Version == "" || result.CipherSuite == "" {
+			summary += " unexpected handshake"
+		}
+		if result.Drift {
+			summary += " drift"
+		}
+		summaries = append(summaries, summary)
+	}
+	expected := []string{
+		"api.web.svc:443 endpoint",
+		"down.web.svc:443 endpoint failed",
+		"web.example.com:443 Ingress/web/web drift",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, summaries); !equal {
+		t.Errorf("unexpected results:\n%s", diff)
+	}
+
+	var rules []string
+	for _, finding := range data.(map[string]interface{})["findings"].([]api.Finding) {
+		rules = append(rules, finding.Resource.Kind+" "+finding.Resource.Name+" "+finding.RuleID)
+	}
+	expectedRules := []string{
+		"Endpoint down.web.svc:443 " + TLSProbeFindingFailed,
+		"Ingress web " + TLSProbeFindingDrift,
+	}
+	if diff, equal := messagediff.PrettyDiff(expectedRules, rules); !equal {
+		t.Errorf("unexpected findings:\n%s", diff)
+	}
+}
+
+func TestConfigTLSProbe_Validate(t *testing.T) {
+	tests := map[string]struct {
+		config  ConfigTLSProbe
+		wantErr bool
+	}{
+		"endpoints":                {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "a.b.svc:443"}}}},
+		"ingress hosts":            {config: ConfigTLSProbe{IngressHosts: true}},
+		"nothing to probe":         {config: ConfigTLSProbe{}, wantErr: true},
+		"address without port":     {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "a.b.svc"}}}, wantErr: true},
+		"secret without namespace": {config: ConfigTLSProbe{Endpoints: []TLSProbeEndpoint{{Address: "a.b.svc:443", Secret: "tls"}}}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := test.config.Validate(); (err != nil) != test.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package k8s
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/pkg/errors"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// ConfigWebhooks contains the configuration for the k8s-webhooks data-gatherer.
+type ConfigWebhooks struct {
+	// KubeConfigPath is the path to the kubeconfig file. If empty, will assume it runs in-cluster.
+	KubeConfigPath string `yaml:"kubeconfig"`
+}
+
+// UnmarshalYAML unmarshals the ConfigWebhooks.
+func (c *ConfigWebhooks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	aux := struct {
+		KubeConfigPath string `yaml:"kubeconfig"`
+	}{}
+	err := unmarshal(&aux)
+	if err != nil {
+		return err
+	}
+
+	c.KubeConfigPath = aux.KubeConfigPath
+
+	return nil
+}
+
+// NewDataGatherer constructs a new instance of the k8s-webhooks data-gatherer.
+func (c *ConfigWebhooks) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	clientset, err := NewClientSet(ctx, c.KubeConfigPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c.newDataGathererWithClient(ctx, clientset)
+}
+
+func (c *ConfigWebhooks) newDataGathererWithClient(ctx context.Context, clientset kubernetes.Interface) (datagatherer.DataGatherer, error) {
+	return &DataGathererWebhooks{
+		ctx:       ctx,
+		clientset: clientset,
+	}, nil
+}
+
+// DataGathererWebhooks builds an inventory of the admission webhooks
+// registered in a cluster, along with the state of the CA bundles and
+// services they depend on. This is mostly useful to diagnose webhook outages,
+// such as the cert-manager webhook being unreachable.
+type DataGathererWebhooks struct {
+	ctx       context.Context
+	clientset kubernetes.Interface
+}
+
+// Webhook describes a single webhook of a Validating or Mutating
+// WebhookConfiguration.
+type Webhook struct {
+	// Kind is either ValidatingWebhookConfiguration or MutatingWebhookConfiguration.
+	Kind string `json:"kind"`
+	// Configuration is the name of the WebhookConfiguration the webhook belongs to.
+	Configuration     string                `json:"configuration"`
+	Name              string                `json:"name"`
+	FailurePolicy     string                `json:"failurePolicy,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// URL is set when the webhook is not served by an in-cluster service.
+	URL      string           `json:"url,omitempty"`
+	Service  *WebhookService  `json:"service,omitempty"`
+	CABundle *WebhookCABundle `json:"caBundle,omitempty"`
+}
+
+// WebhookService is the in-cluster service a webhook is served by.
+type WebhookService struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Port      int32  `json:"port,omitempty"`
+	Path      string `json:"path,omitempty"`
+	// Exists is false when the Service could not be found.
+	Exists bool `json:"exists"`
+	// ReadyEndpoints is the number of ready addresses backing the Service.
+	ReadyEndpoints int `json:"readyEndpoints"`
+	// Available is true when the Service exists and has at least one ready
+	// endpoint.
+	Available bool `json:"available"`
+}
+
+// WebhookCABundle describes the certificates found in a webhook's caBundle.
+type WebhookCABundle struct {
+	Certificates []WebhookCACertificate `json:"certificates"`
+	// Expired is true when at least one certificate in the bundle has expired.
+	Expired bool `json:"expired"`
+	// Error is set when the bundle could not be parsed.
+	Error string `json:"error,omitempty"`
+}
+
+// WebhookCACertificate is a summary of one certificate of a caBundle.
+type WebhookCACertificate struct {
+	Subject   string   `json:"subject"`
+	NotBefore api.Time `json:"notBefore"`
+	NotAfter  api.Time `json:"notAfter"`
+	Expired   bool     `json:"expired"`
+}
+
+// Run is a no-op, the webhook configurations are listed on every Fetch.
+func (g *DataGathererWebhooks) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// WaitForCacheSync is a no-op, see Fetch.
+func (g *DataGathererWebhooks) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Delete is a no-op, see Fetch.
+func (g *DataGathererWebhooks) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch lists all ValidatingWebhookConfigurations and
+// MutatingWebhookConfigurations and returns one entry per webhook.
+func (g *DataGathererWebhooks) Fetch() (interface{}, int, error) {
+	admissionClient := g.clientset.AdmissionregistrationV1()
+
+	validating, err := admissionClient.ValidatingWebhookConfigurations().List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list validatingwebhookconfigurations: %w", err)
+	}
+	mutating, err := admissionClient.MutatingWebhookConfigurations().List(g.ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to list mutatingwebhookconfigurations: %w", err)
+	}
+
+	webhooks := []*Webhook{}
+	for _, cfg := range validating.Items {
+		for _, wh := range cfg.Webhooks {
+			webhook, err := g.describe("ValidatingWebhookConfiguration", cfg.Name, wh.Name, wh.FailurePolicy, wh.NamespaceSelector, wh.ClientConfig)
+			if err != nil {
+				return nil, -1, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	for _, cfg := range mutating.Items {
+		for _, wh := range cfg.Webhooks {
+			webhook, err := g.describe("MutatingWebhookConfiguration", cfg.Name, wh.Name, wh.FailurePolicy, wh.NamespaceSelector, wh.ClientConfig)
+			if err != nil {
+				return nil, -1, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+	}
+
+	response := map[string]interface{}{
+		"webhooks": webhooks,
+	}
+
+	return response, len(webhooks), nil
+}
+
+func (g *DataGathererWebhooks) describe(kind, configuration, name string, failurePolicy *admissionregistrationv1.FailurePolicyType, namespaceSelector *metav1.LabelSelector, clientConfig admissionregistrationv1.WebhookClientConfig) (*Webhook, error) {
+	webhook := &Webhook{
+		Kind:              kind,
+		Configuration:     configuration,
+		Name:              name,
+		NamespaceSelector: namespaceSelector,
+	}
+	if failurePolicy != nil {
+		webhook.FailurePolicy = string(*failurePolicy)
+	}
+	if clientConfig.URL != nil {
+		webhook.URL = *clientConfig.URL
+	}
+	if len(clientConfig.CABundle) > 0 {
+		webhook.CABundle = parseCABundle(clientConfig.CABundle)
+	}
+
+	if ref := clientConfig.Service; ref != nil {
+		service, err := g.serviceStatus(ref)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Service = service
+	}
+
+	return webhook, nil
+}
+
+// serviceStatus looks up the Service and Endpoints a webhook points at.
+func (g *DataGathererWebhooks) serviceStatus(ref *admissionregistrationv1.ServiceReference) (*WebhookService, error) {
+	service := &WebhookService{
+		Namespace: ref.Namespace,
+		Name:      ref.Name,
+	}
+	if ref.Port != nil {
+		service.Port = *ref.Port
+	}
+	if ref.Path != nil {
+		service.Path = *ref.Path
+	}
+
+	coreClient := g.clientset.CoreV1()
+	_, err := coreClient.Services(ref.Namespace).Get(g.ctx, ref.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return service, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	service.Exists = true
+
+	endpoints, err := coreClient.Endpoints(ref.Namespace).Get(g.ctx, ref.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return service, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get endpoints %s/%s: %w", ref.Namespace, ref.Name, err)
+	}
+	for _, subset := range endpoints.Subsets {
+		service.ReadyEndpoints += len(subset.Addresses)
+	}
+	service.Available = service.ReadyEndpoints > 0
+
+	return service, nil
+}
+
+// parseCABundle decodes all PEM certificates in a caBundle. A bundle that
+// cannot be parsed is reported with an error rather than failing the Fetch,
+// as a broken bundle is exactly what this data gatherer should surface.
+func parseCABundle(bundle []byte) *WebhookCABundle {
+	result := &WebhookCABundle{
+		Certificates: []WebhookCACertificate{},
+	}
+	now := clock.now()
+
+	rest := bundle
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to parse certificate: %s", err)
+			return result
+		}
+		expired := now.After(cert.NotAfter)
+		result.Expired = result.Expired || expired
+		result.Certificates = append(result.Certificates, WebhookCACertificate{
+			Subject:   cert.Subject.String(),
+			NotBefore: api.Time{Time: cert.NotBefore},
+			NotAfter:  api.Time{Time: cert.NotAfter},
+			Expired:   expired,
+		})
+	}
+
+	if len(result.Certificates) == 0 {
+		result.Error = "no PEM encoded certificates found"
+	}
+
+	return result
+}
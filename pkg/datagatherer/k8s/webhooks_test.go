@@ -0,0 +1,130 @@
+package k8s
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakeclientset "k8s.io/client-go/kubernetes/fake"
+)
+
+func testCertificatePEM(t *testing.T, commonName string, notAfter time.Time) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestParseCABundle(t *testing.T) {
+	valid := testCertificatePEM(t, "valid", clock.now().Add(time.Hour))
+	expired := testCertificatePEM(t, "expired", clock.now().Add(-time.Hour))
+
+	bundle := parseCABundle(append(valid, expired...))
+	if bundle.Error != "" {
+		t.Fatalf("unexpected error: %s", bundle.Error)
+	}
+	if len(bundle.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(bundle.Certificates))
+	}
+	if bundle.Certificates[0].Expired || !bundle.Certificates[1].Expired {
+		t.Errorf("unexpected expiry flags: %+v", bundle.Certificates)
+	}
+	if !bundle.Expired {
+		t.Errorf("expected bundle to be flagged as expired")
+	}
+
+	if got := parseCABundle([]byte("not a bundle")); got.Error == "" {
+		t.Errorf("expected an error for an invalid bundle")
+	}
+}
+
+func TestWebhooksGatherer_Fetch(t *testing.T) {
+	fail := admissionregistrationv1.Fail
+	clientset := fakeclientset.NewSimpleClientset(
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				{
+					Name:          "webhook.cert-manager.io",
+					FailurePolicy: &fail,
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service:  &admissionregistrationv1.ServiceReference{Namespace: "cert-manager", Name: "cert-manager-webhook"},
+						CABundle: testCertificatePEM(t, "ca", clock.now().Add(time.Hour)),
+					},
+				},
+			},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "missing"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{
+				{
+					Name: "missing.example.com",
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service: &admissionregistrationv1.ServiceReference{Namespace: "example", Name: "missing"},
+					},
+				},
+			},
+		},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "cert-manager-webhook"}},
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "cert-manager-webhook"},
+			Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+		},
+	)
+
+	config := ConfigWebhooks{}
+	dg, err := config.newDataGathererWithClient(context.Background(), clientset)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	res, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 webhooks, got %d", count)
+	}
+
+	webhooks := res.(map[string]interface{})["webhooks"].([]*Webhook)
+
+	certManager := webhooks[0]
+	if certManager.FailurePolicy != "Fail" {
+		t.Errorf("unexpected failurePolicy: %q", certManager.FailurePolicy)
+	}
+	if !certManager.Service.Available || certManager.Service.ReadyEndpoints != 1 {
+		t.Errorf("expected cert-manager webhook service to be available: %+v", certManager.Service)
+	}
+	if certManager.CABundle == nil || certManager.CABundle.Expired {
+		t.Errorf("expected a valid caBundle: %+v", certManager.CABundle)
+	}
+
+	missing := webhooks[1]
+	if missing.Kind != "MutatingWebhookConfiguration" {
+		t.Errorf("unexpected kind: %q", missing.Kind)
+	}
+	if missing.Service.Exists || missing.Service.Available {
+		t.Errorf("expected missing service to be unavailable: %+v", missing.Service)
+	}
+}
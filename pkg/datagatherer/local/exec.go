@@ -0,0 +1,187 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	defaultExecTimeout        = 30 * time.Second
+	defaultExecMaxOutputBytes = 1 << 20
+	// maxExecStderrBytes is how much of the stderr of a failed command is
+	// reported in its error.
+	maxExecStderrBytes = 4096
+)
+
+// ExecConfig is the configuration of the exec data gatherer, which runs a
+// command and gathers its output.
+type ExecConfig struct {
+	// Command is the command run. It is not run in a shell.
+	Command string `yaml:"command"`
+	// Args are the arguments of the command.
+	Args []string `yaml:"args,omitempty"`
+	// Timeout is how long the command can run for. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxOutputBytes is the size of the output above which the command is
+	// failed. Defaults to 1MiB.
+	MaxOutputBytes int64 `yaml:"max-output-bytes,omitempty"`
+	// Format is the format of the output, text or json. The output is
+	// parsed as JSON with json, and kept as text otherwise.
+	Format string `yaml:"format,omitempty"`
+}
+
+// Validate checks the configuration, without running the command, so that
+// mistakes are reported when the agent config is parsed.
+func (c *ExecConfig) Validate() error {
+	var errors []string
+	if c.Command == "" {
+		errors = append(errors, "command is required")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+	if c.MaxOutputBytes < 0 {
+		errors = append(errors, "max-output-bytes must not be negative")
+	}
+	if c.Format != "" && c.Format != "text" && c.Format != "json" {
+		errors = append(errors, fmt.Sprintf("format must be text or json, not %q", c.Format))
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new ExecDataGatherer.
+func (c *ExecConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &ExecDataGatherer{
+		ctx:            ctx,
+		command:        c.Command,
+		args:           c.Args,
+		timeout:        c.Timeout,
+		maxOutputBytes: c.MaxOutputBytes,
+		json:           c.Format == "json",
+	}
+	if g.timeout == 0 {
+		g.timeout = defaultExecTimeout
+	}
+	if g.maxOutputBytes == 0 {
+		g.maxOutputBytes = defaultExecMaxOutputBytes
+	}
+	return g, nil
+}
+
+// ExecDataGatherer is a data-gatherer that runs a command, e.g. a vendor
+// CLI, on every Fetch and gathers its stdout.
+type ExecDataGatherer struct {
+	ctx            context.Context
+	command        string
+	args           []string
+	timeout        time.Duration
+	maxOutputBytes int64
+	json           bool
+}
+
+func (g *ExecDataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *ExecDataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *ExecDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch runs the command and returns its stdout, parsed if its format is
+// json. The command fails if it exits with an error, runs for longer than
+// its timeout or outputs more than its max output size.
+func (g *ExecDataGatherer) Fetch() (interface{}, int, error) {
+	stdout, err := runCommand(g.ctx, g.command, g.args, nil, g.timeout, g.maxOutputBytes)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	if !g.json {
+		return string(stdout), -1, nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(stdout, &data); err != nil {
+		return nil, -1, fmt.Errorf("failed to parse the output of %s: %w", g.command, err)
+	}
+	return data, -1, nil
+}
+
+// runCommand runs the command with stdin, if any, and returns its stdout.
+// The command fails if it exits with an error, runs for longer than timeout
+// or outputs more than maxOutputBytes.
+func runCommand(ctx context.Context, command string, args []string, stdin []byte, timeout time.Duration, maxOutputBytes int64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{limit: maxOutputBytes}
+	stderr := &limitedBuffer{limit: maxExecStderrBytes}
+	cmd := exec.CommandContext(ctx, command, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if stdout.exceeded {
+		return nil, fmt.Errorf("the output of %s exceeds %d bytes", command, maxOutputBytes)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %s", command, timeout)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s failed: %w: %s", command, err, message)
+		}
+		return nil, fmt.Errorf("%s failed: %w", command, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// limitedBuffer is a buffer that keeps up to limit bytes, discarding the
+// rest. The buffer isn't embedded so that its ReadFrom doesn't bypass the
+// limit.
+type limitedBuffer struct {
+	buffer   bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - int64(b.buffer.Len()); int64(len(p)) > remaining {
+		b.exceeded = true
+		b.buffer.Write(p[:remaining])
+		return len(p), nil
+	}
+	return b.buffer.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buffer.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buffer.String()
+}
@@ -0,0 +1,57 @@
+package local
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func fetchExec(config *ExecConfig) (interface{}, error) {
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := dg.Fetch()
+	return data, err
+}
+
+func TestExecFetch(t *testing.T) {
+	data, err := fetchExec(&ExecConfig{Command: "echo", Args: []string{"hello", "world"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if data != "hello world\n" {
+		t.Errorf("unexpected output: %q", data)
+	}
+
+	data, err = fetchExec(&ExecConfig{Command: "echo", Args: []string{`{"version": "1.20.0"}`}, Format: "json"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(map[string]interface{}{"version": "1.20.0"}, data); !equal {
+		t.Errorf("unexpected output:\n%s", diff)
+	}
+}
+
+func TestExecFetchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *ExecConfig
+		expected string
+	}{
+		{"failed", &ExecConfig{Command: "sh", Args: []string{"-c", "echo broken >&2; exit 3"}}, "sh failed: exit status 3: broken"},
+		{"timed out", &ExecConfig{Command: "sleep", Args: []string{"10"}, Timeout: 10 * time.Millisecond}, "sleep timed out after 10ms"},
+		{"too large", &ExecConfig{Command: "echo", Args: []string{"hello"}, MaxOutputBytes: 3}, "the output of echo exceeds 3 bytes"},
+		{"not json", &ExecConfig{Command: "echo", Args: []string{"hello"}, Format: "json"}, "failed to parse the output of echo"},
+		{"invalid", &ExecConfig{Format: "xml"}, `command is required, format must be text or json, not "xml"`},
+	}
+	for _, tc := range tests {
+		_, err := fetchExec(tc.config)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.expected) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
@@ -2,23 +2,40 @@ package local
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/yaml"
 
 	"github.com/jetstack/preflight/pkg/datagatherer"
 )
 
 // Config is the configuration for a local DataGatherer.
 type Config struct {
-	// DataPath is the path to file containing the data to load.
+	// DataPath is the path to file containing the data to load. It can also
+	// be a directory or a glob pattern, e.g. /etc/config/*.json, in which
+	// case the data is the parsed content of each file.
 	DataPath string `yaml:"data-path"`
 }
 
+// Validate checks the configuration, without connecting to the cluster, so
+// that mistakes are reported when the agent config is parsed.
+func (c *Config) Validate() error {
+	return c.validate()
+}
+
 // validate validates the configuration.
 func (c *Config) validate() error {
 	if c.DataPath == "" {
 		return fmt.Errorf("invalid configuration: DataPath cannot be empty")
 	}
+	if _, err := filepath.Match(c.DataPath, ""); err != nil {
+		return fmt.Errorf("invalid configuration: DataPath %q is not a valid glob pattern", c.DataPath)
+	}
 	return nil
 }
 
@@ -53,11 +70,87 @@ func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
 	return nil
 }
 
-// Fetch loads and returns the data from the LocalDatagatherer's dataPath
+// Fetch loads and returns the data from the LocalDatagatherer's dataPath.
+// The data of a directory or a glob pattern is a map of the path of each
+// file to its parsed content.
 func (g *DataGatherer) Fetch() (interface{}, int, error) {
+	if isGlob(g.dataPath) {
+		paths, err := filepath.Glob(g.dataPath)
+		if err != nil {
+			return nil, -1, err
+		}
+		return readFiles(paths)
+	}
+
+	info, err := os.Stat(g.dataPath)
+	if err != nil {
+		return nil, -1, err
+	}
+	if info.IsDir() {
+		entries, err := os.ReadDir(g.dataPath)
+		if err != nil {
+			return nil, -1, err
+		}
+		var paths []string
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(g.dataPath, entry.Name()))
+		}
+		return readFiles(paths)
+	}
+
 	dataBytes, err := ioutil.ReadFile(g.dataPath)
 	if err != nil {
 		return nil, -1, err
 	}
 	return dataBytes, -1, nil
 }
+
+// isGlob returns whether the path is a glob pattern rather than the path of
+// a file or a directory.
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// readFiles returns the parsed content of the regular files of paths, by
+// path, and their number. The sub-directories are skipped.
+func readFiles(paths []string) (interface{}, int, error) {
+	files := map[string]interface{}{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, -1, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		content, err := parseFile(path)
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		files[path] = content
+	}
+	return files, len(files), nil
+}
+
+// parseFile parses JSON and YAML files according to their extension. The
+// content of the other files is returned as a string.
+func parseFile(path string) (interface{}, error) {
+	dataBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(dataBytes, &content)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(dataBytes, &content)
+	default:
+		content = string(dataBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return content, nil
+}
@@ -0,0 +1,89 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func fetch(t *testing.T, dataPath string) (interface{}, int) {
+	dg, err := (&Config{DataPath: dataPath}).NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return data, count
+}
+
+func TestFetch(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"a.json":     `{"name": "a"}`,
+		"b.yaml":     "name: b\nreplicas: 2\n",
+		"c.txt":      "c\n",
+		"sub/d.json": `{"name": "d"}`,
+	})
+
+	data, count := fetch(t, filepath.Join(dir, "a.json"))
+	if string(data.([]byte)) != `{"name": "a"}` || count != -1 {
+		t.Errorf("unexpected data of a file: %s, %d", data, count)
+	}
+
+	data, count = fetch(t, dir)
+	expected := map[string]interface{}{
+		filepath.Join(dir, "a.json"): map[string]interface{}{"name": "a"},
+		filepath.Join(dir, "b.yaml"): map[string]interface{}{"name": "b", "replicas": float64(2)},
+		filepath.Join(dir, "c.txt"):  "c\n",
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data of a directory:\n%s", diff)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 files, got %d", count)
+	}
+
+	data, count = fetch(t, filepath.Join(dir, "*", "*.json"))
+	expected = map[string]interface{}{
+		filepath.Join(dir, "sub", "d.json"): map[string]interface{}{"name": "d"},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data of a glob:\n%s", diff)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 file, got %d", count)
+	}
+}
+
+func TestFetchInvalid(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"a.json": `{"name":`})
+	dg, err := (&Config{DataPath: filepath.Join(dir, "*.json")}).NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, _, err := dg.Fetch(); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	if err := (&Config{DataPath: "/etc/[config"}).Validate(); err == nil {
+		t.Errorf("expected an error")
+	}
+}
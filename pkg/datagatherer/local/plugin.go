@@ -0,0 +1,161 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+// PluginConfig is the configuration of the plugin data gatherer, which runs
+// a custom data gatherer shipped as an executable. The plugin is sent an
+// api.PluginRequest on its stdin and answers with an api.PluginResponse on
+// its stdout.
+type PluginConfig struct {
+	// Path is the path of the executable of the plugin.
+	Path string `yaml:"path"`
+	// Args are the arguments of the plugin.
+	Args []string `yaml:"args,omitempty"`
+	// Config is the configuration of the plugin, sent to it as JSON.
+	Config map[string]interface{} `yaml:"config,omitempty"`
+	// Timeout is how long the plugin can run for. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxOutputBytes is the size of the response above which the plugin is
+	// failed. Defaults to 1MiB.
+	MaxOutputBytes int64 `yaml:"max-output-bytes,omitempty"`
+}
+
+// Validate checks the configuration, without running the plugin, so that
+// mistakes are reported when the agent config is parsed.
+func (c *PluginConfig) Validate() error {
+	var errors []string
+	if c.Path == "" {
+		errors = append(errors, "path is required")
+	}
+	if _, err := json.Marshal(c.Config); err != nil {
+		errors = append(errors, fmt.Sprintf("config cannot be encoded as JSON: %s", err))
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+	if c.MaxOutputBytes < 0 {
+		errors = append(errors, "max-output-bytes must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new PluginDataGatherer.
+func (c *PluginConfig) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	request := api.PluginRequest{ProtocolVersion: api.PluginProtocolVersion}
+	if c.Config != nil {
+		config, err := json.Marshal(c.Config)
+		if err != nil {
+			return nil, err
+		}
+		request.Config = config
+	}
+	encodedRequest, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &PluginDataGatherer{
+		ctx:            ctx,
+		path:           c.Path,
+		args:           c.Args,
+		request:        encodedRequest,
+		timeout:        c.Timeout,
+		maxOutputBytes: c.MaxOutputBytes,
+	}
+	if g.timeout == 0 {
+		g.timeout = defaultExecTimeout
+	}
+	if g.maxOutputBytes == 0 {
+		g.maxOutputBytes = defaultExecMaxOutputBytes
+	}
+	return g, nil
+}
+
+// PluginDataGatherer is a data-gatherer that runs a plugin on every Fetch,
+// so that custom data gatherers can be added without forking the agent.
+type PluginDataGatherer struct {
+	ctx            context.Context
+	path           string
+	args           []string
+	request        []byte
+	timeout        time.Duration
+	maxOutputBytes int64
+}
+
+func (g *PluginDataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *PluginDataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *PluginDataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch runs the plugin and returns the data of its response. The findings
+// of the response are returned under the findings key of the data, which
+// must then be an object, like those of the built-in data gatherers.
+func (g *PluginDataGatherer) Fetch() (interface{}, int, error) {
+	stdout, err := runCommand(g.ctx, g.path, g.args, g.request, g.timeout, g.maxOutputBytes)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	var response api.PluginResponse
+	if err := json.Unmarshal(stdout, &response); err != nil {
+		return nil, -1, fmt.Errorf("failed to parse the response of %s: %w", g.path, err)
+	}
+	if response.ProtocolVersion != api.PluginProtocolVersion {
+		return nil, -1, fmt.Errorf("%s speaks protocol version %q, the agent speaks %q", g.path, response.ProtocolVersion, api.PluginProtocolVersion)
+	}
+	if response.Error != "" {
+		return nil, -1, fmt.Errorf("%s failed: %s", g.path, response.Error)
+	}
+
+	count := -1
+	if response.Count != nil {
+		count = *response.Count
+	}
+
+	if len(response.Findings) == 0 {
+		var data interface{}
+		if len(response.Data) > 0 {
+			if err := json.Unmarshal(response.Data, &data); err != nil {
+				return nil, -1, fmt.Errorf("failed to parse the data of %s: %w", g.path, err)
+			}
+		}
+		return data, count, nil
+	}
+
+	data := map[string]interface{}{}
+	if len(response.Data) > 0 && string(response.Data) != "null" {
+		if err := json.Unmarshal(response.Data, &data); err != nil {
+			return nil, -1, fmt.Errorf("the data of %s must be an object to report findings: %w", g.path, err)
+		}
+	}
+	data["findings"] = response.Findings
+	return data, count, nil
+}
@@ -0,0 +1,91 @@
+package local
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// shellPlugin returns the config of a plugin running the script, which reads
+// the request from stdin.
+func shellPlugin(script string) *PluginConfig {
+	return &PluginConfig{Path: "sh", Args: []string{"-c", script}}
+}
+
+func TestPluginFetch(t *testing.T) {
+	// the plugin echoes the request as its data
+	config := shellPlugin(`read -r request; printf '{"protocolVersion": "v1", "data": %s, "count": 2}' "$request"`)
+	config.Config = map[string]interface{}{"endpoint": "https://vault.example.com", "mounts": []interface{}{"pki"}}
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"protocolVersion": "v1",
+		"config":          map[string]interface{}{"endpoint": "https://vault.example.com", "mounts": []interface{}{"pki"}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+	if count != 2 {
+		t.Errorf("expected a count of 2, got %d", count)
+	}
+}
+
+func TestPluginFetchFindings(t *testing.T) {
+	dg, err := shellPlugin(`cat >/dev/null; echo '{"protocolVersion": "v1", "data": {"roles": 3}, "findings": [{"rule_id": "open-role", "severity": "high", "resource": {"kind": "Role", "name": "pki"}, "message": "pki allows any domain"}]}'`).NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"roles": float64(3),
+		"findings": []api.Finding{{
+			RuleID:   "open-role",
+			Severity: api.SeverityHigh,
+			Resource: api.ResourceRef{Kind: "Role", Name: "pki"},
+			Message:  "pki allows any domain",
+		}},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, data); !equal {
+		t.Errorf("unexpected data:\n%s", diff)
+	}
+	if count != -1 {
+		t.Errorf("expected no count, got %d", count)
+	}
+}
+
+func TestPluginFetchErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *PluginConfig
+		expected string
+	}{
+		{"failed", shellPlugin(`echo broken >&2; exit 3`), "sh failed: exit status 3: broken"},
+		{"error", shellPlugin(`echo '{"protocolVersion": "v1", "error": "vault is sealed"}'`), "sh failed: vault is sealed"},
+		{"version", shellPlugin(`echo '{"protocolVersion": "v2"}'`), `sh speaks protocol version "v2", the agent speaks "v1"`},
+		{"not json", shellPlugin(`echo hello`), "failed to parse the response of sh"},
+		{"findings without object", shellPlugin(`echo '{"protocolVersion": "v1", "data": [1], "findings": [{"rule_id": "r"}]}'`), "the data of sh must be an object to report findings"},
+		{"invalid", &PluginConfig{Timeout: -1}, "path is required, timeout must not be negative"},
+	}
+	for _, tc := range tests {
+		dg, err := tc.config.NewDataGatherer(context.Background())
+		if err == nil {
+			_, _, err = dg.Fetch()
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), tc.expected) {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+// Package venafi contains a data gatherer that reads the certificate policy
+// of Venafi TPP and Venafi as a Service zones.
+package venafi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/datagatherer"
+)
+
+const (
+	// PlatformTPP is Venafi Trust Protection Platform.
+	PlatformTPP = "tpp"
+	// PlatformCloud is Venafi as a Service.
+	PlatformCloud = "cloud"
+
+	// defaultCloudURL is the URL of Venafi as a Service.
+	defaultCloudURL = "https://api.venafi.cloud"
+	// defaultTimeout is the timeout of the requests for the policy of a zone.
+	defaultTimeout = 30 * time.Second
+
+	tppCheckPolicyPath = "/vedsdk/Certificates/CheckPolicy"
+	cloudAPIKeyHeader  = "tppl-api-key"
+)
+
+// Config is the configuration of the venafi-policy data gatherer.
+type Config struct {
+	// Platform is tpp or cloud.
+	Platform string `yaml:"platform"`
+	// URL is the URL of the TPP instance, or of Venafi as a Service, which
+	// defaults to https://api.venafi.cloud.
+	URL string `yaml:"url"`
+	// Zones are the zones whose policy is read: policy folders for TPP, e.g.
+	// \VED\Policy\Certificates\Kubernetes, or application\template alias for
+	// Venafi as a Service.
+	Zones []string `yaml:"zones"`
+	// CredentialsPath is the path to the file holding the credentials: a TPP
+	// access token, or a Venafi as a Service API key. The file is read before
+	// each Fetch, so that the credentials can be rotated.
+	CredentialsPath string `yaml:"credentials-path"`
+	// Timeout is the timeout of the requests. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Validate checks the configuration, without connecting to Venafi, so that
+// mistakes are reported when the agent config is parsed.
+func (c *Config) Validate() error {
+	var errors []string
+	switch c.Platform {
+	case PlatformTPP:
+		if c.URL == "" {
+			errors = append(errors, "url is required for tpp")
+		}
+	case PlatformCloud:
+		for _, zone := range c.Zones {
+			if !strings.Contains(zone, `\`) {
+				errors = append(errors, fmt.Sprintf(`zone %q must be application\template-alias`, zone))
+			}
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("platform must be %s or %s, got %q", PlatformTPP, PlatformCloud, c.Platform))
+	}
+	if c.URL != "" {
+		if u, err := url.Parse(c.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			errors = append(errors, fmt.Sprintf("invalid url %q", c.URL))
+		}
+	}
+	if len(c.Zones) == 0 {
+		errors = append(errors, "at least one zone is required")
+	}
+	if c.CredentialsPath == "" {
+		errors = append(errors, "credentials-path is required")
+	}
+	if c.Timeout < 0 {
+		errors = append(errors, "timeout must not be negative")
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf(strings.Join(errors, ", "))
+	}
+
+	return nil
+}
+
+// NewDataGatherer returns a new DataGatherer.
+func (c *Config) NewDataGatherer(ctx context.Context) (datagatherer.DataGatherer, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	g := &DataGatherer{
+		ctx:             ctx,
+		platform:        c.Platform,
+		baseURL:         strings.TrimSuffix(c.URL, "/"),
+		zones:           c.Zones,
+		credentialsPath: c.CredentialsPath,
+		client:          &http.Client{Timeout: c.Timeout},
+	}
+	if g.baseURL == "" {
+		g.baseURL = defaultCloudURL
+	}
+	if c.Timeout == 0 {
+		g.client.Timeout = defaultTimeout
+	}
+	return g, nil
+}
+
+// DataGatherer reads the certificate policy of the configured zones, so that
+// the issuance in the cluster can be compared with the corporate policy.
+type DataGatherer struct {
+	ctx             context.Context
+	platform        string
+	baseURL         string
+	zones           []string
+	credentialsPath string
+	client          *http.Client
+}
+
+// ZonePolicy is the certificate policy of a zone. The fields that the zone
+// doesn't restrict are omitted.
+type ZonePolicy struct {
+	Zone     string `json:"zone"`
+	Platform string `json:"platform"`
+	// Error is the error reading the policy of the zone, if any.
+	Error string `json:"error,omitempty"`
+	// AllowedDomains are the domains the certificates may be issued for,
+	// including their subdomains, for TPP.
+	AllowedDomains []string `json:"allowedDomains,omitempty"`
+	// DomainPatterns are the regular expressions the names of the
+	// certificates must match, for Venafi as a Service.
+	DomainPatterns   []string    `json:"domainPatterns,omitempty"`
+	WildcardsAllowed *bool       `json:"wildcardsAllowed,omitempty"`
+	KeyTypes         []KeyPolicy `json:"keyTypes,omitempty"`
+	// KeyReuse is whether the private key of a certificate may be reused
+	// when it is renewed.
+	KeyReuse *bool         `json:"keyReuse,omitempty"`
+	Subject  SubjectPolicy `json:"subject"`
+	// ValidityPeriod is the maximum validity of the certificates, as an
+	// ISO 8601 duration, e.g. P90D.
+	ValidityPeriod string `json:"validityPeriod,omitempty"`
+	// Locked are the settings that the certificate requests can't override,
+	// for TPP.
+	Locked []string `json:"locked,omitempty"`
+}
+
+// KeyPolicy is a key algorithm the certificates may use.
+type KeyPolicy struct {
+	// Type is RSA or EC.
+	Type   string   `json:"type"`
+	Sizes  []int    `json:"sizes,omitempty"`
+	Curves []string `json:"curves,omitempty"`
+}
+
+// SubjectPolicy are the values, or for Venafi as a Service the regular
+// expressions, the subject of the certificates must have.
+type SubjectPolicy struct {
+	Organizations       []string `json:"organizations,omitempty"`
+	OrganizationalUnits []string `json:"organizationalUnits,omitempty"`
+	Localities          []string `json:"localities,omitempty"`
+	Provinces           []string `json:"provinces,omitempty"`
+	Countries           []string `json:"countries,omitempty"`
+}
+
+func (g *DataGatherer) Run(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) Delete() error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+func (g *DataGatherer) WaitForCacheSync(stopCh <-chan struct{}) error {
+	// no async functionality, see Fetch
+	return nil
+}
+
+// Fetch reads the policy of each zone. The errors of the zones are reported
+// in their policy, so that one unreadable zone doesn't hide the others.
+func (g *DataGatherer) Fetch() (interface{}, int, error) {
+	data, err := os.ReadFile(g.credentialsPath)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to read the credentials: %w", err)
+	}
+	credentials := strings.TrimSpace(string(data))
+
+	policies := make([]*ZonePolicy, 0, len(g.zones))
+	for _, zone := range g.zones {
+		var policy *ZonePolicy
+		if g.platform == PlatformTPP {
+			policy, err = g.tppPolicy(credentials, zone)
+		} else {
+			policy, err = g.cloudPolicy(credentials, zone)
+		}
+		if err != nil {
+			policy = &ZonePolicy{Error: err.Error()}
+		}
+		policy.Zone = zone
+		policy.Platform = g.platform
+		policies = append(policies, policy)
+	}
+
+	return map[string]interface{}{"policies": policies}, len(policies), nil
+}
+
+// tppValue and tppValues are the settings of a TPP policy, which are locked
+// if the certificate requests can't override them.
+type tppValue struct {
+	Value  interface{} `json:"Value"`
+	Locked bool        `json:"Locked"`
+}
+
+type tppValues struct {
+	Values []string `json:"Values"`
+	Locked bool     `json:"Locked"`
+}
+
+type tppCheckPolicyResponse struct {
+	Error  string `json:"Error"`
+	Policy *struct {
+		KeyPair struct {
+			KeyAlgorithm  tppValue `json:"KeyAlgorithm"`
+			KeySize       tppValue `json:"KeySize"`
+			EllipticCurve tppValue `json:"EllipticCurve"`
+		} `json:"KeyPair"`
+		Subject struct {
+			City               tppValue  `json:"City"`
+			Country            tppValue  `json:"Country"`
+			Organization       tppValue  `json:"Organization"`
+			OrganizationalUnit tppValues `json:"OrganizationalUnit"`
+			State              tppValue  `json:"State"`
+		} `json:"Subject"`
+		WhitelistedDomains []string `json:"WhitelistedDomains"`
+		WildcardsAllowed   bool     `json:"WildcardsAllowed"`
+	} `json:"Policy"`
+}
+
+// tppPolicy reads the policy of a TPP policy folder.
+func (g *DataGatherer) tppPolicy(accessToken, zone string) (*ZonePolicy, error) {
+	body, err := json.Marshal(map[string]string{"PolicyDN": zone})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodPost, g.baseURL+tppCheckPolicyPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var response tppCheckPolicyResponse
+	if err := g.do(req, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	if response.Policy == nil {
+		return nil, fmt.Errorf("no policy in the response")
+	}
+
+	p := response.Policy
+	wildcards := p.WildcardsAllowed
+	policy := &ZonePolicy{
+		AllowedDomains:   p.WhitelistedDomains,
+		WildcardsAllowed: &wildcards,
+		Subject: SubjectPolicy{
+			Organizations:       tppStrings(p.Subject.Organization),
+			OrganizationalUnits: p.Subject.OrganizationalUnit.Values,
+			Localities:          tppStrings(p.Subject.City),
+			Provinces:           tppStrings(p.Subject.State),
+			Countries:           tppStrings(p.Subject.Country),
+		},
+	}
+	if algorithm, ok := p.KeyPair.KeyAlgorithm.Value.(string); ok && algorithm != "" {
+		key := KeyPolicy{Type: algorithm}
+		if size, ok := p.KeyPair.KeySize.Value.(float64); ok && size > 0 {
+			key.Sizes = []int{int(size)}
+		}
+		if curve, ok := p.KeyPair.EllipticCurve.Value.(string); ok && curve != "" {
+			key.Curves = []string{curve}
+		}
+		policy.KeyTypes = []KeyPolicy{key}
+	}
+	for name, value := range map[string]bool{
+		"KeyAlgorithm":       p.KeyPair.KeyAlgorithm.Locked,
+		"KeySize":            p.KeyPair.KeySize.Locked,
+		"EllipticCurve":      p.KeyPair.EllipticCurve.Locked,
+		"City":               p.Subject.City.Locked,
+		"Country":            p.Subject.Country.Locked,
+		"Organization":       p.Subject.Organization.Locked,
+		"OrganizationalUnit": p.Subject.OrganizationalUnit.Locked,
+		"State":              p.Subject.State.Locked,
+	} {
+		if value {
+			policy.Locked = append(policy.Locked, name)
+		}
+	}
+	sort.Strings(policy.Locked)
+	return policy, nil
+}
+
+func tppStrings(value tppValue) []string {
+	if s, ok := value.Value.(string); ok && s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+type cloudIssuingTemplate struct {
+	KeyTypes []struct {
+		KeyType    string   `json:"keyType"`
+		KeyLengths []int    `json:"keyLengths"`
+		KeyCurves  []string `json:"keyCurves"`
+	} `json:"keyTypes"`
+	KeyReuse         *bool    `json:"keyReuse"`
+	SANRegexes       []string `json:"sanRegexes"`
+	SubjectCNRegexes []string `json:"subjectCNRegexes"`
+	SubjectORegexes  []string `json:"subjectORegexes"`
+	SubjectOURegexes []string `json:"subjectOURegexes"`
+	SubjectLRegexes  []string `json:"subjectLRegexes"`
+	SubjectSTRegexes []string `json:"subjectSTRegexes"`
+	SubjectCValues   []string `json:"subjectCValues"`
+	ValidityPeriod   string   `json:"validityPeriod"`
+}
+
+// cloudPolicy reads the issuing template of an application of Venafi as a
+// Service.
+func (g *DataGatherer) cloudPolicy(apiKey, zone string) (*ZonePolicy, error) {
+	application, alias, _ := strings.Cut(zone, `\`)
+	path := fmt.Sprintf("/outagedetection/v1/applications/name/%s/certificateissuingtemplates/%s", url.PathEscape(application), url.PathEscape(alias))
+	req, err := http.NewRequestWithContext(g.ctx, http.MethodGet, g.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(cloudAPIKeyHeader, apiKey)
+
+	var template cloudIssuingTemplate
+	if err := g.do(req, &template); err != nil {
+		return nil, err
+	}
+
+	policy := &ZonePolicy{
+		DomainPatterns: uniqueStrings(append(template.SubjectCNRegexes, template.SANRegexes...)),
+		KeyReuse:       template.KeyReuse,
+		Subject: SubjectPolicy{
+			Organizations:       template.SubjectORegexes,
+			OrganizationalUnits: template.SubjectOURegexes,
+			Localities:          template.SubjectLRegexes,
+			Provinces:           template.SubjectSTRegexes,
+			Countries:           template.SubjectCValues,
+		},
+		ValidityPeriod: template.ValidityPeriod,
+	}
+	for _, keyType := range template.KeyTypes {
+		policy.KeyTypes = append(policy.KeyTypes, KeyPolicy{Type: keyType.KeyType, Sizes: keyType.KeyLengths, Curves: keyType.KeyCurves})
+	}
+	return policy, nil
+}
+
+// do sends the request and decodes the JSON response.
+func (g *DataGatherer) do(req *http.Request, response interface{}) error {
+	res, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: unexpected status code %d: %s", req.Method, req.URL.Path, res.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("%s %s: failed to decode the response: %w", req.Method, req.URL.Path, err)
+	}
+	return nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,177 @@
+package venafi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func writeCredentials(t *testing.T, credentials string) string {
+	path := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(path, []byte(credentials+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func fetchPolicies(t *testing.T, config *Config) []*ZonePolicy {
+	dg, err := config.NewDataGatherer(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data, count, err := dg.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count != len(config.Zones) {
+		t.Errorf("expected %d policies, got %d", len(config.Zones), count)
+	}
+	return data.(map[string]interface{})["policies"].([]*ZonePolicy)
+}
+
+func TestFetchTPP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != tppCheckPolicyPath || r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unexpected request", http.StatusUnauthorized)
+			return
+		}
+		var request struct{ PolicyDN string }
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		if request.PolicyDN != `\VED\Policy\Kubernetes` {
+			json.NewEncoder(w).Encode(map[string]interface{}{"Error": "Policy folder does not exist"})
+			return
+		}
+		w.Write([]byte(`{
+  "Error": "",
+  "Policy": {
+    "KeyPair": {
+      "KeyAlgorithm": {"Locked": true, "Value": "RSA"},
+      "KeySize": {"Locked": false, "Value": 2048}
+    },
+    "Subject": {
+      "Organization": {"Locked": true, "Value": "Example Corp"},
+      "OrganizationalUnit": {"Locked": false, "Values": ["Platform"]},
+      "Country": {"Locked": false, "Value": "GB"}
+    },
+    "WhitelistedDomains": ["example.com"],
+    "WildcardsAllowed": false
+  }
+}`))
+	}))
+	defer server.Close()
+
+	policies := fetchPolicies(t, &Config{
+		Platform:        PlatformTPP,
+		URL:             server.URL,
+		Zones:           []string{`\VED\Policy\Kubernetes`, `\VED\Policy\Missing`},
+		CredentialsPath: writeCredentials(t, "token"),
+	})
+
+	wildcards := false
+	expected := []*ZonePolicy{
+		{
+			Zone:             `\VED\Policy\Kubernetes`,
+			Platform:         PlatformTPP,
+			AllowedDomains:   []string{"example.com"},
+			WildcardsAllowed: &wildcards,
+			KeyTypes:         []KeyPolicy{{Type: "RSA", Sizes: []int{2048}}},
+			Subject: SubjectPolicy{
+				Organizations:       []string{"Example Corp"},
+				OrganizationalUnits: []string{"Platform"},
+				Countries:           []string{"GB"},
+			},
+			Locked: []string{"KeyAlgorithm", "Organization"},
+		},
+		{
+			Zone:     `\VED\Policy\Missing`,
+			Platform: PlatformTPP,
+			Error:    "Policy folder does not exist",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, policies); !equal {
+		t.Errorf("unexpected policies:\n%s", diff)
+	}
+}
+
+func TestFetchCloud(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(cloudAPIKeyHeader) != "api-key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/outagedetection/v1/applications/name/my app/certificateissuingtemplates/Default" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{
+  "keyTypes": [{"keyType": "RSA", "keyLengths": [2048, 4096]}, {"keyType": "EC", "keyCurves": ["P256"]}],
+  "keyReuse": false,
+  "subjectCNRegexes": [".*\\.example\\.com"],
+  "sanRegexes": [".*\\.example\\.com", ".*\\.example\\.org"],
+  "subjectCValues": ["GB"],
+  "validityPeriod": "P90D"
+}`))
+	}))
+	defer server.Close()
+
+	policies := fetchPolicies(t, &Config{
+		Platform:        PlatformCloud,
+		URL:             server.URL,
+		Zones:           []string{`my app\Default`, `my app\Missing`},
+		CredentialsPath: writeCredentials(t, "api-key"),
+	})
+
+	keyReuse := false
+	expected := []*ZonePolicy{
+		{
+			Zone:           `my app\Default`,
+			Platform:       PlatformCloud,
+			DomainPatterns: []string{`.*\.example\.com`, `.*\.example\.org`},
+			KeyTypes: []KeyPolicy{
+				{Type: "RSA", Sizes: []int{2048, 4096}},
+				{Type: "EC", Curves: []string{"P256"}},
+			},
+			KeyReuse:       &keyReuse,
+			Subject:        SubjectPolicy{Countries: []string{"GB"}},
+			ValidityPeriod: "P90D",
+		},
+		{
+			Zone:     `my app\Missing`,
+			Platform: PlatformCloud,
+			Error:    "GET /outagedetection/v1/applications/name/my app/certificateissuingtemplates/Missing: unexpected status code 404: 404 page not found",
+		},
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, policies); !equal {
+		t.Errorf("unexpected policies:\n%s", diff)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]struct {
+		config  Config
+		wantErr bool
+	}{
+		"tpp":                 {config: Config{Platform: PlatformTPP, URL: "https://tpp.example.com", Zones: []string{`\VED\Policy`}, CredentialsPath: "token"}},
+		"cloud":               {config: Config{Platform: PlatformCloud, Zones: []string{`app\alias`}, CredentialsPath: "key"}},
+		"unknown platform":    {config: Config{Platform: "other", Zones: []string{"zone"}, CredentialsPath: "key"}, wantErr: true},
+		"tpp without url":     {config: Config{Platform: PlatformTPP, Zones: []string{`\VED\Policy`}, CredentialsPath: "token"}, wantErr: true},
+		"cloud zone no alias": {config: Config{Platform: PlatformCloud, Zones: []string{"app"}, CredentialsPath: "key"}, wantErr: true},
+		"no zones":            {config: Config{Platform: PlatformCloud, CredentialsPath: "key"}, wantErr: true},
+		"no credentials":      {config: Config{Platform: PlatformCloud, Zones: []string{`app\alias`}}, wantErr: true},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := test.config.Validate(); (err != nil) != test.wantErr {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,427 @@
+// Package diff compares two archived sets of data readings, as written by the
+// agent when an output path is configured.
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+	"github.com/jetstack/preflight/pkg/datagatherer/k8s"
+)
+
+// DefaultExpiryWindow is how soon the certificates must expire to be
+// reported as expiring by default.
+const DefaultExpiryWindow = 30 * 24 * time.Hour
+
+// LoadReadings reads an archive of data readings. Both the list of readings
+// written by the agent's --output-path and a full upload payload, as printed
+// by the echo server, are accepted.
+func LoadReadings(path string) ([]*api.DataReading, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var readings []*api.DataReading
+	if err := json.Unmarshal(data, &readings); err == nil {
+		return readings, nil
+	}
+
+	var payload api.DataReadingsPost
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse %s as data readings: %w", path, err)
+	}
+
+	return payload.DataReadings, nil
+}
+
+// Result is the difference between two sets of data readings.
+type Result struct {
+	DataGatherers []*DataGathererDiff `json:"data_gatherers"`
+}
+
+// DataGathererDiff is the difference between the readings of a single data
+// gatherer.
+type DataGathererDiff struct {
+	DataGatherer string `json:"data_gatherer"`
+	// OnlyBefore and OnlyAfter are set when the data gatherer is missing
+	// from one of the sets of readings.
+	OnlyBefore bool `json:"only_before,omitempty"`
+	OnlyAfter  bool `json:"only_after,omitempty"`
+	// SizeBefore and SizeAfter are the sizes in bytes of the JSON encoded
+	// data of the reading.
+	SizeBefore int `json:"size_before"`
+	SizeAfter  int `json:"size_after"`
+
+	AddedResources   []string `json:"added_resources,omitempty"`
+	RemovedResources []string `json:"removed_resources,omitempty"`
+	ChangedResources []string `json:"changed_resources,omitempty"`
+	AddedFindings    []string `json:"added_findings,omitempty"`
+	RemovedFindings  []string `json:"removed_findings,omitempty"`
+	// ExpiringCertificates are the certificates of the TLS Secrets and
+	// cert-manager Certificates that expire within the expiry window of the
+	// time of the after reading, but didn't within that of the before one.
+	ExpiringCertificates []string `json:"expiring_certificates,omitempty"`
+	// DataChanged is set when the data of the reading differs in any way,
+	// including for readings that are not lists of resources or findings.
+	DataChanged bool `json:"data_changed"`
+}
+
+// Readings compares two sets of data readings, matching readings by data
+// gatherer name. The certificates expiring within expiryWindow are only
+// reported if it is positive.
+func Readings(before, after []*api.DataReading, expiryWindow time.Duration) (*Result, error) {
+	beforeByName := readingsByDataGatherer(before)
+	afterByName := readingsByDataGatherer(after)
+
+	names := map[string]bool{}
+	for name := range beforeByName {
+		names[name] = true
+	}
+	for name := range afterByName {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	result := &Result{DataGatherers: []*DataGathererDiff{}}
+	for _, name := range sorted {
+		d, err := dataGatherer(name, beforeByName[name], afterByName[name], expiryWindow)
+		if err != nil {
+			return nil, err
+		}
+		result.DataGatherers = append(result.DataGatherers, d)
+	}
+
+	return result, nil
+}
+
+func readingsByDataGatherer(readings []*api.DataReading) map[string]*api.DataReading {
+	byName := map[string]*api.DataReading{}
+	for _, r := range readings {
+		byName[r.DataGatherer] = r
+	}
+	return byName
+}
+
+func dataGatherer(name string, before, after *api.DataReading, expiryWindow time.Duration) (*DataGathererDiff, error) {
+	d := &DataGathererDiff{
+		DataGatherer: name,
+		OnlyBefore:   after == nil,
+		OnlyAfter:    before == nil,
+	}
+
+	var beforeData, afterData interface{}
+	var beforeFindingList, afterFindingList []api.Finding
+	if before != nil {
+		beforeData = before.Data
+		beforeFindingList = before.Findings
+	}
+	if after != nil {
+		afterData = after.Data
+		afterFindingList = after.Findings
+	}
+
+	var err error
+	if d.SizeBefore, err = jsonSize(beforeData); err != nil {
+		return nil, err
+	}
+	if d.SizeAfter, err = jsonSize(afterData); err != nil {
+		return nil, err
+	}
+	d.DataChanged = !reflect.DeepEqual(beforeData, afterData) || !reflect.DeepEqual(beforeFindingList, afterFindingList)
+
+	beforeResources := resources(beforeData)
+	afterResources := resources(afterData)
+	for key, resource := range afterResources {
+		previous, ok := beforeResources[key]
+		if !ok {
+			d.AddedResources = append(d.AddedResources, key)
+		} else if !reflect.DeepEqual(previous, resource) {
+			d.ChangedResources = append(d.ChangedResources, key)
+		}
+	}
+	for key := range beforeResources {
+		if _, ok := afterResources[key]; !ok {
+			d.RemovedResources = append(d.RemovedResources, key)
+		}
+	}
+
+	beforeFindings := findings(beforeData, beforeFindingList)
+	afterFindings := findings(afterData, afterFindingList)
+	for key := range afterFindings {
+		if !beforeFindings[key] {
+			d.AddedFindings = append(d.AddedFindings, key)
+		}
+	}
+	for key := range beforeFindings {
+		if !afterFindings[key] {
+			d.RemovedFindings = append(d.RemovedFindings, key)
+		}
+	}
+
+	if expiryWindow > 0 && after != nil {
+		d.ExpiringCertificates = newlyExpiring(before, after, expiryWindow)
+	}
+
+	for _, list := range [][]string{d.AddedResources, d.RemovedResources, d.ChangedResources, d.AddedFindings, d.RemovedFindings, d.ExpiringCertificates} {
+		sort.Strings(list)
+	}
+
+	return d, nil
+}
+
+func jsonSize(data interface{}) (int, error) {
+	if data == nil {
+		return 0, nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal data: %w", err)
+	}
+	return len(b), nil
+}
+
+// resources returns the resources of a reading with a list of `items`, as
+// produced by the k8s-dynamic data gatherer, keyed by their identity. The
+// resourceVersion is ignored when comparing resources.
+func resources(data interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	items, _ := m["items"].([]interface{})
+	for _, item := range items {
+		wrapper, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resource, ok := wrapper["resource"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		result[resourceKey(resource)] = withoutResourceVersion(wrapper)
+	}
+	return result
+}
+
+func resourceKey(resource map[string]interface{}) string {
+	apiVersion, _ := resource["apiVersion"].(string)
+	kind, _ := resource["kind"].(string)
+	metadata, _ := resource["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	namespace, _ := metadata["namespace"].(string)
+	if namespace != "" {
+		name = namespace + "/" + name
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", apiVersion, kind, name))
+}
+
+func withoutResourceVersion(wrapper map[string]interface{}) map[string]interface{} {
+	resource := wrapper["resource"].(map[string]interface{})
+	metadata, ok := resource["metadata"].(map[string]interface{})
+	if !ok {
+		return wrapper
+	}
+	if _, ok := metadata["resourceVersion"]; !ok {
+		return wrapper
+	}
+
+	copiedMetadata := map[string]interface{}{}
+	for k, v := range metadata {
+		if k != "resourceVersion" {
+			copiedMetadata[k] = v
+		}
+	}
+	copiedResource := map[string]interface{}{}
+	for k, v := range resource {
+		copiedResource[k] = v
+	}
+	copiedResource["metadata"] = copiedMetadata
+	copiedWrapper := map[string]interface{}{}
+	for k, v := range wrapper {
+		copiedWrapper[k] = v
+	}
+	copiedWrapper["resource"] = copiedResource
+
+	return copiedWrapper
+}
+
+// newlyExpiring returns the certificates of the after reading expiring
+// within the window of its time, which were missing from the before reading
+// or didn't expire within the window of its time.
+func newlyExpiring(before, after *api.DataReading, window time.Duration) []string {
+	wasExpiring := map[string]bool{}
+	if before != nil {
+		now := readingTime(before)
+		for key, notAfter := range certificates(before.Data) {
+			wasExpiring[key] = notAfter.Sub(now) < window
+		}
+	}
+	now := readingTime(after)
+	var result []string
+	for key, notAfter := range certificates(after.Data) {
+		left := notAfter.Sub(now)
+		if left >= window || wasExpiring[key] {
+			continue
+		}
+		if left < 0 {
+			result = append(result, fmt.Sprintf("%s expired at %s", key, notAfter.Format(time.RFC3339)))
+		} else {
+			result = append(result, fmt.Sprintf("%s expires at %s, in %d days", key, notAfter.Format(time.RFC3339), int(left.Hours()/24)))
+		}
+	}
+	return result
+}
+
+// readingTime returns the time a reading was gathered at, or now for the
+// readings without a timestamp.
+func readingTime(reading *api.DataReading) time.Time {
+	if reading.Timestamp.IsZero() {
+		return time.Now()
+	}
+	return reading.Timestamp.Time
+}
+
+// certificates returns the expiries of the certificates of the TLS Secrets
+// and cert-manager Certificates of a reading, keyed by kind, namespace and
+// name, from its resources or, in the findings report mode, its summary.
+func certificates(data interface{}) map[string]time.Time {
+	result := map[string]time.Time{}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	items, _ := m["items"].([]interface{})
+	for _, item := range items {
+		wrapper, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		resource, ok := wrapper["resource"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if deletedAt, _ := wrapper["deleted_at"].(string); deletedAt != "" {
+			continue
+		}
+		expiry, ok := k8s.CertificateExpiryOf(&unstructured.Unstructured{Object: resource})
+		if ok && expiry.NotAfter != nil {
+			result[api.ResourceRef{Kind: expiry.Kind, Namespace: expiry.Namespace, Name: expiry.Name}.String()] = expiry.NotAfter.Time
+		}
+	}
+	summary, _ := m["certificates"].([]interface{})
+	for _, c := range summary {
+		expiry, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		notAfter, _ := expiry["notAfter"].(string)
+		t, err := time.Parse(time.RFC3339, notAfter)
+		if err != nil {
+			continue
+		}
+		ref := api.ResourceRef{}
+		ref.Kind, _ = expiry["kind"].(string)
+		ref.Namespace, _ = expiry["namespace"].(string)
+		ref.Name, _ = expiry["name"].(string)
+		result[ref.String()] = t
+	}
+	return result
+}
+
+// findings returns the `findings` of a reading, as produced by the analysis
+// data gatherers, formatted as strings.
+// findings returns the keys of the findings of a reading. Readings archived
+// before findings had a section of their own have them in their data.
+func findings(data interface{}, list []api.Finding) map[string]bool {
+	result := map[string]bool{}
+	for _, f := range list {
+		result[fmt.Sprintf("%s %s: %s", f.RuleID, f.Resource, f.Message)] = true
+	}
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return result
+	}
+	legacy, _ := m["findings"].([]interface{})
+	for _, f := range legacy {
+		result[findingKey(f)] = true
+	}
+	return result
+}
+
+func findingKey(finding interface{}) string {
+	m, ok := finding.(map[string]interface{})
+	if ok {
+		findingType, _ := m["type"].(string)
+		message, _ := m["message"].(string)
+		name, _ := m["name"].(string)
+		if namespace, _ := m["namespace"].(string); namespace != "" {
+			name = namespace + "/" + name
+		}
+		if findingType != "" && message != "" {
+			return fmt.Sprintf("%s %s: %s", findingType, name, message)
+		}
+	}
+	b, _ := json.Marshal(finding)
+	return string(b)
+}
+
+// Print writes a human readable summary of the result. Data gatherers without
+// changes or newly expiring certificates are omitted.
+func (r *Result) Print(w io.Writer) {
+	changed := 0
+	for _, d := range r.DataGatherers {
+		if !d.DataChanged && len(d.ExpiringCertificates) == 0 {
+			continue
+		}
+		changed++
+
+		switch {
+		case d.OnlyBefore:
+			fmt.Fprintf(w, "- %s (removed, was %d bytes)\n", d.DataGatherer, d.SizeBefore)
+		case d.OnlyAfter:
+			fmt.Fprintf(w, "+ %s (added, %d bytes)\n", d.DataGatherer, d.SizeAfter)
+		default:
+			fmt.Fprintf(w, "~ %s (%d -> %d bytes, %+d)\n", d.DataGatherer, d.SizeBefore, d.SizeAfter, d.SizeAfter-d.SizeBefore)
+		}
+
+		printList(w, "added resource", "+", d.AddedResources)
+		printList(w, "removed resource", "-", d.RemovedResources)
+		printList(w, "changed resource", "~", d.ChangedResources)
+		printList(w, "new finding", "+", d.AddedFindings)
+		printList(w, "resolved finding", "-", d.RemovedFindings)
+		printList(w, "newly expiring certificate", "!", d.ExpiringCertificates)
+	}
+
+	if changed == 0 {
+		fmt.Fprintln(w, "no differences found")
+	}
+}
+
+// PrintJSON writes the result as indented JSON, for the pipelines reviewing
+// the changes.
+func (r *Result) PrintJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func printList(w io.Writer, label, prefix string, values []string) {
+	for _, v := range values {
+		fmt.Fprintf(w, "    %s %s: %s\n", prefix, label, v)
+	}
+}
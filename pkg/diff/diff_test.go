@@ -0,0 +1,172 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/d4l3k/messagediff"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func parseReadings(t *testing.T, data string) []*api.DataReading {
+	var readings []*api.DataReading
+	if err := json.Unmarshal([]byte(data), &readings); err != nil {
+		t.Fatalf("failed to parse readings: %s", err)
+	}
+	return readings
+}
+
+func TestReadings(t *testing.T) {
+	before := parseReadings(t, `[
+		{"data-gatherer": "k8s/pods", "data": {"items": [
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a", "namespace": "ns", "resourceVersion": "1"}}},
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "b", "namespace": "ns"}, "spec": {"nodeName": "x"}}},
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "c", "namespace": "ns"}}}
+		]}},
+		{"data-gatherer": "k8s-rbac", "data": {"findings": [
+			{"type": "wildcard-grant", "name": "old", "message": "clusterrole grants wildcard verbs, resources or API groups"}
+		]}},
+		{"data-gatherer": "removed", "data": {"items": []}}
+	]`)
+	after := parseReadings(t, `[
+		{"data-gatherer": "k8s/pods", "data": {"items": [
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "a", "namespace": "ns", "resourceVersion": "2"}}},
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "b", "namespace": "ns"}, "spec": {"nodeName": "y"}}},
+			{"resource": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "d", "namespace": "ns"}}}
+		]}},
+		{"data-gatherer": "k8s-rbac", "data": {}, "findings": [
+			{"rule_id": "cluster-admin-binding", "severity": "high", "resource": {"kind": "ClusterRoleBinding", "name": "new"}, "message": "clusterrolebinding grants cluster-admin to Group \"admins\""}
+		]}
+	]`)
+
+	result, err := Readings(before, after, DefaultExpiryWindow)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(result.DataGatherers) != 3 {
+		t.Fatalf("expected 3 data gatherers, got %d", len(result.DataGatherers))
+	}
+
+	rbac := result.DataGatherers[0]
+	if diff, equal := messagediff.PrettyDiff([]string{`cluster-admin-binding ClusterRoleBinding/new: clusterrolebinding grants cluster-admin to Group "admins"`}, rbac.AddedFindings); !equal {
+		t.Errorf("unexpected added findings:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"wildcard-grant old: clusterrole grants wildcard verbs, resources or API groups"}, rbac.RemovedFindings); !equal {
+		t.Errorf("unexpected removed findings:\n%s", diff)
+	}
+
+	pods := result.DataGatherers[1]
+	if diff, equal := messagediff.PrettyDiff([]string{"v1 Pod ns/d"}, pods.AddedResources); !equal {
+		t.Errorf("unexpected added resources:\n%s", diff)
+	}
+	if diff, equal := messagediff.PrettyDiff([]string{"v1 Pod ns/c"}, pods.RemovedResources); !equal {
+		t.Errorf("unexpected removed resources:\n%s", diff)
+	}
+	// a only differs by resourceVersion
+	if diff, equal := messagediff.PrettyDiff([]string{"v1 Pod ns/b"}, pods.ChangedResources); !equal {
+		t.Errorf("unexpected changed resources:\n%s", diff)
+	}
+
+	removed := result.DataGatherers[2]
+	if !removed.OnlyBefore || removed.SizeAfter != 0 {
+		t.Errorf("expected data gatherer to be reported as removed: %+v", removed)
+	}
+
+	var out bytes.Buffer
+	result.Print(&out)
+	for _, expected := range []string{
+		"~ k8s/pods",
+		"    + added resource: v1 Pod ns/d",
+		"- removed (removed, was 12 bytes)",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestReadingsNoDifferences(t *testing.T) {
+	readings := parseReadings(t, `[{"data-gatherer": "k8s/pods", "data": {"items": []}}]`)
+
+	result, err := Readings(readings, readings, DefaultExpiryWindow)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var out bytes.Buffer
+	result.Print(&out)
+	if out.String() != "no differences found\n" {
+		t.Errorf("unexpected output: %q", out.String())
+	}
+}
+
+func TestReadingsExpiringCertificates(t *testing.T) {
+	certificate := func(name, notAfter string) string {
+		return `{"resource": {"apiVersion": "cert-manager.io/v1", "kind": "Certificate", "metadata": {"name": "` + name + `", "namespace": "ns"}, "status": {"notAfter": "` + notAfter + `"}}}`
+	}
+	before := parseReadings(t, `[
+		{"data-gatherer": "k8s/certificates", "timestamp": "2024-01-01T00:00:00Z", "data": {"items": [
+			`+certificate("soon", "2024-02-15T00:00:00Z")+`,
+			`+certificate("expiring", "2024-01-20T00:00:00Z")+`
+		]}},
+		{"data-gatherer": "k8s/secrets", "timestamp": "2024-01-01T00:00:00Z", "data": {"resources": [], "certificates": []}}
+	]`)
+	after := parseReadings(t, `[
+		{"data-gatherer": "k8s/certificates", "timestamp": "2024-01-21T00:00:00Z", "data": {"items": [
+			`+certificate("soon", "2024-02-15T00:00:00Z")+`,
+			`+certificate("expiring", "2024-01-20T00:00:00Z")+`,
+			`+certificate("renewed", "2024-04-01T00:00:00Z")+`
+		]}},
+		{"data-gatherer": "k8s/secrets", "timestamp": "2024-01-21T00:00:00Z", "data": {"resources": [], "certificates": [
+			{"kind": "Secret", "namespace": "ns", "name": "tls", "notAfter": "2024-01-25T12:00:00Z"}
+		]}}
+	]`)
+
+	result, err := Readings(before, after, DefaultExpiryWindow)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string][]string{
+		"k8s/certificates": {"Certificate/ns/soon expires at 2024-02-15T00:00:00Z, in 25 days"},
+		"k8s/secrets":      {"Secret/ns/tls expires at 2024-01-25T12:00:00Z, in 4 days"},
+	}
+	expiring := map[string][]string{}
+	for _, d := range result.DataGatherers {
+		expiring[d.DataGatherer] = d.ExpiringCertificates
+	}
+	if diff, equal := messagediff.PrettyDiff(expected, expiring); !equal {
+		t.Errorf("unexpected expiring certificates:\n%s", diff)
+	}
+
+	var out bytes.Buffer
+	result.Print(&out)
+	if !strings.Contains(out.String(), "~ k8s/certificates (") || !strings.Contains(out.String(), "    ! newly expiring certificate: Certificate/ns/soon expires at 2024-02-15T00:00:00Z, in 25 days") {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := result.PrintJSON(&out); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var decoded Result
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(result, &decoded); !equal {
+		t.Errorf("unexpected JSON output:\n%s", diff)
+	}
+
+	result, err = Readings(before, after, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, d := range result.DataGatherers {
+		if len(d.ExpiringCertificates) > 0 {
+			t.Errorf("expected no expiring certificates without a window: %v", d.ExpiringCertificates)
+		}
+	}
+}
@@ -78,6 +78,7 @@ func prettyPrint(reading *api.DataReading) string {
 Data gatherer: %s
 Timestamp: %s
 SchemaVersion: %s
+DataVersion: %s
 Data: %+v`,
-		reading.ClusterID, reading.DataGatherer, reading.Timestamp, reading.SchemaVersion, reading.Data)
+		reading.ClusterID, reading.DataGatherer, reading.Timestamp, reading.SchemaVersion, reading.DataVersion, reading.Data)
 }
@@ -27,6 +27,7 @@ const agentSubjectName = "agent"
 func GenerateAgentRBACManifests(dataGatherers []agent.DataGatherer) AgentRBACManifests {
 	// create a new AgentRBACManifest struct
 	var AgentRBACManifests AgentRBACManifests
+	namespacesReader := false
 
 	for _, dg := range dataGatherers {
 		if dg.Kind != "k8s-dynamic" {
@@ -34,38 +35,74 @@ func GenerateAgentRBACManifests(dataGatherers []agent.DataGatherer) AgentRBACMan
 		}
 
 		dyConfig := dg.Config.(*k8s.ConfigDynamic)
-		metadataName := fmt.Sprintf("%s-agent-%s-reader", agentNamespace, dyConfig.GroupVersionResource.Resource)
 
-		AgentRBACManifests.ClusterRoles = append(AgentRBACManifests.ClusterRoles, rbac.ClusterRole{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "ClusterRole",
-				APIVersion: "rbac.authorization.k8s.io/v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name: metadataName,
-			},
-			Rules: []rbac.PolicyRule{
-				{
-					Verbs:     []string{"get", "list", "watch"},
-					APIGroups: []string{dyConfig.GroupVersionResource.Group},
-					Resources: []string{dyConfig.GroupVersionResource.Resource},
+		// the namespaces matching a label selector are listed on each fetch
+		if dyConfig.IncludeNamespaceLabelSelector != "" && !namespacesReader {
+			namespacesReader = true
+			addNamespacesReader(&AgentRBACManifests)
+		}
+
+		for _, gvr := range dyConfig.GroupVersionResources() {
+			metadataName := fmt.Sprintf("%s-agent-%s-reader", agentNamespace, gvr.Resource)
+
+			AgentRBACManifests.ClusterRoles = append(AgentRBACManifests.ClusterRoles, rbac.ClusterRole{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "ClusterRole",
+					APIVersion: "rbac.authorization.k8s.io/v1",
 				},
-			},
-		})
+				ObjectMeta: metav1.ObjectMeta{
+					Name: metadataName,
+				},
+				Rules: []rbac.PolicyRule{
+					{
+						Verbs:     []string{"get", "list", "watch"},
+						APIGroups: []string{gvr.Group},
+						Resources: []string{gvr.Resource},
+					},
+				},
+			})
+
+			// if dyConfig.IncludeNamespaces has more than 0 items in it
+			//   then, for each namespace create a rbac.RoleBinding in that namespace
+			// patterns and label selectors can match any namespace, so they need a ClusterRoleBinding
+			if len(dyConfig.IncludeNamespaces) != 0 && !hasNamespacePatterns(dyConfig.IncludeNamespaces) && dyConfig.IncludeNamespaceLabelSelector == "" {
+				for _, ns := range dyConfig.IncludeNamespaces {
+					AgentRBACManifests.RoleBindings = append(AgentRBACManifests.RoleBindings, rbac.RoleBinding{
+						TypeMeta: metav1.TypeMeta{
+							Kind:       "RoleBinding",
+							APIVersion: "rbac.authorization.k8s.io/v1",
+						},
 
-		// if dyConfig.IncludeNamespaces has more than 0 items in it
-		//   then, for each namespace create a rbac.RoleBinding in that namespace
-		if len(dyConfig.IncludeNamespaces) != 0 {
-			for _, ns := range dyConfig.IncludeNamespaces {
-				AgentRBACManifests.RoleBindings = append(AgentRBACManifests.RoleBindings, rbac.RoleBinding{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      metadataName,
+							Namespace: ns,
+						},
+
+						Subjects: []rbac.Subject{
+							{
+								Kind:      "ServiceAccount",
+								Name:      agentSubjectName,
+								Namespace: agentNamespace,
+							},
+						},
+
+						RoleRef: rbac.RoleRef{
+							Kind:     "ClusterRole",
+							Name:     metadataName,
+							APIGroup: "rbac.authorization.k8s.io",
+						},
+					})
+				}
+			} else {
+				// only do this if the dg does not have IncludeNamespaces set
+				AgentRBACManifests.ClusterRoleBindings = append(AgentRBACManifests.ClusterRoleBindings, rbac.ClusterRoleBinding{
 					TypeMeta: metav1.TypeMeta{
-						Kind:       "RoleBinding",
+						Kind:       "ClusterRoleBinding",
 						APIVersion: "rbac.authorization.k8s.io/v1",
 					},
 
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      metadataName,
-						Namespace: ns,
+						Name: metadataName,
 					},
 
 					Subjects: []rbac.Subject{
@@ -83,37 +120,63 @@ func GenerateAgentRBACManifests(dataGatherers []agent.DataGatherer) AgentRBACMan
 					},
 				})
 			}
-		} else {
-			// only do this if the dg does not have IncludeNamespaces set
-			AgentRBACManifests.ClusterRoleBindings = append(AgentRBACManifests.ClusterRoleBindings, rbac.ClusterRoleBinding{
-				TypeMeta: metav1.TypeMeta{
-					Kind:       "ClusterRoleBinding",
-					APIVersion: "rbac.authorization.k8s.io/v1",
-				},
+		}
+	}
 
-				ObjectMeta: metav1.ObjectMeta{
-					Name: metadataName,
-				},
+	return AgentRBACManifests
+}
 
-				Subjects: []rbac.Subject{
-					{
-						Kind:      "ServiceAccount",
-						Name:      agentSubjectName,
-						Namespace: agentNamespace,
-					},
-				},
+// addNamespacesReader grants the agent permission to list namespaces.
+func addNamespacesReader(manifests *AgentRBACManifests) {
+	metadataName := fmt.Sprintf("%s-agent-namespaces-reader", agentNamespace)
+
+	manifests.ClusterRoles = append(manifests.ClusterRoles, rbac.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRole",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: metadataName,
+		},
+		Rules: []rbac.PolicyRule{
+			{
+				Verbs:     []string{"get", "list"},
+				APIGroups: []string{""},
+				Resources: []string{"namespaces"},
+			},
+		},
+	})
+
+	manifests.ClusterRoleBindings = append(manifests.ClusterRoleBindings, rbac.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ClusterRoleBinding",
+			APIVersion: "rbac.authorization.k8s.io/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: metadataName,
+		},
+		Subjects: []rbac.Subject{
+			{
+				Kind:      "ServiceAccount",
+				Name:      agentSubjectName,
+				Namespace: agentNamespace,
+			},
+		},
+		RoleRef: rbac.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     metadataName,
+			APIGroup: "rbac.authorization.k8s.io",
+		},
+	})
+}
 
-				RoleRef: rbac.RoleRef{
-					Kind:     "ClusterRole",
-					Name:     metadataName,
-					APIGroup: "rbac.authorization.k8s.io",
-				},
-			})
+func hasNamespacePatterns(namespaces []string) bool {
+	for _, ns := range namespaces {
+		if k8s.IsNamespacePattern(ns) {
+			return true
 		}
-
 	}
-
-	return AgentRBACManifests
+	return false
 }
 
 func createClusterRoleString(clusterRoles []rbac.ClusterRole) string {
@@ -0,0 +1,405 @@
+// Package query loads data readings into an embedded SQLite database, so that
+// the gathered data can be analysed locally with SQL.
+package query
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	// registers the pure Go "sqlite" database/sql driver
+	_ "modernc.org/sqlite"
+
+	"github.com/jetstack/preflight/api"
+)
+
+// SchemaVersion is the version of the database schema, stored as the
+// user_version of the database. It is incremented whenever the schema
+// changes in a way that breaks existing queries.
+const SchemaVersion = 1
+
+// schema creates the tables. Timestamps are stored as RFC 3339 strings and
+// the data of readings and resources as JSON, which can be queried with the
+// SQLite JSON functions.
+const schema = `
+CREATE TABLE IF NOT EXISTS cycles (
+	id INTEGER PRIMARY KEY,
+	gathered_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS readings (
+	id INTEGER PRIMARY KEY,
+	cycle_id INTEGER NOT NULL REFERENCES cycles(id) ON DELETE CASCADE,
+	data_gatherer TEXT NOT NULL,
+	cluster_id TEXT NOT NULL,
+	timestamp TEXT NOT NULL,
+	schema_version TEXT NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS resources (
+	id INTEGER PRIMARY KEY,
+	reading_id INTEGER NOT NULL REFERENCES readings(id) ON DELETE CASCADE,
+	api_version TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	name TEXT NOT NULL,
+	uid TEXT NOT NULL,
+	deleted_at TEXT,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS certificates (
+	id INTEGER PRIMARY KEY,
+	resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
+	chain_index INTEGER NOT NULL,
+	subject TEXT NOT NULL,
+	issuer TEXT NOT NULL,
+	serial_number TEXT NOT NULL,
+	dns_names TEXT NOT NULL,
+	not_before TEXT NOT NULL,
+	not_after TEXT NOT NULL,
+	is_ca INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS images (
+	id INTEGER PRIMARY KEY,
+	resource_id INTEGER NOT NULL REFERENCES resources(id) ON DELETE CASCADE,
+	container TEXT NOT NULL,
+	image TEXT NOT NULL,
+	repository TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	digest TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS resources_kind ON resources(kind, namespace, name);
+CREATE INDEX IF NOT EXISTS certificates_not_after ON certificates(not_after);
+CREATE INDEX IF NOT EXISTS images_repository ON images(repository, tag);
+`
+
+// DB is a database of data readings.
+type DB struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it if it doesn't exist.
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	if version != 0 && version != SchemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("database %s has schema version %d, expected %d", path, version, SchemaVersion)
+	}
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create database schema: %w", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", SchemaVersion)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to set database schema version: %w", err)
+	}
+
+	return &DB{db: db}, nil
+}
+
+// OpenReadOnly opens an existing database without allowing changes to it.
+func OpenReadOnly(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	return &DB{db: db}, nil
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Load stores the readings of a cycle, keeping only the most recent
+// keepCycles cycles. If keepCycles is 0, all cycles are kept.
+func (d *DB) Load(readings []*api.DataReading, gatheredAt time.Time, keepCycles int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO cycles (gathered_at) VALUES (?)", formatTime(gatheredAt))
+	if err != nil {
+		return fmt.Errorf("failed to insert cycle: %w", err)
+	}
+	cycleID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, reading := range readings {
+		if err := loadReading(tx, cycleID, reading); err != nil {
+			return fmt.Errorf("failed to load reading %q: %w", reading.DataGatherer, err)
+		}
+	}
+
+	if keepCycles > 0 {
+		if _, err := tx.Exec("DELETE FROM cycles WHERE id NOT IN (SELECT id FROM cycles ORDER BY id DESC LIMIT ?)", keepCycles); err != nil {
+			return fmt.Errorf("failed to delete old cycles: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// gatheredItems is the data of the readings of the k8s-dynamic data
+// gatherers.
+type gatheredItems struct {
+	Items []struct {
+		Resource  json.RawMessage `json:"resource"`
+		DeletedAt string          `json:"deleted_at"`
+	} `json:"items"`
+}
+
+func loadReading(tx *sql.Tx, cycleID int64, reading *api.DataReading) error {
+	data, err := json.Marshal(reading.Data)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		"INSERT INTO readings (cycle_id, data_gatherer, cluster_id, timestamp, schema_version, data) VALUES (?, ?, ?, ?, ?, ?)",
+		cycleID, reading.DataGatherer, reading.ClusterID, formatTime(reading.Timestamp.Time), reading.SchemaVersion, string(data),
+	)
+	if err != nil {
+		return err
+	}
+	readingID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	// only readings made of Kubernetes resources have their resources
+	// loaded, the data of other readings can be queried as JSON
+	var items gatheredItems
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil
+	}
+	for _, item := range items.Items {
+		if err := loadResource(tx, readingID, item.Resource, item.DeletedAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type resourceMeta struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		UID       string `json:"uid"`
+	} `json:"metadata"`
+}
+
+func loadResource(tx *sql.Tx, readingID int64, resource json.RawMessage, deletedAt string) error {
+	var meta resourceMeta
+	if err := json.Unmarshal(resource, &meta); err != nil {
+		return fmt.Errorf("failed to parse resource: %w", err)
+	}
+
+	var deleted interface{}
+	if deletedAt != "" {
+		deleted = deletedAt
+	}
+	res, err := tx.Exec(
+		"INSERT INTO resources (reading_id, api_version, kind, namespace, name, uid, deleted_at, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		readingID, meta.APIVersion, meta.Kind, meta.Metadata.Namespace, meta.Metadata.Name, meta.Metadata.UID, deleted, string(resource),
+	)
+	if err != nil {
+		return err
+	}
+	resourceID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if meta.Kind == "Secret" {
+		if err := loadCertificates(tx, resourceID, resource); err != nil {
+			return err
+		}
+	}
+	return loadImages(tx, resourceID, resource)
+}
+
+// loadCertificates stores the certificates of the `tls.crt` of a Secret.
+func loadCertificates(tx *sql.Tx, resourceID int64, resource json.RawMessage) error {
+	var secret struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(resource, &secret); err != nil {
+		return nil
+	}
+	encoded, ok := secret.Data["tls.crt"]
+	if !ok {
+		return nil
+	}
+	rest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+
+	for index := 0; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		_, err = tx.Exec(
+			"INSERT INTO certificates (resource_id, chain_index, subject, issuer, serial_number, dns_names, not_before, not_after, is_ca) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			resourceID, index, cert.Subject.String(), cert.Issuer.String(), cert.SerialNumber.String(),
+			strings.Join(cert.DNSNames, ","), formatTime(cert.NotBefore), formatTime(cert.NotAfter), cert.IsCA,
+		)
+		if err != nil {
+			return err
+		}
+		index++
+	}
+}
+
+type podSpec struct {
+	InitContainers []container `json:"initContainers"`
+	Containers     []container `json:"containers"`
+}
+
+type container struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// loadImages stores the container images of Pods and of the resources with a
+// Pod template.
+func loadImages(tx *sql.Tx, resourceID int64, resource json.RawMessage) error {
+	var workload struct {
+		Spec struct {
+			podSpec
+			Template struct {
+				Spec podSpec `json:"spec"`
+			} `json:"template"`
+			JobTemplate struct {
+				Spec struct {
+					Template struct {
+						Spec podSpec `json:"spec"`
+					} `json:"template"`
+				} `json:"spec"`
+			} `json:"jobTemplate"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(resource, &workload); err != nil {
+		return nil
+	}
+
+	var containers []container
+	for _, spec := range []podSpec{workload.Spec.podSpec, workload.Spec.Template.Spec, workload.Spec.JobTemplate.Spec.Template.Spec} {
+		containers = append(containers, spec.InitContainers...)
+		containers = append(containers, spec.Containers...)
+	}
+	for _, c := range containers {
+		if c.Image == "" {
+			continue
+		}
+		repository, tag, digest := splitImage(c.Image)
+		_, err := tx.Exec(
+			"INSERT INTO images (resource_id, container, image, repository, tag, digest) VALUES (?, ?, ?, ?, ?, ?)",
+			resourceID, c.Name, c.Image, repository, tag, digest,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitImage splits an image reference into its repository, tag and digest.
+func splitImage(image string) (repository, tag, digest string) {
+	repository = image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+	// a colon after the last slash separates the tag, otherwise it is the
+	// port of the registry
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
+
+// Query runs a query and prints the rows as a table.
+func (d *DB) Query(w io.Writer, query string) error {
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			cells[i] = formatValue(value)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return tw.Flush()
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,172 @@
+package query
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/jetstack/preflight/api"
+)
+
+func testCertificate(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "www.example.com"},
+		NotBefore:    notAfter.Add(-24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func testReadings(t *testing.T, notAfter time.Time) []*api.DataReading {
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "example-tls", "namespace": "default", "uid": "uid-1"},
+		"data":       map[string]interface{}{"tls.crt": testCertificate(t, notAfter)},
+	}}
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "default", "uid": "uid-2"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "nginx", "image": "registry.example.com:5000/nginx:1.25"},
+					},
+				},
+			},
+		},
+	}}
+
+	return []*api.DataReading{
+		{
+			ClusterID:    "my-cluster",
+			DataGatherer: "k8s/secrets",
+			Timestamp:    api.Time{Time: notAfter},
+			Data:         map[string]interface{}{"items": []*api.GatheredResource{{Resource: secret}}},
+		},
+		{
+			ClusterID:    "my-cluster",
+			DataGatherer: "k8s/deployments",
+			Timestamp:    api.Time{Time: notAfter},
+			Data:         map[string]interface{}{"items": []*api.GatheredResource{{Resource: deployment}}},
+		},
+		{
+			ClusterID:    "my-cluster",
+			DataGatherer: "k8s-discovery",
+			Timestamp:    api.Time{Time: notAfter},
+			Data:         map[string]interface{}{"server_version": map[string]interface{}{"gitVersion": "v1.28.3"}},
+		},
+	}
+}
+
+func TestLoadAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	notAfter := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := db.Load(testReadings(t, notAfter), notAfter, 2); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	db, err = OpenReadOnly(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer db.Close()
+
+	tests := map[string]struct {
+		query    string
+		expected string
+	}{
+		"old cycles are deleted": {
+			query: "SELECT COUNT(*) AS cycles, (SELECT COUNT(*) FROM resources) AS resources FROM cycles",
+			expected: `CYCLES  RESOURCES
+2       4
+`,
+		},
+		"certificates": {
+			query: "SELECT r.namespace, r.name, c.subject, c.dns_names, c.not_after FROM certificates c JOIN resources r ON r.id = c.resource_id LIMIT 1",
+			expected: `NAMESPACE  NAME         SUBJECT         DNS_NAMES                    NOT_AFTER
+default    example-tls  CN=example.com  example.com,www.example.com  2024-06-01T00:00:00Z
+`,
+		},
+		"images": {
+			query: "SELECT DISTINCT container, repository, tag FROM images",
+			expected: `CONTAINER  REPOSITORY                       TAG
+nginx      registry.example.com:5000/nginx  1.25
+`,
+		},
+		"JSON data": {
+			query: "SELECT DISTINCT json_extract(data, '$.server_version.gitVersion') AS version FROM readings WHERE data_gatherer = 'k8s-discovery'",
+			expected: `VERSION
+v1.28.3
+`,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := db.Query(&out, tc.query); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if out.String() != tc.expected {
+				t.Errorf("unexpected output:\n%s\nwant:\n%s", out.String(), tc.expected)
+			}
+		})
+	}
+
+	// the database can't be changed when opened read-only
+	var out bytes.Buffer
+	if err := db.Query(&out, "DELETE FROM cycles"); err == nil || !strings.Contains(err.Error(), "readonly") {
+		t.Errorf("expected a read-only error, got %v", err)
+	}
+}
+
+func TestSplitImage(t *testing.T) {
+	tests := map[string][3]string{
+		"nginx":                               {"nginx", "", ""},
+		"nginx:1.25":                          {"nginx", "1.25", ""},
+		"registry:5000/nginx":                 {"registry:5000/nginx", "", ""},
+		"registry:5000/nginx:1.25@sha256:abc": {"registry:5000/nginx", "1.25", "sha256:abc"},
+		"nginx@sha256:abc":                    {"nginx", "", "sha256:abc"},
+	}
+	for image, expected := range tests {
+		repository, tag, digest := splitImage(image)
+		if got := [3]string{repository, tag, digest}; got != expected {
+			t.Errorf("splitImage(%q) = %q, want %q", image, got, expected)
+		}
+	}
+}
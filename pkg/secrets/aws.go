@@ -0,0 +1,95 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/cloudauth"
+)
+
+// AWSConfig configures access to AWS Secrets Manager. Secrets are referenced
+// as `aws-sm://<secret name or ARN>[#key]`. Credentials are read from the
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment
+// variables or, with IAM roles for service accounts, obtained for the role in
+// AWS_ROLE_ARN with the token in AWS_WEB_IDENTITY_TOKEN_FILE.
+type AWSConfig struct {
+	// Region of the secrets. Defaults to the AWS_REGION or
+	// AWS_DEFAULT_REGION environment variables, or to the region in the ARN
+	// of the secret.
+	Region string `yaml:"region,omitempty"`
+}
+
+type awsProvider struct {
+	config AWSConfig
+	// endpoint returns the endpoint of a service in a region.
+	endpoint func(service, region string) string
+	client   *http.Client
+	auth     *cloudauth.AWS
+}
+
+func newAWSProvider(config AWSConfig) *awsProvider {
+	if config.Region == "" {
+		config.Region = cloudauth.AWSRegion()
+	}
+	return &awsProvider{
+		config:   config,
+		endpoint: cloudauth.AWSEndpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		auth:     cloudauth.NewAWS(),
+	}
+}
+
+// Fetch reads the current version of a secret.
+func (p *awsProvider) Fetch(ctx context.Context, ref Reference) (Secret, error) {
+	region := p.config.Region
+	if arn := strings.Split(ref.Path, ":"); len(arn) > 3 && arn[0] == "arn" && arn[3] != "" {
+		region = arn[3]
+	}
+	if region == "" {
+		return Secret{}, fmt.Errorf("the AWS region is not set, set secrets.aws.region or AWS_REGION")
+	}
+	credentials, err := p.auth.Credentials(ctx, region)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": ref.Path})
+	if err != nil {
+		return Secret{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint("secretsmanager", region)+"/", bytes.NewReader(body))
+	if err != nil {
+		return Secret{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	cloudauth.SignV4(req, body, credentials, region, "secretsmanager", time.Now())
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return Secret{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return Secret{}, fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	var response struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return Secret{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	value, err := field([]byte(response.SecretString), ref.Key)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value}, nil
+}
@@ -0,0 +1,25 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// fileProvider reads secrets from files, referenced as `file:///<path>[#key]`.
+// Files mounted from Kubernetes Secrets are updated when the Secret changes,
+// so a rotated credential is read once the cached value expires.
+type fileProvider struct{}
+
+// Fetch reads the file, without its trailing newline.
+func (fileProvider) Fetch(ctx context.Context, ref Reference) (Secret, error) {
+	data, err := os.ReadFile(ref.Path)
+	if err != nil {
+		return Secret{}, err
+	}
+	value, err := field(data, ref.Key)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: strings.TrimRight(value, "\r\n")}, nil
+}
@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jetstack/preflight/pkg/cloudauth"
+)
+
+// gcpProvider reads secrets from GCP Secret Manager. Secrets are referenced
+// as `gcp-sm://projects/<project>/secrets/<secret>[/versions/<version>][#key]`;
+// the latest version is read unless a version is given. The access token is
+// read from the GOOGLE_OAUTH_ACCESS_TOKEN environment variable or, on GCP,
+// from the metadata server, which provides the token of the Workload
+// Identity service account on GKE.
+type gcpProvider struct {
+	endpoint string
+	client   *http.Client
+	auth     *cloudauth.GCP
+}
+
+func newGCPProvider() *gcpProvider {
+	return &gcpProvider{
+		endpoint: "https://secretmanager.googleapis.com",
+		client:   &http.Client{Timeout: 30 * time.Second},
+		auth:     cloudauth.NewGCP(),
+	}
+}
+
+// Fetch accesses a version of a secret.
+func (p *gcpProvider) Fetch(ctx context.Context, ref Reference) (Secret, error) {
+	name := strings.Trim(ref.Path, "/")
+	parts := strings.Split(name, "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		name += "/versions/latest"
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		return Secret{}, fmt.Errorf("invalid GCP secret name %q, expected projects/<project>/secrets/<secret>[/versions/<version>]", ref.Path)
+	}
+
+	token, err := p.auth.AccessToken(ctx)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	var response struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return Secret{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if err := p.do(req, &response); err != nil {
+		return Secret{}, err
+	}
+
+	data, err := base64.StdEncoding.DecodeString(response.Payload.Data)
+	if err != nil {
+		return Secret{}, fmt.Errorf("failed to decode secret payload: %w", err)
+	}
+	value, err := field(data, ref.Key)
+	if err != nil {
+		return Secret{}, err
+	}
+	return Secret{Value: value}, nil
+}
+
+func (p *gcpProvider) do(req *http.Request, v interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
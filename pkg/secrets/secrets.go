@@ -0,0 +1,227 @@
+// Package secrets loads credentials from external secret managers and files.
+// A credential is referenced as `scheme://path#key`, e.g.
+// `vault://secret/data/agent#token`, and the Resolver fetches it from the
+// provider of the scheme, caching the value and fetching it again once it
+// expires.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long secrets are cached when their provider does
+// not say how long they are valid for.
+const DefaultCacheTTL = 5 * time.Minute
+
+// Reference identifies a secret in a secret manager.
+type Reference struct {
+	// Scheme selects the provider, e.g. vault.
+	Scheme string
+	// Path identifies the secret in the secret manager.
+	Path string
+	// Key is the field of the secret holding the credential. If empty, the
+	// whole secret is the credential.
+	Key string
+}
+
+func (r Reference) String() string {
+	s := r.Scheme + "://" + r.Path
+	if r.Key != "" {
+		s += "#" + r.Key
+	}
+	return s
+}
+
+// Secret is a value fetched from a secret manager.
+type Secret struct {
+	Value string
+	// TTL is how long the value can be cached for. If zero, the cache TTL
+	// of the resolver is used.
+	TTL time.Duration
+}
+
+// Provider fetches secrets from a secret manager.
+type Provider interface {
+	Fetch(ctx context.Context, ref Reference) (Secret, error)
+}
+
+// ParseReference parses a reference to a secret. The second return value is
+// false if value is not a reference to a secret manager with a registered
+// scheme, in which case the value is the credential itself.
+func ParseReference(value string) (Reference, bool, error) {
+	scheme, rest, ok := strings.Cut(value, "://")
+	if !ok || !knownSchemes[scheme] {
+		return Reference{}, false, nil
+	}
+	ref := Reference{Scheme: scheme, Path: rest}
+	if i := strings.LastIndex(rest, "#"); i >= 0 {
+		ref.Path, ref.Key = rest[:i], rest[i+1:]
+	}
+	if ref.Path == "" {
+		return Reference{}, true, fmt.Errorf("invalid secret reference %q: the path is empty", value)
+	}
+	return ref, true, nil
+}
+
+// IsReference returns true if value is a reference to a secret manager.
+func IsReference(value string) bool {
+	_, ok, _ := ParseReference(value)
+	return ok
+}
+
+var knownSchemes = map[string]bool{
+	"vault":  true,
+	"aws-sm": true,
+	"gcp-sm": true,
+	"file":   true,
+}
+
+// Config configures the secret managers.
+type Config struct {
+	// CacheTTL is how long secrets are cached when their provider does not
+	// say how long they are valid for. Defaults to DefaultCacheTTL.
+	CacheTTL time.Duration `yaml:"cache-ttl,omitempty"`
+	Vault    VaultConfig   `yaml:"vault,omitempty"`
+	AWS      AWSConfig     `yaml:"aws,omitempty"`
+}
+
+// Validate checks the configuration of the secret managers.
+func (c *Config) Validate() error {
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("secrets.cache-ttl must not be negative")
+	}
+	return nil
+}
+
+// Resolver resolves references to secrets, caching their values.
+type Resolver struct {
+	providers map[string]Provider
+	cacheTTL  time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	cache map[Reference]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// NewResolver creates a resolver for the vault://, aws-sm://, gcp-sm:// and
+// file:// schemes.
+func NewResolver(config Config) *Resolver {
+	return newResolver(config.CacheTTL, map[string]Provider{
+		"vault":  newVaultProvider(config.Vault),
+		"aws-sm": newAWSProvider(config.AWS),
+		"gcp-sm": newGCPProvider(),
+		"file":   fileProvider{},
+	})
+}
+
+func newResolver(cacheTTL time.Duration, providers map[string]Provider) *Resolver {
+	if cacheTTL == 0 {
+		cacheTTL = DefaultCacheTTL
+	}
+	return &Resolver{
+		providers: providers,
+		cacheTTL:  cacheTTL,
+		now:       time.Now,
+		cache:     map[Reference]cachedSecret{},
+	}
+}
+
+// Resolve returns the credential referenced by value, or value itself if it
+// is not a reference. Cached values are fetched again once they expire; if
+// that fails, the expired value keeps being used until a fetch succeeds so
+// that an outage of the secret manager doesn't stop the agent.
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	ref, ok, err := ParseReference(value)
+	if err != nil || !ok {
+		return value, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cached, found := r.cache[ref]
+	if found && r.now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	secret, err := r.fetch(ctx, ref)
+	if err != nil {
+		if found {
+			log.Printf("failed to renew secret %s, using the cached value: %s", ref, err)
+			return cached.value, nil
+		}
+		return "", err
+	}
+
+	ttl := secret.TTL
+	if ttl <= 0 || ttl > r.cacheTTL {
+		ttl = r.cacheTTL
+	}
+	r.cache[ref] = cachedSecret{value: secret.Value, expires: r.now().Add(ttl)}
+
+	return secret.Value, nil
+}
+
+func (r *Resolver) fetch(ctx context.Context, ref Reference) (Secret, error) {
+	provider, ok := r.providers[ref.Scheme]
+	if !ok {
+		return Secret{}, fmt.Errorf("no provider for secret %s", ref)
+	}
+	secret, err := provider.Fetch(ctx, ref)
+	if err != nil {
+		return Secret{}, fmt.Errorf("failed to fetch secret %s: %w", ref, err)
+	}
+	if secret.Value == "" {
+		return Secret{}, fmt.Errorf("secret %s is empty", ref)
+	}
+	return secret, nil
+}
+
+// Invalidate drops the cached value of a reference, so that it is fetched
+// again by the next Resolve, e.g. when the credential was rejected as it
+// may have been rotated.
+func (r *Resolver) Invalidate(value string) {
+	ref, ok, err := ParseReference(value)
+	if err != nil || !ok {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, ref)
+}
+
+// field returns the credential in data: data itself if key is empty, or the
+// key field of data parsed as a JSON object.
+func field(data []byte, key string) (string, error) {
+	if key == "" {
+		return string(data), nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", fmt.Errorf("failed to parse secret as a JSON object to read key %q: %w", key, err)
+	}
+	return stringField(fields, key)
+}
+
+func stringField(fields map[string]interface{}, key string) (string, error) {
+	value, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("key %q not found in secret", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("key %q of secret is not a string", key)
+	}
+	return s, nil
+}
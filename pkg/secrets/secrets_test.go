@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d4l3k/messagediff"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := map[string]struct {
+		value    string
+		expected Reference
+		ok       bool
+		err      bool
+	}{
+		"plain value":      {value: "abc123"},
+		"unknown scheme":   {value: "https://example.com#x"},
+		"vault":            {value: "vault://secret/data/agent#token", expected: Reference{Scheme: "vault", Path: "secret/data/agent", Key: "token"}, ok: true},
+		"aws arn":          {value: "aws-sm://arn:aws:secretsmanager:eu-west-1:123:secret:agent#token", expected: Reference{Scheme: "aws-sm", Path: "arn:aws:secretsmanager:eu-west-1:123:secret:agent", Key: "token"}, ok: true},
+		"gcp without key":  {value: "gcp-sm://projects/p/secrets/s", expected: Reference{Scheme: "gcp-sm", Path: "projects/p/secrets/s"}, ok: true},
+		"empty path error": {value: "vault://#token", ok: true, err: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			ref, ok, err := ParseReference(tc.value)
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != tc.ok {
+				t.Errorf("unexpected ok: got=%v want=%v", ok, tc.ok)
+			}
+			if diff, equal := messagediff.PrettyDiff(tc.expected, ref); !equal {
+				t.Errorf("unexpected reference:\n%s", diff)
+			}
+		})
+	}
+}
+
+type fakeProvider struct {
+	secrets []Secret
+	err     error
+	calls   int
+}
+
+func (p *fakeProvider) Fetch(ctx context.Context, ref Reference) (Secret, error) {
+	p.calls++
+	if p.err != nil {
+		return Secret{}, p.err
+	}
+	secret := p.secrets[0]
+	p.secrets = p.secrets[1:]
+	return secret, nil
+}
+
+func TestResolver(t *testing.T) {
+	provider := &fakeProvider{secrets: []Secret{{Value: "one", TTL: time.Minute}, {Value: "two"}}}
+	r := newResolver(10*time.Minute, map[string]Provider{"vault": provider})
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.now = func() time.Time { return now }
+
+	resolve := func(expected string) {
+		t.Helper()
+		got, err := r.Resolve(context.Background(), "vault://secret/agent#token")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != expected {
+			t.Errorf("unexpected value: got=%q want=%q", got, expected)
+		}
+	}
+
+	// plain values are returned as is
+	if got, _ := r.Resolve(context.Background(), "abc"); got != "abc" {
+		t.Errorf("unexpected value: %q", got)
+	}
+
+	resolve("one")
+	resolve("one")
+	if provider.calls != 1 {
+		t.Errorf("expected the cached value to be used, got %d calls", provider.calls)
+	}
+
+	// the TTL of the secret is used when it is shorter than the cache TTL
+	now = now.Add(2 * time.Minute)
+	resolve("two")
+
+	// the expired value is used while the provider fails
+	provider.err = fmt.Errorf("unavailable")
+	now = now.Add(20 * time.Minute)
+	resolve("two")
+
+	// and an error is returned if there is no value to fall back to
+	if _, err := r.Resolve(context.Background(), "vault://secret/other#token"); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	r := NewResolver(Config{})
+	resolve := func(value, expected string) {
+		t.Helper()
+		got, err := r.Resolve(context.Background(), value)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != expected {
+			t.Errorf("unexpected value: got=%q want=%q", got, expected)
+		}
+	}
+
+	write("old\n")
+	resolve("file://"+path, "old")
+
+	// the rotated value is cached until invalidated
+	write("new\n")
+	resolve("file://"+path, "old")
+	r.Invalidate("file://" + path)
+	resolve("file://"+path, "new")
+
+	write(`{"token": "from-json"}`)
+	resolve("file://"+path+"#token", "from-json")
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["role"] != "agent" || body["jwt"] != "sa-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"auth": {"client_token": "vault-token", "lease_duration": 3600}}`)
+		case "/v1/secret/data/agent":
+			if r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"lease_duration": 0, "data": {"data": {"token": "s3cr3t"}, "metadata": {"version": 1}}}`)
+		case "/v1/kv/agent":
+			fmt.Fprint(w, `{"lease_duration": 60, "data": {"token": "kv1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenPath, []byte("sa-token\n"), 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p := newVaultProvider(VaultConfig{Address: server.URL, Namespace: "team", Role: "agent"})
+	p.tokenPath = tokenPath
+
+	secret, err := p.Fetch(context.Background(), Reference{Scheme: "vault", Path: "secret/data/agent", Key: "token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(Secret{Value: "s3cr3t"}, secret); !equal {
+		t.Errorf("unexpected secret:\n%s", diff)
+	}
+
+	secret, err = p.Fetch(context.Background(), Reference{Scheme: "vault", Path: "kv/agent", Key: "token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff, equal := messagediff.PrettyDiff(Secret{Value: "kv1", TTL: time.Minute}, secret); !equal {
+		t.Errorf("unexpected secret:\n%s", diff)
+	}
+
+	if _, err := p.Fetch(context.Background(), Reference{Scheme: "vault", Path: "secret/data/agent", Key: "missing"}); err == nil || err.Error() != `key "missing" not found in secret` {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGCPProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "gcp-token", "expires_in": 3600}`)
+		case "/v1/projects/p/secrets/agent/versions/latest:access":
+			if r.Header.Get("Authorization") != "Bearer gcp-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprintf(w, `{"payload": {"data": %q}}`, base64.StdEncoding.EncodeToString([]byte(`{"token": "s3cr3t"}`)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := newGCPProvider()
+	p.endpoint, p.auth.MetadataEndpoint = server.URL, server.URL
+
+	secret, err := p.Fetch(context.Background(), Reference{Scheme: "gcp-sm", Path: "projects/p/secrets/agent", Key: "token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if secret.Value != "s3cr3t" {
+		t.Errorf("unexpected value: %q", secret.Value)
+	}
+
+	if _, err := p.Fetch(context.Background(), Reference{Scheme: "gcp-sm", Path: "agent"}); err == nil {
+		t.Errorf("expected an error for an invalid secret name")
+	}
+}
+
+func TestAWSProvider(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"SecretString": %q}`, body["SecretId"])
+	}))
+	defer server.Close()
+
+	p := newAWSProvider(AWSConfig{Region: "us-east-1"})
+	p.endpoint = func(service, region string) string { return server.URL }
+
+	// the region is read from the ARN
+	arn := "arn:aws:secretsmanager:eu-west-1:123456789012:secret:agent"
+	secret, err := p.Fetch(context.Background(), Reference{Scheme: "aws-sm", Path: arn})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if secret.Value != arn {
+		t.Errorf("unexpected value: %q", secret.Value)
+	}
+}
@@ -0,0 +1,166 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig configures access to HashiCorp Vault. Secrets are referenced as
+// `vault://<path>#<key>`, e.g. `vault://secret/data/agent#token` for a KV v2
+// secret engine mounted at `secret`.
+type VaultConfig struct {
+	// Address of the Vault server. Defaults to the VAULT_ADDR environment
+	// variable.
+	Address string `yaml:"address,omitempty"`
+	// Namespace is the Vault Enterprise namespace. Defaults to the
+	// VAULT_NAMESPACE environment variable.
+	Namespace string `yaml:"namespace,omitempty"`
+	// Role is the role to log in as with the Kubernetes auth method, using
+	// the token of the agent's service account. If empty, the VAULT_TOKEN
+	// environment variable is used as the Vault token.
+	Role string `yaml:"role,omitempty"`
+	// AuthPath is the mount path of the Kubernetes auth method. Defaults to
+	// kubernetes.
+	AuthPath string `yaml:"auth-path,omitempty"`
+}
+
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+type vaultProvider struct {
+	config    VaultConfig
+	client    *http.Client
+	now       func() time.Time
+	tokenPath string
+
+	mu           sync.Mutex
+	token        string
+	tokenExpires time.Time
+}
+
+func newVaultProvider(config VaultConfig) *vaultProvider {
+	if config.Address == "" {
+		config.Address = os.Getenv("VAULT_ADDR")
+	}
+	if config.Namespace == "" {
+		config.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if config.AuthPath == "" {
+		config.AuthPath = "kubernetes"
+	}
+	return &vaultProvider{
+		config:    config,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		now:       time.Now,
+		tokenPath: serviceAccountTokenPath,
+	}
+}
+
+// Fetch reads a secret from a KV v1 or v2 secret engine.
+func (p *vaultProvider) Fetch(ctx context.Context, ref Reference) (Secret, error) {
+	if p.config.Address == "" {
+		return Secret{}, fmt.Errorf("the Vault address is not set, set secrets.vault.address or VAULT_ADDR")
+	}
+	if ref.Key == "" {
+		return Secret{}, fmt.Errorf("a key is required for Vault secrets, e.g. vault://%s#token", ref.Path)
+	}
+	token, err := p.vaultToken(ctx)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	var response struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := p.do(ctx, http.MethodGet, ref.Path, token, nil, &response); err != nil {
+		return Secret{}, err
+	}
+
+	data := response.Data
+	// KV v2 nests the secret under data, next to its metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, err := stringField(data, ref.Key)
+	if err != nil {
+		return Secret{}, err
+	}
+
+	return Secret{Value: value, TTL: time.Duration(response.LeaseDuration) * time.Second}, nil
+}
+
+// vaultToken returns the Vault token, logging in with the Kubernetes auth
+// method again when the token of the previous login expires.
+func (p *vaultProvider) vaultToken(ctx context.Context) (string, error) {
+	if p.config.Role == "" {
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			return "", fmt.Errorf("no Vault credentials, set secrets.vault.role or VAULT_TOKEN")
+		}
+		return token, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && p.now().Before(p.tokenExpires) {
+		return p.token, nil
+	}
+
+	jwt, err := os.ReadFile(p.tokenPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{"role": p.config.Role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", err
+	}
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := p.do(ctx, http.MethodPost, "auth/"+strings.Trim(p.config.AuthPath, "/")+"/login", "", body, &response); err != nil {
+		return "", fmt.Errorf("failed to log in to Vault as role %q: %w", p.config.Role, err)
+	}
+
+	p.token = response.Auth.ClientToken
+	// log in again before the token expires
+	p.tokenExpires = p.now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 9 / 10)
+	return p.token, nil
+}
+
+func (p *vaultProvider) do(ctx context.Context, method, path, token string, body []byte, v interface{}) error {
+	url := strings.TrimSuffix(p.config.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		errorContent, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("received response with status code %d. Body: [%s]", res.StatusCode, strings.TrimSpace(string(errorContent)))
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}